@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/go-playground/validator/v10"
+	"github.com/urfave/cli/v2"
+)
+
+// Supported access report formats
+const (
+	accessReportFormatCSV  = "csv"
+	accessReportFormatJSON = "json"
+)
+
+type accessReportArgs struct {
+	Format       string
+	OutputFile   string
+	SnapshotFile string
+}
+
+var accessReportCmdArgs accessReportArgs
+
+// accessReportCommand define the effective access matrix report CLI command
+func accessReportCommand() *cli.Command {
+	return &cli.Command{
+		Name: "access-report",
+		Usage: "Report the methods each user is allowed to call on each endpoint, for access " +
+			"certification reviews",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name: "format",
+				Usage: fmt.Sprintf(
+					"Report format: [%s %s]", accessReportFormatCSV, accessReportFormatJSON,
+				),
+				Value:       accessReportFormatCSV,
+				DefaultText: accessReportFormatCSV,
+				Destination: &accessReportCmdArgs.Format,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "output",
+				Usage:       "Report output file. Defaults to stdout.",
+				Aliases:     []string{"f"},
+				Destination: &accessReportCmdArgs.OutputFile,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name: "snapshot",
+				Usage: "Read the users from this backup file, as written by the backup command, " +
+					"instead of the database",
+				Aliases:     []string{"s"},
+				Destination: &accessReportCmdArgs.SnapshotFile,
+				Required:    false,
+			},
+		},
+		Action: commandAction("access-report", accessReportApplication),
+	}
+}
+
+// accessReportResult is the result of the access-report command, when the report is written
+// to a file
+type accessReportResult struct {
+	// File is the report file
+	File string `json:"file"`
+	// Format is the report format
+	Format string `json:"format"`
+	// Users is the number of users reported on
+	Users int `json:"users"`
+	// Entries is the number of access entries reported
+	Entries int `json:"entries"`
+}
+
+func accessReportApplication(c *cli.Context) (interface{}, error) {
+	appCfg, customValidator, validate, err := setupApplication()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := closeLogSinks(); err != nil {
+			log.WithError(err).Errorf("Failed to close log sinks")
+		}
+	}()
+	switch accessReportCmdArgs.Format {
+	case accessReportFormatCSV, accessReportFormatJSON:
+	default:
+		return nil, fmt.Errorf("unsupported report format '%s'", accessReportCmdArgs.Format)
+	}
+
+	// Read the users
+	var snapshot users.Snapshot
+	if accessReportCmdArgs.SnapshotFile != "" {
+		snapshot, err = readSnapshotFile(accessReportCmdArgs.SnapshotFile)
+	} else {
+		snapshot, err = exportDatabaseSnapshot(appCfg, customValidator, validate)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The roles grant the permissions of the roles they inherit from
+	roles, err := common.ResolveRoleInheritance(appCfg.UserManagement.AvailableRoles)
+	if err != nil {
+		return nil, err
+	}
+	matrix := users.BuildAccessMatrix(
+		snapshot,
+		roles,
+		appCfg.Authorization.Rules,
+		appCfg.UserManagement.PermissionWildcards,
+	)
+	// With JSON output, a report not written to a file is the command result
+	if jsonOutput() && accessReportCmdArgs.OutputFile == "" {
+		return matrix, nil
+	}
+
+	var output io.Writer = os.Stdout
+	if accessReportCmdArgs.OutputFile != "" {
+		outputFile, err := os.OpenFile(
+			accessReportCmdArgs.OutputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600,
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Unable to open %s", accessReportCmdArgs.OutputFile)
+			return nil, err
+		}
+		defer outputFile.Close()
+		output = outputFile
+	}
+	if accessReportCmdArgs.Format == accessReportFormatJSON {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(&matrix)
+	} else {
+		err = matrix.WriteCSV(output)
+	}
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to write access report")
+		return nil, err
+	}
+	log.WithFields(logTags).Infof(
+		"Reported %d access entries for %d users", len(matrix.Entries), len(snapshot.Users),
+	)
+	return accessReportResult{
+		File:    accessReportCmdArgs.OutputFile,
+		Format:  accessReportCmdArgs.Format,
+		Users:   len(snapshot.Users),
+		Entries: len(matrix.Entries),
+	}, nil
+}
+
+/*
+exportDatabaseSnapshot read the user management records from the database
+
+	@param appCfg common.AuthorizationServerConfig - the application config
+	@param customValidator common.CustomFieldValidator - custom field validator
+	@param validate *validator.Validate - validator for the database parameters
+	@return the user management records
+*/
+func exportDatabaseSnapshot(
+	appCfg common.AuthorizationServerConfig,
+	customValidator common.CustomFieldValidator,
+	validate *validator.Validate,
+) (users.Snapshot, error) {
+	dbDSN, err := buildDatabaseDSN(validate)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to define DB connection DSN")
+		return users.Snapshot{}, err
+	}
+	userManager, err := defineUserManager(
+		dbDSN,
+		appCfg.Startup.DBConnect,
+		customValidator,
+		nil,
+		nil,
+		&appCfg.UserManagement.PermissionWildcards,
+	)
+	if err != nil {
+		return users.Snapshot{}, err
+	}
+	snapshot, err := users.ExportSnapshot(context.Background(), userManager)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to export user management records")
+		return users.Snapshot{}, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,26 @@
+// Package echoadapter adapts the Padlock middleware Guard for the echo web framework
+package echoadapter
+
+import (
+	"net/http"
+
+	"github.com/alwitt/padlock/middleware"
+	"github.com/labstack/echo/v4"
+)
+
+/*
+Middleware convert a Guard into an echo middleware. Denied requests receive a 403 response.
+
+	@param guard middleware.Guard - the Padlock Guard
+	@return the echo middleware
+*/
+func Middleware(guard middleware.Guard) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !guard.Allowed(c.Request()) {
+				return echo.NewHTTPError(http.StatusForbidden)
+			}
+			return next(c)
+		}
+	}
+}
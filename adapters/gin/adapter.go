@@ -0,0 +1,26 @@
+// Package ginadapter adapts the Padlock middleware Guard for the gin web framework
+package ginadapter
+
+import (
+	"net/http"
+
+	"github.com/alwitt/padlock/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Middleware convert a Guard into a gin middleware. Denied requests are aborted with a 403
+response.
+
+	@param guard middleware.Guard - the Padlock Guard
+	@return the gin middleware
+*/
+func Middleware(guard middleware.Guard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !guard.Allowed(c.Request) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,86 @@
+package ginadapter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alwitt/padlock/client"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/middleware"
+	"github.com/apex/log"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+	gin.SetMode(gin.TestMode)
+
+	paramHeaders := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	// Fake Padlock authorization server
+	lock := sync.Mutex{}
+	available := true
+	padlock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(paramHeaders.Path) == "/allowed" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer padlock.Close()
+
+	authorizer, err := client.DefineAuthorizationClient(
+		client.Config{BaseURL: padlock.URL}, paramHeaders,
+	)
+	assert.Nil(err)
+
+	headers := middleware.HeaderMapping{UserID: "X-User-ID"}
+	defineRouter := func(onFailure middleware.FailurePolicy) *gin.Engine {
+		guard, err := middleware.DefineGuard(middleware.Config{
+			Authorizer: authorizer, Headers: headers, OnFailure: onFailure,
+		})
+		assert.Nil(err)
+		router := gin.New()
+		router.Use(Middleware(guard))
+		router.GET("/*path", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		return router
+	}
+	executeTest := func(router *gin.Engine, path string, expected int) {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.Nil(err)
+		req.Header.Add(headers.UserID, "alice")
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+		assert.Equal(expected, respRecorder.Code, path)
+	}
+
+	// Case 0: allowed
+	executeTest(defineRouter(middleware.FailClosed), "/allowed", http.StatusOK)
+
+	// Case 1: denied
+	executeTest(defineRouter(middleware.FailClosed), "/denied", http.StatusForbidden)
+
+	// Case 2: Padlock not available
+	lock.Lock()
+	available = false
+	lock.Unlock()
+	executeTest(defineRouter(middleware.FailClosed), "/allowed", http.StatusForbidden)
+	executeTest(defineRouter(middleware.FailOpen), "/denied", http.StatusOK)
+}
@@ -0,0 +1,108 @@
+package apis
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// Names of the servers as they should appear in the systemd socket unit "FileDescriptorName"
+const (
+	ServerNameMetrics        = "metrics"
+	ServerNameUserManagement = "user-management"
+	ServerNameAuthorization  = "authorization"
+	ServerNameAuthentication = "authentication"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by systemd socket activation
+const systemdListenFDsStart = 3
+
+/*
+parseSystemdListenFDs parse the systemd socket activation environment variables
+
+	@param listenPID string - value of LISTEN_PID
+	@param listenFDs string - value of LISTEN_FDS
+	@param listenFDNames string - value of LISTEN_FDNAMES
+	@param selfPID int - PID of this process
+	@return the file descriptors passed to this process, indexed by name
+*/
+func parseSystemdListenFDs(
+	listenPID, listenFDs, listenFDNames string, selfPID int,
+) (map[string]int, error) {
+	result := map[string]int{}
+	if listenPID == "" || listenFDs == "" {
+		return result, nil
+	}
+	pid, err := strconv.Atoi(listenPID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID '%s': %w", listenPID, err)
+	}
+	if pid != selfPID {
+		// The sockets were intended for another process
+		return result, nil
+	}
+	count, err := strconv.Atoi(listenFDs)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS '%s'", listenFDs)
+	}
+	names := []string{}
+	if listenFDNames != "" {
+		names = strings.Split(listenFDNames, ":")
+	}
+	if len(names) != count {
+		return nil, fmt.Errorf(
+			"LISTEN_FDNAMES has %d names for %d sockets; set FileDescriptorName for each socket",
+			len(names),
+			count,
+		)
+	}
+	for idx, name := range names {
+		if _, ok := result[name]; ok {
+			return nil, fmt.Errorf("LISTEN_FDNAMES has duplicate name '%s'", name)
+		}
+		result[name] = systemdListenFDsStart + idx
+	}
+	return result, nil
+}
+
+/*
+SystemdListeners fetch the listeners passed to this process through systemd socket activation.
+
+The listeners are indexed by the "FileDescriptorName" of their socket units. The socket
+activation environment variables are cleared once read, so they are not inherited by child
+processes.
+
+	@return the listeners indexed by name
+*/
+func SystemdListeners() (map[string]net.Listener, error) {
+	logTags := log.Fields{"module": "apis", "component": "socket-activation"}
+	fds, err := parseSystemdListenFDs(
+		os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), os.Getpid(),
+	)
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Unable to parse socket activation parameters")
+		return nil, err
+	}
+
+	listeners := map[string]net.Listener{}
+	for name, fd := range fds {
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		// net.FileListener duplicates the descriptor
+		_ = file.Close()
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Socket '%s' is not a listener", name)
+			return nil, err
+		}
+		log.WithFields(logTags).Infof("Received socket '%s' on %s", name, listener.Addr())
+		listeners[name] = listener
+	}
+	return listeners, nil
+}
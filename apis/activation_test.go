@@ -0,0 +1,60 @@
+package apis
+
+import (
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSystemdListenFDs(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Case 0: not socket activated
+	{
+		fds, err := parseSystemdListenFDs("", "", "", 100)
+		assert.Nil(err)
+		assert.Empty(fds)
+	}
+
+	// Case 1: sockets intended for another process
+	{
+		fds, err := parseSystemdListenFDs("101", "1", ServerNameAuthorization, 100)
+		assert.Nil(err)
+		assert.Empty(fds)
+	}
+
+	// Case 2: named sockets
+	{
+		fds, err := parseSystemdListenFDs(
+			"100", "2", ServerNameAuthorization+":"+ServerNameAuthentication, 100,
+		)
+		assert.Nil(err)
+		assert.Equal(map[string]int{ServerNameAuthorization: 3, ServerNameAuthentication: 4}, fds)
+	}
+
+	// Case 3: names missing
+	{
+		_, err := parseSystemdListenFDs("100", "2", ServerNameAuthorization, 100)
+		assert.NotNil(err)
+		_, err = parseSystemdListenFDs("100", "1", "", 100)
+		assert.NotNil(err)
+	}
+
+	// Case 4: duplicate names
+	{
+		_, err := parseSystemdListenFDs(
+			"100", "2", ServerNameAuthorization+":"+ServerNameAuthorization, 100,
+		)
+		assert.NotNil(err)
+	}
+
+	// Case 5: invalid values
+	{
+		_, err := parseSystemdListenFDs("abc", "1", ServerNameAuthorization, 100)
+		assert.NotNil(err)
+		_, err = parseSystemdListenFDs("100", "abc", ServerNameAuthorization, 100)
+		assert.NotNil(err)
+	}
+}
@@ -0,0 +1,123 @@
+package apis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+)
+
+/*
+adminAuthMiddleware require the callers of the user management REST API to present a bearer
+token accepted by the authentication server, for a user holding the admin permission
+
+	@param handler goutils.RestAPIHandler - the handler forming the error responses
+	@param cfg common.ManagementAdminAuthConfig - the admin authentication config
+	@param verifier BearerTokenVerifier - verifies the bearer tokens
+	@param manager users.Management - core user management logic block
+	@return the middleware
+*/
+func adminAuthMiddleware(
+	handler goutils.RestAPIHandler,
+	cfg common.ManagementAdminAuthConfig,
+	verifier BearerTokenVerifier,
+	manager users.Management,
+) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logTags := handler.GetLogTagsForContext(r.Context())
+
+			reject := func(respCode int, msg string, err error, code ErrorCode) {
+				detail := ""
+				if err != nil {
+					detail = err.Error()
+				}
+				log.WithError(err).WithFields(logTags).Errorf(msg)
+				if respCode == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="padlock"`)
+				}
+				if err := handler.WriteRESTResponse(
+					w,
+					respCode,
+					newErrorResponse(handler.GetStdRESTErrorMsg(r.Context(), respCode, msg, detail), code),
+					nil,
+				); err != nil {
+					log.WithError(err).WithFields(logTags).Error("Failed to form response")
+				}
+			}
+
+			userID, rejected := verifyAdmin(
+				r.Context(), cfg, verifier, manager, r.Header.Get(verifier.TokenHeader()),
+			)
+			if rejected != nil {
+				reject(rejected.respCode, rejected.msg, rejected.err, rejected.code)
+				return
+			}
+			log.WithFields(logTags).Debugf("Admin %s authenticated", userID)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/*
+verifyAdmin verify the caller of the user management API presented a bearer token accepted
+by the authentication server, for a user holding the admin permission
+
+	@param ctxt context.Context - the operating context
+	@param cfg common.ManagementAdminAuthConfig - the admin authentication config
+	@param verifier BearerTokenVerifier - verifies the bearer tokens
+	@param manager users.Management - core user management logic block
+	@param header string - the value of the token header
+	@return the caller's user ID, or the reason the caller is rejected
+*/
+func verifyAdmin(
+	ctxt context.Context,
+	cfg common.ManagementAdminAuthConfig,
+	verifier BearerTokenVerifier,
+	manager users.Management,
+	header string,
+) (string, *tokenRejection) {
+	// The user ID is read the same way as by the authentication server, so the tokens of an
+	// issuer with a user ID namespace can't name the users of another issuer
+	userID, err := verifier.VerifyBearerTokenUser(ctxt, header, cfg.UserIDClaim)
+	if err != nil {
+		var rejected *tokenRejection
+		if errors.As(err, &rejected) {
+			return "", rejected
+		}
+		return "", &tokenRejection{
+			respCode: http.StatusUnauthorized,
+			msg:      "Unable to verify bearer token",
+			err:      err,
+			code:     ErrCodeTokenInvalid,
+		}
+	}
+
+	// The caller must hold the admin permission
+	allowed, err := manager.DoesUserHavePermission(ctxt, userID, []string{cfg.Permission})
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		return "", &tokenRejection{
+			respCode: http.StatusInternalServerError,
+			msg:      "Unable to read caller permissions",
+			err:      err,
+			code:     ErrCodeInternal,
+		}
+	}
+	if !allowed {
+		return "", &tokenRejection{
+			respCode: http.StatusForbidden,
+			msg:      fmt.Sprintf("User %s missing permission %s", userID, cfg.Permission),
+			err:      err,
+			code:     ErrCodePermissionDenied,
+		}
+	}
+	return userID, nil
+}
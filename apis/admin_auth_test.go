@@ -0,0 +1,170 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestUserManagementAdminAuth(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-:]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+
+	utCtxt := context.Background()
+	assert.Nil(mgmtCore.AlignRolesWithConfig(utCtxt, map[string]common.UserRoleConfig{
+		"admin":  {AssignedPermissions: []string{"padlock:admin"}},
+		"reader": {AssignedPermissions: []string{"read"}},
+	}))
+	assert.Nil(mgmtCore.DefineUser(utCtxt, models.UserConfig{UserID: "alice"}, []string{"admin"}))
+	assert.Nil(mgmtCore.DefineUser(utCtxt, models.UserConfig{UserID: "bob"}, []string{"reader"}))
+
+	startup := common.DefineReadinessGate()
+	startup.MarkReady()
+	audience := "padlock"
+	tokens, err := DefineBearerTokenVerifier(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		fakeOpenIDClient{
+			tokens: map[string]jwt.MapClaims{
+				"admin-token":     {"sub": "alice", "aud": audience},
+				"reader-token":    {"sub": "bob", "aud": audience},
+				"unknown-token":   {"sub": "carol", "aud": audience},
+				"no-sub-token":    {"azp": "report-service", "aud": audience},
+				"other-aud-token": {"sub": "alice", "aud": "other-service"},
+			},
+		},
+		false,
+		nil,
+		common.AuthenticationConfig{TargetAudience: &audience},
+		nil,
+		nil,
+		common.UserIDNormalizationConfig{},
+	)
+	assert.Nil(err)
+	svr, err := BuildUserManagementServer(
+		common.APIServerConfig{
+			APIs: common.APIConfig{
+				Endpoint:       common.EndpointConfig{PathPrefix: "/"},
+				RequestLogging: common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+			},
+		},
+		mgmtCore,
+		supportMatch,
+		nil,
+		nil,
+		startup,
+		common.DefineFeatureToggles(common.FeatureToggleState{}, nil),
+		nil,
+		nil,
+		common.ManagementAdminAuthConfig{
+			Enabled: true, Permission: "padlock:admin", UserIDClaim: "sub",
+		},
+		tokens,
+	)
+	assert.Nil(err)
+
+	listUsers := func(authorization string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/user", nil)
+		assert.Nil(err)
+		if authorization != "" {
+			req.Header.Add("Authorization", authorization)
+		}
+		respRecorder := httptest.NewRecorder()
+		svr.Handler.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: the caller holds the admin permission
+	{
+		resp := listUsers("Bearer admin-token")
+		assert.Equal(http.StatusOK, resp.Code)
+	}
+
+	// Case 1: no bearer token
+	{
+		resp := listUsers("")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeTokenMissing))
+		assert.NotEmpty(resp.Header().Get("WWW-Authenticate"))
+	}
+
+	// Case 2: the bearer token failed verification, or lacks the user ID claim
+	for _, authorization := range []string{"Bearer forged-token", "Basic admin-token"} {
+		resp := listUsers(authorization)
+		assert.Equal(http.StatusUnauthorized, resp.Code, authorization)
+		assert.Contains(resp.Body.String(), string(ErrCodeTokenInvalid), authorization)
+	}
+	{
+		resp := listUsers("Bearer no-sub-token")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeClaimInvalid))
+	}
+
+	// Case 3: the bearer token is verified the same way as by the authentication server
+	{
+		resp := listUsers("Bearer other-aud-token")
+		assert.Equal(http.StatusBadRequest, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeClaimInvalid))
+	}
+
+	// Case 4: the caller lacks the admin permission, or is not a known user
+	for _, authorization := range []string{"Bearer reader-token", "Bearer unknown-token"} {
+		resp := listUsers(authorization)
+		assert.Equal(http.StatusForbidden, resp.Code, authorization)
+		assert.Contains(resp.Body.String(), string(ErrCodePermissionDenied), authorization)
+	}
+
+	// Case 5: the health checks are not protected
+	{
+		req, err := http.NewRequest("GET", "/liveness/alive", nil)
+		assert.Nil(err)
+		respRecorder := httptest.NewRecorder()
+		svr.Handler.ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusOK, respRecorder.Code)
+	}
+
+	// Case 6: admin authentication requires an OpenID issuer
+	{
+		_, err := BuildUserManagementServer(
+			common.APIServerConfig{},
+			mgmtCore,
+			supportMatch,
+			nil,
+			nil,
+			startup,
+			common.DefineFeatureToggles(common.FeatureToggleState{}, nil),
+			nil,
+			nil,
+			common.ManagementAdminAuthConfig{Enabled: true},
+			nil,
+		)
+		assert.NotNil(err)
+	}
+}
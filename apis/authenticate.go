@@ -1,7 +1,10 @@
 package apis
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -12,6 +15,7 @@ import (
 	"github.com/alwitt/padlock/common"
 	"github.com/alwitt/padlock/match"
 	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
 	"github.com/apex/log"
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -24,9 +28,44 @@ type AuthenticationHandler struct {
 	introspector      authenticate.Introspector
 	targetAudience    *string
 	targetClaims      common.OpenIDClaimsOfInterestConfig
-	reqHeaderParam    common.AuthenticateRequestParamLocConfig
-	respHeaderParam   common.AuthorizeRequestParamLocConfig
-	bypassChecker     match.AuthBypassMatch
+	// issuerClaims are the claims of interest of the issuers overriding targetClaims, keyed by
+	// the canonical issuer URL
+	issuerClaims map[string]common.OpenIDClaimsOfInterestConfig
+	// issuerUserIDNamespaces are the namespaces prefixing the user IDs of the issuers' tokens,
+	// keyed by the canonical issuer URL
+	issuerUserIDNamespaces map[string]string
+	reqHeaderParam         common.AuthenticateRequestParamLocConfig
+	tokenHeader            common.TokenHeaderConfig
+	respHeaderParam        common.AuthorizeRequestParamLocConfig
+	bypassChecker          match.AuthBypassMatch
+	// samlCfg the SAML response validation config
+	samlCfg common.SAMLConfig
+	// samlValidator if provided, SAML responses are accepted in place of a bearer token
+	samlValidator authenticate.SAMLValidator
+	// cfAccessCfg the Cloudflare Access JWT assertion validation config
+	cfAccessCfg common.CloudflareAccessConfig
+	// cfAccessValidator if provided, Cloudflare Access JWT assertions are accepted in place of
+	// a bearer token
+	cfAccessValidator authenticate.CloudflareAccessValidator
+	// apiKeyCfg the API key authentication config
+	apiKeyCfg common.APIKeyAuthConfig
+	// apiKeys if provided, API keys are accepted in place of a bearer token
+	apiKeys users.Management
+	// introspectEndpoint the token introspection endpoint config
+	introspectEndpoint common.IntrospectEndpointConfig
+	// clientCerts if provided, client certificates are accepted in place of a bearer token
+	clientCerts authenticate.ClientCertReader
+	// userinfo if provided, tokens lacking a claim of interest are enriched from the OpenID
+	// issuer's userinfo endpoint
+	userinfo authenticate.UserinfoFetcher
+	// toggles if provided, runtime feature toggles which override the static config
+	toggles common.FeatureToggles
+	// userIDs how the user IDs read by VerifyBearerTokenUser are normalized
+	userIDs common.UserIDNormalizationConfig
+	// failures if provided, answers unauthenticated requests in place of the JSON error
+	failures *failureResponder
+	// decisionMetrics if provided, records the authentication outcomes
+	decisionMetrics DecisionMetricsHelper
 }
 
 // defineAuthenticationHandler define a new AuthenticationHandler instance
@@ -38,6 +77,10 @@ func defineAuthenticationHandler(
 	authnCfg common.AuthenticationConfig,
 	respHeaderParam common.AuthorizeRequestParamLocConfig,
 	metrics goutils.HTTPRequestMetricHelper,
+	decisionMetrics DecisionMetricsHelper,
+	toggles common.FeatureToggles,
+	issuers []common.OpenIDIssuerConfig,
+	apiKeys users.Management,
 ) (AuthenticationHandler, error) {
 	logTags := log.Fields{
 		"module": "apis", "component": "api-handler", "instance": "authentication",
@@ -62,14 +105,40 @@ func defineAuthenticationHandler(
 			LogLevel:      logConfig.LogLevel,
 			MetricsHelper: metrics,
 		},
-		oidClient:         oid,
-		performIntrospect: performIntrospect,
-		introspector:      introspector,
-		targetAudience:    authnCfg.TargetAudience,
-		targetClaims:      authnCfg.TargetClaims,
-		reqHeaderParam:    authnCfg.RequestParamLocation,
-		respHeaderParam:   respHeaderParam,
-		bypassChecker:     nil,
+		oidClient:              oid,
+		performIntrospect:      performIntrospect,
+		introspector:           introspector,
+		targetAudience:         authnCfg.TargetAudience,
+		targetClaims:           authnCfg.TargetClaims,
+		issuerClaims:           map[string]common.OpenIDClaimsOfInterestConfig{},
+		issuerUserIDNamespaces: map[string]string{},
+		reqHeaderParam:         authnCfg.RequestParamLocation,
+		tokenHeader:            authnCfg.TokenHeader,
+		respHeaderParam:        respHeaderParam,
+		bypassChecker:          nil,
+		samlCfg:                authnCfg.SAML,
+		cfAccessCfg:            authnCfg.CloudflareAccess,
+		apiKeyCfg:              authnCfg.APIKey,
+		introspectEndpoint:     authnCfg.IntrospectEndpoint,
+		toggles:                toggles,
+	}
+
+	for _, issuer := range issuers {
+		canonical := authenticate.CanonicalIssuer(issuer.Issuer)
+		if issuer.Claims != nil {
+			instance.issuerClaims[canonical] = *issuer.Claims
+		}
+		if issuer.UserIDNamespace != nil {
+			instance.issuerUserIDNamespaces[canonical] = *issuer.UserIDNamespace
+		}
+	}
+
+	// Fallback to the standard bearer token header
+	if instance.tokenHeader.Header == "" {
+		instance.tokenHeader.Header = "Authorization"
+	}
+	if len(instance.tokenHeader.Schemes) == 0 {
+		instance.tokenHeader.Schemes = []string{"Bearer"}
 	}
 
 	if authnCfg.Bypass != nil {
@@ -81,32 +150,782 @@ func defineAuthenticationHandler(
 		instance.bypassChecker = bypassCheck
 	}
 
+	if authnCfg.SAML.Enabled {
+		samlValidator, err := authenticate.DefineSAMLValidator(authnCfg.SAML)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed define SAML response validator")
+			return AuthenticationHandler{}, err
+		}
+		instance.samlValidator = samlValidator
+	}
+
+	if authnCfg.CloudflareAccess.Enabled {
+		cfAccessValidator, err := authenticate.DefineCloudflareAccessValidator(
+			authnCfg.CloudflareAccess, &http.Client{Timeout: time.Second * 10},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed define Cloudflare Access validator")
+			return AuthenticationHandler{}, err
+		}
+		instance.cfAccessValidator = cfAccessValidator
+	}
+
+	if authnCfg.APIKey.Enabled {
+		if apiKeys == nil {
+			err := fmt.Errorf("API key authentication requires the user database")
+			log.WithError(err).WithFields(logTags).Error("Failed define API key authentication")
+			return AuthenticationHandler{}, err
+		}
+		instance.apiKeys = apiKeys
+	}
+
+	if authnCfg.ClientCert.Enabled {
+		clientCerts, err := authenticate.DefineClientCertReader(authnCfg.ClientCert)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed define client certificate reader")
+			return AuthenticationHandler{}, err
+		}
+		instance.clientCerts = clientCerts
+	}
+
+	failures, err := defineFailureResponder(authnCfg.FailureResponse)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed define failure responder")
+		return AuthenticationHandler{}, err
+	}
+	instance.failures = failures
+	instance.decisionMetrics = decisionMetrics
+
+	if authnCfg.Userinfo.Enabled && oid != nil {
+		instance.userinfo = authenticate.DefineUserinfoFetcher(
+			oid,
+			time.Second*time.Duration(authnCfg.Userinfo.CacheTTL),
+			authnCfg.Userinfo.MaxCacheEntries,
+		)
+	}
+
 	return instance, nil
 }
 
+// introspectionEnabled whether to perform token introspection
+func (h AuthenticationHandler) introspectionEnabled() bool {
+	if h.toggles != nil {
+		return h.toggles.CurrentState().Introspection
+	}
+	return h.performIntrospect
+}
+
+/*
+fetchClaimAsString read a string claim from the token claims
+
+	@param claims jwt.MapClaims - the token claims
+	@param target string - the claim
+	@return the claim value
+*/
+func fetchClaimAsString(claims jwt.MapClaims, target string) (string, error) {
+	if v, ok := claims[target]; ok {
+		if value, ok := v.(string); ok {
+			return value, nil
+		}
+		return "", fmt.Errorf("bearer 'Authorization' token's claim %s is not a string", target)
+	}
+	return "", fmt.Errorf("bearer 'Authorization' token missing %s", target)
+}
+
+/*
+fetchClaimAsFloat read a numeric claim from the token claims
+
+	@param claims jwt.MapClaims - the token claims
+	@param target string - the claim
+	@return the claim value
+*/
+func fetchClaimAsFloat(claims jwt.MapClaims, target string) (float64, error) {
+	if v, ok := claims[target]; ok {
+		if value, ok := v.(float64); ok {
+			return value, nil
+		}
+		return 0, fmt.Errorf("bearer 'Authorization' token's claim %s is not a FLOAT64", target)
+	}
+	return 0, fmt.Errorf("bearer 'Authorization' token missing %s", target)
+}
+
+/*
+setUserParamHeaders set the response headers carrying the user parameters
+
+	@param respHeaders map[string]string - the response headers
+	@param userParams models.UserConfig - the user parameters
+*/
+func (h AuthenticationHandler) setUserParamHeaders(
+	respHeaders map[string]string, userParams models.UserConfig,
+) {
+	respHeaders[h.respHeaderParam.UserID] = userParams.UserID
+	if userParams.Username != nil {
+		respHeaders[h.respHeaderParam.Username] = *userParams.Username
+	}
+	if userParams.FirstName != nil {
+		respHeaders[h.respHeaderParam.FirstName] = *userParams.FirstName
+	}
+	if userParams.LastName != nil {
+		respHeaders[h.respHeaderParam.LastName] = *userParams.LastName
+	}
+	if userParams.Email != nil {
+		respHeaders[h.respHeaderParam.Email] = *userParams.Email
+	}
+}
+
+/*
+readSAMLResponse fetch the base64 encoded SAML response forwarded with the request, either
+in the configured header, or in the configured form field of a POST request
+
+	@param r *http.Request - the request
+	@return the SAML response, or empty if none was forwarded
+*/
+func (h AuthenticationHandler) readSAMLResponse(r *http.Request) string {
+	if encoded := r.Header.Get(h.samlCfg.Header); encoded != "" {
+		return encoded
+	}
+	if r.Method == http.MethodPost {
+		return r.PostFormValue(h.samlCfg.FormField)
+	}
+	return ""
+}
+
+/*
+readSAMLUserParams parse the user parameters out of a validated SAML assertion
+
+	@param assertion authenticate.SAMLAssertion - the assertion
+	@return the user parameters, or the attribute which could not be parsed, and the error
+*/
+func (h AuthenticationHandler) readSAMLUserParams(
+	assertion authenticate.SAMLAssertion,
+) (models.UserConfig, string, error) {
+	fetchAttribute := func(name string) (string, error) {
+		if values := assertion.Attributes[name]; len(values) > 0 && values[0] != "" {
+			return values[0], nil
+		}
+		return "", fmt.Errorf("SAML assertion missing attribute %s", name)
+	}
+
+	userParams := models.UserConfig{UserID: assertion.NameID}
+	if h.samlCfg.Attributes.UserID != nil {
+		uid, err := fetchAttribute(*h.samlCfg.Attributes.UserID)
+		if err != nil {
+			return models.UserConfig{}, *h.samlCfg.Attributes.UserID, err
+		}
+		userParams.UserID = uid
+	} else if userParams.UserID == "" {
+		return models.UserConfig{}, "NameID", fmt.Errorf("SAML assertion missing NameID")
+	}
+
+	// The optional parameters, if the attribute to read them from is specified
+	optional := []struct {
+		attribute *string
+		value     **string
+	}{
+		{attribute: h.samlCfg.Attributes.Username, value: &userParams.Username},
+		{attribute: h.samlCfg.Attributes.FirstName, value: &userParams.FirstName},
+		{attribute: h.samlCfg.Attributes.LastName, value: &userParams.LastName},
+		{attribute: h.samlCfg.Attributes.Email, value: &userParams.Email},
+	}
+	for _, param := range optional {
+		if param.attribute == nil {
+			continue
+		}
+		value, err := fetchAttribute(*param.attribute)
+		if err != nil {
+			return models.UserConfig{}, *param.attribute, err
+		}
+		*param.value = &value
+	}
+
+	return userParams, "", nil
+}
+
+/*
+readAPIKey fetch the API key forwarded with the request, either in the configured header, or
+in the bearer token header with the API key scheme
+
+	@param r *http.Request - the request
+	@return the API key, or empty if none was forwarded
+*/
+func (h AuthenticationHandler) readAPIKey(r *http.Request) string {
+	if apiKey := r.Header.Get(h.apiKeyCfg.Header); apiKey != "" {
+		return apiKey
+	}
+	parts := strings.Split(r.Header.Get(h.tokenHeader.Header), " ")
+	if len(parts) == 2 && strings.EqualFold(parts[0], h.apiKeyCfg.Scheme) {
+		return parts[1]
+	}
+	return ""
+}
+
+/*
+acceptedScheme whether the authorization scheme preceding the token is accepted
+
+	@param scheme string - the authorization scheme
+	@return whether the scheme is accepted
+*/
+func (h AuthenticationHandler) acceptedScheme(scheme string) bool {
+	for _, accepted := range h.tokenHeader.Schemes {
+		if strings.EqualFold(scheme, accepted) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+checkAudience verify the "aud" claim matches the target audience, if one is specified
+
+	@param claims jwt.MapClaims - the token claims
+	@return the error message, and an error if the claim does not match
+*/
+func (h AuthenticationHandler) checkAudience(claims jwt.MapClaims) (string, error) {
+	if h.targetAudience == nil {
+		return "", nil
+	}
+	aud, err := fetchClaimAsString(claims, "aud")
+	if err != nil {
+		return "Unable to parse out 'aud' claim", err
+	}
+	if aud != *h.targetAudience {
+		return "Invalid token", fmt.Errorf("'aud' claim does not match expectation")
+	}
+	return "", nil
+}
+
+// tokenRejection describes why a bearer token is not accepted
+type tokenRejection struct {
+	// respCode is the HTTP response code
+	respCode int
+	// msg is the error message
+	msg string
+	// err is the underlying error, if any
+	err error
+	// code is the machine-readable error code
+	code ErrorCode
+}
+
+// Error implements error
+func (e *tokenRejection) Error() string {
+	if e.err == nil {
+		return e.msg
+	}
+	return fmt.Sprintf("%s: %s", e.msg, e.err.Error())
+}
+
+// Unwrap implements errors.Unwrap
+func (e *tokenRejection) Unwrap() error {
+	return e.err
+}
+
+// BearerTokenVerifier verifies bearer tokens the same way the authentication server does
+type BearerTokenVerifier interface {
+	/*
+		VerifyBearerToken verify the bearer token carried in the token header. The token must
+		follow an accepted authorization scheme, be signed by the OpenID issuer, still be active
+		if introspection is enabled, and be issued for the target audience if one is specified.
+
+		@param ctxt context.Context - the operating context
+		@param header string - the value of the token header
+		@return the token claims, or the reason the token is not accepted
+	*/
+	VerifyBearerToken(ctxt context.Context, header string) (jwt.MapClaims, error)
+
+	/*
+		VerifyBearerTokenUser verify the bearer token carried in the token header, as with
+		VerifyBearerToken, and read the user ID out of it the same way the authentication server
+		does: through the claims mapping of the token's issuer, prefixed with the user ID
+		namespace of the issuer if it has one, then normalized.
+
+		@param ctxt context.Context - the operating context
+		@param header string - the value of the token header
+		@param userIDClaim string - if provided, the claim carrying the user ID of the tokens from
+		issuers without their own claims mapping
+		@return the user ID, or the reason the token is not accepted
+	*/
+	VerifyBearerTokenUser(ctxt context.Context, header string, userIDClaim string) (string, error)
+
+	/*
+		TokenHeader the header carrying the bearer token
+
+		@return the header name
+	*/
+	TokenHeader() string
+}
+
+/*
+DefineBearerTokenVerifier define a verifier of the bearer tokens presented to the APIs of
+this application, which accepts the same tokens as the authentication server. Only bearer
+tokens are accepted; the other credentials are specific to the authentication server.
+
+	@param logConfig common.HTTPRequestLogging - handler log settings
+	@param oid authenticate.OpenIDIssuerClient - the OpenID issuer client
+	@param performIntrospect bool - whether to introspect the tokens
+	@param introspector authenticate.Introspector - the token introspector
+	@param authnCfg common.AuthenticationConfig - the authentication config
+	@param toggles common.FeatureToggles - if provided, runtime feature toggles which override
+	the static config
+	@param issuers []common.OpenIDIssuerConfig - the OpenID issuers, for their claims mapping and
+	user ID namespace
+	@param userIDs common.UserIDNormalizationConfig - how the user IDs read from the tokens are
+	normalized
+	@return the verifier
+*/
+func DefineBearerTokenVerifier(
+	logConfig common.HTTPRequestLogging,
+	oid authenticate.OpenIDIssuerClient,
+	performIntrospect bool,
+	introspector authenticate.Introspector,
+	authnCfg common.AuthenticationConfig,
+	toggles common.FeatureToggles,
+	issuers []common.OpenIDIssuerConfig,
+	userIDs common.UserIDNormalizationConfig,
+) (BearerTokenVerifier, error) {
+	bearerOnly := common.AuthenticationConfig{
+		TargetAudience:       authnCfg.TargetAudience,
+		TargetClaims:         authnCfg.TargetClaims,
+		RequestParamLocation: authnCfg.RequestParamLocation,
+		TokenHeader:          authnCfg.TokenHeader,
+		Introspection:        authnCfg.Introspection,
+	}
+	verifier, err := defineAuthenticationHandler(
+		logConfig,
+		oid,
+		performIntrospect,
+		introspector,
+		bearerOnly,
+		common.AuthorizeRequestParamLocConfig{},
+		nil,
+		nil,
+		toggles,
+		issuers,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	verifier.userIDs = userIDs
+	return verifier, nil
+}
+
+/*
+TokenHeader the header carrying the bearer token
+
+	@return the header name
+*/
+func (h AuthenticationHandler) TokenHeader() string {
+	return h.tokenHeader.Header
+}
+
+/*
+VerifyBearerToken verify the bearer token carried in the token header. The token must
+follow an accepted authorization scheme, be signed by the OpenID issuer, still be active if
+introspection is enabled, and be issued for the target audience if one is specified.
+
+	@param ctxt context.Context - the operating context
+	@param header string - the value of the token header
+	@return the token claims, or the reason the token is not accepted
+*/
+func (h AuthenticationHandler) VerifyBearerToken(
+	ctxt context.Context, header string,
+) (jwt.MapClaims, error) {
+	rawToken, rejected := h.readBearerToken(header)
+	if rejected != nil {
+		return nil, rejected
+	}
+	claims, rejected := h.verifyBearerToken(ctxt, rawToken)
+	if rejected != nil {
+		return nil, rejected
+	}
+	return claims, nil
+}
+
+/*
+readBearerToken read the token out of the token header
+
+	@param header string - the value of the token header
+	@return the raw token, or the reason the token is not accepted
+*/
+func (h AuthenticationHandler) readBearerToken(header string) (string, *tokenRejection) {
+	if header == "" {
+		return "", &tokenRejection{
+			respCode: http.StatusUnauthorized,
+			msg:      fmt.Sprintf("Header '%s' missing", h.tokenHeader.Header),
+			code:     ErrCodeTokenMissing,
+		}
+	}
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 {
+		return "", &tokenRejection{
+			respCode: http.StatusUnauthorized,
+			msg:      fmt.Sprintf("Bearer '%s' has incorrect format", h.tokenHeader.Header),
+			code:     ErrCodeTokenInvalid,
+		}
+	}
+	if !h.acceptedScheme(parts[0]) {
+		return "", &tokenRejection{
+			respCode: http.StatusUnauthorized,
+			msg:      fmt.Sprintf("Authorization scheme '%s' is not accepted", parts[0]),
+			code:     ErrCodeTokenInvalid,
+		}
+	}
+	return parts[1], nil
+}
+
+/*
+verifyBearerToken verify a token: its signature, whether it is still active if introspection
+is enabled, and its audience if a target audience is specified
+
+	@param ctxt context.Context - the operating context
+	@param rawToken string - the token
+	@return the token claims, or the reason the token is not accepted
+*/
+func (h AuthenticationHandler) verifyBearerToken(
+	ctxt context.Context, rawToken string,
+) (jwt.MapClaims, *tokenRejection) {
+	unauthorized := func(msg string, err error, code ErrorCode) *tokenRejection {
+		return &tokenRejection{respCode: http.StatusUnauthorized, msg: msg, err: err, code: code}
+	}
+
+	// Parse the JWT token
+	claims := jwt.MapClaims{}
+	if _, err := h.oidClient.ParseJWT(rawToken, &claims); err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, unauthorized("JWT bearer token has expired", err, ErrCodeTokenExpired)
+		}
+		return nil, unauthorized("Unable to parse JWT bearer token", err, ErrCodeTokenInvalid)
+	}
+
+	// OAuth2 introspect
+	if h.introspectionEnabled() {
+		if !h.oidClient.CanIntrospect() {
+			return nil, unauthorized(
+				"Missing required settings to perform introspection", nil, ErrCodeInternal,
+			)
+		}
+		expirationTime, err := fetchClaimAsFloat(claims, "exp")
+		if err != nil {
+			return nil, unauthorized("Unable to parse out 'exp' claim", err, ErrCodeClaimInvalid)
+		}
+		isValid, err := h.introspector.VerifyToken(
+			ctxt, rawToken, int64(expirationTime), time.Now().UTC(),
+		)
+		if err != nil {
+			return nil, unauthorized("Introspection process errored", err, ErrCodeInternal)
+		}
+		if !isValid {
+			return nil, unauthorized("Token no longer active", nil, ErrCodeTokenInactive)
+		}
+	}
+
+	// Check "aud" if target audience specified
+	if msg, err := h.checkAudience(claims); err != nil {
+		return nil, &tokenRejection{
+			respCode: http.StatusBadRequest, msg: msg, err: err, code: ErrCodeClaimInvalid,
+		}
+	}
+	return claims, nil
+}
+
+/*
+VerifyBearerTokenUser verify the bearer token carried in the token header, as with
+VerifyBearerToken, and read the user ID out of it the same way the authentication server
+does: through the claims mapping of the token's issuer, prefixed with the user ID namespace of
+the issuer if it has one, then normalized.
+
+	@param ctxt context.Context - the operating context
+	@param header string - the value of the token header
+	@param userIDClaim string - if provided, the claim carrying the user ID of the tokens from
+	issuers without their own claims mapping
+	@return the user ID, or the reason the token is not accepted
+*/
+func (h AuthenticationHandler) VerifyBearerTokenUser(
+	ctxt context.Context, header string, userIDClaim string,
+) (string, error) {
+	claims, err := h.VerifyBearerToken(ctxt, header)
+	if err != nil {
+		return "", err
+	}
+	targetClaims := h.claimsOfInterest(claims)
+	if _, mapped := h.issuerClaimsOf(claims); !mapped && userIDClaim != "" {
+		targetClaims.UserIDClaim = userIDClaim
+	}
+	userID, err := h.readUserID(claims, targetClaims)
+	if err != nil {
+		return "", &tokenRejection{
+			respCode: http.StatusUnauthorized,
+			msg:      fmt.Sprintf("Unable to parse out '%s' claim", targetClaims.UserIDClaim),
+			err:      err,
+			code:     ErrCodeClaimInvalid,
+		}
+	}
+	return h.userIDs.Normalize(userID), nil
+}
+
+/*
+readUserID parse the user ID out of the token claims. The user ID of a token from an issuer
+with a user ID namespace is prefixed with the namespace.
+
+	@param claims jwt.MapClaims - the token claims
+	@param targetClaims common.OpenIDClaimsOfInterestConfig - which claim to read the user ID
+	from
+	@return the user ID
+*/
+func (h AuthenticationHandler) readUserID(
+	claims jwt.MapClaims, targetClaims common.OpenIDClaimsOfInterestConfig,
+) (string, error) {
+	uid, err := fetchClaimAsString(claims, targetClaims.UserIDClaim)
+	if err != nil {
+		return "", err
+	}
+	if issuer, ok := claims["iss"].(string); ok {
+		if namespace, ok := h.issuerUserIDNamespaces[authenticate.CanonicalIssuer(issuer)]; ok {
+			return fmt.Sprintf("%s_%s", namespace, uid), nil
+		}
+	}
+	return uid, nil
+}
+
+/*
+readUserParams parse the user parameters out of the token claims. The user ID of a token from
+an issuer with a user ID namespace is prefixed with the namespace.
+
+	@param claims jwt.MapClaims - the token claims
+	@param targetClaims common.OpenIDClaimsOfInterestConfig - which claims to read the user
+	parameters from
+	@return the user parameters, or the claim which could not be parsed, and the error
+*/
+func (h AuthenticationHandler) readUserParams(
+	claims jwt.MapClaims, targetClaims common.OpenIDClaimsOfInterestConfig,
+) (models.UserConfig, string, error) {
+	userParams := models.UserConfig{}
+
+	// User ID
+	uid, err := h.readUserID(claims, targetClaims)
+	if err != nil {
+		return models.UserConfig{}, targetClaims.UserIDClaim, err
+	}
+	userParams.UserID = uid
+
+	// The optional parameters, if the claim to read them from is specified
+	optional := []struct {
+		claim *string
+		value **string
+	}{
+		{claim: targetClaims.UsernameClaim, value: &userParams.Username},
+		{claim: targetClaims.FirstNameClaim, value: &userParams.FirstName},
+		{claim: targetClaims.LastNameClaim, value: &userParams.LastName},
+		{claim: targetClaims.EmailClaim, value: &userParams.Email},
+	}
+	for _, param := range optional {
+		if param.claim == nil {
+			continue
+		}
+		value, err := fetchClaimAsString(claims, *param.claim)
+		if err != nil {
+			return models.UserConfig{}, *param.claim, err
+		}
+		*param.value = &value
+	}
+
+	return userParams, "", nil
+}
+
+/*
+readClientID parse the ID of the OAuth2 client the token was issued to out of the token
+claims. The client ID is optional, so a token without any of the claims is not an error.
+
+	@param claims jwt.MapClaims - the token claims
+	@param clientIDClaims []string - the claims which may contain the client ID, in order
+	@return the client ID, or empty if the token carries none
+*/
+func readClientID(claims jwt.MapClaims, clientIDClaims []string) string {
+	for _, claim := range clientIDClaims {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+/*
+readScopes parse the OAuth2 scopes of the token out of the token claims. The scopes are
+given either as a space separated string, or a list of strings. The scopes are optional, so
+a token without the claim is not an error.
+
+	@param claims jwt.MapClaims - the token claims
+	@param scopeClaim string - the claim containing the scopes
+	@return the scopes, or empty if the token carries none
+*/
+func readScopes(claims jwt.MapClaims, scopeClaim string) []string {
+	if scopeClaim == "" {
+		return nil
+	}
+	switch value := claims[scopeClaim].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		scopes := []string{}
+		for _, entry := range value {
+			if scope, ok := entry.(string); ok && scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
+
+/*
+claimsOfInterest select the claims of interest for a token, by the issuer of the token
+
+	@param claims jwt.MapClaims - the token claims
+	@return the claims of interest
+*/
+func (h AuthenticationHandler) claimsOfInterest(
+	claims jwt.MapClaims,
+) common.OpenIDClaimsOfInterestConfig {
+	if targetClaims, ok := h.issuerClaimsOf(claims); ok {
+		return targetClaims
+	}
+	return h.targetClaims
+}
+
+/*
+issuerClaimsOf select the claims of interest of the issuer of a token, if the issuer has its
+own claims mapping
+
+	@param claims jwt.MapClaims - the token claims
+	@return the claims of interest of the issuer, and whether the issuer has any
+*/
+func (h AuthenticationHandler) issuerClaimsOf(
+	claims jwt.MapClaims,
+) (common.OpenIDClaimsOfInterestConfig, bool) {
+	if issuer, ok := claims["iss"].(string); ok {
+		if targetClaims, ok := h.issuerClaims[authenticate.CanonicalIssuer(issuer)]; ok {
+			return targetClaims, true
+		}
+	}
+	return common.OpenIDClaimsOfInterestConfig{}, false
+}
+
+/*
+enrichFromUserinfo fill in the claims of interest the token lacks from the OpenID issuer's
+userinfo endpoint. Claims already in the token are not overwritten. Failing to reach the
+userinfo endpoint is not an error here; the missing claims are reported when the user
+parameters are read.
+
+	@param ctxt context.Context - the operating context
+	@param rawToken string - the access token
+	@param claims jwt.MapClaims - the token claims to enrich
+	@param targetClaims common.OpenIDClaimsOfInterestConfig - the claims of interest
+*/
+func (h AuthenticationHandler) enrichFromUserinfo(
+	ctxt context.Context,
+	rawToken string,
+	claims jwt.MapClaims,
+	targetClaims common.OpenIDClaimsOfInterestConfig,
+) {
+	logTags := h.GetLogTagsForContext(ctxt)
+
+	missing := false
+	for _, claim := range []*string{
+		&targetClaims.UserIDClaim,
+		targetClaims.UsernameClaim,
+		targetClaims.FirstNameClaim,
+		targetClaims.LastNameClaim,
+		targetClaims.EmailClaim,
+	} {
+		if claim == nil {
+			continue
+		}
+		if _, ok := claims[*claim]; !ok {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return
+	}
+
+	expirationTime, err := fetchClaimAsFloat(claims, "exp")
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Warn("Unable to parse out 'exp' claim for userinfo")
+		return
+	}
+	userinfo, err := h.userinfo.FetchUserinfo(
+		ctxt, rawToken, int64(expirationTime), time.Now().UTC(),
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Warn("Unable to fetch userinfo for token")
+		return
+	}
+	for claim, value := range userinfo {
+		if _, ok := claims[claim]; !ok {
+			claims[claim] = value
+		}
+	}
+}
+
 // ====================================================================================
 // Authenticate
 
 // Authenticate godoc
 // @Summary Authenticate a user
-// @Description Authticate a user by verifiying the bearer token provided
+// @Description Authticate a user by verifiying the bearer token provided. If SAML validation
+// @Description is enabled, a SAML response forwarded in the configured header, or form posted
+// @Description in the configured field, is accepted in place of the bearer token. Likewise for
+// @Description a Cloudflare Access JWT assertion, if Cloudflare Access validation is enabled,
+// @Description and for a client certificate, if client certificate authentication is enabled.
 // @tags Authenticate
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Param Authorization header string true "User must provide a bearer token"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 401 {string} string "error"
 // @Failure 403 {string} string "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/authenticate [get]
+// @Router /v1/authenticate [post]
 func (h AuthenticationHandler) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var respCode int
 	var response interface{}
 	respHeaders := map[string]string{}
+	// outcome is the authentication outcome, if not implied by the response
+	var outcome string
+	startTime := time.Now()
 	logTags := h.GetLogTagsForContext(r.Context())
 	defer func() {
+		if h.decisionMetrics != nil {
+			if outcome == "" {
+				outcome = authenticateOutcomeFor(respCode, response)
+			}
+			h.decisionMetrics.RecordAuthentication(
+				r.Header.Get(h.reqHeaderParam.Host), outcome, time.Since(startTime),
+			)
+		}
+		if respCode == http.StatusUnauthorized && h.failures != nil {
+			for name, value := range respHeaders {
+				w.Header().Set(name, value)
+			}
+			written, err := h.failures.write(
+				w,
+				r,
+				r.Header.Get(h.reqHeaderParam.Host),
+				r.Header.Get(h.reqHeaderParam.Path),
+				respCode,
+				response,
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Error("Failed to form failure response")
+			}
+			if written {
+				return
+			}
+		}
 		if err := h.WriteRESTResponse(w, respCode, response, respHeaders); err != nil {
 			log.WithError(err).WithFields(logTags).Error("Failed to form response")
 		}
@@ -126,191 +945,406 @@ func (h AuthenticationHandler) Authenticate(w http.ResponseWriter, r *http.Reque
 			msg := "authn bypass check failed"
 			log.WithError(err).WithFields(logTags).Error(msg)
 			respCode = http.StatusBadRequest
-			response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+			response = newErrorResponse(
+				h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+				ErrCodeInvalidRequest,
+			)
 			return
 		}
 		// Bypass authentication
 		if matched {
+			outcome = AuthenticateOutcomeBypass
 			respCode = http.StatusOK
 			response = h.GetStdRESTSuccessMsg(r.Context())
 			return
 		}
 	}
 
-	errMacroNoErr := func(msg string) {
-		log.WithFields(logTags).Errorf(msg)
-		respCode = http.StatusUnauthorized
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusUnauthorized, msg, "")
-	}
-
-	// Read the JWT Bearer token
-	bearer := r.Header.Get("Authorization")
-	if bearer == "" {
-		errMacroNoErr("Header 'Authorization' missing")
-		return
-	}
-	bearerParts := strings.Split(bearer, " ")
-	if len(bearerParts) != 2 {
-		errMacroNoErr("Bearer 'Authorization' has incorrect format")
-		return
-	}
-	rawToken := bearerParts[1]
-
-	errMacro := func(msg string, err error) {
-		log.WithError(err).WithFields(logTags).Errorf(msg)
-		respCode = http.StatusUnauthorized
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusUnauthorized, msg, err.Error())
-	}
-
-	// Parse the JWT token
-	userClaims := new(jwt.MapClaims)
-	_, err := h.oidClient.ParseJWT(rawToken, userClaims)
-	if err != nil {
-		errMacro("Unable to parse JWT bearer token", err)
-		return
-	}
-
-	{
-		t, _ := json.MarshalIndent(userClaims, "", "  ")
-		log.WithFields(logTags).Debugf("Token claims\n%s", t)
-	}
-
-	fetchClaimAsString := func(target string) (string, error) {
-		if v, ok := (*userClaims)[target]; ok {
-			if value, ok := v.(string); ok {
-				return value, nil
+	// Accept a SAML response in place of a bearer token
+	if h.samlValidator != nil {
+		if encoded := h.readSAMLResponse(r); encoded != "" {
+			assertion, err := h.samlValidator.ValidateResponse(r.Context(), encoded)
+			if err != nil {
+				msg := "SAML response failed validation"
+				log.WithError(err).WithFields(logTags).Errorf(msg)
+				respCode = http.StatusUnauthorized
+				response = newErrorResponse(
+					h.GetStdRESTErrorMsg(r.Context(), http.StatusUnauthorized, msg, err.Error()),
+					ErrCodeAssertionInvalid,
+				)
+				return
 			}
-			return "", fmt.Errorf("bearer 'Authorization' token's claim %s is not a string", target)
+			userParams, badAttribute, err := h.readSAMLUserParams(assertion)
+			if err != nil {
+				msg := fmt.Sprintf("Unable to parse out '%s' attribute", badAttribute)
+				log.WithError(err).WithFields(logTags).Errorf(msg)
+				respCode = http.StatusBadRequest
+				response = newErrorResponse(
+					h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+					ErrCodeClaimInvalid,
+				)
+				return
+			}
+			h.setUserParamHeaders(respHeaders, userParams)
+			respCode = http.StatusOK
+			response = h.GetStdRESTSuccessMsg(r.Context())
+			return
 		}
-		return "", fmt.Errorf("bearer 'Authorization' token missing %s", target)
 	}
 
-	fetchClaimAsFloat := func(target string) (float64, error) {
-		if v, ok := (*userClaims)[target]; ok {
-			if value, ok := v.(float64); ok {
-				return value, nil
+	// Accept a Cloudflare Access JWT assertion in place of a bearer token
+	if h.cfAccessValidator != nil {
+		if assertion := r.Header.Get(h.cfAccessCfg.Header); assertion != "" {
+			claims, err := h.cfAccessValidator.ValidateAssertion(r.Context(), assertion)
+			if err != nil {
+				msg := "Cloudflare Access assertion failed validation"
+				code := ErrCodeTokenInvalid
+				if errors.Is(err, jwt.ErrTokenExpired) {
+					msg = "Cloudflare Access assertion has expired"
+					code = ErrCodeTokenExpired
+				}
+				log.WithError(err).WithFields(logTags).Errorf(msg)
+				respCode = http.StatusUnauthorized
+				response = newErrorResponse(
+					h.GetStdRESTErrorMsg(r.Context(), http.StatusUnauthorized, msg, err.Error()), code,
+				)
+				return
+			}
+			userParams, badClaim, err := h.readUserParams(claims, h.cfAccessCfg.Claims)
+			if err != nil {
+				msg := fmt.Sprintf("Unable to parse out '%s' claim", badClaim)
+				log.WithError(err).WithFields(logTags).Errorf(msg)
+				respCode = http.StatusBadRequest
+				response = newErrorResponse(
+					h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+					ErrCodeClaimInvalid,
+				)
+				return
 			}
-			return 0, fmt.Errorf("bearer 'Authorization' token's claim %s is not a FLOAT64", target)
+			h.setUserParamHeaders(respHeaders, userParams)
+			respCode = http.StatusOK
+			response = h.GetStdRESTSuccessMsg(r.Context())
+			return
 		}
-		return 0, fmt.Errorf("bearer 'Authorization' token missing %s", target)
 	}
 
-	// OAuth2 introspect
-	if h.performIntrospect {
-		if !h.oidClient.CanIntrospect() {
-			errMacroNoErr("Missing required settings to perform introspection")
+	// Accept an API key in place of a bearer token
+	if h.apiKeys != nil {
+		if apiKey := h.readAPIKey(r); apiKey != "" {
+			user, err := h.apiKeys.VerifyAPIKey(r.Context(), apiKey, time.Now().UTC())
+			if err != nil {
+				msg := "API key failed verification"
+				respCode = http.StatusUnauthorized
+				code := ErrCodeAPIKeyInvalid
+				if !errors.Is(err, users.ErrAPIKeyInvalid) && !errors.Is(err, models.ErrUserNotFound) {
+					msg = "Unable to verify API key"
+					respCode = http.StatusInternalServerError
+					code = ErrCodeInternal
+				}
+				log.WithError(err).WithFields(logTags).Errorf(msg)
+				response = newErrorResponse(
+					h.GetStdRESTErrorMsg(r.Context(), respCode, msg, err.Error()), code,
+				)
+				return
+			}
+			h.setUserParamHeaders(respHeaders, user.UserConfig)
+			respCode = http.StatusOK
+			response = h.GetStdRESTSuccessMsg(r.Context())
 			return
 		}
-		expirationTime, err := fetchClaimAsFloat("exp")
+	}
+
+	// Accept a client certificate in place of a bearer token. A presented token is never
+	// replaced by the certificate identity.
+	if h.clientCerts != nil && r.Header.Get(h.tokenHeader.Header) == "" {
+		identity, presented, err := h.clientCerts.ReadIdentity(r.Context(), r)
 		if err != nil {
-			errMacro("Unable to parse out 'exp' claim", err)
+			msg := "Client certificate failed validation"
+			log.WithError(err).WithFields(logTags).Errorf(msg)
+			respCode = http.StatusUnauthorized
+			response = newErrorResponse(
+				h.GetStdRESTErrorMsg(r.Context(), http.StatusUnauthorized, msg, err.Error()),
+				ErrCodeCertificateInvalid,
+			)
 			return
 		}
-		isValid, err := h.introspector.VerifyToken(
-			r.Context(), rawToken, int64(expirationTime), time.Now().UTC(),
-		)
-		if err != nil {
-			errMacro("Introspection process errored", err)
+		if presented {
+			userParams := models.UserConfig{UserID: identity.UserID}
+			if identity.Email != "" {
+				userParams.Email = &identity.Email
+			}
+			h.setUserParamHeaders(respHeaders, userParams)
+			// The caller is a workload, not a person
+			respHeaders[h.respHeaderParam.ClientID] = identity.UserID
+			respCode = http.StatusOK
+			response = h.GetStdRESTSuccessMsg(r.Context())
 			return
 		}
-		if !isValid {
-			errMacroNoErr("Token no longer active")
-			return
+	}
+
+	// Read and verify the JWT Bearer token
+	rawToken, rejected := h.readBearerToken(r.Header.Get(h.tokenHeader.Header))
+	var claims jwt.MapClaims
+	if rejected == nil {
+		claims, rejected = h.verifyBearerToken(r.Context(), rawToken)
+	}
+	if rejected != nil {
+		if errors.Is(rejected.err, jwt.ErrTokenSignatureInvalid) {
+			outcome = AuthenticateOutcomeBadSignature
+		}
+		detail := ""
+		if rejected.err != nil {
+			detail = rejected.err.Error()
 		}
+		log.WithError(rejected.err).WithFields(logTags).Errorf(rejected.msg)
+		respCode = rejected.respCode
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), respCode, rejected.msg, detail), rejected.code,
+		)
+		return
+	}
+	userClaims := &claims
+
+	{
+		t, _ := json.MarshalIndent(userClaims, "", "  ")
+		log.WithFields(logTags).Debugf("Token claims\n%s", t)
 	}
 
-	errMacro = func(msg string, err error) {
+	errMacro := func(msg string, err error, code ErrorCode) {
 		log.WithError(err).WithFields(logTags).Errorf(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()), code,
+		)
 	}
 
-	// Check "aud" if target audience specified
-	if h.targetAudience != nil {
-		aud, err := fetchClaimAsString("aud")
-		if err != nil {
-			errMacro("Unable to parse out 'aud' claim", err)
-			return
-		}
-		// Verify audience matches
-		if aud != *h.targetAudience {
-			err := fmt.Errorf("'aud' claim does not match expectation")
-			errMacro("Invalid token", err)
-			return
-		}
+	// Fill in the claims of interest the token lacks
+	targetClaims := h.claimsOfInterest(*userClaims)
+	if h.userinfo != nil {
+		h.enrichFromUserinfo(r.Context(), rawToken, *userClaims, targetClaims)
 	}
 
 	// Parse out the critical fields
-	userParams := models.UserConfig{}
-
-	// User ID
-	uid, err := fetchClaimAsString(h.targetClaims.UserIDClaim)
+	userParams, badClaim, err := h.readUserParams(*userClaims, targetClaims)
 	if err != nil {
-		errMacro(fmt.Sprintf("Unable to parse out '%s' claim", h.targetClaims.UserIDClaim), err)
+		errMacro(fmt.Sprintf("Unable to parse out '%s' claim", badClaim), err, ErrCodeClaimInvalid)
 		return
 	}
-	userParams.UserID = uid
-	respHeaders[h.respHeaderParam.UserID] = uid
+	h.setUserParamHeaders(respHeaders, userParams)
+	if clientID := readClientID(*userClaims, targetClaims.ClientIDClaims); clientID != "" {
+		respHeaders[h.respHeaderParam.ClientID] = clientID
+	}
+	if h.respHeaderParam.Scopes != "" {
+		if scopes := readScopes(*userClaims, targetClaims.ScopeClaim); len(scopes) > 0 {
+			respHeaders[h.respHeaderParam.Scopes] = strings.Join(scopes, " ")
+		}
+	}
 
-	// User name
-	if h.targetClaims.UsernameClaim != nil {
-		username, err := fetchClaimAsString(*h.targetClaims.UsernameClaim)
-		if err != nil {
-			errMacro(fmt.Sprintf("Unable to parse out '%s' claim", *h.targetClaims.UsernameClaim), err)
-			return
+	{
+		t, _ := json.MarshalIndent(userParams, "", "  ")
+		log.WithFields(logTags).Debugf("User parameters in Token\n%s", t)
+	}
+
+	// Set the response headers
+
+	respCode = http.StatusOK
+	response = h.GetStdRESTSuccessMsg(r.Context())
+}
+
+// AuthenticateHandler Wrapper around Authenticate
+func (h AuthenticationHandler) AuthenticateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.Authenticate(w, r)
+	}
+}
+
+// ====================================================================================
+// Token Introspection
+
+// ReqIntrospectToken is the API request to introspect a token
+type ReqIntrospectToken struct {
+	// Token is the token to introspect
+	Token string `json:"token" validate:"required"`
+}
+
+// RespIntrospectToken is the API response giving the normalized introspection result
+type RespIntrospectToken struct {
+	goutils.RestAPIBaseResponse
+	// Active whether the token is active
+	Active bool `json:"active"`
+	// Reason is why the token is not active
+	Reason ErrorCode `json:"reason,omitempty"`
+	// User is the user parameters in the token, if active
+	User *models.UserConfig `json:"user,omitempty"`
+	// Expire is when the token expires, as a unix timestamp, if active
+	Expire int64 `json:"exp,omitempty"`
+}
+
+// IntrospectToken godoc
+// @Summary Introspect a token
+// @Description Introspect a token on behalf of a backend, using the token cache and the
+// @Description OpenID issuer client credentials of this application. The backend authenticates
+// @Description with its own client credentials, through HTTP basic authentication. The token,
+// @Description or API key, is verified in the same way as for authentication, and the user
+// @Description parameters are read from the same claims. A token which is not active is
+// @Description reported with the reason, and not as an error.
+// @tags Authenticate
+// @Accept json
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param token body ReqIntrospectToken true "Token to introspect"
+// @Success 200 {object} RespIntrospectToken "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 401 {object} RespError "error"
+// @Failure 404 {object} RespError "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/token/introspect [post]
+func (h AuthenticationHandler) IntrospectToken(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	respHeaders := map[string]string{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, respHeaders); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
 		}
-		userParams.Username = &username
-		respHeaders[h.respHeaderParam.Username] = username
+	}()
+
+	if !h.introspectEndpoint.Enabled {
+		msg := "token introspection not supported"
+		log.WithFields(logTags).Error(msg)
+		respCode = http.StatusNotFound
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusNotFound, msg, ""),
+			ErrCodeFeatureDisabled,
+		)
+		return
+	}
+
+	// The caller must present the client credentials of a known backend
+	if !h.introspectClientKnown(r) {
+		msg := "introspection client credentials not accepted"
+		log.WithFields(logTags).Error(msg)
+		respHeaders["WWW-Authenticate"] = `Basic realm="padlock"`
+		respCode = http.StatusUnauthorized
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusUnauthorized, msg, ""),
+			ErrCodeClientInvalid,
+		)
+		return
 	}
 
-	// First name
-	if h.targetClaims.FirstNameClaim != nil {
-		firstName, err := fetchClaimAsString(*h.targetClaims.FirstNameClaim)
+	var request ReqIntrospectToken
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		msg := "introspection request not parsable"
+		detail := "token missing"
 		if err != nil {
-			errMacro(fmt.Sprintf("Unable to parse out '%s' claim", *h.targetClaims.FirstNameClaim), err)
-			return
+			detail = err.Error()
 		}
-		userParams.FirstName = &firstName
-		respHeaders[h.respHeaderParam.FirstName] = firstName
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, detail),
+			ErrCodeInvalidRequest,
+		)
+		return
 	}
 
-	// Last name
-	if h.targetClaims.LastNameClaim != nil {
-		lastName, err := fetchClaimAsString(*h.targetClaims.LastNameClaim)
-		if err != nil {
-			errMacro(fmt.Sprintf("Unable to parse out '%s' claim", *h.targetClaims.LastNameClaim), err)
-			return
+	inactive := func(msg string, err error, reason ErrorCode) {
+		log.WithError(err).WithFields(logTags).Info(msg)
+		respCode = http.StatusOK
+		response = RespIntrospectToken{
+			RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Active: false, Reason: reason,
 		}
-		userParams.LastName = &lastName
-		respHeaders[h.respHeaderParam.LastName] = lastName
+	}
+	internalErr := func(msg string, err error) {
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			ErrCodeInternal,
+		)
 	}
 
-	// Email
-	if h.targetClaims.EmailClaim != nil {
-		email, err := fetchClaimAsString(*h.targetClaims.EmailClaim)
+	// An API key is verified against the user database, so revoked keys are not active
+	if h.apiKeys != nil && users.IsAPIKey(request.Token) {
+		user, err := h.apiKeys.VerifyAPIKey(r.Context(), request.Token, time.Now().UTC())
 		if err != nil {
-			errMacro(fmt.Sprintf("Unable to parse out '%s' claim", *h.targetClaims.EmailClaim), err)
+			if errors.Is(err, users.ErrAPIKeyInvalid) || errors.Is(err, models.ErrUserNotFound) {
+				inactive("API key failed verification", err, ErrCodeAPIKeyInvalid)
+			} else {
+				internalErr("Unable to verify API key", err)
+			}
 			return
 		}
-		userParams.Email = &email
-		respHeaders[h.respHeaderParam.Email] = email
+		respCode = http.StatusOK
+		response = RespIntrospectToken{
+			RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()),
+			Active:              true,
+			User:                &user.UserConfig,
+		}
+		return
 	}
 
-	{
-		t, _ := json.MarshalIndent(userParams, "", "  ")
-		log.WithFields(logTags).Debugf("User parameters in Token\n%s", t)
+	// Verify the JWT token the same way as for authentication
+	claims, rejected := h.verifyBearerToken(r.Context(), request.Token)
+	if rejected != nil {
+		if rejected.code == ErrCodeInternal {
+			internalErr(rejected.msg, rejected)
+		} else {
+			inactive(rejected.msg, rejected.err, rejected.code)
+		}
+		return
 	}
 
-	// Set the response headers
+	// Fill in the claims of interest the token lacks
+	targetClaims := h.claimsOfInterest(claims)
+	if h.userinfo != nil {
+		h.enrichFromUserinfo(r.Context(), request.Token, claims, targetClaims)
+	}
+	userParams, badClaim, err := h.readUserParams(claims, targetClaims)
+	if err != nil {
+		inactive(fmt.Sprintf("Unable to parse out '%s' claim", badClaim), err, ErrCodeClaimInvalid)
+		return
+	}
 
 	respCode = http.StatusOK
-	response = h.GetStdRESTSuccessMsg(r.Context())
+	result := RespIntrospectToken{
+		RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()),
+		Active:              true,
+		User:                &userParams,
+	}
+	if expirationTime, err := fetchClaimAsFloat(claims, "exp"); err == nil {
+		result.Expire = int64(expirationTime)
+	}
+	response = result
 }
 
-// AuthenticateHandler Wrapper around Authenticate
-func (h AuthenticationHandler) AuthenticateHandler() http.HandlerFunc {
+/*
+introspectClientKnown whether the caller of the token introspection endpoint presented the
+client credentials of a known backend
+
+	@param r *http.Request - the request
+	@return whether the client is known
+*/
+func (h AuthenticationHandler) introspectClientKnown(r *http.Request) bool {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	for _, client := range h.introspectEndpoint.Clients {
+		if subtle.ConstantTimeCompare([]byte(clientID), []byte(client.ID)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// IntrospectTokenHandler Wrapper around IntrospectToken
+func (h AuthenticationHandler) IntrospectTokenHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		h.Authenticate(w, r)
+		h.IntrospectToken(w, r)
 	}
 }
 
@@ -320,10 +1354,15 @@ func (h AuthenticationHandler) AuthenticateHandler() http.HandlerFunc {
 // AuthenticationLivenessHandler the request authentication REST API liveness handler
 type AuthenticationLivenessHandler struct {
 	goutils.RestAPIHandler
+	startup common.ReadinessGate
+	// issuerHealth if provided, the module is only ready while the OpenID issuer is healthy
+	issuerHealth authenticate.IssuerHealthStatus
 }
 
 func defineAuthenticationLivenessHandler(
 	logConfig common.HTTPRequestLogging,
+	startup common.ReadinessGate,
+	issuerHealth authenticate.IssuerHealthStatus,
 ) AuthenticationLivenessHandler {
 	logTags := log.Fields{
 		"module": "apis", "component": "api-handler", "instance": "authentication-liveness",
@@ -347,6 +1386,8 @@ func defineAuthenticationLivenessHandler(
 			}(),
 			LogLevel: logConfig.HealthLogLevel,
 		},
+		startup:      startup,
+		issuerHealth: issuerHealth,
 	}
 }
 
@@ -357,9 +1398,9 @@ func defineAuthenticationLivenessHandler(
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/alive [get]
 func (h AuthenticationLivenessHandler) Alive(w http.ResponseWriter, r *http.Request) {
 	logTags := h.GetLogTagsForContext(r.Context())
@@ -381,14 +1422,15 @@ func (h AuthenticationLivenessHandler) AliveHandler() http.HandlerFunc {
 
 // Ready godoc
 // @Summary Authentication API readiness check
-// @Description Will return success if Authentication REST API module is ready for use
+// @Description Will return success if Authentication REST API module is ready for use. If the
+// @Description OpenID issuer health gates readiness, the issuer must also be healthy.
 // @tags Authenticate
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/ready [get]
 func (h AuthenticationLivenessHandler) Ready(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -399,8 +1441,29 @@ func (h AuthenticationLivenessHandler) Ready(w http.ResponseWriter, r *http.Requ
 			log.WithError(err).WithFields(logTags).Error("Failed to form response")
 		}
 	}()
-	respCode = http.StatusOK
-	response = h.GetStdRESTSuccessMsg(r.Context())
+	if err := h.startup.Ready(); err != nil {
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(
+				r.Context(), http.StatusInternalServerError, "not ready", err.Error(),
+			),
+			ErrCodeNotReady,
+		)
+	} else if h.issuerHealth != nil && !h.issuerHealth.IssuerHealthy() {
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(
+				r.Context(),
+				http.StatusInternalServerError,
+				"not ready",
+				"OpenID issuer is not healthy",
+			),
+			ErrCodeNotReady,
+		)
+	} else {
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
+	}
 }
 
 // ReadyHandler Wrapper around Alive
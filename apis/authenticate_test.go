@@ -0,0 +1,976 @@
+package apis
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/authenticate"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeSAMLValidator accepts a fixed set of SAML responses
+type fakeSAMLValidator struct {
+	assertions map[string]authenticate.SAMLAssertion
+}
+
+func (v fakeSAMLValidator) ValidateResponse(
+	ctxt context.Context, encoded string,
+) (authenticate.SAMLAssertion, error) {
+	assertion, ok := v.assertions[encoded]
+	if !ok {
+		return authenticate.SAMLAssertion{}, fmt.Errorf("unknown SAML response")
+	}
+	return assertion, nil
+}
+
+func TestAuthenticateWithSAML(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	emailAttribute := "email"
+	respHeaders := common.AuthorizeRequestParamLocConfig{
+		UserID: "X-Caller-UserID", Email: "X-Caller-Email",
+	}
+	uut, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		nil,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			SAML: common.SAMLConfig{
+				Header:     "X-SAML-Response",
+				FormField:  "SAMLResponse",
+				Attributes: common.SAMLAttributesConfig{Email: &emailAttribute},
+			},
+		},
+		respHeaders,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+	uut.samlValidator = fakeSAMLValidator{
+		assertions: map[string]authenticate.SAMLAssertion{
+			"valid": {
+				NameID:     "alice",
+				Attributes: map[string][]string{"email": {"alice@unit-test.org"}},
+			},
+			"no-email": {NameID: "bob", Attributes: map[string][]string{}},
+		},
+	}
+
+	// Case 0: SAML response in the header
+	{
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add("X-SAML-Response", "valid")
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusOK, respRecorder.Code)
+		assert.Equal("alice", respRecorder.Header().Get(respHeaders.UserID))
+		assert.Equal("alice@unit-test.org", respRecorder.Header().Get(respHeaders.Email))
+	}
+
+	// Case 1: SAML response form posted
+	{
+		form := url.Values{}
+		form.Set("SAMLResponse", "valid")
+		req, err := http.NewRequest("POST", "/v1/authenticate", strings.NewReader(form.Encode()))
+		assert.Nil(err)
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusOK, respRecorder.Code)
+		assert.Equal("alice", respRecorder.Header().Get(respHeaders.UserID))
+	}
+
+	// Case 2: SAML response failed validation
+	{
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add("X-SAML-Response", "forged")
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusUnauthorized, respRecorder.Code)
+		assert.Contains(respRecorder.Body.String(), string(ErrCodeAssertionInvalid))
+		assert.Empty(respRecorder.Header().Get(respHeaders.UserID))
+	}
+
+	// Case 3: SAML assertion missing a configured attribute
+	{
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add("X-SAML-Response", "no-email")
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusBadRequest, respRecorder.Code)
+		assert.Contains(respRecorder.Body.String(), string(ErrCodeClaimInvalid))
+	}
+}
+
+// fakeCloudflareAccessValidator accepts a fixed set of Cloudflare Access assertions
+type fakeCloudflareAccessValidator struct {
+	assertions map[string]jwt.MapClaims
+}
+
+func (v fakeCloudflareAccessValidator) ValidateAssertion(
+	ctxt context.Context, raw string,
+) (jwt.MapClaims, error) {
+	claims, ok := v.assertions[raw]
+	if !ok {
+		return nil, fmt.Errorf("unknown assertion")
+	}
+	return claims, nil
+}
+
+func TestAuthenticateWithCloudflareAccess(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	emailClaim := "email"
+	respHeaders := common.AuthorizeRequestParamLocConfig{
+		UserID: "X-Caller-UserID", Email: "X-Caller-Email",
+	}
+	uut, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		nil,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			CloudflareAccess: common.CloudflareAccessConfig{
+				Header: "Cf-Access-Jwt-Assertion",
+				Claims: common.OpenIDClaimsOfInterestConfig{
+					UserIDClaim: "sub", EmailClaim: &emailClaim,
+				},
+			},
+		},
+		respHeaders,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+	uut.cfAccessValidator = fakeCloudflareAccessValidator{
+		assertions: map[string]jwt.MapClaims{
+			"valid":    {"sub": "alice", "email": "alice@unit-test.org"},
+			"no-email": {"sub": "bob"},
+		},
+	}
+
+	runAuthenticate := func(assertion string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add("Cf-Access-Jwt-Assertion", assertion)
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: valid assertion
+	{
+		resp := runAuthenticate("valid")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("alice", resp.Header().Get(respHeaders.UserID))
+		assert.Equal("alice@unit-test.org", resp.Header().Get(respHeaders.Email))
+	}
+
+	// Case 1: assertion failed validation
+	{
+		resp := runAuthenticate("forged")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeTokenInvalid))
+	}
+
+	// Case 2: assertion missing a configured claim
+	{
+		resp := runAuthenticate("no-email")
+		assert.Equal(http.StatusBadRequest, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeClaimInvalid))
+	}
+}
+
+/*
+defineTestClientCert generate a CA, and a client certificate with a SPIFFE ID signed by it
+
+	@param t *testing.T - the test
+	@param spiffeID string - the SPIFFE ID of the client
+	@return the CA file, and the PEM encoded client certificate
+*/
+func defineTestClientCert(t *testing.T, spiffeID string) (string, string) {
+	assert := assert.New(t)
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "unit-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, &caTemplate, &caTemplate, &caKey.PublicKey, caKey,
+	)
+	assert.Nil(err)
+	caCert, err := x509.ParseCertificate(caDER)
+	assert.Nil(err)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	assert.Nil(os.WriteFile(
+		caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600,
+	))
+
+	clientURI, err := url.Parse(spiffeID)
+	assert.Nil(err)
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "reporter"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{clientURI},
+	}, caCert, &clientKey.PublicKey, caKey)
+	assert.Nil(err)
+	return caFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}))
+}
+
+func TestAuthenticateWithClientCert(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	spiffeID := "spiffe://unit-test.org/reporter"
+	caFile, clientPEM := defineTestClientCert(t, spiffeID)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{"alice-token": {"sub": "alice"}},
+	}
+	respHeaders := common.AuthorizeRequestParamLocConfig{
+		UserID: "X-Caller-UserID", ClientID: "X-Caller-ClientID", Email: "X-Caller-Email",
+	}
+	uut, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		oidClient,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			TargetClaims: common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+			ClientCert: common.ClientCertAuthConfig{
+				Enabled:         true,
+				Headers:         []string{authenticate.XFCCHeader},
+				TrustedProxies:  []string{"10.0.0.0/8"},
+				IdentitySources: []string{"uri", "cn"},
+				CAFile:          caFile,
+			},
+		},
+		respHeaders,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	forwarded := fmt.Sprintf(`Hash=abc;Cert="%s";Subject="CN=reporter"`, url.PathEscape(clientPEM))
+	runAuthenticate := func(xfcc, remoteAddr, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.RemoteAddr = remoteAddr
+		if xfcc != "" {
+			req.Header.Add(authenticate.XFCCHeader, xfcc)
+		}
+		if token != "" {
+			req.Header.Add("Authorization", "Bearer "+token)
+		}
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+	proxy := "10.0.0.1:43210"
+
+	// Case 0: the caller identity is read from the forwarded certificate
+	{
+		resp := runAuthenticate(forwarded, proxy, "")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal(spiffeID, resp.Header().Get(respHeaders.UserID))
+		assert.Equal(spiffeID, resp.Header().Get(respHeaders.ClientID))
+		assert.Empty(resp.Header().Get(respHeaders.Email))
+	}
+
+	// Case 1: the certificate details without the certificate can't be verified
+	{
+		resp := runAuthenticate(`Hash=abc;URI=spiffe://unit-test.org/admin`, proxy, "")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeCertificateInvalid))
+	}
+
+	// Case 2: without a certificate, a bearer token is expected
+	{
+		resp := runAuthenticate("", proxy, "")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeTokenMissing))
+	}
+
+	// Case 3: a certificate forwarded from an untrusted source is ignored
+	{
+		resp := runAuthenticate(forwarded, "192.168.1.1:43210", "")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeTokenMissing))
+	}
+
+	// Case 4: a presented bearer token is not replaced by the certificate identity
+	{
+		resp := runAuthenticate(forwarded, proxy, "alice-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("alice", resp.Header().Get(respHeaders.UserID))
+		resp = runAuthenticate(forwarded, proxy, "unknown-token")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+	}
+}
+
+// fakeUserinfoFetcher returns fixed userinfo claims
+type fakeUserinfoFetcher struct {
+	claims map[string]interface{}
+	calls  int
+}
+
+func (f *fakeUserinfoFetcher) FetchUserinfo(
+	ctxt context.Context, token string, expire int64, timestamp time.Time,
+) (map[string]interface{}, error) {
+	f.calls++
+	return f.claims, nil
+}
+
+func TestAuthenticateUserinfoEnrichment(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	emailClaim := "email"
+	fetcher := &fakeUserinfoFetcher{
+		claims: map[string]interface{}{"sub": "other-user", "email": "alice@unit-test.org"},
+	}
+	uut := AuthenticationHandler{
+		targetClaims: common.OpenIDClaimsOfInterestConfig{
+			UserIDClaim: "sub", EmailClaim: &emailClaim,
+		},
+		userinfo: fetcher,
+	}
+	exp := float64(time.Now().Add(time.Minute).Unix())
+
+	// Case 0: token has all the claims of interest
+	{
+		claims := jwt.MapClaims{"sub": "alice", "email": "a@unit-test.org", "exp": exp}
+		uut.enrichFromUserinfo(context.Background(), "token", claims, uut.targetClaims)
+		assert.Equal(0, fetcher.calls)
+		assert.Equal("a@unit-test.org", claims["email"])
+	}
+
+	// Case 1: token lacks a claim of interest
+	{
+		claims := jwt.MapClaims{"sub": "alice", "exp": exp}
+		uut.enrichFromUserinfo(context.Background(), "token", claims, uut.targetClaims)
+		assert.Equal(1, fetcher.calls)
+		assert.Equal("alice", claims["sub"])
+		assert.Equal("alice@unit-test.org", claims["email"])
+	}
+}
+
+// fakeOpenIDClient accepts a fixed set of tokens. Only ParseJWT is implemented.
+type fakeOpenIDClient struct {
+	authenticate.OpenIDIssuerClient
+	tokens map[string]jwt.MapClaims
+}
+
+func (c fakeOpenIDClient) ParseJWT(raw string, claimStore jwt.Claims) (*jwt.Token, error) {
+	claims, ok := c.tokens[raw]
+	if !ok {
+		return nil, fmt.Errorf("unknown token")
+	}
+	store := claimStore.(*jwt.MapClaims)
+	if *store == nil {
+		*store = jwt.MapClaims{}
+	}
+	for claim, value := range claims {
+		(*store)[claim] = value
+	}
+	return &jwt.Token{Claims: claimStore, Valid: true}, nil
+}
+
+func TestAuthenticateTokenHeaderSchemes(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{"good-token": {"sub": "alice"}},
+	}
+	respHeaders := common.AuthorizeRequestParamLocConfig{UserID: "X-Caller-UserID"}
+	defineHandler := func(tokenHeader common.TokenHeaderConfig) AuthenticationHandler {
+		uut, err := defineAuthenticationHandler(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+			oidClient,
+			false,
+			nil,
+			common.AuthenticationConfig{
+				TargetClaims: common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+				TokenHeader:  tokenHeader,
+			},
+			respHeaders,
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		)
+		assert.Nil(err)
+		return uut
+	}
+	runAuthenticate := func(
+		uut AuthenticationHandler, header, value string,
+	) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		if value != "" {
+			req.Header.Add(header, value)
+		}
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: default bearer token header
+	{
+		uut := defineHandler(common.TokenHeaderConfig{})
+		resp := runAuthenticate(uut, "Authorization", "bearer good-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("alice", resp.Header().Get(respHeaders.UserID))
+		resp = runAuthenticate(uut, "Authorization", "Token good-token")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeTokenInvalid))
+	}
+
+	// Case 1: alternate header, and additional scheme
+	{
+		uut := defineHandler(common.TokenHeaderConfig{
+			Header: "X-Auth-Token", Schemes: []string{"Bearer", "Token"},
+		})
+		resp := runAuthenticate(uut, "X-Auth-Token", "Token good-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		resp = runAuthenticate(uut, "X-Auth-Token", "Bearer good-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		resp = runAuthenticate(uut, "X-Auth-Token", "Basic good-token")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		resp = runAuthenticate(uut, "Authorization", "Bearer good-token")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeTokenMissing))
+	}
+}
+
+func TestAuthenticateClientID(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{
+			"user-token":    {"sub": "alice"},
+			"machine-token": {"sub": "svc-1", "azp": "report-service"},
+			"legacy-token":  {"sub": "svc-2", "client_id": "legacy-service"},
+		},
+	}
+	respHeaders := common.AuthorizeRequestParamLocConfig{
+		UserID: "X-Caller-UserID", ClientID: "X-Caller-ClientID",
+	}
+	uut, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		oidClient,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			TargetClaims: common.OpenIDClaimsOfInterestConfig{
+				UserIDClaim: "sub", ClientIDClaims: []string{"azp", "client_id"},
+			},
+		},
+		respHeaders,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	for token, expected := range map[string]string{
+		"user-token": "", "machine-token": "report-service", "legacy-token": "legacy-service",
+	} {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add("Authorization", "Bearer "+token)
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusOK, respRecorder.Code, token)
+		assert.Equal(expected, respRecorder.Header().Get(respHeaders.ClientID), token)
+	}
+}
+
+func TestAuthenticateScopes(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{
+			"no-scope-token": {"sub": "alice"},
+			"string-token":   {"sub": "alice", "scope": "openid  reports:read"},
+			"list-token":     {"sub": "alice", "scope": []interface{}{"reports:read", "reports:export"}},
+		},
+	}
+	respHeaders := common.AuthorizeRequestParamLocConfig{
+		UserID: "X-Caller-UserID", Scopes: "X-Caller-Scopes",
+	}
+	uut, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		oidClient,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			TargetClaims: common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub", ScopeClaim: "scope"},
+		},
+		respHeaders,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	for token, expected := range map[string]string{
+		"no-scope-token": "",
+		"string-token":   "openid reports:read",
+		"list-token":     "reports:read reports:export",
+	} {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add("Authorization", "Bearer "+token)
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusOK, respRecorder.Code, token)
+		assert.Equal(expected, respRecorder.Header().Get(respHeaders.Scopes), token)
+	}
+}
+
+func TestAuthenticatePerIssuerClaims(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{
+			"workforce-token":  {"iss": "https://workforce.unit-test.org", "sub": "alice"},
+			"upper-case-token": {"iss": "https://workforce.unit-test.org", "sub": "Alice"},
+			"customer-token": {
+				"iss": "https://customer.unit-test.org/", "sub": "c-1", "customer_id": "bob",
+			},
+		},
+	}
+	respHeaders := common.AuthorizeRequestParamLocConfig{UserID: "X-Caller-UserID"}
+	customerNamespace := "customer"
+	uut, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		oidClient,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			TargetClaims: common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+		},
+		respHeaders,
+		nil,
+		nil,
+		nil,
+		[]common.OpenIDIssuerConfig{
+			{Issuer: "https://workforce.unit-test.org", SharedUserIDs: true},
+			{
+				Issuer:          "https://customer.unit-test.org",
+				Claims:          &common.OpenIDClaimsOfInterestConfig{UserIDClaim: "customer_id"},
+				UserIDNamespace: &customerNamespace,
+			},
+		},
+		nil,
+	)
+	assert.Nil(err)
+	runAuthenticate := func(token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add("Authorization", "Bearer "+token)
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: issuer without its own claims of interest
+	{
+		resp := runAuthenticate("workforce-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("alice", resp.Header().Get(respHeaders.UserID))
+	}
+
+	// Case 1: issuer with its own claims of interest, and user ID namespace
+	{
+		resp := runAuthenticate("customer-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("customer_bob", resp.Header().Get(respHeaders.UserID))
+	}
+
+	// Case 2: the bearer token verifier reads the user ID the same way
+	{
+		verifier, err := DefineBearerTokenVerifier(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+			oidClient,
+			false,
+			nil,
+			common.AuthenticationConfig{
+				TargetClaims: common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+			},
+			nil,
+			[]common.OpenIDIssuerConfig{
+				{Issuer: "https://workforce.unit-test.org", SharedUserIDs: true},
+				{
+					Issuer:          "https://customer.unit-test.org",
+					Claims:          &common.OpenIDClaimsOfInterestConfig{UserIDClaim: "customer_id"},
+					UserIDNamespace: &customerNamespace,
+				},
+			},
+			common.UserIDNormalizationConfig{Lowercase: true},
+		)
+		assert.Nil(err)
+		userID, err := verifier.VerifyBearerTokenUser(
+			context.Background(), "Bearer workforce-token", "",
+		)
+		assert.Nil(err)
+		assert.Equal("alice", userID)
+		userID, err = verifier.VerifyBearerTokenUser(
+			context.Background(), "Bearer customer-token", "sub",
+		)
+		assert.Nil(err)
+		assert.Equal("customer_bob", userID)
+		userID, err = verifier.VerifyBearerTokenUser(
+			context.Background(), "Bearer upper-case-token", "",
+		)
+		assert.Nil(err)
+		assert.Equal("alice", userID)
+	}
+}
+
+func TestAuthenticateWithAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{"good-token": {"sub": "alice"}},
+	}
+	respHeaders := common.AuthorizeRequestParamLocConfig{
+		UserID: "X-Caller-UserID", Username: "X-Caller-Username",
+	}
+	authnCfg := common.AuthenticationConfig{
+		TargetClaims: common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+		APIKey:       common.APIKeyAuthConfig{Enabled: true, Header: "X-API-Key", Scheme: "ApiKey"},
+	}
+	defineHandler := func(apiKeys users.Management) (AuthenticationHandler, error) {
+		return defineAuthenticationHandler(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+			oidClient,
+			false,
+			nil,
+			authnCfg,
+			respHeaders,
+			nil,
+			nil,
+			nil,
+			nil,
+			apiKeys,
+		)
+	}
+	runAuthenticate := func(
+		uut AuthenticationHandler, header, value string,
+	) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add(header, value)
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: API key authentication without the user database
+	{
+		_, err := defineHandler(nil)
+		assert.NotNil(err)
+	}
+
+	uut, err := defineHandler(mgmtCore)
+	assert.Nil(err)
+
+	serviceAccount := uuid.New().String()
+	username := "ci-bot"
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: serviceAccount, Username: &username}, nil,
+	))
+	apiKey, _, err := mgmtCore.CreateAPIKey(context.Background(), serviceAccount, nil, nil)
+	assert.Nil(err)
+
+	// Case 1: API key in the API key header
+	{
+		resp := runAuthenticate(uut, "X-API-Key", apiKey)
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal(serviceAccount, resp.Header().Get(respHeaders.UserID))
+		assert.Equal(username, resp.Header().Get(respHeaders.Username))
+	}
+
+	// Case 2: API key in the bearer token header
+	{
+		resp := runAuthenticate(uut, "Authorization", "ApiKey "+apiKey)
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal(serviceAccount, resp.Header().Get(respHeaders.UserID))
+	}
+
+	// Case 3: bearer tokens are still accepted
+	{
+		resp := runAuthenticate(uut, "Authorization", "Bearer good-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("alice", resp.Header().Get(respHeaders.UserID))
+	}
+
+	// Case 4: unknown API key
+	{
+		resp := runAuthenticate(uut, "X-API-Key", "pdk_unknown")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeAPIKeyInvalid))
+	}
+
+	// Case 5: revoked API key
+	{
+		keys, err := mgmtCore.ListAPIKeys(context.Background(), serviceAccount)
+		assert.Nil(err)
+		assert.Len(keys, 1)
+		assert.Nil(mgmtCore.RevokeAPIKey(context.Background(), serviceAccount, keys[0].KeyID))
+		resp := runAuthenticate(uut, "Authorization", "ApiKey "+apiKey)
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeAPIKeyInvalid))
+	}
+}
+
+func TestIntrospectTokenEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{
+			"good-token": {"sub": "alice", "exp": float64(time.Now().Add(time.Hour).Unix())},
+		},
+	}
+	defineHandler := func(endpoint common.IntrospectEndpointConfig) AuthenticationHandler {
+		uut, err := defineAuthenticationHandler(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+			oidClient,
+			false,
+			nil,
+			common.AuthenticationConfig{
+				TargetClaims: common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+				APIKey: common.APIKeyAuthConfig{
+					Enabled: true, Header: "X-API-Key", Scheme: "ApiKey",
+				},
+				IntrospectEndpoint: endpoint,
+			},
+			common.AuthorizeRequestParamLocConfig{},
+			nil,
+			nil,
+			nil,
+			nil,
+			mgmtCore,
+		)
+		assert.Nil(err)
+		return uut
+	}
+	runIntrospect := func(
+		uut AuthenticationHandler, clientID, secret, token string,
+	) (*httptest.ResponseRecorder, RespIntrospectToken) {
+		body, err := json.Marshal(ReqIntrospectToken{Token: token})
+		assert.Nil(err)
+		req, err := http.NewRequest("POST", "/v1/token/introspect", bytes.NewReader(body))
+		assert.Nil(err)
+		if clientID != "" {
+			req.SetBasicAuth(clientID, secret)
+		}
+		respRecorder := httptest.NewRecorder()
+		uut.IntrospectTokenHandler().ServeHTTP(respRecorder, req)
+		var resp RespIntrospectToken
+		if respRecorder.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &resp))
+		}
+		return respRecorder, resp
+	}
+
+	// Case 0: the endpoint is not enabled
+	{
+		uut := defineHandler(common.IntrospectEndpointConfig{})
+		resp, _ := runIntrospect(uut, "backend", "secret", "good-token")
+		assert.Equal(http.StatusNotFound, resp.Code)
+	}
+
+	uut := defineHandler(common.IntrospectEndpointConfig{
+		Enabled: true,
+		Clients: []common.IntrospectClientConfig{{ID: "backend", Secret: "secret"}},
+	})
+
+	// Case 1: the caller must present known client credentials
+	for _, creds := range [][]string{{"", ""}, {"backend", "wrong"}, {"other", "secret"}} {
+		resp, _ := runIntrospect(uut, creds[0], creds[1], "good-token")
+		assert.Equal(http.StatusUnauthorized, resp.Code, creds)
+		assert.Contains(resp.Body.String(), string(ErrCodeClientInvalid), creds)
+		assert.NotEmpty(resp.Header().Get("WWW-Authenticate"), creds)
+	}
+
+	// Case 2: active token
+	{
+		resp, result := runIntrospect(uut, "backend", "secret", "good-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.True(result.Active)
+		assert.Equal("alice", result.User.UserID)
+		assert.NotZero(result.Expire)
+	}
+
+	// Case 3: token failed verification
+	{
+		resp, result := runIntrospect(uut, "backend", "secret", "forged-token")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.False(result.Active)
+		assert.Equal(ErrCodeTokenInvalid, result.Reason)
+	}
+
+	// Case 4: API key
+	serviceAccount := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: serviceAccount}, nil,
+	))
+	apiKey, info, err := mgmtCore.CreateAPIKey(context.Background(), serviceAccount, nil, nil)
+	assert.Nil(err)
+	{
+		resp, result := runIntrospect(uut, "backend", "secret", apiKey)
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.True(result.Active)
+		assert.Equal(serviceAccount, result.User.UserID)
+	}
+
+	// Case 5: revoked API key
+	assert.Nil(mgmtCore.RevokeAPIKey(context.Background(), serviceAccount, info.KeyID))
+	{
+		resp, result := runIntrospect(uut, "backend", "secret", apiKey)
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.False(result.Active)
+		assert.Equal(ErrCodeAPIKeyInvalid, result.Reason)
+	}
+}
+
+func TestAuthenticationReadiness(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	var probeErr error
+	watchdog := authenticate.DefineIssuerWatchdog(
+		"unit-test", func(context.Context) error { return probeErr }, nil,
+	)
+	startup := common.DefineReadinessGate()
+	startup.MarkReady()
+	uut := defineAuthenticationLivenessHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}}, startup, watchdog,
+	)
+
+	checkReady := func(expected int) {
+		req, err := http.NewRequest("GET", "/v1/ready", nil)
+		assert.Nil(err)
+		respRecorder := httptest.NewRecorder()
+		uut.ReadyHandler().ServeHTTP(respRecorder, req)
+		assert.Equal(expected, respRecorder.Code)
+	}
+
+	// Case 0: issuer healthy
+	assert.Nil(watchdog.CheckHealth(context.Background()))
+	checkReady(http.StatusOK)
+
+	// Case 1: issuer unreachable
+	probeErr = fmt.Errorf("dummy probe failure")
+	assert.NotNil(watchdog.CheckHealth(context.Background()))
+	checkReady(http.StatusInternalServerError)
+
+	// Case 2: issuer reachable again
+	probeErr = nil
+	assert.Nil(watchdog.CheckHealth(context.Background()))
+	checkReady(http.StatusOK)
+
+	// Case 3: issuer health does not gate readiness
+	probeErr = fmt.Errorf("dummy probe failure")
+	assert.NotNil(watchdog.CheckHealth(context.Background()))
+	uut = defineAuthenticationLivenessHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}}, startup, nil,
+	)
+	checkReady(http.StatusOK)
+}
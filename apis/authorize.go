@@ -2,8 +2,15 @@ package apis
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/common"
@@ -21,7 +28,64 @@ type AuthorizationHandler struct {
 	core           users.Management
 	checkHeaders   common.AuthorizeRequestParamLocConfig
 	forUnknown     common.UnknownUserActionConfig
+	respConfig     common.AuthorizeResponseConfig
 	requestMatcher match.RequestMatch
+	// autoAddObserver if provided, notified each time an unknown user is automatically recorded
+	autoAddObserver AutoAddedUserObserver
+	// toggles if provided, runtime feature toggles which override the static config
+	toggles common.FeatureToggles
+	// decisions if provided, memoizes the decisions for known users
+	decisions DecisionCache
+	// webSocket sets how WebSocket upgrade requests are detected
+	webSocket common.WebSocketConfig
+	// impersonation sets how privileged callers may be authorized as another user
+	impersonation common.ImpersonationConfig
+	// quotas if provided, enforces the per-user request quotas on allowed requests
+	quotas QuotaEnforcer
+	// failures if provided, answers denied requests in place of the JSON error
+	failures *failureResponder
+	// history if provided, records the authorization decisions
+	history DecisionHistory
+	// userIDs sets how the user IDs are normalized before they are looked up
+	userIDs common.UserIDNormalizationConfig
+	// decider decides the requests against the authorization rules and the user permissions
+	decider RequestDecider
+	// shedder if provided, sheds requests while the user database is slow or failing
+	shedder LoadShedder
+	// decisionMetrics if provided, records the authorization decisions
+	decisionMetrics DecisionMetricsHelper
+}
+
+// AuthorizationOptions are the optional subsystems and settings of the authorization server.
+// Each is disabled, or takes its default, when left as the zero value.
+type AuthorizationOptions struct {
+	// AutoAddObserver if provided, notified each time an unknown user is automatically recorded
+	AutoAddObserver AutoAddedUserObserver
+	// RouteMetrics if provided, route labelled metric collection agent
+	RouteMetrics RouteMetricsHelper
+	// DecisionMetrics if provided, records the authorization decisions and authentication
+	// outcomes
+	DecisionMetrics DecisionMetricsHelper
+	// Toggles if provided, runtime feature toggles which override the static config
+	Toggles common.FeatureToggles
+	// Decisions if provided, memoizes the decisions for known users
+	Decisions DecisionCache
+	// WebSocket sets how WebSocket upgrade requests are detected
+	WebSocket common.WebSocketConfig
+	// Impersonation sets how privileged callers may be authorized as another user
+	Impersonation common.ImpersonationConfig
+	// Quotas if provided, enforces the per-user request quotas on allowed requests
+	Quotas QuotaEnforcer
+	// FailureResponse sets how denied requests are answered
+	FailureResponse common.FailureResponseConfig
+	// History if provided, records the authorization decisions
+	History DecisionHistory
+	// UserIDs sets how the user IDs are normalized before they are looked up
+	UserIDs common.UserIDNormalizationConfig
+	// Bypass if provided, requests matching these rules skip the permission checks
+	Bypass *common.AuthnBypassConfig
+	// Shedder if provided, sheds requests while the user database is slow or failing
+	Shedder LoadShedder
 }
 
 // defineAuthorizationHandler define a new AuthorizationHandler instance
@@ -32,7 +96,9 @@ func defineAuthorizationHandler(
 	validateSupport common.CustomFieldValidator,
 	checkHeaders common.AuthorizeRequestParamLocConfig,
 	forUnknownUser common.UnknownUserActionConfig,
+	respConfig common.AuthorizeResponseConfig,
 	metrics goutils.HTTPRequestMetricHelper,
+	opts AuthorizationOptions,
 ) (AuthorizationHandler, error) {
 	validate := validator.New()
 	if err := validateSupport.RegisterWithValidator(validate); err != nil {
@@ -43,6 +109,18 @@ func defineAuthorizationHandler(
 		"module": "apis", "component": "api-handler", "instance": "authorization",
 	}
 
+	failures, err := defineFailureResponder(opts.FailureResponse)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed define failure responder")
+		return AuthorizationHandler{}, err
+	}
+
+	decider, err := DefineRequestDecider(core, matcher, opts.Bypass, opts.Shedder)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed define authorization bypass matcher")
+		return AuthorizationHandler{}, err
+	}
+
 	return AuthorizationHandler{
 		RestAPIHandler: goutils.RestAPIHandler{
 			Component: goutils.Component{
@@ -63,14 +141,378 @@ func defineAuthorizationHandler(
 			LogLevel:      logConfig.LogLevel,
 			MetricsHelper: metrics,
 		},
-		validate:       validate,
-		core:           core,
-		checkHeaders:   checkHeaders,
-		forUnknown:     forUnknownUser,
-		requestMatcher: matcher,
+		validate:        validate,
+		core:            core,
+		checkHeaders:    checkHeaders,
+		forUnknown:      forUnknownUser,
+		respConfig:      respConfig,
+		requestMatcher:  matcher,
+		autoAddObserver: opts.AutoAddObserver,
+		toggles:         opts.Toggles,
+		decisions:       opts.Decisions,
+		webSocket:       opts.WebSocket,
+		impersonation:   opts.Impersonation,
+		quotas:          opts.Quotas,
+		failures:        failures,
+		history:         opts.History,
+		userIDs:         opts.UserIDs,
+		decider:         decider,
+		shedder:         opts.Shedder,
+		decisionMetrics: opts.DecisionMetrics,
 	}, nil
 }
 
+// autoAddUnknownUser whether to automatically record unknown users
+func (h AuthorizationHandler) autoAddUnknownUser() bool {
+	if h.toggles != nil {
+		return h.toggles.CurrentState().AutoAddUser
+	}
+	return h.forUnknown.AutoAdd
+}
+
+// dryRun whether to only log denials, and allow all requests
+func (h AuthorizationHandler) dryRun() bool {
+	if h.toggles != nil {
+		return h.toggles.CurrentState().DryRun
+	}
+	return false
+}
+
+// RespDenied is the API response for a denied request, with details on why it was denied
+type RespDenied struct {
+	RespError
+	// Rule is the authorization rule the request matched. Its permissions are the permissions
+	// which would have allowed the request.
+	Rule *match.MatchedRule `json:"rule,omitempty"`
+}
+
+/*
+deniedResponse build the response for a denied request
+
+	@param ctxt context.Context - context calling this API
+	@param msg string - the error message
+	@param code ErrorCode - the error code
+	@param rule *match.MatchedRule - the authorization rule the request matched
+	@return the response
+*/
+func (h AuthorizationHandler) deniedResponse(
+	ctxt context.Context, msg string, code ErrorCode, rule *match.MatchedRule,
+) interface{} {
+	response := newErrorResponse(h.GetStdRESTErrorMsg(ctxt, http.StatusForbidden, msg, ""), code)
+	if !h.respConfig.IncludeDenyDetail || rule == nil {
+		return response
+	}
+	return RespDenied{RespError: response, Rule: rule}
+}
+
+/*
+decisionResponse build the response for an authorization decision of a known user
+
+	@param ctxt context.Context - context calling this API
+	@param logTags log.Fields - the log tags of the request
+	@param header http.Header - the response headers, to report the quota state in
+	@param params common.AccessAuthorizeParam - the request being authorized
+	@param decision Decision - the authorization decision
+	@return the response code, and the response
+*/
+func (h AuthorizationHandler) decisionResponse(
+	ctxt context.Context,
+	logTags log.Fields,
+	header http.Header,
+	params common.AccessAuthorizeParam,
+	decision Decision,
+) (int, interface{}) {
+	if decision.Allowed {
+		response := h.enforceQuota(ctxt, logTags, header, params.UserID, decision.Rule)
+		if response != nil {
+			return http.StatusTooManyRequests, response
+		}
+		return http.StatusOK, h.GetStdRESTSuccessMsg(ctxt)
+	}
+	msg := fmt.Sprintf("User ID %s not allow to '%s'", params.UserID, params.String())
+	log.WithFields(logTags).Errorf(msg)
+	return http.StatusForbidden, h.deniedResponse(
+		ctxt, msg, ErrCodePermissionDenied, decision.Rule,
+	)
+}
+
+/*
+enforceQuota count an allowed request against the user's request quotas, and report the
+state of the most restrictive quota in the response headers. The request is let through if
+the requests could not be counted.
+
+	@param ctxt context.Context - context calling this API
+	@param logTags log.Fields - the log tags of the request
+	@param header http.Header - the response headers, to report the quota state in
+	@param userID string - the ID of the user making the request
+	@param rule *match.MatchedRule - the authorization rule the request matched
+	@return the error response if the user exceeded a quota, otherwise nil
+*/
+func (h AuthorizationHandler) enforceQuota(
+	ctxt context.Context,
+	logTags log.Fields,
+	header http.Header,
+	userID string,
+	rule *match.MatchedRule,
+) interface{} {
+	if h.quotas == nil || userID == "" {
+		return nil
+	}
+	timestamp := time.Now().UTC()
+	state, err := h.quotas.Consume(ctxt, userID, rule, timestamp)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Unable to enforce quotas, allowing request")
+		return nil
+	}
+	if state == nil {
+		return nil
+	}
+	resetSec := int64(math.Ceil(state.Reset.Sub(timestamp).Seconds()))
+	header.Set("X-RateLimit-Limit", strconv.Itoa(state.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(state.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(resetSec, 10))
+	if !state.Exceeded {
+		return nil
+	}
+	header.Set("Retry-After", strconv.FormatInt(resetSec, 10))
+	msg := fmt.Sprintf("User ID %s exceeded quota %s", userID, state.Name)
+	log.WithFields(logTags).Errorf(msg)
+	return newErrorResponse(
+		h.GetStdRESTErrorMsg(ctxt, http.StatusTooManyRequests, msg, ""), ErrCodeQuotaExceeded,
+	)
+}
+
+// RespMinimalError is the API error response in the "minimal" response mode
+type RespMinimalError struct {
+	// Code is the machine-readable error code
+	Code ErrorCode `json:"code"`
+}
+
+/*
+responseMode the response mode to answer a request with. Checks dispatched by the Envoy
+"ext_authz" API are always answered in the standard mode, as the ext_authz server reads the
+decision from the standard response code.
+
+	@param r *http.Request - the request being answered
+	@return the response mode
+*/
+func (h AuthorizationHandler) responseMode(r *http.Request) string {
+	if dispatched, _ := r.Context().Value(extAuthzDispatchKey{}).(bool); dispatched {
+		return common.AuthorizeResponseModeStandard
+	}
+	return h.respConfig.Mode
+}
+
+/*
+writeMinimalResponse write the response in the "minimal" response mode. A successful
+response is sent as 204 with no body, while an error response only carries the error code.
+
+	@param w http.ResponseWriter - the response writer
+	@param respCode int - the response code
+	@param response interface{} - the standard response
+	@return whether successful
+*/
+func (h AuthorizationHandler) writeMinimalResponse(
+	w http.ResponseWriter, respCode int, response interface{},
+) error {
+	if respCode == http.StatusOK {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	minimal := RespMinimalError{Code: ErrCodeInternal}
+	switch errResp := response.(type) {
+	case RespError:
+		minimal.Code = errResp.Code
+	case RespDenied:
+		minimal.Code = errResp.Code
+	}
+	return h.WriteRESTResponse(w, respCode, minimal, nil)
+}
+
+// APIGatewayPolicyStatement is a statement of an API Gateway IAM policy document
+type APIGatewayPolicyStatement struct {
+	// Action is the action the statement governs
+	Action string `json:"Action"`
+	// Effect is either "Allow" or "Deny"
+	Effect string `json:"Effect"`
+	// Resource is the resource the statement governs
+	Resource string `json:"Resource"`
+}
+
+// APIGatewayPolicyDocument is an API Gateway IAM policy document
+type APIGatewayPolicyDocument struct {
+	// Version is the policy language version
+	Version string `json:"Version"`
+	// Statement is the policy statements
+	Statement []APIGatewayPolicyStatement `json:"Statement"`
+}
+
+// RespAPIGatewayPolicy is the API response in the "apigateway" response mode, in the format
+// of an API Gateway Lambda authorizer output
+type RespAPIGatewayPolicy struct {
+	// PrincipalID is the ID of the user making the request
+	PrincipalID string `json:"principalId"`
+	// PolicyDocument is the policy allowing or denying the request
+	PolicyDocument APIGatewayPolicyDocument `json:"policyDocument"`
+	// Context is additional information passed on with the decision
+	Context map[string]string `json:"context,omitempty"`
+}
+
+/*
+writeAPIGatewayResponse write the response in the "apigateway" response mode. Allowed and
+denied requests are both answered with 200, and a policy document with the decision. Other
+errors are answered with the standard response.
+
+	@param w http.ResponseWriter - the response writer
+	@param r *http.Request - the request being answered
+	@param respCode int - the response code
+	@param response interface{} - the standard response
+	@return whether successful
+*/
+func (h AuthorizationHandler) writeAPIGatewayResponse(
+	w http.ResponseWriter, r *http.Request, respCode int, response interface{},
+) error {
+	if respCode != http.StatusOK && respCode != http.StatusForbidden {
+		return h.WriteRESTResponse(w, respCode, response, nil)
+	}
+	params, _ := r.Context().Value(common.AccessAuthorizeParamKey{}).(common.AccessAuthorizeParam)
+	resource := "*"
+	if h.respConfig.PolicyResourceHeader != "" {
+		if methodARN := r.Header.Get(h.respConfig.PolicyResourceHeader); methodARN != "" {
+			resource = methodARN
+		}
+	}
+	statement := APIGatewayPolicyStatement{
+		Action: "execute-api:Invoke", Effect: "Allow", Resource: resource,
+	}
+	policy := RespAPIGatewayPolicy{
+		PrincipalID: params.UserID,
+		PolicyDocument: APIGatewayPolicyDocument{
+			Version: "2012-10-17", Statement: []APIGatewayPolicyStatement{statement},
+		},
+		Context: map[string]string{"userId": params.UserID},
+	}
+	if respCode == http.StatusForbidden {
+		policy.PolicyDocument.Statement[0].Effect = "Deny"
+		switch errResp := response.(type) {
+		case RespError:
+			policy.Context["code"] = string(errResp.Code)
+		case RespDenied:
+			policy.Context["code"] = string(errResp.Code)
+		}
+	}
+	return h.WriteRESTResponse(w, http.StatusOK, policy, nil)
+}
+
+// ReqAllow is the JSON body of an authorization request, for callers which are not request
+// proxies, and so do not forward the parameters through headers
+type ReqAllow struct {
+	// Host is the host / FQDN of the request being authorized
+	Host string `json:"host"`
+	// Path is the URI path of the request being authorized
+	Path string `json:"path"`
+	// Method is the HTTP method of the request being authorized
+	Method string `json:"method"`
+	// UserID is the ID of the user making the request. Not needed for rules allowing
+	// anonymous access.
+	UserID string `json:"user_id,omitempty"`
+	// Username is the optional username of the user making the request
+	Username string `json:"username,omitempty"`
+	// FirstName is the optional first name / given name of the user making the request
+	FirstName string `json:"first_name,omitempty"`
+	// LastName is the optional last name / surname / family name of the user making the request
+	LastName string `json:"last_name,omitempty"`
+	// Email is the optional email of the user making the request
+	Email string `json:"email,omitempty"`
+	// ClientID is the optional ID of the OAuth2 client making the request
+	ClientID string `json:"client_id,omitempty"`
+	// WebSocket whether the request is a WebSocket upgrade
+	WebSocket bool `json:"websocket,omitempty"`
+	// Attributes are the additional named attributes of the request
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Headers are the headers of the request, for the header conditions of the rules
+	Headers map[string]string `json:"headers,omitempty"`
+	// Scopes are the optional OAuth2 scopes of the token the request was made with
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// reqAllowKey is the context key of the ReqAllow body of an authorization request
+type reqAllowKey struct{}
+
+/*
+callerMetadata read the optional parameters describing the user making the request being
+authorized, from the JSON body if one was given, otherwise from the forwarded headers
+
+	@param r *http.Request - the authorization request
+	@return the username, email, first name, and last name
+*/
+func (h AuthorizationHandler) callerMetadata(r *http.Request) (string, string, string, string) {
+	if body, ok := r.Context().Value(reqAllowKey{}).(ReqAllow); ok {
+		return body.Username, body.Email, body.FirstName, body.LastName
+	}
+	return r.Header.Get(h.checkHeaders.Username),
+		r.Header.Get(h.checkHeaders.Email),
+		r.Header.Get(h.checkHeaders.FirstName),
+		r.Header.Get(h.checkHeaders.LastName)
+}
+
+/*
+conditionHeaders select the request headers the authorization rules have conditions on
+
+	@param forwarded http.Header - the headers forwarded by the proxy
+	@param given map[string]string - the headers given in the JSON body, by canonical header
+	name, which are used in place of the forwarded headers if set
+	@return the selected headers, or nil if the rules have no header conditions
+*/
+func (h AuthorizationHandler) conditionHeaders(
+	forwarded http.Header, given map[string]string,
+) map[string]string {
+	names := match.ConditionHeadersOf(h.requestMatcher)
+	if len(names) == 0 {
+		return nil
+	}
+	selected := map[string]string{}
+	for _, name := range names {
+		if given != nil {
+			if value, ok := given[name]; ok {
+				selected[name] = value
+			}
+		} else if values := forwarded.Values(name); len(values) > 0 {
+			selected[name] = values[0]
+		}
+	}
+	return selected
+}
+
+/*
+canonicalScopes render a set of OAuth2 scopes as a string, which is the same for the same set
+of scopes
+
+	@param scopes []string - the scopes
+	@return the sorted, space separated scopes
+*/
+func canonicalScopes(scopes []string) string {
+	sorted := append([]string{}, scopes...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, " ")
+}
+
+/*
+paramsInBody whether the parameters of the request to authorize are given as a JSON body. This
+is only the case for a JSON POST made to the API directly, i.e. without the forwarded path. A
+request proxy may forward the method and the content type of the original request.
+
+	@param r *http.Request - the request
+	@return whether to read the parameters from the body
+*/
+func (h AuthorizationHandler) paramsInBody(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.Header.Get(h.checkHeaders.Path) != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
 /*
 ParamReadMiddleware is a support middleware to be used with Mux to extract the mandatory
 parameters needed to authorize a REST API call and record it in the context.
@@ -80,11 +522,68 @@ parameters needed to authorize a REST API call and record it in the context.
 */
 func (h AuthorizationHandler) ParamReadMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		// Parameters given as a JSON body in place of the forwarded headers
+		if h.paramsInBody(r) {
+			var body ReqAllow
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				logTags := h.GetLogTagsForContext(r.Context())
+				msg := "authorization parameters not parsable"
+				log.WithError(err).WithFields(logTags).Error(msg)
+				response := newErrorResponse(
+					h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+					ErrCodeInvalidRequest,
+				)
+				if err := h.WriteRESTResponse(rw, http.StatusBadRequest, response, nil); err != nil {
+					log.WithError(err).WithFields(logTags).Error("Failed to form response")
+				}
+				return
+			}
+			params := common.AccessAuthorizeParam{
+				UserID:     h.userIDs.Normalize(body.UserID),
+				ClientID:   body.ClientID,
+				Method:     body.Method,
+				Path:       body.Path,
+				Host:       body.Host,
+				WebSocket:  body.WebSocket && h.webSocket.Enabled,
+				Attributes: body.Attributes,
+				Headers:    h.conditionHeaders(nil, common.CanonicalHeaders(body.Headers)),
+				Scopes:     body.Scopes,
+			}
+			// Only a GET can be upgraded
+			params.WebSocket = params.WebSocket && strings.EqualFold(params.Method, http.MethodGet)
+			ctxt := context.WithValue(r.Context(), common.AccessAuthorizeParamKey{}, params)
+			ctxt = context.WithValue(ctxt, reqAllowKey{}, body)
+			next(rw, r.WithContext(ctxt))
+			return
+		}
+
 		params := common.AccessAuthorizeParam{
-			UserID: r.Header.Get(h.checkHeaders.UserID),
-			Method: r.Header.Get(h.checkHeaders.Method),
-			Path:   r.Header.Get(h.checkHeaders.Path),
-			Host:   r.Header.Get(h.checkHeaders.Host),
+			UserID:   h.userIDs.Normalize(r.Header.Get(h.checkHeaders.UserID)),
+			ClientID: r.Header.Get(h.checkHeaders.ClientID),
+			Method:   r.Header.Get(h.checkHeaders.Method),
+			Path:     r.Header.Get(h.checkHeaders.Path),
+			Host:     r.Header.Get(h.checkHeaders.Host),
+		}
+		if len(h.checkHeaders.Attributes) > 0 {
+			params.Attributes = map[string]string{}
+			for attribute, header := range h.checkHeaders.Attributes {
+				if value := r.Header.Get(header); value != "" {
+					params.Attributes[attribute] = value
+				}
+			}
+		}
+		params.Headers = h.conditionHeaders(r.Header, nil)
+		if h.checkHeaders.Scopes != "" {
+			params.Scopes = strings.Fields(r.Header.Get(h.checkHeaders.Scopes))
+		}
+		// Only a GET can be upgraded. The upgrade header is set by the client, so a request of
+		// another method carrying it is authorized by its own method.
+		if h.webSocket.Enabled && strings.EqualFold(params.Method, http.MethodGet) &&
+			strings.EqualFold(r.Header.Get(h.webSocket.UpgradeHeader), "websocket") {
+			// The check is answered without reading the request body, and the connection is not
+			// kept alive, as an upgraded stream would never be drained.
+			params.WebSocket = true
+			rw.Header().Set("Connection", "close")
 		}
 		ctxt := context.WithValue(r.Context(), common.AccessAuthorizeParamKey{}, params)
 		next(rw, r.WithContext(ctxt))
@@ -100,28 +599,104 @@ func (h AuthorizationHandler) ParamReadMiddleware(next http.HandlerFunc) http.Ha
 // via HTTP headers by the entity using this endpoint. The parameters listed in this comment
 // section are the default headers the application will search for. But the headers to check
 // can be configured via the "authorize.request_param_location" object of the application config.
+// If the "authorize.response.mode" is "minimal", success is reported with 204 and no body, and
+// errors only carry the error code. If it is "apigateway", allowed and denied requests are both
+// reported with 200, and an API Gateway Lambda authorizer style policy document. If "authorize.response.includeDenyDetail" is set, denied
+// responses also report the matched rule, and the permissions which would have allowed the request.
+// Callers which are not request proxies may instead POST the parameters as a JSON body, with
+// "Content-Type: application/json", and without the forwarded path header.
+// If "authorize.quota" is enabled, allowed requests report the user's quota state through the
+// "X-RateLimit-*" headers, and requests exceeding a quota are rejected with 429.
 // @tags Authorize
+// @Accept json
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Param X-Forwarded-Host header string true "Host of the API call to authorize"
 // @Param X-Forwarded-Uri header string true "URI path of the API call to authorize"
 // @Param X-Forwarded-Method header string true "HTTP method of the API call to authorize"
-// @Param X-Caller-UserID header string true "ID of the user making the API call to authorize"
+// @Param X-Caller-UserID header string true "ID of the user making the API call to authorize. Not needed for rules allowing anonymous access."
 // @Param X-Caller-Username header string false "Username of the user making the API call to authorize"
 // @Param X-Caller-Firstname header string false "First name / given name of the user making the API call to authorize"
 // @Param X-Caller-Lastname header string false "Last name / surname / family name of the user making the API call to authorize"
 // @Param X-Caller-Email header string false "Email of the user making the API call to authorize"
+// @Param X-Caller-ClientID header string false "ID of the OAuth2 client making the API call to authorize"
+// @Param param body ReqAllow false "Parameters of the API call to authorize, in place of the headers, with POST"
+// @Param X-Impersonate-UserID header string false "If "authorize.impersonation" is enabled, ID of the user to authorize the API call as. The caller must have the impersonation permission."
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
-// @Failure 403 {object} goutils.RestAPIBaseResponse "error"
+// @Success 204 "success, in minimal response mode"
+// @Success 200 {object} RespAPIGatewayPolicy "decision, in apigateway response mode"
+// @Failure 400 {object} RespError "error"
+// @Failure 403 {object} RespDenied "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 429 {object} RespError "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/allow [get]
+// @Router /v1/allow [head]
+// @Router /v1/allow [post]
 func (h AuthorizationHandler) Allow(w http.ResponseWriter, r *http.Request) {
 	var respCode int
 	var response interface{}
+	// impersonator is the caller, when the request is authorized as another user
+	var impersonator string
+	// record is the record of the authorization decision, once the request is normalized
+	var record *models.DecisionRecord
+	// shed whether the request was shed, rather than decided
+	var shed bool
+	// decidedRule is the authorization rule the request matched, if any
+	var decidedRule *match.MatchedRule
+	// unknownUser whether the request was denied as the user is not known
+	var unknownUser bool
+	startTime := time.Now()
 	logTags := h.GetLogTagsForContext(r.Context())
 	defer func() {
+		if impersonator != "" {
+			log.WithFields(logTags).Infof(
+				"Impersonated request by %s concluded with %d", impersonator, respCode,
+			)
+		}
+		if h.decisionMetrics != nil && !shed {
+			if decision, ok := authorizeDecisionFor(respCode, response, unknownUser); ok {
+				host, pathPattern := ruleLabels(decidedRule)
+				h.decisionMetrics.RecordAuthorization(
+					host, pathPattern, decision, time.Since(startTime),
+				)
+			}
+		}
+		denied := !shed &&
+			(respCode == http.StatusForbidden || respCode == http.StatusTooManyRequests)
+		if record != nil && (denied || respCode == http.StatusOK) {
+			recordOutcome(record, response, !denied || h.dryRun(), time.Now().UTC())
+			h.history.Record(r.Context(), *record)
+		}
+		if denied && h.dryRun() {
+			log.WithFields(logTags).Warn("Dry-run mode, allowing request which would be denied")
+			respCode = http.StatusOK
+			response = h.GetStdRESTSuccessMsg(r.Context())
+		}
+		if respCode == http.StatusForbidden && h.failures != nil {
+			ctxtParams := r.Context().Value(common.AccessAuthorizeParamKey{})
+			params, _ := ctxtParams.(common.AccessAuthorizeParam)
+			written, err := h.failures.write(w, r, params.Host, params.Path, respCode, response)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Error("Failed to form failure response")
+			}
+			if written {
+				return
+			}
+		}
+		mode := h.responseMode(r)
+		if mode == common.AuthorizeResponseModeMinimal {
+			if err := h.writeMinimalResponse(w, respCode, response); err != nil {
+				log.WithError(err).WithFields(logTags).Error("Failed to form response")
+			}
+			return
+		}
+		if mode == common.AuthorizeResponseModeAPIGateway {
+			if err := h.writeAPIGatewayResponse(w, r, respCode, response); err != nil {
+				log.WithError(err).WithFields(logTags).Error("Failed to form response")
+			}
+			return
+		}
 		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
 			log.WithError(err).WithFields(logTags).Error("Failed to form response")
 		}
@@ -133,7 +708,10 @@ func (h AuthorizationHandler) Allow(w http.ResponseWriter, r *http.Request) {
 		err := fmt.Errorf("missing parameter regarding REST API call to authorize")
 		log.WithError(err).WithFields(logTags).Errorf(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -144,67 +722,208 @@ func (h AuthorizationHandler) Allow(w http.ResponseWriter, r *http.Request) {
 		err := fmt.Errorf("AuthorizationHandler.paramReadMiddleware() malfunction")
 		log.WithError(err).WithFields(logTags).Errorf(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			ErrCodeInternal,
+		)
 		return
 	}
-	if err := h.validate.Struct(&params); err != nil {
+
+	// Check bypass rules first
+	{
+		matched, err := h.decider.Bypassed(r.Context(), params)
+		if err != nil {
+			msg := "authz bypass check failed"
+			log.WithError(err).WithFields(logTags).Error(msg)
+			respCode = http.StatusBadRequest
+			response = newErrorResponse(
+				h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+				ErrCodeInvalidRequest,
+			)
+			return
+		}
+		// Bypass authorization
+		if matched {
+			log.WithFields(logTags).Debug("Request matches authorization bypass rules")
+			respCode = http.StatusOK
+			response = h.GetStdRESTSuccessMsg(r.Context())
+			return
+		}
+	}
+
+	// The user ID is checked after matching, as a rule allowing anonymous access does not
+	// require one
+	anonymous := params.UserID == ""
+	validateParams := func() error {
+		if anonymous {
+			return h.validate.StructExcept(&params, "UserID")
+		}
+		return h.validate.Struct(&params)
+	}
+	if err := validateParams(); err != nil {
 		msg := "Manditory parameters for REST request to authorize not valid"
 		log.WithError(err).WithFields(logTags).Errorf(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
+	// Authorize the request as the impersonated user, if the caller is allowed to
+	if h.impersonation.Enabled && !anonymous {
+		if target := h.userIDs.Normalize(r.Header.Get(h.impersonation.Header)); target != "" {
+			code, errCode, err := h.checkImpersonation(r.Context(), params.UserID, target)
+			if err != nil {
+				msg := fmt.Sprintf("User ID %s can't impersonate user ID %s", params.UserID, target)
+				log.WithError(err).WithFields(logTags).Errorf(msg)
+				respCode = code
+				response = newErrorResponse(
+					h.GetStdRESTErrorMsg(r.Context(), code, msg, err.Error()), errCode,
+				)
+				return
+			}
+			impersonator = params.UserID
+			params.UserID = target
+			logTags["auth_user_id"] = target
+			logTags["auth_impersonator_id"] = impersonator
+			log.WithFields(logTags).Infof(
+				"User ID %s impersonating user ID %s", impersonator, target,
+			)
+		}
+	}
+
 	// Get the absolute path of the request
 	reqAbsPath, err := match.GetAbsPath(params.Path)
 	if err != nil {
 		msg := "Request path normalization failed"
 		log.WithError(err).WithFields(logTags).Errorf(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
 	logTags["auth_abs_path"] = reqAbsPath
+	if h.history != nil {
+		record = &models.DecisionRecord{
+			UserID:       params.UserID,
+			Impersonator: impersonator,
+			Host:         params.Host,
+			Path:         reqAbsPath,
+			Method:       params.Method,
+		}
+	}
+
+	// Reuse the memoized decision if available
+	decisionKey := DecisionKey{
+		UserID:     params.UserID,
+		ClientID:   params.ClientID,
+		Host:       params.Host,
+		Path:       reqAbsPath,
+		Method:     params.Method,
+		WebSocket:  params.WebSocket,
+		Attributes: common.CanonicalAttributes(params.Attributes),
+		Headers:    common.CanonicalAttributes(params.Headers),
+		Scopes:     canonicalScopes(params.Scopes),
+	}
+	if h.decisions != nil && !common.CacheBypassRequested(r.Context()) {
+		if decision, ok := h.decisions.Lookup(r.Context(), decisionKey, time.Now().UTC()); ok {
+			decidedRule = decision.Rule
+			if record != nil {
+				recordMatchedRule(record, decision.Rule)
+			}
+			respCode, response = h.decisionResponse(
+				r.Context(), logTags, w.Header(), params, decision,
+			)
+			return
+		}
+	}
 
-	// Determine the accepted permissions to trigger the REST API with method
-	allowedPermissions, err := h.requestMatcher.Match(r.Context(), match.RequestParam{
-		Host: &params.Host, Path: reqAbsPath, Method: params.Method,
-	})
+	// Decide the request against the authorization rules, and the user's permissions
+	decision, err := h.decider.Decide(r.Context(), params, reqAbsPath)
 	if err != nil {
+		msg := fmt.Sprintf("Unable to check the rule conditions of user ID %s", params.UserID)
+		log.WithError(err).WithFields(logTags).Errorf(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+		return
+	}
+	if decision.Outcome == OutcomeNoMatchingRule {
 		msg := fmt.Sprintf(
 			"Unable to find match for '%s' against defined API authorizations", params.String(),
 		)
-		log.WithError(err).WithFields(logTags).Errorf(msg)
+		log.WithFields(logTags).Errorf("%s: %s", msg, decision.Reason)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, decision.Reason),
+			ErrCodeNoMatchingRule,
+		)
 		return
 	}
-
-	// Check whether the user is allowed to trigger the REST API with method
-	allowed, err := h.core.DoesUserHavePermission(r.Context(), params.UserID, allowedPermissions)
-	if err == nil {
-		// User is known
-		if allowed {
-			respCode = http.StatusOK
-			response = h.GetStdRESTSuccessMsg(r.Context())
-		} else {
-			msg := fmt.Sprintf("User ID %s not allow to '%s'", params.UserID, params.String())
-			log.WithFields(logTags).Errorf(msg)
-			respCode = http.StatusForbidden
-			response = h.GetStdRESTErrorMsg(r.Context(), http.StatusForbidden, msg, "")
+	matchedRule := decision.Rule
+	decidedRule = matchedRule
+	if record != nil {
+		recordMatchedRule(record, matchedRule)
+	}
+	switch decision.Outcome {
+	case OutcomeInsufficientScope:
+		log.WithFields(logTags).Errorf(decision.Reason)
+		respCode = http.StatusForbidden
+		response = h.deniedResponse(
+			r.Context(), decision.Reason, ErrCodeInsufficientScope, matchedRule,
+		)
+	case OutcomeUserRequired:
+		msg := "Manditory parameters for REST request to authorize not valid"
+		log.WithFields(logTags).Errorf("%s: %s", msg, decision.Reason)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, decision.Reason),
+			ErrCodeInvalidRequest,
+		)
+	case OutcomeShedFailOpen:
+		log.WithFields(logTags).Warn(decision.Reason)
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
+	case OutcomeShed:
+		log.WithFields(logTags).Warn(decision.Reason)
+		shed = true
+		respCode = h.shedder.StatusCode()
+		w.Header().Set(
+			"Retry-After", strconv.FormatInt(h.shedder.RetryAfter(time.Now().UTC()), 10),
+		)
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), respCode, decision.Reason, ""), ErrCodeOverloaded,
+		)
+	case OutcomeAllowed, OutcomeDenied:
+		log.WithFields(logTags).Debug(decision.Reason)
+		// Only the decisions on the user's permissions are worth memoizing
+		result := Decision{Allowed: decision.Outcome == OutcomeAllowed, Rule: matchedRule}
+		if decision.ByUser && h.decisions != nil {
+			h.decisions.Record(r.Context(), decisionKey, result, time.Now().UTC())
 		}
-	} else {
-		// This user is not known
-		if h.forUnknown.AutoAdd {
+		respCode, response = h.decisionResponse(r.Context(), logTags, w.Header(), params, result)
+	case OutcomeUnknownUser:
+		// This user is not known. An impersonated user is never recorded, as the optional
+		// parameters describe the caller.
+		if h.autoAddUnknownUser() && impersonator == "" {
 			// Automatically register the user with the system
 			// Fetch the optional parameters regarding REST request to authorize
-			username := r.Header.Get(h.checkHeaders.Username)
-			userEmail := r.Header.Get(h.checkHeaders.Email)
-			firstName := r.Header.Get(h.checkHeaders.FirstName)
-			lastName := r.Header.Get(h.checkHeaders.LastName)
+			username, userEmail, firstName, lastName := h.callerMetadata(r)
 			// Define the new user
 			newUserParams := models.UserConfig{UserID: params.UserID}
+			// A client credentials token acts as the client itself, so the user is the client's
+			// service account
+			if params.ClientID != "" && params.ClientID == params.UserID &&
+				h.validate.Var(params.UserID, "service_account_id") == nil {
+				newUserParams.ServiceAccount = true
+			}
 			if username != "" {
 				newUserParams.Username = &username
 			}
@@ -222,25 +941,70 @@ func (h AuthorizationHandler) Allow(w http.ResponseWriter, r *http.Request) {
 				msg := fmt.Sprintf("Failed to record user ID %s", params.UserID)
 				log.WithError(err).WithFields(logTags).Errorf(msg)
 				respCode = http.StatusInternalServerError
-				response = h.GetStdRESTErrorMsg(
-					r.Context(), http.StatusInternalServerError, msg, err.Error(),
+				response = newErrorResponse(
+					h.GetStdRESTErrorMsg(
+						r.Context(), http.StatusInternalServerError, msg, err.Error(),
+					),
+					errorCodeFor(err, ErrCodeInternal),
 				)
 			} else {
 				msg := fmt.Sprintf("Recorded new user ID %s with no permissions", params.UserID)
 				log.WithFields(logTags).Errorf(msg)
+				if h.autoAddObserver != nil {
+					h.autoAddObserver.UserAutoAdded(r.Context(), AutoAddedUserEvent{
+						UserID:    params.UserID,
+						Username:  username,
+						Host:      params.Host,
+						Method:    params.Method,
+						Path:      params.Path,
+						Timestamp: time.Now().UTC(),
+					})
+				}
+				unknownUser = true
 				respCode = http.StatusForbidden
-				response = h.GetStdRESTErrorMsg(r.Context(), http.StatusForbidden, msg, "")
+				response = h.deniedResponse(r.Context(), msg, ErrCodePermissionDenied, matchedRule)
 			}
 		} else {
 			// User must be manually registered with the system
 			msg := fmt.Sprintf("User ID %s is unknown", params.UserID)
 			log.WithFields(logTags).Errorf(msg)
 			respCode = http.StatusForbidden
-			response = h.GetStdRESTErrorMsg(r.Context(), http.StatusForbidden, msg, "")
+			response = h.deniedResponse(r.Context(), msg, ErrCodeUserNotFound, matchedRule)
 		}
 	}
 }
 
+/*
+checkImpersonation verify the caller is allowed to impersonate another user
+
+	@param ctxt context.Context - context calling this API
+	@param caller string - the caller's user ID
+	@param target string - the ID of the user to impersonate
+	@return if not allowed, the response code, the error code, and the reason
+*/
+func (h AuthorizationHandler) checkImpersonation(
+	ctxt context.Context, caller, target string,
+) (int, ErrorCode, error) {
+	if err := h.validate.Var(target, "user_id"); err != nil {
+		return http.StatusBadRequest, ErrCodeInvalidRequest, err
+	}
+	if caller == target {
+		return http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("can't impersonate self")
+	}
+	allowed, err := h.core.DoesUserHavePermission(
+		ctxt, caller, []string{h.impersonation.Permission},
+	)
+	if err != nil {
+		return http.StatusForbidden, errorCodeFor(err, ErrCodePermissionDenied), err
+	}
+	if !allowed {
+		return http.StatusForbidden, ErrCodePermissionDenied, fmt.Errorf(
+			"missing permission %s", h.impersonation.Permission,
+		)
+	}
+	return 0, "", nil
+}
+
 // AllowHandler Wrapper around Allow
 func (h AuthorizationHandler) AllowHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -254,11 +1018,12 @@ func (h AuthorizationHandler) AllowHandler() http.HandlerFunc {
 // AuthorizationLivenessHandler the request authorization REST API liveness handler
 type AuthorizationLivenessHandler struct {
 	goutils.RestAPIHandler
-	core users.Management
+	core    users.Management
+	startup common.ReadinessGate
 }
 
 func defineAuthorizationLivenessHandler(
-	logConfig common.HTTPRequestLogging, core users.Management,
+	logConfig common.HTTPRequestLogging, core users.Management, startup common.ReadinessGate,
 ) AuthorizationLivenessHandler {
 	logTags := log.Fields{
 		"module": "apis", "component": "api-handler", "instance": "authorization-liveness",
@@ -282,7 +1047,7 @@ func defineAuthorizationLivenessHandler(
 				return result
 			}(),
 			LogLevel: logConfig.HealthLogLevel,
-		}, core: core,
+		}, core: core, startup: startup,
 	}
 }
 
@@ -293,9 +1058,9 @@ func defineAuthorizationLivenessHandler(
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/alive [get]
 func (h AuthorizationLivenessHandler) Alive(w http.ResponseWriter, r *http.Request) {
 	logTags := h.GetLogTagsForContext(r.Context())
@@ -322,9 +1087,9 @@ func (h AuthorizationLivenessHandler) AliveHandler() http.HandlerFunc {
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/ready [get]
 func (h AuthorizationLivenessHandler) Ready(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -335,10 +1100,21 @@ func (h AuthorizationLivenessHandler) Ready(w http.ResponseWriter, r *http.Reque
 			log.WithError(err).WithFields(logTags).Error("Failed to form response")
 		}
 	}()
-	if err := h.core.Ready(); err != nil {
+	if err := h.startup.Ready(); err != nil {
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(
+				r.Context(), http.StatusInternalServerError, "not ready", err.Error(),
+			),
+			ErrCodeNotReady,
+		)
+	} else if err := h.core.Ready(); err != nil {
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(
-			r.Context(), http.StatusInternalServerError, "not ready", err.Error(),
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(
+				r.Context(), http.StatusInternalServerError, "not ready", err.Error(),
+			),
+			ErrCodeNotReady,
 		)
 	} else {
 		respCode = http.StatusOK
@@ -1,12 +1,15 @@
 package apis
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/alwitt/padlock/common"
 	"github.com/alwitt/padlock/match"
@@ -38,7 +41,7 @@ func TestAuthorization(t *testing.T) {
 	assert.Nil(err)
 	assert.Nil(dbClient.Ready())
 
-	mgmtCore, err := users.CreateManagement(dbClient)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
 	assert.Nil(err)
 	assert.Nil(mgmtCore.Ready())
 
@@ -141,14 +144,34 @@ func TestAuthorization(t *testing.T) {
 		supportMatch,
 		authRequestParamLoc,
 		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
 		nil,
+		AuthorizationOptions{},
 	)
 	assert.Nil(err)
+	startup := common.DefineReadinessGate()
 	livness := defineAuthorizationLivenessHandler(
 		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
 		mgmtCore,
+		startup,
 	)
 
+	// Case 0: not ready until startup completes
+	{
+		rid := uuid.New().String()
+		req, err := http.NewRequest("GET", "/v1/ready", nil)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := livness.LoggingMiddleware(livness.ReadyHandler())
+		handler.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusInternalServerError, respRecorder.Code)
+		checkHeader(respRecorder, rid, 1)
+	}
+	startup.MarkReady()
+
 	// Case 0: verify ready
 	{
 		rid := uuid.New().String()
@@ -269,6 +292,7 @@ func TestAuthorization(t *testing.T) {
 	// --------------------------------------------------------------------------
 	// Then test with auto add
 
+	autoAdded := &recordingAutoAddObserver{}
 	uut, err = defineAuthorizationHandler(
 		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
 		mgmtCore,
@@ -276,7 +300,11 @@ func TestAuthorization(t *testing.T) {
 		supportMatch,
 		authRequestParamLoc,
 		common.UnknownUserActionConfig{AutoAdd: true},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
 		nil,
+		AuthorizationOptions{
+			AutoAddObserver: autoAdded,
+		},
 	)
 	assert.Nil(err)
 
@@ -303,6 +331,9 @@ func TestAuthorization(t *testing.T) {
 		}
 		checkParam.status = http.StatusForbidden
 		executeTest(checkParam)
+		assert.Len(autoAdded.events, 1)
+		assert.Equal(user6, autoAdded.events[0].UserID)
+		assert.Equal(testHost1, autoAdded.events[0].Host)
 	}
 
 	// Case 7: give new user permissions
@@ -319,6 +350,15 @@ func TestAuthorization(t *testing.T) {
 	}
 }
 
+// recordingAutoAddObserver records the auto-added user events
+type recordingAutoAddObserver struct {
+	events []AutoAddedUserEvent
+}
+
+func (o *recordingAutoAddObserver) UserAutoAdded(ctxt context.Context, event AutoAddedUserEvent) {
+	o.events = append(o.events, event)
+}
+
 func TestRelativePathAuthorization(t *testing.T) {
 	assert := assert.New(t)
 	log.SetLevel(log.DebugLevel)
@@ -337,7 +377,7 @@ func TestRelativePathAuthorization(t *testing.T) {
 	assert.Nil(err)
 	assert.Nil(dbClient.Ready())
 
-	mgmtCore, err := users.CreateManagement(dbClient)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
 	assert.Nil(err)
 	assert.Nil(mgmtCore.Ready())
 
@@ -417,7 +457,9 @@ func TestRelativePathAuthorization(t *testing.T) {
 		supportMatch,
 		authRequestParamLoc,
 		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
 		nil,
+		AuthorizationOptions{},
 	)
 	assert.Nil(err)
 
@@ -503,4 +545,1464 @@ func TestRelativePathAuthorization(t *testing.T) {
 	for _, oneTest := range testCases {
 		executeTest(oneTest)
 	}
+
+	// --------------------------------------------------------------------------
+	// Then test with dry-run toggled on at runtime
+
+	toggles := common.DefineFeatureToggles(common.FeatureToggleState{}, nil)
+	uut, err = defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		authRequestParamLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Toggles: toggles,
+		},
+	)
+	assert.Nil(err)
+
+	executeTest(testCase{
+		host:   testHost,
+		path:   "/admin",
+		method: "GET", userID: basicUser,
+		status: http.StatusForbidden,
+	})
+	dryRun := true
+	toggles.UpdateState(common.FeatureToggleUpdate{DryRun: &dryRun})
+	executeTest(testCase{
+		host:   testHost,
+		path:   "/admin",
+		method: "GET", userID: basicUser,
+		status: http.StatusOK,
+	})
+	dryRun = false
+	toggles.UpdateState(common.FeatureToggleUpdate{DryRun: &dryRun})
+
+	// Then test auto add toggled on at runtime
+	newUser := uuid.NewString()
+	executeTest(testCase{
+		host:   testHost,
+		path:   "/user",
+		method: "GET", userID: newUser,
+		status: http.StatusForbidden,
+	})
+	_, err = mgmtCore.GetUser(context.Background(), newUser)
+	assert.NotNil(err)
+	autoAdd := true
+	toggles.UpdateState(common.FeatureToggleUpdate{AutoAddUser: &autoAdd})
+	executeTest(testCase{
+		host:   testHost,
+		path:   "/user",
+		method: "GET", userID: newUser,
+		status: http.StatusForbidden,
+	})
+	_, err = mgmtCore.GetUser(context.Background(), newUser)
+	assert.Nil(err)
+
+	// Test the minimal response mode
+	uut, err = defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		authRequestParamLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeMinimal},
+		nil,
+		AuthorizationOptions{},
+	)
+	assert.Nil(err)
+	{
+		runMinimal := func(path string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest("GET", "/v1/allow", nil)
+			assert.Nil(err)
+			req.Header.Add(authRequestParamLoc.Host, testHost)
+			req.Header.Add(authRequestParamLoc.Path, path)
+			req.Header.Add(authRequestParamLoc.Method, "GET")
+			req.Header.Add(authRequestParamLoc.UserID, basicUser)
+			respRecorder := httptest.NewRecorder()
+			handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+			handler.ServeHTTP(respRecorder, req)
+			return respRecorder
+		}
+
+		respRecorder := runMinimal("/user")
+		assert.Equal(http.StatusNoContent, respRecorder.Code)
+		assert.Equal(0, respRecorder.Body.Len())
+
+		respRecorder = runMinimal("/admin")
+		assert.Equal(http.StatusForbidden, respRecorder.Code)
+		var msg map[string]interface{}
+		assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &msg))
+		assert.EqualValues(map[string]interface{}{"code": string(ErrCodePermissionDenied)}, msg)
+	}
+
+	// Test the apigateway response mode
+	uut, err = defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		authRequestParamLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{
+			Mode: common.AuthorizeResponseModeAPIGateway, PolicyResourceHeader: "X-Method-Arn",
+		},
+		nil,
+		AuthorizationOptions{},
+	)
+	assert.Nil(err)
+	{
+		methodARN := "arn:aws:execute-api:us-east-1:123456789012:api-id/prod/GET/user"
+		runAPIGateway := func(path string, withARN bool) RespAPIGatewayPolicy {
+			req, err := http.NewRequest("GET", "/v1/allow", nil)
+			assert.Nil(err)
+			req.Header.Add(authRequestParamLoc.Host, testHost)
+			req.Header.Add(authRequestParamLoc.Path, path)
+			req.Header.Add(authRequestParamLoc.Method, "GET")
+			req.Header.Add(authRequestParamLoc.UserID, basicUser)
+			if withARN {
+				req.Header.Add("X-Method-Arn", methodARN)
+			}
+			respRecorder := httptest.NewRecorder()
+			handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+			handler.ServeHTTP(respRecorder, req)
+			assert.Equal(http.StatusOK, respRecorder.Code)
+			var policy RespAPIGatewayPolicy
+			assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &policy))
+			return policy
+		}
+
+		policy := runAPIGateway("/user", true)
+		assert.Equal(basicUser, policy.PrincipalID)
+		assert.Equal("2012-10-17", policy.PolicyDocument.Version)
+		assert.Equal(
+			[]APIGatewayPolicyStatement{
+				{Action: "execute-api:Invoke", Effect: "Allow", Resource: methodARN},
+			},
+			policy.PolicyDocument.Statement,
+		)
+		assert.Equal(basicUser, policy.Context["userId"])
+
+		policy = runAPIGateway("/admin", false)
+		assert.Equal(basicUser, policy.PrincipalID)
+		assert.Equal(
+			[]APIGatewayPolicyStatement{
+				{Action: "execute-api:Invoke", Effect: "Deny", Resource: "*"},
+			},
+			policy.PolicyDocument.Statement,
+		)
+		assert.Equal(string(ErrCodePermissionDenied), policy.Context["code"])
+	}
+
+	// Test including the deny detail
+	uut, err = defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		authRequestParamLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{
+			Mode: common.AuthorizeResponseModeStandard, IncludeDenyDetail: true,
+		},
+		nil,
+		AuthorizationOptions{},
+	)
+	assert.Nil(err)
+	{
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(authRequestParamLoc.Host, testHost)
+		req.Header.Add(authRequestParamLoc.Path, "/admin")
+		req.Header.Add(authRequestParamLoc.Method, "GET")
+		req.Header.Add(authRequestParamLoc.UserID, basicUser)
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusForbidden, respRecorder.Code)
+		var msg RespDenied
+		assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &msg))
+		assert.Equal(ErrCodePermissionDenied, msg.Code)
+		assert.NotNil(msg.Rule)
+		assert.EqualValues(
+			match.MatchedRule{
+				Host:        testHost,
+				PathPattern: `^/admin`,
+				Method:      "GET",
+				Permissions: []string{permissions[0]},
+			},
+			*msg.Rule,
+		)
+	}
+}
+
+func TestWebSocketUpgradeAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader":   {AssignedPermissions: []string{"read"}},
+		"streamer": {AssignedPermissions: []string{"read", "stream"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern: `^/events$`,
+						PermissionsForMethod: map[string][]string{
+							"GET": {"read"}, match.WebSocketMethod: {"stream"},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Decisions: DefineDecisionCache(time.Minute, 0),
+			WebSocket: common.WebSocketConfig{Enabled: true, UpgradeHeader: "X-Forwarded-Upgrade"},
+		},
+	)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	streamer := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: streamer}, []string{"streamer"},
+	))
+
+	checkAllow := func(userID, method string, upgrade bool) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/events")
+		if method != "" {
+			req.Header.Add(paramLoc.Method, method)
+		}
+		req.Header.Add(paramLoc.UserID, userID)
+		if upgrade {
+			req.Header.Add("X-Forwarded-Upgrade", "WebSocket")
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: plain GET
+	assert.Equal(http.StatusOK, checkAllow(reader, "GET", false).Code)
+	assert.Equal(http.StatusOK, checkAllow(streamer, "GET", false).Code)
+
+	// Case 1: upgrade requires the distinct permission
+	{
+		resp := checkAllow(reader, "GET", true)
+		assert.Equal(http.StatusForbidden, resp.Code)
+		assert.Equal("close", resp.Header().Get("Connection"))
+		assert.Equal(http.StatusOK, checkAllow(streamer, "GET", true).Code)
+	}
+
+	// Case 2: only a GET is an upgrade, other methods are authorized by their own rules
+	assert.Equal(http.StatusBadRequest, checkAllow(streamer, "", true).Code)
+	for _, method := range []string{"DELETE", "POST"} {
+		resp := checkAllow(streamer, method, true)
+		assert.Equal(http.StatusForbidden, resp.Code, method)
+		assert.Empty(resp.Header().Get("Connection"), method)
+	}
+}
+
+func TestAnonymousAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"writer": {AssignedPermissions: []string{"write"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern: `^/docs$`,
+						PermissionsForMethod: map[string][]string{
+							"GET": {common.AnonymousPermission}, "POST": {"write"},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Decisions: DefineDecisionCache(time.Minute, 0),
+		},
+	)
+	assert.Nil(err)
+
+	writer := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: writer}, []string{"writer"},
+	))
+
+	checkAllow := func(userID, method string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/docs")
+		req.Header.Add(paramLoc.Method, method)
+		if userID != "" {
+			req.Header.Add(paramLoc.UserID, userID)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: anonymous request to a public endpoint
+	assert.Equal(http.StatusOK, checkAllow("", "GET").Code)
+
+	// Case 1: known and unknown users can also use the public endpoint
+	assert.Equal(http.StatusOK, checkAllow(writer, "GET").Code)
+	assert.Equal(http.StatusOK, checkAllow(uuid.New().String(), "GET").Code)
+
+	// Case 2: anonymous request to a protected endpoint
+	assert.Equal(http.StatusBadRequest, checkAllow("", "POST").Code)
+	assert.Equal(http.StatusOK, checkAllow(writer, "POST").Code)
+}
+
+func TestAuthorizationBypass(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"writer": {AssignedPermissions: []string{"write"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/orders$`,
+						PermissionsForMethod: map[string][]string{"*": {"write"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Bypass: &common.AuthnBypassConfig{
+				Rules: []common.AuthnBypassMatchEntry{
+					{MatchType: "method", Matches: []string{"OPTIONS"}},
+					{MatchType: "path", Matches: []string{"^/healthz$"}},
+				},
+			},
+		},
+	)
+	assert.Nil(err)
+
+	checkAllow := func(userID, method, path string) int {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, path)
+		req.Header.Add(paramLoc.Method, method)
+		if userID != "" {
+			req.Header.Add(paramLoc.UserID, userID)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder.Code
+	}
+
+	// Case 0: preflight requests skip the permission checks
+	assert.Equal(http.StatusOK, checkAllow("", "OPTIONS", "/orders"))
+	assert.Equal(http.StatusOK, checkAllow(uuid.New().String(), "OPTIONS", "/orders"))
+
+	// Case 1: health checks skip the permission checks, even without a matching rule
+	assert.Equal(http.StatusOK, checkAllow("", "GET", "/healthz"))
+
+	// Case 2: other requests are checked
+	assert.Equal(http.StatusBadRequest, checkAllow("", "GET", "/orders"))
+	assert.Equal(http.StatusForbidden, checkAllow(uuid.New().String(), "GET", "/orders"))
+	assert.Equal(http.StatusForbidden, checkAllow(uuid.New().String(), "GET", "/healthz/deep"))
+}
+
+func TestClientAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"writer": {AssignedPermissions: []string{"write"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern: `^/reports$`,
+						PermissionsForMethod: map[string][]string{
+							"GET": {"write"}, "POST": {"write"},
+						},
+						ClientsForMethod: map[string][]string{"GET": {"report-service"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:     "X-Forwarded-Host",
+		Path:     "X-Forwarded-Uri",
+		Method:   "X-Forwarded-Method",
+		UserID:   "X-Caller-UserID",
+		ClientID: "X-Caller-ClientID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: true},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Decisions: DefineDecisionCache(time.Minute, 0),
+		},
+	)
+	assert.Nil(err)
+
+	checkAllow := func(userID, clientID, method string) int {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/reports")
+		req.Header.Add(paramLoc.Method, method)
+		if userID != "" {
+			req.Header.Add(paramLoc.UserID, userID)
+		}
+		if clientID != "" {
+			req.Header.Add(paramLoc.ClientID, clientID)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder.Code
+	}
+
+	serviceAccount := uuid.New().String()
+
+	// Case 0: the granted client is allowed without a user entry
+	assert.Equal(http.StatusOK, checkAllow(serviceAccount, "report-service", "GET"))
+	assert.Equal(http.StatusOK, checkAllow("", "report-service", "GET"))
+	{
+		_, err := mgmtCore.GetUser(context.Background(), serviceAccount)
+		assert.NotNil(err)
+	}
+
+	// Case 1: the client is only granted the listed method
+	assert.Equal(http.StatusForbidden, checkAllow(serviceAccount, "report-service", "POST"))
+
+	// Case 2: other clients go through the user permission check
+	assert.Equal(http.StatusForbidden, checkAllow(serviceAccount, "other-service", "GET"))
+	assert.Equal(http.StatusBadRequest, checkAllow("", "other-service", "GET"))
+	assert.Nil(mgmtCore.SetUserRoles(context.Background(), serviceAccount, []string{"writer"}))
+	assert.Equal(http.StatusOK, checkAllow(serviceAccount, "", "GET"))
+}
+
+func TestUserAttributeAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern: `^/ledger$`,
+						PermissionsForMethod: map[string][]string{
+							"GET": {"read"}, "POST": {"read"},
+						},
+						UserAttributesForMethod: map[string]map[string]string{
+							"GET": {"department": "^finance$"},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{},
+	)
+	assert.Nil(err)
+
+	checkAllow := func(userID, method string) int {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/ledger")
+		req.Header.Add(paramLoc.Method, method)
+		req.Header.Add(paramLoc.UserID, userID)
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder.Code
+	}
+
+	testUser := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: testUser}, []string{"reader"},
+	))
+
+	// Case 0: a user without the attribute is denied
+	assert.Equal(http.StatusForbidden, checkAllow(testUser, "GET"))
+	// Methods without attribute conditions only need the permission
+	assert.Equal(http.StatusOK, checkAllow(testUser, "POST"))
+
+	// Case 1: a user with a different attribute value is denied
+	assert.Nil(mgmtCore.UpdateUser(context.Background(), testUser, models.UserConfig{
+		UserID: testUser, Attributes: map[string]string{"department": "sales"},
+	}))
+	assert.Equal(http.StatusForbidden, checkAllow(testUser, "GET"))
+
+	// Case 2: a user with the matching attribute is allowed
+	assert.Nil(mgmtCore.UpdateUser(context.Background(), testUser, models.UserConfig{
+		UserID: testUser, Attributes: map[string]string{"department": "finance"},
+	}))
+	assert.Equal(http.StatusOK, checkAllow(testUser, "GET"))
+
+	// Case 3: the attribute does not replace the permission
+	assert.Nil(mgmtCore.SetUserRoles(context.Background(), testUser, []string{}))
+	assert.Equal(http.StatusForbidden, checkAllow(testUser, "GET"))
+}
+
+func TestServiceAccountAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidatorWithServiceAccounts(
+		`^[a-zA-Z0-9-]+$`,
+		`^[a-zA-Z0-9-]+$`,
+		`^[a-zA-Z0-9-]+$`,
+		`^[a-zA-Z0-9-]+$`,
+		`^.+$`,
+		`^svc-[a-zA-Z0-9-]+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern: `^/batch$`,
+						PermissionsForMethod: map[string][]string{
+							"GET": {"read"}, "POST": {"read"},
+						},
+						ServiceAccountsOnlyForMethod: map[string]bool{"POST": true},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:     "X-Forwarded-Host",
+		Path:     "X-Forwarded-Uri",
+		Method:   "X-Forwarded-Method",
+		UserID:   "X-Caller-UserID",
+		ClientID: "X-Caller-ClientID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: true},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{},
+	)
+	assert.Nil(err)
+
+	checkAllow := func(userID, clientID, method string) int {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/batch")
+		req.Header.Add(paramLoc.Method, method)
+		req.Header.Add(paramLoc.UserID, userID)
+		if clientID != "" {
+			req.Header.Add(paramLoc.ClientID, clientID)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder.Code
+	}
+
+	humanUser := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: humanUser}, []string{"reader"},
+	))
+	serviceAccount := "svc-" + uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(),
+		models.UserConfig{UserID: serviceAccount, ServiceAccount: true},
+		[]string{"reader"},
+	))
+
+	// Case 0: only service accounts may use the method
+	assert.Equal(http.StatusForbidden, checkAllow(humanUser, "", "POST"))
+	assert.Equal(http.StatusOK, checkAllow(serviceAccount, "", "POST"))
+	// Methods without the condition are open to all users
+	assert.Equal(http.StatusOK, checkAllow(humanUser, "", "GET"))
+	assert.Equal(http.StatusOK, checkAllow(serviceAccount, "", "GET"))
+
+	// Case 1: the condition does not replace the permission
+	assert.Nil(mgmtCore.SetUserRoles(context.Background(), serviceAccount, []string{}))
+	assert.Equal(http.StatusForbidden, checkAllow(serviceAccount, "", "POST"))
+
+	// Case 2: an unknown user acting as its own client is recorded as a service account
+	newAccount := "svc-" + uuid.New().String()
+	assert.Equal(http.StatusForbidden, checkAllow(newAccount, newAccount, "GET"))
+	user, err := mgmtCore.GetUser(context.Background(), newAccount)
+	assert.Nil(err)
+	assert.True(user.ServiceAccount)
+	newUser := uuid.New().String()
+	assert.Equal(http.StatusForbidden, checkAllow(newUser, "", "GET"))
+	user, err = mgmtCore.GetUser(context.Background(), newUser)
+	assert.Nil(err)
+	assert.False(user.ServiceAccount)
+}
+
+func TestDenyPathAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"admin":      {AssignedPermissions: []string{"admin"}},
+		"contractor": {AssignedPermissions: []string{"contractor"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/admin/.+$`,
+						PermissionsForMethod: map[string][]string{"*": {"admin"}},
+						ClientsForMethod:     map[string][]string{"*": {"ci-pipeline"}},
+					},
+				},
+				DeniedPathsForHost: []match.TargetDenySpec{
+					{PathPattern: `^/admin/.+$`, Methods: []string{"DELETE"}},
+					{
+						PathPattern:       `^/admin/reports/.+$`,
+						DeniedPermissions: []string{"contractor"},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:     "X-Forwarded-Host",
+		Path:     "X-Forwarded-Uri",
+		Method:   "X-Forwarded-Method",
+		UserID:   "X-Caller-UserID",
+		ClientID: "X-Caller-ClientID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{},
+	)
+	assert.Nil(err)
+
+	checkAllow := func(userID, clientID, method, path string) int {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, path)
+		req.Header.Add(paramLoc.Method, method)
+		req.Header.Add(paramLoc.UserID, userID)
+		if clientID != "" {
+			req.Header.Add(paramLoc.ClientID, clientID)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder.Code
+	}
+
+	adminUser := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: adminUser}, []string{"admin"},
+	))
+
+	// Case 0: the admin is allowed, except for the denied method
+	assert.Equal(http.StatusOK, checkAllow(adminUser, "", "GET", "/admin/users"))
+	assert.Equal(http.StatusForbidden, checkAllow(adminUser, "", "DELETE", "/admin/users"))
+
+	// Case 1: the denied method is denied to the allowed clients too
+	assert.Equal(http.StatusOK, checkAllow(adminUser, "ci-pipeline", "POST", "/admin/users"))
+	assert.Equal(
+		http.StatusForbidden, checkAllow(adminUser, "ci-pipeline", "DELETE", "/admin/users"),
+	)
+
+	// Case 2: holders of the denied permission are denied, even with the allowed permission
+	assert.Equal(http.StatusOK, checkAllow(adminUser, "", "GET", "/admin/reports/daily"))
+	assert.Nil(mgmtCore.AddRolesToUser(context.Background(), adminUser, []string{"contractor"}))
+	assert.Equal(http.StatusForbidden, checkAllow(adminUser, "", "GET", "/admin/reports/daily"))
+	assert.Equal(http.StatusOK, checkAllow(adminUser, "", "GET", "/admin/users"))
+}
+
+func TestImpersonationAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"support": {AssignedPermissions: []string{"impersonate"}},
+		"reader":  {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/orders$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+	impersonation := common.ImpersonationConfig{
+		Enabled: true, Header: "X-Impersonate-UserID", Permission: "impersonate",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: true},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Decisions:     DefineDecisionCache(time.Minute, 0),
+			Impersonation: impersonation,
+		},
+	)
+	assert.Nil(err)
+
+	supportUser := uuid.New().String()
+	reader := uuid.New().String()
+	otherUser := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: supportUser}, []string{"support"},
+	))
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: otherUser}, nil,
+	))
+
+	checkAllow := func(userID, impersonate string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/orders")
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, userID)
+		if impersonate != "" {
+			req.Header.Add(impersonation.Header, impersonate)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: the support user is evaluated as itself without the header
+	assert.Equal(http.StatusForbidden, checkAllow(supportUser, "").Code)
+
+	// Case 1: the support user is evaluated as the impersonated user
+	assert.Equal(http.StatusOK, checkAllow(supportUser, reader).Code)
+	assert.Equal(http.StatusForbidden, checkAllow(supportUser, otherUser).Code)
+
+	// Case 2: a caller without the impersonation permission
+	{
+		resp := checkAllow(otherUser, reader)
+		assert.Equal(http.StatusForbidden, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodePermissionDenied))
+	}
+
+	// Case 3: an unknown impersonated user is not recorded
+	{
+		unknownUser := uuid.New().String()
+		resp := checkAllow(supportUser, unknownUser)
+		assert.Equal(http.StatusForbidden, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeUserNotFound))
+		_, err := mgmtCore.GetUser(context.Background(), unknownUser)
+		assert.NotNil(err)
+	}
+
+	// Case 4: malformed impersonated user ID
+	assert.Equal(http.StatusBadRequest, checkAllow(supportUser, "not/valid").Code)
+}
+
+func TestAuthorizationWithJSONBody(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/orders$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:     "X-Forwarded-Host",
+		Path:     "X-Forwarded-Uri",
+		Method:   "X-Forwarded-Method",
+		UserID:   "X-Caller-UserID",
+		Username: "X-Caller-Username",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: true},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{},
+	)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+
+	checkAllow := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/v1/allow", bytes.NewBufferString(body))
+		assert.Nil(err)
+		req.Header.Add("Content-Type", "application/json; charset=utf-8")
+		// The other headers are ignored when the parameters are given as a body
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, reader)
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: allowed request
+	{
+		body := fmt.Sprintf(
+			`{"host":"unit-test.org","path":"/orders","method":"GET","user_id":"%s"}`, reader,
+		)
+		assert.Equal(http.StatusOK, checkAllow(body).Code)
+	}
+
+	// Case 1: denied request
+	{
+		body := fmt.Sprintf(
+			`{"host":"unit-test.org","path":"/orders","method":"DELETE","user_id":"%s"}`, reader,
+		)
+		assert.Equal(http.StatusForbidden, checkAllow(body).Code)
+	}
+
+	// Case 2: missing and malformed parameters
+	{
+		resp := checkAllow(`{"host":"unit-test.org","path":"/orders","method":"GET"}`)
+		assert.Equal(http.StatusBadRequest, resp.Code)
+		resp = checkAllow(`{"host":`)
+		assert.Equal(http.StatusBadRequest, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeInvalidRequest))
+	}
+
+	// Case 3: unknown user is recorded with the parameters of the body
+	{
+		newUser := uuid.New().String()
+		body := fmt.Sprintf(
+			`{"host":"unit-test.org","path":"/orders","method":"GET","user_id":"%s","username":"batch-job"}`,
+			newUser,
+		)
+		assert.Equal(http.StatusForbidden, checkAllow(body).Code)
+		userInfo, err := mgmtCore.GetUser(context.Background(), newUser)
+		assert.Nil(err)
+		assert.NotNil(userInfo.Username)
+		assert.Equal("batch-job", *userInfo.Username)
+	}
+
+	// Case 4: a proxy forwarding the method and the content type of the original request
+	for _, contentType := range []string{"application/json", ""} {
+		req, err := http.NewRequest("POST", "/v1/allow", nil)
+		assert.Nil(err)
+		if contentType != "" {
+			req.Header.Add("Content-Type", contentType)
+		}
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/orders")
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, reader)
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusOK, respRecorder.Code, contentType)
+	}
+}
+
+func TestRequestConditionAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader":   {AssignedPermissions: []string{"read"}},
+		"exporter": {AssignedPermissions: []string{"export"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	matcherSpec, err := match.ConvertConfigToTargetGroupSpec(&common.AuthorizationConfig{
+		Rules: []common.HostAuthorizationConfig{
+			{
+				Host: "*",
+				TargetPaths: []common.PathAuthorizationConfig{
+					{
+						PathRegexPattern: `^/reports(\?.*)?$`,
+						AllowedMethods: []common.PermissionForAPIMethodConfig{
+							{Method: "GET", Permissions: []string{"read"}},
+						},
+					},
+					{
+						PathRegexPattern: `^/reports(\?.*)?$`,
+						AllowedMethods: []common.PermissionForAPIMethodConfig{
+							{Method: "GET", Permissions: []string{"export"}},
+						},
+						QueryParams: map[string]string{"action": "^export$"},
+					},
+					{
+						PathRegexPattern: `^/reports(\?.*)?$`,
+						AllowedMethods: []common.PermissionForAPIMethodConfig{
+							{Method: "GET", Permissions: []string{"export"}},
+						},
+						Headers: map[string]string{"accept": "^text/csv$"},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(matcherSpec)
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Decisions: DefineDecisionCache(time.Minute, 0),
+		},
+	)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+
+	checkAllow := func(path string, headers map[string]string) int {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, path)
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, reader)
+		for name, value := range headers {
+			req.Header.Add(name, value)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder.Code
+	}
+
+	// Case 0: requests without the conditions only need the read permission
+	assert.Equal(http.StatusOK, checkAllow("/reports", nil))
+	assert.Equal(http.StatusOK, checkAllow("/reports?action=view", nil))
+
+	// Case 1: the export query parameter needs the export permission
+	assert.Equal(http.StatusForbidden, checkAllow("/reports?action=export", nil))
+
+	// Case 2: the forwarded header needs the export permission, despite the memoized decision
+	// for the same path without the header
+	assert.Equal(http.StatusForbidden, checkAllow("/reports", map[string]string{"Accept": "text/csv"}))
+	assert.Equal(http.StatusOK, checkAllow("/reports", map[string]string{"Accept": "text/html"}))
+
+	// Case 3: granting the export permission
+	assert.Nil(mgmtCore.SetUserRoles(context.Background(), reader, []string{"exporter"}))
+	uut.decisions.FlushAll(context.Background())
+	assert.Equal(http.StatusOK, checkAllow("/reports?action=export", nil))
+	assert.Equal(http.StatusOK, checkAllow("/reports", map[string]string{"Accept": "text/csv"}))
+	assert.Equal(http.StatusForbidden, checkAllow("/reports", nil))
+}
+
+func TestScopeAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	matcherSpec, err := match.ConvertConfigToTargetGroupSpec(&common.AuthorizationConfig{
+		Rules: []common.HostAuthorizationConfig{
+			{
+				Host: "*",
+				TargetPaths: []common.PathAuthorizationConfig{
+					{
+						PathRegexPattern: `^/reports$`,
+						AllowedMethods: []common.PermissionForAPIMethodConfig{
+							{
+								Method:         "GET",
+								Permissions:    []string{"read"},
+								Clients:        []string{"report-service"},
+								RequiredScopes: []string{"reports:read", "openid"},
+							},
+							{Method: "PUT", Permissions: []string{"read"}},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(matcherSpec)
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:     "X-Forwarded-Host",
+		Path:     "X-Forwarded-Uri",
+		Method:   "X-Forwarded-Method",
+		UserID:   "X-Caller-UserID",
+		ClientID: "X-Caller-ClientID",
+		Scopes:   "X-Caller-Scopes",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Decisions: DefineDecisionCache(time.Minute, 0),
+		},
+	)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+
+	checkAllow := func(method, userID, clientID, scopes string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/reports")
+		req.Header.Add(paramLoc.Method, method)
+		req.Header.Add(paramLoc.UserID, userID)
+		if clientID != "" {
+			req.Header.Add(paramLoc.ClientID, clientID)
+		}
+		if scopes != "" {
+			req.Header.Add(paramLoc.Scopes, scopes)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: the user holds the permission, and the token carries the scopes
+	assert.Equal(http.StatusOK, checkAllow("GET", reader, "", "openid reports:read").Code)
+	assert.Equal(http.StatusOK, checkAllow("GET", reader, "", "reports:read email openid").Code)
+
+	// Case 1: the token lacks a required scope, despite the memoized decision
+	for _, scopes := range []string{"", "openid", "reports:write openid"} {
+		resp := checkAllow("GET", reader, "", scopes)
+		assert.Equal(http.StatusForbidden, resp.Code, scopes)
+		assert.Contains(resp.Body.String(), string(ErrCodeInsufficientScope), scopes)
+	}
+
+	// Case 2: the directly allowed clients also need the scopes
+	assert.Equal(
+		http.StatusOK, checkAllow("GET", "svc-1", "report-service", "openid reports:read").Code,
+	)
+	assert.Equal(http.StatusForbidden, checkAllow("GET", "svc-1", "report-service", "openid").Code)
+
+	// Case 3: methods without required scopes only need the permission
+	assert.Equal(http.StatusOK, checkAllow("PUT", reader, "", "").Code)
 }
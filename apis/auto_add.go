@@ -0,0 +1,136 @@
+package apis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AutoAddedUserEvent describes an unknown user automatically recorded during authorization
+type AutoAddedUserEvent struct {
+	// UserID is the ID of the recorded user
+	UserID string `json:"user_id"`
+	// Username is the username of the recorded user, if provided
+	Username string `json:"username,omitempty"`
+	// Host is the host of the request being authorized
+	Host string `json:"host"`
+	// Method is the method of the request being authorized
+	Method string `json:"method"`
+	// Path is the path of the request being authorized
+	Path string `json:"path"`
+	// Timestamp is when the user was recorded
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AutoAddedUserObserver is notified each time an unknown user is automatically recorded
+type AutoAddedUserObserver interface {
+	/*
+		UserAutoAdded record that an unknown user was automatically recorded
+
+		 @param ctxt context.Context - execution context
+		 @param event AutoAddedUserEvent - the recorded user
+	*/
+	UserAutoAdded(ctxt context.Context, event AutoAddedUserEvent)
+}
+
+// autoAddedUserObserverImpl implements AutoAddedUserObserver
+type autoAddedUserObserverImpl struct {
+	goutils.Component
+	metric *prometheus.CounterVec
+	alert  common.AutoAddAlertConfig
+	client *http.Client
+	// notifier if provided, sends the account lifecycle notifications
+	notifier users.AccountNotifier
+}
+
+/*
+DefineAutoAddedUserObserver define a new AutoAddedUserObserver
+
+	@param metric *prometheus.CounterVec - if provided, the metric to count the recorded users
+	on. It must support the label "host".
+	@param alert common.AutoAddAlertConfig - webhook alert config
+	@param notifier users.AccountNotifier - if provided, sends the account lifecycle
+	notifications
+	@return new AutoAddedUserObserver instance
+*/
+func DefineAutoAddedUserObserver(
+	metric *prometheus.CounterVec,
+	alert common.AutoAddAlertConfig,
+	notifier users.AccountNotifier,
+) AutoAddedUserObserver {
+	return &autoAddedUserObserverImpl{
+		Component: goutils.Component{
+			LogTags: log.Fields{
+				"module": "apis", "component": "auto-add-observer", "instance": "authorization",
+			},
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+			},
+		},
+		metric:   metric,
+		alert:    alert,
+		client:   &http.Client{Timeout: time.Second * time.Duration(alert.TimeoutSecs)},
+		notifier: notifier,
+	}
+}
+
+/*
+UserAutoAdded record that an unknown user was automatically recorded. The webhook alert is
+sent in the background, so it does not delay the authorization response.
+
+	@param ctxt context.Context - execution context
+	@param event AutoAddedUserEvent - the recorded user
+*/
+func (o *autoAddedUserObserverImpl) UserAutoAdded(ctxt context.Context, event AutoAddedUserEvent) {
+	if o.metric != nil {
+		o.metric.With(prometheus.Labels{"host": event.Host}).Inc()
+	}
+	if o.notifier != nil {
+		o.notifier.Notify(ctxt, users.AccountEvent{
+			Event:     common.AccountEventAutoAdded,
+			UserID:    event.UserID,
+			Username:  event.Username,
+			Timestamp: event.Timestamp,
+		})
+	}
+	if !o.alert.Enabled {
+		return
+	}
+	logTags := o.GetLogTagsForContext(ctxt)
+	go func() {
+		if err := o.sendAlert(event); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to send auto-added user alert for user ID %s", event.UserID)
+		}
+	}()
+}
+
+/*
+sendAlert POST the event to the alert webhook
+
+	@param event AutoAddedUserEvent - the recorded user
+*/
+func (o *autoAddedUserObserverImpl) sendAlert(event AutoAddedUserEvent) error {
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Post(o.alert.WebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+	return nil
+}
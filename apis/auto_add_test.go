@@ -0,0 +1,55 @@
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoAddedUserObserver(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	received := make(chan AutoAddedUserEvent, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event AutoAddedUserEvent
+		assert.Nil(json.NewDecoder(r.Body).Decode(&event))
+		received <- event
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+
+	metric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "authorization_auto_added_user_total", Help: "unit-test"},
+		[]string{"host"},
+	)
+	uut := DefineAutoAddedUserObserver(metric, common.AutoAddAlertConfig{
+		Enabled: true, WebhookURL: webhook.URL, TimeoutSecs: 5,
+	}, nil)
+
+	event := AutoAddedUserEvent{
+		UserID:    "user-1",
+		Host:      "unittest.com",
+		Method:    "GET",
+		Path:      "/path1",
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+	}
+	uut.UserAutoAdded(context.Background(), event)
+	assert.Equal(1.0, testutil.ToFloat64(metric.WithLabelValues("unittest.com")))
+
+	select {
+	case alert := <-received:
+		assert.Equal(event, alert)
+	case <-time.After(time.Second * 5):
+		assert.Fail("webhook alert not received")
+	}
+}
@@ -0,0 +1,97 @@
+package apis
+
+import (
+	"net/http"
+	"path"
+)
+
+// checkHandler authenticates, then authorizes a request in one round trip, so the request proxy
+// needs only one call per request
+type checkHandler struct {
+	// authenticate is the handler of the authentication API
+	authenticate http.HandlerFunc
+	// authorizer is the HTTP handler of the authorization server
+	authorizer http.Handler
+	// allowPath is the path of the authorization API on the authorization server
+	allowPath string
+	// userHeaders are the headers carrying the parameters of the authenticated user
+	userHeaders []string
+}
+
+/*
+defineCheckHandler define a new combined authentication and authorization handler
+
+	@param authnHandler AuthenticationHandler - the authentication handler
+	@param authorizer http.Handler - the HTTP handler of the authorization server
+	@param authorizePathPrefix string - the authorization server's end-point path prefix
+	@return the check handler
+*/
+func defineCheckHandler(
+	authnHandler AuthenticationHandler, authorizer http.Handler, authorizePathPrefix string,
+) checkHandler {
+	userParams := authnHandler.respHeaderParam
+	return checkHandler{
+		authenticate: authnHandler.Authenticate,
+		authorizer:   authorizer,
+		allowPath:    path.Join(authorizePathPrefix, "/v1/allow"),
+		userHeaders: []string{
+			userParams.UserID,
+			userParams.Username,
+			userParams.FirstName,
+			userParams.LastName,
+			userParams.Email,
+			userParams.ClientID,
+			userParams.Scopes,
+		},
+	}
+}
+
+// Check godoc
+// @Summary Authenticate, then authorize a request
+// @Description Authenticate the user as /v1/authenticate does, then authorize the request for
+// @Description the authenticated user as /v1/allow does, in one round trip. The response is
+// @Description that of the authorization, with the user parameter headers of the authentication.
+// @tags Authenticate
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param Authorization header string true "User must provide a bearer token"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 401 {string} string "error"
+// @Failure 403 {object} RespDenied "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/check [get]
+// @Router /v1/check [post]
+func (h checkHandler) Check(w http.ResponseWriter, r *http.Request) {
+	authnResp := newCapturedResponse()
+	h.authenticate(authnResp, r)
+	if authnResp.Code != http.StatusOK {
+		authnResp.copyTo(w)
+		return
+	}
+
+	allowReq := r.Clone(r.Context())
+	allowReq.Method = http.MethodGet
+	allowReq.URL.Path = h.allowPath
+	allowReq.RequestURI = ""
+	allowReq.Body = http.NoBody
+	allowReq.ContentLength = 0
+	// The user parameters come only from the authentication, never from the caller
+	for _, header := range h.userHeaders {
+		allowReq.Header.Del(header)
+		if value := authnResp.Header().Get(header); value != "" {
+			allowReq.Header.Set(header, value)
+			w.Header().Set(header, value)
+		}
+	}
+
+	authzResp := newCapturedResponse()
+	h.authorizer.ServeHTTP(authzResp, allowReq)
+	authzResp.copyTo(w)
+}
+
+// CheckHandler Wrapper around Check
+func (h checkHandler) CheckHandler() http.HandlerFunc {
+	return h.Check
+}
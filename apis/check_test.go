@@ -0,0 +1,113 @@
+package apis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHandler(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{
+			"good-token": {"sub": "alice"}, "other-token": {"sub": "bob"},
+		},
+	}
+	respHeaders := common.AuthorizeRequestParamLocConfig{
+		UserID: "X-Caller-UserID", Username: "X-Caller-Username",
+	}
+	authnHandler, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		oidClient,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			TargetClaims: common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+		},
+		respHeaders,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	// The authorization server allows only "alice", and records what it was called with
+	var authorized *http.Request
+	authorizer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorized = r
+		w.Header().Set("X-Authz", "done")
+		if r.Header.Get(respHeaders.UserID) == "alice" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	})
+	uut := defineCheckHandler(authnHandler, authorizer, "/authz")
+
+	runCheck := func(headers map[string]string) *httptest.ResponseRecorder {
+		authorized = nil
+		req, err := http.NewRequest("GET", "/v1/check", nil)
+		assert.Nil(err)
+		req.Header.Set("X-Forwarded-Uri", "/reports")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		respRecorder := httptest.NewRecorder()
+		uut.CheckHandler().ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: authenticated and authorized
+	{
+		resp := runCheck(map[string]string{"Authorization": "Bearer good-token"})
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("alice", resp.Header().Get(respHeaders.UserID))
+		assert.Equal("done", resp.Header().Get("X-Authz"))
+		assert.NotNil(authorized)
+		assert.Equal("/authz/v1/allow", authorized.URL.Path)
+		assert.Equal("/reports", authorized.Header.Get("X-Forwarded-Uri"))
+		assert.Equal("alice", authorized.Header.Get(respHeaders.UserID))
+	}
+
+	// Case 1: not authenticated, so not authorized
+	{
+		resp := runCheck(map[string]string{"Authorization": "Bearer bad-token"})
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Nil(authorized)
+	}
+
+	// Case 2: the caller can not supply the user parameters
+	{
+		resp := runCheck(map[string]string{
+			"Authorization":      "Bearer good-token",
+			respHeaders.Username: "admin",
+		})
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.NotNil(authorized)
+		assert.Empty(authorized.Header.Get(respHeaders.Username))
+	}
+
+	// Case 3: authenticated, but not authorized
+	{
+		resp := runCheck(map[string]string{"Authorization": "Bearer other-token"})
+		assert.Equal(http.StatusForbidden, resp.Code)
+		assert.Equal("bob", resp.Header().Get(respHeaders.UserID))
+		assert.NotNil(authorized)
+	}
+
+	// Case 4: no token, with the caller supplying the user ID
+	{
+		resp := runCheck(map[string]string{respHeaders.UserID: "alice"})
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Nil(authorized)
+	}
+}
@@ -1,8 +1,15 @@
 package apis
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
 	"github.com/gorilla/mux"
 )
 
@@ -17,3 +24,134 @@ func registerPathPrefix(parent *mux.Router, prefix string, handler MethodHandler
 	}
 	return router
 }
+
+// trackInFlightMiddleware record requests being processed with the in-flight tracker
+func trackInFlightMiddleware(tracker common.InFlightTracker, server string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tracker.RequestStarted(server)
+			defer tracker.RequestCompleted(server)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/*
+cacheBypassMiddleware mark requests from trusted sources bearing the cache bypass header, set to
+the shared secret, so they skip the token and decision caches
+
+	@param cfg common.CacheBypassConfig - the cache bypass header config
+	@return the middleware
+*/
+func cacheBypassMiddleware(cfg common.CacheBypassConfig) (mux.MiddlewareFunc, error) {
+	trusted, err := common.ParseTrustedNetworks(cfg.TrustedSources)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache bypass trusted source: %w", err)
+	}
+	logTags := log.Fields{"module": "apis", "component": "cache-bypass"}
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if provided := r.Header.Get(cfg.Header); provided != "" {
+				// Drop the header, so the secret is not logged
+				r.Header.Del(cfg.Header)
+				switch {
+				case !trusted.Contains(r.RemoteAddr):
+					log.WithFields(logTags).
+						Warnf("Ignoring cache bypass header from untrusted source %s", r.RemoteAddr)
+				case subtle.ConstantTimeCompare([]byte(provided), []byte(cfg.Secret)) != 1:
+					log.WithFields(logTags).
+						Warnf("Ignoring cache bypass header with wrong secret from %s", r.RemoteAddr)
+				default:
+					log.WithFields(logTags).Infof("Request from %s skips the caches", r.RemoteAddr)
+					r = r.WithContext(context.WithValue(r.Context(), common.CacheBypassKey{}, true))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// metricsAuthMiddleware require the configured credentials on the metrics endpoint
+func metricsAuthMiddleware(cfg common.MetricsAuthConfig) mux.MiddlewareFunc {
+	matches := func(provided, expected string) bool {
+		return subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed := false
+			switch cfg.Mode {
+			case common.MetricsAuthModeBasic:
+				username, password, ok := r.BasicAuth()
+				allowed = ok && matches(username, cfg.Username) && matches(password, cfg.Password)
+				if !allowed {
+					w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+				}
+			case common.MetricsAuthModeBearer:
+				token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+				allowed = ok && matches(token, cfg.BearerToken)
+				if !allowed {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				}
+			default:
+				allowed = true
+			}
+			if !allowed {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// capturedResponse is a http.ResponseWriter keeping the response in memory, so a handler can be
+// called in-process, i.e. to answer a query arriving over another protocol
+type capturedResponse struct {
+	// Code is the HTTP response code
+	Code int
+	// Body is the response body
+	Body    bytes.Buffer
+	header  http.Header
+	written bool
+}
+
+// newCapturedResponse define a new capturedResponse
+func newCapturedResponse() *capturedResponse {
+	return &capturedResponse{Code: http.StatusOK, header: http.Header{}}
+}
+
+// Header implements http.ResponseWriter
+func (c *capturedResponse) Header() http.Header {
+	return c.header
+}
+
+// WriteHeader implements http.ResponseWriter. Only the first response code is kept.
+func (c *capturedResponse) WriteHeader(code int) {
+	if c.written {
+		return
+	}
+	c.written = true
+	c.Code = code
+}
+
+// Write implements http.ResponseWriter
+func (c *capturedResponse) Write(body []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	return c.Body.Write(body)
+}
+
+/*
+copyTo write the captured response to the caller
+
+	@param w http.ResponseWriter - the response writer
+*/
+func (c *capturedResponse) copyTo(w http.ResponseWriter) {
+	for name, values := range c.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(c.Code)
+	_, _ = w.Write(c.Body.Bytes())
+}
@@ -0,0 +1,148 @@
+package apis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsAuthMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(cfg common.MetricsAuthConfig, modify func(r *http.Request)) int {
+		req, err := http.NewRequest("GET", "/metrics", nil)
+		assert.Nil(err)
+		modify(req)
+		respRecorder := httptest.NewRecorder()
+		metricsAuthMiddleware(cfg)(next).ServeHTTP(respRecorder, req)
+		return respRecorder.Code
+	}
+
+	// Case 0: no authentication
+	{
+		cfg := common.MetricsAuthConfig{Mode: common.MetricsAuthModeNone}
+		assert.Equal(http.StatusOK, call(cfg, func(r *http.Request) {}))
+	}
+
+	// Case 1: basic authentication
+	{
+		cfg := common.MetricsAuthConfig{
+			Mode: common.MetricsAuthModeBasic, Username: "scraper", Password: "secret",
+		}
+		assert.Equal(http.StatusUnauthorized, call(cfg, func(r *http.Request) {}))
+		assert.Equal(http.StatusUnauthorized, call(cfg, func(r *http.Request) {
+			r.SetBasicAuth("scraper", "wrong")
+		}))
+		assert.Equal(http.StatusOK, call(cfg, func(r *http.Request) {
+			r.SetBasicAuth("scraper", "secret")
+		}))
+	}
+
+	// Case 2: bearer token authentication
+	{
+		cfg := common.MetricsAuthConfig{Mode: common.MetricsAuthModeBearer, BearerToken: "token"}
+		assert.Equal(http.StatusUnauthorized, call(cfg, func(r *http.Request) {}))
+		assert.Equal(http.StatusUnauthorized, call(cfg, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer wrong")
+		}))
+		assert.Equal(http.StatusOK, call(cfg, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer token")
+		}))
+	}
+}
+
+func TestCacheBypassMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	var bypassed bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bypassed = common.CacheBypassRequested(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(cfg common.CacheBypassConfig, remoteAddr string, header string) bool {
+		middleware, err := cacheBypassMiddleware(cfg)
+		assert.Nil(err)
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.RemoteAddr = remoteAddr
+		if header != "" {
+			req.Header.Set("Padlock-Cache-Bypass", header)
+		}
+		bypassed = false
+		middleware(next).ServeHTTP(httptest.NewRecorder(), req)
+		if cfg.Enabled {
+			// The header is dropped, so the secret is not logged
+			assert.Empty(req.Header.Get("Padlock-Cache-Bypass"))
+		}
+		return bypassed
+	}
+
+	cfg := common.CacheBypassConfig{
+		Enabled:        true,
+		Header:         "Padlock-Cache-Bypass",
+		Secret:         "unit-test",
+		TrustedSources: []string{"10.0.0.0/8", "::1/128"},
+	}
+
+	// Case 0: header from trusted sources
+	assert.True(call(cfg, "10.1.2.3:43210", "unit-test"))
+	assert.True(call(cfg, "[::1]:43210", "unit-test"))
+
+	// Case 1: no header
+	assert.False(call(cfg, "10.1.2.3:43210", ""))
+
+	// Case 2: header from an untrusted source
+	assert.False(call(cfg, "192.168.1.2:43210", "unit-test"))
+
+	// Case 3: cache bypass not enabled
+	cfg.Enabled = false
+	assert.False(call(cfg, "10.1.2.3:43210", "unit-test"))
+
+	// Case 4: header relayed from a client through a trusted proxy, without the secret
+	cfg.Enabled = true
+	assert.False(call(cfg, "10.1.2.3:43210", "1"))
+
+	// Case 5: invalid trusted source
+	_, err := cacheBypassMiddleware(common.CacheBypassConfig{TrustedSources: []string{"10.0.0.1"}})
+	assert.NotNil(err)
+}
+
+func TestCapturedResponse(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Case 0: body written without a response code
+	{
+		uut := newCapturedResponse()
+		uut.Header().Set("Content-Type", "application/json")
+		_, err := uut.Write([]byte(`{"success":true}`))
+		assert.Nil(err)
+		assert.Equal(http.StatusOK, uut.Code)
+
+		resp := httptest.NewRecorder()
+		uut.copyTo(resp)
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("application/json", resp.Header().Get("Content-Type"))
+		assert.Equal(`{"success":true}`, resp.Body.String())
+	}
+
+	// Case 1: only the first response code is kept
+	{
+		uut := newCapturedResponse()
+		uut.WriteHeader(http.StatusForbidden)
+		uut.WriteHeader(http.StatusOK)
+		_, err := uut.Write([]byte("denied"))
+		assert.Nil(err)
+		assert.Equal(http.StatusForbidden, uut.Code)
+		assert.Equal("denied", uut.Body.String())
+	}
+}
@@ -0,0 +1,321 @@
+package apis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+)
+
+// RequestOutcome is the outcome of deciding a request against the authorization rules
+type RequestOutcome string
+
+// Supported request outcomes
+const (
+	// OutcomeAllowed the request is allowed
+	OutcomeAllowed RequestOutcome = "allowed"
+	// OutcomeDenied the request is denied
+	OutcomeDenied RequestOutcome = "denied"
+	// OutcomeNoMatchingRule no authorization rule matches the request
+	OutcomeNoMatchingRule RequestOutcome = "no_matching_rule"
+	// OutcomeInsufficientScope the token lacks the OAuth2 scopes the rule requires
+	OutcomeInsufficientScope RequestOutcome = "insufficient_scope"
+	// OutcomeUserRequired the rule requires a user, but the request names none
+	OutcomeUserRequired RequestOutcome = "user_required"
+	// OutcomeUnknownUser the user making the request is not known
+	OutcomeUnknownUser RequestOutcome = "unknown_user"
+	// OutcomeShed the user lookup was shed, as the user database is slow or failing
+	OutcomeShed RequestOutcome = "shed"
+	// OutcomeShedFailOpen the user lookup was shed, but the request is on a fail-open path
+	OutcomeShedFailOpen RequestOutcome = "shed_fail_open"
+)
+
+// RequestDecision is the decision on a request
+type RequestDecision struct {
+	// Outcome is the outcome of the decision
+	Outcome RequestOutcome
+	// Rule is the authorization rule the request matched, if any
+	Rule *match.MatchedRule
+	// Reason explains the outcome
+	Reason string
+	// ByUser whether the decision was made on the permissions and attributes of the user, rather
+	// than by the rule alone
+	ByUser bool
+}
+
+// RequestDecider decides whether a request is allowed by the authorization rules, and the
+// permissions and attributes of the user making it. It is shared by the authorization API and
+// the offline "check" command, so both reach the same decision.
+type RequestDecider struct {
+	core    users.Management
+	matcher match.RequestMatch
+	// bypassChecker if provided, requests matching its rules skip the permission checks
+	bypassChecker match.AuthBypassMatch
+	// shedder if provided, sheds the user lookups while the user database is slow or failing
+	shedder LoadShedder
+}
+
+/*
+DefineRequestDecider define a new RequestDecider instance
+
+	@param core users.Management - core user management logic block
+	@param matcher match.RequestMatch - the request matcher
+	@param bypass *common.AuthnBypassConfig - requests matching these rules skip the permission
+	checks. Optional.
+	@param shedder LoadShedder - sheds the user lookups while the user database is slow or
+	failing. Optional.
+	@return new RequestDecider instance
+*/
+func DefineRequestDecider(
+	core users.Management,
+	matcher match.RequestMatch,
+	bypass *common.AuthnBypassConfig,
+	shedder LoadShedder,
+) (RequestDecider, error) {
+	decider := RequestDecider{core: core, matcher: matcher, shedder: shedder}
+	if bypass != nil {
+		bypassChecker, err := match.DefineAuthBypassMatch(*bypass)
+		if err != nil {
+			return RequestDecider{}, err
+		}
+		decider.bypassChecker = bypassChecker
+	}
+	return decider, nil
+}
+
+/*
+Bypassed whether the request matches the authorization bypass rules, and so skips the
+permission checks
+
+	@param ctxt context.Context - the operating context
+	@param params common.AccessAuthorizeParam - the parameters of the request
+	@return whether the request is bypassed
+*/
+func (d RequestDecider) Bypassed(
+	ctxt context.Context, params common.AccessAuthorizeParam,
+) (bool, error) {
+	if d.bypassChecker == nil {
+		return false, nil
+	}
+	return d.bypassChecker.Match(ctxt, match.RequestParam{
+		Host: &params.Host, Method: params.Method, Path: params.Path,
+	})
+}
+
+/*
+Decide decide whether a request is allowed. The request is matched against the authorization
+rules; the matched rule may decide the request alone, otherwise the user must hold one of the
+permissions of the rule, satisfy its user conditions, and hold none of its denied permissions.
+
+	@param ctxt context.Context - the operating context
+	@param params common.AccessAuthorizeParam - the parameters of the request
+	@param absPath string - the normalized absolute path of the request
+	@return the decision
+*/
+func (d RequestDecider) Decide(
+	ctxt context.Context, params common.AccessAuthorizeParam, absPath string,
+) (RequestDecision, error) {
+	request := match.RequestParam{
+		Host:       &params.Host,
+		Path:       absPath,
+		Method:     params.Method,
+		WebSocket:  params.WebSocket,
+		Attributes: params.Attributes,
+		Headers:    params.Headers,
+		Scopes:     params.Scopes,
+	}
+	// Some matchers, i.e. Rego policies, decide on the permissions the user holds for the host
+	// of the request
+	if params.UserID != "" && match.NeedsUserPermissions(d.matcher) {
+		if shed, ok := d.shed(absPath); !ok {
+			return shed, nil
+		}
+		lookupStart := time.Now()
+		permissions, err := d.core.GetUserRequestPermissions(ctxt, params.UserID)
+		d.lookupDone(lookupStart, err)
+		if err == nil {
+			request.UserPermissions = permissions
+		} else if !errors.Is(err, models.ErrUserNotFound) {
+			return RequestDecision{}, err
+		}
+	}
+	rule, err := d.matcher.MatchRule(ctxt, request)
+	if err != nil {
+		return RequestDecision{Outcome: OutcomeNoMatchingRule, Reason: err.Error()}, nil
+	}
+	decision := RequestDecision{Rule: rule}
+
+	switch {
+	// Denied paths are closed to all requests
+	case rule.DeniesAll():
+		decision.Outcome = OutcomeDenied
+		decision.Reason = "the authorization rule denies the request"
+		return decision, nil
+	// Public endpoints are open to all requests
+	case rule.AllowsAnonymous():
+		decision.Outcome = OutcomeAllowed
+		decision.Reason = "the authorization rule allows anonymous access"
+		return decision, nil
+	// The token must carry the scopes the rule requires, whoever makes the request
+	case !rule.AcceptsScopes(params.Scopes):
+		decision.Outcome = OutcomeInsufficientScope
+		decision.Reason = fmt.Sprintf(
+			"token scopes '%s' lack the scopes '%s' required by the rule",
+			strings.Join(params.Scopes, " "),
+			strings.Join(rule.Scopes, " "),
+		)
+		return decision, nil
+	// Clients granted the rule directly are allowed regardless of the user
+	case rule.AllowsClient(params.ClientID):
+		decision.Outcome = OutcomeAllowed
+		decision.Reason = fmt.Sprintf("the authorization rule allows client %s", params.ClientID)
+		return decision, nil
+	case params.UserID == "":
+		decision.Outcome = OutcomeUserRequired
+		decision.Reason = fmt.Sprintf("user ID is required for '%s'", params.String())
+		return decision, nil
+	}
+
+	if shed, ok := d.shed(absPath); !ok {
+		shed.Rule = rule
+		return shed, nil
+	}
+
+	// Check whether the user holds one of the permissions of the rule
+	var allowedPermissions []string
+	if rule != nil {
+		allowedPermissions = rule.Permissions
+	}
+	lookupStart := time.Now()
+	allowed, err := d.core.DoesUserHavePermission(ctxt, params.UserID, allowedPermissions)
+	d.lookupDone(lookupStart, err)
+	if errors.Is(err, models.ErrUserNotFound) {
+		decision.Outcome = OutcomeUnknownUser
+		decision.Reason = fmt.Sprintf("user ID %s is unknown", params.UserID)
+		return decision, nil
+	} else if err != nil {
+		return RequestDecision{}, err
+	}
+	decision.ByUser = true
+	decision.Outcome = OutcomeDenied
+	if !allowed {
+		decision.Reason = fmt.Sprintf(
+			"user ID %s has none of the permissions of the rule", params.UserID,
+		)
+		return decision, nil
+	}
+
+	// The user must also satisfy the user conditions of the rule, and hold none of its denied
+	// permissions
+	if accepted, err := d.acceptsUser(ctxt, params.UserID, rule); err != nil {
+		return RequestDecision{}, err
+	} else if !accepted {
+		decision.Reason = fmt.Sprintf(
+			"user ID %s does not satisfy the user conditions of the rule", params.UserID,
+		)
+		return decision, nil
+	}
+	if clear, err := d.holdsNoDeniedPermission(ctxt, params.UserID, rule); err != nil {
+		return RequestDecision{}, err
+	} else if !clear {
+		decision.Reason = fmt.Sprintf(
+			"user ID %s holds one of the denied permissions of the rule", params.UserID,
+		)
+		return decision, nil
+	}
+	decision.Outcome = OutcomeAllowed
+	decision.Reason = fmt.Sprintf("user ID %s holds a permission of the rule", params.UserID)
+	return decision, nil
+}
+
+/*
+shed check whether a user lookup is admitted by the load shedder. The request is shed, rather
+than queued on the user database, while it is slow or failing.
+
+	@param absPath string - the normalized absolute path of the request
+	@return if the lookup is not admitted, the decision on the shed request, and false
+*/
+func (d RequestDecider) shed(absPath string) (RequestDecision, bool) {
+	if d.shedder == nil || d.shedder.Admit(time.Now().UTC()) {
+		return RequestDecision{}, true
+	}
+	if d.shedder.FailOpen(absPath) {
+		return RequestDecision{
+			Outcome: OutcomeShedFailOpen,
+			Reason:  "shedding load, allowing request on fail-open path",
+		}, false
+	}
+	return RequestDecision{
+		Outcome: OutcomeShed, Reason: "authorization server overloaded, shedding request",
+	}, false
+}
+
+/*
+lookupDone report an admitted user lookup to the load shedder
+
+	@param lookupStart time.Time - when the lookup started
+	@param err error - the lookup error, if any. An unknown user is not a failure.
+*/
+func (d RequestDecider) lookupDone(lookupStart time.Time, err error) {
+	if d.shedder == nil {
+		return
+	}
+	d.shedder.Done(
+		time.Since(lookupStart),
+		err != nil && !errors.Is(err, models.ErrUserNotFound),
+		time.Now().UTC(),
+	)
+}
+
+/*
+acceptsUser check whether the user satisfies the user attribute and the service account
+conditions of the matched rule
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - the ID of the user
+	@param rule *match.MatchedRule - the authorization rule the request matched
+	@return whether the user satisfies the conditions
+*/
+func (d RequestDecider) acceptsUser(
+	ctxt context.Context, userID string, rule *match.MatchedRule,
+) (bool, error) {
+	if rule == nil || (len(rule.UserAttributes) == 0 && !rule.ServiceAccountsOnly) {
+		return true, nil
+	}
+	user, err := d.core.GetUser(ctxt, userID)
+	if err != nil {
+		return false, err
+	}
+	if rule.ServiceAccountsOnly && !user.ServiceAccount {
+		return false, nil
+	}
+	return rule.AcceptsUserAttributes(user.Attributes)
+}
+
+/*
+holdsNoDeniedPermission check whether the user holds none of the denied permissions of the
+matched rule
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - the ID of the user
+	@param rule *match.MatchedRule - the authorization rule the request matched
+	@return whether the user holds none of the denied permissions
+*/
+func (d RequestDecider) holdsNoDeniedPermission(
+	ctxt context.Context, userID string, rule *match.MatchedRule,
+) (bool, error) {
+	if rule == nil || len(rule.DeniedPermissions) == 0 {
+		return true, nil
+	}
+	denied, err := d.core.DoesUserHavePermission(ctxt, userID, rule.DeniedPermissions)
+	if err != nil {
+		return false, err
+	}
+	return !denied, nil
+}
@@ -0,0 +1,266 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRequestDecider(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+		"writer": {AssignedPermissions: []string{"write"}},
+		"staging-admin": {
+			AssignedPermissions: []string{"admin"}, AllowedHosts: []string{"staging.unit-test.org"},
+		},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	matcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/public$`,
+						PermissionsForMethod: map[string][]string{"GET": {common.AnonymousPermission}},
+					},
+					{
+						PathPattern:          `^/reports$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+						ClientsForMethod:     map[string][]string{"GET": {"report-service"}},
+						ScopesForMethod:      map[string][]string{"GET": {"reports:read"}},
+					},
+					{
+						PathPattern:          `^/ledger$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+						UserAttributesForMethod: map[string]map[string]string{
+							"GET": {"department": "^finance$"},
+						},
+					},
+				},
+				DeniedPathsForHost: []match.TargetDenySpec{
+					{PathPattern: `^/admin$`},
+					{PathPattern: `^/reports$`, DeniedPermissions: []string{"write"}},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	uut, err := DefineRequestDecider(mgmtCore, matcher, &common.AuthnBypassConfig{
+		Rules: []common.AuthnBypassMatchEntry{{MatchType: "path", Matches: []string{"^/healthz$"}}},
+	}, nil)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+	readerWriter := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(),
+		models.UserConfig{
+			UserID: readerWriter, Attributes: map[string]string{"department": "finance"},
+		},
+		[]string{"reader", "writer"},
+	))
+	stagingAdmin := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: stagingAdmin},
+		[]string{"reader", "staging-admin"},
+	))
+
+	// Case 0: bypass rules
+	{
+		bypassed, err := uut.Bypassed(context.Background(), common.AccessAuthorizeParam{
+			Host: "unit-test.org", Method: "GET", Path: "/healthz",
+		})
+		assert.Nil(err)
+		assert.True(bypassed)
+		bypassed, err = uut.Bypassed(context.Background(), common.AccessAuthorizeParam{
+			Host: "unit-test.org", Method: "GET", Path: "/ledger",
+		})
+		assert.Nil(err)
+		assert.False(bypassed)
+	}
+
+	for idx, testCase := range []struct {
+		params  common.AccessAuthorizeParam
+		outcome RequestOutcome
+		byUser  bool
+	}{
+		// Case 1: denied path
+		{
+			params:  common.AccessAuthorizeParam{UserID: reader, Method: "GET", Path: "/admin"},
+			outcome: OutcomeDenied,
+		},
+		// Case 2: anonymous access
+		{
+			params:  common.AccessAuthorizeParam{Method: "GET", Path: "/public"},
+			outcome: OutcomeAllowed,
+		},
+		// Case 3: required scopes
+		{
+			params:  common.AccessAuthorizeParam{UserID: reader, Method: "GET", Path: "/reports"},
+			outcome: OutcomeInsufficientScope,
+		},
+		// Case 4: allowed client
+		{
+			params: common.AccessAuthorizeParam{
+				ClientID: "report-service", Method: "GET", Path: "/reports",
+				Scopes: []string{"reports:read"},
+			},
+			outcome: OutcomeAllowed,
+		},
+		// Case 5: no user
+		{
+			params: common.AccessAuthorizeParam{
+				Method: "GET", Path: "/reports", Scopes: []string{"reports:read"},
+			},
+			outcome: OutcomeUserRequired,
+		},
+		// Case 6: unknown user
+		{
+			params: common.AccessAuthorizeParam{
+				UserID: uuid.New().String(), Method: "GET", Path: "/reports",
+				Scopes: []string{"reports:read"},
+			},
+			outcome: OutcomeUnknownUser,
+		},
+		// Case 7: user holding the permission
+		{
+			params: common.AccessAuthorizeParam{
+				UserID: reader, Method: "GET", Path: "/reports", Scopes: []string{"reports:read"},
+			},
+			outcome: OutcomeAllowed,
+			byUser:  true,
+		},
+		// Case 8: user holding a denied permission
+		{
+			params: common.AccessAuthorizeParam{
+				UserID: readerWriter, Method: "GET", Path: "/reports",
+				Scopes: []string{"reports:read"},
+			},
+			outcome: OutcomeDenied,
+			byUser:  true,
+		},
+		// Case 9: user attribute conditions
+		{
+			params:  common.AccessAuthorizeParam{UserID: reader, Method: "GET", Path: "/ledger"},
+			outcome: OutcomeDenied,
+			byUser:  true,
+		},
+		{
+			params: common.AccessAuthorizeParam{
+				UserID: readerWriter, Method: "GET", Path: "/ledger",
+			},
+			outcome: OutcomeAllowed,
+			byUser:  true,
+		},
+	} {
+		testCase.params.Host = "unit-test.org"
+		decision, err := uut.Decide(context.Background(), testCase.params, testCase.params.Path)
+		assert.Nil(err, fmt.Sprintf("case %d", idx))
+		assert.Equal(testCase.outcome, decision.Outcome, fmt.Sprintf("case %d", idx))
+		assert.Equal(testCase.byUser, decision.ByUser, fmt.Sprintf("case %d", idx))
+		assert.NotEmpty(decision.Reason, fmt.Sprintf("case %d", idx))
+	}
+
+	// Case 10: matcher deciding on the permissions of the user
+	{
+		regoMatcher, err := match.DefineRegoPolicyMatcher(match.RegoPolicySpec{
+			Module: "policy.rego",
+			Policy: `package padlock.authz
+
+decision := {"permissions": ["read"]} {
+	input.user_permissions[_] == "write"
+}
+`,
+			Query: "data.padlock.authz.decision",
+		})
+		assert.Nil(err)
+		regoDecider, err := DefineRequestDecider(mgmtCore, regoMatcher, nil, nil)
+		assert.Nil(err)
+
+		params := common.AccessAuthorizeParam{
+			UserID: readerWriter, Host: "unit-test.org", Method: "GET", Path: "/reports",
+		}
+		decision, err := regoDecider.Decide(context.Background(), params, params.Path)
+		assert.Nil(err)
+		assert.Equal(OutcomeAllowed, decision.Outcome)
+		assert.Equal("padlock.authz.decision", decision.Rule.PathPattern)
+
+		params.UserID = reader
+		decision, err = regoDecider.Decide(context.Background(), params, params.Path)
+		assert.Nil(err)
+		assert.Equal(OutcomeDenied, decision.Outcome)
+
+		params.UserID = uuid.New().String()
+		decision, err = regoDecider.Decide(context.Background(), params, params.Path)
+		assert.Nil(err)
+		assert.Equal(OutcomeUnknownUser, decision.Outcome)
+	}
+
+	// Case 11: the policy only sees the permissions of the roles applying to the host
+	{
+		regoMatcher, err := match.DefineRegoPolicyMatcher(match.RegoPolicySpec{
+			Module: "policy.rego",
+			Policy: `package padlock.authz
+
+decision := {"permissions": ["read"]} {
+	input.user_permissions[_] == "admin"
+}
+`,
+			Query: "data.padlock.authz.decision",
+		})
+		assert.Nil(err)
+		regoDecider, err := DefineRequestDecider(mgmtCore, regoMatcher, nil, nil)
+		assert.Nil(err)
+
+		for _, testCase := range []struct {
+			host    string
+			outcome RequestOutcome
+		}{
+			{host: "staging.unit-test.org", outcome: OutcomeAllowed},
+			{host: "prod.unit-test.org", outcome: OutcomeDenied},
+		} {
+			params := common.AccessAuthorizeParam{
+				UserID: stagingAdmin, Host: testCase.host, Method: "GET", Path: "/reports",
+			}
+			ctxt := context.WithValue(context.Background(), common.AccessAuthorizeParamKey{}, params)
+			decision, err := regoDecider.Decide(ctxt, params, params.Path)
+			assert.Nil(err, testCase.host)
+			assert.Equal(testCase.outcome, decision.Outcome, testCase.host)
+		}
+	}
+}
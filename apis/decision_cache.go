@@ -0,0 +1,290 @@
+package apis
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
+	"github.com/alwitt/padlock/match"
+	"github.com/apex/log"
+)
+
+// DecisionKey identifies a memoized authorization decision
+type DecisionKey struct {
+	// UserID is the ID of the user making the request
+	UserID string
+	// ClientID is the ID of the OAuth2 client making the request, if known
+	ClientID string
+	// Host is the host of the request
+	Host string
+	// Path is the absolute path of the request
+	Path string
+	// Method is the method of the request
+	Method string
+	// WebSocket whether the request is a WebSocket upgrade
+	WebSocket bool
+	// Attributes is the canonical form of the additional named attributes of the request
+	Attributes string
+	// Headers is the canonical form of the request headers the rules have conditions on
+	Headers string
+	// Scopes is the sorted, space separated OAuth2 scopes of the token of the request
+	Scopes string
+}
+
+// Decision is a memoized authorization decision
+type Decision struct {
+	// Allowed whether the request was allowed
+	Allowed bool
+	// Rule is the authorization rule the request matched
+	Rule *match.MatchedRule
+}
+
+// DecisionCache memoizes authorization decisions for known users
+type DecisionCache interface {
+	/*
+		Lookup fetch a memoized decision
+
+			@param ctxt context.Context - the operating context
+			@param key DecisionKey - the request being authorized
+			@param timestamp time.Time - the current timestamp
+			@return the decision, and whether it was found
+	*/
+	Lookup(ctxt context.Context, key DecisionKey, timestamp time.Time) (Decision, bool)
+
+	/*
+		Record memoize a decision
+
+			@param ctxt context.Context - the operating context
+			@param key DecisionKey - the request being authorized
+			@param decision Decision - the decision
+			@param timestamp time.Time - the current timestamp
+	*/
+	Record(ctxt context.Context, key DecisionKey, decision Decision, timestamp time.Time)
+
+	/*
+		FlushUser remove all memoized decisions of a user
+
+			@param ctxt context.Context - the operating context
+			@param userID string - the user ID
+			@return the number of decisions removed
+	*/
+	FlushUser(ctxt context.Context, userID string) int
+
+	/*
+		FlushAll remove all memoized decisions
+
+			@param ctxt context.Context - the operating context
+			@return the number of decisions removed
+	*/
+	FlushAll(ctxt context.Context) int
+
+	/*
+		RemoveExpired remove the memoized decisions which have expired
+
+			@param ctxt context.Context - the operating context
+			@param timestamp time.Time - the current timestamp
+			@return the number of decisions removed
+	*/
+	RemoveExpired(ctxt context.Context, timestamp time.Time) int
+}
+
+// decisionEntry a memoized decision with its expiration time
+type decisionEntry struct {
+	key      DecisionKey
+	decision Decision
+	expire   time.Time
+}
+
+// decisionCacheImpl implements DecisionCache
+type decisionCacheImpl struct {
+	goutils.Component
+	lock       sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	// cache is the memoized decisions, grouped by user ID
+	cache map[string]map[DecisionKey]*list.Element
+	// recent is the memoized decisions, the most recently used first
+	recent *list.List
+}
+
+/*
+DefineDecisionCache define a new in-memory authorization decision cache
+
+	@param ttl time.Duration - how long a decision is memoized for
+	@param maxEntries int - max number of decisions to memoize. When full, the least recently
+	used decision is removed. Zero means no limit.
+	@return new DecisionCache instance
+*/
+func DefineDecisionCache(ttl time.Duration, maxEntries int) DecisionCache {
+	logTags := log.Fields{"module": "apis", "component": "decision-cache"}
+	return &decisionCacheImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		lock:       sync.Mutex{},
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		cache:      make(map[string]map[DecisionKey]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+/*
+remove remove one memoized decision. The caller must hold the lock.
+
+	@param element *list.Element - the memoized decision
+*/
+func (c *decisionCacheImpl) remove(element *list.Element) {
+	entry := c.recent.Remove(element).(*decisionEntry)
+	forUser := c.cache[entry.key.UserID]
+	delete(forUser, entry.key)
+	if len(forUser) == 0 {
+		delete(c.cache, entry.key.UserID)
+	}
+}
+
+/*
+Lookup fetch a memoized decision
+
+	@param ctxt context.Context - the operating context
+	@param key DecisionKey - the request being authorized
+	@param timestamp time.Time - the current timestamp
+	@return the decision, and whether it was found
+*/
+func (c *decisionCacheImpl) Lookup(
+	ctxt context.Context, key DecisionKey, timestamp time.Time,
+) (Decision, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.cache[key.UserID][key]
+	if !ok {
+		return Decision{}, false
+	}
+	entry := element.Value.(*decisionEntry)
+	if !timestamp.Before(entry.expire) {
+		c.remove(element)
+		return Decision{}, false
+	}
+	c.recent.MoveToFront(element)
+	return entry.decision, true
+}
+
+/*
+Record memoize a decision
+
+	@param ctxt context.Context - the operating context
+	@param key DecisionKey - the request being authorized
+	@param decision Decision - the decision
+	@param timestamp time.Time - the current timestamp
+*/
+func (c *decisionCacheImpl) Record(
+	ctxt context.Context, key DecisionKey, decision Decision, timestamp time.Time,
+) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := &decisionEntry{key: key, decision: decision, expire: timestamp.Add(c.ttl)}
+	forUser, ok := c.cache[key.UserID]
+	if !ok {
+		forUser = make(map[DecisionKey]*list.Element)
+		c.cache[key.UserID] = forUser
+	}
+	if element, ok := forUser[key]; ok {
+		element.Value = entry
+		c.recent.MoveToFront(element)
+		return
+	}
+	forUser[key] = c.recent.PushFront(entry)
+	// Make room by removing the least recently used decision
+	if c.maxEntries > 0 && c.recent.Len() > c.maxEntries {
+		c.remove(c.recent.Back())
+	}
+}
+
+/*
+FlushUser remove all memoized decisions of a user
+
+	@param ctxt context.Context - the operating context
+	@param userID string - the user ID
+	@return the number of decisions removed
+*/
+func (c *decisionCacheImpl) FlushUser(ctxt context.Context, userID string) int {
+	logTags := c.GetLogTagsForContext(ctxt)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	removed := 0
+	for _, element := range c.cache[userID] {
+		c.remove(element)
+		removed++
+	}
+	log.WithFields(logTags).Debugf("Flushed %d decisions of user %s", removed, userID)
+	return removed
+}
+
+/*
+FlushAll remove all memoized decisions
+
+	@param ctxt context.Context - the operating context
+	@return the number of decisions removed
+*/
+func (c *decisionCacheImpl) FlushAll(ctxt context.Context) int {
+	logTags := c.GetLogTagsForContext(ctxt)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	removed := c.recent.Len()
+	c.cache = make(map[string]map[DecisionKey]*list.Element)
+	c.recent.Init()
+	log.WithFields(logTags).Debugf("Flushed %d decisions", removed)
+	return removed
+}
+
+/*
+RemoveExpired remove the memoized decisions which have expired
+
+	@param ctxt context.Context - the operating context
+	@param timestamp time.Time - the current timestamp
+	@return the number of decisions removed
+*/
+func (c *decisionCacheImpl) RemoveExpired(ctxt context.Context, timestamp time.Time) int {
+	logTags := c.GetLogTagsForContext(ctxt)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	removed := 0
+	for element := c.recent.Front(); element != nil; {
+		next := element.Next()
+		if !timestamp.Before(element.Value.(*decisionEntry).expire) {
+			c.remove(element)
+			removed++
+		}
+		element = next
+	}
+	log.WithFields(logTags).Debugf("Removed %d expired decisions", removed)
+	return removed
+}
+
+/*
+SubscribeDecisionCacheToInvalidation flush the memoized decisions of a user when the user or
+the user's roles change, or when a flush is requested through the invalidation bus
+
+	@param cache DecisionCache - the decision cache
+	@param bus invalidation.Bus - the invalidation notice bus
+*/
+func SubscribeDecisionCacheToInvalidation(cache DecisionCache, bus invalidation.Bus) {
+	handler := func(ctxt context.Context, msg invalidation.Message) error {
+		if msg.Target == invalidation.TargetAll || msg.Key == "" {
+			cache.FlushAll(ctxt)
+			return nil
+		}
+		cache.FlushUser(ctxt, msg.Key)
+		return nil
+	}
+	bus.Subscribe(invalidation.TargetUser, handler)
+	bus.Subscribe(invalidation.TargetDecision, handler)
+}
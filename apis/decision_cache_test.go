@@ -0,0 +1,236 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDecisionCache(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	utCtxt := context.Background()
+	uut := DefineDecisionCache(time.Second*10, 0)
+	timestamp := time.Now().UTC()
+
+	user0 := uuid.New().String()
+	user1 := uuid.New().String()
+	key0 := DecisionKey{UserID: user0, Host: "unit-test.org", Path: "/path1", Method: "GET"}
+	key1 := DecisionKey{UserID: user0, Host: "unit-test.org", Path: "/path1", Method: "PUT"}
+	key2 := DecisionKey{UserID: user1, Host: "unit-test.org", Path: "/path1", Method: "GET"}
+
+	// Case 0: nothing memoized
+	{
+		_, ok := uut.Lookup(utCtxt, key0, timestamp)
+		assert.False(ok)
+	}
+
+	// Case 1: memoize decisions
+	uut.Record(utCtxt, key0, Decision{Allowed: true}, timestamp)
+	uut.Record(utCtxt, key1, Decision{Allowed: false}, timestamp)
+	uut.Record(utCtxt, key2, Decision{Allowed: true}, timestamp.Add(time.Second*5))
+	{
+		decision, ok := uut.Lookup(utCtxt, key0, timestamp.Add(time.Second))
+		assert.True(ok)
+		assert.True(decision.Allowed)
+		decision, ok = uut.Lookup(utCtxt, key1, timestamp.Add(time.Second))
+		assert.True(ok)
+		assert.False(decision.Allowed)
+	}
+
+	// Case 2: decisions expire
+	{
+		_, ok := uut.Lookup(utCtxt, key0, timestamp.Add(time.Second*10))
+		assert.False(ok)
+		assert.Equal(1, uut.RemoveExpired(utCtxt, timestamp.Add(time.Second*10)))
+		_, ok = uut.Lookup(utCtxt, key2, timestamp.Add(time.Second*10))
+		assert.True(ok)
+	}
+
+	// Case 3: flush by user
+	uut.Record(utCtxt, key0, Decision{Allowed: true}, timestamp)
+	uut.Record(utCtxt, key1, Decision{Allowed: false}, timestamp)
+	assert.Equal(2, uut.FlushUser(utCtxt, user0))
+	{
+		_, ok := uut.Lookup(utCtxt, key0, timestamp)
+		assert.False(ok)
+		_, ok = uut.Lookup(utCtxt, key2, timestamp)
+		assert.True(ok)
+	}
+
+	// Case 4: flush through the invalidation bus
+	bus := invalidation.DefineLocalBus("unit-test")
+	SubscribeDecisionCacheToInvalidation(uut, bus)
+	uut.Record(utCtxt, key0, Decision{Allowed: true}, timestamp)
+	assert.Nil(bus.Publish(utCtxt, invalidation.TargetUser, user0))
+	{
+		_, ok := uut.Lookup(utCtxt, key0, timestamp)
+		assert.False(ok)
+		_, ok = uut.Lookup(utCtxt, key2, timestamp)
+		assert.True(ok)
+	}
+	uut.Record(utCtxt, key0, Decision{Allowed: true}, timestamp)
+	assert.Nil(bus.Publish(utCtxt, invalidation.TargetDecision, ""))
+	{
+		_, ok := uut.Lookup(utCtxt, key0, timestamp)
+		assert.False(ok)
+		_, ok = uut.Lookup(utCtxt, key2, timestamp)
+		assert.False(ok)
+	}
+
+	// Case 5: the least recently used decision is removed when full
+	bounded := DefineDecisionCache(time.Second*10, 2)
+	bounded.Record(utCtxt, key0, Decision{Allowed: true}, timestamp)
+	bounded.Record(utCtxt, key1, Decision{Allowed: false}, timestamp)
+	{
+		_, ok := bounded.Lookup(utCtxt, key0, timestamp)
+		assert.True(ok)
+	}
+	bounded.Record(utCtxt, key2, Decision{Allowed: true}, timestamp)
+	{
+		_, ok := bounded.Lookup(utCtxt, key1, timestamp)
+		assert.False(ok)
+		_, ok = bounded.Lookup(utCtxt, key0, timestamp)
+		assert.True(ok)
+		_, ok = bounded.Lookup(utCtxt, key2, timestamp)
+		assert.True(ok)
+	}
+	assert.Equal(1, bounded.FlushUser(utCtxt, user0))
+	assert.Equal(1, bounded.FlushAll(utCtxt))
+}
+
+func TestAuthorizationWithDecisionCache(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	bus := invalidation.DefineLocalBus("unit-test")
+	decisions := DefineDecisionCache(time.Minute, 0)
+	SubscribeDecisionCacheToInvalidation(decisions, bus)
+
+	mgmtCore, err := users.CreateManagement(dbClient, bus, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/path1$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:      "X-Forwarded-Host",
+		Path:      "X-Forwarded-Uri",
+		Method:    "X-Forwarded-Method",
+		UserID:    "X-Caller-UserID",
+		Username:  "X-Caller-Username",
+		FirstName: "X-Caller-Firstname",
+		LastName:  "X-Caller-Lastname",
+		Email:     "X-Caller-Email",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Decisions: decisions,
+		},
+	)
+	assert.Nil(err)
+
+	testUser := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(context.Background(), models.UserConfig{UserID: testUser}, nil))
+
+	checkAllow := func(bypass bool, expected int) {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/path1")
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, testUser)
+		if bypass {
+			req = req.WithContext(
+				context.WithValue(req.Context(), common.CacheBypassKey{}, true),
+			)
+		}
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		assert.Equal(expected, respRecorder.Code)
+	}
+
+	// Case 0: decision is memoized
+	checkAllow(false, http.StatusForbidden)
+	{
+		decision, ok := decisions.Lookup(context.Background(), DecisionKey{
+			UserID: testUser, Host: "unit-test.org", Path: "/path1", Method: "GET",
+		}, time.Now().UTC())
+		assert.True(ok)
+		assert.False(decision.Allowed)
+	}
+
+	// Case 1: memoized decision is reused, even if the underlying state changes
+	decisions.Record(context.Background(), DecisionKey{
+		UserID: testUser, Host: "unit-test.org", Path: "/path1", Method: "GET",
+	}, Decision{Allowed: true}, time.Now().UTC())
+	checkAllow(false, http.StatusOK)
+
+	// Case 2: cache bypass skips the memoized decision
+	checkAllow(true, http.StatusForbidden)
+	checkAllow(false, http.StatusForbidden)
+
+	// Case 3: changing the user's roles flushes the memoized decisions
+	assert.Nil(mgmtCore.SetUserRoles(context.Background(), testUser, []string{"reader"}))
+	checkAllow(false, http.StatusOK)
+	assert.Nil(mgmtCore.SetUserRoles(context.Background(), testUser, []string{}))
+	checkAllow(false, http.StatusForbidden)
+}
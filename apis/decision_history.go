@@ -0,0 +1,194 @@
+package apis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+)
+
+// DecisionHistory keeps a rolling window of authorization decisions for later queries
+type DecisionHistory interface {
+	/*
+		Record queue a decision to be recorded. The decision is persisted on the next flush.
+
+			@param ctxt context.Context - the operating context
+			@param decision models.DecisionRecord - the decision
+	*/
+	Record(ctxt context.Context, decision models.DecisionRecord)
+
+	/*
+		Flush persist the queued decisions
+
+			@param ctxt context.Context - the operating context
+			@return whether successful
+	*/
+	Flush(ctxt context.Context) error
+
+	/*
+		Query fetch the recorded decisions matching the filter, newest first
+
+			@param ctxt context.Context - the operating context
+			@param filter models.DecisionHistoryFilter - the query filter
+			@return the matching decisions
+	*/
+	Query(ctxt context.Context, filter models.DecisionHistoryFilter) ([]models.DecisionRecord, error)
+
+	/*
+		Prune remove the decisions which are outside the retention window
+
+			@param ctxt context.Context - the operating context
+			@param timestamp time.Time - the current timestamp
+			@return the number of decisions removed
+	*/
+	Prune(ctxt context.Context, timestamp time.Time) (int64, error)
+}
+
+// decisionHistoryImpl implements DecisionHistory
+type decisionHistoryImpl struct {
+	goutils.Component
+	client     models.DecisionHistoryDBClient
+	retention  time.Duration
+	maxEntries int
+	maxPending int
+	lock       sync.Mutex
+	// pending is the decisions waiting to be persisted
+	pending []models.DecisionRecord
+	// dropped is the number of decisions dropped since the last flush, as too many were pending
+	dropped int
+}
+
+/*
+DefineDecisionHistory define a new authorization decision history. Decisions are queued in
+memory, and persisted in batches, so recording does not delay the authorization response.
+
+	@param client models.DecisionHistoryDBClient - the decision history DB client
+	@param retention time.Duration - how long the decisions are kept for
+	@param maxEntries int - max number of decisions to keep. Zero means no limit.
+	@param maxPending int - max number of decisions to queue between flushes. Decisions over
+	this are dropped.
+	@return new DecisionHistory instance
+*/
+func DefineDecisionHistory(
+	client models.DecisionHistoryDBClient, retention time.Duration, maxEntries, maxPending int,
+) DecisionHistory {
+	logTags := log.Fields{"module": "apis", "component": "decision-history"}
+	return &decisionHistoryImpl{
+		Component:  goutils.Component{LogTags: logTags},
+		client:     client,
+		retention:  retention,
+		maxEntries: maxEntries,
+		maxPending: maxPending,
+		pending:    []models.DecisionRecord{},
+	}
+}
+
+/*
+Record queue a decision to be recorded. The decision is persisted on the next flush.
+
+	@param ctxt context.Context - the operating context
+	@param decision models.DecisionRecord - the decision
+*/
+func (h *decisionHistoryImpl) Record(ctxt context.Context, decision models.DecisionRecord) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.pending) >= h.maxPending {
+		h.dropped++
+		return
+	}
+	h.pending = append(h.pending, decision)
+}
+
+/*
+Flush persist the queued decisions
+
+	@param ctxt context.Context - the operating context
+	@return whether successful
+*/
+func (h *decisionHistoryImpl) Flush(ctxt context.Context) error {
+	logTags := h.GetLogTagsForContext(ctxt)
+	h.lock.Lock()
+	batch := h.pending
+	dropped := h.dropped
+	h.pending = []models.DecisionRecord{}
+	h.dropped = 0
+	h.lock.Unlock()
+	if dropped > 0 {
+		log.WithFields(logTags).Warnf("Dropped %d decisions, too many were pending", dropped)
+	}
+	return h.client.RecordDecisions(ctxt, batch)
+}
+
+/*
+Query fetch the recorded decisions matching the filter, newest first
+
+	@param ctxt context.Context - the operating context
+	@param filter models.DecisionHistoryFilter - the query filter
+	@return the matching decisions
+*/
+func (h *decisionHistoryImpl) Query(
+	ctxt context.Context, filter models.DecisionHistoryFilter,
+) ([]models.DecisionRecord, error) {
+	// Include the decisions of this replica which are not yet persisted
+	if err := h.Flush(ctxt); err != nil {
+		return nil, err
+	}
+	return h.client.ListDecisions(ctxt, filter)
+}
+
+/*
+Prune remove the decisions which are outside the retention window
+
+	@param ctxt context.Context - the operating context
+	@param timestamp time.Time - the current timestamp
+	@return the number of decisions removed
+*/
+func (h *decisionHistoryImpl) Prune(ctxt context.Context, timestamp time.Time) (int64, error) {
+	logTags := h.GetLogTagsForContext(ctxt)
+	removed, err := h.client.PruneDecisions(ctxt, timestamp.Add(-h.retention), h.maxEntries)
+	if err != nil {
+		return 0, err
+	}
+	log.WithFields(logTags).Debugf("Pruned %d decisions", removed)
+	return removed, nil
+}
+
+/*
+recordMatchedRule note the authorization rule the request matched in its decision record
+
+	@param record *models.DecisionRecord - the decision record
+	@param rule *match.MatchedRule - the authorization rule the request matched
+*/
+func recordMatchedRule(record *models.DecisionRecord, rule *match.MatchedRule) {
+	if rule == nil {
+		return
+	}
+	record.RuleHost = rule.Host
+	record.RulePathPattern = rule.PathPattern
+	record.RuleMethod = rule.Method
+}
+
+/*
+recordOutcome note the outcome of the request in its decision record
+
+	@param record *models.DecisionRecord - the decision record
+	@param response interface{} - the response of the decision
+	@param allowed bool - whether the request was allowed
+	@param timestamp time.Time - when the decision was made
+*/
+func recordOutcome(
+	record *models.DecisionRecord, response interface{}, allowed bool, timestamp time.Time,
+) {
+	record.Timestamp = timestamp
+	record.Allowed = allowed
+	switch errResp := response.(type) {
+	case RespError:
+		record.Code = string(errResp.Code)
+	case RespDenied:
+		record.Code = string(errResp.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDecisionHistory(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+	historyClient, err := models.CreateDecisionHistoryDBClient(db)
+	assert.Nil(err)
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/orders$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	// Only two decisions may be queued between flushes
+	history := DefineDecisionHistory(historyClient, time.Hour, 0, 2)
+
+	authz, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			History: history,
+		},
+	)
+	assert.Nil(err)
+	mgmt, err := defineUserManagementHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		supportMatch,
+		nil,
+		nil,
+		nil,
+		history,
+	)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	otherUser := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: otherUser}, nil,
+	))
+
+	checkAllow := func(userID, path string) int {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, path)
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, userID)
+		respRecorder := httptest.NewRecorder()
+		handler := authz.LoggingMiddleware(authz.ParamReadMiddleware(authz.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder.Code
+	}
+	listDecisions := func(query string) (int, RespListDecisions) {
+		req, err := http.NewRequest("GET", "/v1/decisions?"+query, nil)
+		assert.Nil(err)
+		respRecorder := httptest.NewRecorder()
+		mgmt.LoggingMiddleware(mgmt.ListDecisionsHandler()).ServeHTTP(respRecorder, req)
+		var resp RespListDecisions
+		if respRecorder.Code == http.StatusOK {
+			assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &resp))
+		}
+		return respRecorder.Code, resp
+	}
+
+	// Case 0: decisions are recorded
+	start := time.Now().UTC().Add(-time.Second)
+	assert.Equal(http.StatusOK, checkAllow(reader, "/orders"))
+	assert.Equal(http.StatusForbidden, checkAllow(otherUser, "/orders"))
+	{
+		respCode, resp := listDecisions("user=" + reader)
+		assert.Equal(http.StatusOK, respCode)
+		assert.Len(resp.Decisions, 1)
+		assert.True(resp.Decisions[0].Allowed)
+		assert.Equal("/orders", resp.Decisions[0].Path)
+		assert.Equal(`^/orders$`, resp.Decisions[0].RulePathPattern)
+		assert.Empty(resp.Decisions[0].Code)
+		respCode, resp = listDecisions("user=" + otherUser)
+		assert.Equal(http.StatusOK, respCode)
+		assert.Len(resp.Decisions, 1)
+		assert.False(resp.Decisions[0].Allowed)
+		assert.Equal(string(ErrCodePermissionDenied), resp.Decisions[0].Code)
+	}
+
+	// Case 1: requests not matching any rule are recorded as denied
+	assert.Equal(http.StatusForbidden, checkAllow(reader, "/unknown"))
+
+	// Case 2: decisions over the queue limit are dropped
+	for count := 0; count < 3; count++ {
+		assert.Equal(http.StatusOK, checkAllow(reader, "/orders"))
+	}
+	{
+		respCode, resp := listDecisions("user=" + reader)
+		assert.Equal(http.StatusOK, respCode)
+		assert.Len(resp.Decisions, 3)
+		assert.True(resp.Decisions[0].Allowed)
+		assert.Equal("/unknown", resp.Decisions[1].Path)
+		assert.False(resp.Decisions[1].Allowed)
+		assert.Empty(resp.Decisions[1].RulePathPattern)
+	}
+
+	// Case 3: query by time range and limit
+	{
+		respCode, resp := listDecisions(
+			"since=" + start.Format(time.RFC3339) + "&limit=2",
+		)
+		assert.Equal(http.StatusOK, respCode)
+		assert.Len(resp.Decisions, 2)
+		respCode, resp = listDecisions("until=" + start.Format(time.RFC3339))
+		assert.Equal(http.StatusOK, respCode)
+		assert.Empty(resp.Decisions)
+	}
+
+	// Case 4: invalid filters
+	for _, query := range []string{"since=yesterday", "limit=0", "user=not_valid!"} {
+		respCode, _ := listDecisions(query)
+		assert.Equal(http.StatusBadRequest, respCode, query)
+	}
+
+	// Case 5: decisions outside the retention window are pruned
+	{
+		removed, err := history.Prune(context.Background(), time.Now().UTC().Add(time.Hour*2))
+		assert.Nil(err)
+		assert.Equal(int64(4), removed)
+	}
+
+	// Case 6: decision history not enabled
+	{
+		uut, err := defineUserManagementHandler(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+			mgmtCore,
+			supportMatch,
+			nil,
+			nil,
+			nil,
+			nil,
+		)
+		assert.Nil(err)
+		req, err := http.NewRequest("GET", "/v1/decisions", nil)
+		assert.Nil(err)
+		respRecorder := httptest.NewRecorder()
+		uut.ListDecisionsHandler().ServeHTTP(respRecorder, req)
+		assert.Equal(http.StatusNotFound, respRecorder.Code)
+	}
+}
@@ -0,0 +1,248 @@
+package apis
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/match"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Authorization decisions recorded by DecisionMetricsHelper
+const (
+	// AuthorizeDecisionAllow the request was allowed
+	AuthorizeDecisionAllow = "allow"
+	// AuthorizeDecisionDeny the request was denied
+	AuthorizeDecisionDeny = "deny"
+	// AuthorizeDecisionUnknownUser the request was denied, as the user is not known
+	AuthorizeDecisionUnknownUser = "unknown_user"
+)
+
+// Authentication outcomes recorded by DecisionMetricsHelper
+const (
+	// AuthenticateOutcomeValid the request was authenticated
+	AuthenticateOutcomeValid = "valid"
+	// AuthenticateOutcomeBypass the request matched the authentication bypass rules
+	AuthenticateOutcomeBypass = "bypass"
+	// AuthenticateOutcomeMissing the request carried no credentials
+	AuthenticateOutcomeMissing = "missing"
+	// AuthenticateOutcomeExpired the credentials have expired
+	AuthenticateOutcomeExpired = "expired"
+	// AuthenticateOutcomeBadSignature the token signature failed verification
+	AuthenticateOutcomeBadSignature = "bad_signature"
+	// AuthenticateOutcomeRevoked introspection reported the token is no longer active
+	AuthenticateOutcomeRevoked = "introspection_revoked"
+	// AuthenticateOutcomeInvalid the credentials are otherwise not valid
+	AuthenticateOutcomeInvalid = "invalid"
+	// AuthenticateOutcomeError the credentials could not be checked
+	AuthenticateOutcomeError = "error"
+)
+
+// decisionLabelNoRule is the host and path pattern label of a decision not matching any rule
+const decisionLabelNoRule = "none"
+
+// DecisionMetricsHelper records the authorization decisions and authentication outcomes
+type DecisionMetricsHelper interface {
+	/*
+		RecordAuthorization record one authorization decision
+
+		 @param host string - the host of the matched authorization rule
+		 @param pathPattern string - the path pattern of the matched authorization rule
+		 @param decision string - the decision
+		 @param latency time.Duration - the decision processing latency
+	*/
+	RecordAuthorization(host, pathPattern, decision string, latency time.Duration)
+
+	/*
+		RecordAuthentication record one authentication outcome
+
+		 @param host string - the host of the request being authenticated
+		 @param outcome string - the outcome
+		 @param latency time.Duration - the authentication processing latency
+	*/
+	RecordAuthentication(host, outcome string, latency time.Duration)
+}
+
+// decisionMetricsHelperImpl implements DecisionMetricsHelper
+type decisionMetricsHelperImpl struct {
+	lock                sync.Mutex
+	maxHosts            int
+	seenHosts           map[string]bool
+	authorizeTracker    *prometheus.CounterVec
+	authorizeLatency    *prometheus.CounterVec
+	authenticateTracker *prometheus.CounterVec
+	authenticateLatency *prometheus.CounterVec
+}
+
+/*
+DefineDecisionMetricsHelper define a new DecisionMetricsHelper
+
+	@param collector goutils.MetricsCollector - the metrics collector to install the metrics with
+	@param maxHosts int - max number of distinct request host labels to record for the
+	authentication outcomes. Requests for additional hosts are recorded under the "other" host.
+	@return new DecisionMetricsHelper instance
+*/
+func DefineDecisionMetricsHelper(
+	collector goutils.MetricsCollector, maxHosts int,
+) (DecisionMetricsHelper, error) {
+	authorizeLabels := []string{"host", "path_pattern", "decision"}
+	authorizeTracker, err := collector.InstallCustomCounterVecMetrics(
+		context.Background(),
+		"authorization_decision_total",
+		"Authorization decision tracking by matched rule",
+		authorizeLabels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	authorizeLatency, err := collector.InstallCustomCounterVecMetrics(
+		context.Background(),
+		"authorization_decision_latency_secs_total",
+		"Authorization decision latency tracking by matched rule",
+		authorizeLabels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	authenticateLabels := []string{"host", "outcome"}
+	authenticateTracker, err := collector.InstallCustomCounterVecMetrics(
+		context.Background(),
+		"authentication_outcome_total",
+		"Authentication outcome tracking by request host",
+		authenticateLabels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	authenticateLatency, err := collector.InstallCustomCounterVecMetrics(
+		context.Background(),
+		"authentication_outcome_latency_secs_total",
+		"Authentication latency tracking by request host",
+		authenticateLabels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &decisionMetricsHelperImpl{
+		lock:                sync.Mutex{},
+		maxHosts:            maxHosts,
+		seenHosts:           map[string]bool{},
+		authorizeTracker:    authorizeTracker,
+		authorizeLatency:    authorizeLatency,
+		authenticateTracker: authenticateTracker,
+		authenticateLatency: authenticateLatency,
+	}, nil
+}
+
+// hostLabel get the request host label to use, applying the host label cap
+func (m *decisionMetricsHelperImpl) hostLabel(host string) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.seenHosts[host] {
+		return host
+	}
+	if len(m.seenHosts) >= m.maxHosts {
+		return routeLabelOther
+	}
+	m.seenHosts[host] = true
+	return host
+}
+
+/*
+RecordAuthorization record one authorization decision
+
+	@param host string - the host of the matched authorization rule
+	@param pathPattern string - the path pattern of the matched authorization rule
+	@param decision string - the decision
+	@param latency time.Duration - the decision processing latency
+*/
+func (m *decisionMetricsHelperImpl) RecordAuthorization(
+	host, pathPattern, decision string, latency time.Duration,
+) {
+	labels := prometheus.Labels{"host": host, "path_pattern": pathPattern, "decision": decision}
+	m.authorizeTracker.With(labels).Inc()
+	m.authorizeLatency.With(labels).Add(latency.Seconds())
+}
+
+/*
+RecordAuthentication record one authentication outcome
+
+	@param host string - the host of the request being authenticated
+	@param outcome string - the outcome
+	@param latency time.Duration - the authentication processing latency
+*/
+func (m *decisionMetricsHelperImpl) RecordAuthentication(
+	host, outcome string, latency time.Duration,
+) {
+	labels := prometheus.Labels{"host": m.hostLabel(host), "outcome": outcome}
+	m.authenticateTracker.With(labels).Inc()
+	m.authenticateLatency.With(labels).Add(latency.Seconds())
+}
+
+/*
+authorizeDecisionFor classify the conclusion of an authorization request
+
+	@param respCode int - the response code
+	@param response interface{} - the response
+	@param unknownUser bool - whether the user was not known
+	@return the decision, and whether the request concluded with a decision
+*/
+func authorizeDecisionFor(respCode int, response interface{}, unknownUser bool) (string, bool) {
+	switch {
+	case respCode == http.StatusOK:
+		return AuthorizeDecisionAllow, true
+	case respCode != http.StatusForbidden && respCode != http.StatusTooManyRequests:
+		return "", false
+	}
+	if resp, ok := response.(RespError); unknownUser || (ok && resp.Code == ErrCodeUserNotFound) {
+		return AuthorizeDecisionUnknownUser, true
+	}
+	return AuthorizeDecisionDeny, true
+}
+
+/*
+authenticateOutcomeFor classify the conclusion of an authentication request
+
+	@param respCode int - the response code
+	@param response interface{} - the response
+	@return the outcome
+*/
+func authenticateOutcomeFor(respCode int, response interface{}) string {
+	if respCode == http.StatusOK {
+		return AuthenticateOutcomeValid
+	}
+	if respCode >= http.StatusInternalServerError {
+		return AuthenticateOutcomeError
+	}
+	resp, ok := response.(RespError)
+	if !ok {
+		return AuthenticateOutcomeInvalid
+	}
+	switch resp.Code {
+	case ErrCodeTokenMissing:
+		return AuthenticateOutcomeMissing
+	case ErrCodeTokenExpired:
+		return AuthenticateOutcomeExpired
+	case ErrCodeTokenInactive:
+		return AuthenticateOutcomeRevoked
+	case ErrCodeInternal:
+		return AuthenticateOutcomeError
+	}
+	return AuthenticateOutcomeInvalid
+}
+
+/*
+ruleLabels the host and path pattern labels of the matched authorization rule
+
+	@param rule *match.MatchedRule - the matched rule, if any
+	@return the host and path pattern labels
+*/
+func ruleLabels(rule *match.MatchedRule) (string, string) {
+	if rule == nil {
+		return decisionLabelNoRule, decisionLabelNoRule
+	}
+	return rule.Host, rule.PathPattern
+}
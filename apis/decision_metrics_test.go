@@ -0,0 +1,276 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// failingOpenIDClient rejects a fixed set of tokens with the given validation errors
+type failingOpenIDClient struct {
+	fakeOpenIDClient
+	failures map[string]uint32
+}
+
+func (c failingOpenIDClient) ParseJWT(raw string, claimStore jwt.Claims) (*jwt.Token, error) {
+	if flags, ok := c.failures[raw]; ok {
+		return nil, jwt.NewValidationError("unit-test failure", flags)
+	}
+	return c.fakeOpenIDClient.ParseJWT(raw, claimStore)
+}
+
+func TestDecisionMetricsClassification(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case 0: authorization decisions
+	{
+		decision, ok := authorizeDecisionFor(http.StatusOK, nil, false)
+		assert.True(ok)
+		assert.Equal(AuthorizeDecisionAllow, decision)
+		decision, ok = authorizeDecisionFor(
+			http.StatusForbidden, RespError{Code: ErrCodePermissionDenied}, false,
+		)
+		assert.True(ok)
+		assert.Equal(AuthorizeDecisionDeny, decision)
+		decision, ok = authorizeDecisionFor(
+			http.StatusForbidden, RespError{Code: ErrCodeUserNotFound}, false,
+		)
+		assert.True(ok)
+		assert.Equal(AuthorizeDecisionUnknownUser, decision)
+		decision, ok = authorizeDecisionFor(
+			http.StatusForbidden, RespError{Code: ErrCodePermissionDenied}, true,
+		)
+		assert.True(ok)
+		assert.Equal(AuthorizeDecisionUnknownUser, decision)
+		decision, ok = authorizeDecisionFor(http.StatusTooManyRequests, nil, false)
+		assert.True(ok)
+		assert.Equal(AuthorizeDecisionDeny, decision)
+		_, ok = authorizeDecisionFor(http.StatusBadRequest, nil, false)
+		assert.False(ok)
+	}
+
+	// Case 1: matched rule labels
+	{
+		host, pathPattern := ruleLabels(nil)
+		assert.Equal(decisionLabelNoRule, host)
+		assert.Equal(decisionLabelNoRule, pathPattern)
+		host, pathPattern = ruleLabels(&match.MatchedRule{Host: "*", PathPattern: "^/path1$"})
+		assert.Equal("*", host)
+		assert.Equal("^/path1$", pathPattern)
+	}
+}
+
+func TestAuthenticationOutcomeMetrics(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	collector, err := goutils.GetNewMetricsCollector(
+		log.Fields{"module": "goutils", "component": "metrics-core"}, []goutils.LogMetadataModifier{},
+	)
+	assert.Nil(err)
+	helper, err := DefineDecisionMetricsHelper(collector, 2)
+	assert.Nil(err)
+	metrics, ok := helper.(*decisionMetricsHelperImpl)
+	assert.True(ok)
+
+	oidClient := failingOpenIDClient{
+		fakeOpenIDClient: fakeOpenIDClient{
+			tokens: map[string]jwt.MapClaims{"good-token": {"sub": "alice"}},
+		},
+		failures: map[string]uint32{
+			"expired-token":   jwt.ValidationErrorExpired,
+			"forged-token":    jwt.ValidationErrorSignatureInvalid,
+			"malformed-token": jwt.ValidationErrorMalformed,
+		},
+	}
+	reqParams := common.AuthenticateRequestParamLocConfig{
+		Host: "X-Forwarded-Host", Path: "X-Forwarded-Uri", Method: "X-Forwarded-Method",
+	}
+	uut, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		oidClient,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			TargetClaims:         common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+			RequestParamLocation: reqParams,
+		},
+		common.AuthorizeRequestParamLocConfig{UserID: "X-Caller-UserID"},
+		nil,
+		helper,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	authenticate := func(host, token string) {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Add(reqParams.Host, host)
+		if token != "" {
+			req.Header.Add("Authorization", "Bearer "+token)
+		}
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+	}
+	outcomeCount := func(host, outcome string) float64 {
+		return testutil.ToFloat64(metrics.authenticateTracker.With(prometheus.Labels{
+			"host": host, "outcome": outcome,
+		}))
+	}
+
+	// Case 0: outcomes are labelled by request host
+	authenticate("unit-test-1.org", "good-token")
+	authenticate("unit-test-1.org", "good-token")
+	authenticate("unit-test-2.org", "good-token")
+	assert.Equal(2.0, outcomeCount("unit-test-1.org", AuthenticateOutcomeValid))
+	assert.Equal(1.0, outcomeCount("unit-test-2.org", AuthenticateOutcomeValid))
+
+	// Case 1: failed authentications
+	authenticate("unit-test-1.org", "")
+	authenticate("unit-test-1.org", "expired-token")
+	authenticate("unit-test-1.org", "forged-token")
+	authenticate("unit-test-1.org", "malformed-token")
+	assert.Equal(1.0, outcomeCount("unit-test-1.org", AuthenticateOutcomeMissing))
+	assert.Equal(1.0, outcomeCount("unit-test-1.org", AuthenticateOutcomeExpired))
+	assert.Equal(1.0, outcomeCount("unit-test-1.org", AuthenticateOutcomeBadSignature))
+	assert.Equal(1.0, outcomeCount("unit-test-1.org", AuthenticateOutcomeInvalid))
+
+	// Case 2: hosts past the label cap are recorded as "other"
+	authenticate("unit-test-3.org", "good-token")
+	assert.Equal(1.0, outcomeCount(routeLabelOther, AuthenticateOutcomeValid))
+
+	// Case 3: authorization decisions are labelled by matched rule
+	metrics.RecordAuthorization("*", "^/path1$", AuthorizeDecisionDeny, time.Millisecond)
+	assert.Equal(1.0, testutil.ToFloat64(metrics.authorizeTracker.With(prometheus.Labels{
+		"host": "*", "path_pattern": "^/path1$", "decision": AuthorizeDecisionDeny,
+	})))
+}
+
+func TestAuthorizationDecisionMetrics(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/path1$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	collector, err := goutils.GetNewMetricsCollector(
+		log.Fields{"module": "goutils", "component": "metrics-core"}, []goutils.LogMetadataModifier{},
+	)
+	assert.Nil(err)
+	helper, err := DefineDecisionMetricsHelper(collector, 10)
+	assert.Nil(err)
+	metrics, ok := helper.(*decisionMetricsHelperImpl)
+	assert.True(ok)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			DecisionMetrics: helper,
+		},
+	)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+	nobody := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(context.Background(), models.UserConfig{UserID: nobody}, nil))
+
+	checkAllow := func(path, userID string, expected int) {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, path)
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, userID)
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		assert.Equal(expected, respRecorder.Code)
+	}
+	decisionCount := func(pathPattern, decision string) float64 {
+		host := "*"
+		if pathPattern == decisionLabelNoRule {
+			host = decisionLabelNoRule
+		}
+		return testutil.ToFloat64(metrics.authorizeTracker.With(prometheus.Labels{
+			"host": host, "path_pattern": pathPattern, "decision": decision,
+		}))
+	}
+
+	// Case 0: decisions are labelled by the matched rule
+	checkAllow("/path1", reader, http.StatusOK)
+	checkAllow("/path1", nobody, http.StatusForbidden)
+	checkAllow("/path1", uuid.New().String(), http.StatusForbidden)
+	assert.Equal(1.0, decisionCount(`^/path1$`, AuthorizeDecisionAllow))
+	assert.Equal(1.0, decisionCount(`^/path1$`, AuthorizeDecisionDeny))
+	assert.Equal(1.0, decisionCount(`^/path1$`, AuthorizeDecisionUnknownUser))
+
+	// Case 1: requests not matching any rule are denied without a rule label
+	checkAllow("/path2", reader, http.StatusForbidden)
+	assert.Equal(1.0, decisionCount(decisionLabelNoRule, AuthorizeDecisionDeny))
+}
@@ -0,0 +1,111 @@
+package apis
+
+import (
+	"errors"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+)
+
+// ErrorCode is a stable machine-readable identifier for an API error
+type ErrorCode string
+
+const (
+	// ErrCodeInvalidRequest the request parameters are missing, malformed, or not valid
+	ErrCodeInvalidRequest ErrorCode = "INVALID_REQUEST"
+	// ErrCodeUserNotFound the user is not on record
+	ErrCodeUserNotFound ErrorCode = "USER_NOT_FOUND"
+	// ErrCodeGroupNotFound the group is not on record
+	ErrCodeGroupNotFound ErrorCode = "GROUP_NOT_FOUND"
+	// ErrCodeAPIKeyNotFound the API key is not on record
+	ErrCodeAPIKeyNotFound ErrorCode = "API_KEY_NOT_FOUND"
+	// ErrCodeRoleUnknown the role is not in the role configuration
+	ErrCodeRoleUnknown ErrorCode = "ROLE_UNKNOWN"
+	// ErrCodePermissionUnknown the permission is not assigned to any role in the role
+	// configuration
+	ErrCodePermissionUnknown ErrorCode = "PERMISSION_UNKNOWN"
+	// ErrCodePermissionDenied the user does not have the permissions needed for the request
+	ErrCodePermissionDenied ErrorCode = "PERMISSION_DENIED"
+	// ErrCodeInsufficientScope the token of the request lacks the OAuth2 scopes needed for the
+	// request
+	ErrCodeInsufficientScope ErrorCode = "INSUFFICIENT_SCOPE"
+	// ErrCodeQuotaExceeded the user has exceeded a request quota
+	ErrCodeQuotaExceeded ErrorCode = "QUOTA_EXCEEDED"
+	// ErrCodeRateLimited the caller, or all callers together, exceeded the request rate limit
+	ErrCodeRateLimited ErrorCode = "RATE_LIMITED"
+	// ErrCodeOverloaded the request was shed while the user database is slow or failing
+	ErrCodeOverloaded ErrorCode = "OVERLOADED"
+	// ErrCodeNoMatchingRule the request does not match any authorization rule
+	ErrCodeNoMatchingRule ErrorCode = "NO_MATCHING_RULE"
+	// ErrCodeTokenMissing the request does not carry a bearer token
+	ErrCodeTokenMissing ErrorCode = "TOKEN_MISSING"
+	// ErrCodeTokenInvalid the bearer token is malformed, or failed verification
+	ErrCodeTokenInvalid ErrorCode = "TOKEN_INVALID"
+	// ErrCodeTokenExpired the bearer token has expired
+	ErrCodeTokenExpired ErrorCode = "TOKEN_EXPIRED"
+	// ErrCodeTokenInactive the OpenID issuer reports the bearer token is no longer active
+	ErrCodeTokenInactive ErrorCode = "TOKEN_INACTIVE"
+	// ErrCodeClaimInvalid a required token claim is missing, or does not match expectation
+	ErrCodeClaimInvalid ErrorCode = "CLAIM_INVALID"
+	// ErrCodeAssertionInvalid the SAML response is malformed, or failed validation
+	ErrCodeAssertionInvalid ErrorCode = "ASSERTION_INVALID"
+	// ErrCodeAPIKeyInvalid the API key is not on record, or has expired
+	ErrCodeAPIKeyInvalid ErrorCode = "API_KEY_INVALID"
+	// ErrCodeCertificateInvalid the client certificate is malformed, failed verification, or
+	// lacks an identity
+	ErrCodeCertificateInvalid ErrorCode = "CERTIFICATE_INVALID"
+	// ErrCodeClientInvalid the client credentials of the calling backend are missing, or not
+	// accepted
+	ErrCodeClientInvalid ErrorCode = "CLIENT_INVALID"
+	// ErrCodeFeatureDisabled the requested feature is not enabled
+	ErrCodeFeatureDisabled ErrorCode = "FEATURE_DISABLED"
+	// ErrCodeNotReady the service is not ready
+	ErrCodeNotReady ErrorCode = "NOT_READY"
+	// ErrCodeInternal the request failed due to an internal error
+	ErrCodeInternal ErrorCode = "INTERNAL_ERROR"
+)
+
+// RespError is the API error response
+type RespError struct {
+	goutils.RestAPIBaseResponse
+	// Code is the machine-readable error code
+	Code ErrorCode `json:"code"`
+}
+
+/*
+newErrorResponse attach a machine-readable error code to a standard error response
+
+	@param base goutils.RestAPIBaseResponse - the standard error response
+	@param code ErrorCode - the error code
+	@return the error response
+*/
+func newErrorResponse(base goutils.RestAPIBaseResponse, code ErrorCode) RespError {
+	return RespError{RestAPIBaseResponse: base, Code: code}
+}
+
+/*
+errorCodeFor pick the error code for an error reported by the core logic
+
+	@param err error - the error
+	@param fallback ErrorCode - the error code to use if the error is not recognized
+	@return the error code
+*/
+func errorCodeFor(err error, fallback ErrorCode) ErrorCode {
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		return ErrCodeUserNotFound
+	case errors.Is(err, models.ErrGroupNotFound):
+		return ErrCodeGroupNotFound
+	case errors.Is(err, models.ErrAPIKeyNotFound):
+		return ErrCodeAPIKeyNotFound
+	case errors.Is(err, users.ErrAPIKeyInvalid):
+		return ErrCodeAPIKeyInvalid
+	case errors.Is(err, users.ErrRoleUnknown):
+		return ErrCodeRoleUnknown
+	case errors.Is(err, users.ErrPermissionUnknown):
+		return ErrCodePermissionUnknown
+	default:
+		return fallback
+	}
+}
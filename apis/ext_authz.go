@@ -0,0 +1,194 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// extAuthzDispatchKey is the context key marking an authorization request dispatched by the
+// Envoy "ext_authz" API
+type extAuthzDispatchKey struct{}
+
+// extAuthzServer implements the Envoy "ext_authz" Authorization gRPC service
+type extAuthzServer struct {
+	goutils.Component
+	authv3.UnimplementedAuthorizationServer
+	// handler is the HTTP handler of the authorization server
+	handler http.Handler
+	// allowPath is the path of the authorization API on the authorization server
+	allowPath string
+	// checkHeaders sets which headers carry the parameters of the request to authorize
+	checkHeaders common.AuthorizeRequestParamLocConfig
+}
+
+/*
+DefineExtAuthzServer define a new Envoy "ext_authz" Authorization gRPC service. The checks are
+answered by dispatching them to the authorization server, so they are processed the same way
+as the HTTP requests. The dispatched checks are answered in the standard response mode.
+
+	@param handler http.Handler - the HTTP handler of the authorization server
+	@param pathPrefix string - the authorization server's end-point path prefix
+	@param checkHeaders common.AuthorizeRequestParamLocConfig - param on which headers carry
+	the parameters of the request to authorize
+	@return new Authorization gRPC service instance
+*/
+func DefineExtAuthzServer(
+	handler http.Handler, pathPrefix string, checkHeaders common.AuthorizeRequestParamLocConfig,
+) authv3.AuthorizationServer {
+	return &extAuthzServer{
+		Component: goutils.Component{
+			LogTags: log.Fields{"module": "apis", "component": "ext-authz"},
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+			},
+		},
+		handler:      handler,
+		allowPath:    path.Join(pathPrefix, "/v1/allow"),
+		checkHeaders: checkHeaders,
+	}
+}
+
+/*
+BuildExtAuthzServer creates the gRPC server serving the Envoy "ext_authz" API
+
+	@param service authv3.AuthorizationServer - the Authorization gRPC service
+	@return the grpc.Server
+*/
+func BuildExtAuthzServer(service authv3.AuthorizationServer) *grpc.Server {
+	svr := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(svr, service)
+	return svr
+}
+
+/*
+Check authorize one request forwarded by Envoy
+
+	@param ctxt context.Context - the operating context
+	@param req *authv3.CheckRequest - the attributes of the request to authorize
+	@return the authorization decision
+*/
+func (s *extAuthzServer) Check(
+	ctxt context.Context, req *authv3.CheckRequest,
+) (*authv3.CheckResponse, error) {
+	logTags := s.GetLogTagsForContext(ctxt)
+
+	attributes := req.GetAttributes().GetRequest().GetHttp()
+	if attributes == nil {
+		err := fmt.Errorf("check request is missing the HTTP request attributes")
+		log.WithError(err).WithFields(logTags).Error("Unable to answer ext_authz check")
+		return extAuthzDenied(http.StatusBadRequest, nil, err.Error()), nil
+	}
+
+	// The decision is read from the standard response, whatever the configured response mode
+	allowReq, err := http.NewRequestWithContext(
+		context.WithValue(ctxt, extAuthzDispatchKey{}, true), http.MethodGet, s.allowPath, nil,
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to define ext_authz check request")
+		return extAuthzDenied(http.StatusInternalServerError, nil, err.Error()), nil
+	}
+	// Envoy forwards the HTTP/2 pseudo-headers among the request headers
+	for name, value := range attributes.GetHeaders() {
+		if strings.HasPrefix(name, ":") {
+			continue
+		}
+		allowReq.Header.Set(name, value)
+	}
+	allowReq.Header.Set(s.checkHeaders.Host, attributes.GetHost())
+	allowReq.Header.Set(s.checkHeaders.Path, attributes.GetPath())
+	allowReq.Header.Set(s.checkHeaders.Method, attributes.GetMethod())
+
+	resp := newCapturedResponse()
+	s.handler.ServeHTTP(resp, allowReq)
+
+	if resp.Code != http.StatusOK {
+		return extAuthzDenied(resp.Code, resp.Header(), resp.Body.String()), nil
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				ResponseHeadersToAdd: extAuthzHeaders(resp.Header()),
+			},
+		},
+	}, nil
+}
+
+/*
+extAuthzDenied form the ext_authz response denying a request
+
+	@param code int - the HTTP response code to answer the request with
+	@param header http.Header - the headers to answer the request with
+	@param body string - the body to answer the request with
+	@return the ext_authz response
+*/
+func extAuthzDenied(code int, header http.Header, body string) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(extAuthzStatusCode(code))},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode(code)},
+				Headers: extAuthzHeaders(header),
+				Body:    body,
+			},
+		},
+	}
+}
+
+/*
+extAuthzHeaders convert the authorization server response headers into ext_authz headers
+
+	@param header http.Header - the response headers
+	@return the ext_authz headers
+*/
+func extAuthzHeaders(header http.Header) []*corev3.HeaderValueOption {
+	result := []*corev3.HeaderValueOption{}
+	for name := range header {
+		// Envoy sets the framing of the response itself
+		if name == "Content-Length" {
+			continue
+		}
+		result = append(result, &corev3.HeaderValueOption{
+			Header: &corev3.HeaderValue{Key: name, Value: header.Get(name)},
+		})
+	}
+	return result
+}
+
+/*
+extAuthzStatusCode map the HTTP response code of the authorization server to a gRPC status code
+
+	@param code int - the HTTP response code
+	@return the gRPC status code
+*/
+func extAuthzStatusCode(code int) codes.Code {
+	switch {
+	case code == http.StatusOK:
+		return codes.OK
+	case code == http.StatusBadRequest:
+		return codes.InvalidArgument
+	case code == http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case code == http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case code == http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case code >= http.StatusInternalServerError:
+		return codes.Internal
+	default:
+		return codes.PermissionDenied
+	}
+}
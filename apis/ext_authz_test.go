@@ -0,0 +1,179 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestExtAuthzServer(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/orders$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	reader := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+
+	// The checks are answered the same way whatever the response mode of the HTTP responses
+	for _, mode := range []string{
+		common.AuthorizeResponseModeStandard,
+		common.AuthorizeResponseModeMinimal,
+		common.AuthorizeResponseModeAPIGateway,
+	} {
+		authz, err := defineAuthorizationHandler(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+			mgmtCore,
+			restRequestMatcher,
+			supportMatch,
+			paramLoc,
+			common.UnknownUserActionConfig{},
+			common.AuthorizeResponseConfig{Mode: mode},
+			nil,
+			AuthorizationOptions{},
+		)
+		assert.Nil(err)
+		router := mux.NewRouter()
+		router.Handle(
+			"/authz/v1/allow",
+			authz.LoggingMiddleware(authz.ParamReadMiddleware(authz.AllowHandler())),
+		)
+
+		// Serve the ext_authz API over an in-memory connection
+		listener := bufconn.Listen(1024 * 1024)
+		svr := BuildExtAuthzServer(DefineExtAuthzServer(router, "/authz", paramLoc))
+		go func() {
+			_ = svr.Serve(listener)
+		}()
+		conn, err := grpc.Dial(
+			"bufnet",
+			grpc.WithContextDialer(func(ctxt context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctxt)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		assert.Nil(err)
+		client := authv3.NewAuthorizationClient(conn)
+
+		check := func(method, path string, headers map[string]string) *authv3.CheckResponse {
+			ctxt, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			resp, err := client.Check(ctxt, &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{
+							Method:  method,
+							Host:    "unit-test.org",
+							Path:    path,
+							Headers: headers,
+						},
+					},
+				},
+			})
+			assert.Nil(err)
+			return resp
+		}
+
+		// Case 0: allowed request
+		{
+			resp := check("GET", "/orders", map[string]string{
+				":authority": "unit-test.org", "x-caller-userid": reader,
+			})
+			assert.Equal(int32(codes.OK), resp.GetStatus().GetCode(), mode)
+			assert.NotNil(resp.GetOkResponse())
+		}
+
+		// Case 1: user without permission
+		{
+			resp := check("POST", "/orders", map[string]string{"x-caller-userid": reader})
+			assert.Equal(int32(codes.PermissionDenied), resp.GetStatus().GetCode(), mode)
+			assert.Equal(http.StatusForbidden, int(resp.GetDeniedResponse().GetStatus().GetCode()))
+			assert.Contains(resp.GetDeniedResponse().GetBody(), string(ErrCodePermissionDenied))
+		}
+
+		// Case 2: unknown user
+		{
+			resp := check("GET", "/orders", map[string]string{"x-caller-userid": uuid.NewString()})
+			assert.Equal(int32(codes.PermissionDenied), resp.GetStatus().GetCode(), mode)
+			assert.Equal(http.StatusForbidden, int(resp.GetDeniedResponse().GetStatus().GetCode()))
+			assert.Contains(resp.GetDeniedResponse().GetBody(), string(ErrCodeUserNotFound))
+		}
+
+		// Case 3: check without HTTP request attributes
+		{
+			ctxt, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			resp, err := client.Check(ctxt, &authv3.CheckRequest{})
+			assert.Nil(err)
+			assert.Equal(int32(codes.InvalidArgument), resp.GetStatus().GetCode())
+		}
+
+		assert.Nil(conn.Close())
+		svr.Stop()
+	}
+}
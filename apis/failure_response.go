@@ -0,0 +1,199 @@
+package apis
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alwitt/padlock/common"
+)
+
+// FailurePage is the data available to the error page template
+type FailurePage struct {
+	// StatusCode is the HTTP response code
+	StatusCode int
+	// Status is the text of the HTTP response code
+	Status string
+	// Code is the machine-readable error code
+	Code ErrorCode
+	// Message is the human-readable error message
+	Message string
+	// RequestID is the request ID to match against logs
+	RequestID string
+	// ReturnTo is the URL of the original request
+	ReturnTo string
+	// LoginURL is the login URL, with the URL of the original request, if one is configured
+	LoginURL string
+}
+
+// failureResponseRule selects how to respond to failed requests to matching paths
+type failureResponseRule struct {
+	path   common.RegexCheck
+	action string
+}
+
+// failureResponder answers failed authentication or authorization requests in place of the
+// standard JSON error
+type failureResponder struct {
+	cfg      common.FailureResponseConfig
+	rules    []failureResponseRule
+	loginURL *url.URL
+	page     *template.Template
+}
+
+/*
+defineFailureResponder define a new failure responder
+
+	@param cfg common.FailureResponseConfig - the failure response config
+	@return the failure responder, or nil if the failure responses are not customized
+*/
+func defineFailureResponder(cfg common.FailureResponseConfig) (*failureResponder, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	instance := &failureResponder{cfg: cfg, rules: []failureResponseRule{}}
+	actions := map[string]bool{cfg.BrowserAction: true}
+	for _, rule := range cfg.Rules {
+		pattern, err := common.NewRegexCheck(rule.PathPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid failure response path pattern: %w", err)
+		}
+		instance.rules = append(
+			instance.rules, failureResponseRule{path: pattern, action: rule.Action},
+		)
+		actions[rule.Action] = true
+	}
+	if cfg.LoginURL != "" {
+		loginURL, err := url.Parse(cfg.LoginURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid login URL: %w", err)
+		}
+		instance.loginURL = loginURL
+	} else if actions[common.FailureActionRedirect] {
+		return nil, fmt.Errorf("the failure response redirect action requires a login URL")
+	}
+	if cfg.Template != "" {
+		page, err := template.ParseFiles(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse failure response template: %w", err)
+		}
+		instance.page = page
+	} else if actions[common.FailureActionTemplate] {
+		return nil, fmt.Errorf("the failure response template action requires a template")
+	}
+	return instance, nil
+}
+
+/*
+action select how to respond to a failed request
+
+	@param r *http.Request - the request being answered
+	@param path string - the path of the original request
+	@return the action
+*/
+func (f *failureResponder) action(r *http.Request, path string) string {
+	for _, rule := range f.rules {
+		if matched, err := rule.path.Match([]byte(path)); err == nil && matched {
+			return rule.action
+		}
+	}
+	if strings.Contains(r.Header.Get(f.cfg.AcceptHeader), "text/html") {
+		return f.cfg.BrowserAction
+	}
+	return common.FailureActionJSON
+}
+
+/*
+returnTo build the URL of the original request
+
+	@param r *http.Request - the request being answered
+	@param host string - the host of the original request
+	@param path string - the path of the original request
+	@return the URL of the original request
+*/
+func (f *failureResponder) returnTo(r *http.Request, host, path string) string {
+	scheme := r.Header.Get(f.cfg.SchemeHeader)
+	if scheme == "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
+
+/*
+loginRedirect build the login URL to redirect to
+
+	@param returnTo string - the URL of the original request
+	@return the login URL, with the URL of the original request
+*/
+func (f *failureResponder) loginRedirect(returnTo string) string {
+	if f.loginURL == nil {
+		return ""
+	}
+	redirect := *f.loginURL
+	query := redirect.Query()
+	query.Set(f.cfg.ReturnToParam, returnTo)
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
+
+/*
+write answer a failed request, if the request calls for a response other than the standard
+JSON error
+
+	@param w http.ResponseWriter - the response writer
+	@param r *http.Request - the request being answered
+	@param host string - the host of the original request
+	@param path string - the path of the original request
+	@param respCode int - the response code
+	@param response interface{} - the standard response
+	@return whether the response was written
+*/
+func (f *failureResponder) write(
+	w http.ResponseWriter,
+	r *http.Request,
+	host, path string,
+	respCode int,
+	response interface{},
+) (bool, error) {
+	action := f.action(r, path)
+	if action == common.FailureActionJSON {
+		return false, nil
+	}
+	returnTo := f.returnTo(r, host, path)
+	if action == common.FailureActionRedirect {
+		w.Header().Set("Location", f.loginRedirect(returnTo))
+		w.WriteHeader(http.StatusFound)
+		return true, nil
+	}
+	page := FailurePage{
+		StatusCode: respCode,
+		Status:     http.StatusText(respCode),
+		ReturnTo:   returnTo,
+		LoginURL:   f.loginRedirect(returnTo),
+	}
+	var base *RespError
+	switch errResp := response.(type) {
+	case RespError:
+		base = &errResp
+	case RespDenied:
+		base = &errResp.RespError
+	}
+	if base != nil {
+		page.Code = base.Code
+		page.RequestID = base.RequestID
+		if base.Error != nil {
+			page.Message = base.Error.Msg
+		}
+	}
+	rendered := bytes.Buffer{}
+	if err := f.page.Execute(&rendered, page); err != nil {
+		return false, err
+	}
+	w.Header().Set("content-type", f.cfg.TemplateContentType)
+	w.WriteHeader(respCode)
+	_, err := w.Write(rendered.Bytes())
+	return true, err
+}
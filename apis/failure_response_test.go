@@ -0,0 +1,193 @@
+package apis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureResponder(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	templateFile := filepath.Join(t.TempDir(), "error.html")
+	assert.Nil(os.WriteFile(
+		templateFile,
+		[]byte(`<p>{{.StatusCode}} {{.Code}} {{.Message}}</p><a href="{{.LoginURL}}">login</a>`),
+		0600,
+	))
+
+	baseCfg := common.FailureResponseConfig{
+		Enabled:             true,
+		BrowserAction:       common.FailureActionRedirect,
+		Rules:               []common.FailureResponseRuleConfig{},
+		AcceptHeader:        "Accept",
+		SchemeHeader:        "X-Forwarded-Proto",
+		LoginURL:            "https://login.unit-test.org/signin?app=portal",
+		ReturnToParam:       "return_to",
+		TemplateContentType: "text/html; charset=utf-8",
+	}
+
+	// Case 0: not enabled
+	{
+		uut, err := defineFailureResponder(common.FailureResponseConfig{})
+		assert.Nil(err)
+		assert.Nil(uut)
+	}
+
+	// Case 1: actions missing their settings
+	{
+		cfg := baseCfg
+		cfg.LoginURL = ""
+		_, err := defineFailureResponder(cfg)
+		assert.NotNil(err)
+		cfg = baseCfg
+		cfg.Rules = []common.FailureResponseRuleConfig{
+			{PathPattern: "^/docs", Action: common.FailureActionTemplate},
+		}
+		_, err = defineFailureResponder(cfg)
+		assert.NotNil(err)
+	}
+
+	cfg := baseCfg
+	cfg.Template = templateFile
+	cfg.Rules = []common.FailureResponseRuleConfig{
+		{PathPattern: "^/api/", Action: common.FailureActionJSON},
+		{PathPattern: "^/docs", Action: common.FailureActionTemplate},
+	}
+	uut, err := defineFailureResponder(cfg)
+	assert.Nil(err)
+	assert.NotNil(uut)
+
+	respond := func(accept, path string) (*httptest.ResponseRecorder, bool) {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		req.Header.Set("X-Forwarded-Proto", "http")
+		respRecorder := httptest.NewRecorder()
+		response := newErrorResponse(
+			goutils.RestAPIHandler{}.GetStdRESTErrorMsg(
+				req.Context(), http.StatusForbidden, "not allowed", "",
+			),
+			ErrCodePermissionDenied,
+		)
+		written, err := uut.write(
+			respRecorder, req, "portal.unit-test.org", path, http.StatusForbidden, response,
+		)
+		assert.Nil(err)
+		return respRecorder, written
+	}
+
+	// Case 2: non-browser request gets the JSON error
+	{
+		_, written := respond("application/json", "/home")
+		assert.False(written)
+	}
+
+	// Case 3: browser request is redirected to login
+	{
+		resp, written := respond("text/html,application/xhtml+xml", "/home?tab=1")
+		assert.True(written)
+		assert.Equal(http.StatusFound, resp.Code)
+		location, err := url.Parse(resp.Header().Get("Location"))
+		assert.Nil(err)
+		assert.Equal("login.unit-test.org", location.Host)
+		assert.Equal("portal", location.Query().Get("app"))
+		assert.Equal("http://portal.unit-test.org/home?tab=1", location.Query().Get("return_to"))
+	}
+
+	// Case 4: path rules take precedence over the accept header
+	{
+		_, written := respond("text/html", "/api/orders")
+		assert.False(written)
+		resp, written := respond("application/json", "/docs/intro")
+		assert.True(written)
+		assert.Equal(http.StatusForbidden, resp.Code)
+		assert.Equal("text/html; charset=utf-8", resp.Header().Get("content-type"))
+		assert.Contains(resp.Body.String(), "403 PERMISSION_DENIED not allowed")
+		assert.Contains(resp.Body.String(), "login.unit-test.org")
+	}
+}
+
+func TestAuthenticateFailureRedirect(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	oidClient := fakeOpenIDClient{
+		tokens: map[string]jwt.MapClaims{"good-token": {"sub": "alice"}},
+	}
+	reqHeaders := common.AuthenticateRequestParamLocConfig{
+		Host: "X-Forwarded-Host", Path: "X-Forwarded-Uri", Method: "X-Forwarded-Method",
+	}
+	uut, err := defineAuthenticationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		oidClient,
+		false,
+		nil,
+		common.AuthenticationConfig{
+			TargetClaims:         common.OpenIDClaimsOfInterestConfig{UserIDClaim: "sub"},
+			RequestParamLocation: reqHeaders,
+			FailureResponse: common.FailureResponseConfig{
+				Enabled:       true,
+				BrowserAction: common.FailureActionRedirect,
+				AcceptHeader:  "Accept",
+				SchemeHeader:  "X-Forwarded-Proto",
+				LoginURL:      "https://login.unit-test.org/signin",
+				ReturnToParam: "next",
+			},
+		},
+		common.AuthorizeRequestParamLocConfig{UserID: "X-Caller-UserID"},
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	runAuthenticate := func(accept, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.Header.Set(reqHeaders.Host, "portal.unit-test.org")
+		req.Header.Set(reqHeaders.Path, "/home")
+		req.Header.Set(reqHeaders.Method, "GET")
+		req.Header.Set("Accept", accept)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		respRecorder := httptest.NewRecorder()
+		uut.AuthenticateHandler().ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: authenticated browser request
+	assert.Equal(http.StatusOK, runAuthenticate("text/html", "good-token").Code)
+
+	// Case 1: unauthenticated browser request
+	{
+		resp := runAuthenticate("text/html", "")
+		assert.Equal(http.StatusFound, resp.Code)
+		assert.Equal(
+			"https://login.unit-test.org/signin?next=https%3A%2F%2Fportal.unit-test.org%2Fhome",
+			resp.Header().Get("Location"),
+		)
+	}
+
+	// Case 2: unauthenticated API request
+	{
+		resp := runAuthenticate("application/json", "")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeTokenMissing))
+	}
+}
@@ -0,0 +1,175 @@
+package apis
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+)
+
+// HealthCheck reports the health of one submodule or dependency. Returns nil if healthy.
+type HealthCheck func() error
+
+// HealthRegistry tracks the health checks of the enabled submodules and their dependencies
+type HealthRegistry interface {
+	/*
+		Register add a named health check. A later check with the same name replaces the
+		earlier one.
+
+		 @param name string - the submodule or dependency name
+		 @param check HealthCheck - the health check
+	*/
+	Register(name string, check HealthCheck)
+
+	/*
+		CheckAll run all the health checks
+
+		 @return whether all checks passed, and the result of each check
+	*/
+	CheckAll() (bool, map[string]ComponentHealth)
+}
+
+// ComponentHealth is the health of one submodule or dependency
+type ComponentHealth struct {
+	// Healthy whether the component is healthy
+	Healthy bool `json:"healthy"`
+	// Error is the reason the component is not healthy
+	Error string `json:"error,omitempty"`
+}
+
+// healthRegistryImpl implements HealthRegistry
+type healthRegistryImpl struct {
+	lock   sync.RWMutex
+	checks map[string]HealthCheck
+}
+
+/*
+DefineHealthRegistry define a new HealthRegistry
+
+	@return new HealthRegistry instance
+*/
+func DefineHealthRegistry() HealthRegistry {
+	return &healthRegistryImpl{lock: sync.RWMutex{}, checks: map[string]HealthCheck{}}
+}
+
+/*
+Register add a named health check. A later check with the same name replaces the earlier one.
+
+	@param name string - the submodule or dependency name
+	@param check HealthCheck - the health check
+*/
+func (r *healthRegistryImpl) Register(name string, check HealthCheck) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checks[name] = check
+}
+
+/*
+CheckAll run all the health checks
+
+	@return whether all checks passed, and the result of each check
+*/
+func (r *healthRegistryImpl) CheckAll() (bool, map[string]ComponentHealth) {
+	// Run the checks outside of the lock, as they may be slow
+	r.lock.RLock()
+	checks := make(map[string]HealthCheck, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	r.lock.RUnlock()
+
+	healthy := true
+	results := map[string]ComponentHealth{}
+	for name, check := range checks {
+		if err := check(); err != nil {
+			healthy = false
+			results[name] = ComponentHealth{Healthy: false, Error: err.Error()}
+		} else {
+			results[name] = ComponentHealth{Healthy: true}
+		}
+	}
+	return healthy, results
+}
+
+// ====================================================================================
+
+// HealthHandler the aggregated health REST API handler
+type HealthHandler struct {
+	goutils.RestAPIHandler
+	registry HealthRegistry
+}
+
+// defineHealthHandler define a new HealthHandler instance
+func defineHealthHandler(logConfig common.HTTPRequestLogging, registry HealthRegistry) HealthHandler {
+	logTags := log.Fields{
+		"module": "apis", "component": "api-handler", "instance": "health",
+	}
+
+	return HealthHandler{
+		RestAPIHandler: goutils.RestAPIHandler{
+			Component: goutils.Component{
+				LogTags: logTags,
+				LogTagModifiers: []goutils.LogMetadataModifier{
+					goutils.ModifyLogMetadataByRestRequestParam,
+				},
+			},
+			CallRequestIDHeaderField: &logConfig.RequestIDHeader,
+			DoNotLogHeaders: func() map[string]bool {
+				result := map[string]bool{}
+				for _, v := range logConfig.DoNotLogHeaders {
+					result[v] = true
+				}
+				return result
+			}(),
+			LogLevel: logConfig.HealthLogLevel,
+		},
+		registry: registry,
+	}
+}
+
+// RespHealth is the API response summarizing the health of the application
+type RespHealth struct {
+	goutils.RestAPIBaseResponse
+	// Healthy whether all the components are healthy
+	Healthy bool `json:"healthy"`
+	// Components is the health of each enabled submodule and dependency
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// Health godoc
+// @Summary Aggregated health check
+// @Description Summarize the health of every enabled submodule, and their dependencies
+// @tags Health
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Success 200 {object} RespHealth "success"
+// @Failure 404 {string} string "error"
+// @Failure 503 {object} RespHealth "one or more components are unhealthy"
+// @Router /healthz [get]
+func (h HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	logTags := h.GetLogTagsForContext(r.Context())
+	healthy, components := h.registry.CheckAll()
+	respCode := http.StatusOK
+	baseResp := h.GetStdRESTSuccessMsg(r.Context())
+	if !healthy {
+		respCode = http.StatusServiceUnavailable
+		baseResp.Success = false
+	}
+	if err := h.WriteRESTResponse(
+		w,
+		respCode,
+		RespHealth{RestAPIBaseResponse: baseResp, Healthy: healthy, Components: components},
+		nil,
+	); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to form response")
+	}
+}
+
+// HealthHandler Wrapper around Health
+func (h HealthHandler) HealthHandler() http.HandlerFunc {
+	return h.LoggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		h.Health(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	registry := DefineHealthRegistry()
+	uut := defineHealthHandler(common.HTTPRequestLogging{}, registry)
+
+	call := func() (int, RespHealth) {
+		req, err := http.NewRequest("GET", "/healthz", nil)
+		assert.Nil(err)
+		respRecorder := httptest.NewRecorder()
+		uut.HealthHandler().ServeHTTP(respRecorder, req)
+		var msg RespHealth
+		assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &msg))
+		return respRecorder.Code, msg
+	}
+
+	// Case 0: all components healthy
+	registry.Register(ServerNameAuthorization, func() error { return nil })
+	registry.Register("database", func() error { return nil })
+	{
+		code, msg := call()
+		assert.Equal(http.StatusOK, code)
+		assert.True(msg.Healthy)
+		assert.Len(msg.Components, 2)
+		assert.True(msg.Components["database"].Healthy)
+	}
+
+	// Case 1: one component unhealthy
+	registry.Register("database", func() error { return fmt.Errorf("connection refused") })
+	{
+		code, msg := call()
+		assert.Equal(http.StatusServiceUnavailable, code)
+		assert.False(msg.Healthy)
+		assert.False(msg.Success)
+		assert.True(msg.Components[ServerNameAuthorization].Healthy)
+		assert.False(msg.Components["database"].Healthy)
+		assert.Equal("connection refused", msg.Components["database"].Error)
+	}
+}
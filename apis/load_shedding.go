@@ -0,0 +1,198 @@
+package apis
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+)
+
+// LoadShedder decides whether to shed authorization requests while the user database is
+// slow or failing
+type LoadShedder interface {
+	/*
+		Admit decide whether a request may look up the user database. An admitted request must
+		report the outcome of the lookup with Done.
+
+			@param timestamp time.Time - the current timestamp
+			@return whether the request may proceed
+	*/
+	Admit(timestamp time.Time) bool
+
+	/*
+		Done report the outcome of a user database lookup by an admitted request
+
+			@param latency time.Duration - how long the lookup took
+			@param failed bool - whether the lookup failed
+			@param timestamp time.Time - the current timestamp
+	*/
+	Done(latency time.Duration, failed bool, timestamp time.Time)
+
+	/*
+		FailOpen whether a shed request for a path is allowed instead of rejected
+
+			@param path string - the request path
+			@return whether to allow the request
+	*/
+	FailOpen(path string) bool
+
+	/*
+		StatusCode the response code of a rejected request
+
+			@return the response code
+	*/
+	StatusCode() int
+
+	/*
+		RetryAfter the number of seconds a rejected caller should wait before retrying
+
+			@param timestamp time.Time - the current timestamp
+			@return the number of seconds to wait
+	*/
+	RetryAfter(timestamp time.Time) int64
+}
+
+// loadShedderImpl implements LoadShedder
+type loadShedderImpl struct {
+	config        common.LoadSheddingConfig
+	failOpenPaths []common.RegexCheck
+
+	lock sync.Mutex
+	// windowStart is when the current observation window started
+	windowStart time.Time
+	// lookups is the number of lookups completed within the current window
+	lookups int
+	// failures is the number of lookups failed within the current window
+	failures int
+	// latency is the total latency of the lookups completed within the current window
+	latency time.Duration
+	// inFlight is the number of lookups currently in progress
+	inFlight int
+	// shedUntil is when to stop shedding load
+	shedUntil time.Time
+}
+
+/*
+DefineLoadShedder define a new load shedder
+
+	@param config common.LoadSheddingConfig - the load shedding config
+	@return new LoadShedder instance
+*/
+func DefineLoadShedder(config common.LoadSheddingConfig) (LoadShedder, error) {
+	failOpenPaths := []common.RegexCheck{}
+	for _, pattern := range config.FailOpenPaths {
+		check, err := common.NewRegexCheck(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fail-open path pattern '%s': %w", pattern, err)
+		}
+		failOpenPaths = append(failOpenPaths, check)
+	}
+	return &loadShedderImpl{
+		config: config, failOpenPaths: failOpenPaths, lock: sync.Mutex{},
+	}, nil
+}
+
+/*
+Admit decide whether a request may look up the user database. An admitted request must
+report the outcome of the lookup with Done.
+
+	@param timestamp time.Time - the current timestamp
+	@return whether the request may proceed
+*/
+func (s *loadShedderImpl) Admit(timestamp time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if timestamp.Before(s.shedUntil) {
+		return false
+	}
+	if s.config.MaxInFlight > 0 && s.inFlight >= s.config.MaxInFlight {
+		return false
+	}
+	s.inFlight++
+	return true
+}
+
+/*
+Done report the outcome of a user database lookup by an admitted request
+
+	@param latency time.Duration - how long the lookup took
+	@param failed bool - whether the lookup failed
+	@param timestamp time.Time - the current timestamp
+*/
+func (s *loadShedderImpl) Done(latency time.Duration, failed bool, timestamp time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.inFlight > 0 {
+		s.inFlight--
+	}
+	window := time.Second * time.Duration(s.config.Window)
+	if !timestamp.Before(s.windowStart.Add(window)) {
+		s.resetWindow(timestamp)
+	}
+	s.lookups++
+	s.latency += latency
+	if failed {
+		s.failures++
+	}
+	if s.lookups < s.config.MinRequests {
+		return
+	}
+	threshold := time.Millisecond * time.Duration(s.config.LatencyThreshold)
+	tooSlow := threshold > 0 && s.latency/time.Duration(s.lookups) > threshold
+	tooFaulty := s.config.ErrorRatio > 0 &&
+		float64(s.failures)/float64(s.lookups) >= s.config.ErrorRatio
+	if tooSlow || tooFaulty {
+		s.shedUntil = timestamp.Add(time.Second * time.Duration(s.config.Cooldown))
+		s.resetWindow(s.shedUntil)
+	}
+}
+
+// resetWindow start a new observation window
+func (s *loadShedderImpl) resetWindow(start time.Time) {
+	s.windowStart = start
+	s.lookups = 0
+	s.failures = 0
+	s.latency = 0
+}
+
+/*
+FailOpen whether a shed request for a path is allowed instead of rejected
+
+	@param path string - the request path
+	@return whether to allow the request
+*/
+func (s *loadShedderImpl) FailOpen(path string) bool {
+	for _, check := range s.failOpenPaths {
+		if matched, err := check.Match([]byte(path)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+StatusCode the response code of a rejected request
+
+	@return the response code
+*/
+func (s *loadShedderImpl) StatusCode() int {
+	return s.config.StatusCode
+}
+
+/*
+RetryAfter the number of seconds a rejected caller should wait before retrying
+
+	@param timestamp time.Time - the current timestamp
+	@return the number of seconds to wait
+*/
+func (s *loadShedderImpl) RetryAfter(timestamp time.Time) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	wait := int64(math.Ceil(s.shedUntil.Sub(timestamp).Seconds()))
+	if wait < 1 {
+		return 1
+	}
+	return wait
+}
@@ -0,0 +1,222 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestLoadShedder(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	config := common.LoadSheddingConfig{
+		Enabled:          true,
+		Window:           10,
+		MinRequests:      4,
+		LatencyThreshold: 100,
+		ErrorRatio:       0.5,
+		Cooldown:         5,
+		MaxInFlight:      2,
+		StatusCode:       http.StatusServiceUnavailable,
+		FailOpenPaths:    []string{`^/status$`},
+	}
+
+	// Case 0: invalid fail-open path pattern
+	{
+		badConfig := config
+		badConfig.FailOpenPaths = []string{`^/status($`}
+		_, err := DefineLoadShedder(badConfig)
+		assert.NotNil(err)
+	}
+
+	uut, err := DefineLoadShedder(config)
+	assert.Nil(err)
+	assert.Equal(http.StatusServiceUnavailable, uut.StatusCode())
+	assert.True(uut.FailOpen("/status"))
+	assert.False(uut.FailOpen("/orders"))
+
+	timestamp := time.Unix(1200, 0).UTC()
+
+	// Case 1: shed requests beyond the concurrent lookup limit
+	assert.True(uut.Admit(timestamp))
+	assert.True(uut.Admit(timestamp))
+	assert.False(uut.Admit(timestamp))
+	uut.Done(time.Millisecond, false, timestamp)
+	assert.True(uut.Admit(timestamp))
+	uut.Done(time.Millisecond, false, timestamp)
+	uut.Done(time.Millisecond, false, timestamp)
+
+	// Case 2: failures below the minimum number of lookups are tolerated
+	timestamp = timestamp.Add(time.Second * 10)
+	for itr := 0; itr < 3; itr++ {
+		assert.True(uut.Admit(timestamp))
+		uut.Done(time.Millisecond, true, timestamp)
+	}
+	assert.True(uut.Admit(timestamp))
+
+	// Case 3: shed load once the failure ratio is reached
+	uut.Done(time.Millisecond, true, timestamp)
+	assert.False(uut.Admit(timestamp))
+	assert.Equal(int64(5), uut.RetryAfter(timestamp))
+	assert.False(uut.Admit(timestamp.Add(time.Second * 4)))
+
+	// Case 4: resume after the cooldown
+	timestamp = timestamp.Add(time.Second * 5)
+	for itr := 0; itr < 4; itr++ {
+		assert.True(uut.Admit(timestamp))
+		uut.Done(time.Millisecond*50, false, timestamp)
+	}
+	assert.True(uut.Admit(timestamp))
+	uut.Done(time.Millisecond*50, false, timestamp)
+
+	// Case 5: shed load once the average latency exceeds the threshold
+	timestamp = timestamp.Add(time.Second * 10)
+	for itr := 0; itr < 3; itr++ {
+		assert.True(uut.Admit(timestamp))
+		uut.Done(time.Millisecond*200, false, timestamp)
+	}
+	assert.True(uut.Admit(timestamp))
+	uut.Done(time.Millisecond*200, false, timestamp)
+	assert.False(uut.Admit(timestamp.Add(time.Second)))
+}
+
+func TestAuthorizationWithLoadShedding(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/orders$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+					{
+						PathPattern:          `^/status$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	shedder, err := DefineLoadShedder(common.LoadSheddingConfig{
+		Enabled:       true,
+		Window:        60,
+		MinRequests:   1,
+		ErrorRatio:    0.25,
+		Cooldown:      60,
+		StatusCode:    http.StatusTooManyRequests,
+		FailOpenPaths: []string{`^/status$`},
+	})
+	assert.Nil(err)
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Shedder: shedder,
+		},
+	)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+
+	checkAllow := func(userID, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, path)
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, userID)
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: healthy database, unknown users are not failures
+	assert.Equal(http.StatusOK, checkAllow(reader, "/orders").Code)
+	assert.Equal(http.StatusForbidden, checkAllow(uuid.New().String(), "/orders").Code)
+	assert.Equal(http.StatusOK, checkAllow(reader, "/orders").Code)
+
+	// Case 1: the database starts failing
+	{
+		sqlDB, err := db.DB()
+		assert.Nil(err)
+		assert.Nil(sqlDB.Close())
+	}
+	{
+		resp := checkAllow(reader, "/orders")
+		assert.NotEqual(http.StatusOK, resp.Code)
+		assert.NotEqual(http.StatusTooManyRequests, resp.Code)
+	}
+
+	// Case 2: requests are shed
+	{
+		resp := checkAllow(reader, "/orders")
+		assert.Equal(http.StatusTooManyRequests, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeOverloaded))
+		assert.NotEmpty(resp.Header().Get("Retry-After"))
+	}
+
+	// Case 3: requests on the fail-open paths are allowed
+	assert.Equal(http.StatusOK, checkAllow(reader, "/status").Code)
+}
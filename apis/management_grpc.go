@@ -0,0 +1,574 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/managementpb"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// managementGRPCServer implements the gRPC user management API
+type managementGRPCServer struct {
+	goutils.Component
+	managementpb.UnimplementedUserManagementServer
+	core     users.Management
+	validate *validator.Validate
+}
+
+/*
+DefineManagementGRPCServer define a new gRPC user management API service. The calls are
+validated the same way as the user management REST API requests.
+
+	@param core users.Management - core user management logic block
+	@param validateSupport common.CustomFieldValidator - customer validator support object
+	@return new user management gRPC service instance
+*/
+func DefineManagementGRPCServer(
+	core users.Management, validateSupport common.CustomFieldValidator,
+) (managementpb.UserManagementServer, error) {
+	validate := validator.New()
+	if err := validateSupport.RegisterWithValidator(validate); err != nil {
+		return nil, err
+	}
+	return &managementGRPCServer{
+		Component: goutils.Component{
+			LogTags: log.Fields{"module": "apis", "component": "management-grpc"},
+		},
+		core:     core,
+		validate: validate,
+	}, nil
+}
+
+/*
+BuildManagementGRPCServer creates the gRPC server serving the user management API
+
+	@param service managementpb.UserManagementServer - the user management gRPC service
+	@param tlsCfg common.ServerTLSConfig - the TLS config of the server
+	@param adminAuth common.ManagementAdminAuthConfig - how the callers are authenticated, the
+	same way as the callers of the user management REST API
+	@param tokens BearerTokenVerifier - if admin authentication is enabled, verifies the callers'
+	bearer tokens the same way as the authentication server
+	@param manager users.Management - core user management logic block
+	@return the grpc.Server
+*/
+func BuildManagementGRPCServer(
+	service managementpb.UserManagementServer,
+	tlsCfg common.ServerTLSConfig,
+	adminAuth common.ManagementAdminAuthConfig,
+	tokens BearerTokenVerifier,
+	manager users.Management,
+) (*grpc.Server, error) {
+	if adminAuth.Enabled && tokens == nil {
+		return nil, fmt.Errorf("user management admin authentication requires an OpenID issuer")
+	}
+	tlsConfig, err := tlsCfg.DefineTLSConfig()
+	if err != nil {
+		log.WithError(err).Error("Unable to define TLS config for user management gRPC server")
+		return nil, err
+	}
+	opts := []grpc.ServerOption{}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	// Only admins may use the API
+	if adminAuth.Enabled {
+		authorize := func(ctxt context.Context) error {
+			return managementGRPCAdminAuth(ctxt, adminAuth, tokens, manager)
+		}
+		opts = append(
+			opts,
+			grpc.UnaryInterceptor(func(
+				ctxt context.Context,
+				req interface{},
+				_ *grpc.UnaryServerInfo,
+				handler grpc.UnaryHandler,
+			) (interface{}, error) {
+				if err := authorize(ctxt); err != nil {
+					return nil, err
+				}
+				return handler(ctxt, req)
+			}),
+			grpc.StreamInterceptor(func(
+				srv interface{},
+				stream grpc.ServerStream,
+				_ *grpc.StreamServerInfo,
+				handler grpc.StreamHandler,
+			) error {
+				if err := authorize(stream.Context()); err != nil {
+					return err
+				}
+				return handler(srv, stream)
+			}),
+		)
+	}
+	svr := grpc.NewServer(opts...)
+	managementpb.RegisterUserManagementServer(svr, service)
+	return svr, nil
+}
+
+/*
+managementGRPCAdminAuth require the caller of the gRPC user management API to present, in
+the call metadata, a bearer token accepted by the authentication server, for a user holding
+the admin permission
+
+	@param ctxt context.Context - the call context
+	@param cfg common.ManagementAdminAuthConfig - the admin authentication config
+	@param tokens BearerTokenVerifier - verifies the bearer tokens
+	@param manager users.Management - core user management logic block
+	@return the gRPC error if the caller is rejected
+*/
+func managementGRPCAdminAuth(
+	ctxt context.Context,
+	cfg common.ManagementAdminAuthConfig,
+	tokens BearerTokenVerifier,
+	manager users.Management,
+) error {
+	logTags := log.Fields{"module": "apis", "component": "management-grpc"}
+	header := ""
+	if md, ok := metadata.FromIncomingContext(ctxt); ok {
+		if values := md.Get(tokens.TokenHeader()); len(values) > 0 {
+			header = values[0]
+		}
+	}
+	userID, rejected := verifyAdmin(ctxt, cfg, tokens, manager, header)
+	if rejected != nil {
+		log.WithError(rejected).WithFields(logTags).Error("Caller rejected")
+		return managementGRPCError(rejected, rejected.code, "Admin authentication failed")
+	}
+	log.WithFields(logTags).Debugf("Admin %s authenticated", userID)
+	return nil
+}
+
+/*
+managementGRPCError form the gRPC error for a failed call
+
+	@param err error - the error
+	@param code ErrorCode - the machine-readable error code, reported as the error reason
+	@param msg string - the error message
+	@return the gRPC error
+*/
+func managementGRPCError(err error, code ErrorCode, msg string) error {
+	grpcCode := codes.Internal
+	switch code {
+	case ErrCodeInvalidRequest, ErrCodeRoleUnknown, ErrCodePermissionUnknown:
+		grpcCode = codes.InvalidArgument
+	case ErrCodeUserNotFound, ErrCodeGroupNotFound, ErrCodeAPIKeyNotFound:
+		grpcCode = codes.NotFound
+	case ErrCodeTokenMissing,
+		ErrCodeTokenInvalid,
+		ErrCodeTokenExpired,
+		ErrCodeTokenInactive,
+		ErrCodeClaimInvalid:
+		grpcCode = codes.Unauthenticated
+	case ErrCodePermissionDenied:
+		grpcCode = codes.PermissionDenied
+	}
+	result := status.New(grpcCode, fmt.Sprintf("%s: %s", msg, err.Error()))
+	if withInfo, detailErr := result.WithDetails(
+		&errdetails.ErrorInfo{Reason: string(code), Domain: "padlock"},
+	); detailErr == nil {
+		result = withInfo
+	}
+	return result.Err()
+}
+
+/*
+userConfigFromProto convert the gRPC user parameters into models.UserConfig
+
+	@param user *managementpb.User - the gRPC user parameters
+	@return the user parameters
+*/
+func userConfigFromProto(user *managementpb.User) models.UserConfig {
+	return models.UserConfig{
+		UserID:         user.GetUserId(),
+		Username:       user.Username,
+		Email:          user.Email,
+		FirstName:      user.FirstName,
+		LastName:       user.LastName,
+		Attributes:     user.GetAttributes(),
+		ServiceAccount: user.GetServiceAccount(),
+	}
+}
+
+/*
+userInfoToProto convert models.UserInfo into the gRPC user parameters
+
+	@param user models.UserInfo - the user parameters
+	@return the gRPC user parameters
+*/
+func userInfoToProto(user models.UserInfo) *managementpb.User {
+	return &managementpb.User{
+		UserId:         user.UserID,
+		Username:       user.Username,
+		Email:          user.Email,
+		FirstName:      user.FirstName,
+		LastName:       user.LastName,
+		Attributes:     user.Attributes,
+		CreatedAt:      timestamppb.New(user.CreatedAt),
+		UpdatedAt:      timestamppb.New(user.UpdatedAt),
+		ServiceAccount: user.ServiceAccount,
+	}
+}
+
+/*
+roleToProto convert common.UserRoleConfig into the gRPC role
+
+	@param role common.UserRoleConfig - the role
+	@return the gRPC role
+*/
+func roleToProto(role common.UserRoleConfig) *managementpb.Role {
+	return &managementpb.Role{
+		Permissions:  role.AssignedPermissions,
+		AllowedHosts: role.AllowedHosts,
+		Inherits:     role.Inherits,
+	}
+}
+
+/*
+checkUserID verify a user ID is valid
+
+	@param ctxt context.Context - the operating context
+	@param userID string - the user ID
+	@return the gRPC error if not valid
+*/
+func (s *managementGRPCServer) checkUserID(ctxt context.Context, userID string) error {
+	if err := s.validate.Var(userID, "required,user_id"); err != nil {
+		msg := "no valid user ID"
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return managementGRPCError(err, ErrCodeInvalidRequest, msg)
+	}
+	return nil
+}
+
+/*
+checkRoles verify a list of role names is valid
+
+	@param ctxt context.Context - the operating context
+	@param roles []string - the role names
+	@return the gRPC error if not valid
+*/
+func (s *managementGRPCServer) checkRoles(ctxt context.Context, roles []string) error {
+	if err := s.validate.Struct(&ReqNewUserRoles{Roles: roles}); err != nil {
+		msg := "role parameters not valid"
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return managementGRPCError(err, ErrCodeInvalidRequest, msg)
+	}
+	return nil
+}
+
+// ====================================================================================
+// Role Management
+
+/*
+ListRoles query for the list of roles on record
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.ListRolesRequest - the request
+	@return the roles on record
+*/
+func (s *managementGRPCServer) ListRoles(
+	ctxt context.Context, _ *managementpb.ListRolesRequest,
+) (*managementpb.ListRolesResponse, error) {
+	roles, err := s.core.ListAllRoles(ctxt)
+	if err != nil {
+		msg := "Failed to query all roles"
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	resp := &managementpb.ListRolesResponse{Roles: map[string]*managementpb.Role{}}
+	for roleName, role := range roles {
+		resp.Roles[roleName] = roleToProto(role)
+	}
+	return resp, nil
+}
+
+/*
+GetRole query for a role on record, along with the users assigned the role
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.GetRoleRequest - the request
+	@return the role
+*/
+func (s *managementGRPCServer) GetRole(
+	ctxt context.Context, req *managementpb.GetRoleRequest,
+) (*managementpb.GetRoleResponse, error) {
+	logTags := s.GetLogTagsForContext(ctxt)
+	if err := s.validate.Var(req.GetName(), "required,role_name"); err != nil {
+		msg := "no valid role name"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		return nil, managementGRPCError(err, ErrCodeInvalidRequest, msg)
+	}
+	role, assigned, err := s.core.GetRoleWithLinkedUsers(ctxt, req.GetName())
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query role %s", req.GetName())
+		log.WithError(err).WithFields(logTags).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	resp := &managementpb.GetRoleResponse{
+		Role: roleToProto(role), AssignedUsers: []*managementpb.User{},
+	}
+	for _, user := range assigned {
+		resp.AssignedUsers = append(resp.AssignedUsers, userInfoToProto(user))
+	}
+	return resp, nil
+}
+
+// ====================================================================================
+// User Management
+
+/*
+DefineUser define a new user, along with its roles
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.DefineUserRequest - the request
+	@return the response
+*/
+func (s *managementGRPCServer) DefineUser(
+	ctxt context.Context, req *managementpb.DefineUserRequest,
+) (*managementpb.DefineUserResponse, error) {
+	logTags := s.GetLogTagsForContext(ctxt)
+	params := ReqNewUserParams{User: userConfigFromProto(req.GetUser()), Roles: req.GetRoles()}
+	if err := s.validate.Struct(&params); err != nil {
+		msg := "new user parameters not valid"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		return nil, managementGRPCError(err, ErrCodeInvalidRequest, msg)
+	}
+	if err := s.core.DefineUser(ctxt, params.User, params.Roles); err != nil {
+		msg := fmt.Sprintf("Failed to define new user %s", params.User.UserID)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	return &managementpb.DefineUserResponse{}, nil
+}
+
+/*
+ListUsers query for all users on record, optionally only those of one account type
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.ListUsersRequest - the request
+	@return the users on record
+*/
+func (s *managementGRPCServer) ListUsers(
+	ctxt context.Context, req *managementpb.ListUsersRequest,
+) (*managementpb.ListUsersResponse, error) {
+	var allUsers []models.UserInfo
+	var err error
+	if req.AccountType != nil {
+		if err := s.validate.Var(
+			req.GetAccountType(), "oneof=user service_account",
+		); err != nil {
+			msg := "Account type is not valid"
+			log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+			return nil, managementGRPCError(err, ErrCodeInvalidRequest, msg)
+		}
+		allUsers, err = s.core.SearchUsers(
+			ctxt, models.UserSearchFilter{AccountType: req.GetAccountType()},
+		)
+	} else {
+		allUsers, err = s.core.ListAllUsers(ctxt)
+	}
+	if err != nil {
+		msg := "Failed to query all users"
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	resp := &managementpb.ListUsersResponse{Users: []*managementpb.User{}}
+	for _, user := range allUsers {
+		resp.Users = append(resp.Users, userInfoToProto(user))
+	}
+	return resp, nil
+}
+
+/*
+GetUser query for a user, along with its roles and permissions
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.GetUserRequest - the request
+	@return the user
+*/
+func (s *managementGRPCServer) GetUser(
+	ctxt context.Context, req *managementpb.GetUserRequest,
+) (*managementpb.GetUserResponse, error) {
+	if err := s.checkUserID(ctxt, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	user, err := s.core.GetUser(ctxt, req.GetUserId())
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query user %s", req.GetUserId())
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	return &managementpb.GetUserResponse{
+		User:                  userInfoToProto(user.UserInfo),
+		Roles:                 user.Roles,
+		Permissions:           user.Permissions,
+		Groups:                user.Groups,
+		GroupRoles:            user.GroupRoles,
+		AssociatedPermissions: user.AssociatedPermission,
+	}, nil
+}
+
+/*
+UpdateUser update the parameters of a user
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.UpdateUserRequest - the request
+	@return the response
+*/
+func (s *managementGRPCServer) UpdateUser(
+	ctxt context.Context, req *managementpb.UpdateUserRequest,
+) (*managementpb.UpdateUserResponse, error) {
+	logTags := s.GetLogTagsForContext(ctxt)
+	userInfo := userConfigFromProto(req.GetUser())
+	if err := s.validate.Struct(&userInfo); err != nil {
+		msg := "user parameters not valid"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		return nil, managementGRPCError(err, ErrCodeInvalidRequest, msg)
+	}
+	if err := s.core.UpdateUser(ctxt, userInfo.UserID, userInfo); err != nil {
+		msg := fmt.Sprintf("Failed to update user %s", userInfo.UserID)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	return &managementpb.UpdateUserResponse{}, nil
+}
+
+/*
+DeleteUser delete a user
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.DeleteUserRequest - the request
+	@return the response
+*/
+func (s *managementGRPCServer) DeleteUser(
+	ctxt context.Context, req *managementpb.DeleteUserRequest,
+) (*managementpb.DeleteUserResponse, error) {
+	if err := s.checkUserID(ctxt, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	if err := s.core.DeleteUser(ctxt, req.GetUserId()); err != nil {
+		msg := fmt.Sprintf("Failed to delete user %s", req.GetUserId())
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	return &managementpb.DeleteUserResponse{}, nil
+}
+
+// ====================================================================================
+// Role Assignment
+
+/*
+SetUserRoles change the roles of a user
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.SetUserRolesRequest - the request
+	@return the response
+*/
+func (s *managementGRPCServer) SetUserRoles(
+	ctxt context.Context, req *managementpb.SetUserRolesRequest,
+) (*managementpb.SetUserRolesResponse, error) {
+	if err := s.checkUserID(ctxt, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	if err := s.checkRoles(ctxt, req.GetRoles()); err != nil {
+		return nil, err
+	}
+	if err := s.core.SetUserRoles(ctxt, req.GetUserId(), req.GetRoles()); err != nil {
+		msg := fmt.Sprintf("Failed to set user %s roles", req.GetUserId())
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	return &managementpb.SetUserRolesResponse{}, nil
+}
+
+/*
+AddUserRoles add roles to a user
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.AddUserRolesRequest - the request
+	@return the response
+*/
+func (s *managementGRPCServer) AddUserRoles(
+	ctxt context.Context, req *managementpb.AddUserRolesRequest,
+) (*managementpb.AddUserRolesResponse, error) {
+	if err := s.checkUserID(ctxt, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	if err := s.checkRoles(ctxt, req.GetRoles()); err != nil {
+		return nil, err
+	}
+	if err := s.core.AddRolesToUser(ctxt, req.GetUserId(), req.GetRoles()); err != nil {
+		msg := fmt.Sprintf("Failed to add roles to user %s", req.GetUserId())
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	return &managementpb.AddUserRolesResponse{}, nil
+}
+
+/*
+RemoveUserRoles remove roles from a user
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.RemoveUserRolesRequest - the request
+	@return the response
+*/
+func (s *managementGRPCServer) RemoveUserRoles(
+	ctxt context.Context, req *managementpb.RemoveUserRolesRequest,
+) (*managementpb.RemoveUserRolesResponse, error) {
+	if err := s.checkUserID(ctxt, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	if err := s.checkRoles(ctxt, req.GetRoles()); err != nil {
+		return nil, err
+	}
+	if err := s.core.RemoveRolesFromUser(ctxt, req.GetUserId(), req.GetRoles()); err != nil {
+		msg := fmt.Sprintf("Failed to remove roles from user %s", req.GetUserId())
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	return &managementpb.RemoveUserRolesResponse{}, nil
+}
+
+/*
+SetUserPermissions change the permissions granted directly to a user, in addition to the
+permissions of its roles
+
+	@param ctxt context.Context - the operating context
+	@param req *managementpb.SetUserPermissionsRequest - the request
+	@return the response
+*/
+func (s *managementGRPCServer) SetUserPermissions(
+	ctxt context.Context, req *managementpb.SetUserPermissionsRequest,
+) (*managementpb.SetUserPermissionsResponse, error) {
+	if err := s.checkUserID(ctxt, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	if err := s.validate.Struct(
+		&ReqNewUserPermissions{Permissions: req.GetPermissions()},
+	); err != nil {
+		msg := "permission parameters not valid"
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, ErrCodeInvalidRequest, msg)
+	}
+	if err := s.core.SetUserPermissions(
+		ctxt, req.GetUserId(), req.GetPermissions(),
+	); err != nil {
+		msg := fmt.Sprintf("Failed to set user %s permissions", req.GetUserId())
+		log.WithError(err).WithFields(s.GetLogTagsForContext(ctxt)).Error(msg)
+		return nil, managementGRPCError(err, errorCodeFor(err, ErrCodeInternal), msg)
+	}
+	return &managementpb.SetUserPermissionsResponse{}, nil
+}
@@ -0,0 +1,349 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/managementpb"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestManagementGRPCServer(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+		"writer": {AssignedPermissions: []string{"write"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	// Serve the user management API over an in-memory connection
+	service, err := DefineManagementGRPCServer(mgmtCore, supportMatch)
+	assert.Nil(err)
+	svr, err := BuildManagementGRPCServer(
+		service, common.ServerTLSConfig{}, common.ManagementAdminAuthConfig{}, nil, mgmtCore,
+	)
+	assert.Nil(err)
+	listener := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = svr.Serve(listener)
+	}()
+	defer svr.Stop()
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctxt context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctxt)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(err)
+	defer conn.Close()
+	client := managementpb.NewUserManagementClient(conn)
+
+	ctxt, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	// errorReason helper function to read the error code of a failed call
+	errorReason := func(err error) (codes.Code, string) {
+		asStatus, ok := status.FromError(err)
+		assert.True(ok)
+		for _, detail := range asStatus.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				return asStatus.Code(), info.Reason
+			}
+		}
+		return asStatus.Code(), ""
+	}
+
+	// Case 0: list the roles
+	{
+		resp, err := client.ListRoles(ctxt, &managementpb.ListRolesRequest{})
+		assert.Nil(err)
+		assert.Len(resp.Roles, 2)
+		assert.Equal([]string{"read"}, resp.Roles["reader"].Permissions)
+	}
+
+	// Case 1: define a user
+	testUser := uuid.New().String()
+	{
+		username := "unit-tester"
+		_, err := client.DefineUser(ctxt, &managementpb.DefineUserRequest{
+			User: &managementpb.User{
+				UserId:     testUser,
+				Username:   &username,
+				Attributes: map[string]string{"department": "finance"},
+			},
+			Roles: []string{"reader"},
+		})
+		assert.Nil(err)
+		resp, err := client.GetUser(ctxt, &managementpb.GetUserRequest{UserId: testUser})
+		assert.Nil(err)
+		assert.Equal(testUser, resp.User.UserId)
+		assert.Equal(username, resp.User.GetUsername())
+		assert.Equal(map[string]string{"department": "finance"}, resp.User.Attributes)
+		assert.Equal([]string{"reader"}, resp.Roles)
+		assert.Equal([]string{"read"}, resp.AssociatedPermissions)
+	}
+
+	// Case 2: invalid and unknown users
+	{
+		_, err := client.GetUser(ctxt, &managementpb.GetUserRequest{UserId: "not valid!"})
+		code, reason := errorReason(err)
+		assert.Equal(codes.InvalidArgument, code)
+		assert.Equal(string(ErrCodeInvalidRequest), reason)
+		_, err = client.GetUser(ctxt, &managementpb.GetUserRequest{UserId: uuid.New().String()})
+		code, reason = errorReason(err)
+		assert.Equal(codes.NotFound, code)
+		assert.Equal(string(ErrCodeUserNotFound), reason)
+	}
+
+	// Case 3: change the user's roles
+	{
+		_, err := client.AddUserRoles(ctxt, &managementpb.AddUserRolesRequest{
+			UserId: testUser, Roles: []string{"writer"},
+		})
+		assert.Nil(err)
+		resp, err := client.GetRole(ctxt, &managementpb.GetRoleRequest{Name: "writer"})
+		assert.Nil(err)
+		assert.Equal([]string{"write"}, resp.Role.Permissions)
+		assert.Len(resp.AssignedUsers, 1)
+		assert.Equal(testUser, resp.AssignedUsers[0].UserId)
+
+		_, err = client.RemoveUserRoles(ctxt, &managementpb.RemoveUserRolesRequest{
+			UserId: testUser, Roles: []string{"reader"},
+		})
+		assert.Nil(err)
+		user, err := client.GetUser(ctxt, &managementpb.GetUserRequest{UserId: testUser})
+		assert.Nil(err)
+		assert.Equal([]string{"writer"}, user.Roles)
+
+		_, err = client.SetUserRoles(ctxt, &managementpb.SetUserRolesRequest{
+			UserId: testUser, Roles: []string{"reader"},
+		})
+		assert.Nil(err)
+		user, err = client.GetUser(ctxt, &managementpb.GetUserRequest{UserId: testUser})
+		assert.Nil(err)
+		assert.Equal([]string{"reader"}, user.Roles)
+
+		_, err = client.SetUserRoles(ctxt, &managementpb.SetUserRolesRequest{
+			UserId: testUser, Roles: []string{"unknown-role"},
+		})
+		code, reason := errorReason(err)
+		assert.Equal(codes.InvalidArgument, code)
+		assert.Equal(string(ErrCodeRoleUnknown), reason)
+	}
+
+	// Case 4: grant permissions directly to the user
+	{
+		_, err := client.SetUserPermissions(ctxt, &managementpb.SetUserPermissionsRequest{
+			UserId: testUser, Permissions: []string{"write"},
+		})
+		assert.Nil(err)
+		user, err := client.GetUser(ctxt, &managementpb.GetUserRequest{UserId: testUser})
+		assert.Nil(err)
+		assert.Equal([]string{"write"}, user.Permissions)
+		assert.Contains(user.AssociatedPermissions, "write")
+
+		_, err = client.SetUserPermissions(ctxt, &managementpb.SetUserPermissionsRequest{
+			UserId: testUser, Permissions: []string{"unknown-permission"},
+		})
+		_, reason := errorReason(err)
+		assert.Equal(string(ErrCodePermissionUnknown), reason)
+
+		_, err = client.SetUserPermissions(ctxt, &managementpb.SetUserPermissionsRequest{
+			UserId: testUser,
+		})
+		assert.Nil(err)
+		user, err = client.GetUser(ctxt, &managementpb.GetUserRequest{UserId: testUser})
+		assert.Nil(err)
+		assert.Empty(user.Permissions)
+	}
+
+	// Case 5: update the user
+	{
+		email := "unit-test@testing.org"
+		_, err := client.UpdateUser(ctxt, &managementpb.UpdateUserRequest{
+			User: &managementpb.User{UserId: testUser, Email: &email},
+		})
+		assert.Nil(err)
+		resp, err := client.ListUsers(ctxt, &managementpb.ListUsersRequest{})
+		assert.Nil(err)
+		assert.Len(resp.Users, 1)
+		assert.Equal(email, resp.Users[0].GetEmail())
+		assert.Nil(resp.Users[0].Username)
+	}
+
+	// Case 6: delete the user
+	{
+		_, err := client.DeleteUser(ctxt, &managementpb.DeleteUserRequest{UserId: testUser})
+		assert.Nil(err)
+		resp, err := client.ListUsers(ctxt, &managementpb.ListUsersRequest{})
+		assert.Nil(err)
+		assert.Empty(resp.Users)
+	}
+}
+
+func TestManagementGRPCServerAdminAuth(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-:]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+
+	utCtxt := context.Background()
+	assert.Nil(mgmtCore.AlignRolesWithConfig(utCtxt, map[string]common.UserRoleConfig{
+		"admin":  {AssignedPermissions: []string{"padlock:admin"}},
+		"reader": {AssignedPermissions: []string{"read"}},
+	}))
+	assert.Nil(mgmtCore.DefineUser(utCtxt, models.UserConfig{UserID: "alice"}, []string{"admin"}))
+	assert.Nil(mgmtCore.DefineUser(utCtxt, models.UserConfig{UserID: "bob"}, []string{"reader"}))
+
+	// The tokens are read from the configured token header
+	tokens, err := DefineBearerTokenVerifier(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		fakeOpenIDClient{
+			tokens: map[string]jwt.MapClaims{
+				"admin-token":  {"sub": "alice"},
+				"reader-token": {"sub": "bob"},
+			},
+		},
+		false,
+		nil,
+		common.AuthenticationConfig{
+			TokenHeader: common.TokenHeaderConfig{
+				Header: "X-Padlock-Token", Schemes: []string{"Token"},
+			},
+		},
+		nil,
+		nil,
+		common.UserIDNormalizationConfig{},
+	)
+	assert.Nil(err)
+
+	service, err := DefineManagementGRPCServer(mgmtCore, supportMatch)
+	assert.Nil(err)
+	svr, err := BuildManagementGRPCServer(
+		service,
+		common.ServerTLSConfig{},
+		common.ManagementAdminAuthConfig{
+			Enabled: true, Permission: "padlock:admin", UserIDClaim: "sub",
+		},
+		tokens,
+		mgmtCore,
+	)
+	assert.Nil(err)
+	listener := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = svr.Serve(listener)
+	}()
+	defer svr.Stop()
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctxt context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctxt)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(err)
+	defer conn.Close()
+	client := managementpb.NewUserManagementClient(conn)
+
+	listRoles := func(token string) (codes.Code, string) {
+		ctxt, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if token != "" {
+			ctxt = metadata.AppendToOutgoingContext(ctxt, "X-Padlock-Token", token)
+		}
+		_, err := client.ListRoles(ctxt, &managementpb.ListRolesRequest{})
+		asStatus, ok := status.FromError(err)
+		assert.True(ok)
+		for _, detail := range asStatus.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				return asStatus.Code(), info.Reason
+			}
+		}
+		return asStatus.Code(), ""
+	}
+
+	// Case 0: the caller holds the admin permission
+	{
+		code, _ := listRoles("Token admin-token")
+		assert.Equal(codes.OK, code)
+	}
+
+	// Case 1: no bearer token, or one which failed verification
+	for _, token := range []string{"", "Token forged-token", "Bearer admin-token"} {
+		code, reason := listRoles(token)
+		assert.Equal(codes.Unauthenticated, code, token)
+		assert.NotEmpty(reason, token)
+	}
+
+	// Case 2: the caller lacks the admin permission
+	{
+		code, reason := listRoles("Token reader-token")
+		assert.Equal(codes.PermissionDenied, code)
+		assert.Equal(string(ErrCodePermissionDenied), reason)
+	}
+
+	// Case 3: admin authentication requires an OpenID issuer
+	{
+		_, err := BuildManagementGRPCServer(
+			service,
+			common.ServerTLSConfig{},
+			common.ManagementAdminAuthConfig{Enabled: true},
+			nil,
+			mgmtCore,
+		)
+		assert.NotNil(err)
+	}
+}
@@ -0,0 +1,160 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// routeLabelOther is the route label used once the route label cap is reached
+const routeLabelOther = "other"
+
+// RouteMetricsHelper records HTTP request metrics labelled by API route
+type RouteMetricsHelper interface {
+	/*
+		RecordRequest record one HTTP request
+
+		 @param server string - the server which processed the request
+		 @param route string - the route template the request matched
+		 @param method string - the request method
+		 @param status int - the response code
+		 @param latency time.Duration - the request processing latency
+	*/
+	RecordRequest(server, route, method string, status int, latency time.Duration)
+}
+
+// routeMetricsHelperImpl implements RouteMetricsHelper
+type routeMetricsHelperImpl struct {
+	lock           sync.Mutex
+	maxRoutes      int
+	seenRoutes     map[string]map[string]bool
+	requestTracker *prometheus.CounterVec
+	latencyTracker *prometheus.CounterVec
+}
+
+/*
+DefineRouteMetricsHelper define a new RouteMetricsHelper
+
+	@param collector goutils.MetricsCollector - the metrics collector to install the metrics with
+	@param maxRoutes int - max number of distinct route labels to record per server. Requests for
+	additional routes are recorded under the "other" route.
+	@return new RouteMetricsHelper instance
+*/
+func DefineRouteMetricsHelper(
+	collector goutils.MetricsCollector, maxRoutes int,
+) (RouteMetricsHelper, error) {
+	labels := []string{"server", "route", "method", "status_class"}
+	requestTracker, err := collector.InstallCustomCounterVecMetrics(
+		context.Background(), "http_route_request_total", "HTTP request tracking by route", labels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	latencyTracker, err := collector.InstallCustomCounterVecMetrics(
+		context.Background(),
+		"http_route_request_latency_secs_total",
+		"HTTP request latency tracking by route",
+		labels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &routeMetricsHelperImpl{
+		lock:           sync.Mutex{},
+		maxRoutes:      maxRoutes,
+		seenRoutes:     map[string]map[string]bool{},
+		requestTracker: requestTracker,
+		latencyTracker: latencyTracker,
+	}, nil
+}
+
+// routeLabel get the route label to use, applying the route label cap
+func (m *routeMetricsHelperImpl) routeLabel(server, route string) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	routes, ok := m.seenRoutes[server]
+	if !ok {
+		routes = map[string]bool{}
+		m.seenRoutes[server] = routes
+	}
+	if routes[route] {
+		return route
+	}
+	if len(routes) >= m.maxRoutes {
+		return routeLabelOther
+	}
+	routes[route] = true
+	return route
+}
+
+/*
+RecordRequest record one HTTP request
+
+	@param server string - the server which processed the request
+	@param route string - the route template the request matched
+	@param method string - the request method
+	@param status int - the response code
+	@param latency time.Duration - the request processing latency
+*/
+func (m *routeMetricsHelperImpl) RecordRequest(
+	server, route, method string, status int, latency time.Duration,
+) {
+	labels := prometheus.Labels{
+		"server":       server,
+		"route":        m.routeLabel(server, route),
+		"method":       strings.ToUpper(method),
+		"status_class": fmt.Sprintf("%dxx", status/100),
+	}
+	m.requestTracker.With(labels).Inc()
+	m.latencyTracker.With(labels).Add(latency.Seconds())
+}
+
+// statusRecorder captures the response code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap the wrapped http.ResponseWriter, so http.ResponseController can reach it
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// matchedRouteTemplate the path template of the route the request matched
+func matchedRouteTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return routeLabelOther
+}
+
+// routeMetricsMiddleware record request metrics labelled by the matched route template
+func routeMetricsMiddleware(helper RouteMetricsHelper, server string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if helper == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := matchedRouteTemplate(r)
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(recorder, r)
+			helper.RecordRequest(server, route, r.Method, recorder.status, time.Since(start))
+		})
+	}
+}
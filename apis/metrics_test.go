@@ -0,0 +1,71 @@
+package apis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/goutils"
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteMetricsMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	collector, err := goutils.GetNewMetricsCollector(
+		log.Fields{"module": "goutils", "component": "metrics-core"}, []goutils.LogMetadataModifier{},
+	)
+	assert.Nil(err)
+	helper, err := DefineRouteMetricsHelper(collector, 2)
+	assert.Nil(err)
+	uut, ok := helper.(*routeMetricsHelperImpl)
+	assert.True(ok)
+
+	router := mux.NewRouter()
+	router.Use(routeMetricsMiddleware(helper, ServerNameUserManagement))
+	v1Router := registerPathPrefix(router, "/v1", nil)
+	_ = registerPathPrefix(v1Router, "/user/{userID}", map[string]http.HandlerFunc{
+		"get": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+	})
+	_ = registerPathPrefix(v1Router, "/role/{roleName}", map[string]http.HandlerFunc{
+		"get": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) },
+	})
+	_ = registerPathPrefix(v1Router, "/role", map[string]http.HandlerFunc{
+		"get": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+	})
+
+	call := func(path string) {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.Nil(err)
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+	}
+	requestCount := func(route, statusClass string) float64 {
+		return testutil.ToFloat64(uut.requestTracker.With(prometheus.Labels{
+			"server":       ServerNameUserManagement,
+			"route":        route,
+			"method":       "GET",
+			"status_class": statusClass,
+		}))
+	}
+
+	// Case 0: requests are labelled by route template, not raw path
+	call("/v1/user/user-1")
+	call("/v1/user/user-2")
+	assert.Equal(2.0, requestCount("/v1/user/{userID}", "2xx"))
+
+	// Case 1: status class label
+	call("/v1/role/role-1")
+	assert.Equal(1.0, requestCount("/v1/role/{roleName}", "4xx"))
+
+	// Case 2: route label cap reached
+	call("/v1/role")
+	assert.Equal(1.0, requestCount(routeLabelOther, "2xx"))
+	call("/v1/user/user-3")
+	assert.Equal(3.0, requestCount("/v1/user/{userID}", "2xx"))
+}
@@ -0,0 +1,208 @@
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/nats-io/nats.go"
+)
+
+// Supported NATS query kinds
+const (
+	// NATSQueryAuthorize queries the authorization submodule
+	NATSQueryAuthorize = "authorize"
+	// NATSQueryAuthenticate queries the authentication submodule
+	NATSQueryAuthenticate = "authenticate"
+)
+
+// NATSQuery is an authorization or authentication query received over NATS
+type NATSQuery struct {
+	// Kind is the type of query
+	Kind string `json:"kind"`
+	// Headers are the request headers, as a request proxy would forward them to the submodule
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// NATSDecision is the reply to a NATSQuery
+type NATSDecision struct {
+	// Allowed whether the submodule allowed the request
+	Allowed bool `json:"allowed"`
+	// Status is the HTTP status code the submodule responded with
+	Status int `json:"status"`
+	// Headers are the response headers, i.e. the user parameters on a successful authentication
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is the response body of the submodule
+	Body json.RawMessage `json:"body,omitempty"`
+	// Error describes why the query could not be processed
+	Error string `json:"error,omitempty"`
+}
+
+// NATSQueryTarget is the submodule API a kind of NATS query is answered by
+type NATSQueryTarget struct {
+	// Handler is the HTTP handler of the submodule server
+	Handler http.Handler
+	// Path is the path of the API on the submodule server
+	Path string
+}
+
+/*
+DefineNATSQueryTarget define the target of a kind of NATS query
+
+	@param handler http.Handler - the HTTP handler of the submodule server
+	@param pathPrefix string - the submodule server's end-point path prefix
+	@param api string - the API path relative to the path prefix
+	@return the query target
+*/
+func DefineNATSQueryTarget(handler http.Handler, pathPrefix, api string) NATSQueryTarget {
+	return NATSQueryTarget{Handler: handler, Path: path.Join(pathPrefix, api)}
+}
+
+// NATSResponder answers authorization and authentication queries received over NATS
+type NATSResponder interface {
+	/*
+		Stop stop answering queries, and close the NATS connection
+
+		 @param ctxt context.Context - the operating context
+		 @return whether successful
+	*/
+	Stop(ctxt context.Context) error
+}
+
+// natsResponderImpl implements NATSResponder
+type natsResponderImpl struct {
+	goutils.Component
+	conn    *nats.Conn
+	sub     *nats.Subscription
+	targets map[string]NATSQueryTarget
+	wg      sync.WaitGroup
+}
+
+/*
+DefineNATSResponder define a new NATS responder. The queries are answered by dispatching them
+to the submodule servers, so they are processed the same way as the HTTP requests.
+
+	@param cfg common.NATSResponderConfig - the NATS responder config
+	@param targets map[string]NATSQueryTarget - the submodule API to answer each kind of query
+	with. Query kinds without a target are rejected.
+	@return new NATSResponder instance
+*/
+func DefineNATSResponder(
+	cfg common.NATSResponderConfig, targets map[string]NATSQueryTarget,
+) (NATSResponder, error) {
+	logTags := log.Fields{
+		"module": "apis", "component": "nats-responder", "subject": cfg.Subject,
+	}
+
+	conn, err := nats.Connect(cfg.URL, nats.Name("padlock"))
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to connect to NATS at %s", cfg.URL)
+		return nil, err
+	}
+
+	instance := &natsResponderImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+			},
+		},
+		conn:    conn,
+		targets: targets,
+		wg:      sync.WaitGroup{},
+	}
+
+	sub, err := conn.QueueSubscribe(cfg.Subject, cfg.QueueGroup, func(msg *nats.Msg) {
+		instance.wg.Add(1)
+		go func() {
+			defer instance.wg.Done()
+			decision := instance.handleQuery(context.Background(), msg.Data)
+			reply, err := json.Marshal(&decision)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Error("Failed to serialize NATS decision")
+				return
+			}
+			if err := msg.Respond(reply); err != nil {
+				log.WithError(err).WithFields(logTags).Error("Failed to reply to NATS query")
+			}
+		}()
+	})
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to subscribe to NATS query subject")
+		conn.Close()
+		return nil, err
+	}
+	instance.sub = sub
+
+	return instance, nil
+}
+
+/*
+handleQuery answer one query by dispatching it to the submodule server
+
+	@param ctxt context.Context - the operating context
+	@param payload []byte - the serialized NATSQuery
+	@return the decision
+*/
+func (r *natsResponderImpl) handleQuery(ctxt context.Context, payload []byte) NATSDecision {
+	logTags := r.GetLogTagsForContext(ctxt)
+
+	var query NATSQuery
+	if err := json.Unmarshal(payload, &query); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to parse NATS query")
+		return NATSDecision{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+	target, ok := r.targets[query.Kind]
+	if !ok {
+		err := fmt.Errorf("query kind '%s' is not supported", query.Kind)
+		log.WithError(err).WithFields(logTags).Error("Unable to answer NATS query")
+		return NATSDecision{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	req, err := http.NewRequestWithContext(ctxt, http.MethodGet, target.Path, nil)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to define NATS query request")
+		return NATSDecision{Status: http.StatusInternalServerError, Error: err.Error()}
+	}
+	for name, value := range query.Headers {
+		req.Header.Set(name, value)
+	}
+	resp := newCapturedResponse()
+	target.Handler.ServeHTTP(resp, req)
+
+	decision := NATSDecision{
+		Allowed: resp.Code == http.StatusOK,
+		Status:  resp.Code,
+		Headers: map[string]string{},
+	}
+	for name := range resp.Header() {
+		decision.Headers[name] = resp.Header().Get(name)
+	}
+	if body := resp.Body.Bytes(); json.Valid(body) {
+		decision.Body = body
+	}
+	return decision
+}
+
+/*
+Stop stop answering queries, and close the NATS connection
+
+	@param ctxt context.Context - the operating context
+	@return whether successful
+*/
+func (r *natsResponderImpl) Stop(ctxt context.Context) error {
+	defer r.conn.Close()
+	if err := r.sub.Unsubscribe(); err != nil {
+		log.WithError(err).WithFields(r.GetLogTagsForContext(ctxt)).
+			Error("Failed to unsubscribe from NATS query subject")
+		return err
+	}
+	return goutils.TimeBoundedWaitGroupWait(ctxt, &r.wg, time.Second*10)
+}
@@ -0,0 +1,80 @@
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNATSResponderHandleQuery(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Stub authentication server, which accepts a single token
+	router := mux.NewRouter()
+	router.Path("/padlock/v1/authenticate").Methods("GET").HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "bearer good-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"success":false}`))
+				return
+			}
+			w.Header().Set("X-Caller-UserID", "alice")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"success":true}`))
+		},
+	)
+
+	uut := &natsResponderImpl{
+		targets: map[string]NATSQueryTarget{
+			NATSQueryAuthenticate: DefineNATSQueryTarget(router, "/padlock", "/v1/authenticate"),
+		},
+	}
+	utCtxt := context.Background()
+
+	query := func(q NATSQuery) []byte {
+		payload, err := json.Marshal(&q)
+		assert.Nil(err)
+		return payload
+	}
+
+	// Case 0: allowed
+	{
+		decision := uut.handleQuery(utCtxt, query(NATSQuery{
+			Kind: NATSQueryAuthenticate, Headers: map[string]string{"Authorization": "bearer good-token"},
+		}))
+		assert.True(decision.Allowed)
+		assert.Equal(http.StatusOK, decision.Status)
+		assert.Equal("alice", decision.Headers["X-Caller-Userid"])
+		assert.JSONEq(`{"success":true}`, string(decision.Body))
+	}
+
+	// Case 1: denied
+	{
+		decision := uut.handleQuery(utCtxt, query(NATSQuery{
+			Kind: NATSQueryAuthenticate, Headers: map[string]string{"Authorization": "bearer bad-token"},
+		}))
+		assert.False(decision.Allowed)
+		assert.Equal(http.StatusUnauthorized, decision.Status)
+	}
+
+	// Case 2: query kind without a target
+	{
+		decision := uut.handleQuery(utCtxt, query(NATSQuery{Kind: NATSQueryAuthorize}))
+		assert.False(decision.Allowed)
+		assert.Equal(http.StatusBadRequest, decision.Status)
+		assert.NotEmpty(decision.Error)
+	}
+
+	// Case 3: malformed query
+	{
+		decision := uut.handleQuery(utCtxt, []byte("hello"))
+		assert.False(decision.Allowed)
+		assert.Equal(http.StatusBadRequest, decision.Status)
+	}
+}
@@ -0,0 +1,259 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/apex/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// QuotaCounter counts the requests made within fixed quota windows
+type QuotaCounter interface {
+	/*
+		Increment count one request against a quota window
+
+			@param ctxt context.Context - the operating context
+			@param key string - the quota window key
+			@param expire time.Time - when the quota window ends
+			@param timestamp time.Time - the current timestamp
+			@return the number of requests counted within the window, including this one
+	*/
+	Increment(ctxt context.Context, key string, expire, timestamp time.Time) (int64, error)
+}
+
+// quotaCount the number of requests counted within a quota window
+type quotaCount struct {
+	count  int64
+	expire time.Time
+}
+
+// memoryQuotaCounter implements QuotaCounter in memory
+type memoryQuotaCounter struct {
+	lock   sync.Mutex
+	counts map[string]quotaCount
+	// nextPrune is when to next remove the counts of the ended windows
+	nextPrune time.Time
+}
+
+/*
+DefineMemoryQuotaCounter define a new in-memory request counter. The requests are counted
+separately by each replica.
+
+	@return new QuotaCounter instance
+*/
+func DefineMemoryQuotaCounter() QuotaCounter {
+	return &memoryQuotaCounter{lock: sync.Mutex{}, counts: map[string]quotaCount{}}
+}
+
+/*
+Increment count one request against a quota window
+
+	@param ctxt context.Context - the operating context
+	@param key string - the quota window key
+	@param expire time.Time - when the quota window ends
+	@param timestamp time.Time - the current timestamp
+	@return the number of requests counted within the window, including this one
+*/
+func (c *memoryQuotaCounter) Increment(
+	ctxt context.Context, key string, expire, timestamp time.Time,
+) (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if timestamp.After(c.nextPrune) {
+		for countKey, count := range c.counts {
+			if !timestamp.Before(count.expire) {
+				delete(c.counts, countKey)
+			}
+		}
+		c.nextPrune = timestamp.Add(time.Minute)
+	}
+	count := c.counts[key]
+	count.count++
+	count.expire = expire
+	c.counts[key] = count
+	return count.count, nil
+}
+
+// redisQuotaCounter implements QuotaCounter with Redis
+type redisQuotaCounter struct {
+	client *redis.Client
+	prefix string
+}
+
+/*
+DefineRedisQuotaCounter define a new request counter backed by Redis. The requests are
+counted across all replicas sharing the Redis server.
+
+	@param client *redis.Client - the Redis client
+	@param prefix string - the prefix of the counter keys
+	@return new QuotaCounter instance
+*/
+func DefineRedisQuotaCounter(client *redis.Client, prefix string) QuotaCounter {
+	return &redisQuotaCounter{client: client, prefix: prefix}
+}
+
+/*
+Increment count one request against a quota window
+
+	@param ctxt context.Context - the operating context
+	@param key string - the quota window key
+	@param expire time.Time - when the quota window ends
+	@param timestamp time.Time - the current timestamp
+	@return the number of requests counted within the window, including this one
+*/
+func (c *redisQuotaCounter) Increment(
+	ctxt context.Context, key string, expire, timestamp time.Time,
+) (int64, error) {
+	redisKey := fmt.Sprintf("%s:%s", c.prefix, key)
+	var incr *redis.IntCmd
+	if _, err := c.client.TxPipelined(ctxt, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctxt, redisKey)
+		pipe.Expire(ctxt, redisKey, expire.Sub(timestamp))
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// QuotaState is the state of a user's request quota after a request
+type QuotaState struct {
+	// Name is the name of the quota
+	Name string
+	// Limit is the number of requests allowed within a window
+	Limit int
+	// Remaining is the number of requests remaining within the current window
+	Remaining int
+	// Reset is when the current window ends
+	Reset time.Time
+	// Exceeded whether the request exceeded the quota
+	Exceeded bool
+}
+
+// QuotaEnforcer enforces the per-user request quotas
+type QuotaEnforcer interface {
+	/*
+		Consume count an allowed request against the quotas applicable to it
+
+			@param ctxt context.Context - the operating context
+			@param userID string - the ID of the user making the request
+			@param rule *match.MatchedRule - the authorization rule the request matched
+			@param timestamp time.Time - the current timestamp
+			@return the state of the most restrictive applicable quota, or nil if none applies
+	*/
+	Consume(
+		ctxt context.Context, userID string, rule *match.MatchedRule, timestamp time.Time,
+	) (*QuotaState, error)
+}
+
+// quotaEnforcerImpl implements QuotaEnforcer
+type quotaEnforcerImpl struct {
+	goutils.Component
+	counter QuotaCounter
+	rules   []common.QuotaRuleConfig
+}
+
+/*
+DefineQuotaEnforcer define a new request quota enforcer
+
+	@param counter QuotaCounter - the request counter
+	@param rules []common.QuotaRuleConfig - the quotas to enforce
+	@return new QuotaEnforcer instance
+*/
+func DefineQuotaEnforcer(counter QuotaCounter, rules []common.QuotaRuleConfig) QuotaEnforcer {
+	logTags := log.Fields{"module": "apis", "component": "quota-enforcer"}
+	return &quotaEnforcerImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		counter: counter,
+		rules:   rules,
+	}
+}
+
+/*
+applies whether a quota applies to a request matching an authorization rule
+
+	@param quota common.QuotaRuleConfig - the quota
+	@param rule *match.MatchedRule - the authorization rule the request matched
+	@return whether the quota applies
+*/
+func (e *quotaEnforcerImpl) applies(
+	quota common.QuotaRuleConfig, rule *match.MatchedRule,
+) bool {
+	if quota.Permission == "" {
+		return true
+	}
+	if rule == nil {
+		return false
+	}
+	for _, permission := range rule.Permissions {
+		if permission == quota.Permission {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Consume count an allowed request against the quotas applicable to it
+
+	@param ctxt context.Context - the operating context
+	@param userID string - the ID of the user making the request
+	@param rule *match.MatchedRule - the authorization rule the request matched
+	@param timestamp time.Time - the current timestamp
+	@return the state of the most restrictive applicable quota, or nil if none applies
+*/
+func (e *quotaEnforcerImpl) Consume(
+	ctxt context.Context, userID string, rule *match.MatchedRule, timestamp time.Time,
+) (*QuotaState, error) {
+	logTags := e.GetLogTagsForContext(ctxt)
+	var result *QuotaState
+	for _, quota := range e.rules {
+		if !e.applies(quota, rule) {
+			continue
+		}
+		window := time.Second * time.Duration(quota.Window)
+		windowStart := timestamp.Truncate(window)
+		reset := windowStart.Add(window)
+		key := fmt.Sprintf("%s:%s:%d", quota.Name, userID, windowStart.Unix())
+		count, err := e.counter.Increment(ctxt, key, reset, timestamp)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf(
+				"Failed to count request of user ID %s against quota %s", userID, quota.Name,
+			)
+			return nil, err
+		}
+		state := QuotaState{
+			Name:      quota.Name,
+			Limit:     quota.Requests,
+			Remaining: quota.Requests - int(count),
+			Reset:     reset,
+			Exceeded:  count > int64(quota.Requests),
+		}
+		if state.Remaining < 0 {
+			state.Remaining = 0
+		}
+		// Report the exceeded quota which resets last, otherwise the one with the fewest
+		// remaining requests
+		if result == nil ||
+			(state.Exceeded && (!result.Exceeded || state.Reset.After(result.Reset))) ||
+			(!state.Exceeded && !result.Exceeded && state.Remaining < result.Remaining) {
+			result = &state
+		}
+	}
+	if result != nil && result.Exceeded {
+		log.WithFields(logTags).Debugf("User ID %s exceeded quota %s", userID, result.Name)
+	}
+	return result, nil
+}
@@ -0,0 +1,229 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestQuotaEnforcer(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	defer redisClient.Close()
+
+	counters := map[string]QuotaCounter{
+		"memory": DefineMemoryQuotaCounter(),
+		"redis":  DefineRedisQuotaCounter(redisClient, "padlock:quota"),
+	}
+
+	rules := []common.QuotaRuleConfig{
+		{Name: "all", Requests: 5, Window: 60},
+		{Name: "write", Permission: "write", Requests: 2, Window: 10},
+	}
+	readRule := &match.MatchedRule{Permissions: []string{"read"}}
+	writeRule := &match.MatchedRule{Permissions: []string{"read", "write"}}
+
+	ctxt := context.Background()
+
+	for backend, counter := range counters {
+		uut := DefineQuotaEnforcer(counter, rules)
+		userID := uuid.NewString()
+		// Start of a window of both quotas
+		timestamp := time.Unix(1200, 0).UTC()
+
+		// Case 0: only the unscoped quota applies
+		state, err := uut.Consume(ctxt, userID, readRule, timestamp)
+		assert.Nil(err, backend)
+		assert.NotNil(state, backend)
+		assert.Equal("all", state.Name, backend)
+		assert.Equal(4, state.Remaining, backend)
+		assert.Equal(timestamp.Add(time.Minute), state.Reset, backend)
+		assert.False(state.Exceeded, backend)
+
+		// Case 1: the scoped quota is the most restrictive
+		state, err = uut.Consume(ctxt, userID, writeRule, timestamp)
+		assert.Nil(err, backend)
+		assert.Equal("write", state.Name, backend)
+		assert.Equal(1, state.Remaining, backend)
+		assert.Equal(timestamp.Add(time.Second*10), state.Reset, backend)
+		state, err = uut.Consume(ctxt, userID, writeRule, timestamp)
+		assert.Nil(err, backend)
+		assert.Equal("write", state.Name, backend)
+		assert.Equal(0, state.Remaining, backend)
+		assert.False(state.Exceeded, backend)
+
+		// Case 2: exceed the scoped quota
+		state, err = uut.Consume(ctxt, userID, writeRule, timestamp)
+		assert.Nil(err, backend)
+		assert.Equal("write", state.Name, backend)
+		assert.True(state.Exceeded, backend)
+
+		// Case 3: the other users are not affected
+		state, err = uut.Consume(ctxt, uuid.NewString(), writeRule, timestamp)
+		assert.Nil(err, backend)
+		assert.False(state.Exceeded, backend)
+
+		// Case 4: the scoped quota window resets
+		timestamp = timestamp.Add(time.Second * 10)
+		state, err = uut.Consume(ctxt, userID, writeRule, timestamp)
+		assert.Nil(err, backend)
+		assert.Equal("all", state.Name, backend)
+		assert.Equal(0, state.Remaining, backend)
+		assert.False(state.Exceeded, backend)
+
+		// Case 5: exceed the unscoped quota
+		state, err = uut.Consume(ctxt, userID, readRule, timestamp)
+		assert.Nil(err, backend)
+		assert.Equal("all", state.Name, backend)
+		assert.True(state.Exceeded, backend)
+	}
+
+	// Case 6: no quota applies
+	{
+		uut := DefineQuotaEnforcer(DefineMemoryQuotaCounter(), rules[1:])
+		state, err := uut.Consume(ctxt, uuid.NewString(), readRule, time.Now().UTC())
+		assert.Nil(err)
+		assert.Nil(state)
+	}
+
+	// Case 7: the Redis counters expire with the window
+	assert.Greater(len(redisServer.Keys()), 0)
+	redisServer.FastForward(time.Hour)
+	assert.Empty(redisServer.Keys())
+}
+
+func TestAuthorizationWithQuota(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	restRequestMatcher, err := match.DefineTargetGroupMatcher(match.TargetGroupSpec{
+		AllowedHosts: map[string]match.TargetHostSpec{
+			"*": {
+				TargetHost: "*",
+				AllowedPathsForHost: []match.TargetPathSpec{
+					{
+						PathPattern:          `^/orders$`,
+						PermissionsForMethod: map[string][]string{"GET": {"read"}},
+					},
+				},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	uut, err := defineAuthorizationHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		restRequestMatcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		AuthorizationOptions{
+			Decisions: DefineDecisionCache(time.Minute, 0),
+			Quotas: DefineQuotaEnforcer(
+				DefineMemoryQuotaCounter(),
+				[]common.QuotaRuleConfig{{Name: "reads", Permission: "read", Requests: 2, Window: 3600}},
+			),
+		},
+	)
+	assert.Nil(err)
+
+	reader := uuid.New().String()
+	otherUser := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: reader}, []string{"reader"},
+	))
+	assert.Nil(mgmtCore.DefineUser(
+		context.Background(), models.UserConfig{UserID: otherUser}, nil,
+	))
+
+	checkAllow := func(userID string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, "unit-test.org")
+		req.Header.Add(paramLoc.Path, "/orders")
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, userID)
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ParamReadMiddleware(uut.AllowHandler()))
+		handler.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: allowed requests within the quota, including the memoized decisions
+	for remaining := 1; remaining >= 0; remaining-- {
+		resp := checkAllow(reader)
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal("2", resp.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(fmt.Sprintf("%d", remaining), resp.Header().Get("X-RateLimit-Remaining"))
+		assert.NotEmpty(resp.Header().Get("X-RateLimit-Reset"))
+		assert.Empty(resp.Header().Get("Retry-After"))
+	}
+
+	// Case 1: the request exceeding the quota
+	{
+		resp := checkAllow(reader)
+		assert.Equal(http.StatusTooManyRequests, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeQuotaExceeded))
+		assert.Equal("0", resp.Header().Get("X-RateLimit-Remaining"))
+		assert.NotEmpty(resp.Header().Get("Retry-After"))
+	}
+
+	// Case 2: denied requests are not counted
+	{
+		resp := checkAllow(otherUser)
+		assert.Equal(http.StatusForbidden, resp.Code)
+		assert.Empty(resp.Header().Get("X-RateLimit-Limit"))
+	}
+}
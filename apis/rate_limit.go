@@ -0,0 +1,226 @@
+package apis
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+)
+
+// RateLimiter limits the rate of requests, overall and for each caller, with token buckets
+type RateLimiter interface {
+	/*
+		Allow decide whether a request may proceed, taking a token from the global bucket and
+		from the caller's bucket if so
+
+			@param caller string - the caller the request is from
+			@param timestamp time.Time - the current timestamp
+			@return whether the request may proceed, and if not, the number of seconds the caller
+			should wait before retrying
+	*/
+	Allow(caller string, timestamp time.Time) (bool, int64)
+}
+
+// tokenBucket a token bucket refilled at a constant rate
+type tokenBucket struct {
+	caller string
+	tokens float64
+	// refilled is when the tokens were last refilled
+	refilled time.Time
+}
+
+/*
+refill add the tokens accumulated since the last refill
+
+	@param rate float64 - the refill rate, in tokens per second
+	@param burst float64 - the bucket capacity
+	@param timestamp time.Time - the current timestamp
+*/
+func (b *tokenBucket) refill(rate, burst float64, timestamp time.Time) {
+	if elapsed := timestamp.Sub(b.refilled).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+		b.refilled = timestamp
+	}
+}
+
+/*
+wait the number of seconds until the bucket holds a token
+
+	@param rate float64 - the refill rate, in tokens per second
+	@return the number of seconds to wait
+*/
+func (b *tokenBucket) wait(rate float64) int64 {
+	return int64(math.Ceil((1 - b.tokens) / rate))
+}
+
+// rateLimiterImpl implements RateLimiter
+type rateLimiterImpl struct {
+	globalRate     float64
+	globalBurst    float64
+	perCallerRate  float64
+	perCallerBurst float64
+	maxCallers     int
+
+	lock   sync.Mutex
+	global tokenBucket
+	// callers is the buckets of the callers, by caller
+	callers map[string]*list.Element
+	// recent is the buckets of the callers, the most recently seen first
+	recent *list.List
+}
+
+/*
+DefineRateLimiter define a new token bucket rate limiter
+
+	@param config common.RateLimitConfig - the rate limit config
+	@return new RateLimiter instance
+*/
+func DefineRateLimiter(config common.RateLimitConfig) RateLimiter {
+	// A bucket must hold at least one token to ever allow a request
+	burstOf := func(bucket common.RateLimitBucketConfig) float64 {
+		return math.Max(1, float64(bucket.Burst))
+	}
+	return &rateLimiterImpl{
+		globalRate:     config.Global.Rate,
+		globalBurst:    burstOf(config.Global),
+		perCallerRate:  config.PerCaller.Rate,
+		perCallerBurst: burstOf(config.PerCaller.RateLimitBucketConfig),
+		maxCallers:     config.PerCaller.MaxCallers,
+		lock:           sync.Mutex{},
+		global:         tokenBucket{tokens: burstOf(config.Global)},
+		callers:        make(map[string]*list.Element),
+		recent:         list.New(),
+	}
+}
+
+/*
+Allow decide whether a request may proceed, taking a token from the global bucket and from
+the caller's bucket if so
+
+	@param caller string - the caller the request is from
+	@param timestamp time.Time - the current timestamp
+	@return whether the request may proceed, and if not, the number of seconds the caller
+	should wait before retrying
+*/
+func (l *rateLimiterImpl) Allow(caller string, timestamp time.Time) (bool, int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var callerBucket *tokenBucket
+	if l.perCallerRate > 0 {
+		callerBucket = l.callerBucket(caller, timestamp)
+		callerBucket.refill(l.perCallerRate, l.perCallerBurst, timestamp)
+		if callerBucket.tokens < 1 {
+			return false, callerBucket.wait(l.perCallerRate)
+		}
+	}
+	if l.globalRate > 0 {
+		l.global.refill(l.globalRate, l.globalBurst, timestamp)
+		if l.global.tokens < 1 {
+			return false, l.global.wait(l.globalRate)
+		}
+		l.global.tokens--
+	}
+	if callerBucket != nil {
+		callerBucket.tokens--
+	}
+	return true, 0
+}
+
+/*
+callerBucket fetch the bucket of a caller, starting a full one if the caller is not tracked.
+The caller must hold the lock.
+
+	@param caller string - the caller
+	@param timestamp time.Time - the current timestamp
+	@return the caller's bucket
+*/
+func (l *rateLimiterImpl) callerBucket(caller string, timestamp time.Time) *tokenBucket {
+	if element, ok := l.callers[caller]; ok {
+		l.recent.MoveToFront(element)
+		return element.Value.(*tokenBucket)
+	}
+	bucket := &tokenBucket{caller: caller, tokens: l.perCallerBurst, refilled: timestamp}
+	l.callers[caller] = l.recent.PushFront(bucket)
+	// Make room by forgetting the least recently seen caller
+	if l.maxCallers > 0 && l.recent.Len() > l.maxCallers {
+		forgotten := l.recent.Remove(l.recent.Back()).(*tokenBucket)
+		delete(l.callers, forgotten.caller)
+	}
+	return bucket
+}
+
+/*
+rateLimitCaller read who a request is from, for the per caller rate limit
+
+	@param r *http.Request - the request
+	@param config common.PerCallerRateLimitConfig - the per caller rate limit config
+	@param proxies common.TrustedNetworks - the trusted request proxies
+	@param userIDHeader string - the header carrying the caller's user ID, if known
+	@return the caller
+*/
+func rateLimitCaller(
+	r *http.Request,
+	config common.PerCallerRateLimitConfig,
+	proxies common.TrustedNetworks,
+	userIDHeader string,
+) string {
+	if config.CallerKey == common.RateLimitCallerUserID && userIDHeader != "" {
+		if userID := r.Header.Get(userIDHeader); userID != "" {
+			return "user:" + userID
+		}
+	}
+	return "ip:" + proxies.SourceIP(r, config.SourceIPHeader)
+}
+
+/*
+rateLimitMiddleware reject requests exceeding the rate limits with 429
+
+	@param handler goutils.RestAPIHandler - the handler forming the error responses
+	@param config common.RateLimitConfig - the rate limit config
+	@param limiter RateLimiter - the rate limiter
+	@param userIDHeader string - the header carrying the caller's user ID, if known
+	@return the middleware
+*/
+func rateLimitMiddleware(
+	handler goutils.RestAPIHandler,
+	config common.RateLimitConfig,
+	limiter RateLimiter,
+	userIDHeader string,
+) (mux.MiddlewareFunc, error) {
+	proxies, err := common.ParseTrustedNetworks(config.PerCaller.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller := rateLimitCaller(r, config.PerCaller, proxies, userIDHeader)
+			allowed, retryAfter := limiter.Allow(caller, time.Now())
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logTags := handler.GetLogTagsForContext(r.Context())
+			msg := "Request rate limit exceeded"
+			log.WithFields(logTags).Warnf("%s by %s", msg, caller)
+			if err := handler.WriteRESTResponse(
+				w,
+				http.StatusTooManyRequests,
+				newErrorResponse(
+					handler.GetStdRESTErrorMsg(r.Context(), http.StatusTooManyRequests, msg, ""),
+					ErrCodeRateLimited,
+				),
+				map[string]string{"Retry-After": strconv.FormatInt(retryAfter, 10)},
+			); err != nil {
+				log.WithError(err).WithFields(logTags).Error("Failed to form response")
+			}
+		})
+	}, nil
+}
@@ -0,0 +1,162 @@
+package apis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	timestamp := time.Now()
+
+	// Case 0: per caller limit
+	{
+		uut := DefineRateLimiter(common.RateLimitConfig{
+			Enabled: true,
+			PerCaller: common.PerCallerRateLimitConfig{
+				RateLimitBucketConfig: common.RateLimitBucketConfig{Rate: 0.5, Burst: 2},
+			},
+		})
+		for i := 0; i < 2; i++ {
+			allowed, _ := uut.Allow("alice", timestamp)
+			assert.True(allowed)
+		}
+		allowed, retryAfter := uut.Allow("alice", timestamp)
+		assert.False(allowed)
+		assert.Equal(int64(2), retryAfter)
+		// Other callers have their own bucket
+		allowed, _ = uut.Allow("bob", timestamp)
+		assert.True(allowed)
+		// The bucket refills over time
+		allowed, _ = uut.Allow("alice", timestamp.Add(time.Second))
+		assert.False(allowed)
+		allowed, _ = uut.Allow("alice", timestamp.Add(time.Second*2))
+		assert.True(allowed)
+	}
+
+	// Case 1: global limit shared by all callers
+	{
+		uut := DefineRateLimiter(common.RateLimitConfig{
+			Enabled: true,
+			Global:  common.RateLimitBucketConfig{Rate: 10, Burst: 3},
+			PerCaller: common.PerCallerRateLimitConfig{
+				RateLimitBucketConfig: common.RateLimitBucketConfig{Rate: 10, Burst: 2},
+			},
+		})
+		for _, caller := range []string{"alice", "bob", "carol"} {
+			allowed, _ := uut.Allow(caller, timestamp)
+			assert.True(allowed, caller)
+		}
+		allowed, retryAfter := uut.Allow("dave", timestamp)
+		assert.False(allowed)
+		assert.Equal(int64(1), retryAfter)
+		allowed, _ = uut.Allow("dave", timestamp.Add(time.Millisecond*100))
+		assert.True(allowed)
+	}
+
+	// Case 2: the least recently seen caller is forgotten
+	{
+		uut := DefineRateLimiter(common.RateLimitConfig{
+			Enabled: true,
+			PerCaller: common.PerCallerRateLimitConfig{
+				RateLimitBucketConfig: common.RateLimitBucketConfig{Rate: 0.1, Burst: 1},
+				MaxCallers:            2,
+			},
+		})
+		for _, caller := range []string{"alice", "bob", "carol"} {
+			allowed, _ := uut.Allow(caller, timestamp)
+			assert.True(allowed, caller)
+		}
+		// "alice" starts a new full bucket, while "carol" is still limited
+		allowed, _ := uut.Allow("alice", timestamp)
+		assert.True(allowed)
+		allowed, _ = uut.Allow("carol", timestamp)
+		assert.False(allowed)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	config := common.RateLimitConfig{
+		Enabled: true,
+		PerCaller: common.PerCallerRateLimitConfig{
+			RateLimitBucketConfig: common.RateLimitBucketConfig{Rate: 0.01, Burst: 1},
+			CallerKey:             common.RateLimitCallerUserID,
+			SourceIPHeader:        "X-Forwarded-For",
+			TrustedProxies:        []string{"10.0.0.0/8"},
+		},
+	}
+	middleware, err := rateLimitMiddleware(
+		goutils.RestAPIHandler{Component: goutils.Component{LogTags: log.Fields{}}},
+		config,
+		DefineRateLimiter(config),
+		"X-Caller-UserID",
+	)
+	assert.Nil(err)
+	uut := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	remoteAddr := "10.0.0.1:5678"
+	send := func(headers map[string]string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		respRecorder := httptest.NewRecorder()
+		uut.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	// Case 0: callers told apart by user ID
+	{
+		assert.Equal(http.StatusOK, send(map[string]string{"X-Caller-UserID": "alice"}).Code)
+		resp := send(map[string]string{"X-Caller-UserID": "alice"})
+		assert.Equal(http.StatusTooManyRequests, resp.Code)
+		assert.Contains(resp.Body.String(), string(ErrCodeRateLimited))
+		assert.Equal("100", resp.Header().Get("Retry-After"))
+		assert.Equal(http.StatusOK, send(map[string]string{"X-Caller-UserID": "bob"}).Code)
+	}
+
+	// Case 1: callers without a user ID told apart by the forwarded source IP
+	{
+		assert.Equal(
+			http.StatusOK,
+			send(map[string]string{"X-Forwarded-For": "192.168.1.1, 10.0.0.1"}).Code,
+		)
+		assert.Equal(
+			http.StatusTooManyRequests, send(map[string]string{"X-Forwarded-For": "192.168.1.1"}).Code,
+		)
+		// Falling back to the connection source IP
+		assert.Equal(http.StatusOK, send(nil).Code)
+		assert.Equal(http.StatusTooManyRequests, send(nil).Code)
+	}
+
+	// Case 2: the addresses the client adds in front of its own are ignored
+	for _, spoofed := range []string{"172.16.0.1", "172.16.0.2"} {
+		resp := send(map[string]string{"X-Forwarded-For": spoofed + ", 192.168.1.1, 10.0.0.2"})
+		assert.Equal(http.StatusTooManyRequests, resp.Code, spoofed)
+	}
+
+	// Case 3: the header is ignored on connections not from a trusted proxy
+	{
+		remoteAddr = "192.168.7.7:5678"
+		assert.Equal(http.StatusOK, send(map[string]string{"X-Forwarded-For": "192.168.1.1"}).Code)
+		assert.Equal(
+			http.StatusTooManyRequests, send(map[string]string{"X-Forwarded-For": "192.168.1.2"}).Code,
+		)
+	}
+}
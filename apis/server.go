@@ -11,10 +11,12 @@ import (
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/authenticate"
 	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
 	"github.com/alwitt/padlock/match"
 	"github.com/alwitt/padlock/users"
 	"github.com/apex/log"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 )
@@ -26,6 +28,8 @@ BuildMetricsCollectionServer create server to host metrics collection endpoint
 	@param metricsCollector goutils.MetricsCollector - metrics collector
 	@param collectionEndpoint string - endpoint to expose the metrics on
 	@param maxRESTRequests int - max number fo parallel requests to support
+	@param auth common.MetricsAuthConfig - metrics endpoint authentication configuration
+	@param health HealthRegistry - health checks to summarize on "/healthz"
 	@returns HTTP server instance
 */
 func BuildMetricsCollectionServer(
@@ -33,9 +37,16 @@ func BuildMetricsCollectionServer(
 	metricsCollector goutils.MetricsCollector,
 	collectionEndpoint string,
 	maxRESTRequests int,
+	auth common.MetricsAuthConfig,
+	health HealthRegistry,
 ) (*http.Server, error) {
 	router := mux.NewRouter()
 	metricsCollector.ExposeCollectionEndpoint(router, collectionEndpoint, maxRESTRequests)
+	versionHandler := defineVersionHandler(common.HTTPRequestLogging{}, "metrics-version")
+	router.Path("/version").Methods("get").HandlerFunc(versionHandler.VersionHandler())
+	healthHandler := defineHealthHandler(common.HTTPRequestLogging{}, health)
+	router.Path("/healthz").Methods("get").HandlerFunc(healthHandler.HealthHandler())
+	router.Use(metricsAuthMiddleware(auth))
 
 	serverListen := fmt.Sprintf(
 		"%s:%d", httpCfg.ListenOn, httpCfg.Port,
@@ -47,10 +58,29 @@ func BuildMetricsCollectionServer(
 		IdleTimeout:  time.Second * time.Duration(httpCfg.Timeouts.IdleTimeout),
 		Handler:      h2c.NewHandler(router, &http2.Server{}),
 	}
+	if err := applyServerTLS(httpSrv, httpCfg.TLS); err != nil {
+		return nil, err
+	}
 
 	return httpSrv, nil
 }
 
+/*
+applyServerTLS set the TLS config of a HTTP server, if TLS is enabled
+
+	@param httpSrv *http.Server - the HTTP server
+	@param tlsCfg common.ServerTLSConfig - the TLS settings of the server
+*/
+func applyServerTLS(httpSrv *http.Server, tlsCfg common.ServerTLSConfig) error {
+	tlsConfig, err := tlsCfg.DefineTLSConfig()
+	if err != nil {
+		log.WithError(err).Errorf("Unable to define TLS config for server %s", httpSrv.Addr)
+		return err
+	}
+	httpSrv.TLSConfig = tlsConfig
+	return nil
+}
+
 // ====================================================================================
 // User Management Server
 
@@ -61,6 +91,16 @@ BuildUserManagementServer creates the user management server
 	@param manager users.Management - core user management logic block
 	@param validateSupport common.CustomFieldValidator - customer validator support object
 	@param metrics goutils.HTTPRequestMetricHelper - metric collection agent
+	@param routeMetrics RouteMetricsHelper - route labelled metric collection agent. Optional.
+	@param startup common.ReadinessGate - tracks whether the application startup tasks completed
+	@param toggles common.FeatureToggles - the runtime feature toggles
+	@param invalidate invalidation.Bus - if provided, the bus used to request the flushing of the
+	memoized authorization decisions
+	@param history DecisionHistory - the recorded authorization decisions. Optional.
+	@param adminAuth common.ManagementAdminAuthConfig - how the callers of the REST API are
+	authenticated
+	@param tokens BearerTokenVerifier - if admin authentication is enabled, verifies the callers'
+	bearer tokens the same way as the authentication server
 	@return the http.Server
 */
 func BuildUserManagementServer(
@@ -68,17 +108,35 @@ func BuildUserManagementServer(
 	manager users.Management,
 	validateSupport common.CustomFieldValidator,
 	metrics goutils.HTTPRequestMetricHelper,
+	routeMetrics RouteMetricsHelper,
+	startup common.ReadinessGate,
+	toggles common.FeatureToggles,
+	invalidate invalidation.Bus,
+	history DecisionHistory,
+	adminAuth common.ManagementAdminAuthConfig,
+	tokens BearerTokenVerifier,
 ) (*http.Server, error) {
+	if adminAuth.Enabled && tokens == nil {
+		return nil, fmt.Errorf("user management admin authentication requires an OpenID issuer")
+	}
 	coreHandler, err := defineUserManagementHandler(
-		httpCfg.APIs.RequestLogging, manager, validateSupport, metrics,
+		httpCfg.APIs.RequestLogging, manager, validateSupport, metrics, toggles, invalidate, history,
 	)
 	if err != nil {
 		return nil, err
 	}
-	livenessHandler := defineUserManagementLivenessHandler(httpCfg.APIs.RequestLogging, manager)
+	livenessHandler := defineUserManagementLivenessHandler(
+		httpCfg.APIs.RequestLogging, manager, startup,
+	)
 
 	router := mux.NewRouter()
+	router.Use(routeMetricsMiddleware(routeMetrics, ServerNameUserManagement))
+	router.Use(tracingMiddleware(ServerNameUserManagement))
 	mainRouter := registerPathPrefix(router, httpCfg.APIs.Endpoint.PathPrefix, nil)
+	versionHandler := defineVersionHandler(httpCfg.APIs.RequestLogging, "user-management-version")
+	_ = registerPathPrefix(mainRouter, "/version", map[string]http.HandlerFunc{
+		"get": versionHandler.VersionHandler(),
+	})
 	livenessRouter := registerPathPrefix(mainRouter, "/liveness", nil)
 	v1Router := registerPathPrefix(mainRouter, "/v1", nil)
 
@@ -95,6 +153,10 @@ func BuildUserManagementServer(
 		"post": coreHandler.DefineUserHandler(),
 		"get":  coreHandler.ListAllUsersHandler(),
 	})
+	// Must be registered before the per user routes, else "search" is read as a user ID
+	_ = registerPathPrefix(userRouter, "/search", map[string]http.HandlerFunc{
+		"get": coreHandler.SearchUsersHandler(),
+	})
 	perUserRouter := registerPathPrefix(userRouter, "/{userID}", map[string]http.HandlerFunc{
 		"get":    coreHandler.GetUserHandler(),
 		"delete": coreHandler.DeleteUserHandler(),
@@ -103,6 +165,56 @@ func BuildUserManagementServer(
 	_ = registerPathPrefix(perUserRouter, "/roles", map[string]http.HandlerFunc{
 		"put": coreHandler.UpdateUserRolesHandler(),
 	})
+	_ = registerPathPrefix(perUserRouter, "/permissions", map[string]http.HandlerFunc{
+		"get": coreHandler.GetUserPermissionsHandler(),
+		"put": coreHandler.UpdateUserPermissionsHandler(),
+	})
+	apiKeyRouter := registerPathPrefix(perUserRouter, "/api-key", map[string]http.HandlerFunc{
+		"post": coreHandler.CreateAPIKeyHandler(),
+		"get":  coreHandler.ListAPIKeysHandler(),
+	})
+	_ = registerPathPrefix(apiKeyRouter, "/{keyID}", map[string]http.HandlerFunc{
+		"delete": coreHandler.RevokeAPIKeyHandler(),
+	})
+
+	// Group management
+	groupRouter := registerPathPrefix(v1Router, "/group", map[string]http.HandlerFunc{
+		"post": coreHandler.DefineGroupHandler(),
+		"get":  coreHandler.ListAllGroupsHandler(),
+	})
+	perGroupRouter := registerPathPrefix(groupRouter, "/{groupName}", map[string]http.HandlerFunc{
+		"get":    coreHandler.GetGroupHandler(),
+		"delete": coreHandler.DeleteGroupHandler(),
+	})
+	_ = registerPathPrefix(perGroupRouter, "/roles", map[string]http.HandlerFunc{
+		"put": coreHandler.UpdateGroupRolesHandler(),
+	})
+	groupUsersRouter := registerPathPrefix(perGroupRouter, "/users", map[string]http.HandlerFunc{
+		"post": coreHandler.AddGroupUsersHandler(),
+	})
+	_ = registerPathPrefix(groupUsersRouter, "/{userID}", map[string]http.HandlerFunc{
+		"delete": coreHandler.RemoveGroupUserHandler(),
+	})
+
+	// Authorization decision history
+	_ = registerPathPrefix(v1Router, "/decisions", map[string]http.HandlerFunc{
+		"get": coreHandler.ListDecisionsHandler(),
+	})
+
+	// User change event stream
+	_ = registerPathPrefix(v1Router, "/events", map[string]http.HandlerFunc{
+		"get": coreHandler.StreamEventsHandler(),
+	})
+
+	// Runtime feature toggles
+	adminRouter := registerPathPrefix(v1Router, "/admin", nil)
+	_ = registerPathPrefix(adminRouter, "/toggles", map[string]http.HandlerFunc{
+		"get": coreHandler.GetFeatureTogglesHandler(),
+		"put": coreHandler.UpdateFeatureTogglesHandler(),
+	})
+	_ = registerPathPrefix(adminRouter, "/decision-cache", map[string]http.HandlerFunc{
+		"delete": coreHandler.FlushDecisionCacheHandler(),
+	})
 
 	// Health check
 	_ = registerPathPrefix(livenessRouter, "/alive", map[string]http.HandlerFunc{
@@ -112,7 +224,9 @@ func BuildUserManagementServer(
 		"get": livenessHandler.ReadyHandler(),
 	})
 
-	// Add logging middleware
+	// Add logging middleware. The event stream needs the write deadline controls the logging
+	// middleware hides.
+	v1Router.Use(responseControllerMiddleware)
 	v1Router.Use(func(next http.Handler) http.Handler {
 		return coreHandler.LoggingMiddleware(next.ServeHTTP)
 	})
@@ -120,6 +234,11 @@ func BuildUserManagementServer(
 		return livenessHandler.LoggingMiddleware(next.ServeHTTP)
 	})
 
+	// Only admins may use the API
+	if adminAuth.Enabled {
+		v1Router.Use(adminAuthMiddleware(coreHandler.RestAPIHandler, adminAuth, tokens, manager))
+	}
+
 	serverListen := fmt.Sprintf(
 		"%s:%d", httpCfg.Server.ListenOn, httpCfg.Server.Port,
 	)
@@ -130,6 +249,9 @@ func BuildUserManagementServer(
 		IdleTimeout:  time.Second * time.Duration(httpCfg.Server.Timeouts.IdleTimeout),
 		Handler:      h2c.NewHandler(router, &http2.Server{}),
 	}
+	if err := applyServerTLS(httpSrv, httpCfg.Server.TLS); err != nil {
+		return nil, err
+	}
 
 	return httpSrv, nil
 }
@@ -147,7 +269,11 @@ BuildAuthorizationServer creates the authorization server
 	@param checkHeaders common.AuthorizeRequestParamLocConfig - param on which headers to search for
 	parameters regarding a REST API to authorize.
 	@param forUnknownUser common.UnknownUserActionConfig - param on how to handle new unknown user
+	@param respConfig common.AuthorizeResponseConfig - param on how to respond to requests
 	@param metrics goutils.HTTPRequestMetricHelper - metric collection agent
+	@param startup common.ReadinessGate - tracks whether the application startup tasks completed
+	@param inFlight common.InFlightTracker - tracks the authorization requests being processed
+	@param opts AuthorizationOptions - the optional subsystems and settings
 	@return the http.Server
 */
 func BuildAuthorizationServer(
@@ -157,7 +283,11 @@ func BuildAuthorizationServer(
 	validateSupport common.CustomFieldValidator,
 	checkHeaders common.AuthorizeRequestParamLocConfig,
 	forUnknownUser common.UnknownUserActionConfig,
+	respConfig common.AuthorizeResponseConfig,
 	metrics goutils.HTTPRequestMetricHelper,
+	startup common.ReadinessGate,
+	inFlight common.InFlightTracker,
+	opts AuthorizationOptions,
 ) (*http.Server, error) {
 	coreHandler, err := defineAuthorizationHandler(
 		httpCfg.APIs.RequestLogging,
@@ -166,21 +296,38 @@ func BuildAuthorizationServer(
 		validateSupport,
 		checkHeaders,
 		forUnknownUser,
+		respConfig,
 		metrics,
+		opts,
 	)
 	if err != nil {
 		return nil, err
 	}
-	livenessHandler := defineAuthorizationLivenessHandler(httpCfg.APIs.RequestLogging, manager)
+	livenessHandler := defineAuthorizationLivenessHandler(
+		httpCfg.APIs.RequestLogging, manager, startup,
+	)
 
 	router := mux.NewRouter()
+	router.Use(routeMetricsMiddleware(opts.RouteMetrics, ServerNameAuthorization))
+	router.Use(tracingMiddleware(ServerNameAuthorization))
+	bypassMiddleware, err := cacheBypassMiddleware(httpCfg.APIs.CacheBypass)
+	if err != nil {
+		return nil, err
+	}
+	router.Use(bypassMiddleware)
 	mainRouter := registerPathPrefix(router, httpCfg.APIs.Endpoint.PathPrefix, nil)
+	versionHandler := defineVersionHandler(httpCfg.APIs.RequestLogging, "authorization-version")
+	_ = registerPathPrefix(mainRouter, "/version", map[string]http.HandlerFunc{
+		"get": versionHandler.VersionHandler(),
+	})
 	livenessRouter := registerPathPrefix(mainRouter, "/liveness", nil)
 	v1Router := registerPathPrefix(mainRouter, "/v1", nil)
 
 	// Authorize
 	_ = registerPathPrefix(v1Router, "/allow", map[string]http.HandlerFunc{
-		"get": coreHandler.AllowHandler(),
+		"get":  coreHandler.AllowHandler(),
+		"head": coreHandler.AllowHandler(),
+		"post": coreHandler.AllowHandler(),
 	})
 
 	// Health check
@@ -199,11 +346,28 @@ func BuildAuthorizationServer(
 		return livenessHandler.LoggingMiddleware(next.ServeHTTP)
 	})
 
+	// Reject requests over the rate limits
+	if httpCfg.APIs.RateLimit.Enabled {
+		rateLimit, err := rateLimitMiddleware(
+			coreHandler.RestAPIHandler,
+			httpCfg.APIs.RateLimit,
+			DefineRateLimiter(httpCfg.APIs.RateLimit),
+			checkHeaders.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		v1Router.Use(rateLimit)
+	}
+
 	// Add request parameter extract middleware
 	v1Router.Use(func(next http.Handler) http.Handler {
 		return coreHandler.ParamReadMiddleware(next.ServeHTTP)
 	})
 
+	// Track in-flight requests
+	v1Router.Use(trackInFlightMiddleware(inFlight, ServerNameAuthorization))
+
 	serverListen := fmt.Sprintf(
 		"%s:%d", httpCfg.Server.ListenOn, httpCfg.Server.Port,
 	)
@@ -214,6 +378,9 @@ func BuildAuthorizationServer(
 		IdleTimeout:  time.Second * time.Duration(httpCfg.Server.Timeouts.IdleTimeout),
 		Handler:      h2c.NewHandler(router, &http2.Server{}),
 	}
+	if err := applyServerTLS(httpSrv, httpCfg.Server.TLS); err != nil {
+		return nil, err
+	}
 
 	return httpSrv, nil
 }
@@ -222,29 +389,16 @@ func BuildAuthorizationServer(
 // Authentication Server
 
 /*
-BuildAuthenticationServer creates the authentication server
+DefineOpenIDIssuerClient performs OpenID issuer discovery, and defines the issuer client
 
-	@param httpCfg common.HTTPConfig - HTTP server config
 	@param openIDCfg common.OpenIDIssuerConfig - OpenID issuer configuration
-	@parem performIntrospection bool - whether to perform introspection
-	@param tokenCache authenticate.TokenCache - cache to reduce number of introspections
-	@param authnConfig common.AuthenticationConfig - authentication submodule configuration
-	@param respHeaderParam common.AuthorizeRequestParamLocConfig - config which indicates what
-	response headers to output the user parameters on.
-	@param metrics goutils.HTTPRequestMetricHelper - metric collection agent
-	@return the http.Server
+	@return the OpenID issuer client
 */
-func BuildAuthenticationServer(
-	httpCfg common.APIServerConfig,
+func DefineOpenIDIssuerClient(
 	openIDCfg common.OpenIDIssuerConfig,
-	performIntrospection bool,
-	tokenCache authenticate.TokenCache,
-	authnConfig common.AuthenticationConfig,
-	respHeaderParam common.AuthorizeRequestParamLocConfig,
-	metrics goutils.HTTPRequestMetricHelper,
-) (*http.Server, error) {
+) (authenticate.OpenIDIssuerClient, error) {
 	// Define custom HTTP client for connecting with OpenID issuer
-	oidHTTPClient := http.Client{}
+	transport := http.DefaultTransport
 	// Define the TLS settings if custom CA was provided
 	if openIDCfg.CustomCA != nil {
 		caCert, err := os.ReadFile(*openIDCfg.CustomCA)
@@ -255,15 +409,62 @@ func BuildAuthenticationServer(
 		caCertPool := x509.NewCertPool()
 		caCertPool.AppendCertsFromPEM(caCert)
 		tlsConfig := &tls.Config{RootCAs: caCertPool}
-		oidHTTPClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+		transport = &http.Transport{TLSClientConfig: tlsConfig}
 	}
+	// Trace the calls to the issuer, as part of the request being authenticated
+	oidHTTPClient := http.Client{Transport: otelhttp.NewTransport(transport)}
 
-	oidClient, err := authenticate.DefineOpenIDClient(openIDCfg, &oidHTTPClient)
-	if err != nil {
-		return nil, err
-	}
+	return authenticate.DefineOpenIDClient(openIDCfg, &oidHTTPClient)
+}
+
+/*
+BuildAuthenticationServer creates the authentication server
 
-	introspector := authenticate.DefineIntrospector(tokenCache, oidClient.IntrospectToken)
+	@param httpCfg common.HTTPConfig - HTTP server config
+	@param oidClient authenticate.OpenIDIssuerClient - OpenID issuer client
+	@parem performIntrospection bool - whether to perform introspection
+	@param introspector authenticate.Introspector - introspects tokens, with a cache to reduce the
+	number of introspections
+	@param authnConfig common.AuthenticationConfig - authentication submodule configuration
+	@param respHeaderParam common.AuthorizeRequestParamLocConfig - config which indicates what
+	response headers to output the user parameters on.
+	@param metrics goutils.HTTPRequestMetricHelper - metric collection agent
+	@param routeMetrics RouteMetricsHelper - route labelled metric collection agent. Optional.
+	@param decisionMetrics DecisionMetricsHelper - authorization decision and authentication
+	outcome metric collection agent. Optional.
+	@param startup common.ReadinessGate - tracks whether the application startup tasks completed
+	@param issuerHealth authenticate.IssuerHealthStatus - if provided, the server only reports
+	ready while the OpenID issuer is healthy
+	@param inFlight common.InFlightTracker - tracks the authentication requests being processed
+	@param toggles common.FeatureToggles - the runtime feature toggles
+	@param issuers []common.OpenIDIssuerConfig - the parameters of the OpenID issuers, whose
+	claims of interest override the authentication config's
+	@param apiKeys users.Management - if API key authentication is enabled, the user manager
+	verifying the API keys
+	@param authorizer http.Handler - if provided, the HTTP handler of the authorization server,
+	which "/v1/check" authorizes the authenticated requests with
+	@param authorizePathPrefix string - the authorization server's end-point path prefix
+	@return the http.Server
+*/
+func BuildAuthenticationServer(
+	httpCfg common.APIServerConfig,
+	oidClient authenticate.OpenIDIssuerClient,
+	performIntrospection bool,
+	introspector authenticate.Introspector,
+	authnConfig common.AuthenticationConfig,
+	respHeaderParam common.AuthorizeRequestParamLocConfig,
+	metrics goutils.HTTPRequestMetricHelper,
+	routeMetrics RouteMetricsHelper,
+	decisionMetrics DecisionMetricsHelper,
+	startup common.ReadinessGate,
+	issuerHealth authenticate.IssuerHealthStatus,
+	inFlight common.InFlightTracker,
+	toggles common.FeatureToggles,
+	issuers []common.OpenIDIssuerConfig,
+	apiKeys users.Management,
+	authorizer http.Handler,
+	authorizePathPrefix string,
+) (*http.Server, error) {
 	coreHandler, err := defineAuthenticationHandler(
 		httpCfg.APIs.RequestLogging,
 		oidClient,
@@ -272,20 +473,53 @@ func BuildAuthenticationServer(
 		authnConfig,
 		respHeaderParam,
 		metrics,
+		decisionMetrics,
+		toggles,
+		issuers,
+		apiKeys,
 	)
 	if err != nil {
 		return nil, err
 	}
-	livenessHandler := defineAuthenticationLivenessHandler(httpCfg.APIs.RequestLogging)
+	livenessHandler := defineAuthenticationLivenessHandler(
+		httpCfg.APIs.RequestLogging, startup, issuerHealth,
+	)
 
 	router := mux.NewRouter()
+	router.Use(routeMetricsMiddleware(routeMetrics, ServerNameAuthentication))
+	router.Use(tracingMiddleware(ServerNameAuthentication))
+	bypassMiddleware, err := cacheBypassMiddleware(httpCfg.APIs.CacheBypass)
+	if err != nil {
+		return nil, err
+	}
+	router.Use(bypassMiddleware)
 	mainRouter := registerPathPrefix(router, httpCfg.APIs.Endpoint.PathPrefix, nil)
+	versionHandler := defineVersionHandler(httpCfg.APIs.RequestLogging, "authentication-version")
+	_ = registerPathPrefix(mainRouter, "/version", map[string]http.HandlerFunc{
+		"get": versionHandler.VersionHandler(),
+	})
 	livenessRouter := registerPathPrefix(mainRouter, "/liveness", nil)
 	v1Router := registerPathPrefix(mainRouter, "/v1", nil)
 
 	// Authentication
 	_ = registerPathPrefix(v1Router, "/authenticate", map[string]http.HandlerFunc{
-		"get": coreHandler.AuthenticateHandler(),
+		"get":  coreHandler.AuthenticateHandler(),
+		"post": coreHandler.AuthenticateHandler(),
+	})
+
+	// Combined authentication and authorization
+	if authorizer != nil {
+		checker := defineCheckHandler(coreHandler, authorizer, authorizePathPrefix)
+		_ = registerPathPrefix(v1Router, "/check", map[string]http.HandlerFunc{
+			"get":  checker.CheckHandler(),
+			"post": checker.CheckHandler(),
+		})
+	}
+
+	// Token introspection on behalf of backends
+	tokenRouter := registerPathPrefix(v1Router, "/token", nil)
+	_ = registerPathPrefix(tokenRouter, "/introspect", map[string]http.HandlerFunc{
+		"post": coreHandler.IntrospectTokenHandler(),
 	})
 
 	// Health check
@@ -304,6 +538,24 @@ func BuildAuthenticationServer(
 		return livenessHandler.LoggingMiddleware(next.ServeHTTP)
 	})
 
+	// Reject requests over the rate limits. The callers are not yet authenticated, so they are
+	// told apart by source IP.
+	if httpCfg.APIs.RateLimit.Enabled {
+		rateLimit, err := rateLimitMiddleware(
+			coreHandler.RestAPIHandler,
+			httpCfg.APIs.RateLimit,
+			DefineRateLimiter(httpCfg.APIs.RateLimit),
+			"",
+		)
+		if err != nil {
+			return nil, err
+		}
+		v1Router.Use(rateLimit)
+	}
+
+	// Track in-flight requests
+	v1Router.Use(trackInFlightMiddleware(inFlight, ServerNameAuthentication))
+
 	serverListen := fmt.Sprintf(
 		"%s:%d", httpCfg.Server.ListenOn, httpCfg.Server.Port,
 	)
@@ -314,6 +566,9 @@ func BuildAuthenticationServer(
 		IdleTimeout:  time.Second * time.Duration(httpCfg.Server.Timeouts.IdleTimeout),
 		Handler:      h2c.NewHandler(router, &http2.Server{}),
 	}
+	if err := applyServerTLS(httpSrv, httpCfg.Server.TLS); err != nil {
+		return nil, err
+	}
 
 	return httpSrv, nil
 }
@@ -0,0 +1,48 @@
+package apis
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the OpenTelemetry tracer of the API handlers
+const tracerName = "github.com/alwitt/padlock/apis"
+
+// tracingMiddleware record an OpenTelemetry span for each request, named by the matched route
+// template, continuing the trace context of the request if present
+func tracingMiddleware(server string) mux.MiddlewareFunc {
+	tracer := otel.Tracer(tracerName)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := matchedRouteTemplate(r)
+			ctxt := otel.GetTextMapPropagator().Extract(
+				r.Context(), propagation.HeaderCarrier(r.Header),
+			)
+			ctxt, span := tracer.Start(
+				ctxt,
+				fmt.Sprintf("%s %s", r.Method, route),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPMethod(r.Method),
+					semconv.HTTPRoute(route),
+					attribute.String("padlock.server", server),
+				),
+			)
+			defer span.End()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(ctxt))
+			span.SetAttributes(semconv.HTTPStatusCode(recorder.status))
+			if recorder.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(recorder.status))
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package apis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Install the trace propagator only
+	stopTracing, err := common.InstallTracing(context.Background(), common.TracingConfig{})
+	assert.Nil(err)
+	defer func() {
+		assert.Nil(stopTracing(context.Background()))
+	}()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	router := mux.NewRouter()
+	router.Use(tracingMiddleware(ServerNameAuthorization))
+	v1Router := registerPathPrefix(router, "/v1", nil)
+	_ = registerPathPrefix(v1Router, "/user/{userID}", map[string]http.HandlerFunc{
+		"get": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+	})
+	_ = registerPathPrefix(v1Router, "/role/{roleName}", map[string]http.HandlerFunc{
+		"get": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+	})
+
+	call := func(path string, headers map[string]string) {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.Nil(err)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+	}
+	spanAttribute := func(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+		for _, attr := range span.Attributes() {
+			if attr.Key == key {
+				return attr.Value
+			}
+		}
+		return attribute.Value{}
+	}
+
+	// Case 0: span is named by the route template
+	call("/v1/user/user-1", nil)
+	{
+		spans := recorder.Ended()
+		assert.Len(spans, 1)
+		span := spans[0]
+		assert.Equal("GET /v1/user/{userID}", span.Name())
+		assert.Equal("/v1/user/{userID}", spanAttribute(span, "http.route").AsString())
+		assert.Equal(int64(http.StatusOK), spanAttribute(span, "http.status_code").AsInt64())
+		assert.Equal(ServerNameAuthorization, spanAttribute(span, "padlock.server").AsString())
+		assert.Equal(codes.Unset, span.Status().Code)
+		assert.False(span.Parent().IsValid())
+	}
+
+	// Case 1: server error marks the span as failed
+	call("/v1/role/role-1", nil)
+	{
+		spans := recorder.Ended()
+		assert.Len(spans, 2)
+		span := spans[1]
+		assert.Equal("GET /v1/role/{roleName}", span.Name())
+		assert.Equal(codes.Error, span.Status().Code)
+	}
+
+	// Case 2: trace context of the request is continued
+	call("/v1/user/user-2", map[string]string{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	{
+		spans := recorder.Ended()
+		assert.Len(spans, 3)
+		span := spans[2]
+		assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		assert.True(span.Parent().IsRemote())
+		assert.Equal("00f067aa0ba902b7", span.Parent().SpanID().String())
+	}
+}
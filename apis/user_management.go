@@ -1,12 +1,17 @@
 package apis
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
 	"github.com/alwitt/padlock/models"
 	"github.com/alwitt/padlock/users"
 	"github.com/apex/log"
@@ -19,6 +24,11 @@ type UserManagementHandler struct {
 	goutils.RestAPIHandler
 	validate *validator.Validate
 	core     users.Management
+	toggles  common.FeatureToggles
+	// invalidate if provided, used to request the flushing of the memoized authorization decisions
+	invalidate invalidation.Bus
+	// history if provided, the recorded authorization decisions
+	history DecisionHistory
 }
 
 // defineUserManagementHandler define a new UserManagementHandler instance
@@ -27,6 +37,9 @@ func defineUserManagementHandler(
 	core users.Management,
 	validateSupport common.CustomFieldValidator,
 	metrics goutils.HTTPRequestMetricHelper,
+	toggles common.FeatureToggles,
+	invalidate invalidation.Bus,
+	history DecisionHistory,
 ) (UserManagementHandler, error) {
 	validate := validator.New()
 	if err := validateSupport.RegisterWithValidator(validate); err != nil {
@@ -56,8 +69,11 @@ func defineUserManagementHandler(
 			LogLevel:      logConfig.LogLevel,
 			MetricsHelper: metrics,
 		},
-		validate: validate,
-		core:     core,
+		validate:   validate,
+		core:       core,
+		toggles:    toggles,
+		invalidate: invalidate,
+		history:    history,
 	}, nil
 }
 
@@ -73,14 +89,16 @@ type RespListAllRoles struct {
 
 // ListAllRoles godoc
 // @Summary List All Roles
-// @Description List all roles the system is operating against
+// @Description List all roles the system is operating against. If a permission is given, only
+// @Description list the roles which grant that permission.
 // @tags Management
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param permission query string false "Only list roles which grant this permission"
 // @Success 200 {object} RespListAllRoles "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/role [get]
 func (h UserManagementHandler) ListAllRoles(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -92,19 +110,53 @@ func (h UserManagementHandler) ListAllRoles(w http.ResponseWriter, r *http.Reque
 		}
 	}()
 
+	// Get the optional permission filter
+	permission := r.URL.Query().Get("permission")
+	if permission != "" {
+		type testStruct struct {
+			Permission string `validate:"required,user_permissions"`
+		}
+		if err := h.validate.Struct(&testStruct{Permission: permission}); err != nil {
+			msg := "permission filter is not valid"
+			log.WithError(err).WithFields(logTags).Error(msg)
+			respCode = http.StatusBadRequest
+			response = newErrorResponse(
+				h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+				ErrCodeInvalidRequest,
+			)
+			return
+		}
+	}
+
 	roles, err := h.core.ListAllRoles(r.Context())
 	if err != nil {
 		msg := "Failed to query for all roles in system"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(
-			r.Context(), http.StatusInternalServerError, msg, err.Error(),
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
 		)
-	} else {
-		respCode = http.StatusOK
-		response = RespListAllRoles{
-			RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Roles: roles,
+		return
+	}
+
+	// Only keep the roles which grant the permission
+	if permission != "" {
+		filtered := map[string]common.UserRoleConfig{}
+		for roleName, roleInfo := range roles {
+			for _, onePerm := range roleInfo.AssignedPermissions {
+				if onePerm == permission {
+					filtered[roleName] = roleInfo
+					break
+				}
+			}
 		}
+		roles = filtered
+	}
+
+	respCode = http.StatusOK
+	response = RespListAllRoles{
+		RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Roles: roles,
 	}
 }
 
@@ -134,9 +186,9 @@ type RespRoleInfo struct {
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Param roleName path string true "Role name"
 // @Success 200 {object} RespRoleInfo "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/role/{roleName} [get]
 func (h UserManagementHandler) GetRole(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -154,11 +206,11 @@ func (h UserManagementHandler) GetRole(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		log.WithFields(logTags).Errorf("Role name missing")
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(
-			r.Context(),
-			http.StatusBadRequest,
-			"role name missing",
-			"Role name must be provided",
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(
+				r.Context(), http.StatusBadRequest, "role name missing", "Role name must be provided",
+			),
+			ErrCodeInvalidRequest,
 		)
 		return
 	}
@@ -169,7 +221,10 @@ func (h UserManagementHandler) GetRole(w http.ResponseWriter, r *http.Request) {
 		msg := fmt.Sprintf("role name %s is not valid", roleName)
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -178,7 +233,10 @@ func (h UserManagementHandler) GetRole(w http.ResponseWriter, r *http.Request) {
 		msg := fmt.Sprintf("Failed to query for role %s", roleName)
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
 	} else {
 		respCode = http.StatusOK
 		response = RespRoleInfo{
@@ -216,9 +274,9 @@ type ReqNewUserParams struct {
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Param userInfo body ReqNewUserParams true "New user information"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/user [post]
 func (h UserManagementHandler) DefineUser(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -235,14 +293,20 @@ func (h UserManagementHandler) DefineUser(w http.ResponseWriter, r *http.Request
 		msg := "new user parameters not parsable"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 	if err := h.validate.Struct(&userInfo); err != nil {
 		msg := "new user parameters not valid"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -250,7 +314,10 @@ func (h UserManagementHandler) DefineUser(w http.ResponseWriter, r *http.Request
 		msg := fmt.Sprintf("Failed to define new user %s", userInfo.User.UserID)
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
 	} else {
 		respCode = http.StatusOK
 		response = h.GetStdRESTSuccessMsg(r.Context())
@@ -280,9 +347,9 @@ type RespListAllUsers struct {
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Success 200 {object} RespListAllUsers "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/user [get]
 func (h UserManagementHandler) ListAllUsers(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -299,8 +366,9 @@ func (h UserManagementHandler) ListAllUsers(w http.ResponseWriter, r *http.Reque
 		msg := "Failed to query for all users in system"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(
-			r.Context(), http.StatusInternalServerError, msg, err.Error(),
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
 		)
 	} else {
 		respCode = http.StatusOK
@@ -319,6 +387,98 @@ func (h UserManagementHandler) ListAllUsersHandler() http.HandlerFunc {
 
 // -----------------------------------------------------------------------
 
+// SearchUsers godoc
+// @Summary Search for users
+// @Description Search for users matching all of the provided filters, ordered by user ID
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param user_id_prefix query string false "Only match users whose user ID starts with this prefix"
+// @Param username query string false "Only match users with this username"
+// @Param email query string false "Only match users with this email"
+// @Param role query string false "Only match users with this role"
+// @Param account_type query string false "Only match users of this account type: user or service_account"
+// @Param limit query int false "Max number of users to return"
+// @Success 200 {object} RespListAllUsers "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/user/search [get]
+func (h UserManagementHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	// Parse the search filter
+	query := r.URL.Query()
+	filter := models.UserSearchFilter{
+		UserIDPrefix: query.Get("user_id_prefix"),
+		Username:     query.Get("username"),
+		Email:        query.Get("email"),
+		Role:         query.Get("role"),
+		AccountType:  query.Get("account_type"),
+	}
+	type testStruct struct {
+		Username    string `validate:"omitempty,username"`
+		Email       string `validate:"omitempty,email"`
+		Role        string `validate:"omitempty,role_name"`
+		AccountType string `validate:"omitempty,oneof=user service_account"`
+	}
+	err := h.validate.Struct(&testStruct{
+		Username:    filter.Username,
+		Email:       filter.Email,
+		Role:        filter.Role,
+		AccountType: filter.AccountType,
+	})
+	if err == nil && query.Get("limit") != "" {
+		filter.Limit, err = strconv.Atoi(query.Get("limit"))
+		if err == nil && filter.Limit < 0 {
+			err = fmt.Errorf("limit can't be negative")
+		}
+	}
+	if err != nil {
+		msg := "user search filter is not valid"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	users, err := h.core.SearchUsers(r.Context(), filter)
+	if err != nil {
+		msg := "Failed to search for users"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+		return
+	}
+
+	respCode = http.StatusOK
+	response = RespListAllUsers{
+		RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Users: users,
+	}
+}
+
+// SearchUsersHandler Wrapper around SearchUsers
+func (h UserManagementHandler) SearchUsersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.SearchUsers(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
 // fetchUserID helper function to fetch the user ID from URI path
 func (h UserManagementHandler) fetchUserID(r *http.Request) (string, error) {
 	vars := mux.Vars(r)
@@ -350,9 +510,9 @@ type RespUserInfo struct {
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Param userID path string true "User ID"
 // @Success 200 {object} RespUserInfo "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/user/{userID} [get]
 func (h UserManagementHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -370,7 +530,10 @@ func (h UserManagementHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 		msg := "no valid user ID"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -379,7 +542,10 @@ func (h UserManagementHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 		msg := fmt.Sprintf("Failed to query for user %s", userID)
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
 	} else {
 		respCode = http.StatusOK
 		response = RespUserInfo{
@@ -397,6 +563,101 @@ func (h UserManagementHandler) GetUserHandler() http.HandlerFunc {
 
 // -----------------------------------------------------------------------
 
+// RespUserPermissions is the API response giving the effective permissions of one user
+type RespUserPermissions struct {
+	goutils.RestAPIBaseResponse
+	// Permissions are the permissions the user has based on the roles associated with the user,
+	// and the permissions granted directly to the user
+	Permissions []string `json:"permissions" validate:"required"`
+	// Granted are the permissions granted directly to the user
+	Granted []string `json:"granted"`
+	// Checks are whether the user has each of the permissions listed in the request
+	Checks map[string]bool `json:"checks,omitempty"`
+}
+
+// GetUserPermissions godoc
+// @Summary Get a user's effective permissions
+// @Description Query for the permissions a user has based on its roles and direct grants, optionally checking
+// @Description whether the user has specific permissions.
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param userID path string true "User ID"
+// @Param check query string false "Comma separated list of permissions to check for"
+// @Success 200 {object} RespUserPermissions "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/user/{userID}/permissions [get]
+func (h UserManagementHandler) GetUserPermissions(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	// Get user ID
+	userID, err := h.fetchUserID(r)
+	if err != nil {
+		msg := "no valid user ID"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	userInfo, err := h.core.GetUser(r.Context(), userID)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query for user %s", userID)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+		return
+	}
+
+	var checks map[string]bool
+	if checkParam := r.URL.Query().Get("check"); checkParam != "" {
+		hasPermission := map[string]bool{}
+		for _, onePerm := range userInfo.AssociatedPermission {
+			hasPermission[onePerm] = true
+		}
+		checks = map[string]bool{}
+		for _, onePerm := range strings.Split(checkParam, ",") {
+			onePerm = strings.TrimSpace(onePerm)
+			if onePerm == "" {
+				continue
+			}
+			checks[onePerm] = hasPermission[onePerm]
+		}
+	}
+
+	respCode = http.StatusOK
+	response = RespUserPermissions{
+		RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()),
+		Permissions:         userInfo.AssociatedPermission,
+		Granted:             userInfo.Permissions,
+		Checks:              checks,
+	}
+}
+
+// GetUserPermissionsHandler Wrapper around GetUserPermissions
+func (h UserManagementHandler) GetUserPermissionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.GetUserPermissions(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
 // DeleteUser godoc
 // @Summary Delete user
 // @Description Remove user from the system.
@@ -405,9 +666,9 @@ func (h UserManagementHandler) GetUserHandler() http.HandlerFunc {
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
 // @Param userID path string true "User ID"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/user/{userID} [delete]
 func (h UserManagementHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -425,7 +686,10 @@ func (h UserManagementHandler) DeleteUser(w http.ResponseWriter, r *http.Request
 		msg := "no valid user ID"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -433,7 +697,10 @@ func (h UserManagementHandler) DeleteUser(w http.ResponseWriter, r *http.Request
 		msg := fmt.Sprintf("Failed to delete user %s", userID)
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
 	} else {
 		respCode = http.StatusOK
 		response = h.GetStdRESTSuccessMsg(r.Context())
@@ -459,9 +726,9 @@ func (h UserManagementHandler) DeleteUserHandler() http.HandlerFunc {
 // @Param userID path string true "User ID"
 // @Param userInfo body models.UserConfig true "Updated user information"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/user/{userID} [put]
 func (h UserManagementHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -479,7 +746,10 @@ func (h UserManagementHandler) UpdateUser(w http.ResponseWriter, r *http.Request
 		msg := "no valid user ID"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -488,14 +758,20 @@ func (h UserManagementHandler) UpdateUser(w http.ResponseWriter, r *http.Request
 		msg := "user parameters not parsable"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 	if err := h.validate.Struct(&userInfo); err != nil {
 		msg := "user parameters not valid"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -503,7 +779,10 @@ func (h UserManagementHandler) UpdateUser(w http.ResponseWriter, r *http.Request
 		msg := fmt.Sprintf("Failed to update user %s", userID)
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
 	} else {
 		respCode = http.StatusOK
 		response = h.GetStdRESTSuccessMsg(r.Context())
@@ -535,9 +814,9 @@ type ReqNewUserRoles struct {
 // @Param userID path string true "User ID"
 // @Param roles body ReqNewUserRoles true "User's new roles"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 500 {object} RespError "error"
 // @Router /v1/user/{userID}/roles [put]
 func (h UserManagementHandler) UpdateUserRoles(w http.ResponseWriter, r *http.Request) {
 	var respCode int
@@ -555,7 +834,10 @@ func (h UserManagementHandler) UpdateUserRoles(w http.ResponseWriter, r *http.Re
 		msg := "no valid user ID"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -564,14 +846,20 @@ func (h UserManagementHandler) UpdateUserRoles(w http.ResponseWriter, r *http.Re
 		msg := "new role parameters not parsable"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 	if err := h.validate.Struct(&newRoles); err != nil {
 		msg := "new role parameters not valid"
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusBadRequest
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
 		return
 	}
 
@@ -579,7 +867,10 @@ func (h UserManagementHandler) UpdateUserRoles(w http.ResponseWriter, r *http.Re
 		msg := fmt.Sprintf("Failed to set user %s roles", userID)
 		log.WithError(err).WithFields(logTags).Error(msg)
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error())
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
 	} else {
 		respCode = http.StatusOK
 		response = h.GetStdRESTSuccessMsg(r.Context())
@@ -593,86 +884,140 @@ func (h UserManagementHandler) UpdateUserRolesHandler() http.HandlerFunc {
 	}
 }
 
-// ====================================================================================
-// Utilities
-
-// UserManagementLivenessHandler the user / role management REST API liveness handler
-type UserManagementLivenessHandler struct {
-	goutils.RestAPIHandler
-	core users.Management
-}
-
-func defineUserManagementLivenessHandler(
-	logConfig common.HTTPRequestLogging,
-	core users.Management,
-) UserManagementLivenessHandler {
-	logTags := log.Fields{
-		"module": "apis", "component": "api-handler", "instance": "user-management-liveness",
-	}
+// -----------------------------------------------------------------------
 
-	return UserManagementLivenessHandler{
-		RestAPIHandler: goutils.RestAPIHandler{
-			Component: goutils.Component{
-				LogTags: logTags,
-				LogTagModifiers: []goutils.LogMetadataModifier{
-					goutils.ModifyLogMetadataByRestRequestParam,
-					common.ModifyLogMetadataByAccessAuthorizeParam,
-				},
-			},
-			CallRequestIDHeaderField: &logConfig.RequestIDHeader,
-			DoNotLogHeaders: func() map[string]bool {
-				result := map[string]bool{}
-				for _, v := range logConfig.DoNotLogHeaders {
-					result[v] = true
-				}
-				return result
-			}(),
-			LogLevel: logConfig.HealthLogLevel,
-		}, core: core,
-	}
+// ReqNewUserPermissions is the new permissions to grant directly to the user
+type ReqNewUserPermissions struct {
+	// Permissions list the permissions to grant to this user
+	Permissions []string `json:"permissions" validate:"omitempty,dive,user_permissions"`
 }
 
-// Alive godoc
-// @Summary User Management API liveness check
-// @Description Will return success to indicate user management REST API module is live
+// UpdateUserPermissions godoc
+// @Summary Update a user's directly granted permissions
+// @Description Change the permissions granted directly to the user, in addition to the
+// @Description permissions of its roles, to what caller requested. The permissions must be
+// @Description assigned to at least one of the configured roles.
 // @tags Management
+// @Accept json
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param userID path string true "User ID"
+// @Param permissions body ReqNewUserPermissions true "User's new directly granted permissions"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
-// @Router /v1/alive [get]
-func (h UserManagementLivenessHandler) Alive(w http.ResponseWriter, r *http.Request) {
+// @Failure 500 {object} RespError "error"
+// @Router /v1/user/{userID}/permissions [put]
+func (h UserManagementHandler) UpdateUserPermissions(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
 	logTags := h.GetLogTagsForContext(r.Context())
-	if err := h.WriteRESTResponse(
-		w, http.StatusOK, h.GetStdRESTSuccessMsg(r.Context()), nil,
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	// Get user ID
+	userID, err := h.fetchUserID(r)
+	if err != nil {
+		msg := "no valid user ID"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	var newPermissions ReqNewUserPermissions
+	if err := json.NewDecoder(r.Body).Decode(&newPermissions); err != nil {
+		msg := "new permission parameters not parsable"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+	if err := h.validate.Struct(&newPermissions); err != nil {
+		msg := "new permission parameters not valid"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	if err := h.core.SetUserPermissions(
+		r.Context(), userID, newPermissions.Permissions,
 	); err != nil {
-		log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		msg := fmt.Sprintf("Failed to set user %s permissions", userID)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
 	}
 }
 
-// AliveHandler Wrapper around Alive
-func (h UserManagementLivenessHandler) AliveHandler() http.HandlerFunc {
+// UpdateUserPermissionsHandler Wrapper around UpdateUserPermissions
+func (h UserManagementHandler) UpdateUserPermissionsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		h.Alive(w, r)
+		h.UpdateUserPermissions(w, r)
 	}
 }
 
-// -----------------------------------------------------------------------
+// ====================================================================================
+// Group Management
 
-// Ready godoc
-// @Summary User Management API readiness check
-// @Description Will return success if user management REST API module is ready for use
+// fetchGroupName helper function to fetch the group name from URI path
+func (h UserManagementHandler) fetchGroupName(r *http.Request) (string, error) {
+	vars := mux.Vars(r)
+	groupName, ok := vars["groupName"]
+	if !ok {
+		return "", fmt.Errorf("missing group name in URI path")
+	}
+	type testStruct struct {
+		Group string `validate:"required,role_name"`
+	}
+	if err := h.validate.Struct(&testStruct{Group: groupName}); err != nil {
+		return "", err
+	}
+	return groupName, nil
+}
+
+// ReqNewGroupParams is the API request with information on a new group
+type ReqNewGroupParams struct {
+	// Group is the group name
+	Group string `json:"group" validate:"required,role_name"`
+	// Roles list the roles to assign to the members of this group
+	Roles []string `json:"roles" validate:"omitempty,dive,role_name"`
+}
+
+// DefineGroup godoc
+// @Summary Define new group
+// @Description Define a new group, and optionally assign roles to it. The members of a group
+// @Description hold the roles of the group, in addition to their own roles.
 // @tags Management
+// @Accept json
 // @Produce json
 // @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param groupInfo body ReqNewGroupParams true "New group information"
 // @Success 200 {object} goutils.RestAPIBaseResponse "success"
-// @Failure 400 {object} goutils.RestAPIBaseResponse "error"
+// @Failure 400 {object} RespError "error"
 // @Failure 404 {string} string "error"
-// @Failure 500 {object} goutils.RestAPIBaseResponse "error"
-// @Router /v1/ready [get]
-func (h UserManagementLivenessHandler) Ready(w http.ResponseWriter, r *http.Request) {
+// @Failure 500 {object} RespError "error"
+// @Router /v1/group [post]
+func (h UserManagementHandler) DefineGroup(w http.ResponseWriter, r *http.Request) {
 	var respCode int
 	var response interface{}
 	logTags := h.GetLogTagsForContext(r.Context())
@@ -681,10 +1026,1286 @@ func (h UserManagementLivenessHandler) Ready(w http.ResponseWriter, r *http.Requ
 			log.WithError(err).WithFields(logTags).Error("Failed to form response")
 		}
 	}()
-	if err := h.core.Ready(); err != nil {
+
+	var groupInfo ReqNewGroupParams
+	if err := json.NewDecoder(r.Body).Decode(&groupInfo); err != nil {
+		msg := "new group parameters not parsable"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+	if err := h.validate.Struct(&groupInfo); err != nil {
+		msg := "new group parameters not valid"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	if err := h.core.DefineGroup(r.Context(), groupInfo.Group, groupInfo.Roles); err != nil {
+		msg := fmt.Sprintf("Failed to define new group %s", groupInfo.Group)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
+	}
+}
+
+// DefineGroupHandler Wrapper around DefineGroup
+func (h UserManagementHandler) DefineGroupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.DefineGroup(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// RespListAllGroups is the API response listing all the groups the system is managing
+type RespListAllGroups struct {
+	goutils.RestAPIBaseResponse
+	// Groups are the group names
+	Groups []string `json:"groups" validate:"required"`
+}
+
+// ListAllGroups godoc
+// @Summary List all groups
+// @Description List all groups managed by the system.
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Success 200 {object} RespListAllGroups "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/group [get]
+func (h UserManagementHandler) ListAllGroups(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	groups, err := h.core.ListAllGroups(r.Context())
+	if err != nil {
+		msg := "Failed to query for all groups in system"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = RespListAllGroups{
+			RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Groups: groups,
+		}
+	}
+}
+
+// ListAllGroupsHandler Wrapper around ListAllGroups
+func (h UserManagementHandler) ListAllGroupsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ListAllGroups(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// RespGroupInfo is the API response giving info on one group
+type RespGroupInfo struct {
+	goutils.RestAPIBaseResponse
+	// Group is info on this group
+	Group models.GroupDetails `json:"group" validate:"required,dive"`
+}
+
+// GetGroup godoc
+// @Summary Get info on group
+// @Description Query for information regarding one group, along with its roles and members.
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param groupName path string true "Group name"
+// @Success 200 {object} RespGroupInfo "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/group/{groupName} [get]
+func (h UserManagementHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	groupName, err := h.fetchGroupName(r)
+	if err != nil {
+		msg := "no valid group name"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	groupInfo, err := h.core.GetGroup(r.Context(), groupName)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query for group %s", groupName)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = RespGroupInfo{
+			RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Group: groupInfo,
+		}
+	}
+}
+
+// GetGroupHandler Wrapper around GetGroup
+func (h UserManagementHandler) GetGroupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.GetGroup(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// DeleteGroup godoc
+// @Summary Delete a group
+// @Description Remove a group from the system. The members of the group are not deleted.
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param groupName path string true "Group name"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/group/{groupName} [delete]
+func (h UserManagementHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	groupName, err := h.fetchGroupName(r)
+	if err != nil {
+		msg := "no valid group name"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	if err := h.core.DeleteGroup(r.Context(), groupName); err != nil {
+		msg := fmt.Sprintf("Failed to delete group %s", groupName)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
+	}
+}
+
+// DeleteGroupHandler Wrapper around DeleteGroup
+func (h UserManagementHandler) DeleteGroupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.DeleteGroup(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// ReqNewGroupRoles is the new roles to be assigned to the group
+type ReqNewGroupRoles struct {
+	// Roles list the roles to assign to the members of this group
+	Roles []string `json:"roles" validate:"omitempty,dive,role_name"`
+}
+
+// UpdateGroupRoles godoc
+// @Summary Update a group's roles
+// @Description Change the group's roles to what caller requested
+// @tags Management
+// @Accept json
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param groupName path string true "Group name"
+// @Param roles body ReqNewGroupRoles true "Group's new roles"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/group/{groupName}/roles [put]
+func (h UserManagementHandler) UpdateGroupRoles(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	groupName, err := h.fetchGroupName(r)
+	if err != nil {
+		msg := "no valid group name"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	var newRoles ReqNewGroupRoles
+	if err := json.NewDecoder(r.Body).Decode(&newRoles); err != nil {
+		msg := "new role parameters not parsable"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+	if err := h.validate.Struct(&newRoles); err != nil {
+		msg := "new role parameters not valid"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	if err := h.core.SetGroupRoles(r.Context(), groupName, newRoles.Roles); err != nil {
+		msg := fmt.Sprintf("Failed to set group %s roles", groupName)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
+	}
+}
+
+// UpdateGroupRolesHandler Wrapper around UpdateGroupRoles
+func (h UserManagementHandler) UpdateGroupRolesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.UpdateGroupRoles(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// ReqGroupUsers is the users to add to a group
+type ReqGroupUsers struct {
+	// Users list the IDs of the users to add to this group
+	Users []string `json:"users" validate:"required,gt=0,dive,user_id"`
+}
+
+// AddGroupUsers godoc
+// @Summary Add users to a group
+// @Description Add users to a group, so they hold the roles of the group
+// @tags Management
+// @Accept json
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param groupName path string true "Group name"
+// @Param users body ReqGroupUsers true "Users to add to the group"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/group/{groupName}/users [post]
+func (h UserManagementHandler) AddGroupUsers(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	groupName, err := h.fetchGroupName(r)
+	if err != nil {
+		msg := "no valid group name"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	var newUsers ReqGroupUsers
+	if err := json.NewDecoder(r.Body).Decode(&newUsers); err != nil {
+		msg := "group member parameters not parsable"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+	if err := h.validate.Struct(&newUsers); err != nil {
+		msg := "group member parameters not valid"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	if err := h.core.AddUsersToGroup(r.Context(), groupName, newUsers.Users); err != nil {
+		msg := fmt.Sprintf("Failed to add users to group %s", groupName)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
+	}
+}
+
+// AddGroupUsersHandler Wrapper around AddGroupUsers
+func (h UserManagementHandler) AddGroupUsersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.AddGroupUsers(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// RemoveGroupUser godoc
+// @Summary Remove a user from a group
+// @Description Remove a user from a group, so it no longer holds the roles of the group
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param groupName path string true "Group name"
+// @Param userID path string true "User ID"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/group/{groupName}/users/{userID} [delete]
+func (h UserManagementHandler) RemoveGroupUser(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	groupName, err := h.fetchGroupName(r)
+	if err != nil {
+		msg := "no valid group name"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+	userID, err := h.fetchUserID(r)
+	if err != nil {
+		msg := "no valid user ID"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	if err := h.core.RemoveUsersFromGroup(
+		r.Context(), groupName, []string{userID},
+	); err != nil {
+		msg := fmt.Sprintf("Failed to remove user %s from group %s", userID, groupName)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
+	}
+}
+
+// RemoveGroupUserHandler Wrapper around RemoveGroupUser
+func (h UserManagementHandler) RemoveGroupUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.RemoveGroupUser(w, r)
+	}
+}
+
+// ====================================================================================
+// API Key Management
+
+// fetchKeyID helper function to read the API key ID from the URI path
+func (h UserManagementHandler) fetchKeyID(r *http.Request) (string, error) {
+	vars := mux.Vars(r)
+	keyID, ok := vars["keyID"]
+	if !ok {
+		return "", fmt.Errorf("missing API key ID in URI path")
+	}
+	type testStruct struct {
+		KeyID string `validate:"required,uuid"`
+	}
+	if err := h.validate.Struct(&testStruct{KeyID: keyID}); err != nil {
+		return "", err
+	}
+	return keyID, nil
+}
+
+// ReqNewAPIKey is the API request with information on a new API key
+type ReqNewAPIKey struct {
+	// Description describes the purpose of the API key
+	Description *string `json:"description,omitempty"`
+	// ExpiresAt if provided, is when the API key expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// RespNewAPIKey is the API response giving a newly minted API key
+type RespNewAPIKey struct {
+	goutils.RestAPIBaseResponse
+	// Key is the API key. It is only returned once.
+	Key string `json:"key" validate:"required"`
+	// Info is info on the API key
+	Info models.APIKeyInfo `json:"info" validate:"required"`
+}
+
+// CreateAPIKey godoc
+// @Summary Mint a new API key for a user
+// @Description Mint a new API key, which machine clients present to the authentication server
+// @Description to authenticate as the user. The key is only returned in this response.
+// @tags Management
+// @Accept json
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param userID path string true "User ID"
+// @Param keyInfo body ReqNewAPIKey true "API key parameters"
+// @Success 200 {object} RespNewAPIKey "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/user/{userID}/api-key [post]
+func (h UserManagementHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	userID, err := h.fetchUserID(r)
+	if err != nil {
+		msg := "no valid user ID"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	var params ReqNewAPIKey
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		msg := "API key parameters not parsable"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		msg := "API key parameters not valid"
+		err := fmt.Errorf("expiration %s is in the past", params.ExpiresAt.Format(time.RFC3339))
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	key, info, err := h.core.CreateAPIKey(r.Context(), userID, params.Description, params.ExpiresAt)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to mint API key for user %s", userID)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+		return
+	}
+
+	respCode = http.StatusOK
+	response = RespNewAPIKey{
+		RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Key: key, Info: info,
+	}
+}
+
+// CreateAPIKeyHandler Wrapper around CreateAPIKey
+func (h UserManagementHandler) CreateAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.CreateAPIKey(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// RespListAPIKeys is the API response listing the API keys of a user
+type RespListAPIKeys struct {
+	goutils.RestAPIBaseResponse
+	// Keys are info on the API keys of the user
+	Keys []models.APIKeyInfo `json:"keys" validate:"required"`
+}
+
+// ListAPIKeys godoc
+// @Summary List the API keys of a user
+// @Description List the API keys of a user. The keys themselves are not returned.
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param userID path string true "User ID"
+// @Success 200 {object} RespListAPIKeys "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/user/{userID}/api-key [get]
+func (h UserManagementHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	userID, err := h.fetchUserID(r)
+	if err != nil {
+		msg := "no valid user ID"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	keys, err := h.core.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to query for API keys of user %s", userID)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+		return
+	}
+
+	respCode = http.StatusOK
+	response = RespListAPIKeys{RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Keys: keys}
+}
+
+// ListAPIKeysHandler Wrapper around ListAPIKeys
+func (h UserManagementHandler) ListAPIKeysHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ListAPIKeys(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key of a user
+// @Description Revoke an API key of a user, so it is no longer accepted by the authentication server
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param userID path string true "User ID"
+// @Param keyID path string true "API key ID"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/user/{userID}/api-key/{keyID} [delete]
+func (h UserManagementHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	userID, err := h.fetchUserID(r)
+	if err != nil {
+		msg := "no valid user ID"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+	keyID, err := h.fetchKeyID(r)
+	if err != nil {
+		msg := "no valid API key ID"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	if err := h.core.RevokeAPIKey(r.Context(), userID, keyID); err != nil {
+		msg := fmt.Sprintf("Failed to revoke API key %s of user %s", keyID, userID)
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			errorCodeFor(err, ErrCodeInternal),
+		)
+	} else {
+		respCode = http.StatusOK
+		response = h.GetStdRESTSuccessMsg(r.Context())
+	}
+}
+
+// RevokeAPIKeyHandler Wrapper around RevokeAPIKey
+func (h UserManagementHandler) RevokeAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.RevokeAPIKey(w, r)
+	}
+}
+
+// ====================================================================================
+// Runtime Feature Toggles
+
+// RespFeatureToggles is the API response giving the state of the runtime feature toggles
+type RespFeatureToggles struct {
+	goutils.RestAPIBaseResponse
+	// Toggles is the current toggle state
+	Toggles common.FeatureToggleState `json:"toggles"`
+}
+
+// GetFeatureToggles godoc
+// @Summary Get runtime feature toggles
+// @Description Get the current state of the runtime feature toggles
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Success 200 {object} RespFeatureToggles "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/admin/toggles [get]
+func (h UserManagementHandler) GetFeatureToggles(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	if h.toggles == nil {
+		msg := "runtime feature toggles not supported"
+		log.WithFields(logTags).Error(msg)
+		respCode = http.StatusNotFound
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusNotFound, msg, ""),
+			ErrCodeFeatureDisabled,
+		)
+		return
+	}
+
+	respCode = http.StatusOK
+	response = RespFeatureToggles{
+		RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()),
+		Toggles:             h.toggles.CurrentState(),
+	}
+}
+
+// GetFeatureTogglesHandler Wrapper around GetFeatureToggles
+func (h UserManagementHandler) GetFeatureTogglesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.GetFeatureToggles(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// UpdateFeatureToggles godoc
+// @Summary Update runtime feature toggles
+// @Description Change the runtime feature toggles. Toggles not provided are left unchanged.
+// @tags Management
+// @Accept json
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param toggles body common.FeatureToggleUpdate true "Toggles to change"
+// @Success 200 {object} RespFeatureToggles "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/admin/toggles [put]
+func (h UserManagementHandler) UpdateFeatureToggles(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	if h.toggles == nil {
+		msg := "runtime feature toggles not supported"
+		log.WithFields(logTags).Error(msg)
+		respCode = http.StatusNotFound
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusNotFound, msg, ""),
+			ErrCodeFeatureDisabled,
+		)
+		return
+	}
+
+	var update common.FeatureToggleUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		msg := "feature toggle update not parsable"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	newState := h.toggles.UpdateState(update)
+	log.WithFields(logTags).Infof(
+		"Feature toggles changed: introspection=%v auto_add_user=%v dry_run=%v",
+		newState.Introspection,
+		newState.AutoAddUser,
+		newState.DryRun,
+	)
+	respCode = http.StatusOK
+	response = RespFeatureToggles{
+		RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Toggles: newState,
+	}
+}
+
+// UpdateFeatureTogglesHandler Wrapper around UpdateFeatureToggles
+func (h UserManagementHandler) UpdateFeatureTogglesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.UpdateFeatureToggles(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// FlushDecisionCache godoc
+// @Summary Flush memoized authorization decisions
+// @Description Flush the memoized authorization decisions on all replicas. If a user ID is
+// @Description given, only flush the decisions of that user.
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param user_id query string false "Only flush the decisions of this user"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/admin/decision-cache [delete]
+func (h UserManagementHandler) FlushDecisionCache(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	if h.invalidate == nil {
+		msg := "authorization decision cache not supported"
+		log.WithFields(logTags).Error(msg)
+		respCode = http.StatusNotFound
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusNotFound, msg, ""),
+			ErrCodeFeatureDisabled,
+		)
+		return
+	}
+
+	// Decisions are memoized under the normalized user ID
+	userID := users.NormalizeUserID(h.core, r.URL.Query().Get("user_id"))
+	if userID != "" {
+		if err := h.validate.Var(userID, "user_id"); err != nil {
+			msg := "user ID not valid"
+			log.WithError(err).WithFields(logTags).Error(msg)
+			respCode = http.StatusBadRequest
+			response = newErrorResponse(
+				h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+				ErrCodeInvalidRequest,
+			)
+			return
+		}
+	}
+
+	if err := h.invalidate.Publish(r.Context(), invalidation.TargetDecision, userID); err != nil {
+		msg := "failed to request decision cache flush"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			ErrCodeInternal,
+		)
+		return
+	}
+
+	if userID != "" {
+		log.WithFields(logTags).Infof("Requested flush of decisions of user %s", userID)
+	} else {
+		log.WithFields(logTags).Info("Requested flush of all decisions")
+	}
+	respCode = http.StatusOK
+	response = h.GetStdRESTSuccessMsg(r.Context())
+}
+
+// FlushDecisionCacheHandler Wrapper around FlushDecisionCache
+func (h UserManagementHandler) FlushDecisionCacheHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.FlushDecisionCache(w, r)
+	}
+}
+
+// ====================================================================================
+// Authorization Decision History
+
+// defaultDecisionQueryLimit is the max number of decisions returned if no limit is given
+const defaultDecisionQueryLimit = 100
+
+// RespListDecisions is the API response listing recorded authorization decisions
+type RespListDecisions struct {
+	goutils.RestAPIBaseResponse
+	// Decisions are the decisions, newest first
+	Decisions []models.DecisionRecord `json:"decisions"`
+}
+
+// ListDecisions godoc
+// @Summary List recorded authorization decisions
+// @Description List the recorded authorization decisions matching all of the provided
+// @Description filters, newest first.
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param user query string false "Only list the decisions of this user"
+// @Param since query string false "Only list decisions made at or after this RFC3339 time"
+// @Param until query string false "Only list decisions made before this RFC3339 time"
+// @Param limit query int false "Max number of decisions to return. Defaults to 100."
+// @Success 200 {object} RespListDecisions "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {object} RespError "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/decisions [get]
+func (h UserManagementHandler) ListDecisions(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+
+	if h.history == nil {
+		msg := "authorization decision history not supported"
+		log.WithFields(logTags).Error(msg)
+		respCode = http.StatusNotFound
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusNotFound, msg, ""),
+			ErrCodeFeatureDisabled,
+		)
+		return
+	}
+
+	// Parse the query filter
+	query := r.URL.Query()
+	filter := models.DecisionHistoryFilter{
+		UserID: users.NormalizeUserID(h.core, query.Get("user")), Limit: defaultDecisionQueryLimit,
+	}
+	parseTime := func(param string) (*time.Time, error) {
+		if query.Get(param) == "" {
+			return nil, nil
+		}
+		timestamp, err := time.Parse(time.RFC3339, query.Get(param))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a RFC3339 time: %w", param, err)
+		}
+		return &timestamp, nil
+	}
+	var err error
+	if filter.UserID != "" {
+		err = h.validate.Var(filter.UserID, "user_id")
+	}
+	if err == nil {
+		filter.Since, err = parseTime("since")
+	}
+	if err == nil {
+		filter.Until, err = parseTime("until")
+	}
+	if err == nil && query.Get("limit") != "" {
+		filter.Limit, err = strconv.Atoi(query.Get("limit"))
+		if err == nil && filter.Limit < 1 {
+			err = fmt.Errorf("limit must be positive")
+		}
+	}
+	if err != nil {
+		msg := "decision query filter is not valid"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusBadRequest
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+			ErrCodeInvalidRequest,
+		)
+		return
+	}
+
+	decisions, err := h.history.Query(r.Context(), filter)
+	if err != nil {
+		msg := "Failed to query decision history"
+		log.WithError(err).WithFields(logTags).Error(msg)
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(r.Context(), http.StatusInternalServerError, msg, err.Error()),
+			ErrCodeInternal,
+		)
+		return
+	}
+
+	respCode = http.StatusOK
+	response = RespListDecisions{
+		RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), Decisions: decisions,
+	}
+}
+
+// ListDecisionsHandler Wrapper around ListDecisions
+func (h UserManagementHandler) ListDecisionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ListDecisions(w, r)
+	}
+}
+
+// responseControllerKey is the context key for the response controller of the response writer
+// given by the HTTP server
+type responseControllerKey struct{}
+
+/*
+responseControllerMiddleware record the response controller of the response writer given by
+the HTTP server, before the logging middleware wraps the writer. The wrapper hides the write
+deadline controls.
+
+	@param next http.Handler - the next handler
+	@return the wrapped handler
+*/
+func responseControllerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxt := context.WithValue(r.Context(), responseControllerKey{}, http.NewResponseController(w))
+		next.ServeHTTP(w, r.WithContext(ctxt))
+	})
+}
+
+/*
+responseControllerFor get the response controller recorded by responseControllerMiddleware,
+or one for the given response writer if none was recorded
+
+	@param w http.ResponseWriter - the response writer
+	@param r *http.Request - the request
+	@return the response controller
+*/
+func responseControllerFor(w http.ResponseWriter, r *http.Request) *http.ResponseController {
+	if controller, ok := r.Context().Value(responseControllerKey{}).(*http.ResponseController); ok {
+		return controller
+	}
+	return http.NewResponseController(w)
+}
+
+// eventStreamKeepAlive is the interval between the keep-alive comments of an idle event stream
+const eventStreamKeepAlive = time.Second * 15
+
+// StreamEvents godoc
+// @Summary Stream user change events
+// @Description Stream, as server-sent events, the users defined, updated, or deleted, and the
+// @Description changes to their role assignments, made through this replica from now on. The
+// @Description SSE event name is the event type. The stream ends at the server write timeout,
+// @Description after which the client should reconnect.
+// @tags Management
+// @Produce text/event-stream
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Param events query string false "Comma separated event types to stream. Defaults to all."
+// @Success 200 {object} users.UserEvent "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/events [get]
+func (h UserManagementHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	logTags := h.GetLogTagsForContext(r.Context())
+
+	// Parse the event types of interest
+	wanted := map[string]bool{}
+	if query := r.URL.Query().Get("events"); query != "" {
+		for _, event := range strings.Split(query, ",") {
+			if err := h.validate.Var(event, fmt.Sprintf(
+				"oneof=%s %s %s %s",
+				users.UserEventCreated,
+				users.UserEventUpdated,
+				users.UserEventDeleted,
+				users.UserEventRolesChanged,
+			)); err != nil {
+				msg := "event stream filter is not valid"
+				err = fmt.Errorf("unknown event type %s", event)
+				log.WithError(err).WithFields(logTags).Error(msg)
+				if err := h.WriteRESTResponse(w, http.StatusBadRequest, newErrorResponse(
+					h.GetStdRESTErrorMsg(r.Context(), http.StatusBadRequest, msg, err.Error()),
+					ErrCodeInvalidRequest,
+				), nil); err != nil {
+					log.WithError(err).WithFields(logTags).Error("Failed to form response")
+				}
+				return
+			}
+			wanted[event] = true
+		}
+	}
+
+	flusher := http.NewResponseController(w)
+	// The stream outlives the server write timeout, which is meant for ordinary responses
+	if err := responseControllerFor(w, r).SetWriteDeadline(time.Time{}); err != nil {
+		log.WithError(err).WithFields(logTags).Warn("Unable to clear the write deadline")
+	}
+	events, unsubscribe := h.core.SubscribeEvents()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := flusher.Flush(); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Event stream not supported")
+		return
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(wanted) > 0 && !wanted[event.Event] {
+				continue
+			}
+			payload, _ := json.Marshal(&event)
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, payload)
+		}
+		if err == nil {
+			err = flusher.Flush()
+		}
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Debug("Event stream closed")
+			return
+		}
+	}
+}
+
+// StreamEventsHandler Wrapper around StreamEvents
+func (h UserManagementHandler) StreamEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.StreamEvents(w, r)
+	}
+}
+
+// ====================================================================================
+// Utilities
+
+// UserManagementLivenessHandler the user / role management REST API liveness handler
+type UserManagementLivenessHandler struct {
+	goutils.RestAPIHandler
+	core    users.Management
+	startup common.ReadinessGate
+}
+
+func defineUserManagementLivenessHandler(
+	logConfig common.HTTPRequestLogging,
+	core users.Management,
+	startup common.ReadinessGate,
+) UserManagementLivenessHandler {
+	logTags := log.Fields{
+		"module": "apis", "component": "api-handler", "instance": "user-management-liveness",
+	}
+
+	return UserManagementLivenessHandler{
+		RestAPIHandler: goutils.RestAPIHandler{
+			Component: goutils.Component{
+				LogTags: logTags,
+				LogTagModifiers: []goutils.LogMetadataModifier{
+					goutils.ModifyLogMetadataByRestRequestParam,
+					common.ModifyLogMetadataByAccessAuthorizeParam,
+				},
+			},
+			CallRequestIDHeaderField: &logConfig.RequestIDHeader,
+			DoNotLogHeaders: func() map[string]bool {
+				result := map[string]bool{}
+				for _, v := range logConfig.DoNotLogHeaders {
+					result[v] = true
+				}
+				return result
+			}(),
+			LogLevel: logConfig.HealthLogLevel,
+		}, core: core, startup: startup,
+	}
+}
+
+// Alive godoc
+// @Summary User Management API liveness check
+// @Description Will return success to indicate user management REST API module is live
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/alive [get]
+func (h UserManagementLivenessHandler) Alive(w http.ResponseWriter, r *http.Request) {
+	logTags := h.GetLogTagsForContext(r.Context())
+	if err := h.WriteRESTResponse(
+		w, http.StatusOK, h.GetStdRESTSuccessMsg(r.Context()), nil,
+	); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to form response")
+	}
+}
+
+// AliveHandler Wrapper around Alive
+func (h UserManagementLivenessHandler) AliveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.Alive(w, r)
+	}
+}
+
+// -----------------------------------------------------------------------
+
+// Ready godoc
+// @Summary User Management API readiness check
+// @Description Will return success if user management REST API module is ready for use
+// @tags Management
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Success 200 {object} goutils.RestAPIBaseResponse "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /v1/ready [get]
+func (h UserManagementLivenessHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	var respCode int
+	var response interface{}
+	logTags := h.GetLogTagsForContext(r.Context())
+	defer func() {
+		if err := h.WriteRESTResponse(w, respCode, response, nil); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to form response")
+		}
+	}()
+	if err := h.startup.Ready(); err != nil {
+		respCode = http.StatusInternalServerError
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(
+				r.Context(), http.StatusInternalServerError, "not ready", err.Error(),
+			),
+			ErrCodeNotReady,
+		)
+	} else if err := h.core.Ready(); err != nil {
 		respCode = http.StatusInternalServerError
-		response = h.GetStdRESTErrorMsg(
-			r.Context(), http.StatusInternalServerError, "not ready", err.Error(),
+		response = newErrorResponse(
+			h.GetStdRESTErrorMsg(
+				r.Context(), http.StatusInternalServerError, "not ready", err.Error(),
+			),
+			ErrCodeNotReady,
 		)
 	} else {
 		respCode = http.StatusOK
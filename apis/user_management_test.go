@@ -1,15 +1,19 @@
 package apis
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
 	"github.com/alwitt/padlock/models"
 	"github.com/alwitt/padlock/users"
 	"github.com/apex/log"
@@ -39,7 +43,7 @@ func TestRoleManagementAPI(t *testing.T) {
 	assert.Nil(err)
 	assert.Nil(dbClient.Ready())
 
-	mgmtCore, err := users.CreateManagement(dbClient)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
 	assert.Nil(err)
 	assert.Nil(mgmtCore.Ready())
 
@@ -50,11 +54,16 @@ func TestRoleManagementAPI(t *testing.T) {
 		mgmtCore,
 		supportMatch,
 		nil,
+		nil,
+		nil,
+		nil,
 	)
 	assert.Nil(err)
+	startup := common.DefineReadinessGate()
 	liveness := defineUserManagementLivenessHandler(
 		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
 		mgmtCore,
+		startup,
 	)
 
 	checkHeader := func(w http.ResponseWriter, reqID string) {
@@ -62,6 +71,22 @@ func TestRoleManagementAPI(t *testing.T) {
 		assert.Equal("application/json", w.Header().Get("content-type"))
 	}
 
+	// Case 0: not ready until startup completes
+	{
+		rid := uuid.New().String()
+		req, err := http.NewRequest("GET", "/v1/ready", nil)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := liveness.LoggingMiddleware(liveness.ReadyHandler())
+		handler.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusInternalServerError, respRecorder.Code)
+		checkHeader(respRecorder, rid)
+	}
+	startup.MarkReady()
+
 	// Case 0: check ready
 	{
 		rid := uuid.New().String()
@@ -91,6 +116,9 @@ func TestRoleManagementAPI(t *testing.T) {
 
 		assert.Equal(http.StatusInternalServerError, respRecorder.Code)
 		checkHeader(respRecorder, rid)
+		var msg RespError
+		assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &msg))
+		assert.Equal(ErrCodeRoleUnknown, msg.Code)
 	}
 
 	// Define test roles
@@ -157,6 +185,46 @@ func TestRoleManagementAPI(t *testing.T) {
 		assert.EqualValues(testRoles[roles[2]], msg.Role)
 		assert.Empty(msg.AssignedUsers)
 	}
+
+	// Case 3: filter the roles by permission
+	{
+		rid := uuid.New().String()
+		req, err := http.NewRequest("GET", fmt.Sprintf("/v1/role?permission=%s", permissions[1]), nil)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ListAllRolesHandler())
+		handler.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusOK, respRecorder.Code)
+		checkHeader(respRecorder, rid)
+
+		respMsg := respRecorder.Body.Bytes()
+		var msg RespListAllRoles
+		assert.Nil(json.Unmarshal(respMsg, &msg))
+		assert.Len(msg.Roles, 2)
+		assert.Contains(msg.Roles, roles[0])
+		assert.Contains(msg.Roles, roles[2])
+	}
+	{
+		rid := uuid.New().String()
+		req, err := http.NewRequest(
+			"GET", fmt.Sprintf("/v1/role?permission=%s", uuid.New().String()), nil,
+		)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.ListAllRolesHandler())
+		handler.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusOK, respRecorder.Code)
+		respMsg := respRecorder.Body.Bytes()
+		var msg RespListAllRoles
+		assert.Nil(json.Unmarshal(respMsg, &msg))
+		assert.Empty(msg.Roles)
+	}
 }
 
 func TestUserManagementAPI(t *testing.T) {
@@ -177,7 +245,7 @@ func TestUserManagementAPI(t *testing.T) {
 	assert.Nil(err)
 	assert.Nil(dbClient.Ready())
 
-	mgmtCore, err := users.CreateManagement(dbClient)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
 	assert.Nil(err)
 	assert.Nil(mgmtCore.Ready())
 
@@ -188,12 +256,18 @@ func TestUserManagementAPI(t *testing.T) {
 		mgmtCore,
 		supportMatch,
 		nil,
+		nil,
+		nil,
+		nil,
 	)
 	assert.Nil(err)
+	startup := common.DefineReadinessGate()
 	liveness := defineUserManagementLivenessHandler(
 		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
 		mgmtCore,
+		startup,
 	)
+	startup.MarkReady()
 
 	checkHeader := func(w http.ResponseWriter, reqID string) {
 		assert.Equal(reqID, w.Header().Get(requestIDHeader))
@@ -245,6 +319,9 @@ func TestUserManagementAPI(t *testing.T) {
 
 		assert.Equal(http.StatusInternalServerError, respRecorder.Code)
 		checkHeader(respRecorder, rid)
+		var msg RespError
+		assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &msg))
+		assert.Equal(ErrCodeUserNotFound, msg.Code)
 	}
 	{
 		rid := uuid.New().String()
@@ -367,6 +444,33 @@ func TestUserManagementAPI(t *testing.T) {
 			strListToMap(msg.User.AssociatedPermission),
 		)
 	}
+	{
+		rid := uuid.New().String()
+		unknownPerm := uuid.New().String()
+		req, err := http.NewRequest(
+			"GET",
+			fmt.Sprintf("/v1/user/%s/permissions?check=%s,%s", user2, permissions[0], unknownPerm),
+			nil,
+		)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		router := mux.NewRouter()
+		respRecorder := httptest.NewRecorder()
+		router.HandleFunc(
+			"/v1/user/{userID}/permissions", uut.LoggingMiddleware(uut.GetUserPermissionsHandler()),
+		)
+		router.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusOK, respRecorder.Code)
+		checkHeader(respRecorder, rid)
+
+		respMsg := respRecorder.Body.Bytes()
+		var msg RespUserPermissions
+		assert.Nil(json.Unmarshal(respMsg, &msg))
+		assert.EqualValues(strListToMap(permissions), strListToMap(msg.Permissions))
+		assert.EqualValues(map[string]bool{permissions[0]: true, unknownPerm: false}, msg.Checks)
+	}
 
 	// Case 4: change user information
 	{
@@ -486,3 +590,605 @@ func TestUserManagementAPI(t *testing.T) {
 		)
 	}
 }
+
+func TestGroupManagementAPI(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	uut, err := defineUserManagementHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		supportMatch,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/group", uut.DefineGroupHandler()).Methods("POST")
+	router.HandleFunc("/v1/group", uut.ListAllGroupsHandler()).Methods("GET")
+	router.HandleFunc("/v1/group/{groupName}", uut.GetGroupHandler()).Methods("GET")
+	router.HandleFunc("/v1/group/{groupName}", uut.DeleteGroupHandler()).Methods("DELETE")
+	router.HandleFunc("/v1/group/{groupName}/roles", uut.UpdateGroupRolesHandler()).Methods("PUT")
+	router.HandleFunc("/v1/group/{groupName}/users", uut.AddGroupUsersHandler()).Methods("POST")
+	router.HandleFunc(
+		"/v1/group/{groupName}/users/{userID}", uut.RemoveGroupUserHandler(),
+	).Methods("DELETE")
+	call := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var payload []byte
+		if body != nil {
+			payload, err = json.Marshal(body)
+			assert.Nil(err)
+		}
+		req, err := http.NewRequest(method, path, bytes.NewReader(payload))
+		assert.Nil(err)
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+		"writer": {AssignedPermissions: []string{"read", "write"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+	userID := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(context.Background(), models.UserConfig{UserID: userID}, nil))
+
+	// Case 0: unknown group
+	{
+		resp := call("GET", "/v1/group/editors", nil)
+		assert.Equal(http.StatusInternalServerError, resp.Code)
+		var msg RespError
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &msg))
+		assert.Equal(ErrCodeGroupNotFound, msg.Code)
+	}
+
+	// Case 1: define group
+	{
+		resp := call("POST", "/v1/group", ReqNewGroupParams{Group: "editors", Roles: []string{"x"}})
+		assert.Equal(http.StatusInternalServerError, resp.Code)
+		var msg RespError
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &msg))
+		assert.Equal(ErrCodeRoleUnknown, msg.Code)
+		resp = call("POST", "/v1/group", ReqNewGroupParams{Group: "bad group"})
+		assert.Equal(http.StatusBadRequest, resp.Code)
+		resp = call("POST", "/v1/group", ReqNewGroupParams{
+			Group: "editors", Roles: []string{"writer"},
+		})
+		assert.Equal(http.StatusOK, resp.Code)
+		resp = call("GET", "/v1/group", nil)
+		assert.Equal(http.StatusOK, resp.Code)
+		var groups RespListAllGroups
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &groups))
+		assert.Equal([]string{"editors"}, groups.Groups)
+	}
+
+	// Case 2: add user to group
+	{
+		resp := call("POST", "/v1/group/editors/users", ReqGroupUsers{Users: []string{}})
+		assert.Equal(http.StatusBadRequest, resp.Code)
+		resp = call("POST", "/v1/group/editors/users", ReqGroupUsers{
+			Users: []string{uuid.New().String()},
+		})
+		assert.Equal(http.StatusInternalServerError, resp.Code)
+		var msg RespError
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &msg))
+		assert.Equal(ErrCodeUserNotFound, msg.Code)
+		resp = call("POST", "/v1/group/editors/users", ReqGroupUsers{Users: []string{userID}})
+		assert.Equal(http.StatusOK, resp.Code)
+		allowed, err := mgmtCore.DoesUserHavePermission(
+			context.Background(), userID, []string{"write"},
+		)
+		assert.Nil(err)
+		assert.True(allowed)
+	}
+
+	// Case 3: change group roles
+	{
+		resp := call("PUT", "/v1/group/editors/roles", ReqNewGroupRoles{Roles: []string{"reader"}})
+		assert.Equal(http.StatusOK, resp.Code)
+		resp = call("GET", "/v1/group/editors", nil)
+		assert.Equal(http.StatusOK, resp.Code)
+		var group RespGroupInfo
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &group))
+		assert.Equal([]string{"reader"}, group.Group.Roles)
+		assert.Equal([]string{userID}, group.Group.Users)
+	}
+
+	// Case 4: remove user from group
+	{
+		resp := call("DELETE", fmt.Sprintf("/v1/group/editors/users/%s", userID), nil)
+		assert.Equal(http.StatusOK, resp.Code)
+		user, err := mgmtCore.GetUser(context.Background(), userID)
+		assert.Nil(err)
+		assert.Empty(user.Groups)
+	}
+
+	// Case 5: delete group
+	{
+		resp := call("DELETE", "/v1/group/editors", nil)
+		assert.Equal(http.StatusOK, resp.Code)
+		resp = call("DELETE", "/v1/group/editors", nil)
+		assert.Equal(http.StatusInternalServerError, resp.Code)
+	}
+}
+
+func TestAPIKeyManagementAPI(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	uut, err := defineUserManagementHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		supportMatch,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/user/{userID}/api-key", uut.CreateAPIKeyHandler()).Methods("POST")
+	router.HandleFunc("/v1/user/{userID}/api-key", uut.ListAPIKeysHandler()).Methods("GET")
+	router.HandleFunc(
+		"/v1/user/{userID}/api-key/{keyID}", uut.RevokeAPIKeyHandler(),
+	).Methods("DELETE")
+	call := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var payload []byte
+		if body != nil {
+			payload, err = json.Marshal(body)
+			assert.Nil(err)
+		}
+		req, err := http.NewRequest(method, path, bytes.NewReader(payload))
+		assert.Nil(err)
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+
+	userID := uuid.New().String()
+	assert.Nil(mgmtCore.DefineUser(context.Background(), models.UserConfig{UserID: userID}, nil))
+
+	// Case 0: API key for unknown user
+	{
+		resp := call("POST", fmt.Sprintf("/v1/user/%s/api-key", uuid.New().String()), ReqNewAPIKey{})
+		assert.Equal(http.StatusInternalServerError, resp.Code)
+		var msg RespError
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &msg))
+		assert.Equal(ErrCodeUserNotFound, msg.Code)
+	}
+
+	// Case 1: API key already expired
+	{
+		expiresAt := time.Now().Add(-time.Minute)
+		resp := call(
+			"POST", fmt.Sprintf("/v1/user/%s/api-key", userID), ReqNewAPIKey{ExpiresAt: &expiresAt},
+		)
+		assert.Equal(http.StatusBadRequest, resp.Code)
+	}
+
+	// Case 2: mint API key
+	var newKey RespNewAPIKey
+	{
+		description := "ci-pipeline"
+		resp := call(
+			"POST", fmt.Sprintf("/v1/user/%s/api-key", userID), ReqNewAPIKey{Description: &description},
+		)
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &newKey))
+		assert.NotEmpty(newKey.Key)
+		assert.Equal(description, *newKey.Info.Description)
+		user, err := mgmtCore.VerifyAPIKey(context.Background(), newKey.Key, time.Now())
+		assert.Nil(err)
+		assert.Equal(userID, user.UserID)
+	}
+
+	// Case 3: list API keys
+	{
+		resp := call("GET", fmt.Sprintf("/v1/user/%s/api-key", userID), nil)
+		assert.Equal(http.StatusOK, resp.Code)
+		var keys RespListAPIKeys
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &keys))
+		assert.Len(keys.Keys, 1)
+		assert.Equal(newKey.Info.KeyID, keys.Keys[0].KeyID)
+		assert.NotContains(resp.Body.String(), newKey.Key)
+	}
+
+	// Case 4: revoke API key
+	{
+		path := fmt.Sprintf("/v1/user/%s/api-key/%s", userID, newKey.Info.KeyID)
+		resp := call("DELETE", fmt.Sprintf("/v1/user/%s/api-key/not-a-key-id", userID), nil)
+		assert.Equal(http.StatusBadRequest, resp.Code)
+		resp = call("DELETE", path, nil)
+		assert.Equal(http.StatusOK, resp.Code)
+		resp = call("DELETE", path, nil)
+		assert.Equal(http.StatusInternalServerError, resp.Code)
+		var msg RespError
+		assert.Nil(json.Unmarshal(resp.Body.Bytes(), &msg))
+		assert.Equal(ErrCodeAPIKeyNotFound, msg.Code)
+	}
+}
+
+func TestFeatureTogglesAPI(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	requestIDHeader := "Padlock-Unit-Tester"
+
+	checkHeader := func(w http.ResponseWriter, reqID string) {
+		assert.Equal(reqID, w.Header().Get(requestIDHeader))
+		assert.Equal("application/json", w.Header().Get("content-type"))
+	}
+
+	// Case 0: toggles not supported
+	{
+		uut, err := defineUserManagementHandler(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+			mgmtCore,
+			supportMatch,
+			nil,
+			nil,
+			nil,
+			nil,
+		)
+		assert.Nil(err)
+
+		rid := uuid.New().String()
+		req, err := http.NewRequest("GET", "/v1/admin/toggles", nil)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.GetFeatureTogglesHandler())
+		handler.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusNotFound, respRecorder.Code)
+		checkHeader(respRecorder, rid)
+	}
+
+	toggles := common.DefineFeatureToggles(
+		common.FeatureToggleState{Introspection: true, AutoAddUser: false, DryRun: false}, nil,
+	)
+	uut, err := defineUserManagementHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+		mgmtCore,
+		supportMatch,
+		nil,
+		toggles,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	// Case 1: read initial state
+	{
+		rid := uuid.New().String()
+		req, err := http.NewRequest("GET", "/v1/admin/toggles", nil)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.GetFeatureTogglesHandler())
+		handler.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusOK, respRecorder.Code)
+		checkHeader(respRecorder, rid)
+		var msg RespFeatureToggles
+		assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &msg))
+		assert.True(msg.Toggles.Introspection)
+		assert.False(msg.Toggles.AutoAddUser)
+		assert.False(msg.Toggles.DryRun)
+	}
+
+	// Case 2: partial update
+	{
+		rid := uuid.New().String()
+		req, err := http.NewRequest(
+			"PUT", "/v1/admin/toggles", bytes.NewBufferString(`{"dry_run": true}`),
+		)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.UpdateFeatureTogglesHandler())
+		handler.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusOK, respRecorder.Code)
+		checkHeader(respRecorder, rid)
+		var msg RespFeatureToggles
+		assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &msg))
+		assert.True(msg.Toggles.Introspection)
+		assert.False(msg.Toggles.AutoAddUser)
+		assert.True(msg.Toggles.DryRun)
+		assert.Equal(msg.Toggles, toggles.CurrentState())
+	}
+
+	// Case 3: bad request body
+	{
+		rid := uuid.New().String()
+		req, err := http.NewRequest(
+			"PUT", "/v1/admin/toggles", bytes.NewBufferString(`{"dry_run": "maybe"}`),
+		)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.UpdateFeatureTogglesHandler())
+		handler.ServeHTTP(respRecorder, req)
+
+		assert.Equal(http.StatusBadRequest, respRecorder.Code)
+		checkHeader(respRecorder, rid)
+		assert.True(toggles.CurrentState().DryRun)
+	}
+}
+
+func TestFlushDecisionCacheAPI(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	requestIDHeader := "Padlock-Unit-Tester"
+
+	flush := func(uut UserManagementHandler, query string) int {
+		rid := uuid.New().String()
+		req, err := http.NewRequest("DELETE", "/v1/admin/decision-cache"+query, nil)
+		assert.Nil(err)
+		req.Header.Add(requestIDHeader, rid)
+
+		respRecorder := httptest.NewRecorder()
+		handler := uut.LoggingMiddleware(uut.FlushDecisionCacheHandler())
+		handler.ServeHTTP(respRecorder, req)
+		assert.Equal(rid, respRecorder.Header().Get(requestIDHeader))
+		return respRecorder.Code
+	}
+
+	// Case 0: decision cache not supported
+	{
+		uut, err := defineUserManagementHandler(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+			mgmtCore,
+			supportMatch,
+			nil,
+			nil,
+			nil,
+			nil,
+		)
+		assert.Nil(err)
+		assert.Equal(http.StatusNotFound, flush(uut, ""))
+	}
+
+	bus := invalidation.DefineLocalBus("unit-test")
+	decisions := DefineDecisionCache(time.Minute, 0)
+	SubscribeDecisionCacheToInvalidation(decisions, bus)
+	uut, err := defineUserManagementHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+		mgmtCore,
+		supportMatch,
+		nil,
+		nil,
+		bus,
+		nil,
+	)
+	assert.Nil(err)
+
+	timestamp := time.Now().UTC()
+	user0 := uuid.New().String()
+	user1 := uuid.New().String()
+	key0 := DecisionKey{UserID: user0, Host: "unit-test.org", Path: "/", Method: "GET"}
+	key1 := DecisionKey{UserID: user1, Host: "unit-test.org", Path: "/", Method: "GET"}
+	decisions.Record(context.Background(), key0, Decision{Allowed: true}, timestamp)
+	decisions.Record(context.Background(), key1, Decision{Allowed: true}, timestamp)
+
+	// Case 1: invalid user ID
+	assert.Equal(http.StatusBadRequest, flush(uut, "?user_id=bad%20user"))
+
+	// Case 2: flush one user
+	assert.Equal(http.StatusOK, flush(uut, "?user_id="+user0))
+	{
+		_, ok := decisions.Lookup(context.Background(), key0, timestamp)
+		assert.False(ok)
+		_, ok = decisions.Lookup(context.Background(), key1, timestamp)
+		assert.True(ok)
+	}
+
+	// Case 3: flush all users
+	assert.Equal(http.StatusOK, flush(uut, ""))
+	{
+		_, ok := decisions.Lookup(context.Background(), key1, timestamp)
+		assert.False(ok)
+	}
+
+	// Case 4: the user ID is normalized as the decisions are recorded
+	{
+		uut, err := defineUserManagementHandler(
+			common.HTTPRequestLogging{DoNotLogHeaders: []string{}, RequestIDHeader: requestIDHeader},
+			users.WithUserIDNormalization(mgmtCore, common.UserIDNormalizationConfig{Lowercase: true}),
+			supportMatch,
+			nil,
+			nil,
+			bus,
+			nil,
+		)
+		assert.Nil(err)
+		key := DecisionKey{UserID: "alice", Host: "unit-test.org", Path: "/", Method: "GET"}
+		decisions.Record(context.Background(), key, Decision{Allowed: true}, timestamp)
+		assert.Equal(http.StatusOK, flush(uut, "?user_id=Alice"))
+		_, ok := decisions.Lookup(context.Background(), key, timestamp)
+		assert.False(ok)
+	}
+}
+
+func TestStreamEventsAPI(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}))
+
+	uut, err := defineUserManagementHandler(
+		common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+		mgmtCore,
+		supportMatch,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	assert.Nil(err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/events", uut.StreamEventsHandler()).Methods("GET")
+	server := httptest.NewUnstartedServer(
+		responseControllerMiddleware(uut.LoggingMiddleware(router.ServeHTTP)),
+	)
+	// The stream must outlive the server write timeout
+	server.Config.WriteTimeout = time.Millisecond * 200
+	server.Start()
+	defer server.Close()
+
+	// Case 0: unknown event type
+	{
+		resp, err := http.Get(server.URL + "/v1/events?events=user_renamed")
+		assert.Nil(err)
+		assert.Equal(http.StatusBadRequest, resp.StatusCode)
+		assert.Nil(resp.Body.Close())
+	}
+
+	// Case 1: stream the user changes
+	{
+		ctxt, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		req, err := http.NewRequestWithContext(
+			ctxt,
+			http.MethodGet,
+			server.URL+"/v1/events?events=user_created,role_assignment_changed,user_deleted",
+			nil,
+		)
+		assert.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(err)
+		defer resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+		assert.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+		// Wait past the server write timeout
+		time.Sleep(time.Millisecond * 500)
+
+		userID := uuid.New().String()
+		assert.Nil(mgmtCore.DefineUser(context.Background(), models.UserConfig{UserID: userID}, nil))
+		// Updates are filtered out
+		assert.Nil(mgmtCore.UpdateUser(
+			context.Background(), userID, models.UserConfig{UserID: userID},
+		))
+		assert.Nil(mgmtCore.SetUserRoles(context.Background(), userID, []string{"reader"}))
+		assert.Nil(mgmtCore.DeleteUser(context.Background(), userID))
+
+		reader := bufio.NewReader(resp.Body)
+		for _, expected := range []string{
+			users.UserEventCreated, users.UserEventRolesChanged, users.UserEventDeleted,
+		} {
+			line, err := reader.ReadString('\n')
+			assert.Nil(err)
+			assert.Equal(fmt.Sprintf("event: %s\n", expected), line)
+			line, err = reader.ReadString('\n')
+			assert.Nil(err)
+			var event users.UserEvent
+			assert.Nil(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+			assert.Equal(expected, event.Event)
+			assert.Equal(userID, event.UserID)
+			line, err = reader.ReadString('\n')
+			assert.Nil(err)
+			assert.Equal("\n", line)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package apis
+
+import (
+	"net/http"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+)
+
+// VersionHandler the build information REST API handler
+type VersionHandler struct {
+	goutils.RestAPIHandler
+}
+
+// defineVersionHandler define a new VersionHandler instance
+func defineVersionHandler(logConfig common.HTTPRequestLogging, instance string) VersionHandler {
+	logTags := log.Fields{
+		"module": "apis", "component": "api-handler", "instance": instance,
+	}
+
+	return VersionHandler{
+		RestAPIHandler: goutils.RestAPIHandler{
+			Component: goutils.Component{
+				LogTags: logTags,
+				LogTagModifiers: []goutils.LogMetadataModifier{
+					goutils.ModifyLogMetadataByRestRequestParam,
+				},
+			},
+			CallRequestIDHeaderField: &logConfig.RequestIDHeader,
+			DoNotLogHeaders: func() map[string]bool {
+				result := map[string]bool{}
+				for _, v := range logConfig.DoNotLogHeaders {
+					result[v] = true
+				}
+				return result
+			}(),
+			LogLevel: logConfig.HealthLogLevel,
+		},
+	}
+}
+
+// RespVersion is the API response giving the build information of the application
+type RespVersion struct {
+	goutils.RestAPIBaseResponse
+	common.BuildInfo
+}
+
+// Version godoc
+// @Summary Application build information
+// @Description Report the version, git commit, and Go version of the running application
+// @tags Version
+// @Produce json
+// @Param Padlock-Request-ID header string false "User provided request ID to match against logs"
+// @Success 200 {object} RespVersion "success"
+// @Failure 400 {object} RespError "error"
+// @Failure 404 {string} string "error"
+// @Failure 500 {object} RespError "error"
+// @Router /version [get]
+func (h VersionHandler) Version(w http.ResponseWriter, r *http.Request) {
+	logTags := h.GetLogTagsForContext(r.Context())
+	if err := h.WriteRESTResponse(
+		w,
+		http.StatusOK,
+		RespVersion{
+			RestAPIBaseResponse: h.GetStdRESTSuccessMsg(r.Context()), BuildInfo: common.GetBuildInfo(),
+		},
+		nil,
+	); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to form response")
+	}
+}
+
+// VersionHandler Wrapper around Version
+func (h VersionHandler) VersionHandler() http.HandlerFunc {
+	return h.LoggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		h.Version(w, r)
+	})
+}
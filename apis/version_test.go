@@ -0,0 +1,41 @@
+package apis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersion(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	requestIDHeader := "Padlock-Unit-Tester"
+	uut := defineVersionHandler(
+		common.HTTPRequestLogging{RequestIDHeader: requestIDHeader}, "unit-test",
+	)
+
+	rid := uuid.NewString()
+	req, err := http.NewRequest("GET", "/version", nil)
+	assert.Nil(err)
+	req.Header.Add(requestIDHeader, rid)
+
+	respRecorder := httptest.NewRecorder()
+	uut.VersionHandler().ServeHTTP(respRecorder, req)
+	assert.Equal(http.StatusOK, respRecorder.Code)
+
+	var msg RespVersion
+	assert.Nil(json.Unmarshal(respRecorder.Body.Bytes(), &msg))
+	assert.True(msg.Success)
+	assert.Equal(rid, msg.RequestID)
+	assert.Equal(common.Version, msg.Version)
+	assert.NotEmpty(msg.GitCommit)
+	assert.Equal(runtime.Version(), msg.GoVersion)
+}
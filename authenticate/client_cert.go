@@ -0,0 +1,336 @@
+package authenticate
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+)
+
+// Certificate fields the caller identity can be read from
+const (
+	// ClientCertIdentityURI the first URI SAN, i.e. a SPIFFE ID
+	ClientCertIdentityURI = "uri"
+	// ClientCertIdentityDNS the first DNS SAN
+	ClientCertIdentityDNS = "dns"
+	// ClientCertIdentityEmail the first email SAN
+	ClientCertIdentityEmail = "email"
+	// ClientCertIdentityCN the subject common name
+	ClientCertIdentityCN = "cn"
+)
+
+// XFCCHeader is the header Envoy forwards the client certificate details in
+const XFCCHeader = "X-Forwarded-Client-Cert"
+
+// PEM certificate encapsulation boundaries
+const (
+	pemCertBegin = "-----BEGIN CERTIFICATE-----"
+	pemCertEnd   = "-----END CERTIFICATE-----"
+)
+
+// ClientCertIdentity the caller identity read from a client certificate
+type ClientCertIdentity struct {
+	// UserID is the user ID, read from the first identity source the certificate has
+	UserID string
+	// Email is the first email SAN, if any
+	Email string
+}
+
+// ClientCertReader reads the caller identity from the client certificate forwarded by the
+// request proxy which terminated mutual TLS with the caller. The certificate of a mutual TLS
+// connection to Padlock is not used, as on the forward auth path, it is the certificate of the
+// request proxy.
+type ClientCertReader interface {
+	/*
+		ReadIdentity read the caller identity from the client certificate of a request. The
+		forwarded certificates are only read on requests from the trusted request proxies.
+
+		 @param ctxt context.Context - the operating context
+		 @param r *http.Request - the request
+		 @return the caller identity, and whether the request carried a client certificate
+	*/
+	ReadIdentity(ctxt context.Context, r *http.Request) (ClientCertIdentity, bool, error)
+}
+
+// clientCertFields the certificate fields of interest
+type clientCertFields struct {
+	uris       []string
+	dnsNames   []string
+	emails     []string
+	commonName string
+}
+
+// clientCertReaderImpl implements ClientCertReader
+type clientCertReaderImpl struct {
+	goutils.Component
+	headers []string
+	sources []string
+	// proxies are the request proxies allowed to forward the certificates
+	proxies common.TrustedNetworks
+	// roots are the CAs the forwarded certificates must chain to
+	roots *x509.CertPool
+}
+
+/*
+DefineClientCertReader define a new client certificate identity reader
+
+	@param cfg common.ClientCertAuthConfig - the client certificate authentication config
+	@return new ClientCertReader instance
+*/
+func DefineClientCertReader(cfg common.ClientCertAuthConfig) (ClientCertReader, error) {
+	logTags := log.Fields{"module": "authenticate", "component": "client-cert-reader"}
+
+	instance := &clientCertReaderImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+			},
+		},
+		headers: cfg.Headers,
+		sources: cfg.IdentitySources,
+	}
+
+	// A certificate is public, so a forwarded one only identifies the caller if it was
+	// forwarded by a proxy which verified the caller holds its key
+	if len(cfg.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("client certificate authentication requires the trusted proxies")
+	}
+	proxies, err := common.ParseTrustedNetworks(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	instance.proxies = proxies
+
+	if cfg.CAFile == "" {
+		return nil, fmt.Errorf("client certificate authentication requires a CA file")
+	}
+	caPEM, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to read %s", cfg.CAFile)
+		return nil, err
+	}
+	instance.roots = x509.NewCertPool()
+	if !instance.roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("client certificate CA %s contains no certificates", cfg.CAFile)
+	}
+
+	return instance, nil
+}
+
+/*
+ReadIdentity read the caller identity from the client certificate of a request. The
+forwarded certificates are only read on requests from the trusted request proxies.
+
+	@param ctxt context.Context - the operating context
+	@param r *http.Request - the request
+	@return the caller identity, and whether the request carried a client certificate
+*/
+func (c *clientCertReaderImpl) ReadIdentity(
+	ctxt context.Context, r *http.Request,
+) (ClientCertIdentity, bool, error) {
+	logTags := c.GetLogTagsForContext(ctxt)
+
+	for _, header := range c.headers {
+		value := r.Header.Get(header)
+		if value == "" {
+			continue
+		}
+		if !c.proxies.Contains(r.RemoteAddr) {
+			log.WithFields(logTags).
+				Warnf("Ignoring client certificate in '%s' from untrusted source %s", header, r.RemoteAddr)
+			return ClientCertIdentity{}, false, nil
+		}
+		var fields clientCertFields
+		var err error
+		if strings.EqualFold(header, XFCCHeader) {
+			fields, err = c.readXFCC(value)
+		} else {
+			fields, err = c.readForwardedPEM(value)
+		}
+		if err != nil {
+			log.WithError(err).
+				WithFields(logTags).
+				Errorf("Client certificate in '%s' not valid", header)
+			return ClientCertIdentity{}, true, err
+		}
+		identity, err := c.identityFrom(fields)
+		return identity, true, err
+	}
+
+	return ClientCertIdentity{}, false, nil
+}
+
+/*
+identityFrom pick the caller identity out of the certificate fields
+
+	@param fields clientCertFields - the certificate fields
+	@return the caller identity
+*/
+func (c *clientCertReaderImpl) identityFrom(fields clientCertFields) (ClientCertIdentity, error) {
+	identity := ClientCertIdentity{}
+	if len(fields.emails) > 0 {
+		identity.Email = fields.emails[0]
+	}
+	for _, source := range c.sources {
+		switch source {
+		case ClientCertIdentityURI:
+			if len(fields.uris) > 0 {
+				identity.UserID = fields.uris[0]
+			}
+		case ClientCertIdentityDNS:
+			if len(fields.dnsNames) > 0 {
+				identity.UserID = fields.dnsNames[0]
+			}
+		case ClientCertIdentityEmail:
+			identity.UserID = identity.Email
+		case ClientCertIdentityCN:
+			identity.UserID = fields.commonName
+		}
+		if identity.UserID != "" {
+			return identity, nil
+		}
+	}
+	return ClientCertIdentity{}, fmt.Errorf(
+		"client certificate has none of the identity fields %s", strings.Join(c.sources, ", "),
+	)
+}
+
+/*
+parseCert parse a forwarded PEM encoded certificate, and verify it against the CAs. The line
+breaks of the PEM encoding are not required, as a proxy may fold the certificate
+onto one line.
+
+	@param encoded string - the PEM encoded certificate
+	@return the certificate fields
+*/
+func (c *clientCertReaderImpl) parseCert(encoded string) (clientCertFields, error) {
+	_, body, ok := strings.Cut(encoded, pemCertBegin)
+	if ok {
+		body, _, ok = strings.Cut(body, pemCertEnd)
+	}
+	if !ok {
+		return clientCertFields{}, fmt.Errorf("no PEM encoded certificate found")
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return clientCertFields{}, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return clientCertFields{}, err
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots: c.roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return clientCertFields{}, err
+	}
+	return fieldsOfCert(cert), nil
+}
+
+/*
+readForwardedPEM read a PEM encoded certificate forwarded in a header, as with the nginx
+"ssl-client-cert" header. The certificate may be URL encoded.
+
+	@param value string - the header value
+	@return the certificate fields
+*/
+func (c *clientCertReaderImpl) readForwardedPEM(value string) (clientCertFields, error) {
+	if strings.Contains(value, "%") {
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			return clientCertFields{}, err
+		}
+		value = unescaped
+	}
+	return c.parseCert(value)
+}
+
+/*
+readXFCC read the client certificate of the Envoy "X-Forwarded-Client-Cert" header. When the
+header was appended to by multiple proxies, the last element describes the caller of the
+nearest proxy. The element must carry the certificate in the "Cert" field, as the other fields
+can not be verified against the CAs.
+
+	@param value string - the header value
+	@return the certificate fields
+*/
+func (c *clientCertReaderImpl) readXFCC(value string) (clientCertFields, error) {
+	elements := splitQuoted(value, ',')
+	pairs := splitQuoted(elements[len(elements)-1], ';')
+	for _, pair := range pairs {
+		key, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return clientCertFields{}, fmt.Errorf("malformed %s element '%s'", XFCCHeader, pair)
+		}
+		if !strings.EqualFold(strings.TrimSpace(key), "cert") {
+			continue
+		}
+		raw = strings.TrimSpace(raw)
+		if len(raw) >= 2 && strings.HasPrefix(raw, `"`) && strings.HasSuffix(raw, `"`) {
+			raw = strings.ReplaceAll(raw[1:len(raw)-1], `\"`, `"`)
+		}
+		encoded, err := url.PathUnescape(raw)
+		if err != nil {
+			return clientCertFields{}, err
+		}
+		return c.parseCert(encoded)
+	}
+	return clientCertFields{}, fmt.Errorf(
+		"%s lacks the certificate to verify against the CAs", XFCCHeader,
+	)
+}
+
+/*
+fieldsOfCert read the fields of interest of a certificate
+
+	@param cert *x509.Certificate - the certificate
+	@return the certificate fields
+*/
+func fieldsOfCert(cert *x509.Certificate) clientCertFields {
+	fields := clientCertFields{
+		dnsNames:   cert.DNSNames,
+		emails:     cert.EmailAddresses,
+		commonName: cert.Subject.CommonName,
+	}
+	for _, uri := range cert.URIs {
+		fields.uris = append(fields.uris, uri.String())
+	}
+	return fields
+}
+
+/*
+splitQuoted split a string on a separator, ignoring the separators within double quotes
+
+	@param value string - the string
+	@param sep rune - the separator
+	@return the parts
+*/
+func splitQuoted(value string, sep rune) []string {
+	parts := []string{}
+	quoted := false
+	escaped := false
+	start := 0
+	for idx, char := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case char == '\\':
+			escaped = true
+		case char == '"':
+			quoted = !quoted
+		case char == sep && !quoted:
+			parts = append(parts, value[start:idx])
+			start = idx + 1
+		}
+	}
+	return append(parts, value[start:])
+}
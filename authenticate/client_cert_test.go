@@ -0,0 +1,193 @@
+package authenticate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCertReader(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Generate a CA, and a client certificate signed by it
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "unit-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, &caTemplate, &caTemplate, &caKey.PublicKey, caKey,
+	)
+	assert.Nil(err)
+	caCert, err := x509.ParseCertificate(caDER)
+	assert.Nil(err)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	assert.Nil(os.WriteFile(
+		caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600,
+	))
+
+	spiffeID, err := url.Parse("spiffe://unit-test.org/ns/default/sa/reporter")
+	assert.Nil(err)
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "reporter"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:           []*url.URL{spiffeID},
+		DNSNames:       []string{"reporter.unit-test.org"},
+		EmailAddresses: []string{"reporter@unit-test.org"},
+	}, caCert, &clientKey.PublicKey, caKey)
+	assert.Nil(err)
+	clientCert, err := x509.ParseCertificate(clientDER)
+	assert.Nil(err)
+	clientPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}))
+
+	// A self-signed certificate not chaining to the CA
+	rogueTemplate := caTemplate
+	rogueTemplate.Subject = pkix.Name{CommonName: "rogue"}
+	rogueDER, err := x509.CreateCertificate(
+		rand.Reader, &rogueTemplate, &rogueTemplate, &caKey.PublicKey, caKey,
+	)
+	assert.Nil(err)
+	roguePEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rogueDER}))
+
+	utCtxt := context.Background()
+	readIdentity := func(
+		uut ClientCertReader, headers map[string]string, remoteAddr string,
+	) (ClientCertIdentity, bool, error) {
+		req, err := http.NewRequest("GET", "/v1/authenticate", nil)
+		assert.Nil(err)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		// The certificate of the connection is the request proxy's
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}}
+		return uut.ReadIdentity(utCtxt, req)
+	}
+	proxy := "10.0.0.1:43210"
+
+	config := common.ClientCertAuthConfig{
+		Enabled:         true,
+		Headers:         []string{XFCCHeader, "ssl-client-cert"},
+		TrustedProxies:  []string{"10.0.0.0/8"},
+		IdentitySources: []string{"uri", "dns", "cn"},
+		CAFile:          caFile,
+	}
+	uut, err := DefineClientCertReader(config)
+	assert.Nil(err)
+
+	// Case 0: no forwarded client certificate, the mutual TLS peer certificate is not used
+	{
+		_, presented, err := readIdentity(uut, nil, proxy)
+		assert.Nil(err)
+		assert.False(presented)
+	}
+
+	// Case 1: Envoy forwarded certificate, with multiple proxy hops
+	{
+		xfcc := fmt.Sprintf(
+			`By=spiffe://unit-test.org/edge;URI=spiffe://unit-test.org/other,`+
+				`By=spiffe://unit-test.org/padlock;Hash=abc;Cert="%s";Subject="CN=reporter"`,
+			url.PathEscape(clientPEM),
+		)
+		identity, presented, err := readIdentity(uut, map[string]string{XFCCHeader: xfcc}, proxy)
+		assert.Nil(err)
+		assert.True(presented)
+		assert.Equal(spiffeID.String(), identity.UserID)
+		assert.Equal("reporter@unit-test.org", identity.Email)
+	}
+
+	// Case 2: Envoy forwarded certificate details without the certificate can't be verified
+	{
+		xfcc := `By=spiffe://unit-test.org/padlock;Subject="CN=reporter,O=unit-test";` +
+			`URI=spiffe://unit-test.org/admin`
+		_, presented, err := readIdentity(uut, map[string]string{XFCCHeader: xfcc}, proxy)
+		assert.NotNil(err)
+		assert.True(presented)
+	}
+
+	// Case 3: nginx forwarded certificate, URL encoded or folded onto one line
+	{
+		identity, _, err := readIdentity(
+			uut, map[string]string{"ssl-client-cert": url.PathEscape(clientPEM)}, proxy,
+		)
+		assert.Nil(err)
+		assert.Equal(spiffeID.String(), identity.UserID)
+		folded := strings.ReplaceAll(strings.TrimSpace(clientPEM), "\n", " ")
+		identity, _, err = readIdentity(uut, map[string]string{"ssl-client-cert": folded}, proxy)
+		assert.Nil(err)
+		assert.Equal(spiffeID.String(), identity.UserID)
+	}
+
+	// Case 4: malformed certificate, or not chaining to the CA
+	{
+		_, presented, err := readIdentity(
+			uut, map[string]string{"ssl-client-cert": "garbage"}, proxy,
+		)
+		assert.NotNil(err)
+		assert.True(presented)
+		_, _, err = readIdentity(uut, map[string]string{"ssl-client-cert": roguePEM}, proxy)
+		assert.NotNil(err)
+	}
+
+	// Case 5: forwarded certificates from a source other than the trusted proxies are ignored
+	{
+		_, presented, err := readIdentity(
+			uut, map[string]string{"ssl-client-cert": clientPEM}, "192.168.1.1:43210",
+		)
+		assert.Nil(err)
+		assert.False(presented)
+	}
+
+	// Case 6: identity read from the preferred certificate field
+	{
+		cfg := config
+		cfg.IdentitySources = []string{"email"}
+		uut, err := DefineClientCertReader(cfg)
+		assert.Nil(err)
+		identity, _, err := readIdentity(uut, map[string]string{"ssl-client-cert": clientPEM}, proxy)
+		assert.Nil(err)
+		assert.Equal("reporter@unit-test.org", identity.UserID)
+	}
+
+	// Case 7: the CA and the trusted proxies are required
+	{
+		cfg := config
+		cfg.CAFile = ""
+		_, err := DefineClientCertReader(cfg)
+		assert.NotNil(err)
+		cfg = config
+		cfg.TrustedProxies = nil
+		_, err = DefineClientCertReader(cfg)
+		assert.NotNil(err)
+	}
+}
@@ -0,0 +1,161 @@
+package authenticate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// CloudflareAccessValidator validates the JWT assertions Cloudflare Access attaches to the
+// requests it forwards
+type CloudflareAccessValidator interface {
+	/*
+		ValidateAssertion validate a Cloudflare Access JWT assertion. The assertion must be signed
+		by one of the team domain's signing keys, be issued by the team domain, be intended for
+		the application's audience, and not have expired.
+
+		 @param ctxt context.Context - the operating context
+		 @param raw string - the JWT assertion
+		 @return the assertion claims
+	*/
+	ValidateAssertion(ctxt context.Context, raw string) (jwt.MapClaims, error)
+}
+
+// cloudflareAccessValidatorImpl implements CloudflareAccessValidator
+type cloudflareAccessValidatorImpl struct {
+	goutils.Component
+	issuer     string
+	audience   string
+	certsURI   string
+	httpClient *http.Client
+	// publicKey the team domain's signing public keys by "kid", guarded by publicKeyLock
+	publicKey     map[string]interface{}
+	publicKeyLock sync.RWMutex
+	// publicKeyFetched when the signing keys were last fetched
+	publicKeyFetched time.Time
+}
+
+/*
+DefineCloudflareAccessValidator define a new Cloudflare Access JWT assertion validator
+
+	@param cfg common.CloudflareAccessConfig - the Cloudflare Access validation config
+	@param httpClient *http.Client - the HTTP client to fetch the team domain's signing keys with
+	@return new CloudflareAccessValidator instance
+*/
+func DefineCloudflareAccessValidator(
+	cfg common.CloudflareAccessConfig, httpClient *http.Client,
+) (CloudflareAccessValidator, error) {
+	issuer := strings.TrimSuffix(cfg.TeamDomain, "/")
+	logTags := log.Fields{
+		"module": "authenticate", "component": "cloudflare-access-validator", "issuer": issuer,
+	}
+
+	certsURI := fmt.Sprintf("%s/cdn-cgi/access/certs", issuer)
+	keyMaterial, err := fetchSigningKeys(httpClient, certsURI, logTags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudflareAccessValidatorImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+			},
+		},
+		issuer:           issuer,
+		audience:         cfg.Audience,
+		certsURI:         certsURI,
+		httpClient:       httpClient,
+		publicKey:        keyMaterial,
+		publicKeyLock:    sync.RWMutex{},
+		publicKeyFetched: time.Now(),
+	}, nil
+}
+
+/*
+associatedPublicKey fetches the signing public key based on "kid" value of the assertion. If
+the "kid" is unknown, the signing keys are refetched, at most once per minimum refresh
+interval, as Cloudflare periodically rotates them.
+
+	@param token *jwt.Token - the JWT assertion to find the public key for
+	@return public key material
+*/
+func (v *cloudflareAccessValidatorImpl) associatedPublicKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("jwt missing 'kid' field")
+	}
+	v.publicKeyLock.RLock()
+	pubKey, ok := v.publicKey[kid]
+	v.publicKeyLock.RUnlock()
+	if ok {
+		return pubKey, nil
+	}
+
+	v.publicKeyLock.Lock()
+	defer v.publicKeyLock.Unlock()
+	// Another request may have refetched the signing keys in the meantime
+	if pubKey, ok := v.publicKey[kid]; ok {
+		return pubKey, nil
+	}
+	if time.Since(v.publicKeyFetched) >= defaultJWKSRefreshMinInterval {
+		log.WithFields(v.LogTags).Infof("Refetching signing keys to find unknown public key %s", kid)
+		v.publicKeyFetched = time.Now()
+		if keyMaterial, err := fetchSigningKeys(v.httpClient, v.certsURI, v.LogTags); err == nil {
+			v.publicKey = keyMaterial
+			if pubKey, ok := v.publicKey[kid]; ok {
+				return pubKey, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("assertion refers to public key %s which is unknown", kid)
+}
+
+/*
+ValidateAssertion validate a Cloudflare Access JWT assertion. The assertion must be signed
+by one of the team domain's signing keys, be issued by the team domain, be intended for
+the application's audience, and not have expired.
+
+	@param ctxt context.Context - the operating context
+	@param raw string - the JWT assertion
+	@return the assertion claims
+*/
+func (v *cloudflareAccessValidatorImpl) ValidateAssertion(
+	ctxt context.Context, raw string,
+) (jwt.MapClaims, error) {
+	logTags := v.GetLogTagsForContext(ctxt)
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, v.associatedPublicKey); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Cloudflare Access assertion failed validation")
+		return nil, err
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		err := fmt.Errorf("assertion has no expiration")
+		log.WithError(err).WithFields(logTags).Error("Cloudflare Access assertion failed validation")
+		return nil, err
+	}
+	if !claims.VerifyIssuer(v.issuer, true) {
+		err := fmt.Errorf("assertion not issued by %s", v.issuer)
+		log.WithError(err).WithFields(logTags).Error("Cloudflare Access assertion failed validation")
+		return nil, err
+	}
+	if !claims.VerifyAudience(v.audience, true) {
+		err := fmt.Errorf("assertion not intended for audience %s", v.audience)
+		log.WithError(err).WithFields(logTags).Error("Cloudflare Access assertion failed validation")
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,119 @@
+package authenticate
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudflareAccessValidator(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	utCtxt := context.Background()
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cdn-cgi/access/certs" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []OIDSigningJWK{
+				{
+					Algorithm: "RS256",
+					Exponent: base64.RawURLEncoding.EncodeToString(
+						big.NewInt(int64(signingKey.E)).Bytes(),
+					),
+					Modulus: base64.RawURLEncoding.EncodeToString(signingKey.N.Bytes()),
+					ID:      "cf-key",
+					Type:    "RSA",
+					Use:     "sig",
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	audience := "cf-app-aud-tag"
+	uut, err := DefineCloudflareAccessValidator(
+		common.CloudflareAccessConfig{Enabled: true, TeamDomain: server.URL + "/", Audience: audience},
+		server.Client(),
+	)
+	assert.Nil(err)
+
+	sign := func(key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		assert.Nil(err)
+		return signed
+	}
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   server.URL,
+			"aud":   []string{audience},
+			"sub":   "9d2f0a3e-user",
+			"email": "alice@unit-test.org",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+		}
+	}
+
+	// Case 0: valid assertion
+	{
+		claims, err := uut.ValidateAssertion(utCtxt, sign(signingKey, "cf-key", validClaims()))
+		assert.Nil(err)
+		assert.Equal("alice@unit-test.org", claims["email"])
+	}
+
+	// Case 1: signed by another key
+	{
+		_, err := uut.ValidateAssertion(utCtxt, sign(otherKey, "cf-key", validClaims()))
+		assert.NotNil(err)
+		_, err = uut.ValidateAssertion(utCtxt, sign(otherKey, "other-key", validClaims()))
+		assert.NotNil(err)
+	}
+
+	// Case 2: wrong audience
+	{
+		claims := validClaims()
+		claims["aud"] = []string{"other-app"}
+		_, err := uut.ValidateAssertion(utCtxt, sign(signingKey, "cf-key", claims))
+		assert.NotNil(err)
+	}
+
+	// Case 3: wrong issuer
+	{
+		claims := validClaims()
+		claims["iss"] = "https://other.cloudflareaccess.com"
+		_, err := uut.ValidateAssertion(utCtxt, sign(signingKey, "cf-key", claims))
+		assert.NotNil(err)
+	}
+
+	// Case 4: expired, or no expiration
+	{
+		claims := validClaims()
+		claims["exp"] = time.Now().Add(-time.Minute).Unix()
+		_, err := uut.ValidateAssertion(utCtxt, sign(signingKey, "cf-key", claims))
+		assert.ErrorIs(err, jwt.ErrTokenExpired)
+		delete(claims, "exp")
+		_, err = uut.ValidateAssertion(utCtxt, sign(signingKey, "cf-key", claims))
+		assert.NotNil(err)
+	}
+}
@@ -2,10 +2,13 @@ package authenticate
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
 	"github.com/apex/log"
 )
 
@@ -24,6 +27,19 @@ type Introspector interface {
 		@return whether token is valid
 	*/
 	VerifyToken(ctxt context.Context, token string, expire int64, timestamp time.Time) (bool, error)
+
+	/*
+		RevalidateCachedTokens re-introspect the cached tokens due for re-validation within the
+		given duration, so requests bearing them need not wait for introspection. Tokens which
+		are no longer active are evicted from cache. Nothing is done while the OpenID issuer is
+		not healthy.
+
+		@param ctxt context.Context - the operating context
+		@param within time.Duration - the duration
+		@param timestamp time.Time - the current timestamp
+		@return the number of tokens re-introspected
+	*/
+	RevalidateCachedTokens(ctxt context.Context, within time.Duration, timestamp time.Time) int
 }
 
 // introspectorImpl implements Introspector
@@ -31,6 +47,15 @@ type introspectorImpl struct {
 	goutils.Component
 	cache      TokenCache
 	introspect IntrospectFunc
+	invalidate invalidation.Bus
+	// issuerHealth if provided, only accept cached tokens when the issuer is not healthy
+	issuerHealth IssuerHealthStatus
+	// staleWhileRevalidate whether to accept a cached token due for re-validation, while it is
+	// re-introspected in the background
+	staleWhileRevalidate bool
+	// revalidating the hashes of the tokens being re-introspected in the background
+	revalidating     map[string]bool
+	revalidatingLock sync.Mutex
 }
 
 /*
@@ -38,9 +63,23 @@ DefineIntrospector defines a new introspector object
 
 	@param cache TokenCache - token cache
 	@param introspectCB IntrospectFunc - callback function to use to perform introspection
+	@param invalidate invalidation.Bus - if provided, notify other replicas when a token fails
+	introspection through this bus
+	@param issuerHealth IssuerHealthStatus - if provided, operate in degraded mode while the
+	OpenID issuer is not healthy: previously cached tokens which have not expired are accepted
+	without introspection, and all other tokens are rejected.
+	@param staleWhileRevalidate bool - whether to accept a cached token due for re-validation,
+	while it is re-introspected in the background. Otherwise, the token is re-introspected before
+	it is accepted.
 	@return new introspector
 */
-func DefineIntrospector(cache TokenCache, introspectCB IntrospectFunc) Introspector {
+func DefineIntrospector(
+	cache TokenCache,
+	introspectCB IntrospectFunc,
+	invalidate invalidation.Bus,
+	issuerHealth IssuerHealthStatus,
+	staleWhileRevalidate bool,
+) Introspector {
 	logTags := log.Fields{"module": "authenticate", "component": "introspector"}
 	return &introspectorImpl{
 		Component: goutils.Component{
@@ -50,8 +89,13 @@ func DefineIntrospector(cache TokenCache, introspectCB IntrospectFunc) Introspec
 				common.ModifyLogMetadataByAccessAuthorizeParam,
 			},
 		},
-		cache:      cache,
-		introspect: introspectCB,
+		cache:                cache,
+		introspect:           introspectCB,
+		invalidate:           invalidate,
+		issuerHealth:         issuerHealth,
+		staleWhileRevalidate: staleWhileRevalidate,
+		revalidating:         map[string]bool{},
+		revalidatingLock:     sync.Mutex{},
 	}
 }
 
@@ -69,6 +113,53 @@ func (i *introspectorImpl) VerifyToken(
 ) (bool, error) {
 	logtags := i.GetLogTagsForContext(ctxt)
 
+	// Degraded mode: the issuer is not available for introspection
+	if i.issuerHealth != nil && !i.issuerHealth.IssuerHealthy() {
+		isCached, err := i.cache.UnexpiredTokenInCache(ctxt, token, timestamp)
+		if err != nil {
+			log.WithError(err).WithFields(logtags).Error("Unable to check token cache")
+			return false, err
+		}
+		if isCached {
+			log.WithFields(logtags).Warn("OpenID issuer unhealthy, accepting previously cached token")
+			return true, nil
+		}
+		err = fmt.Errorf("OpenID issuer unhealthy, only previously cached tokens are accepted")
+		log.WithError(err).WithFields(logtags).Error("Unable to introspect token")
+		return false, err
+	}
+
+	// The request skips the token cache, but the introspection result still updates it
+	if common.CacheBypassRequested(ctxt) {
+		log.WithFields(logtags).Infof("Introspecting token without checking the token cache")
+		return i.introspectAndRecord(ctxt, token, expire, timestamp)
+	}
+
+	// Check whether this token recently failed introspection
+	isInactive, err := i.cache.InactiveTokenInCache(ctxt, token, timestamp)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Unable to check token cache")
+		return false, err
+	}
+	if isInactive {
+		log.WithFields(logtags).Debugf("Skipping introspection of recently inactive token")
+		return false, nil
+	}
+
+	// Accept a token due for re-validation, and re-introspect it in the background
+	if i.staleWhileRevalidate {
+		isStale, err := i.cache.StaleTokenInCache(ctxt, token, timestamp)
+		if err != nil {
+			log.WithError(err).WithFields(logtags).Error("Unable to check token cache")
+			return false, err
+		}
+		if isStale {
+			log.WithFields(logtags).Debugf("Accepting cached token while it is re-validated")
+			i.revalidateToken(ctxt, token, expire, timestamp)
+			return true, nil
+		}
+	}
+
 	// Check whether this token was seen before
 	isValid, err := i.cache.ValidTokenInCache(ctxt, token, timestamp)
 	if err != nil {
@@ -81,8 +172,25 @@ func (i *introspectorImpl) VerifyToken(
 		return true, nil
 	}
 
+	return i.introspectAndRecord(ctxt, token, expire, timestamp)
+}
+
+/*
+introspectAndRecord introspect a token, and record the result in the token cache
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param expire int64 - when the token expires
+	@param timestamp time.Time - the current timestamp
+	@return whether token is valid
+*/
+func (i *introspectorImpl) introspectAndRecord(
+	ctxt context.Context, token string, expire int64, timestamp time.Time,
+) (bool, error) {
+	logtags := i.GetLogTagsForContext(ctxt)
+
 	// Perform introspection
-	isValid, err = i.introspect(ctxt, token)
+	isValid, err := i.introspect(ctxt, token)
 	if err != nil {
 		log.WithError(err).WithFields(logtags).Error("Introspection process failed")
 		return false, err
@@ -90,6 +198,11 @@ func (i *introspectorImpl) VerifyToken(
 
 	// Token failed introspection
 	if !isValid {
+		// Evict the token, which may be cached when the cache was skipped
+		if err := i.cache.RemoveToken(ctxt, token); err != nil {
+			log.WithError(err).WithFields(logtags).Error("Unable to remove from token cache")
+		}
+		i.recordInactiveToken(ctxt, token, timestamp)
 		return false, nil
 	}
 
@@ -100,3 +213,160 @@ func (i *introspectorImpl) VerifyToken(
 	}
 	return true, nil
 }
+
+/*
+recordInactiveToken record a token which failed introspection, and notify the other replicas
+which may still have the token cached
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+*/
+func (i *introspectorImpl) recordInactiveToken(
+	ctxt context.Context, token string, timestamp time.Time,
+) {
+	logtags := i.GetLogTagsForContext(ctxt)
+	if err := i.cache.RecordInactiveToken(ctxt, token, timestamp); err != nil {
+		log.WithError(err).WithFields(logtags).Error("Unable to write to token cache")
+	}
+	if i.invalidate == nil {
+		return
+	}
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash")
+		return
+	}
+	if err := i.invalidate.Publish(ctxt, invalidation.TargetToken, tokenHash); err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to publish token invalidation")
+	}
+}
+
+/*
+RevalidateCachedTokens re-introspect the cached tokens due for re-validation within the
+given duration, so requests bearing them need not wait for introspection. Tokens which
+are no longer active are evicted from cache. Nothing is done while the OpenID issuer is
+not healthy.
+
+	@param ctxt context.Context - the operating context
+	@param within time.Duration - the duration
+	@param timestamp time.Time - the current timestamp
+	@return the number of tokens re-introspected
+*/
+func (i *introspectorImpl) RevalidateCachedTokens(
+	ctxt context.Context, within time.Duration, timestamp time.Time,
+) int {
+	logtags := i.GetLogTagsForContext(ctxt)
+	if i.issuerHealth != nil && !i.issuerHealth.IssuerHealthy() {
+		log.WithFields(logtags).Warn("OpenID issuer unhealthy, skipping token re-introspection")
+		return 0
+	}
+	revalidated := 0
+	// Re-introspect one token at a time, to not flood the OpenID issuer
+	for _, cached := range i.cache.TokensDueForRevalidation(ctxt, within, timestamp) {
+		tokenHash, err := getTokenHash(cached.Token)
+		if err != nil {
+			log.WithError(err).WithFields(logtags).Error("Failed to compute token hash")
+			continue
+		}
+		if !i.startRevalidating(tokenHash) {
+			continue
+		}
+		i.reintrospectToken(ctxt, cached.Token, cached.Expire, timestamp)
+		i.stopRevalidating(tokenHash)
+		revalidated++
+	}
+	log.WithFields(logtags).Debugf("Re-introspected %d cached tokens", revalidated)
+	return revalidated
+}
+
+/*
+startRevalidating mark a token as being re-introspected
+
+	@param tokenHash string - the token hash
+	@return whether the token was not already being re-introspected
+*/
+func (i *introspectorImpl) startRevalidating(tokenHash string) bool {
+	i.revalidatingLock.Lock()
+	defer i.revalidatingLock.Unlock()
+	if i.revalidating[tokenHash] {
+		return false
+	}
+	i.revalidating[tokenHash] = true
+	return true
+}
+
+/*
+stopRevalidating mark a token as no longer being re-introspected
+
+	@param tokenHash string - the token hash
+*/
+func (i *introspectorImpl) stopRevalidating(tokenHash string) {
+	i.revalidatingLock.Lock()
+	defer i.revalidatingLock.Unlock()
+	delete(i.revalidating, tokenHash)
+}
+
+/*
+revalidateToken re-introspect a cached token in the background, unless that is already in
+progress
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param expire int64 - when the token expires
+	@param timestamp time.Time - the current timestamp
+*/
+func (i *introspectorImpl) revalidateToken(
+	ctxt context.Context, token string, expire int64, timestamp time.Time,
+) {
+	logtags := i.GetLogTagsForContext(ctxt)
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash")
+		return
+	}
+	if !i.startRevalidating(tokenHash) {
+		return
+	}
+
+	// The request context ends with the request
+	go func() {
+		defer i.stopRevalidating(tokenHash)
+		i.reintrospectToken(context.Background(), token, expire, timestamp)
+	}()
+}
+
+/*
+reintrospectToken re-introspect a cached token, and update the token cache with the result. If
+the re-introspection fails to complete, the token is removed from cache, so the next request
+bearing it is introspected before it is accepted.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param expire int64 - when the token expires
+	@param timestamp time.Time - the current timestamp
+*/
+func (i *introspectorImpl) reintrospectToken(
+	ctxt context.Context, token string, expire int64, timestamp time.Time,
+) {
+	logtags := i.GetLogTagsForContext(ctxt)
+	isValid, err := i.introspect(ctxt, token)
+	switch {
+	case err != nil:
+		log.WithError(err).WithFields(logtags).Error("Re-introspection failed")
+		if err := i.cache.RemoveToken(ctxt, token); err != nil {
+			log.WithError(err).WithFields(logtags).Error("Unable to remove from token cache")
+		}
+	case !isValid:
+		log.WithFields(logtags).Debugf("Cached token no longer active")
+		// Evict the token even when inactive tokens are not cached
+		if err := i.cache.RemoveToken(ctxt, token); err != nil {
+			log.WithError(err).WithFields(logtags).Error("Unable to remove from token cache")
+		}
+		i.recordInactiveToken(ctxt, token, timestamp)
+	default:
+		if err := i.cache.RecordToken(ctxt, token, expire, timestamp); err != nil {
+			log.WithError(err).WithFields(logtags).Error("Unable to write to token cache")
+		}
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alwitt/padlock/common"
 	"github.com/apex/log"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -41,7 +43,7 @@ func TestIntrospector(t *testing.T) {
 	assert := assert.New(t)
 	log.SetLevel(log.DebugLevel)
 
-	cache := DefineTokenCache(time.Minute * 5)
+	cache := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
 
 	var tokenIsValid bool
 	tokenIsValid = false
@@ -52,7 +54,7 @@ func TestIntrospector(t *testing.T) {
 
 	ctxt := context.Background()
 
-	uut := DefineIntrospector(cache, dummyIntrospect)
+	uut := DefineIntrospector(cache, dummyIntrospect, nil, nil, false)
 
 	currentTime := time.Now().UTC()
 
@@ -110,3 +112,389 @@ func TestIntrospector(t *testing.T) {
 		assert.False(valid)
 	}
 }
+
+func TestIntrospectorDegradedMode(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	cache := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+
+	issuerUp := true
+	watchdog := DefineIssuerWatchdog("unit-test", func(ctxt context.Context) error {
+		if issuerUp {
+			return nil
+		}
+		return fmt.Errorf("dummy error")
+	}, nil)
+
+	introspectCalls := 0
+	dummyIntrospect := func(context.Context, string) (bool, error) {
+		introspectCalls++
+		return true, nil
+	}
+
+	ctxt := context.Background()
+
+	uut := DefineIntrospector(cache, dummyIntrospect, nil, watchdog, false)
+
+	currentTime := time.Now().UTC()
+
+	// Case 0: issuer healthy, token is introspected and cached
+	token1 := uuid.New().String()
+	tokenExpire1 := currentTime.Add(time.Minute * 60)
+	{
+		assert.Nil(watchdog.CheckHealth(ctxt))
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		assert.Equal(1, introspectCalls)
+	}
+
+	// Case 1: issuer down, cached token accepted even though re-introspection is due
+	issuerUp = false
+	currentTime = currentTime.Add(time.Minute * 6)
+	{
+		assert.NotNil(watchdog.CheckHealth(ctxt))
+		assert.False(watchdog.IssuerHealthy())
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		assert.Equal(1, introspectCalls)
+	}
+
+	// Case 2: issuer down, unknown token rejected
+	{
+		token2 := uuid.New().String()
+		valid, err := uut.VerifyToken(ctxt, token2, tokenExpire1.Unix(), currentTime)
+		assert.NotNil(err)
+		assert.False(valid)
+		assert.Equal(1, introspectCalls)
+	}
+
+	// Case 3: issuer recovers, introspection resumes
+	issuerUp = true
+	{
+		assert.Nil(watchdog.CheckHealth(ctxt))
+		assert.True(watchdog.IssuerHealthy())
+		token3 := uuid.New().String()
+		valid, err := uut.VerifyToken(ctxt, token3, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		assert.Equal(2, introspectCalls)
+	}
+}
+
+func TestIntrospectorNegativeCache(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	cache := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+
+	introspectCalls := 0
+	tokenIsValid := false
+	dummyIntrospect := func(context.Context, string) (bool, error) {
+		introspectCalls++
+		return tokenIsValid, nil
+	}
+
+	ctxt := context.Background()
+
+	uut := DefineIntrospector(cache, dummyIntrospect, nil, nil, false)
+
+	currentTime := time.Now().UTC()
+	token1 := uuid.New().String()
+	tokenExpire1 := currentTime.Add(time.Minute * 60)
+
+	// Case 0: inactive token is introspected once
+	{
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+		assert.Equal(1, introspectCalls)
+	}
+
+	// Case 1: repeated requests with the inactive token skip introspection
+	currentTime = currentTime.Add(time.Second * 5)
+	for itr := 0; itr < 3; itr++ {
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+	}
+	assert.Equal(1, introspectCalls)
+
+	// Case 2: once the negative entry expires, the token is introspected again
+	currentTime = currentTime.Add(time.Second * 10)
+	tokenIsValid = true
+	{
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		assert.Equal(2, introspectCalls)
+	}
+
+	// Case 3: an introspection which errored is not cached, so it is retried
+	introspectErr := fmt.Errorf("dummy introspection failure")
+	erroring := DefineIntrospector(
+		cache,
+		func(ctxt context.Context, token string) (bool, error) {
+			introspectCalls++
+			return false, introspectErr
+		},
+		nil, nil, false,
+	)
+	token3 := uuid.New().String()
+	for itr := 0; itr < 2; itr++ {
+		valid, err := erroring.VerifyToken(ctxt, token3, tokenExpire1.Unix(), currentTime)
+		assert.Equal(introspectErr, err)
+		assert.False(valid)
+	}
+	assert.Equal(4, introspectCalls)
+	{
+		inactive, err := cache.InactiveTokenInCache(ctxt, token3, currentTime)
+		assert.Nil(err)
+		assert.False(inactive)
+	}
+
+	// Case 4: negative caching disabled
+	uut = DefineIntrospector(
+		DefineTokenCache(time.Minute*5, 0, 0, nil), dummyIntrospect, nil, nil, false,
+	)
+	tokenIsValid = false
+	token2 := uuid.New().String()
+	for itr := 0; itr < 3; itr++ {
+		valid, err := uut.VerifyToken(ctxt, token2, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+	}
+	assert.Equal(7, introspectCalls)
+}
+
+func TestIntrospectorStaleWhileRevalidate(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	cache := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+
+	introspectLock := sync.Mutex{}
+	introspectCalls := 0
+	tokenIsValid := true
+	var introspectErr error
+	// Background re-introspections block until released
+	release := make(chan bool)
+	blocking := false
+	dummyIntrospect := func(context.Context, string) (bool, error) {
+		introspectLock.Lock()
+		introspectCalls++
+		wait := blocking
+		isValid := tokenIsValid
+		err := introspectErr
+		introspectLock.Unlock()
+		if wait {
+			<-release
+		}
+		return isValid, err
+	}
+	calls := func() int {
+		introspectLock.Lock()
+		defer introspectLock.Unlock()
+		return introspectCalls
+	}
+
+	ctxt := context.Background()
+
+	uut := DefineIntrospector(cache, dummyIntrospect, nil, nil, true)
+
+	currentTime := time.Now().UTC()
+	token1 := uuid.New().String()
+	tokenExpire1 := currentTime.Add(time.Minute * 60)
+
+	// Case 0: new token is introspected before it is accepted
+	{
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		assert.Equal(1, calls())
+	}
+
+	// Case 1: token due for re-validation is accepted, and re-introspected once in the background
+	currentTime = currentTime.Add(time.Minute * 6)
+	introspectLock.Lock()
+	blocking = true
+	introspectLock.Unlock()
+	for itr := 0; itr < 3; itr++ {
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+	}
+	assert.Eventually(func() bool { return calls() == 2 }, time.Second, time.Millisecond*10)
+	release <- true
+	assert.Eventually(func() bool {
+		stale, err := cache.StaleTokenInCache(ctxt, token1, currentTime)
+		return err == nil && !stale
+	}, time.Second, time.Millisecond*10)
+	introspectLock.Lock()
+	blocking = false
+	introspectLock.Unlock()
+	{
+		valid, err := cache.ValidTokenInCache(ctxt, token1, currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		assert.Equal(2, calls())
+	}
+
+	// Case 2: token no longer active is rejected after the background re-introspection
+	currentTime = currentTime.Add(time.Minute * 6)
+	introspectLock.Lock()
+	tokenIsValid = false
+	introspectLock.Unlock()
+	{
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+	}
+	assert.Eventually(func() bool {
+		inactive, err := cache.InactiveTokenInCache(ctxt, token1, currentTime)
+		return err == nil && inactive
+	}, time.Second, time.Millisecond*10)
+	{
+		valid, err := uut.VerifyToken(ctxt, token1, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+		assert.Equal(3, calls())
+	}
+
+	// Case 3: failed background re-introspection removes the token from cache
+	token2 := uuid.New().String()
+	introspectLock.Lock()
+	tokenIsValid = true
+	introspectLock.Unlock()
+	{
+		valid, err := uut.VerifyToken(ctxt, token2, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+	}
+	currentTime = currentTime.Add(time.Minute * 6)
+	introspectLock.Lock()
+	introspectErr = fmt.Errorf("dummy error")
+	introspectLock.Unlock()
+	{
+		valid, err := uut.VerifyToken(ctxt, token2, tokenExpire1.Unix(), currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+	}
+	assert.Eventually(func() bool {
+		unexpired, err := cache.UnexpiredTokenInCache(ctxt, token2, currentTime)
+		return err == nil && !unexpired
+	}, time.Second, time.Millisecond*10)
+	{
+		valid, err := uut.VerifyToken(ctxt, token2, tokenExpire1.Unix(), currentTime)
+		assert.NotNil(err)
+		assert.False(valid)
+	}
+}
+
+func TestIntrospectorRevalidateCachedTokens(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	cache := DefineTokenCache(time.Minute*5, 0, 0, nil)
+
+	introspected := map[string]int{}
+	revoked := map[string]bool{}
+	dummyIntrospect := func(_ context.Context, token string) (bool, error) {
+		introspected[token]++
+		return !revoked[token], nil
+	}
+
+	ctxt := context.Background()
+
+	uut := DefineIntrospector(cache, dummyIntrospect, nil, nil, false)
+
+	startTime := time.Now().UTC()
+	token1 := uuid.New().String()
+	token2 := uuid.New().String()
+	token3 := uuid.New().String()
+	// Token 3 is cached later, and expires before it is due for re-validation
+	assert.Nil(cache.RecordToken(ctxt, token1, startTime.Add(time.Hour).Unix(), startTime))
+	assert.Nil(cache.RecordToken(ctxt, token2, startTime.Add(time.Hour).Unix(), startTime))
+	assert.Nil(cache.RecordToken(
+		ctxt, token3, startTime.Add(time.Minute*5).Unix(), startTime.Add(time.Minute),
+	))
+
+	// Case 0: no token due for re-validation yet
+	assert.Equal(0, uut.RevalidateCachedTokens(ctxt, time.Minute, startTime.Add(time.Minute)))
+
+	// Case 1: re-introspect the tokens due within the next minute, and evict the revoked one
+	revoked[token2] = true
+	currentTime := startTime.Add(time.Minute * 4)
+	assert.Equal(2, uut.RevalidateCachedTokens(ctxt, time.Minute, currentTime))
+	assert.Equal(map[string]int{token1: 1, token2: 1}, introspected)
+	{
+		valid, err := cache.ValidTokenInCache(ctxt, token1, startTime.Add(time.Minute*6))
+		assert.Nil(err)
+		assert.True(valid)
+		unexpired, err := cache.UnexpiredTokenInCache(ctxt, token2, currentTime)
+		assert.Nil(err)
+		assert.False(unexpired)
+	}
+
+	// Case 2: the re-introspected token is not due again until its next re-validation
+	assert.Equal(0, uut.RevalidateCachedTokens(ctxt, time.Minute, currentTime))
+}
+
+func TestIntrospectorCacheBypass(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	cache := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+
+	introspectCalls := 0
+	tokenIsValid := true
+	dummyIntrospect := func(context.Context, string) (bool, error) {
+		introspectCalls++
+		return tokenIsValid, nil
+	}
+
+	ctxt := context.Background()
+	bypassCtxt := context.WithValue(ctxt, common.CacheBypassKey{}, true)
+
+	uut := DefineIntrospector(cache, dummyIntrospect, nil, nil, false)
+
+	currentTime := time.Now().UTC()
+	token := uuid.New().String()
+	tokenExpire := currentTime.Add(time.Hour).Unix()
+
+	// Case 0: cache the token
+	{
+		valid, err := uut.VerifyToken(ctxt, token, tokenExpire, currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		assert.Equal(1, introspectCalls)
+	}
+
+	// Case 1: the token is introspected when the cache is skipped
+	tokenIsValid = false
+	{
+		valid, err := uut.VerifyToken(bypassCtxt, token, tokenExpire, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+		assert.Equal(2, introspectCalls)
+	}
+
+	// Case 2: the result updated the cache
+	{
+		valid, err := uut.VerifyToken(ctxt, token, tokenExpire, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+		assert.Equal(2, introspectCalls)
+	}
+
+	// Case 3: the cached inactive token is still introspected when the cache is skipped
+	tokenIsValid = true
+	{
+		valid, err := uut.VerifyToken(bypassCtxt, token, tokenExpire, currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		assert.Equal(3, introspectCalls)
+	}
+}
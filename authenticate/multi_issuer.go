@@ -0,0 +1,246 @@
+package authenticate
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/*
+CanonicalIssuer normalize an issuer URL, so the issuer named by a token's "iss" claim can be
+compared with the configured issuers
+
+	@param issuer string - the issuer URL
+	@return the normalized issuer URL
+*/
+func CanonicalIssuer(issuer string) string {
+	return strings.TrimSuffix(issuer, "/")
+}
+
+/*
+TokenIssuer read the "iss" claim of a JWT without verifying the token
+
+	@param raw string - the original JWT string
+	@return the issuer of the token
+*/
+func TokenIssuer(raw string) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, claims); err != nil {
+		return "", err
+	}
+	issuer, ok := claims["iss"].(string)
+	if !ok || issuer == "" {
+		return "", fmt.Errorf("token does not name its issuer")
+	}
+	return issuer, nil
+}
+
+// multiIssuerClientImpl implements OpenIDIssuerClient over several OpenID issuers. Each token
+// is handled by the issuer named by its "iss" claim.
+type multiIssuerClientImpl struct {
+	// primary is the issuer handling the operations not tied to a token
+	primary string
+	// issuers are the clients of each issuer, keyed by the canonical issuer URL
+	issuers map[string]OpenIDIssuerClient
+}
+
+/*
+DefineMultiIssuerOpenIDClient defines an OpenIDIssuerClient which accepts tokens from several
+OpenID issuers. Each token is routed to the issuer named by its "iss" claim, and is only
+accepted if that issuer verifies it. Tokens from other issuers, and JWTs not naming their issuer,
+are rejected, while tokens which are not JWTs go to the primary issuer.
+
+	@param primary string - the issuer handling the operations not tied to a token, such as
+	requesting tokens
+	@param issuers map[string]OpenIDIssuerClient - the clients of each issuer, keyed by the
+	issuer URL
+	@return new client instance
+*/
+func DefineMultiIssuerOpenIDClient(
+	primary string, issuers map[string]OpenIDIssuerClient,
+) (OpenIDIssuerClient, error) {
+	clients := map[string]OpenIDIssuerClient{}
+	for issuer, client := range issuers {
+		clients[CanonicalIssuer(issuer)] = client
+	}
+	if _, ok := clients[CanonicalIssuer(primary)]; !ok {
+		return nil, fmt.Errorf("primary OpenID issuer %s is not among the issuers", primary)
+	}
+	return &multiIssuerClientImpl{primary: CanonicalIssuer(primary), issuers: clients}, nil
+}
+
+// clientFor helper function to select the issuer client for a token. Tokens which are not
+// JWTs, such as opaque access tokens, are handled by the primary issuer.
+func (c *multiIssuerClientImpl) clientFor(raw string) (OpenIDIssuerClient, error) {
+	_, client, err := c.routeToken(raw)
+	return client, err
+}
+
+// routeToken helper function to select the issuer for a token, by the token's unverified
+// "iss" claim. Tokens which are not JWTs are routed to the primary issuer, while JWTs not
+// naming their issuer are rejected.
+func (c *multiIssuerClientImpl) routeToken(raw string) (string, OpenIDIssuerClient, error) {
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, jwt.MapClaims{}); err != nil {
+		return c.primary, c.issuers[c.primary], nil
+	}
+	issuer, err := TokenIssuer(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	client, ok := c.issuers[CanonicalIssuer(issuer)]
+	if !ok {
+		return "", nil, fmt.Errorf("token issued by unknown OpenID issuer %s", issuer)
+	}
+	return CanonicalIssuer(issuer), client, nil
+}
+
+// verifiedIssuer helper function to read the "iss" claim of a verified token
+func verifiedIssuer(claims jwt.Claims) (string, bool) {
+	switch typed := claims.(type) {
+	case jwt.MapClaims:
+		issuer, ok := typed["iss"].(string)
+		return issuer, ok
+	case *jwt.MapClaims:
+		issuer, ok := (*typed)["iss"].(string)
+		return issuer, ok
+	case *jwt.RegisteredClaims:
+		return typed.Issuer, typed.Issuer != ""
+	case jwt.RegisteredClaims:
+		return typed.Issuer, typed.Issuer != ""
+	}
+	return "", false
+}
+
+/*
+AssociatedPublicKey fetches the associated public based on "kid" value of a JWT token
+
+	@param token *jwt.Token - the JWT token to find the public key for
+	@return public key material
+*/
+func (c *multiIssuerClientImpl) AssociatedPublicKey(token *jwt.Token) (interface{}, error) {
+	client, err := c.clientFor(token.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return client.AssociatedPublicKey(token)
+}
+
+/*
+ParseJWT parses a string into a JWT token object. The token is verified by the issuer named by
+its "iss" claim, and once verified, its "iss" claim must still name that issuer.
+
+	@param raw string - the original JWT string
+	@param claimStore jwt.Claims - the object to store the claims in
+	@return the parsed JWT token object
+*/
+func (c *multiIssuerClientImpl) ParseJWT(raw string, claimStore jwt.Claims) (*jwt.Token, error) {
+	routed, client, err := c.routeToken(raw)
+	if err != nil {
+		return nil, err
+	}
+	token, err := client.ParseJWT(raw, claimStore)
+	if err != nil {
+		return token, err
+	}
+	issuer, ok := verifiedIssuer(token.Claims)
+	if !ok || CanonicalIssuer(issuer) != routed {
+		return nil, fmt.Errorf("token issuer does not match the verifying OpenID issuer %s", routed)
+	}
+	return token, nil
+}
+
+/*
+CanIntrospect whether the client can perform introspection
+
+	@return whether any of the issuer clients can perform introspection
+*/
+func (c *multiIssuerClientImpl) CanIntrospect() bool {
+	for _, client := range c.issuers {
+		if client.CanIntrospect() {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+IntrospectToken perform introspection for a token
+
+	@param ctxt context.Context - the operating context
+	@param token string - the token to introspect
+	@return whether token is still valid
+*/
+func (c *multiIssuerClientImpl) IntrospectToken(ctxt context.Context, token string) (bool, error) {
+	client, err := c.clientFor(token)
+	if err != nil {
+		return false, err
+	}
+	return client.IntrospectToken(ctxt, token)
+}
+
+/*
+IntrospectTokenDetails perform introspection for a token, and return the full decoded
+introspection response
+
+	@param ctxt context.Context - the operating context
+	@param token string - the token to introspect
+	@return the decoded introspection response
+*/
+func (c *multiIssuerClientImpl) IntrospectTokenDetails(
+	ctxt context.Context, token string,
+) (map[string]interface{}, error) {
+	client, err := c.clientFor(token)
+	if err != nil {
+		return nil, err
+	}
+	return client.IntrospectTokenDetails(ctxt, token)
+}
+
+/*
+RequestToken request a token from the primary issuer's token endpoint, authenticating with the
+client credentials
+
+	@param ctxt context.Context - the operating context
+	@param grantParams url.Values - the grant parameters, including "grant_type"
+	@return the token endpoint response
+*/
+func (c *multiIssuerClientImpl) RequestToken(
+	ctxt context.Context, grantParams url.Values,
+) (TokenGrantResponse, error) {
+	return c.issuers[c.primary].RequestToken(ctxt, grantParams)
+}
+
+/*
+FetchUserinfo query the issuer's userinfo endpoint on behalf of a token
+
+	@param ctxt context.Context - the operating context
+	@param token string - the access token
+	@return the userinfo claims
+*/
+func (c *multiIssuerClientImpl) FetchUserinfo(
+	ctxt context.Context, token string,
+) (map[string]interface{}, error) {
+	client, err := c.clientFor(token)
+	if err != nil {
+		return nil, err
+	}
+	return client.FetchUserinfo(ctxt, token)
+}
+
+/*
+ProbeEndpoints check whether the endpoints of every OpenID issuer are reachable
+
+	@param ctxt context.Context - the operating context
+	@return nil if reachable, or an error otherwise
+*/
+func (c *multiIssuerClientImpl) ProbeEndpoints(ctxt context.Context) error {
+	for issuer, client := range c.issuers {
+		if err := client.ProbeEndpoints(ctxt); err != nil {
+			return fmt.Errorf("OpenID issuer %s: %w", issuer, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package authenticate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// namedOpenIDClient reports its name as the userinfo of every token. Only ParseJWT and
+// FetchUserinfo are implemented.
+type namedOpenIDClient struct {
+	OpenIDIssuerClient
+	name string
+	// verifiedIssuer if set, replaces the "iss" claim of the parsed tokens
+	verifiedIssuer string
+}
+
+func (c namedOpenIDClient) ParseJWT(raw string, claimStore jwt.Claims) (*jwt.Token, error) {
+	token, _, err := jwt.NewParser().ParseUnverified(raw, claimStore)
+	if err != nil {
+		return nil, err
+	}
+	if c.verifiedIssuer != "" {
+		if claims, ok := claimStore.(*jwt.MapClaims); ok {
+			(*claims)["iss"] = c.verifiedIssuer
+		}
+	}
+	token.Valid = true
+	return token, nil
+}
+
+func (c namedOpenIDClient) FetchUserinfo(
+	ctxt context.Context, token string,
+) (map[string]interface{}, error) {
+	return map[string]interface{}{"client": c.name}, nil
+}
+
+func TestMultiIssuerOpenIDClient(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	signClaims := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).
+			SignedString([]byte("unit-test"))
+		assert.Nil(err)
+		return token
+	}
+	signToken := func(issuer string) string {
+		return signClaims(jwt.MapClaims{"iss": issuer, "sub": "alice"})
+	}
+
+	issuers := map[string]OpenIDIssuerClient{
+		"https://workforce.unit-test.org/": namedOpenIDClient{name: "workforce"},
+		"https://customer.unit-test.org":   namedOpenIDClient{name: "customer"},
+		"https://partner.unit-test.org": namedOpenIDClient{
+			name: "partner", verifiedIssuer: "https://workforce.unit-test.org",
+		},
+	}
+
+	// Case 0: primary issuer must be among the issuers
+	{
+		_, err := DefineMultiIssuerOpenIDClient("https://other.unit-test.org", issuers)
+		assert.NotNil(err)
+	}
+
+	uut, err := DefineMultiIssuerOpenIDClient("https://workforce.unit-test.org", issuers)
+	assert.Nil(err)
+
+	ctxt := context.Background()
+	for idx, testCase := range []struct {
+		token  string
+		client string
+	}{
+		{token: signToken("https://workforce.unit-test.org"), client: "workforce"},
+		{token: signToken("https://customer.unit-test.org/"), client: "customer"},
+		{token: "opaque-token", client: "workforce"},
+	} {
+		// Case 1: route by the token's issuer
+		info, err := uut.FetchUserinfo(ctxt, testCase.token)
+		assert.Nil(err, fmt.Sprintf("case %d", idx))
+		assert.Equal(testCase.client, info["client"], fmt.Sprintf("case %d", idx))
+	}
+
+	// Case 2: token from an unknown issuer
+	{
+		_, err := uut.ParseJWT(signToken("https://other.unit-test.org"), &jwt.MapClaims{})
+		assert.NotNil(err)
+		_, err = uut.ParseJWT(signToken("https://customer.unit-test.org"), &jwt.MapClaims{})
+		assert.Nil(err)
+	}
+
+	// Case 3: JWT not naming its issuer
+	{
+		_, err := uut.ParseJWT(signClaims(jwt.MapClaims{"sub": "alice"}), &jwt.MapClaims{})
+		assert.NotNil(err)
+	}
+
+	// Case 4: verified token names another issuer than the one verifying it
+	{
+		_, err := uut.ParseJWT(signToken("https://partner.unit-test.org"), &jwt.MapClaims{})
+		assert.NotNil(err)
+	}
+}
@@ -10,6 +10,10 @@ import (
 	"io"
 	"math/big"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/common"
@@ -51,6 +55,55 @@ type OpenIDIssuerClient interface {
 		 @return whether token is still valid
 	*/
 	IntrospectToken(ctxt context.Context, token string) (bool, error)
+
+	/*
+		IntrospectTokenDetails perform introspection for a token, and return the full decoded
+		introspection response
+
+		 @param ctxt context.Context - the operating context
+		 @param token string - the token to introspect
+		 @return the decoded introspection response
+	*/
+	IntrospectTokenDetails(ctxt context.Context, token string) (map[string]interface{}, error)
+
+	/*
+		RequestToken request a token from the issuer's token endpoint, authenticating with the
+		client credentials
+
+		 @param ctxt context.Context - the operating context
+		 @param grantParams url.Values - the grant parameters, including "grant_type"
+		 @return the token endpoint response
+	*/
+	RequestToken(ctxt context.Context, grantParams url.Values) (TokenGrantResponse, error)
+
+	/*
+		FetchUserinfo query the issuer's userinfo endpoint on behalf of a token
+
+		 @param ctxt context.Context - the operating context
+		 @param token string - the access token
+		 @return the userinfo claims
+	*/
+	FetchUserinfo(ctxt context.Context, token string) (map[string]interface{}, error)
+
+	/*
+		ProbeEndpoints check whether the OpenID issuer's discovery, JWKS, and introspection
+		(if available) endpoints are reachable, whether the signing keys are loaded, and whether
+		the introspection client credentials (if provided) are accepted
+
+		 @param ctxt context.Context - the operating context
+		 @return nil if reachable, or an error otherwise
+	*/
+	ProbeEndpoints(ctxt context.Context) error
+}
+
+// TokenGrantResponse is the issuer's token endpoint response
+type TokenGrantResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
 }
 
 // OpenIDIssuerConfig holds the OpenID issuer's API info.
@@ -83,15 +136,32 @@ type OIDSigningJWK struct {
 	Use       string `json:"use"`
 }
 
+// defaultJWKSRefreshMinInterval is the default minimum interval between refetching the JWKS
+// on encountering an unknown "kid"
+const defaultJWKSRefreshMinInterval = time.Second * 30
+
+// defaultJWKSRefreshInterval is the default interval between refetching the JWKS in the
+// background
+const defaultJWKSRefreshInterval = time.Hour
+
 // openIDIssuerClientImpl implements OpenIDIssuerClient
 type openIDIssuerClientImpl struct {
 	goutils.Component
 	cfg          OpenIDIssuerConfig
+	discoveryEP  string
 	hostOverride *string
 	httpClient   *http.Client
-	publicKey    map[string]interface{}
 	clientID     *string
 	clientSecret *string
+	// clientSecretFile if provided, the client secret is read from this file instead
+	clientSecretFile common.SecretFile
+	// publicKey the issuer's signing public keys by "kid", guarded by publicKeyLock
+	publicKey     map[string]interface{}
+	publicKeyLock sync.RWMutex
+	// publicKeyFetched when the JWKS was last fetched
+	publicKeyFetched time.Time
+	// jwksRefreshMinInt minimum interval between refetching the JWKS on an unknown "kid"
+	jwksRefreshMinInt time.Duration
 }
 
 /*
@@ -129,15 +199,90 @@ func DefineOpenIDClient(
 	}
 
 	// Read the issuer's signing public key
-	resp, err = httpClient.Get(cfg.JwksURI)
+	keyMaterial, err := fetchSigningKeys(httpClient, cfg.JwksURI, logTags)
+	if err != nil {
+		return nil, err
+	}
+
+	{
+		t, _ := json.MarshalIndent(&cfg, "", "  ")
+		log.WithFields(logTags).Debugf("OpenID issuer parameters\n%s", t)
+	}
+
+	if idpConfig.RequestHostOverride != nil {
+		log.WithFields(logTags).Warnf(
+			"Using host override '%s' when communicating with IDP", *idpConfig.RequestHostOverride,
+		)
+	}
+
+	// The client secret file is watched for the lifetime of the process
+	var clientSecretFile common.SecretFile
+	if idpConfig.ClientCredFile != nil {
+		clientSecretFile, err = common.WatchSecretFile(*idpConfig.ClientCredFile)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Error("Unable to read client secret file")
+			return nil, err
+		}
+	}
+
+	jwksRefreshMinInt := defaultJWKSRefreshMinInterval
+	if idpConfig.JWKSRefreshMinInterval != nil {
+		jwksRefreshMinInt = time.Second * time.Duration(*idpConfig.JWKSRefreshMinInterval)
+	}
+	jwksRefreshInt := defaultJWKSRefreshInterval
+	if idpConfig.JWKSRefreshInterval != nil {
+		jwksRefreshInt = time.Second * time.Duration(*idpConfig.JWKSRefreshInterval)
+	}
+
+	instance := &openIDIssuerClientImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		cfg:               cfg,
+		discoveryEP:       cfgEP,
+		hostOverride:      idpConfig.RequestHostOverride,
+		httpClient:        httpClient,
+		clientID:          idpConfig.ClientID,
+		clientSecret:      idpConfig.ClientCred,
+		clientSecretFile:  clientSecretFile,
+		publicKey:         keyMaterial,
+		publicKeyLock:     sync.RWMutex{},
+		publicKeyFetched:  time.Now(),
+		jwksRefreshMinInt: jwksRefreshMinInt,
+	}
+
+	// The JWKS is refreshed in the background for the lifetime of the process
+	if jwksRefreshInt > 0 {
+		go instance.refreshSigningKeysPeriodically(jwksRefreshInt)
+	}
+
+	return instance, nil
+}
+
+/*
+fetchSigningKeys read the OpenID issuer's signing public keys from its JWKS endpoint
+
+	@param httpClient *http.Client - the HTTP client to use to communicate with the OpenID issuer
+	@param jwksURI string - the JWKS endpoint
+	@param logTags log.Fields - the log metadata
+	@return the public keys by "kid"
+*/
+func fetchSigningKeys(
+	httpClient *http.Client, jwksURI string, logTags log.Fields,
+) (map[string]interface{}, error) {
+	resp, err := httpClient.Get(jwksURI)
 	if err != nil {
-		log.WithError(err).WithFields(logTags).Errorf("GET %s unsuccessful", cfg.JwksURI)
+		log.WithError(err).WithFields(logTags).Errorf("GET %s unsuccessful", jwksURI)
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf("reading JWKS from %s returned %d", cfg.JwksURI, resp.StatusCode)
-		log.WithError(err).WithFields(logTags).Errorf("GET %s unsuccessful", cfg.JwksURI)
+		err := fmt.Errorf("reading JWKS from %s returned %d", jwksURI, resp.StatusCode)
+		log.WithError(err).WithFields(logTags).Errorf("GET %s unsuccessful", jwksURI)
 		return nil, err
 	}
 	type jwksResp struct {
@@ -145,7 +290,7 @@ func DefineOpenIDClient(
 	}
 	var signingKeys jwksResp
 	if err := json.NewDecoder(resp.Body).Decode(&signingKeys); err != nil {
-		log.WithError(err).WithFields(logTags).Errorf("Failed to parse %s response", cfg.JwksURI)
+		log.WithError(err).WithFields(logTags).Errorf("Failed to parse %s response", jwksURI)
 		return nil, err
 	}
 
@@ -170,37 +315,13 @@ func DefineOpenIDClient(
 
 		keyMaterial[key.ID] = pubKey
 	}
-
-	{
-		t, _ := json.MarshalIndent(&cfg, "", "  ")
-		log.WithFields(logTags).Debugf("OpenID issuer parameters\n%s", t)
-	}
-
-	if idpConfig.RequestHostOverride != nil {
-		log.WithFields(logTags).Warnf(
-			"Using host override '%s' when communicating with IDP", *idpConfig.RequestHostOverride,
-		)
-	}
-
-	return &openIDIssuerClientImpl{
-		Component: goutils.Component{
-			LogTags: logTags,
-			LogTagModifiers: []goutils.LogMetadataModifier{
-				goutils.ModifyLogMetadataByRestRequestParam,
-				common.ModifyLogMetadataByAccessAuthorizeParam,
-			},
-		},
-		cfg:          cfg,
-		hostOverride: idpConfig.RequestHostOverride,
-		httpClient:   httpClient,
-		publicKey:    keyMaterial,
-		clientID:     idpConfig.ClientID,
-		clientSecret: idpConfig.ClientCred,
-	}, nil
+	return keyMaterial, nil
 }
 
 /*
-AssociatedPublicKey fetches the associated public based on "kid" value of a JWT token
+AssociatedPublicKey fetches the associated public based on "kid" value of a JWT token. If the
+"kid" is unknown, the JWKS is refetched, at most once per minimum refresh interval, in case the
+issuer rotated in a new signing key.
 
 	@param token *jwt.Token - the JWT token to find the public key for
 	@return public key material
@@ -214,7 +335,13 @@ func (c *openIDIssuerClientImpl) AssociatedPublicKey(token *jwt.Token) (interfac
 	if !ok {
 		return nil, fmt.Errorf("jwt 'kid' field does not contain a string")
 	}
-	if pubKey, ok := c.publicKey[kid]; ok {
+	c.publicKeyLock.RLock()
+	pubKey, ok := c.publicKey[kid]
+	c.publicKeyLock.RUnlock()
+	if ok {
+		return pubKey, nil
+	}
+	if pubKey, ok := c.refreshSigningKeys(kid); ok {
 		return pubKey, nil
 	}
 	msg := fmt.Sprintf("Encountered JWT referring public key %s which is unknown", kid)
@@ -222,6 +349,58 @@ func (c *openIDIssuerClientImpl) AssociatedPublicKey(token *jwt.Token) (interfac
 	return nil, fmt.Errorf(msg)
 }
 
+/*
+refreshSigningKeys refetch the JWKS to find an unknown "kid", unless the JWKS was fetched within
+the minimum refresh interval
+
+	@param kid string - the unknown "kid"
+	@return the public key, and whether it was found
+*/
+func (c *openIDIssuerClientImpl) refreshSigningKeys(kid string) (interface{}, bool) {
+	c.publicKeyLock.Lock()
+	defer c.publicKeyLock.Unlock()
+	// Another request may have refetched the JWKS in the meantime
+	if pubKey, ok := c.publicKey[kid]; ok {
+		return pubKey, true
+	}
+	if time.Since(c.publicKeyFetched) < c.jwksRefreshMinInt {
+		return nil, false
+	}
+	log.WithFields(c.LogTags).Infof("Refetching JWKS to find unknown public key %s", kid)
+	c.publicKeyFetched = time.Now()
+	keyMaterial, err := fetchSigningKeys(c.httpClient, c.cfg.JwksURI, c.LogTags)
+	if err != nil {
+		return nil, false
+	}
+	c.publicKey = keyMaterial
+	pubKey, ok := c.publicKey[kid]
+	return pubKey, ok
+}
+
+/*
+refreshSigningKeysPeriodically refetch the JWKS on a fixed interval, so signing keys rotated in
+by the issuer are known before the first token using them arrives, and retired keys are dropped.
+If a refetch fails, the current signing keys remain in use.
+
+	@param interval time.Duration - the interval between refetches
+*/
+func (c *openIDIssuerClientImpl) refreshSigningKeysPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		keyMaterial, err := fetchSigningKeys(c.httpClient, c.cfg.JwksURI, c.LogTags)
+		if err != nil {
+			log.WithError(err).WithFields(c.LogTags).Error("Periodic JWKS refresh failed")
+			continue
+		}
+		c.publicKeyLock.Lock()
+		c.publicKey = keyMaterial
+		c.publicKeyFetched = time.Now()
+		c.publicKeyLock.Unlock()
+		log.WithFields(c.LogTags).Debugf("Refreshed JWKS with %d signing keys", len(keyMaterial))
+	}
+}
+
 /*
 ParseJWT parses a string into a JWT token object.
 
@@ -256,13 +435,26 @@ type introspectResponse struct {
 	Active            bool             `json:"active"`
 }
 
+/*
+secret get the current client secret
+
+	@return the client secret, or nil if not provided
+*/
+func (c *openIDIssuerClientImpl) secret() *string {
+	if c.clientSecretFile != nil {
+		value := c.clientSecretFile.Value()
+		return &value
+	}
+	return c.clientSecret
+}
+
 /*
 CanIntrospect whether the client can perform introspection
 
 	@return whether the client can perform introspection
 */
 func (c *openIDIssuerClientImpl) CanIntrospect() bool {
-	if c.clientID == nil || c.clientSecret == nil || c.cfg.IntrospectionEP == "" {
+	if c.clientID == nil || c.secret() == nil || c.cfg.IntrospectionEP == "" {
 		// Introspection require
 		// * Introspection endpoint
 		// * Client ID
@@ -273,24 +465,23 @@ func (c *openIDIssuerClientImpl) CanIntrospect() bool {
 }
 
 /*
-IntrospectToken perform introspection for a token
+introspect perform introspection for a token
 
 	@param ctxt context.Context - the operating context
 	@param token string - the token to introspect
-	@return whether token is still valid
+	@return the raw introspection response
 */
-func (c *openIDIssuerClientImpl) IntrospectToken(ctxt context.Context, token string) (bool, error) {
+func (c *openIDIssuerClientImpl) introspect(ctxt context.Context, token string) ([]byte, error) {
 	logtags := c.GetLogTagsForContext(ctxt)
-	if c.clientID == nil || c.clientSecret == nil || c.cfg.IntrospectionEP == "" {
+	if c.clientID == nil || c.secret() == nil || c.cfg.IntrospectionEP == "" {
 		// Introspection require
 		// * Introspection endpoint
 		// * Client ID
 		// * Client secret
 		log.WithFields(logtags).Error("Missing required settings to perform introspection")
-		return false, fmt.Errorf("missing required settings to perform introspection")
+		return nil, fmt.Errorf("missing required settings to perform introspection")
 	}
 
-	var response introspectResponse
 	introspectURL := c.cfg.IntrospectionEP
 
 	// Prepare the request
@@ -298,9 +489,9 @@ func (c *openIDIssuerClientImpl) IntrospectToken(ctxt context.Context, token str
 	req, err := http.NewRequest("POST", introspectURL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		log.WithError(err).WithFields(logtags).Error("Failed to define introspect POST request")
-		return false, err
+		return nil, err
 	}
-	req.SetBasicAuth(*c.clientID, *c.clientSecret)
+	req.SetBasicAuth(*c.clientID, *c.secret())
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	if c.hostOverride != nil {
@@ -311,15 +502,33 @@ func (c *openIDIssuerClientImpl) IntrospectToken(ctxt context.Context, token str
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		log.WithError(err).WithFields(logtags).Errorf("Introspect against %s failed", introspectURL)
-		return false, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Parse the response
 	body, _ := io.ReadAll(resp.Body)
 	{
 		log.WithFields(logtags).Debugf("Raw introspect response %s", body)
 	}
+	return body, nil
+}
+
+/*
+IntrospectToken perform introspection for a token
+
+	@param ctxt context.Context - the operating context
+	@param token string - the token to introspect
+	@return whether token is still valid
+*/
+func (c *openIDIssuerClientImpl) IntrospectToken(ctxt context.Context, token string) (bool, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	body, err := c.introspect(ctxt, token)
+	if err != nil {
+		return false, err
+	}
+
+	// Parse the response
+	var response introspectResponse
 	if err := json.Unmarshal(body, &response); err != nil {
 		log.WithError(err).WithFields(logtags).Error("Failed to process introspect response")
 		return false, err
@@ -327,3 +536,398 @@ func (c *openIDIssuerClientImpl) IntrospectToken(ctxt context.Context, token str
 
 	return response.Active, nil
 }
+
+/*
+IntrospectTokenDetails perform introspection for a token, and return the full decoded
+introspection response
+
+	@param ctxt context.Context - the operating context
+	@param token string - the token to introspect
+	@return the decoded introspection response
+*/
+func (c *openIDIssuerClientImpl) IntrospectTokenDetails(
+	ctxt context.Context, token string,
+) (map[string]interface{}, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	body, err := c.introspect(ctxt, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the response
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to process introspect response")
+		return nil, err
+	}
+
+	return response, nil
+}
+
+/*
+RequestToken request a token from the issuer's token endpoint, authenticating with the
+client credentials
+
+	@param ctxt context.Context - the operating context
+	@param grantParams url.Values - the grant parameters, including "grant_type"
+	@return the token endpoint response
+*/
+func (c *openIDIssuerClientImpl) RequestToken(
+	ctxt context.Context, grantParams url.Values,
+) (TokenGrantResponse, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	if c.clientID == nil || c.cfg.TokenEP == "" {
+		log.WithFields(logtags).Error("Missing required settings to request a token")
+		return TokenGrantResponse{}, fmt.Errorf("missing required settings to request a token")
+	}
+
+	// Prepare the request
+	params := url.Values{}
+	for name, values := range grantParams {
+		params[name] = values
+	}
+	clientSecret := c.secret()
+	if clientSecret == nil {
+		// A public client only identifies itself
+		params.Set("client_id", *c.clientID)
+	}
+	req, err := http.NewRequestWithContext(
+		ctxt, http.MethodPost, c.cfg.TokenEP, strings.NewReader(params.Encode()),
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to define token POST request")
+		return TokenGrantResponse{}, err
+	}
+	if clientSecret != nil {
+		req.SetBasicAuth(*c.clientID, *clientSecret)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.hostOverride != nil {
+		req.Host = *c.hostOverride
+	}
+
+	// Perform the request
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Token request against %s failed", c.cfg.TokenEP)
+		return TokenGrantResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	// Parse the response
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("token request returned %d: %s", resp.StatusCode, body)
+		log.WithError(err).WithFields(logtags).Error("Token request unsuccessful")
+		return TokenGrantResponse{}, err
+	}
+	var response TokenGrantResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to process token response")
+		return TokenGrantResponse{}, err
+	}
+	return response, nil
+}
+
+/*
+FetchUserinfo query the issuer's userinfo endpoint on behalf of a token
+
+	@param ctxt context.Context - the operating context
+	@param token string - the access token
+	@return the userinfo claims
+*/
+func (c *openIDIssuerClientImpl) FetchUserinfo(
+	ctxt context.Context, token string,
+) (map[string]interface{}, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	if c.cfg.UserinfoEP == "" {
+		log.WithFields(logtags).Error("Issuer does not advertise a userinfo endpoint")
+		return nil, fmt.Errorf("issuer does not advertise a userinfo endpoint")
+	}
+
+	// Prepare the request
+	req, err := http.NewRequestWithContext(ctxt, http.MethodGet, c.cfg.UserinfoEP, nil)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to define userinfo GET request")
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/json")
+	if c.hostOverride != nil {
+		req.Host = *c.hostOverride
+	}
+
+	// Perform the request
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Userinfo against %s failed", c.cfg.UserinfoEP)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Parse the response
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("userinfo request returned %d: %s", resp.StatusCode, body)
+		log.WithError(err).WithFields(logtags).Error("Userinfo request unsuccessful")
+		return nil, err
+	}
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to process userinfo response")
+		return nil, err
+	}
+	return response, nil
+}
+
+/*
+ProbeEndpoints check whether the OpenID issuer's discovery, JWKS, and introspection
+(if available) endpoints are reachable, whether the signing keys are loaded, and whether
+the introspection client credentials (if provided) are accepted
+
+	@param ctxt context.Context - the operating context
+	@return nil if reachable, or an error otherwise
+*/
+func (c *openIDIssuerClientImpl) ProbeEndpoints(ctxt context.Context) error {
+	logtags := c.GetLogTagsForContext(ctxt)
+
+	// probe helper function. Any response below 500 from the introspection endpoint indicates
+	// the endpoint is reachable, as the probe does not carry a valid token. When the client
+	// credentials are given, the issuer must accept them, and report on the dummy token.
+	probe := func(method, endpoint string, requireOK, withCredentials bool) error {
+		var body io.Reader
+		if withCredentials {
+			body = bytes.NewBufferString("token=padlock-readiness-probe")
+		}
+		req, err := http.NewRequestWithContext(ctxt, method, endpoint, body)
+		if err != nil {
+			return err
+		}
+		if withCredentials {
+			req.SetBasicAuth(*c.clientID, *c.secret())
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if c.hostOverride != nil {
+			req.Host = *c.hostOverride
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			log.WithError(err).WithFields(logtags).Errorf("Probe %s %s failed", method, endpoint)
+			return err
+		}
+		defer resp.Body.Close()
+		if (requireOK && resp.StatusCode != http.StatusOK) ||
+			resp.StatusCode >= http.StatusInternalServerError {
+			err := fmt.Errorf("probe %s %s returned %d", method, endpoint, resp.StatusCode)
+			log.WithError(err).WithFields(logtags).Error("Probe unsuccessful")
+			return err
+		}
+		return nil
+	}
+
+	if err := probe(http.MethodGet, c.discoveryEP, true, false); err != nil {
+		return err
+	}
+	if err := probe(http.MethodGet, c.cfg.JwksURI, true, false); err != nil {
+		return err
+	}
+	c.publicKeyLock.RLock()
+	keyCount := len(c.publicKey)
+	c.publicKeyLock.RUnlock()
+	if keyCount == 0 {
+		err := fmt.Errorf("no signing keys loaded from %s", c.cfg.JwksURI)
+		log.WithError(err).WithFields(logtags).Error("Probe unsuccessful")
+		return err
+	}
+	if c.cfg.IntrospectionEP != "" {
+		canIntrospect := c.CanIntrospect()
+		if err := probe(
+			http.MethodPost, c.cfg.IntrospectionEP, canIntrospect, canIntrospect,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ====================================================================================
+
+// DeferredOpenIDIssuerClient is an OpenIDIssuerClient which forwards calls to a client defined
+// later, once OpenID issuer discovery completes. Until then, all tokens are rejected.
+type DeferredOpenIDIssuerClient interface {
+	OpenIDIssuerClient
+
+	/*
+		SetClient install the client to forward calls to
+
+		 @param client OpenIDIssuerClient - the client
+	*/
+	SetClient(client OpenIDIssuerClient)
+}
+
+// deferredOpenIDIssuerClientImpl implements DeferredOpenIDIssuerClient
+type deferredOpenIDIssuerClientImpl struct {
+	lock   sync.RWMutex
+	client OpenIDIssuerClient
+}
+
+/*
+DefineDeferredOpenIDClient defines a new DeferredOpenIDIssuerClient
+
+	@return new client instance
+*/
+func DefineDeferredOpenIDClient() DeferredOpenIDIssuerClient {
+	return &deferredOpenIDIssuerClientImpl{lock: sync.RWMutex{}, client: nil}
+}
+
+/*
+SetClient install the client to forward calls to
+
+	@param client OpenIDIssuerClient - the client
+*/
+func (c *deferredOpenIDIssuerClientImpl) SetClient(client OpenIDIssuerClient) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.client = client
+}
+
+// getClient helper function to fetch the installed client
+func (c *deferredOpenIDIssuerClientImpl) getClient() (OpenIDIssuerClient, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.client == nil {
+		return nil, fmt.Errorf("OpenID issuer discovery not yet complete")
+	}
+	return c.client, nil
+}
+
+/*
+AssociatedPublicKey fetches the associated public based on "kid" value of a JWT token
+
+	@param token *jwt.Token - the JWT token to find the public key for
+	@return public key material
+*/
+func (c *deferredOpenIDIssuerClientImpl) AssociatedPublicKey(token *jwt.Token) (interface{}, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.AssociatedPublicKey(token)
+}
+
+/*
+ParseJWT parses a string into a JWT token object.
+
+	@param raw string - the original JWT string
+	@param claimStore jwt.Claims - the object to store the claims in
+	@return the parsed JWT token object
+*/
+func (c *deferredOpenIDIssuerClientImpl) ParseJWT(raw string, claimStore jwt.Claims) (
+	*jwt.Token, error,
+) {
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.ParseJWT(raw, claimStore)
+}
+
+/*
+CanIntrospect whether the client can perform introspection
+
+	@return whether the client can perform introspection
+*/
+func (c *deferredOpenIDIssuerClientImpl) CanIntrospect() bool {
+	client, err := c.getClient()
+	if err != nil {
+		return false
+	}
+	return client.CanIntrospect()
+}
+
+/*
+IntrospectToken perform introspection for a token
+
+	@param ctxt context.Context - the operating context
+	@param token string - the token to introspect
+	@return whether token is still valid
+*/
+func (c *deferredOpenIDIssuerClientImpl) IntrospectToken(ctxt context.Context, token string) (
+	bool, error,
+) {
+	client, err := c.getClient()
+	if err != nil {
+		return false, err
+	}
+	return client.IntrospectToken(ctxt, token)
+}
+
+/*
+IntrospectTokenDetails perform introspection for a token, and return the full decoded
+introspection response
+
+	@param ctxt context.Context - the operating context
+	@param token string - the token to introspect
+	@return the decoded introspection response
+*/
+func (c *deferredOpenIDIssuerClientImpl) IntrospectTokenDetails(
+	ctxt context.Context, token string,
+) (map[string]interface{}, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.IntrospectTokenDetails(ctxt, token)
+}
+
+/*
+RequestToken request a token from the issuer's token endpoint, authenticating with the
+client credentials
+
+	@param ctxt context.Context - the operating context
+	@param grantParams url.Values - the grant parameters, including "grant_type"
+	@return the token endpoint response
+*/
+func (c *deferredOpenIDIssuerClientImpl) RequestToken(
+	ctxt context.Context, grantParams url.Values,
+) (TokenGrantResponse, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return TokenGrantResponse{}, err
+	}
+	return client.RequestToken(ctxt, grantParams)
+}
+
+/*
+FetchUserinfo query the issuer's userinfo endpoint on behalf of a token
+
+	@param ctxt context.Context - the operating context
+	@param token string - the access token
+	@return the userinfo claims
+*/
+func (c *deferredOpenIDIssuerClientImpl) FetchUserinfo(
+	ctxt context.Context, token string,
+) (map[string]interface{}, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.FetchUserinfo(ctxt, token)
+}
+
+/*
+ProbeEndpoints check whether the OpenID issuer's discovery, JWKS, and introspection
+(if available) endpoints are reachable, whether the signing keys are loaded, and whether
+the introspection client credentials (if provided) are accepted
+
+	@param ctxt context.Context - the operating context
+	@return nil if reachable, or an error otherwise
+*/
+func (c *deferredOpenIDIssuerClientImpl) ProbeEndpoints(ctxt context.Context) error {
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+	return client.ProbeEndpoints(ctxt)
+}
@@ -0,0 +1,387 @@
+package authenticate
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenIDClientIssuerCalls(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(OpenIDIssuerConfig{
+				Issuer:          server.URL,
+				JwksURI:         server.URL + "/certs",
+				IntrospectionEP: server.URL + "/introspect",
+				TokenEP:         server.URL + "/token",
+			})
+		case "/certs":
+			_, _ = w.Write([]byte(`{"keys":[]}`))
+		case "/introspect":
+			clientID, clientSecret, ok := r.BasicAuth()
+			assert.True(ok)
+			assert.Equal("padlock", clientID)
+			assert.Equal("secret", clientSecret)
+			assert.Nil(r.ParseForm())
+			active := r.PostForm.Get("token") == "good-token"
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"active": active, "username": "user-1", "scope": "openid",
+			})
+		case "/token":
+			clientID, clientSecret, ok := r.BasicAuth()
+			assert.True(ok)
+			assert.Equal("padlock", clientID)
+			assert.Equal("secret", clientSecret)
+			assert.Nil(r.ParseForm())
+			if r.PostForm.Get("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"unsupported_grant_type"}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(TokenGrantResponse{
+				AccessToken: "good-token", TokenType: "Bearer", ExpiresIn: 300,
+				Scope: r.PostForm.Get("scope"),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	clientID := "padlock"
+	clientSecret := "secret"
+	uut, err := DefineOpenIDClient(
+		common.OpenIDIssuerConfig{
+			Issuer: server.URL, ClientID: &clientID, ClientCred: &clientSecret,
+		},
+		server.Client(),
+	)
+	assert.Nil(err)
+	assert.True(uut.CanIntrospect())
+
+	ctxt := context.Background()
+
+	// Case 0: active token
+	{
+		active, err := uut.IntrospectToken(ctxt, "good-token")
+		assert.Nil(err)
+		assert.True(active)
+		details, err := uut.IntrospectTokenDetails(ctxt, "good-token")
+		assert.Nil(err)
+		assert.Equal(true, details["active"])
+		assert.Equal("user-1", details["username"])
+		assert.Equal("openid", details["scope"])
+	}
+
+	// Case 1: inactive token
+	{
+		active, err := uut.IntrospectToken(ctxt, "bad-token")
+		assert.Nil(err)
+		assert.False(active)
+		details, err := uut.IntrospectTokenDetails(ctxt, "bad-token")
+		assert.Nil(err)
+		assert.Equal(false, details["active"])
+	}
+
+	// Case 2: request a token
+	{
+		token, err := uut.RequestToken(ctxt, url.Values{
+			"grant_type": []string{"client_credentials"}, "scope": []string{"openid"},
+		})
+		assert.Nil(err)
+		assert.Equal("good-token", token.AccessToken)
+		assert.Equal(int64(300), token.ExpiresIn)
+		assert.Equal("openid", token.Scope)
+		_, err = uut.RequestToken(ctxt, url.Values{"grant_type": []string{"implicit"}})
+		assert.NotNil(err)
+		assert.Contains(err.Error(), "unsupported_grant_type")
+	}
+
+	// Case 3: deferred client before discovery completes
+	{
+		deferred := DefineDeferredOpenIDClient()
+		_, err := deferred.IntrospectTokenDetails(ctxt, "good-token")
+		assert.NotNil(err)
+		deferred.SetClient(uut)
+		details, err := deferred.IntrospectTokenDetails(ctxt, "good-token")
+		assert.Nil(err)
+		assert.Equal(true, details["active"])
+	}
+}
+
+func TestOpenIDClientJWKSRefresh(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	jwk := func(kid string) OIDSigningJWK {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(err)
+		return OIDSigningJWK{
+			Algorithm: "RS256",
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			ID:        kid,
+			Type:      "RSA",
+			Use:       "sig",
+		}
+	}
+
+	jwksLock := sync.Mutex{}
+	jwks := []OIDSigningJWK{jwk("key-1")}
+	jwksFetches := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(OpenIDIssuerConfig{
+				Issuer: server.URL, JwksURI: server.URL + "/certs",
+			})
+		case "/certs":
+			jwksLock.Lock()
+			defer jwksLock.Unlock()
+			jwksFetches++
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": jwks})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	fetches := func() int {
+		jwksLock.Lock()
+		defer jwksLock.Unlock()
+		return jwksFetches
+	}
+	rotate := func(kid string) {
+		jwksLock.Lock()
+		defer jwksLock.Unlock()
+		jwks = append(jwks, jwk(kid))
+	}
+	tokenWithKID := func(kid string) *jwt.Token {
+		return &jwt.Token{Header: map[string]interface{}{"kid": kid}}
+	}
+
+	// Case 0: unknown "kid" triggers a JWKS refetch
+	{
+		noMinInterval := 0
+		uut, err := DefineOpenIDClient(
+			common.OpenIDIssuerConfig{Issuer: server.URL, JWKSRefreshMinInterval: &noMinInterval},
+			server.Client(),
+		)
+		assert.Nil(err)
+		assert.Equal(1, fetches())
+		_, err = uut.AssociatedPublicKey(tokenWithKID("key-1"))
+		assert.Nil(err)
+		assert.Equal(1, fetches())
+		rotate("key-2")
+		pubKey, err := uut.AssociatedPublicKey(tokenWithKID("key-2"))
+		assert.Nil(err)
+		assert.NotNil(pubKey)
+		assert.Equal(2, fetches())
+		_, err = uut.AssociatedPublicKey(tokenWithKID("key-2"))
+		assert.Nil(err)
+		assert.Equal(2, fetches())
+	}
+
+	// Case 1: JWKS refetches are rate limited
+	{
+		uut, err := DefineOpenIDClient(common.OpenIDIssuerConfig{Issuer: server.URL}, server.Client())
+		assert.Nil(err)
+		assert.Equal(3, fetches())
+		rotate("key-3")
+		_, err = uut.AssociatedPublicKey(tokenWithKID("key-3"))
+		assert.NotNil(err)
+		_, err = uut.AssociatedPublicKey(tokenWithKID("unknown"))
+		assert.NotNil(err)
+		assert.Equal(3, fetches())
+	}
+
+	// Case 2: JWKS refreshed in the background
+	{
+		refreshInterval := 1
+		uut, err := DefineOpenIDClient(
+			common.OpenIDIssuerConfig{Issuer: server.URL, JWKSRefreshInterval: &refreshInterval},
+			server.Client(),
+		)
+		assert.Nil(err)
+		rotate("key-4")
+		_, err = uut.AssociatedPublicKey(tokenWithKID("key-4"))
+		assert.NotNil(err)
+		assert.Eventually(func() bool {
+			_, err := uut.AssociatedPublicKey(tokenWithKID("key-4"))
+			return err == nil
+		}, time.Second*5, time.Millisecond*100)
+	}
+}
+
+func TestOpenIDClientProbeEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	signingKey := OIDSigningJWK{
+		Algorithm: "RS256",
+		Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		ID:        "key-1",
+		Type:      "RSA",
+		Use:       "sig",
+	}
+
+	publishKeys := true
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(OpenIDIssuerConfig{
+				Issuer:          server.URL,
+				JwksURI:         server.URL + "/certs",
+				IntrospectionEP: server.URL + "/introspect",
+			})
+		case "/certs":
+			keys := []OIDSigningJWK{}
+			if publishKeys {
+				keys = append(keys, signingKey)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+		case "/introspect":
+			clientID, clientSecret, ok := r.BasicAuth()
+			if !ok || clientID != "padlock" || clientSecret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctxt := context.Background()
+	clientID := "padlock"
+
+	// Case 0: valid client credentials
+	{
+		clientSecret := "secret"
+		uut, err := DefineOpenIDClient(
+			common.OpenIDIssuerConfig{
+				Issuer: server.URL, ClientID: &clientID, ClientCred: &clientSecret,
+			},
+			server.Client(),
+		)
+		assert.Nil(err)
+		assert.Nil(uut.ProbeEndpoints(ctxt))
+	}
+
+	// Case 1: client credentials rejected by the issuer
+	{
+		clientSecret := "wrong-secret"
+		uut, err := DefineOpenIDClient(
+			common.OpenIDIssuerConfig{
+				Issuer: server.URL, ClientID: &clientID, ClientCred: &clientSecret,
+			},
+			server.Client(),
+		)
+		assert.Nil(err)
+		assert.NotNil(uut.ProbeEndpoints(ctxt))
+	}
+
+	// Case 2: no client credentials, only the introspection endpoint is checked
+	{
+		uut, err := DefineOpenIDClient(
+			common.OpenIDIssuerConfig{Issuer: server.URL}, server.Client(),
+		)
+		assert.Nil(err)
+		assert.Nil(uut.ProbeEndpoints(ctxt))
+	}
+
+	// Case 3: no signing keys loaded
+	publishKeys = false
+	{
+		clientSecret := "secret"
+		uut, err := DefineOpenIDClient(
+			common.OpenIDIssuerConfig{
+				Issuer: server.URL, ClientID: &clientID, ClientCred: &clientSecret,
+			},
+			server.Client(),
+		)
+		assert.Nil(err)
+		assert.NotNil(uut.ProbeEndpoints(ctxt))
+	}
+}
+
+func TestOpenIDClientCredFile(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(OpenIDIssuerConfig{
+				Issuer:          server.URL,
+				JwksURI:         server.URL + "/certs",
+				IntrospectionEP: server.URL + "/introspect",
+			})
+		case "/certs":
+			_, _ = w.Write([]byte(`{"keys":[]}`))
+		case "/introspect":
+			_, clientSecret, _ := r.BasicAuth()
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"active": clientSecret == "secret-2"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	credFile := filepath.Join(t.TempDir(), "client-cred")
+	assert.Nil(os.WriteFile(credFile, []byte("secret-1\n"), 0600))
+
+	clientID := "padlock"
+	uut, err := DefineOpenIDClient(
+		common.OpenIDIssuerConfig{Issuer: server.URL, ClientID: &clientID, ClientCredFile: &credFile},
+		server.Client(),
+	)
+	assert.Nil(err)
+	assert.True(uut.CanIntrospect())
+
+	ctxt := context.Background()
+
+	// Case 0: the credential from the file is used
+	{
+		active, err := uut.IntrospectToken(ctxt, "token")
+		assert.Nil(err)
+		assert.False(active)
+	}
+
+	// Case 1: the rotated credential is used without redefining the client
+	{
+		assert.Nil(os.WriteFile(credFile, []byte("secret-2\n"), 0600))
+		assert.Eventually(func() bool {
+			active, err := uut.IntrospectToken(ctxt, "token")
+			return err == nil && active
+		}, time.Second*5, time.Millisecond*20)
+	}
+}
@@ -0,0 +1,137 @@
+package authenticate
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	saml2 "github.com/russellhaering/gosaml2"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// SAMLAssertion is the content of a validated SAML assertion
+type SAMLAssertion struct {
+	// NameID is the assertion subject's NameID
+	NameID string
+	// Attributes are the assertion attribute values, by attribute name
+	Attributes map[string][]string
+}
+
+// SAMLValidator validates SAML responses forwarded with a request to authenticate
+type SAMLValidator interface {
+	/*
+		ValidateResponse validate a SAML response. The response or its assertion must be signed
+		by the identity provider, the assertion must be restricted to the expected audience, and
+		the assertion conditions must hold at the current time.
+
+		 @param ctxt context.Context - the operating context
+		 @param encoded string - the base64 encoded SAML response
+		 @return the validated assertion
+	*/
+	ValidateResponse(ctxt context.Context, encoded string) (SAMLAssertion, error)
+}
+
+// samlValidatorImpl implements SAMLValidator
+type samlValidatorImpl struct {
+	goutils.Component
+	sp *saml2.SAMLServiceProvider
+}
+
+/*
+DefineSAMLValidator define a new SAML response validator
+
+	@param cfg common.SAMLConfig - the SAML validation config
+	@return new SAMLValidator instance
+*/
+func DefineSAMLValidator(cfg common.SAMLConfig) (SAMLValidator, error) {
+	certPEM, err := os.ReadFile(cfg.IDPCertificateFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", cfg.IDPCertificateFile, err)
+	}
+	certs := []*x509.Certificate{}
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse certificate in %s: %w", cfg.IDPCertificateFile, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", cfg.IDPCertificateFile)
+	}
+	return defineSAMLValidator(cfg, certs), nil
+}
+
+/*
+defineSAMLValidator define a new SAML response validator
+
+	@param cfg common.SAMLConfig - the SAML validation config
+	@param certs []*x509.Certificate - the identity provider's signing certificates
+	@return new samlValidatorImpl instance
+*/
+func defineSAMLValidator(cfg common.SAMLConfig, certs []*x509.Certificate) *samlValidatorImpl {
+	logTags := log.Fields{
+		"module": "authenticate", "component": "saml-validator", "issuer": cfg.IDPIssuer,
+	}
+	return &samlValidatorImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+			},
+		},
+		sp: &saml2.SAMLServiceProvider{
+			IdentityProviderIssuer:      cfg.IDPIssuer,
+			AssertionConsumerServiceURL: cfg.AssertionConsumerServiceURL,
+			AudienceURI:                 cfg.Audience,
+			IDPCertificateStore:         &dsig.MemoryX509CertificateStore{Roots: certs},
+			AllowMissingAttributes:      true,
+		},
+	}
+}
+
+/*
+ValidateResponse validate a SAML response. The response or its assertion must be signed
+by the identity provider, the assertion must be restricted to the expected audience, and
+the assertion conditions must hold at the current time.
+
+	@param ctxt context.Context - the operating context
+	@param encoded string - the base64 encoded SAML response
+	@return the validated assertion
+*/
+func (v *samlValidatorImpl) ValidateResponse(
+	ctxt context.Context, encoded string,
+) (SAMLAssertion, error) {
+	logTags := v.GetLogTagsForContext(ctxt)
+
+	info, err := v.sp.RetrieveAssertionInfo(encoded)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("SAML response failed validation")
+		return SAMLAssertion{}, err
+	}
+	if info.WarningInfo.InvalidTime {
+		err := fmt.Errorf("SAML assertion is outside of its validity period")
+		log.WithError(err).WithFields(logTags).Error("SAML response failed validation")
+		return SAMLAssertion{}, err
+	}
+	// An assertion without an audience restriction is not accepted either
+	if info.WarningInfo.NotInAudience || len(info.Assertions[0].Conditions.AudienceRestrictions) == 0 {
+		err := fmt.Errorf("SAML assertion is not restricted to audience '%s'", v.sp.AudienceURI)
+		log.WithError(err).WithFields(logTags).Error("SAML response failed validation")
+		return SAMLAssertion{}, err
+	}
+
+	assertion := SAMLAssertion{NameID: info.NameID, Attributes: map[string][]string{}}
+	for name := range info.Values {
+		assertion.Attributes[name] = info.Values.GetAll(name)
+	}
+	return assertion, nil
+}
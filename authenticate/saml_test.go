@@ -0,0 +1,179 @@
+package authenticate
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSAMLResponse parameters of a SAML response built for testing
+type testSAMLResponse struct {
+	issuer    string
+	recipient string
+	audience  string
+	nameID    string
+	notBefore time.Time
+	notAfter  time.Time
+	email     string
+}
+
+/*
+buildTestSAMLResponse build a SAML response with a signed assertion
+
+	@param signer dsig.X509KeyStore - the key to sign the assertion with
+	@param params testSAMLResponse - the response parameters
+	@return the base64 encoded response
+*/
+func buildTestSAMLResponse(signer dsig.X509KeyStore, params testSAMLResponse) (string, error) {
+	audience := ""
+	if params.audience != "" {
+		audience = fmt.Sprintf(
+			`<saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction>`,
+			params.audience,
+		)
+	}
+	raw := fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_response" Version="2.0" IssueInstant="%[4]s" Destination="%[2]s">
+<saml:Issuer>%[1]s</saml:Issuer>
+<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_assertion" Version="2.0" IssueInstant="%[4]s">
+<saml:Issuer>%[1]s</saml:Issuer>
+<saml:Subject>
+<saml:NameID>%[3]s</saml:NameID>
+<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"><saml:SubjectConfirmationData Recipient="%[2]s" NotOnOrAfter="%[5]s"/></saml:SubjectConfirmation>
+</saml:Subject>
+<saml:Conditions NotBefore="%[4]s" NotOnOrAfter="%[5]s">%[6]s</saml:Conditions>
+<saml:AttributeStatement><saml:Attribute Name="email"><saml:AttributeValue>%[7]s</saml:AttributeValue></saml:Attribute></saml:AttributeStatement>
+</saml:Assertion>
+</samlp:Response>`,
+		params.issuer,
+		params.recipient,
+		params.nameID,
+		params.notBefore.UTC().Format(time.RFC3339),
+		params.notAfter.UTC().Format(time.RFC3339),
+		audience,
+		params.email,
+	)
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(raw); err != nil {
+		return "", err
+	}
+	assertion := doc.FindElement("//Assertion")
+	signingCtxt := dsig.NewDefaultSigningContext(signer)
+	signingCtxt.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	signed, err := signingCtxt.SignEnveloped(assertion)
+	if err != nil {
+		return "", err
+	}
+	doc.Root().InsertChild(assertion, signed)
+	doc.Root().RemoveChild(assertion)
+	serialized, err := doc.WriteToBytes()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(serialized), nil
+}
+
+func TestSAMLValidator(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	utCtxt := context.Background()
+	idpKeys := dsig.RandomKeyStoreForTest()
+	_, idpCertDER, err := idpKeys.GetKeyPair()
+	assert.Nil(err)
+	idpCert, err := x509.ParseCertificate(idpCertDER)
+	assert.Nil(err)
+
+	cfg := common.SAMLConfig{
+		Enabled:                     true,
+		IDPIssuer:                   "https://idp.unit-test.org",
+		Audience:                    "https://padlock.unit-test.org",
+		AssertionConsumerServiceURL: "https://padlock.unit-test.org/v1/authenticate",
+	}
+	uut := defineSAMLValidator(cfg, []*x509.Certificate{idpCert})
+
+	validParams := func() testSAMLResponse {
+		return testSAMLResponse{
+			issuer:    cfg.IDPIssuer,
+			recipient: cfg.AssertionConsumerServiceURL,
+			audience:  cfg.Audience,
+			nameID:    "alice",
+			notBefore: time.Now().Add(-time.Minute),
+			notAfter:  time.Now().Add(time.Minute * 5),
+			email:     "alice@unit-test.org",
+		}
+	}
+
+	// Case 0: valid response
+	{
+		encoded, err := buildTestSAMLResponse(idpKeys, validParams())
+		assert.Nil(err)
+		assertion, err := uut.ValidateResponse(utCtxt, encoded)
+		assert.Nil(err)
+		assert.Equal("alice", assertion.NameID)
+		assert.Equal([]string{"alice@unit-test.org"}, assertion.Attributes["email"])
+	}
+
+	// Case 1: signed by another key
+	{
+		encoded, err := buildTestSAMLResponse(dsig.RandomKeyStoreForTest(), validParams())
+		assert.Nil(err)
+		_, err = uut.ValidateResponse(utCtxt, encoded)
+		assert.NotNil(err)
+	}
+
+	// Case 2: wrong audience
+	{
+		params := validParams()
+		params.audience = "https://other.unit-test.org"
+		encoded, err := buildTestSAMLResponse(idpKeys, params)
+		assert.Nil(err)
+		_, err = uut.ValidateResponse(utCtxt, encoded)
+		assert.NotNil(err)
+	}
+
+	// Case 3: no audience restriction
+	{
+		params := validParams()
+		params.audience = ""
+		encoded, err := buildTestSAMLResponse(idpKeys, params)
+		assert.Nil(err)
+		_, err = uut.ValidateResponse(utCtxt, encoded)
+		assert.NotNil(err)
+	}
+
+	// Case 4: not yet valid
+	{
+		params := validParams()
+		params.notBefore = time.Now().Add(time.Minute)
+		encoded, err := buildTestSAMLResponse(idpKeys, params)
+		assert.Nil(err)
+		_, err = uut.ValidateResponse(utCtxt, encoded)
+		assert.NotNil(err)
+	}
+
+	// Case 5: wrong issuer
+	{
+		params := validParams()
+		params.issuer = "https://other-idp.unit-test.org"
+		encoded, err := buildTestSAMLResponse(idpKeys, params)
+		assert.Nil(err)
+		_, err = uut.ValidateResponse(utCtxt, encoded)
+		assert.NotNil(err)
+	}
+
+	// Case 6: not a SAML response
+	{
+		_, err := uut.ValidateResponse(utCtxt, base64.StdEncoding.EncodeToString([]byte("hello")))
+		assert.NotNil(err)
+	}
+}
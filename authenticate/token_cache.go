@@ -1,6 +1,7 @@
 package authenticate
 
 import (
+	"container/list"
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
@@ -9,16 +10,51 @@ import (
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
 	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 )
 
 // cacheEntry JWT token entry
 type cacheEntry struct {
-	// When the token expires
+	// When the token expires. For an inactive token, when the entry expires.
 	expire int64
 	// When the token was cached
 	recorded time.Time
+	// Whether the token failed introspection
+	inactive bool
+	// The original token, so it can be re-introspected. Not known for imported entries.
+	token string
+}
+
+// CachedToken is a cached token due for re-validation
+type CachedToken struct {
+	// Token is the original token
+	Token string
+	// Expire is when the token expires
+	Expire int64
+}
+
+// lruEntry is a token cache entry in the least recently used order
+type lruEntry struct {
+	tokenHash string
+	entry     cacheEntry
+}
+
+// TokenCacheEntry is a token cache entry, as persisted to warm the cache on restart
+type TokenCacheEntry struct {
+	// TokenHash is the hash of the token
+	TokenHash string `json:"token_hash"`
+	// Expire is when the token expires. For an inactive token, when the entry expires.
+	Expire int64 `json:"expire"`
+	// Recorded is when the token was cached
+	Recorded time.Time `json:"recorded"`
+	// Inactive is whether the token failed introspection
+	Inactive bool `json:"inactive,omitempty"`
+	// Issuer is the OpenID issuer of the token, when the token cache is partitioned by issuer
+	Issuer string `json:"issuer,omitempty"`
 }
 
 // TokenCache cache for recording and fetching tokens encountered
@@ -34,6 +70,18 @@ type TokenCache interface {
 	*/
 	RecordToken(ctxt context.Context, token string, expire int64, timestamp time.Time) error
 
+	/*
+		RecordInactiveToken cache a token which failed introspection, so repeated requests
+		bearing the token are rejected without introspection for a short while. Nothing is
+		cached if negative caching is disabled.
+
+		@param ctxt context.Context - the operating context
+		@param token string - the original token
+		@param timestamp time.Time - the current timestamp
+		@return whether caching was successful
+	*/
+	RecordInactiveToken(ctxt context.Context, token string, timestamp time.Time) error
+
 	/*
 		RecordToken remote a token from cache
 
@@ -43,6 +91,16 @@ type TokenCache interface {
 	*/
 	RemoveToken(ctxt context.Context, token string) error
 
+	/*
+		RemoveTokenByHash remove a token from cache using its hash. An entry recording that the
+		token failed introspection is kept, as the token is no longer valid regardless.
+
+		@param ctxt context.Context - the operating context
+		@param tokenHash string - the token hash
+		@return whether delete was successful
+	*/
+	RemoveTokenByHash(ctxt context.Context, tokenHash string) error
+
 	/*
 		ValidTokenInCache check whether this token is already cached and valid.
 
@@ -56,6 +114,52 @@ type TokenCache interface {
 	*/
 	ValidTokenInCache(ctxt context.Context, token string, timestamp time.Time) (bool, error)
 
+	/*
+		InactiveTokenInCache check whether this token recently failed introspection
+
+		@param ctxt context.Context - the operating context
+		@param token string - the original token
+		@param timestamp time.Time - the current timestamp
+		@return whether it is present and inactive
+	*/
+	InactiveTokenInCache(ctxt context.Context, token string, timestamp time.Time) (bool, error)
+
+	/*
+		StaleTokenInCache check whether this token is cached and not yet expired, but requires
+		re-validation. Unlike ValidTokenInCache, the token is not removed from cache.
+
+		 @param ctxt context.Context - the operating context
+		 @param token string - the original token
+		 @param timestamp time.Time - the current timestamp
+		 @return whether it is present, not expired, and requires re-validation
+	*/
+	StaleTokenInCache(ctxt context.Context, token string, timestamp time.Time) (bool, error)
+
+	/*
+		TokensDueForRevalidation list the cached tokens which have not expired, and are due for
+		re-validation within the given duration. Only tokens cached in their original form are
+		listed.
+
+		 @param ctxt context.Context - the operating context
+		 @param within time.Duration - the duration
+		 @param timestamp time.Time - the current timestamp
+		 @return the cached tokens
+	*/
+	TokensDueForRevalidation(
+		ctxt context.Context, within time.Duration, timestamp time.Time,
+	) []CachedToken
+
+	/*
+		UnexpiredTokenInCache check whether this token is cached and not yet expired, regardless
+		of whether it requires re-validation.
+
+		 @param ctxt context.Context - the operating context
+		 @param token string - the original token
+		 @param timestamp time.Time - the current timestamp
+		 @return whether it is present and not expired
+	*/
+	UnexpiredTokenInCache(ctxt context.Context, token string, timestamp time.Time) (bool, error)
+
 	/*
 		RemoveExpiredFromCache remove all expired tokens from cache
 
@@ -71,23 +175,62 @@ type TokenCache interface {
 		@param ctxt context.Context - the operating context
 	*/
 	ClearCache(ctxt context.Context)
+
+	/*
+		ExportEntries list the unexpired cache entries, so they can be persisted
+
+		@param ctxt context.Context - the operating context
+		@param timestamp time.Time - the current timestamp
+		@return the cache entries
+	*/
+	ExportEntries(ctxt context.Context, timestamp time.Time) []TokenCacheEntry
+
+	/*
+		ImportEntries load previously exported cache entries. Expired entries are skipped, and
+		tokens already cached are kept as is.
+
+		@param ctxt context.Context - the operating context
+		@param entries []TokenCacheEntry - the cache entries
+		@param timestamp time.Time - the current timestamp
+		@return the number of entries loaded
+	*/
+	ImportEntries(ctxt context.Context, entries []TokenCacheEntry, timestamp time.Time) int
 }
 
 // tokenCacheImpl implements TokenCache
 type tokenCacheImpl struct {
 	goutils.Component
-	lock       sync.RWMutex
-	cache      map[string]cacheEntry
-	refreshInt time.Duration
+	lock  sync.RWMutex
+	cache map[string]*list.Element
+	// lru orders the entries from the most to the least recently used
+	lru         *list.List
+	refreshInt  time.Duration
+	inactiveTTL time.Duration
+	// maxEntries is the maximum number of entries, beyond which the least recently used entries
+	// are evicted. Zero means unbounded.
+	maxEntries int
+	// evictions if provided, the metric to count the evicted entries on
+	evictions *prometheus.CounterVec
 }
 
 /*
 DefineTokenCache defines a new token cache object
 
 	@param refreshInt time.Duration - a token must to be re-validated after this duration
+	@param inactiveTTL time.Duration - how long to remember a token failed introspection. Zero
+	disables negative caching.
+	@param maxEntries int - the maximum number of entries, beyond which the least recently used
+	entries are evicted. Zero means unbounded.
+	@param evictions *prometheus.CounterVec - if provided, the metric to count the evicted
+	entries on. It must support the label "kind".
 	@return new cache instance
 */
-func DefineTokenCache(refreshInt time.Duration) TokenCache {
+func DefineTokenCache(
+	refreshInt time.Duration,
+	inactiveTTL time.Duration,
+	maxEntries int,
+	evictions *prometheus.CounterVec,
+) TokenCache {
 	logTags := log.Fields{"module": "authenticate", "component": "token-cache"}
 	return &tokenCacheImpl{
 		Component: goutils.Component{
@@ -97,20 +240,100 @@ func DefineTokenCache(refreshInt time.Duration) TokenCache {
 				common.ModifyLogMetadataByAccessAuthorizeParam,
 			},
 		},
-		lock:       sync.RWMutex{},
-		cache:      make(map[string]cacheEntry),
-		refreshInt: refreshInt,
+		lock:        sync.RWMutex{},
+		cache:       make(map[string]*list.Element),
+		lru:         list.New(),
+		refreshInt:  refreshInt,
+		inactiveTTL: inactiveTTL,
+		maxEntries:  maxEntries,
+		evictions:   evictions,
+	}
+}
+
+/*
+getEntry fetch a cache entry, marking it as the most recently used. The lock must be held.
+
+	@param tokenHash string - the token hash
+	@return the cache entry, and whether it exists
+*/
+func (c *tokenCacheImpl) getEntry(tokenHash string) (cacheEntry, bool) {
+	element, ok := c.cache[tokenHash]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*lruEntry).entry, true
+}
+
+/*
+putEntry record a cache entry as the most recently used, evicting the least recently used
+entries beyond the maximum number of entries. The lock must be held.
+
+	@param ctxt context.Context - the operating context
+	@param tokenHash string - the token hash
+	@param entry cacheEntry - the cache entry
+*/
+func (c *tokenCacheImpl) putEntry(ctxt context.Context, tokenHash string, entry cacheEntry) {
+	if element, ok := c.cache[tokenHash]; ok {
+		element.Value.(*lruEntry).entry = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.cache[tokenHash] = c.lru.PushFront(&lruEntry{tokenHash: tokenHash, entry: entry})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		evicted := c.lru.Remove(c.lru.Back()).(*lruEntry)
+		delete(c.cache, evicted.tokenHash)
+		kind := "active"
+		if evicted.entry.inactive {
+			kind = "inactive"
+		}
+		if c.evictions != nil {
+			c.evictions.With(prometheus.Labels{"kind": kind}).Inc()
+		}
+		log.WithFields(c.GetLogTagsForContext(ctxt)).Debugf(
+			"Evicted least recently used %s token [%s] from cache", kind, evicted.tokenHash,
+		)
 	}
 }
 
-// getTokenHash compute SHA1 sum of a token
+/*
+deleteEntry remove a cache entry. The lock must be held.
+
+	@param tokenHash string - the token hash
+*/
+func (c *tokenCacheImpl) deleteEntry(tokenHash string) {
+	if element, ok := c.cache[tokenHash]; ok {
+		c.lru.Remove(element)
+		delete(c.cache, tokenHash)
+	}
+}
+
+/*
+getTokenHash compute the cache key of a token. A JWT carrying a "jti" claim is keyed by the
+SHA1 sum of its issuer and token ID, so the entry follows the token as identified by the
+issuer. Any other token is keyed by the SHA1 sum of the token itself.
+
+The token signature is not verified here; the caller must only cache tokens it verified.
+
+	@param token string - the original token
+	@return the cache key
+*/
 func getTokenHash(token string) (string, error) {
+	keyed := token
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err == nil {
+		tokenID, _ := claims["jti"].(string)
+		issuer, _ := claims["iss"].(string)
+		if tokenID != "" {
+			keyed = fmt.Sprintf("jti:%s:%s", issuer, tokenID)
+		}
+	}
 	hasher := sha1.New()
-	written, err := hasher.Write([]byte(token))
+	written, err := hasher.Write([]byte(keyed))
 	if err != nil {
 		return "", err
 	}
-	if written != len(token) {
+	if written != len(keyed) {
 		return "", fmt.Errorf("failed to hash token")
 	}
 	return base64.URLEncoding.EncodeToString(hasher.Sum(nil)), nil
@@ -122,7 +345,7 @@ func packageToken(token string, expire int64, timestamp time.Time) (string, cach
 	if err != nil {
 		return "", cacheEntry{}, err
 	}
-	return tokenHashSum, cacheEntry{expire: expire, recorded: timestamp}, nil
+	return tokenHashSum, cacheEntry{expire: expire, recorded: timestamp, token: token}, nil
 }
 
 /*
@@ -148,13 +371,50 @@ func (c *tokenCacheImpl) RecordToken(
 	// Record the token
 	{
 		c.lock.Lock()
-		c.cache[tokenHash] = entry
+		c.putEntry(ctxt, tokenHash, entry)
 		c.lock.Unlock()
 	}
 	log.WithFields(logtags).Debugf("Adding token [%s] to cache", tokenHash)
 	return nil
 }
 
+/*
+RecordInactiveToken cache a token which failed introspection, so repeated requests
+bearing the token are rejected without introspection for a short while. Nothing is
+cached if negative caching is disabled.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether caching was successful
+*/
+func (c *tokenCacheImpl) RecordInactiveToken(
+	ctxt context.Context, token string, timestamp time.Time,
+) error {
+	if c.inactiveTTL <= 0 {
+		return nil
+	}
+	logtags := c.GetLogTagsForContext(ctxt)
+
+	// Compute the token hash
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash for recording")
+		return err
+	}
+
+	// Record the token
+	{
+		c.lock.Lock()
+		c.putEntry(ctxt, tokenHash, cacheEntry{
+			expire: timestamp.Add(c.inactiveTTL).Unix(), recorded: timestamp, inactive: true,
+		})
+		c.lock.Unlock()
+	}
+	log.WithFields(logtags).Debugf("Adding inactive token [%s] to cache", tokenHash)
+	return nil
+}
+
 /*
 RecordToken remote a token from cache
 
@@ -175,7 +435,28 @@ func (c *tokenCacheImpl) RemoveToken(ctxt context.Context, token string) error {
 	// Remove the token
 	{
 		c.lock.Lock()
-		delete(c.cache, tokenHash)
+		c.deleteEntry(tokenHash)
+		c.lock.Unlock()
+	}
+	log.WithFields(logtags).Debugf("Deleting token [%s] from cache", tokenHash)
+	return nil
+}
+
+/*
+RemoveTokenByHash remove a token from cache using its hash. An entry recording that the
+token failed introspection is kept, as the token is no longer valid regardless.
+
+	@param ctxt context.Context - the operating context
+	@param tokenHash string - the token hash
+	@return whether delete was successful
+*/
+func (c *tokenCacheImpl) RemoveTokenByHash(ctxt context.Context, tokenHash string) error {
+	logtags := c.GetLogTagsForContext(ctxt)
+	{
+		c.lock.Lock()
+		if element, ok := c.cache[tokenHash]; ok && !element.Value.(*lruEntry).entry.inactive {
+			c.deleteEntry(tokenHash)
+		}
 		c.lock.Unlock()
 	}
 	log.WithFields(logtags).Debugf("Deleting token [%s] from cache", tokenHash)
@@ -208,20 +489,24 @@ func (c *tokenCacheImpl) ValidTokenInCache(
 	// Check whether the token exist
 	var existingEntry cacheEntry
 	{
-		c.lock.RLocker().Lock()
-		entry, ok := c.cache[tokenHash]
+		c.lock.Lock()
+		entry, ok := c.getEntry(tokenHash)
 		if !ok {
 			log.WithFields(logtags).Debugf("Token [%s] is unknown", tokenHash)
-			c.lock.RLocker().Unlock()
+			c.lock.Unlock()
 			return false, nil
 		}
 		existingEntry = entry
-		c.lock.RLocker().Unlock()
+		c.lock.Unlock()
+	}
+	if existingEntry.inactive {
+		log.WithFields(logtags).Debugf("Token [%s] is inactive", tokenHash)
+		return false, nil
 	}
 
 	removeToken := func() {
 		c.lock.Lock()
-		delete(c.cache, tokenHash)
+		c.deleteEntry(tokenHash)
 		c.lock.Unlock()
 	}
 
@@ -253,6 +538,119 @@ func (c *tokenCacheImpl) ValidTokenInCache(
 	return true, nil
 }
 
+/*
+InactiveTokenInCache check whether this token recently failed introspection
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present and inactive
+*/
+func (c *tokenCacheImpl) InactiveTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	// Compute the token hash
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash for verification")
+		return false, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.getEntry(tokenHash)
+	if !ok || !entry.inactive {
+		return false, nil
+	}
+	return timestamp.Unix() < entry.expire, nil
+}
+
+/*
+StaleTokenInCache check whether this token is cached and not yet expired, but requires
+re-validation. Unlike ValidTokenInCache, the token is not removed from cache.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present, not expired, and requires re-validation
+*/
+func (c *tokenCacheImpl) StaleTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	// Compute the token hash
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash for verification")
+		return false, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.getEntry(tokenHash)
+	if !ok || entry.inactive || timestamp.Unix() > entry.expire {
+		return false, nil
+	}
+	return timestamp.After(entry.recorded) && timestamp.Sub(entry.recorded) >= c.refreshInt, nil
+}
+
+/*
+TokensDueForRevalidation list the cached tokens which have not expired, and are due for
+re-validation within the given duration. Only tokens cached in their original form are listed.
+
+	@param ctxt context.Context - the operating context
+	@param within time.Duration - the duration
+	@param timestamp time.Time - the current timestamp
+	@return the cached tokens
+*/
+func (c *tokenCacheImpl) TokensDueForRevalidation(
+	ctxt context.Context, within time.Duration, timestamp time.Time,
+) []CachedToken {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	due := []CachedToken{}
+	for _, element := range c.cache {
+		entry := element.Value.(*lruEntry).entry
+		if entry.inactive || entry.token == "" || timestamp.Unix() >= entry.expire {
+			continue
+		}
+		dueTime := entry.recorded.Add(c.refreshInt)
+		// No need to re-validate tokens expiring before they are due
+		if dueTime.Unix() > entry.expire || dueTime.After(timestamp.Add(within)) {
+			continue
+		}
+		due = append(due, CachedToken{Token: entry.token, Expire: entry.expire})
+	}
+	return due
+}
+
+/*
+UnexpiredTokenInCache check whether this token is cached and not yet expired, regardless
+of whether it requires re-validation.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present and not expired
+*/
+func (c *tokenCacheImpl) UnexpiredTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	// Compute the token hash
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash for verification")
+		return false, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.getEntry(tokenHash)
+	if !ok || entry.inactive {
+		return false, nil
+	}
+	return timestamp.Unix() <= entry.expire, nil
+}
+
 /*
 RemoveExpiredFromCache remove all expired tokens from cache
 
@@ -265,13 +663,13 @@ func (c *tokenCacheImpl) RemoveExpiredFromCache(ctxt context.Context, timestamp
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	toRemove := []string{}
-	for id, entry := range c.cache {
-		if timestamp.Unix() >= entry.expire {
+	for id, element := range c.cache {
+		if timestamp.Unix() >= element.Value.(*lruEntry).entry.expire {
 			toRemove = append(toRemove, id)
 		}
 	}
 	for _, id := range toRemove {
-		delete(c.cache, id)
+		c.deleteEntry(id)
 		log.WithFields(logtags).Debugf("Token [%s] has expired. Removing from cache...", id)
 	}
 	return nil
@@ -285,5 +683,84 @@ ClearCache remove all entries from cache
 func (c *tokenCacheImpl) ClearCache(ctxt context.Context) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.cache = make(map[string]cacheEntry)
+	c.cache = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+/*
+ExportEntries list the unexpired cache entries, so they can be persisted
+
+	@param ctxt context.Context - the operating context
+	@param timestamp time.Time - the current timestamp
+	@return the cache entries
+*/
+func (c *tokenCacheImpl) ExportEntries(
+	ctxt context.Context, timestamp time.Time,
+) []TokenCacheEntry {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entries := []TokenCacheEntry{}
+	// Least recently used first, so importing the entries preserves the order
+	for element := c.lru.Back(); element != nil; element = element.Prev() {
+		tokenHash, entry := element.Value.(*lruEntry).tokenHash, element.Value.(*lruEntry).entry
+		if timestamp.Unix() >= entry.expire {
+			continue
+		}
+		entries = append(entries, TokenCacheEntry{
+			TokenHash: tokenHash,
+			Expire:    entry.expire,
+			Recorded:  entry.recorded,
+			Inactive:  entry.inactive,
+		})
+	}
+	return entries
+}
+
+/*
+ImportEntries load previously exported cache entries. Expired entries are skipped, and
+tokens already cached are kept as is.
+
+	@param ctxt context.Context - the operating context
+	@param entries []TokenCacheEntry - the cache entries
+	@param timestamp time.Time - the current timestamp
+	@return the number of entries loaded
+*/
+func (c *tokenCacheImpl) ImportEntries(
+	ctxt context.Context, entries []TokenCacheEntry, timestamp time.Time,
+) int {
+	logtags := c.GetLogTagsForContext(ctxt)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	loaded := 0
+	for _, entry := range entries {
+		if timestamp.Unix() >= entry.Expire {
+			continue
+		}
+		if _, ok := c.cache[entry.TokenHash]; ok {
+			continue
+		}
+		c.putEntry(ctxt, entry.TokenHash, cacheEntry{
+			expire: entry.Expire, recorded: entry.Recorded, inactive: entry.Inactive,
+		})
+		loaded++
+	}
+	log.WithFields(logtags).Debugf("Loaded %d of %d persisted tokens", loaded, len(entries))
+	return loaded
+}
+
+/*
+SubscribeTokenCacheToInvalidation remove entries from a token cache when other replicas
+report that a token is no longer valid
+
+	@param cache TokenCache - the token cache
+	@param bus invalidation.Bus - the invalidation notice bus
+*/
+func SubscribeTokenCacheToInvalidation(cache TokenCache, bus invalidation.Bus) {
+	bus.Subscribe(invalidation.TargetToken, func(ctxt context.Context, msg invalidation.Message) error {
+		if msg.Target == invalidation.TargetAll {
+			cache.ClearCache(ctxt)
+			return nil
+		}
+		return cache.RemoveTokenByHash(ctxt, msg.Key)
+	})
 }
@@ -0,0 +1,537 @@
+package authenticate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/bradfitz/gomemcache/memcache"
+	"golang.org/x/net/context"
+)
+
+// memcachedClient is the subset of the Memcached client operations the token cache uses
+type memcachedClient interface {
+	Get(key string) (*memcache.Item, error)
+	Set(item *memcache.Item) error
+	Add(item *memcache.Item) error
+	Delete(key string) error
+	Increment(key string, delta uint64) (uint64, error)
+}
+
+// memcachedMaxRelativeExpire is the longest expiration Memcached accepts as relative to now.
+// Longer expirations are given as UNIX timestamps.
+const memcachedMaxRelativeExpire = 60 * 60 * 24 * 30
+
+// memcachedTokenCacheImpl implements TokenCache with Memcached
+type memcachedTokenCacheImpl struct {
+	goutils.Component
+	client      memcachedClient
+	keyPrefix   string
+	refreshInt  time.Duration
+	inactiveTTL time.Duration
+}
+
+/*
+DefineMemcachedTokenCache defines a new token cache object backed by Memcached, which is shared
+by all the replicas using the same Memcached servers and key prefix.
+
+Memcached expires the entries on its own, and its keys can not be listed. So the entries are
+neither exported for persistence nor removed by RemoveExpiredFromCache, and ClearCache moves the
+cache to a new key namespace instead.
+
+	@param client *memcache.Client - the Memcached client
+	@param keyPrefix string - the prefix of the Memcached keys used by the token cache
+	@param refreshInt time.Duration - a token must to be re-validated after this duration
+	@param inactiveTTL time.Duration - how long to remember a token failed introspection. Zero
+	disables negative caching.
+	@return new cache instance
+*/
+func DefineMemcachedTokenCache(
+	client *memcache.Client, keyPrefix string, refreshInt time.Duration, inactiveTTL time.Duration,
+) TokenCache {
+	return defineMemcachedTokenCache(client, keyPrefix, refreshInt, inactiveTTL)
+}
+
+// defineMemcachedTokenCache defines a new token cache object backed by a Memcached client
+func defineMemcachedTokenCache(
+	client memcachedClient, keyPrefix string, refreshInt time.Duration, inactiveTTL time.Duration,
+) *memcachedTokenCacheImpl {
+	logTags := log.Fields{"module": "authenticate", "component": "memcached-token-cache"}
+	return &memcachedTokenCacheImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		client:      client,
+		keyPrefix:   keyPrefix,
+		refreshInt:  refreshInt,
+		inactiveTTL: inactiveTTL,
+	}
+}
+
+// namespaceKey the Memcached key holding the current key namespace
+func (c *memcachedTokenCacheImpl) namespaceKey() string {
+	return c.keyPrefix + "namespace"
+}
+
+/*
+getNamespace fetch the current key namespace, defining it if it does not exist. A new namespace
+starts at the current UNIX time, so entries from before an evicted namespace are not reused.
+
+	@return the current key namespace
+*/
+func (c *memcachedTokenCacheImpl) getNamespace() (string, error) {
+	item, err := c.client.Get(c.namespaceKey())
+	if err == nil {
+		return string(item.Value), nil
+	}
+	if !errors.Is(err, memcache.ErrCacheMiss) {
+		return "", err
+	}
+	namespace := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.client.Add(&memcache.Item{Key: c.namespaceKey(), Value: []byte(namespace)})
+	if errors.Is(err, memcache.ErrNotStored) {
+		// Another replica defined the namespace first
+		item, err := c.client.Get(c.namespaceKey())
+		if err != nil {
+			return "", err
+		}
+		return string(item.Value), nil
+	}
+	return namespace, err
+}
+
+/*
+entryKey compute the Memcached key of a token cache entry
+
+	@param tokenHash string - the token hash
+	@return the Memcached key
+*/
+func (c *memcachedTokenCacheImpl) entryKey(tokenHash string) (string, error) {
+	namespace, err := c.getNamespace()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s:%s", c.keyPrefix, namespace, tokenHash), nil
+}
+
+/*
+writeEntry write a token cache entry, which Memcached expires with the entry
+
+	@param key string - the Memcached key
+	@param entry TokenCacheEntry - the cache entry
+	@param timestamp time.Time - the current timestamp
+	@param overwrite bool - whether to replace an existing entry
+	@return whether an entry was written
+*/
+func (c *memcachedTokenCacheImpl) writeEntry(
+	key string, entry TokenCacheEntry, timestamp time.Time, overwrite bool,
+) (bool, error) {
+	ttl := entry.Expire - timestamp.Unix()
+	if ttl <= 0 {
+		return false, nil
+	}
+	expiration := int32(ttl)
+	if ttl > memcachedMaxRelativeExpire {
+		expiration = int32(entry.Expire)
+	}
+	value, err := json.Marshal(&entry)
+	if err != nil {
+		return false, err
+	}
+	item := &memcache.Item{Key: key, Value: value, Expiration: expiration}
+	if overwrite {
+		return true, c.client.Set(item)
+	}
+	err = c.client.Add(item)
+	if errors.Is(err, memcache.ErrNotStored) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/*
+readEntry read a token cache entry
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@return the Memcached key, the cache entry, and whether the entry exists
+*/
+func (c *memcachedTokenCacheImpl) readEntry(
+	ctxt context.Context, token string,
+) (string, TokenCacheEntry, bool, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash for verification")
+		return "", TokenCacheEntry{}, false, err
+	}
+	key, err := c.entryKey(tokenHash)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to read Memcached key namespace")
+		return "", TokenCacheEntry{}, false, err
+	}
+	item, err := c.client.Get(key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return key, TokenCacheEntry{}, false, nil
+	}
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Failed to read token [%s]", tokenHash)
+		return "", TokenCacheEntry{}, false, err
+	}
+	var entry TokenCacheEntry
+	if err := json.Unmarshal(item.Value, &entry); err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Failed to parse token [%s] entry", tokenHash)
+		return "", TokenCacheEntry{}, false, err
+	}
+	return key, entry, true, nil
+}
+
+/*
+deleteEntry delete a token cache entry, which may not exist
+
+	@param key string - the Memcached key
+	@return whether successful
+*/
+func (c *memcachedTokenCacheImpl) deleteEntry(key string) error {
+	if err := c.client.Delete(key); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return err
+	}
+	return nil
+}
+
+/*
+RecordToken cache a new token
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param expire int64 - when the token expires
+	@param timestamp time.Time - the current timestamp
+	@return whether caching was successful
+*/
+func (c *memcachedTokenCacheImpl) RecordToken(
+	ctxt context.Context, token string, expire int64, timestamp time.Time,
+) error {
+	logtags := c.GetLogTagsForContext(ctxt)
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash for recording")
+		return err
+	}
+	key, err := c.entryKey(tokenHash)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to read Memcached key namespace")
+		return err
+	}
+	if _, err := c.writeEntry(key, TokenCacheEntry{
+		TokenHash: tokenHash, Expire: expire, Recorded: timestamp,
+	}, timestamp, true); err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Failed to record token [%s]", tokenHash)
+		return err
+	}
+	log.WithFields(logtags).Debugf("Adding token [%s] to cache", tokenHash)
+	return nil
+}
+
+/*
+RecordInactiveToken cache a token which failed introspection, so repeated requests
+bearing the token are rejected without introspection for a short while. Nothing is
+cached if negative caching is disabled.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether caching was successful
+*/
+func (c *memcachedTokenCacheImpl) RecordInactiveToken(
+	ctxt context.Context, token string, timestamp time.Time,
+) error {
+	if c.inactiveTTL <= 0 {
+		return nil
+	}
+	logtags := c.GetLogTagsForContext(ctxt)
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash for recording")
+		return err
+	}
+	key, err := c.entryKey(tokenHash)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to read Memcached key namespace")
+		return err
+	}
+	if _, err := c.writeEntry(key, TokenCacheEntry{
+		TokenHash: tokenHash,
+		Expire:    timestamp.Add(c.inactiveTTL).Unix(),
+		Recorded:  timestamp,
+		Inactive:  true,
+	}, timestamp, true); err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Failed to record token [%s]", tokenHash)
+		return err
+	}
+	log.WithFields(logtags).Debugf("Adding inactive token [%s] to cache", tokenHash)
+	return nil
+}
+
+/*
+RecordToken remote a token from cache
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@return whether delete was successful
+*/
+func (c *memcachedTokenCacheImpl) RemoveToken(ctxt context.Context, token string) error {
+	logtags := c.GetLogTagsForContext(ctxt)
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to compute token hash for deletion")
+		return err
+	}
+	key, err := c.entryKey(tokenHash)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to read Memcached key namespace")
+		return err
+	}
+	if err := c.deleteEntry(key); err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Failed to delete token [%s]", tokenHash)
+		return err
+	}
+	log.WithFields(logtags).Debugf("Deleting token [%s] from cache", tokenHash)
+	return nil
+}
+
+/*
+RemoveTokenByHash remove a token from cache using its hash. An entry recording that the
+token failed introspection is kept, as the token is no longer valid regardless.
+
+	@param ctxt context.Context - the operating context
+	@param tokenHash string - the token hash
+	@return whether delete was successful
+*/
+func (c *memcachedTokenCacheImpl) RemoveTokenByHash(ctxt context.Context, tokenHash string) error {
+	logtags := c.GetLogTagsForContext(ctxt)
+	key, err := c.entryKey(tokenHash)
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to read Memcached key namespace")
+		return err
+	}
+	item, err := c.client.Get(key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Failed to read token [%s]", tokenHash)
+		return err
+	}
+	var entry TokenCacheEntry
+	if err := json.Unmarshal(item.Value, &entry); err == nil && entry.Inactive {
+		return nil
+	}
+	if err := c.deleteEntry(key); err != nil {
+		log.WithError(err).WithFields(logtags).Errorf("Failed to delete token [%s]", tokenHash)
+		return err
+	}
+	log.WithFields(logtags).Debugf("Deleting token [%s] from cache", tokenHash)
+	return nil
+}
+
+/*
+ValidTokenInCache check whether this token is already cached and valid
+
+If the token is present, but requires re-validation, this function will remove the
+token from cache and indicate no valid token is cached.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present and valid
+*/
+func (c *memcachedTokenCacheImpl) ValidTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	key, entry, ok, err := c.readEntry(ctxt, token)
+	if err != nil || !ok || entry.Inactive {
+		return false, err
+	}
+
+	// Check whether the token has expired, or the cache entry need to be refreshed
+	if timestamp.Unix() > entry.Expire ||
+		(timestamp.After(entry.Recorded) && timestamp.Sub(entry.Recorded) >= c.refreshInt) {
+		log.WithFields(logtags).Debugf(
+			"Token [%s] has expired or needs to be re-validated. Removing from cache...",
+			entry.TokenHash,
+		)
+		if err := c.deleteEntry(key); err != nil {
+			log.WithError(err).WithFields(logtags).
+				Errorf("Failed to delete token [%s]", entry.TokenHash)
+			return false, err
+		}
+		return false, nil
+	}
+
+	log.WithFields(logtags).Debugf("Token [%s] still valid", entry.TokenHash)
+	return true, nil
+}
+
+/*
+InactiveTokenInCache check whether this token recently failed introspection
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present and inactive
+*/
+func (c *memcachedTokenCacheImpl) InactiveTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	_, entry, ok, err := c.readEntry(ctxt, token)
+	if err != nil || !ok || !entry.Inactive {
+		return false, err
+	}
+	return timestamp.Unix() < entry.Expire, nil
+}
+
+/*
+StaleTokenInCache check whether this token is cached and not yet expired, but requires
+re-validation. Unlike ValidTokenInCache, the token is not removed from cache.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present, not expired, and requires re-validation
+*/
+func (c *memcachedTokenCacheImpl) StaleTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	_, entry, ok, err := c.readEntry(ctxt, token)
+	if err != nil || !ok || entry.Inactive || timestamp.Unix() > entry.Expire {
+		return false, err
+	}
+	return timestamp.After(entry.Recorded) && timestamp.Sub(entry.Recorded) >= c.refreshInt, nil
+}
+
+/*
+TokensDueForRevalidation list the cached tokens which have not expired, and are due for
+re-validation within the given duration. The original tokens are not stored in Memcached, so
+nothing is listed.
+
+	@param ctxt context.Context - the operating context
+	@param within time.Duration - the duration
+	@param timestamp time.Time - the current timestamp
+	@return the cached tokens
+*/
+func (c *memcachedTokenCacheImpl) TokensDueForRevalidation(
+	ctxt context.Context, within time.Duration, timestamp time.Time,
+) []CachedToken {
+	return []CachedToken{}
+}
+
+/*
+UnexpiredTokenInCache check whether this token is cached and not yet expired, regardless
+of whether it requires re-validation.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present and not expired
+*/
+func (c *memcachedTokenCacheImpl) UnexpiredTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	_, entry, ok, err := c.readEntry(ctxt, token)
+	if err != nil || !ok || entry.Inactive {
+		return false, err
+	}
+	return timestamp.Unix() <= entry.Expire, nil
+}
+
+/*
+RemoveExpiredFromCache remove all expired tokens from cache. Memcached expires the entries on
+its own, so this does nothing.
+
+	@param ctxt context.Context - the operating context
+	@param timestamp time.Time - the current timestamp
+	@return whether successful
+*/
+func (c *memcachedTokenCacheImpl) RemoveExpiredFromCache(
+	ctxt context.Context, timestamp time.Time,
+) error {
+	return nil
+}
+
+/*
+ClearCache remove all entries from cache, by moving to a new key namespace. The entries of the
+previous namespace are left for Memcached to expire.
+
+@param ctxt context.Context - the operating context
+*/
+func (c *memcachedTokenCacheImpl) ClearCache(ctxt context.Context) {
+	logtags := c.GetLogTagsForContext(ctxt)
+	_, err := c.client.Increment(c.namespaceKey(), 1)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		// Without a namespace, there is nothing to clear
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithFields(logtags).Error("Failed to clear token cache")
+		return
+	}
+	log.WithFields(logtags).Info("Cleared token cache")
+}
+
+/*
+ExportEntries list the unexpired cache entries, so they can be persisted. Memcached keys can
+not be listed, so nothing is returned.
+
+	@param ctxt context.Context - the operating context
+	@param timestamp time.Time - the current timestamp
+	@return the cache entries
+*/
+func (c *memcachedTokenCacheImpl) ExportEntries(
+	ctxt context.Context, timestamp time.Time,
+) []TokenCacheEntry {
+	return []TokenCacheEntry{}
+}
+
+/*
+ImportEntries load previously exported cache entries. Expired entries are skipped, and
+tokens already cached are kept as is.
+
+	@param ctxt context.Context - the operating context
+	@param entries []TokenCacheEntry - the cache entries
+	@param timestamp time.Time - the current timestamp
+	@return the number of entries loaded
+*/
+func (c *memcachedTokenCacheImpl) ImportEntries(
+	ctxt context.Context, entries []TokenCacheEntry, timestamp time.Time,
+) int {
+	logtags := c.GetLogTagsForContext(ctxt)
+	loaded := 0
+	for _, entry := range entries {
+		key, err := c.entryKey(entry.TokenHash)
+		if err != nil {
+			log.WithError(err).WithFields(logtags).Error("Failed to read Memcached key namespace")
+			break
+		}
+		written, err := c.writeEntry(key, entry, timestamp, false)
+		if err != nil {
+			log.WithError(err).WithFields(logtags).
+				Errorf("Failed to load token [%s]", entry.TokenHash)
+			continue
+		}
+		if written {
+			loaded++
+		}
+	}
+	log.WithFields(logtags).Debugf("Loaded %d of %d persisted tokens", loaded, len(entries))
+	return loaded
+}
@@ -0,0 +1,194 @@
+package authenticate
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMemcached is an in-memory stand-in for a Memcached server, which does not expire items
+type fakeMemcached struct {
+	lock  sync.Mutex
+	items map[string]memcache.Item
+}
+
+func (f *fakeMemcached) Get(key string) (*memcache.Item, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	item, ok := f.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	return &item, nil
+}
+
+func (f *fakeMemcached) Set(item *memcache.Item) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.items[item.Key] = *item
+	return nil
+}
+
+func (f *fakeMemcached) Add(item *memcache.Item) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.items[item.Key]; ok {
+		return memcache.ErrNotStored
+	}
+	f.items[item.Key] = *item
+	return nil
+}
+
+func (f *fakeMemcached) Delete(key string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.items[key]; !ok {
+		return memcache.ErrCacheMiss
+	}
+	delete(f.items, key)
+	return nil
+}
+
+func (f *fakeMemcached) Increment(key string, delta uint64) (uint64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	item, ok := f.items[key]
+	if !ok {
+		return 0, memcache.ErrCacheMiss
+	}
+	value, err := strconv.ParseUint(string(item.Value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	value += delta
+	item.Value = []byte(strconv.FormatUint(value, 10))
+	f.items[key] = item
+	return value, nil
+}
+
+func TestMemcachedTokenCache(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	server := &fakeMemcached{items: map[string]memcache.Item{}}
+	uut := defineMemcachedTokenCache(server, "padlock:token:", time.Minute*5, time.Second*10)
+	// Another replica sharing the same Memcached servers
+	replica := defineMemcachedTokenCache(server, "padlock:token:", time.Minute*5, time.Second*10)
+
+	startTime := time.Now().UTC()
+
+	ctxt := context.Background()
+
+	// Case 0: empty cache
+	{
+		valid, err := uut.ValidTokenInCache(ctxt, uuid.New().String(), startTime)
+		assert.Nil(err)
+		assert.False(valid)
+	}
+
+	// Case 1: record a token, which is visible to the other replica
+	token1 := uuid.New().String()
+	currentTime := startTime
+	assert.Nil(uut.RecordToken(ctxt, token1, currentTime.Add(time.Minute*6).Unix(), currentTime))
+	for _, cache := range []TokenCache{uut, replica} {
+		valid, err := cache.ValidTokenInCache(ctxt, token1, currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+	}
+	{
+		tokenHash, err := getTokenHash(token1)
+		assert.Nil(err)
+		key, err := uut.entryKey(tokenHash)
+		assert.Nil(err)
+		item, err := server.Get(key)
+		assert.Nil(err)
+		assert.Equal(int32(360), item.Expiration)
+	}
+
+	// Case 2: move time forward, token 1 need to be refreshed
+	currentTime = currentTime.Add(time.Second * 330)
+	{
+		stale, err := uut.StaleTokenInCache(ctxt, token1, currentTime)
+		assert.Nil(err)
+		assert.True(stale)
+		valid, err := uut.ValidTokenInCache(ctxt, token1, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+		unexpired, err := replica.UnexpiredTokenInCache(ctxt, token1, currentTime)
+		assert.Nil(err)
+		assert.False(unexpired)
+	}
+
+	// Case 3: record an inactive token
+	token2 := uuid.New().String()
+	assert.Nil(uut.RecordInactiveToken(ctxt, token2, currentTime))
+	{
+		inactive, err := replica.InactiveTokenInCache(ctxt, token2, currentTime)
+		assert.Nil(err)
+		assert.True(inactive)
+		valid, err := replica.ValidTokenInCache(ctxt, token2, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+	}
+	// Invalidation notices keep the inactive entry
+	{
+		tokenHash, err := getTokenHash(token2)
+		assert.Nil(err)
+		assert.Nil(replica.RemoveTokenByHash(ctxt, tokenHash))
+		inactive, err := uut.InactiveTokenInCache(ctxt, token2, currentTime)
+		assert.Nil(err)
+		assert.True(inactive)
+	}
+
+	// Case 4: remove a token
+	token3 := uuid.New().String()
+	assert.Nil(uut.RecordToken(ctxt, token3, currentTime.Add(time.Minute).Unix(), currentTime))
+	assert.Nil(replica.RemoveToken(ctxt, token3))
+	{
+		valid, err := uut.ValidTokenInCache(ctxt, token3, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+	}
+	// Removing an unknown token is not an error
+	assert.Nil(uut.RemoveToken(ctxt, uuid.New().String()))
+
+	// Case 5: import entries, keeping those already cached
+	token4 := uuid.New().String()
+	token4Hash, err := getTokenHash(token4)
+	assert.Nil(err)
+	token2Hash, err := getTokenHash(token2)
+	assert.Nil(err)
+	loaded := uut.ImportEntries(ctxt, []TokenCacheEntry{
+		{TokenHash: token4Hash, Expire: currentTime.Add(time.Minute).Unix(), Recorded: currentTime},
+		{TokenHash: token2Hash, Expire: currentTime.Add(time.Minute).Unix(), Recorded: currentTime},
+		{TokenHash: "expired", Expire: currentTime.Add(-time.Minute).Unix(), Recorded: currentTime},
+	}, currentTime)
+	assert.Equal(1, loaded)
+	{
+		valid, err := replica.ValidTokenInCache(ctxt, token4, currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		inactive, err := replica.InactiveTokenInCache(ctxt, token2, currentTime)
+		assert.Nil(err)
+		assert.True(inactive)
+	}
+	assert.Empty(uut.ExportEntries(ctxt, currentTime))
+
+	// Case 6: clear the cache for every replica
+	replica.ClearCache(ctxt)
+	{
+		valid, err := uut.ValidTokenInCache(ctxt, token4, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+		inactive, err := uut.InactiveTokenInCache(ctxt, token2, currentTime)
+		assert.Nil(err)
+		assert.False(inactive)
+	}
+}
@@ -0,0 +1,310 @@
+package authenticate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+)
+
+// Token cache lookup results, as recorded by the lookup metric
+const (
+	tokenCacheLookupHit      = "hit"
+	tokenCacheLookupMiss     = "miss"
+	tokenCacheLookupInactive = "inactive"
+)
+
+// partitionedTokenCacheImpl implements TokenCache over one token cache per OpenID issuer
+type partitionedTokenCacheImpl struct {
+	// primary is the issuer whose partition holds the tokens not naming a known issuer
+	primary string
+	// partitions are the token caches of each issuer, keyed by the canonical issuer URL
+	partitions map[string]TokenCache
+	// lookups if provided, the metric to count the token cache lookups on
+	lookups *prometheus.CounterVec
+}
+
+/*
+DefinePartitionedTokenCache defines a token cache which keeps the tokens of each OpenID issuer
+in a separate partition, so each issuer can have its own caching settings. Each token is
+cached in the partition of the issuer named by its "iss" claim; tokens which are not JWTs, or
+name no known issuer, are cached in the primary issuer's partition.
+
+	@param primary string - the issuer whose partition holds the tokens not naming a known issuer
+	@param partitions map[string]TokenCache - the token caches of each issuer, keyed by the
+	issuer URL
+	@param lookups *prometheus.CounterVec - if provided, the metric to count the token cache
+	lookups on. It must support the labels "issuer" and "result".
+	@return new cache instance
+*/
+func DefinePartitionedTokenCache(
+	primary string, partitions map[string]TokenCache, lookups *prometheus.CounterVec,
+) (TokenCache, error) {
+	caches := map[string]TokenCache{}
+	for issuer, cache := range partitions {
+		caches[CanonicalIssuer(issuer)] = cache
+	}
+	if _, ok := caches[CanonicalIssuer(primary)]; !ok {
+		return nil, fmt.Errorf("primary OpenID issuer %s has no token cache partition", primary)
+	}
+	return &partitionedTokenCacheImpl{
+		primary: CanonicalIssuer(primary), partitions: caches, lookups: lookups,
+	}, nil
+}
+
+// partitionFor helper function to select the partition of a token
+func (c *partitionedTokenCacheImpl) partitionFor(token string) (string, TokenCache) {
+	if issuer, err := TokenIssuer(token); err == nil {
+		if cache, ok := c.partitions[CanonicalIssuer(issuer)]; ok {
+			return CanonicalIssuer(issuer), cache
+		}
+	}
+	return c.primary, c.partitions[c.primary]
+}
+
+// recordLookup helper function to count a token cache lookup
+func (c *partitionedTokenCacheImpl) recordLookup(issuer string, result string) {
+	if c.lookups != nil {
+		c.lookups.With(prometheus.Labels{"issuer": issuer, "result": result}).Inc()
+	}
+}
+
+/*
+RecordToken cache a new token
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param expire int64 - when the token expires
+	@param timestamp time.Time - the current timestamp
+	@return whether caching was successful
+*/
+func (c *partitionedTokenCacheImpl) RecordToken(
+	ctxt context.Context, token string, expire int64, timestamp time.Time,
+) error {
+	_, cache := c.partitionFor(token)
+	return cache.RecordToken(ctxt, token, expire, timestamp)
+}
+
+/*
+RecordInactiveToken cache a token which failed introspection, so repeated requests
+bearing the token are rejected without introspection for a short while. Nothing is
+cached if negative caching is disabled for the token's issuer.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether caching was successful
+*/
+func (c *partitionedTokenCacheImpl) RecordInactiveToken(
+	ctxt context.Context, token string, timestamp time.Time,
+) error {
+	_, cache := c.partitionFor(token)
+	return cache.RecordInactiveToken(ctxt, token, timestamp)
+}
+
+/*
+RemoveToken remove a token from cache
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@return whether delete was successful
+*/
+func (c *partitionedTokenCacheImpl) RemoveToken(ctxt context.Context, token string) error {
+	_, cache := c.partitionFor(token)
+	return cache.RemoveToken(ctxt, token)
+}
+
+/*
+RemoveTokenByHash remove a token from cache using its hash. The issuer of the token is not
+known from its hash, so the token is removed from every partition.
+
+	@param ctxt context.Context - the operating context
+	@param tokenHash string - the token hash
+	@return whether delete was successful
+*/
+func (c *partitionedTokenCacheImpl) RemoveTokenByHash(ctxt context.Context, tokenHash string) error {
+	for _, cache := range c.partitions {
+		if err := cache.RemoveTokenByHash(ctxt, tokenHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ValidTokenInCache check whether this token is already cached and valid.
+
+If the token is present, but requires re-validation, this function will remove the
+token from cache and indicate no valid token is cached.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present and valid
+*/
+func (c *partitionedTokenCacheImpl) ValidTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	issuer, cache := c.partitionFor(token)
+	valid, err := cache.ValidTokenInCache(ctxt, token, timestamp)
+	if err != nil {
+		return valid, err
+	}
+	if valid {
+		c.recordLookup(issuer, tokenCacheLookupHit)
+	} else {
+		c.recordLookup(issuer, tokenCacheLookupMiss)
+	}
+	return valid, nil
+}
+
+/*
+InactiveTokenInCache check whether this token recently failed introspection
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present and inactive
+*/
+func (c *partitionedTokenCacheImpl) InactiveTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	issuer, cache := c.partitionFor(token)
+	inactive, err := cache.InactiveTokenInCache(ctxt, token, timestamp)
+	if err == nil && inactive {
+		c.recordLookup(issuer, tokenCacheLookupInactive)
+	}
+	return inactive, err
+}
+
+/*
+StaleTokenInCache check whether this token is cached and not yet expired, but requires
+re-validation. Unlike ValidTokenInCache, the token is not removed from cache.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present, not expired, and requires re-validation
+*/
+func (c *partitionedTokenCacheImpl) StaleTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	_, cache := c.partitionFor(token)
+	return cache.StaleTokenInCache(ctxt, token, timestamp)
+}
+
+/*
+TokensDueForRevalidation list the cached tokens which have not expired, and are due for
+re-validation within the given duration, across every partition. Only tokens cached in their
+original form are listed.
+
+	@param ctxt context.Context - the operating context
+	@param within time.Duration - the duration
+	@param timestamp time.Time - the current timestamp
+	@return the cached tokens
+*/
+func (c *partitionedTokenCacheImpl) TokensDueForRevalidation(
+	ctxt context.Context, within time.Duration, timestamp time.Time,
+) []CachedToken {
+	due := []CachedToken{}
+	for _, cache := range c.partitions {
+		due = append(due, cache.TokensDueForRevalidation(ctxt, within, timestamp)...)
+	}
+	return due
+}
+
+/*
+UnexpiredTokenInCache check whether this token is cached and not yet expired, regardless
+of whether it requires re-validation.
+
+	@param ctxt context.Context - the operating context
+	@param token string - the original token
+	@param timestamp time.Time - the current timestamp
+	@return whether it is present and not expired
+*/
+func (c *partitionedTokenCacheImpl) UnexpiredTokenInCache(
+	ctxt context.Context, token string, timestamp time.Time,
+) (bool, error) {
+	_, cache := c.partitionFor(token)
+	return cache.UnexpiredTokenInCache(ctxt, token, timestamp)
+}
+
+/*
+RemoveExpiredFromCache remove all expired tokens from every partition
+
+	@param ctxt context.Context - the operating context
+	@param timestamp time.Time - the current timestamp
+	@return whether successful
+*/
+func (c *partitionedTokenCacheImpl) RemoveExpiredFromCache(
+	ctxt context.Context, timestamp time.Time,
+) error {
+	for _, cache := range c.partitions {
+		if err := cache.RemoveExpiredFromCache(ctxt, timestamp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ClearCache remove all entries from every partition
+
+@param ctxt context.Context - the operating context
+*/
+func (c *partitionedTokenCacheImpl) ClearCache(ctxt context.Context) {
+	for _, cache := range c.partitions {
+		cache.ClearCache(ctxt)
+	}
+}
+
+/*
+ExportEntries list the unexpired cache entries of every partition, tagged with their issuer,
+so they can be persisted
+
+	@param ctxt context.Context - the operating context
+	@param timestamp time.Time - the current timestamp
+	@return the cache entries
+*/
+func (c *partitionedTokenCacheImpl) ExportEntries(
+	ctxt context.Context, timestamp time.Time,
+) []TokenCacheEntry {
+	entries := []TokenCacheEntry{}
+	for issuer, cache := range c.partitions {
+		for _, entry := range cache.ExportEntries(ctxt, timestamp) {
+			entry.Issuer = issuer
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+/*
+ImportEntries load previously exported cache entries into the partitions of their issuers.
+Entries of unknown issuers, or persisted before the cache was partitioned, are loaded into the
+primary issuer's partition. Expired entries are skipped, and tokens already cached are kept
+as is.
+
+	@param ctxt context.Context - the operating context
+	@param entries []TokenCacheEntry - the cache entries
+	@param timestamp time.Time - the current timestamp
+	@return the number of entries loaded
+*/
+func (c *partitionedTokenCacheImpl) ImportEntries(
+	ctxt context.Context, entries []TokenCacheEntry, timestamp time.Time,
+) int {
+	perIssuer := map[string][]TokenCacheEntry{}
+	for _, entry := range entries {
+		issuer := CanonicalIssuer(entry.Issuer)
+		if _, ok := c.partitions[issuer]; !ok {
+			issuer = c.primary
+		}
+		perIssuer[issuer] = append(perIssuer[issuer], entry)
+	}
+	loaded := 0
+	for issuer, issuerEntries := range perIssuer {
+		loaded += c.partitions[issuer].ImportEntries(ctxt, issuerEntries, timestamp)
+	}
+	return loaded
+}
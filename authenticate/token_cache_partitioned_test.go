@@ -0,0 +1,159 @@
+package authenticate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionedTokenCache(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	signToken := func(issuer string, tokenID string) string {
+		token, err := jwt.NewWithClaims(
+			jwt.SigningMethodHS256, jwt.MapClaims{"iss": issuer, "jti": tokenID},
+		).SignedString([]byte("unit-test"))
+		assert.Nil(err)
+		return token
+	}
+
+	lookups := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "token_cache_lookups_total"}, []string{"issuer", "result"},
+	)
+	workforce := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+	customer := DefineTokenCache(time.Second*30, 0, 0, nil)
+
+	// Case 0: primary issuer must have a partition
+	{
+		_, err := DefinePartitionedTokenCache(
+			"https://other.unit-test.org",
+			map[string]TokenCache{"https://workforce.unit-test.org": workforce},
+			lookups,
+		)
+		assert.NotNil(err)
+	}
+
+	uut, err := DefinePartitionedTokenCache(
+		"https://workforce.unit-test.org",
+		map[string]TokenCache{
+			"https://workforce.unit-test.org/": workforce,
+			"https://customer.unit-test.org":   customer,
+		},
+		lookups,
+	)
+	assert.Nil(err)
+
+	ctxt := context.Background()
+	currentTime := time.Now().UTC()
+	expire := currentTime.Add(time.Hour).Unix()
+	workforceToken := signToken("https://workforce.unit-test.org", "w-1")
+	customerToken := signToken("https://customer.unit-test.org/", "c-1")
+	otherToken := signToken("https://other.unit-test.org", "o-1")
+
+	// Case 1: tokens are cached in the partition of their issuer
+	assert.Nil(uut.RecordToken(ctxt, workforceToken, expire, currentTime))
+	assert.Nil(uut.RecordToken(ctxt, customerToken, expire, currentTime))
+	assert.Nil(uut.RecordToken(ctxt, otherToken, expire, currentTime))
+	assert.Nil(uut.RecordToken(ctxt, "opaque-token", expire, currentTime))
+	for token, cache := range map[string]TokenCache{
+		workforceToken: workforce,
+		customerToken:  customer,
+		otherToken:     workforce,
+		"opaque-token": workforce,
+	} {
+		cached, err := cache.UnexpiredTokenInCache(ctxt, token, currentTime)
+		assert.Nil(err)
+		assert.True(cached, token)
+	}
+	{
+		cached, err := workforce.UnexpiredTokenInCache(ctxt, customerToken, currentTime)
+		assert.Nil(err)
+		assert.False(cached)
+	}
+
+	// Case 2: each partition applies its own re-introspection interval
+	currentTime = currentTime.Add(time.Minute)
+	{
+		valid, err := uut.ValidTokenInCache(ctxt, workforceToken, currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		valid, err = uut.ValidTokenInCache(ctxt, customerToken, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+	}
+	assert.Equal(
+		1.0, testutil.ToFloat64(lookups.With(prometheus.Labels{
+			"issuer": "https://workforce.unit-test.org", "result": "hit",
+		})),
+	)
+	assert.Equal(
+		1.0, testutil.ToFloat64(lookups.With(prometheus.Labels{
+			"issuer": "https://customer.unit-test.org", "result": "miss",
+		})),
+	)
+
+	// Case 3: each partition applies its own negative caching
+	{
+		inactiveWorkforce := signToken("https://workforce.unit-test.org", "w-2")
+		inactiveCustomer := signToken("https://customer.unit-test.org", "c-2")
+		assert.Nil(uut.RecordInactiveToken(ctxt, inactiveWorkforce, currentTime))
+		assert.Nil(uut.RecordInactiveToken(ctxt, inactiveCustomer, currentTime))
+		inactive, err := uut.InactiveTokenInCache(ctxt, inactiveWorkforce, currentTime)
+		assert.Nil(err)
+		assert.True(inactive)
+		inactive, err = uut.InactiveTokenInCache(ctxt, inactiveCustomer, currentTime)
+		assert.Nil(err)
+		assert.False(inactive)
+	}
+
+	// Case 4: export and import keep the entries in the partition of their issuer
+	{
+		entries := uut.ExportEntries(ctxt, currentTime)
+		issuers := map[string]int{}
+		for _, entry := range entries {
+			issuers[entry.Issuer]++
+		}
+		assert.Equal(
+			map[string]int{"https://workforce.unit-test.org": 4}, issuers,
+		)
+
+		importWorkforce := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+		importCustomer := DefineTokenCache(time.Second*30, 0, 0, nil)
+		imported, err := DefinePartitionedTokenCache(
+			"https://workforce.unit-test.org",
+			map[string]TokenCache{
+				"https://workforce.unit-test.org": importWorkforce,
+				"https://customer.unit-test.org":  importCustomer,
+			},
+			nil,
+		)
+		assert.Nil(err)
+		entries = append(entries, TokenCacheEntry{
+			TokenHash: "customer-hash",
+			Expire:    expire,
+			Recorded:  currentTime,
+			Issuer:    "https://customer.unit-test.org",
+		}, TokenCacheEntry{TokenHash: "legacy-hash", Expire: expire, Recorded: currentTime})
+		assert.Equal(6, imported.ImportEntries(ctxt, entries, currentTime))
+		assert.Len(importWorkforce.ExportEntries(ctxt, currentTime), 5)
+		assert.Len(importCustomer.ExportEntries(ctxt, currentTime), 1)
+	}
+
+	// Case 5: removal by hash applies to every partition
+	{
+		assert.Nil(uut.RecordToken(ctxt, customerToken, expire, currentTime))
+		tokenHash, err := getTokenHash(customerToken)
+		assert.Nil(err)
+		assert.Nil(uut.RemoveTokenByHash(ctxt, tokenHash))
+		cached, err := uut.UnexpiredTokenInCache(ctxt, customerToken, currentTime)
+		assert.Nil(err)
+		assert.False(cached)
+	}
+}
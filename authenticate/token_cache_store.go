@@ -0,0 +1,223 @@
+package authenticate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenCacheStore persists the token cache entries, so the token cache can be warmed on restart
+type TokenCacheStore interface {
+	/*
+		Save persist the token cache entries, replacing those persisted before
+
+		@param ctxt context.Context - the operating context
+		@param entries []TokenCacheEntry - the cache entries
+		@param timestamp time.Time - the current timestamp
+		@return whether successful
+	*/
+	Save(ctxt context.Context, entries []TokenCacheEntry, timestamp time.Time) error
+
+	/*
+		Load read the persisted token cache entries
+
+		@param ctxt context.Context - the operating context
+		@return the cache entries, which is empty if none were persisted
+	*/
+	Load(ctxt context.Context) ([]TokenCacheEntry, error)
+}
+
+// persistedTokenCache is the persisted form of the token cache
+type persistedTokenCache struct {
+	// Saved is when the token cache was persisted
+	Saved time.Time `json:"saved"`
+	// Entries are the cache entries
+	Entries []TokenCacheEntry `json:"entries"`
+}
+
+// fileTokenCacheStore implements TokenCacheStore with a local file
+type fileTokenCacheStore struct {
+	path string
+}
+
+/*
+DefineFileTokenCacheStore define a token cache store persisting to a local file
+
+	@param path string - the file to persist to
+	@return new TokenCacheStore instance
+*/
+func DefineFileTokenCacheStore(path string) TokenCacheStore {
+	return &fileTokenCacheStore{path: path}
+}
+
+/*
+Save persist the token cache entries, replacing those persisted before. The file is replaced
+atomically, so a crash while saving does not corrupt it.
+
+	@param ctxt context.Context - the operating context
+	@param entries []TokenCacheEntry - the cache entries
+	@param timestamp time.Time - the current timestamp
+	@return whether successful
+*/
+func (s *fileTokenCacheStore) Save(
+	ctxt context.Context, entries []TokenCacheEntry, timestamp time.Time,
+) error {
+	content, err := json.Marshal(persistedTokenCache{Saved: timestamp, Entries: entries})
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(content); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), s.path)
+}
+
+/*
+Load read the persisted token cache entries
+
+	@param ctxt context.Context - the operating context
+	@return the cache entries, which is empty if none were persisted
+*/
+func (s *fileTokenCacheStore) Load(ctxt context.Context) ([]TokenCacheEntry, error) {
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []TokenCacheEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var persisted persistedTokenCache
+	if err := json.Unmarshal(content, &persisted); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", s.path, err)
+	}
+	return persisted.Entries, nil
+}
+
+// redisTokenCacheStore implements TokenCacheStore with Redis
+type redisTokenCacheStore struct {
+	client *redis.Client
+	key    string
+}
+
+/*
+DefineRedisTokenCacheStore define a token cache store persisting to Redis. The entries are
+stored as one value, which expires with the last entry.
+
+	@param client *redis.Client - the Redis client
+	@param key string - the key to store the entries under
+	@return new TokenCacheStore instance
+*/
+func DefineRedisTokenCacheStore(client *redis.Client, key string) TokenCacheStore {
+	return &redisTokenCacheStore{client: client, key: key}
+}
+
+/*
+Save persist the token cache entries, replacing those persisted before
+
+	@param ctxt context.Context - the operating context
+	@param entries []TokenCacheEntry - the cache entries
+	@param timestamp time.Time - the current timestamp
+	@return whether successful
+*/
+func (s *redisTokenCacheStore) Save(
+	ctxt context.Context, entries []TokenCacheEntry, timestamp time.Time,
+) error {
+	lastExpire := timestamp.Unix()
+	for _, entry := range entries {
+		if entry.Expire > lastExpire {
+			lastExpire = entry.Expire
+		}
+	}
+	if lastExpire <= timestamp.Unix() {
+		return s.client.Del(ctxt, s.key).Err()
+	}
+	content, err := json.Marshal(persistedTokenCache{Saved: timestamp, Entries: entries})
+	if err != nil {
+		return err
+	}
+	return s.client.Set(
+		ctxt, s.key, content, time.Second*time.Duration(lastExpire-timestamp.Unix()),
+	).Err()
+}
+
+/*
+Load read the persisted token cache entries
+
+	@param ctxt context.Context - the operating context
+	@return the cache entries, which is empty if none were persisted
+*/
+func (s *redisTokenCacheStore) Load(ctxt context.Context) ([]TokenCacheEntry, error) {
+	content, err := s.client.Get(ctxt, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return []TokenCacheEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var persisted persistedTokenCache
+	if err := json.Unmarshal(content, &persisted); err != nil {
+		return nil, fmt.Errorf("unable to parse Redis key %s: %w", s.key, err)
+	}
+	return persisted.Entries, nil
+}
+
+/*
+WarmTokenCache load the persisted token cache entries into the token cache
+
+	@param ctxt context.Context - the operating context
+	@param cache TokenCache - the token cache
+	@param store TokenCacheStore - the token cache store
+	@param timestamp time.Time - the current timestamp
+	@return the number of entries loaded
+*/
+func WarmTokenCache(
+	ctxt context.Context, cache TokenCache, store TokenCacheStore, timestamp time.Time,
+) (int, error) {
+	logTags := log.Fields{"module": "authenticate", "component": "token-cache-store"}
+	entries, err := store.Load(ctxt)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to load persisted token cache")
+		return 0, err
+	}
+	loaded := cache.ImportEntries(ctxt, entries, timestamp)
+	log.WithFields(logTags).Infof("Warmed token cache with %d persisted tokens", loaded)
+	return loaded, nil
+}
+
+/*
+PersistTokenCache persist the unexpired token cache entries
+
+	@param ctxt context.Context - the operating context
+	@param cache TokenCache - the token cache
+	@param store TokenCacheStore - the token cache store
+	@param timestamp time.Time - the current timestamp
+	@return whether successful
+*/
+func PersistTokenCache(
+	ctxt context.Context, cache TokenCache, store TokenCacheStore, timestamp time.Time,
+) error {
+	logTags := log.Fields{"module": "authenticate", "component": "token-cache-store"}
+	entries := cache.ExportEntries(ctxt, timestamp)
+	if err := store.Save(ctxt, entries, timestamp); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to persist token cache")
+		return err
+	}
+	log.WithFields(logTags).Debugf("Persisted %d tokens", len(entries))
+	return nil
+}
@@ -0,0 +1,117 @@
+package authenticate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenCacheStore(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	defer redisClient.Close()
+
+	stores := map[string]TokenCacheStore{
+		"file": DefineFileTokenCacheStore(
+			filepath.Join(t.TempDir(), fmt.Sprintf("token-cache-%s.json", uuid.NewString())),
+		),
+		"redis": DefineRedisTokenCacheStore(redisClient, "padlock:token-cache"),
+	}
+
+	ctxt := context.Background()
+
+	for backend, uut := range stores {
+		log.Debugf("Testing %s token cache store", backend)
+		currentTime := time.Now().UTC()
+
+		// Case 0: nothing persisted yet
+		{
+			entries, err := uut.Load(ctxt)
+			assert.Nil(err, backend)
+			assert.Empty(entries, backend)
+		}
+
+		// Case 1: persist a cache, and warm another with it
+		cache := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+		token1 := uuid.NewString()
+		token2 := uuid.NewString()
+		token3 := uuid.NewString()
+		assert.Nil(cache.RecordToken(ctxt, token1, currentTime.Add(time.Hour).Unix(), currentTime))
+		assert.Nil(cache.RecordToken(ctxt, token2, currentTime.Add(time.Minute).Unix(), currentTime))
+		assert.Nil(cache.RecordInactiveToken(ctxt, token3, currentTime))
+		assert.Nil(PersistTokenCache(ctxt, cache, uut, currentTime))
+
+		currentTime = currentTime.Add(time.Second * 30)
+		warmed := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+		loaded, err := WarmTokenCache(ctxt, warmed, uut, currentTime)
+		assert.Nil(err, backend)
+		// The inactive token entry has expired
+		assert.Equal(2, loaded, backend)
+		for _, token := range []string{token1, token2} {
+			valid, err := warmed.ValidTokenInCache(ctxt, token, currentTime)
+			assert.Nil(err, backend)
+			assert.True(valid, backend)
+		}
+		{
+			inactive, err := warmed.InactiveTokenInCache(ctxt, token3, currentTime)
+			assert.Nil(err, backend)
+			assert.False(inactive, backend)
+		}
+		// The recorded time is kept, so the re-validation schedule is unchanged
+		{
+			valid, err := warmed.ValidTokenInCache(ctxt, token1, currentTime.Add(time.Minute*5))
+			assert.Nil(err, backend)
+			assert.False(valid, backend)
+		}
+
+		// Case 2: entries expired when warming are skipped
+		loaded, err = WarmTokenCache(
+			ctxt, DefineTokenCache(time.Minute*5, 0, 0, nil), uut, currentTime.Add(time.Minute*2),
+		)
+		assert.Nil(err, backend)
+		assert.Equal(1, loaded, backend)
+
+		// Case 3: persisting an empty cache replaces the previous entries
+		assert.Nil(PersistTokenCache(
+			ctxt, DefineTokenCache(time.Minute*5, 0, 0, nil), uut, currentTime,
+		))
+		{
+			entries, err := uut.Load(ctxt)
+			assert.Nil(err, backend)
+			assert.Empty(entries, backend)
+		}
+	}
+
+	// Case 4: the Redis value expires with the last entry
+	{
+		currentTime := time.Now().UTC()
+		assert.Nil(stores["redis"].Save(ctxt, []TokenCacheEntry{
+			{TokenHash: "hash", Expire: currentTime.Add(time.Minute).Unix(), Recorded: currentTime},
+		}, currentTime))
+		assert.Equal(time.Minute, redisServer.TTL("padlock:token-cache"))
+		redisServer.FastForward(time.Minute * 2)
+		entries, err := stores["redis"].Load(ctxt)
+		assert.Nil(err)
+		assert.Empty(entries)
+	}
+
+	// Case 5: corrupted file
+	{
+		path := filepath.Join(t.TempDir(), "token-cache.json")
+		assert.Nil(os.WriteFile(path, []byte("not json"), 0o600))
+		_, err := DefineFileTokenCacheStore(path).Load(ctxt)
+		assert.NotNil(err)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"time"
 
 	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,7 +17,7 @@ func TestTokenCache(t *testing.T) {
 	assert := assert.New(t)
 	log.SetLevel(log.DebugLevel)
 
-	uut := DefineTokenCache(time.Minute * 5)
+	uut := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
 
 	startTime := time.Now().UTC()
 
@@ -85,4 +88,150 @@ func TestTokenCache(t *testing.T) {
 		assert.Nil(err)
 		assert.True(valid)
 	}
+
+	// Case 5: record an inactive token
+	token5 := uuid.New().String()
+	assert.Nil(uut.RecordInactiveToken(ctxt, token5, currentTime))
+	{
+		inactive, err := uut.InactiveTokenInCache(ctxt, token5, currentTime)
+		assert.Nil(err)
+		assert.True(inactive)
+		valid, err := uut.ValidTokenInCache(ctxt, token5, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+		unexpired, err := uut.UnexpiredTokenInCache(ctxt, token5, currentTime)
+		assert.Nil(err)
+		assert.False(unexpired)
+		inactive, err = uut.InactiveTokenInCache(ctxt, token4, currentTime)
+		assert.Nil(err)
+		assert.False(inactive)
+	}
+	// Invalidation notices from other replicas keep the inactive entry
+	{
+		tokenHash, err := getTokenHash(token5)
+		assert.Nil(err)
+		assert.Nil(uut.RemoveTokenByHash(ctxt, tokenHash))
+		inactive, err := uut.InactiveTokenInCache(ctxt, token5, currentTime)
+		assert.Nil(err)
+		assert.True(inactive)
+	}
+
+	// Case 6: the inactive token entry expires
+	currentTime = currentTime.Add(time.Second * 10)
+	{
+		inactive, err := uut.InactiveTokenInCache(ctxt, token5, currentTime)
+		assert.Nil(err)
+		assert.False(inactive)
+	}
+	assert.Nil(uut.RemoveExpiredFromCache(ctxt, currentTime))
+	{
+		inactive, err := uut.InactiveTokenInCache(ctxt, token5, currentTime.Add(-time.Second*5))
+		assert.Nil(err)
+		assert.False(inactive)
+	}
+}
+
+func TestTokenCacheKeyedByTokenID(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	uut := DefineTokenCache(time.Minute*5, time.Second*10, 0, nil)
+
+	currentTime := time.Now().UTC()
+	expire := currentTime.Add(time.Hour).Unix()
+	ctxt := context.Background()
+
+	signToken := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(
+			[]byte("unit-test"),
+		)
+		assert.Nil(err)
+		return token
+	}
+	tokenID := uuid.NewString()
+	token1 := signToken(jwt.MapClaims{"iss": "issuer-1", "jti": tokenID, "sub": "user-1"})
+	// Same token ID from the same issuer, encoded differently
+	token2 := signToken(jwt.MapClaims{"iss": "issuer-1", "jti": tokenID, "sub": "user-2"})
+	// Same token ID from another issuer
+	token3 := signToken(jwt.MapClaims{"iss": "issuer-2", "jti": tokenID, "sub": "user-1"})
+	// No token ID
+	token4 := signToken(jwt.MapClaims{"iss": "issuer-1", "sub": "user-1"})
+
+	// Case 0: entries of the same token ID are shared
+	assert.Nil(uut.RecordToken(ctxt, token1, expire, currentTime))
+	{
+		valid, err := uut.ValidTokenInCache(ctxt, token2, currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+		valid, err = uut.ValidTokenInCache(ctxt, token3, currentTime)
+		assert.Nil(err)
+		assert.False(valid)
+	}
+
+	// Case 1: a token ID found inactive is inactive regardless of the encoding
+	assert.Nil(uut.RecordInactiveToken(ctxt, token2, currentTime))
+	{
+		inactive, err := uut.InactiveTokenInCache(ctxt, token1, currentTime)
+		assert.Nil(err)
+		assert.True(inactive)
+		inactive, err = uut.InactiveTokenInCache(ctxt, token3, currentTime)
+		assert.Nil(err)
+		assert.False(inactive)
+	}
+
+	// Case 2: tokens without a token ID are keyed by the token
+	{
+		hash1, err := getTokenHash(token4)
+		assert.Nil(err)
+		hash2, err := getTokenHash(signToken(jwt.MapClaims{"iss": "issuer-1", "sub": "user-2"}))
+		assert.Nil(err)
+		assert.NotEqual(hash1, hash2)
+	}
+}
+
+func TestTokenCacheEviction(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	evictions := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "token_cache_evicted_total"}, []string{"kind"},
+	)
+	uut := DefineTokenCache(time.Minute*5, time.Second*10, 3, evictions)
+
+	currentTime := time.Now().UTC()
+	expire := currentTime.Add(time.Hour).Unix()
+
+	ctxt := context.Background()
+
+	tokens := []string{}
+	for itr := 0; itr < 3; itr++ {
+		token := uuid.New().String()
+		tokens = append(tokens, token)
+		assert.Nil(uut.RecordToken(ctxt, token, expire, currentTime))
+	}
+
+	// Case 0: using the first token makes the second the least recently used
+	{
+		valid, err := uut.ValidTokenInCache(ctxt, tokens[0], currentTime)
+		assert.Nil(err)
+		assert.True(valid)
+	}
+
+	// Case 1: exceeding the maximum evicts the least recently used token
+	token4 := uuid.New().String()
+	assert.Nil(uut.RecordToken(ctxt, token4, expire, currentTime))
+	for idx, token := range []string{tokens[0], tokens[1], tokens[2], token4} {
+		valid, err := uut.ValidTokenInCache(ctxt, token, currentTime)
+		assert.Nil(err)
+		assert.Equal(idx != 1, valid)
+	}
+	assert.Equal(1.0, testutil.ToFloat64(evictions.With(prometheus.Labels{"kind": "active"})))
+
+	// Case 2: a flood of inactive tokens only keeps the most recent
+	for itr := 0; itr < 5; itr++ {
+		assert.Nil(uut.RecordInactiveToken(ctxt, uuid.New().String(), currentTime))
+	}
+	assert.Len(uut.ExportEntries(ctxt, currentTime), 3)
+	assert.Equal(4.0, testutil.ToFloat64(evictions.With(prometheus.Labels{"kind": "active"})))
+	assert.Equal(2.0, testutil.ToFloat64(evictions.With(prometheus.Labels{"kind": "inactive"})))
 }
@@ -0,0 +1,138 @@
+package authenticate
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+)
+
+// UserinfoFetcher fetches the userinfo claims of a token from the OpenID issuer, caching the
+// responses so repeated requests with the same token do not call the issuer again
+type UserinfoFetcher interface {
+	/*
+		FetchUserinfo fetch the userinfo claims of a token, from the cache if available
+
+		 @param ctxt context.Context - the operating context
+		 @param token string - the access token
+		 @param expire int64 - when the token expires
+		 @param timestamp time.Time - the current timestamp
+		 @return the userinfo claims
+	*/
+	FetchUserinfo(
+		ctxt context.Context, token string, expire int64, timestamp time.Time,
+	) (map[string]interface{}, error)
+}
+
+// userinfoEntry a cached userinfo response
+type userinfoEntry struct {
+	tokenHash string
+	// When the entry expires
+	expire time.Time
+	claims map[string]interface{}
+}
+
+// userinfoFetcherImpl implements UserinfoFetcher
+type userinfoFetcherImpl struct {
+	goutils.Component
+	client OpenIDIssuerClient
+	lock   sync.Mutex
+	cache  map[string]*list.Element
+	// lru orders the entries from the most to the least recently used
+	lru        *list.List
+	ttl        time.Duration
+	maxEntries int
+}
+
+/*
+DefineUserinfoFetcher define a new userinfo fetcher
+
+	@param client OpenIDIssuerClient - the client to query the userinfo endpoint with
+	@param ttl time.Duration - how long to cache a userinfo response. An entry is never cached
+	past the expiration of its token.
+	@param maxEntries int - the maximum number of entries, beyond which the least recently used
+	entries are evicted
+	@return new UserinfoFetcher instance
+*/
+func DefineUserinfoFetcher(
+	client OpenIDIssuerClient, ttl time.Duration, maxEntries int,
+) UserinfoFetcher {
+	logTags := log.Fields{"module": "authenticate", "component": "userinfo-fetcher"}
+	return &userinfoFetcherImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		client:     client,
+		lock:       sync.Mutex{},
+		cache:      make(map[string]*list.Element),
+		lru:        list.New(),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+/*
+FetchUserinfo fetch the userinfo claims of a token, from the cache if available
+
+	@param ctxt context.Context - the operating context
+	@param token string - the access token
+	@param expire int64 - when the token expires
+	@param timestamp time.Time - the current timestamp
+	@return the userinfo claims
+*/
+func (f *userinfoFetcherImpl) FetchUserinfo(
+	ctxt context.Context, token string, expire int64, timestamp time.Time,
+) (map[string]interface{}, error) {
+	logTags := f.GetLogTagsForContext(ctxt)
+	tokenHash, err := getTokenHash(token)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to hash token")
+		return nil, err
+	}
+
+	f.lock.Lock()
+	if element, ok := f.cache[tokenHash]; ok {
+		entry := element.Value.(*userinfoEntry)
+		if timestamp.Before(entry.expire) {
+			f.lru.MoveToFront(element)
+			f.lock.Unlock()
+			return entry.claims, nil
+		}
+		f.lru.Remove(element)
+		delete(f.cache, tokenHash)
+	}
+	f.lock.Unlock()
+
+	claims, err := f.client.FetchUserinfo(ctxt, token)
+	if err != nil {
+		return nil, err
+	}
+
+	entryExpire := timestamp.Add(f.ttl)
+	if tokenExpire := time.Unix(expire, 0); tokenExpire.Before(entryExpire) {
+		entryExpire = tokenExpire
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	entry := &userinfoEntry{tokenHash: tokenHash, expire: entryExpire, claims: claims}
+	if element, ok := f.cache[tokenHash]; ok {
+		element.Value = entry
+		f.lru.MoveToFront(element)
+	} else {
+		f.cache[tokenHash] = f.lru.PushFront(entry)
+	}
+	for f.maxEntries > 0 && f.lru.Len() > f.maxEntries {
+		evicted := f.lru.Remove(f.lru.Back()).(*userinfoEntry)
+		delete(f.cache, evicted.tokenHash)
+	}
+	return claims, nil
+}
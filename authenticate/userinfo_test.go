@@ -0,0 +1,104 @@
+package authenticate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserinfoFetcher(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	var userinfoCalls int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(OpenIDIssuerConfig{
+				Issuer:     server.URL,
+				JwksURI:    server.URL + "/certs",
+				UserinfoEP: server.URL + "/userinfo",
+			})
+		case "/certs":
+			_, _ = w.Write([]byte(`{"keys":[]}`))
+		case "/userinfo":
+			atomic.AddInt32(&userinfoCalls, 1)
+			switch r.Header.Get("Authorization") {
+			case "Bearer token-1":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"sub": "user-1", "email": "user-1@unit-test.org",
+				})
+			case "Bearer token-2":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"sub": "user-2", "email": "user-2@unit-test.org",
+				})
+			default:
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := DefineOpenIDClient(common.OpenIDIssuerConfig{Issuer: server.URL}, server.Client())
+	assert.Nil(err)
+	uut := DefineUserinfoFetcher(client, time.Minute, 1)
+
+	ctxt := context.Background()
+	now := time.Now()
+	tokenExpire := now.Add(time.Minute * 5).Unix()
+
+	// Case 0: fetch from the issuer, then from the cache
+	{
+		claims, err := uut.FetchUserinfo(ctxt, "token-1", tokenExpire, now)
+		assert.Nil(err)
+		assert.Equal("user-1@unit-test.org", claims["email"])
+		claims, err = uut.FetchUserinfo(ctxt, "token-1", tokenExpire, now.Add(time.Second*30))
+		assert.Nil(err)
+		assert.Equal("user-1", claims["sub"])
+		assert.Equal(int32(1), atomic.LoadInt32(&userinfoCalls))
+	}
+
+	// Case 1: cache entry past its TTL
+	{
+		claims, err := uut.FetchUserinfo(ctxt, "token-1", tokenExpire, now.Add(time.Minute*2))
+		assert.Nil(err)
+		assert.Equal("user-1", claims["sub"])
+		assert.Equal(int32(2), atomic.LoadInt32(&userinfoCalls))
+	}
+
+	// Case 2: cache entry is not kept past the token expiration
+	{
+		shortExpire := now.Add(time.Second * 10).Unix()
+		_, err := uut.FetchUserinfo(ctxt, "token-2", shortExpire, now)
+		assert.Nil(err)
+		assert.Equal(int32(3), atomic.LoadInt32(&userinfoCalls))
+		claims, err := uut.FetchUserinfo(ctxt, "token-2", shortExpire, now.Add(time.Second*20))
+		assert.Nil(err)
+		assert.Equal("user-2", claims["sub"])
+		assert.Equal(int32(4), atomic.LoadInt32(&userinfoCalls))
+	}
+
+	// Case 3: least recently used entry evicted
+	{
+		_, err := uut.FetchUserinfo(ctxt, "token-1", tokenExpire, now)
+		assert.Nil(err)
+		assert.Equal(int32(5), atomic.LoadInt32(&userinfoCalls))
+	}
+
+	// Case 4: issuer rejects the token
+	{
+		_, err := uut.FetchUserinfo(ctxt, "token-3", tokenExpire, now)
+		assert.NotNil(err)
+	}
+}
@@ -0,0 +1,120 @@
+package authenticate
+
+import (
+	"context"
+	"sync"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// IssuerProbeFunc signature for a function which probes the OpenID issuer's endpoints
+type IssuerProbeFunc func(ctxt context.Context) error
+
+// IssuerHealthStatus reports on the health of the OpenID issuer
+type IssuerHealthStatus interface {
+	/*
+		IssuerHealthy whether the OpenID issuer was reachable during the last check
+
+		 @return whether the OpenID issuer is healthy
+	*/
+	IssuerHealthy() bool
+}
+
+// IssuerWatchdog periodically checks the health of the OpenID issuer
+type IssuerWatchdog interface {
+	IssuerHealthStatus
+
+	/*
+		CheckHealth probe the OpenID issuer, and record the result
+
+		 @param ctxt context.Context - the operating context
+		 @return nil if the issuer is healthy, or an error otherwise
+	*/
+	CheckHealth(ctxt context.Context) error
+}
+
+// issuerWatchdogImpl implements IssuerWatchdog
+type issuerWatchdogImpl struct {
+	goutils.Component
+	issuer   string
+	probe    IssuerProbeFunc
+	upMetric *prometheus.GaugeVec
+	lock     sync.RWMutex
+	healthy  bool
+}
+
+/*
+DefineIssuerWatchdog defines a new OpenID issuer watchdog. The issuer is considered healthy
+until a check indicates otherwise.
+
+	@param issuer string - the OpenID issuer
+	@param probe IssuerProbeFunc - function to probe the issuer's endpoints
+	@param upMetric *prometheus.GaugeVec - if provided, the metric recording whether the issuer
+	is up. It must support the label "issuer".
+	@return new watchdog instance
+*/
+func DefineIssuerWatchdog(
+	issuer string, probe IssuerProbeFunc, upMetric *prometheus.GaugeVec,
+) IssuerWatchdog {
+	logTags := log.Fields{"module": "authenticate", "component": "issuer-watchdog", "issuer": issuer}
+	return &issuerWatchdogImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		issuer:   issuer,
+		probe:    probe,
+		upMetric: upMetric,
+		lock:     sync.RWMutex{},
+		healthy:  true,
+	}
+}
+
+/*
+CheckHealth probe the OpenID issuer, and record the result
+
+	@param ctxt context.Context - the operating context
+	@return nil if the issuer is healthy, or an error otherwise
+*/
+func (w *issuerWatchdogImpl) CheckHealth(ctxt context.Context) error {
+	logTags := w.GetLogTagsForContext(ctxt)
+	err := w.probe(ctxt)
+	healthy := err == nil
+
+	w.lock.Lock()
+	if healthy != w.healthy {
+		if healthy {
+			log.WithFields(logTags).Info("OpenID issuer is reachable again")
+		} else {
+			log.WithError(err).WithFields(logTags).Error("OpenID issuer is not reachable")
+		}
+	}
+	w.healthy = healthy
+	w.lock.Unlock()
+
+	if w.upMetric != nil {
+		value := 0.0
+		if healthy {
+			value = 1.0
+		}
+		w.upMetric.With(prometheus.Labels{"issuer": w.issuer}).Set(value)
+	}
+	return err
+}
+
+/*
+IssuerHealthy whether the OpenID issuer was reachable during the last check
+
+	@return whether the OpenID issuer is healthy
+*/
+func (w *issuerWatchdogImpl) IssuerHealthy() bool {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.healthy
+}
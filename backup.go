@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/go-playground/validator/v10"
+	"github.com/urfave/cli/v2"
+)
+
+type backupArgs struct {
+	OutputFile string
+	InputFile  string
+}
+
+var backupCmdArgs backupArgs
+
+// backupCommands define the backup and restore CLI commands
+func backupCommands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:  "backup",
+			Usage: "Dump the user management records into a versioned, DB engine independent file",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "output",
+					Usage:       "Backup output file",
+					Aliases:     []string{"f"},
+					Destination: &backupCmdArgs.OutputFile,
+					Required:    true,
+				},
+			},
+			Action: commandAction("backup", backupApplication),
+		},
+		{
+			Name:  "restore",
+			Usage: "Load user management records from a backup file",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "input",
+					Usage:       "Backup input file",
+					Aliases:     []string{"f"},
+					Destination: &backupCmdArgs.InputFile,
+					Required:    true,
+				},
+			},
+			Action: commandAction("restore", restoreApplication),
+		},
+	}
+}
+
+// backupResult is the result of the backup and restore commands
+type backupResult struct {
+	// File is the backup file
+	File string `json:"file"`
+	// Users is the number of users backed up or restored
+	Users int `json:"users"`
+}
+
+func backupApplication(c *cli.Context) (interface{}, error) {
+	appCfg, customValidator, validate, err := setupApplication()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := closeLogSinks(); err != nil {
+			log.WithError(err).Errorf("Failed to close log sinks")
+		}
+	}()
+	started := time.Now()
+	records, err := performBackup(appCfg, customValidator, validate)
+	pushBatchJobMetrics(appCfg.Metrics.Push, "backup", started, records, err)
+	if err != nil {
+		return nil, err
+	}
+	return backupResult{File: backupCmdArgs.OutputFile, Users: records}, nil
+}
+
+func performBackup(
+	appCfg common.AuthorizationServerConfig,
+	customValidator common.CustomFieldValidator,
+	validate *validator.Validate,
+) (int, error) {
+	snapshot, err := exportDatabaseSnapshot(appCfg, customValidator, validate)
+	if err != nil {
+		return 0, err
+	}
+	serialized, err := json.MarshalIndent(&snapshot, "", "  ")
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to serialize backup")
+		return 0, err
+	}
+	if err := os.WriteFile(backupCmdArgs.OutputFile, serialized, 0600); err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to write %s", backupCmdArgs.OutputFile)
+		return 0, err
+	}
+	log.WithFields(logTags).Infof(
+		"Backed up %d users to %s", len(snapshot.Users), backupCmdArgs.OutputFile,
+	)
+	return len(snapshot.Users), nil
+}
+
+func restoreApplication(c *cli.Context) (interface{}, error) {
+	appCfg, customValidator, validate, err := setupApplication()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := closeLogSinks(); err != nil {
+			log.WithError(err).Errorf("Failed to close log sinks")
+		}
+	}()
+	started := time.Now()
+	records, err := performRestore(appCfg, customValidator, validate)
+	pushBatchJobMetrics(appCfg.Metrics.Push, "restore", started, records, err)
+	if err != nil {
+		return nil, err
+	}
+	return backupResult{File: backupCmdArgs.InputFile, Users: records}, nil
+}
+
+func performRestore(
+	appCfg common.AuthorizationServerConfig,
+	customValidator common.CustomFieldValidator,
+	validate *validator.Validate,
+) (int, error) {
+	snapshot, err := readSnapshotFile(backupCmdArgs.InputFile)
+	if err != nil {
+		return 0, err
+	}
+
+	dbDSN, err := buildDatabaseDSN(validate)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to define DB connection DSN")
+		return 0, err
+	}
+	userManager, err := defineUserManager(
+		dbDSN,
+		appCfg.Startup.DBConnect,
+		customValidator,
+		nil,
+		nil,
+		&appCfg.UserManagement.PermissionWildcards,
+	)
+	if err != nil {
+		return 0, err
+	}
+	// The roles must be aligned with the configuration before users can refer to them
+	err = userManager.AlignRolesWithConfig(
+		context.Background(), appCfg.UserManagement.AvailableRoles,
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to perform role config sync")
+		return 0, err
+	}
+
+	if err := users.RestoreSnapshot(context.Background(), userManager, snapshot); err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Failed to restore user management records from %s", backupCmdArgs.InputFile)
+		return 0, err
+	}
+	log.WithFields(logTags).Infof(
+		"Restored %d users from %s", len(snapshot.Users), backupCmdArgs.InputFile,
+	)
+	return len(snapshot.Users), nil
+}
+
+/*
+readSnapshotFile read a backup file
+
+	@param path string - the backup file
+	@return the backup snapshot
+*/
+func readSnapshotFile(path string) (users.Snapshot, error) {
+	var snapshot users.Snapshot
+	serialized, err := os.ReadFile(path)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to read %s", path)
+		return snapshot, err
+	}
+	if err := json.Unmarshal(serialized, &snapshot); err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to parse %s", path)
+		return snapshot, err
+	}
+	return snapshot, nil
+}
+
+// pushBatchJobMetrics push the outcome of a CLI operation to the Pushgateway, if enabled
+func pushBatchJobMetrics(
+	config common.MetricsPushConfig, operation string, started time.Time, records int, jobErr error,
+) {
+	if !config.Enabled {
+		return
+	}
+	pusher := common.DefineBatchJobMetricsPusher(config, cmdArgs.Hostname)
+	err := pusher.PushResult(context.Background(), common.BatchJobResult{
+		Operation: operation,
+		Started:   started,
+		Completed: time.Now(),
+		Records:   records,
+		Success:   jobErr == nil,
+	})
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Failed to push '%s' metrics to %s", operation, config.GatewayURL)
+	}
+}
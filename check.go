@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Expected decisions of the check command
+const (
+	checkDecisionAllow = "allow"
+	checkDecisionDeny  = "deny"
+)
+
+// checkPlaceholderUserID is the user ID checked when only roles are given
+const checkPlaceholderUserID = "padlock-check-user"
+
+type checkArgs struct {
+	UserID       string
+	Roles        cli.StringSlice
+	SnapshotFile string
+	Host         string
+	Path         string
+	Method       string
+	Attributes   cli.StringSlice
+	ClientID     string
+	Scopes       cli.StringSlice
+	Headers      cli.StringSlice
+	Expect       string
+}
+
+var checkCmdArgs checkArgs
+
+// checkCommand define the offline authorization decision CLI command
+func checkCommand() *cli.Command {
+	return &cli.Command{
+		Name: "check",
+		Usage: "Decide whether a user would be allowed to make a request, using the application " +
+			"config and an optional user backup file, without a running server or database",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name: "user",
+				Usage: "User ID to check. With --role, the user is given those roles. Otherwise, " +
+					"the user must be in the backup file. Without a user ID or roles, the request is " +
+					"checked as an anonymous request.",
+				Aliases:     []string{"u"},
+				Destination: &checkCmdArgs.UserID,
+				Required:    false,
+			},
+			&cli.StringSliceFlag{
+				Name:        "role",
+				Usage:       "Role of the user to check. Repeat for multiple roles.",
+				Aliases:     []string{"r"},
+				Destination: &checkCmdArgs.Roles,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "snapshot",
+				Usage:       "User backup file, as written by the backup command",
+				Aliases:     []string{"s"},
+				Destination: &checkCmdArgs.SnapshotFile,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "host",
+				Usage:       "Host of the request to check",
+				Destination: &checkCmdArgs.Host,
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "URI path of the request to check",
+				Destination: &checkCmdArgs.Path,
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "method",
+				Usage:       "HTTP method of the request to check",
+				Value:       http.MethodGet,
+				DefaultText: http.MethodGet,
+				Destination: &checkCmdArgs.Method,
+				Required:    false,
+			},
+			&cli.StringSliceFlag{
+				Name:        "attribute",
+				Usage:       "Named request attribute of the request to check, as NAME=VALUE",
+				Destination: &checkCmdArgs.Attributes,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "client",
+				Usage:       "ID of the OAuth2 client making the request to check",
+				Destination: &checkCmdArgs.ClientID,
+				Required:    false,
+			},
+			&cli.StringSliceFlag{
+				Name:        "scope",
+				Usage:       "OAuth2 scope of the token the request is made with. Repeat for multiple scopes.",
+				Destination: &checkCmdArgs.Scopes,
+				Required:    false,
+			},
+			&cli.StringSliceFlag{
+				Name:        "header",
+				Usage:       "Header of the request to check, as NAME=VALUE",
+				Destination: &checkCmdArgs.Headers,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name: "expect",
+				Usage: fmt.Sprintf(
+					"Expected decision: [%s %s]. If given, a different decision is an error.",
+					checkDecisionAllow, checkDecisionDeny,
+				),
+				Destination: &checkCmdArgs.Expect,
+				Required:    false,
+			},
+		},
+		Action: commandAction("check", checkApplication),
+	}
+}
+
+// checkResult is the outcome of the check command
+type checkResult struct {
+	// Decision is either "allow" or "deny"
+	Decision string `json:"decision"`
+	// Reason explains a denial
+	Reason string `json:"reason,omitempty"`
+	// Request is the request checked
+	Request string `json:"request"`
+	// UserID is the user checked
+	UserID string `json:"user_id"`
+	// UserPermissions are the permissions the user holds for the host of the request
+	UserPermissions []string `json:"user_permissions"`
+	// Rule is the authorization rule the request matched
+	Rule *match.MatchedRule `json:"rule,omitempty"`
+}
+
+func checkApplication(c *cli.Context) (interface{}, error) {
+	appCfg, customValidator, _, err := setupApplication()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := closeLogSinks(); err != nil {
+			log.WithError(err).Errorf("Failed to close log sinks")
+		}
+	}()
+	switch checkCmdArgs.Expect {
+	case "", checkDecisionAllow, checkDecisionDeny:
+	default:
+		return nil, fmt.Errorf("unsupported expected decision '%s'", checkCmdArgs.Expect)
+	}
+
+	result, err := performCheck(c.Context, appCfg, customValidator)
+	if err != nil {
+		return nil, err
+	}
+	if !jsonOutput() {
+		serialized, err := json.MarshalIndent(&result, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println(string(serialized))
+	}
+
+	if checkCmdArgs.Expect != "" && checkCmdArgs.Expect != result.Decision {
+		return result, fmt.Errorf(
+			"expected decision '%s', got '%s'", checkCmdArgs.Expect, result.Decision,
+		)
+	}
+	return result, nil
+}
+
+/*
+readCheckSnapshot read the user backup file, if given. The roles given on the command line
+replace the roles of the user checked.
+
+	@param userID string - the user checked
+	@param roles []string - the roles given on the command line
+	@return the user records to load
+*/
+func readCheckSnapshot(userID string, roles []string) (users.Snapshot, error) {
+	snapshot := users.Snapshot{
+		Version: users.SnapshotFormatVersion, Roles: []string{}, Users: []users.SnapshotUser{},
+	}
+	if checkCmdArgs.SnapshotFile != "" {
+		var err error
+		if snapshot, err = readSnapshotFile(checkCmdArgs.SnapshotFile); err != nil {
+			return snapshot, err
+		}
+	}
+	if len(roles) > 0 {
+		found := false
+		for idx, oneUser := range snapshot.Users {
+			if oneUser.UserID == userID {
+				snapshot.Users[idx].Roles = roles
+				found = true
+			}
+		}
+		if !found {
+			snapshot.Users = append(snapshot.Users, users.SnapshotUser{
+				UserConfig: models.UserConfig{UserID: userID}, Roles: roles,
+			})
+		}
+	}
+	return snapshot, nil
+}
+
+/*
+defineInMemoryUserManager define a user manager backed by a private in-memory database, holding
+the configured roles, and the given user records
+
+	@param ctxt context.Context - the operating context
+	@param appCfg common.AuthorizationServerConfig - the application config
+	@param customValidator common.CustomFieldValidator - custom field validator
+	@param snapshot users.Snapshot - the user records to load
+	@return the user manager
+*/
+func defineInMemoryUserManager(
+	ctxt context.Context,
+	appCfg common.AuthorizationServerConfig,
+	customValidator common.CustomFieldValidator,
+	snapshot users.Snapshot,
+) (users.Management, error) {
+	dbDSN := fmt.Sprintf("file:padlock-%s?mode=memory&cache=shared", uuid.NewString())
+	baseDBClient, err := gorm.Open(
+		sqlite.Open(dbDSN), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to create in-memory DB client")
+		return nil, err
+	}
+	dbClient, err := models.CreateManagementDBClient(baseDBClient, customValidator)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to create DB client")
+		return nil, err
+	}
+	userManager, err := users.CreateManagement(
+		dbClient, nil, &appCfg.UserManagement.PermissionWildcards,
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to define user management instance")
+		return nil, err
+	}
+	if err := userManager.AlignRolesWithConfig(
+		ctxt, appCfg.UserManagement.AvailableRoles,
+	); err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to perform role config sync")
+		return nil, err
+	}
+	if err := users.RestoreSnapshot(ctxt, userManager, snapshot); err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to load user records")
+		return nil, err
+	}
+	return userManager, nil
+}
+
+/*
+readNameValues parse the NAME=VALUE command line values
+
+	@param values []string - the command line values
+	@param kind string - what the values are, for the error message
+	@return the values, by name
+*/
+func readNameValues(values []string, kind string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, entry := range values {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s '%s' is not NAME=VALUE", kind, entry)
+		}
+		result[name] = value
+	}
+	return result, nil
+}
+
+/*
+performCheck decide whether the user would be allowed to make the request. The decision is
+made by the same logic as the authorization API.
+
+	@param ctxt context.Context - the operating context
+	@param appCfg common.AuthorizationServerConfig - the application config
+	@param customValidator common.CustomFieldValidator - custom field validator
+	@return the decision
+*/
+func performCheck(
+	ctxt context.Context,
+	appCfg common.AuthorizationServerConfig,
+	customValidator common.CustomFieldValidator,
+) (checkResult, error) {
+	roles := checkCmdArgs.Roles.Value()
+	userID := appCfg.UserIDNormalization.Normalize(checkCmdArgs.UserID)
+	if userID == "" && len(roles) > 0 {
+		userID = checkPlaceholderUserID
+	}
+
+	// Build request matcher
+	matcher, err := defineRequestMatcher(&appCfg.Authorization.AuthorizationConfig)
+	if err != nil {
+		return checkResult{}, err
+	}
+
+	snapshot, err := readCheckSnapshot(userID, roles)
+	if err != nil {
+		return checkResult{}, err
+	}
+	userManager, err := defineInMemoryUserManager(ctxt, appCfg, customValidator, snapshot)
+	if err != nil {
+		return checkResult{}, err
+	}
+	decider, err := apis.DefineRequestDecider(
+		userManager, matcher, appCfg.Authorization.Bypass, nil,
+	)
+	if err != nil {
+		return checkResult{}, err
+	}
+
+	method := strings.ToUpper(checkCmdArgs.Method)
+	result := checkResult{
+		Decision:        checkDecisionDeny,
+		Request:         fmt.Sprintf("%s %s%s", method, checkCmdArgs.Host, checkCmdArgs.Path),
+		UserID:          userID,
+		UserPermissions: []string{},
+	}
+
+	givenAttributes, err := readNameValues(checkCmdArgs.Attributes.Value(), "attribute")
+	if err != nil {
+		return checkResult{}, err
+	}
+	// Config keys, and so the attribute names, are case insensitive
+	attributes := map[string]string{}
+	for name, value := range givenAttributes {
+		attributes[strings.ToLower(name)] = value
+	}
+	headers, err := readNameValues(checkCmdArgs.Headers.Value(), "header")
+	if err != nil {
+		return checkResult{}, err
+	}
+	params := common.AccessAuthorizeParam{
+		UserID:     userID,
+		ClientID:   checkCmdArgs.ClientID,
+		Method:     method,
+		Path:       checkCmdArgs.Path,
+		Host:       checkCmdArgs.Host,
+		Attributes: attributes,
+		Headers:    common.CanonicalHeaders(headers),
+		Scopes:     checkCmdArgs.Scopes.Value(),
+	}
+
+	bypassed, err := decider.Bypassed(ctxt, params)
+	if err != nil {
+		return checkResult{}, err
+	}
+	if bypassed {
+		result.Decision = checkDecisionAllow
+		result.Reason = "the request matches the authorization bypass rules"
+		return result, nil
+	}
+
+	reqAbsPath, err := match.GetAbsPath(checkCmdArgs.Path)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Request path normalization failed")
+		return checkResult{}, err
+	}
+	params.Path = reqAbsPath
+
+	// Same as the authorization API, the host of the request decides which roles apply
+	ctxt = context.WithValue(ctxt, common.AccessAuthorizeParamKey{}, params)
+	decision, err := decider.Decide(ctxt, params, reqAbsPath)
+	if err != nil {
+		return checkResult{}, err
+	}
+	result.Rule = decision.Rule
+	if decision.Outcome == apis.OutcomeAllowed {
+		result.Decision = checkDecisionAllow
+	} else if decision.Outcome == apis.OutcomeNoMatchingRule {
+		result.Reason = fmt.Sprintf("no matching authorization rule: %s", decision.Reason)
+	} else {
+		result.Reason = decision.Reason
+	}
+
+	if userID != "" && decision.Outcome != apis.OutcomeUnknownUser {
+		permissions, err := userManager.GetUserRequestPermissions(ctxt, userID)
+		if err == nil {
+			result.UserPermissions = permissions
+		}
+	}
+	return result, nil
+}
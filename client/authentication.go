@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/common"
+)
+
+// AuthenticationRequest describes the REST API call to authenticate
+type AuthenticationRequest struct {
+	// BearerToken is the caller's bearer token
+	BearerToken string
+	// Host is the host / FQDN of the request being authenticated
+	Host string
+	// Path is the URI path of the request being authenticated
+	Path string
+	// Method is the HTTP method of the request being authenticated
+	Method string
+}
+
+// AuthenticatedUser is the user parameters Padlock extracted from an authenticated token
+type AuthenticatedUser struct {
+	// UserID is the user ID
+	UserID string
+	// Username is the username, if known
+	Username string
+	// FirstName is the first name / given name, if known
+	FirstName string
+	// LastName is the last name / surname / family name, if known
+	LastName string
+	// Email is the email, if known
+	Email string
+}
+
+// TokenIntrospection is the normalized result of introspecting a token through Padlock
+type TokenIntrospection struct {
+	// Active whether the token is active
+	Active bool
+	// Reason is the error code explaining why the token is not active
+	Reason string
+	// User is the user parameters in the token, if active
+	User AuthenticatedUser
+	// Expire is when the token expires, if active
+	Expire time.Time
+}
+
+// AuthenticationClient is the client for the Padlock authentication API
+type AuthenticationClient interface {
+	/*
+		Ready check whether the authentication API is ready
+
+		 @param ctxt context.Context - the calling context
+		 @return nil if ready, or an error otherwise
+	*/
+	Ready(ctxt context.Context) error
+
+	/*
+		Authenticate check whether a REST API call is authenticated
+
+		 @param ctxt context.Context - the calling context
+		 @param request AuthenticationRequest - the REST API call to authenticate
+		 @return the user parameters, and whether the call is authenticated. An error is
+		 returned only if the check could not be completed. The user parameters are empty if
+		 the call bypassed authentication.
+	*/
+	Authenticate(
+		ctxt context.Context, request AuthenticationRequest,
+	) (AuthenticatedUser, bool, error)
+
+	/*
+		IntrospectToken introspect a token through Padlock, so the caller need not hold its own
+		OpenID issuer client credentials
+
+		 @param ctxt context.Context - the calling context
+		 @param token string - the token
+		 @return the introspection result. An error is returned only if the introspection could
+		 not be completed.
+	*/
+	IntrospectToken(ctxt context.Context, token string) (TokenIntrospection, error)
+}
+
+// authenticationClientImpl implements AuthenticationClient
+type authenticationClientImpl struct {
+	baseClient
+	reqHeaders  common.AuthenticateRequestParamLocConfig
+	respHeaders common.AuthorizeRequestParamLocConfig
+	// introspectClientID and introspectSecret are the introspection client credentials
+	introspectClientID string
+	introspectSecret   string
+}
+
+/*
+DefineAuthenticationClient define a new authentication API client
+
+	@param cfg Config - the client config
+	@param reqHeaders common.AuthenticateRequestParamLocConfig - the headers Padlock reads the
+	parameters of the REST API call to authenticate from. This must match the Padlock
+	configuration.
+	@param respHeaders common.AuthorizeRequestParamLocConfig - the headers Padlock returns the
+	user parameters on. This must match the Padlock configuration.
+	@return new AuthenticationClient instance
+*/
+func DefineAuthenticationClient(
+	cfg Config,
+	reqHeaders common.AuthenticateRequestParamLocConfig,
+	respHeaders common.AuthorizeRequestParamLocConfig,
+) (AuthenticationClient, error) {
+	base, err := defineBaseClient(cfg, "authentication")
+	if err != nil {
+		return nil, err
+	}
+	return &authenticationClientImpl{
+		baseClient:         base,
+		reqHeaders:         reqHeaders,
+		respHeaders:        respHeaders,
+		introspectClientID: cfg.IntrospectClientID,
+		introspectSecret:   cfg.IntrospectClientSecret,
+	}, nil
+}
+
+/*
+Ready check whether the authentication API is ready
+
+	@param ctxt context.Context - the calling context
+	@return nil if ready, or an error otherwise
+*/
+func (c *authenticationClientImpl) Ready(ctxt context.Context) error {
+	return c.callJSON(ctxt, http.MethodGet, "/liveness/ready", nil, nil)
+}
+
+/*
+Authenticate check whether a REST API call is authenticated
+
+	@param ctxt context.Context - the calling context
+	@param request AuthenticationRequest - the REST API call to authenticate
+	@return the user parameters, and whether the call is authenticated. An error is
+	returned only if the check could not be completed. The user parameters are empty if
+	the call bypassed authentication.
+*/
+func (c *authenticationClientImpl) Authenticate(
+	ctxt context.Context, request AuthenticationRequest,
+) (AuthenticatedUser, bool, error) {
+	headers := map[string]string{
+		c.reqHeaders.Host:   request.Host,
+		c.reqHeaders.Path:   request.Path,
+		c.reqHeaders.Method: request.Method,
+	}
+	if request.BearerToken != "" {
+		headers["Authorization"] = fmt.Sprintf("Bearer %s", request.BearerToken)
+	}
+
+	resp, err := c.call(ctxt, http.MethodGet, "/v1/authenticate", nil, headers, nil)
+	if err != nil {
+		return AuthenticatedUser{}, false, err
+	}
+	switch resp.statusCode {
+	case http.StatusOK:
+		return AuthenticatedUser{
+			UserID:    resp.header.Get(c.respHeaders.UserID),
+			Username:  resp.header.Get(c.respHeaders.Username),
+			FirstName: resp.header.Get(c.respHeaders.FirstName),
+			LastName:  resp.header.Get(c.respHeaders.LastName),
+			Email:     resp.header.Get(c.respHeaders.Email),
+		}, true, nil
+	case http.StatusUnauthorized:
+		return AuthenticatedUser{}, false, nil
+	default:
+		return AuthenticatedUser{}, false, parseAPIError(resp)
+	}
+}
+
+/*
+IntrospectToken introspect a token through Padlock, so the caller need not hold its own
+OpenID issuer client credentials
+
+	@param ctxt context.Context - the calling context
+	@param token string - the token
+	@return the introspection result. An error is returned only if the introspection could
+	not be completed.
+*/
+func (c *authenticationClientImpl) IntrospectToken(
+	ctxt context.Context, token string,
+) (TokenIntrospection, error) {
+	credentials := base64.StdEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%s:%s", c.introspectClientID, c.introspectSecret)),
+	)
+	raw, err := c.call(
+		ctxt,
+		http.MethodPost,
+		"/v1/token/introspect",
+		nil,
+		map[string]string{"Authorization": fmt.Sprintf("Basic %s", credentials)},
+		apis.ReqIntrospectToken{Token: token},
+	)
+	if err != nil {
+		return TokenIntrospection{}, err
+	}
+	if raw.statusCode != http.StatusOK {
+		return TokenIntrospection{}, parseAPIError(raw)
+	}
+	var resp apis.RespIntrospectToken
+	if err := json.Unmarshal(raw.body, &resp); err != nil {
+		return TokenIntrospection{}, err
+	}
+	result := TokenIntrospection{Active: resp.Active, Reason: string(resp.Reason)}
+	if resp.User != nil {
+		result.User.UserID = resp.User.UserID
+		if resp.User.Username != nil {
+			result.User.Username = *resp.User.Username
+		}
+		if resp.User.FirstName != nil {
+			result.User.FirstName = *resp.User.FirstName
+		}
+		if resp.User.LastName != nil {
+			result.User.LastName = *resp.User.LastName
+		}
+		if resp.User.Email != nil {
+			result.User.Email = *resp.User.Email
+		}
+	}
+	if resp.Expire > 0 {
+		result.Expire = time.Unix(resp.Expire, 0).UTC()
+	}
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/authenticate"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/padlocktest"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticationClientIntrospectToken(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	router := http.NewServeMux()
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	issuer, err := padlocktest.DefineOpenIDIssuer(testServer.URL+"/issuer", time.Minute, nil)
+	assert.Nil(err)
+	router.Handle("/issuer/", http.StripPrefix("/issuer", issuer))
+
+	clientID := "padlock"
+	clientSecret := "secret"
+	oidClient, err := apis.DefineOpenIDIssuerClient(common.OpenIDIssuerConfig{
+		Issuer: testServer.URL + "/issuer", ClientID: &clientID, ClientCred: &clientSecret,
+	})
+	assert.Nil(err)
+
+	emailClaim := "email"
+	audience := "unit-test"
+	respHeaders := common.AuthorizeRequestParamLocConfig{
+		UserID: "X-Caller-UserID", Email: "X-Caller-Email",
+	}
+	svr, err := apis.BuildAuthenticationServer(
+		common.APIServerConfig{
+			APIs: common.APIConfig{
+				Endpoint: common.EndpointConfig{PathPrefix: "/authn"},
+				RequestLogging: common.HTTPRequestLogging{
+					DoNotLogHeaders: []string{}, RequestIDHeader: DefaultRequestIDHeader,
+				},
+			},
+		},
+		oidClient,
+		true,
+		authenticate.DefineIntrospector(
+			authenticate.DefineTokenCache(time.Minute, time.Minute, 0, nil),
+			oidClient.IntrospectToken,
+			nil,
+			nil,
+			false,
+		),
+		common.AuthenticationConfig{
+			TargetAudience: &audience,
+			TargetClaims: common.OpenIDClaimsOfInterestConfig{
+				UserIDClaim: "sub", EmailClaim: &emailClaim,
+			},
+			IntrospectEndpoint: common.IntrospectEndpointConfig{
+				Enabled: true,
+				Clients: []common.IntrospectClientConfig{{ID: "backend", Secret: "backend-secret"}},
+			},
+		},
+		respHeaders,
+		nil,
+		nil,
+		nil,
+		common.DefineReadinessGate(),
+		nil,
+		common.DefineInFlightTracker(nil),
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+	)
+	assert.Nil(err)
+	router.Handle("/authn/", svr.Handler)
+
+	uut, err := DefineAuthenticationClient(
+		Config{
+			BaseURL:                testServer.URL + "/authn",
+			IntrospectClientID:     "backend",
+			IntrospectClientSecret: "backend-secret",
+		},
+		common.AuthenticateRequestParamLocConfig{},
+		respHeaders,
+	)
+	assert.Nil(err)
+	ctxt := context.Background()
+
+	// Case 0: active token
+	{
+		token, err := issuer.IssueToken(
+			"alice", map[string]interface{}{"aud": audience, "email": "alice@testing.org"},
+		)
+		assert.Nil(err)
+		result, err := uut.IntrospectToken(ctxt, token)
+		assert.Nil(err)
+		assert.True(result.Active)
+		assert.Empty(result.Reason)
+		assert.Equal("alice", result.User.UserID)
+		assert.Equal("alice@testing.org", result.User.Email)
+		assert.WithinDuration(time.Now().Add(time.Minute), result.Expire, time.Second*5)
+	}
+
+	// Case 1: token for a different audience
+	{
+		token, err := issuer.IssueToken(
+			"alice", map[string]interface{}{"aud": "other", "email": "alice@testing.org"},
+		)
+		assert.Nil(err)
+		result, err := uut.IntrospectToken(ctxt, token)
+		assert.Nil(err)
+		assert.False(result.Active)
+		assert.Equal(string(apis.ErrCodeClaimInvalid), result.Reason)
+		assert.Empty(result.User.UserID)
+	}
+
+	// Case 2: token not issued by the issuer
+	{
+		other, err := padlocktest.DefineOpenIDIssuer(testServer.URL+"/issuer", time.Minute, nil)
+		assert.Nil(err)
+		token, err := other.IssueToken("alice", map[string]interface{}{"aud": audience})
+		assert.Nil(err)
+		result, err := uut.IntrospectToken(ctxt, token)
+		assert.Nil(err)
+		assert.False(result.Active)
+		assert.Equal(string(apis.ErrCodeTokenInvalid), result.Reason)
+	}
+
+	// Case 3: missing token
+	{
+		_, err := uut.IntrospectToken(ctxt, "")
+		assert.NotNil(err)
+	}
+
+	// Case 4: the backend client credentials are not accepted
+	{
+		other, err := DefineAuthenticationClient(
+			Config{
+				BaseURL:                testServer.URL + "/authn",
+				IntrospectClientID:     "backend",
+				IntrospectClientSecret: "wrong-secret",
+			},
+			common.AuthenticateRequestParamLocConfig{},
+			respHeaders,
+		)
+		assert.Nil(err)
+		token, err := issuer.IssueToken("alice", map[string]interface{}{"aud": audience})
+		assert.Nil(err)
+		_, err = other.IntrospectToken(ctxt, token)
+		assert.NotNil(err)
+		apiErr, ok := err.(*APIError)
+		assert.True(ok)
+		assert.Equal(http.StatusUnauthorized, apiErr.StatusCode)
+		assert.Equal(apis.ErrCodeClientInvalid, apiErr.Code)
+	}
+}
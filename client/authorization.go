@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/common"
+)
+
+// AuthorizationRequest describes the REST API call to authorize
+type AuthorizationRequest struct {
+	// Host is the host / FQDN of the request being authorized
+	Host string
+	// Path is the URI path of the request being authorized
+	Path string
+	// Method is the HTTP method of the request being authorized
+	Method string
+	// UserID is the user ID of the user making the request
+	UserID string
+	// Username is the optional username of the user making the request
+	Username string
+	// FirstName is the optional first name / given name of the user making the request
+	FirstName string
+	// LastName is the optional last name / surname / family name of the user making the request
+	LastName string
+	// Email is the optional email of the user making the request
+	Email string
+}
+
+// DeniedError reports an authorization check which Padlock answered with a denial other than
+// 403: 400 when the request matches no rule, 401, or 429 when a quota or rate limit is hit.
+// Unlike transport errors and 5xx responses, it is a definite answer, and must not be treated
+// as a failure to complete the check.
+type DeniedError struct {
+	*APIError
+}
+
+// Unwrap returns the underlying APIError
+func (e *DeniedError) Unwrap() error {
+	return e.APIError
+}
+
+/*
+IsDenied check whether an error returned by AuthorizationClient.Allow is a denial by Padlock,
+rather than a failure to complete the check
+
+	@param err error - the error
+	@return whether the error is a DeniedError
+*/
+func IsDenied(err error) bool {
+	var denied *DeniedError
+	return errors.As(err, &denied)
+}
+
+// AuthorizationClient is the client for the Padlock authorization API
+type AuthorizationClient interface {
+	/*
+		Ready check whether the authorization API is ready
+
+		 @param ctxt context.Context - the calling context
+		 @return nil if ready, or an error otherwise
+	*/
+	Ready(ctxt context.Context) error
+
+	/*
+		Allow check whether a user is allowed to make a REST API call
+
+		 @param ctxt context.Context - the calling context
+		 @param request AuthorizationRequest - the REST API call to authorize
+		 @return whether the call is allowed. A 403 is returned as not allowed with no error.
+		 Other denials are returned as not allowed with a *DeniedError. Any other error means
+		 the check could not be completed.
+	*/
+	Allow(ctxt context.Context, request AuthorizationRequest) (bool, error)
+}
+
+// authorizationClientImpl implements AuthorizationClient
+type authorizationClientImpl struct {
+	baseClient
+	paramHeaders common.AuthorizeRequestParamLocConfig
+}
+
+/*
+DefineAuthorizationClient define a new authorization API client
+
+	@param cfg Config - the client config
+	@param paramHeaders common.AuthorizeRequestParamLocConfig - the headers Padlock reads the
+	parameters of the REST API call to authorize from. This must match the Padlock configuration.
+	@return new AuthorizationClient instance
+*/
+func DefineAuthorizationClient(
+	cfg Config, paramHeaders common.AuthorizeRequestParamLocConfig,
+) (AuthorizationClient, error) {
+	base, err := defineBaseClient(cfg, "authorization")
+	if err != nil {
+		return nil, err
+	}
+	return &authorizationClientImpl{baseClient: base, paramHeaders: paramHeaders}, nil
+}
+
+/*
+Ready check whether the authorization API is ready
+
+	@param ctxt context.Context - the calling context
+	@return nil if ready, or an error otherwise
+*/
+func (c *authorizationClientImpl) Ready(ctxt context.Context) error {
+	return c.callJSON(ctxt, http.MethodGet, "/liveness/ready", nil, nil)
+}
+
+/*
+Allow check whether a user is allowed to make a REST API call
+
+	@param ctxt context.Context - the calling context
+	@param request AuthorizationRequest - the REST API call to authorize
+	@return whether the call is allowed. A 403 is returned as not allowed with no error.
+	Other denials are returned as not allowed with a *DeniedError. Any other error means
+	the check could not be completed.
+*/
+func (c *authorizationClientImpl) Allow(
+	ctxt context.Context, request AuthorizationRequest,
+) (bool, error) {
+	headers := map[string]string{
+		c.paramHeaders.Host:   request.Host,
+		c.paramHeaders.Path:   request.Path,
+		c.paramHeaders.Method: request.Method,
+		c.paramHeaders.UserID: request.UserID,
+	}
+	optional := map[string]string{
+		c.paramHeaders.Username:  request.Username,
+		c.paramHeaders.FirstName: request.FirstName,
+		c.paramHeaders.LastName:  request.LastName,
+		c.paramHeaders.Email:     request.Email,
+	}
+	for header, value := range optional {
+		if value != "" {
+			headers[header] = value
+		}
+	}
+
+	resp, err := c.call(ctxt, http.MethodGet, "/v1/allow", nil, headers, nil)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case resp.statusCode == http.StatusOK:
+		return policyAllows(resp.body), nil
+	case resp.statusCode == http.StatusNoContent:
+		return true, nil
+	case resp.statusCode == http.StatusForbidden:
+		return false, nil
+	case resp.statusCode >= http.StatusBadRequest && resp.statusCode < http.StatusInternalServerError:
+		return false, &DeniedError{APIError: parseAPIError(resp)}
+	default:
+		return false, parseAPIError(resp)
+	}
+}
+
+/*
+policyAllows check the decision in a 200 response. In the "apigateway" response mode, Padlock
+answers denied requests with 200 as well, and a policy document denying the request.
+
+	@param body []byte - the response body
+	@return whether the response allows the request
+*/
+func policyAllows(body []byte) bool {
+	var policy apis.RespAPIGatewayPolicy
+	if err := json.Unmarshal(body, &policy); err != nil {
+		return true
+	}
+	for _, statement := range policy.PolicyDocument.Statement {
+		if statement.Effect != "Allow" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizationClientAllow(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	paramHeaders := common.AuthorizeRequestParamLocConfig{
+		Host:   "X-Forwarded-Host",
+		Path:   "X-Forwarded-Uri",
+		Method: "X-Forwarded-Method",
+		UserID: "X-Caller-UserID",
+	}
+
+	// Test server which answers with a canned response per user
+	responses := map[string]struct {
+		code int
+		body string
+	}{
+		"standard":    {http.StatusOK, `{"success":true}`},
+		"no-content":  {http.StatusNoContent, ""},
+		"gw-allow":    {http.StatusOK, `{"principalId":"gw-allow","policyDocument":{"Version":"2012-10-17","Statement":[{"Action":"execute-api:Invoke","Effect":"Allow","Resource":"*"}]}}`},
+		"gw-deny":     {http.StatusOK, `{"principalId":"gw-deny","policyDocument":{"Version":"2012-10-17","Statement":[{"Action":"execute-api:Invoke","Effect":"Deny","Resource":"*"}]}}`},
+		"forbidden":   {http.StatusForbidden, `{"success":false}`},
+		"no-rule":     {http.StatusBadRequest, `{"success":false,"code":"rule_not_found"}`},
+		"unknown":     {http.StatusUnauthorized, `{"success":false}`},
+		"rate-limit":  {http.StatusTooManyRequests, `{"success":false}`},
+		"unavailable": {http.StatusInternalServerError, `{"success":false}`},
+	}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responses[r.Header.Get(paramHeaders.UserID)]
+		w.WriteHeader(resp.code)
+		_, _ = w.Write([]byte(resp.body))
+	}))
+	defer testServer.Close()
+
+	uut, err := DefineAuthorizationClient(Config{BaseURL: testServer.URL}, paramHeaders)
+	assert.Nil(err)
+
+	check := func(userID string) (bool, error) {
+		return uut.Allow(context.Background(), AuthorizationRequest{
+			Host: "unit-test.org", Path: "/", Method: "GET", UserID: userID,
+		})
+	}
+
+	// Case 0: allowed
+	for _, userID := range []string{"standard", "no-content", "gw-allow"} {
+		allowed, err := check(userID)
+		assert.Nil(err, userID)
+		assert.True(allowed, userID)
+	}
+
+	// Case 1: denied in the "apigateway" response mode
+	{
+		allowed, err := check("gw-deny")
+		assert.Nil(err)
+		assert.False(allowed)
+	}
+
+	// Case 2: denied with 403
+	{
+		allowed, err := check("forbidden")
+		assert.Nil(err)
+		assert.False(allowed)
+	}
+
+	// Case 3: denied with other 4xx
+	for _, userID := range []string{"no-rule", "unknown", "rate-limit"} {
+		allowed, err := check(userID)
+		assert.False(allowed, userID)
+		assert.NotNil(err, userID)
+		assert.True(IsDenied(err), userID)
+		denied, ok := err.(*DeniedError)
+		assert.True(ok, userID)
+		assert.Equal(responses[userID].code, denied.StatusCode, userID)
+	}
+	{
+		_, err := check("no-rule")
+		assert.Equal(apis.ErrorCode("rule_not_found"), err.(*DeniedError).Code)
+	}
+
+	// Case 4: server failure
+	{
+		allowed, err := check("unavailable")
+		assert.False(allowed)
+		assert.NotNil(err)
+		assert.False(IsDenied(err))
+		apiErr, ok := err.(*APIError)
+		assert.True(ok)
+		assert.Equal(http.StatusInternalServerError, apiErr.StatusCode)
+	}
+
+	// Case 5: transport failure
+	{
+		other, err := DefineAuthorizationClient(Config{BaseURL: "http://127.0.0.1:1"}, paramHeaders)
+		assert.Nil(err)
+		allowed, err := other.Allow(context.Background(), AuthorizationRequest{
+			Host: "unit-test.org", Path: "/", Method: "GET", UserID: "standard",
+		})
+		assert.False(allowed)
+		assert.NotNil(err)
+		assert.False(IsDenied(err))
+	}
+}
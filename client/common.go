@@ -0,0 +1,329 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/apis"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+)
+
+// DefaultRequestIDHeader is the HTTP header Padlock reads the API request ID from by default
+const DefaultRequestIDHeader = "Padlock-Request-ID"
+
+// RetryPolicy defines how failed API calls are retried with exponential backoff
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts. Values less than 2 disable retries.
+	MaxAttempts int
+	// InitialInterval is the wait before the first retry
+	InitialInterval time.Duration
+	// MaxInterval is the max wait between retries
+	MaxInterval time.Duration
+}
+
+// Config is the Padlock API client config
+type Config struct {
+	// BaseURL is the base URL of the Padlock API server, including any path prefix
+	BaseURL string
+	// RequestIDHeader is the HTTP header to send the API request ID on. Defaults to
+	// DefaultRequestIDHeader.
+	RequestIDHeader string
+	// Retry is the retry policy for API calls. Only idempotent calls are retried, and only
+	// on transport errors or 5xx responses.
+	Retry RetryPolicy
+	// HTTPClient is the HTTP client to use. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// IntrospectClientID is the client ID the backend introspects tokens with. Only used by
+	// AuthenticationClient.IntrospectToken.
+	IntrospectClientID string
+	// IntrospectClientSecret is the client secret the backend introspects tokens with
+	IntrospectClientSecret string
+}
+
+// APIError is an error response from the Padlock API
+type APIError struct {
+	// StatusCode is the HTTP response code
+	StatusCode int
+	// RequestID is the API request ID
+	RequestID string
+	// Code is the machine-readable error code reported by Padlock
+	Code apis.ErrorCode
+	// Message is the error message reported by Padlock
+	Message string
+	// Detail is additional detail on the error reported by Padlock
+	Detail string
+}
+
+// Error implements error
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("padlock API error %d", e.StatusCode)
+	if e.Code != "" {
+		msg = fmt.Sprintf("%s %s", msg, e.Code)
+	}
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+	if e.Detail != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Detail)
+	}
+	if e.RequestID != "" {
+		msg = fmt.Sprintf("%s [request %s]", msg, e.RequestID)
+	}
+	return msg
+}
+
+// requestIDKey is the context key for the API request ID
+type requestIDKey struct{}
+
+/*
+WithRequestID attach an API request ID to a context. API calls made with this context will
+send the request ID to Padlock, so the calls can be matched against the Padlock logs.
+
+	@param ctxt context.Context - the parent context
+	@param requestID string - the request ID
+	@return the new context
+*/
+func WithRequestID(ctxt context.Context, requestID string) context.Context {
+	return context.WithValue(ctxt, requestIDKey{}, requestID)
+}
+
+/*
+RequestIDFromContext get the API request ID attached to a context
+
+	@param ctxt context.Context - the context
+	@return the request ID, and whether one is present
+*/
+func RequestIDFromContext(ctxt context.Context) (string, bool) {
+	requestID, ok := ctxt.Value(requestIDKey{}).(string)
+	return requestID, ok && requestID != ""
+}
+
+// baseClient is the common HTTP client logic shared by the Padlock API clients
+type baseClient struct {
+	goutils.Component
+	baseURL         *url.URL
+	requestIDHeader string
+	retry           RetryPolicy
+	httpClient      *http.Client
+}
+
+// defineBaseClient define a new baseClient
+func defineBaseClient(cfg Config, instance string) (baseClient, error) {
+	baseURL, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return baseClient{}, err
+	}
+	if baseURL.Scheme == "" || baseURL.Host == "" {
+		return baseClient{}, fmt.Errorf("base URL '%s' is not absolute", cfg.BaseURL)
+	}
+	requestIDHeader := cfg.RequestIDHeader
+	if requestIDHeader == "" {
+		requestIDHeader = DefaultRequestIDHeader
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return baseClient{
+		Component: goutils.Component{
+			LogTags: log.Fields{
+				"module": "client", "component": "padlock-client", "instance": instance,
+			},
+		},
+		baseURL:         baseURL,
+		requestIDHeader: requestIDHeader,
+		retry:           cfg.Retry,
+		httpClient:      httpClient,
+	}, nil
+}
+
+// apiResponse is the raw result of one API call
+type apiResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+/*
+call make an API call, retrying according to the retry policy
+
+	@param ctxt context.Context - the calling context
+	@param method string - the HTTP method
+	@param path string - the API path, relative to the base URL
+	@param query url.Values - the query parameters, if any
+	@param headers map[string]string - additional request headers, if any
+	@param payload interface{} - the request body to send as JSON, if any
+	@return the API response
+*/
+func (c baseClient) call(
+	ctxt context.Context,
+	method string,
+	path string,
+	query url.Values,
+	headers map[string]string,
+	payload interface{},
+) (apiResponse, error) {
+	logTags := c.GetLogTagsForContext(ctxt)
+
+	requestID, ok := RequestIDFromContext(ctxt)
+	if !ok {
+		requestID = uuid.NewString()
+	}
+	logTags["request_id"] = requestID
+
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to serialize request")
+			return apiResponse{}, err
+		}
+	}
+
+	target := c.baseURL.JoinPath(path)
+	if query != nil {
+		target.RawQuery = query.Encode()
+	}
+
+	attempt := func() (apiResponse, error) {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctxt, method, target.String(), reqBody)
+		if err != nil {
+			return apiResponse{}, err
+		}
+		req.Header.Set(c.requestIDHeader, requestID)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return apiResponse{}, err
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return apiResponse{}, err
+		}
+		return apiResponse{statusCode: resp.StatusCode, header: resp.Header, body: respBody}, nil
+	}
+
+	// Only retry idempotent calls
+	maxAttempts := 1
+	if method != http.MethodPost && c.retry.MaxAttempts > 1 {
+		maxAttempts = c.retry.MaxAttempts
+	}
+	wait := c.retry.InitialInterval
+	for itr := 1; ; itr++ {
+		resp, err := attempt()
+		if err == nil && resp.statusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if itr >= maxAttempts {
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("%s %s failed", method, path)
+			}
+			return resp, err
+		}
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Warnf("%s %s attempt %d failed, retrying in %s", method, path, itr, wait)
+		} else {
+			log.WithFields(logTags).
+				Warnf("%s %s attempt %d returned %d, retrying in %s", method, path, itr, resp.statusCode, wait)
+		}
+		select {
+		case <-ctxt.Done():
+			return apiResponse{}, ctxt.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if c.retry.MaxInterval > 0 && wait > c.retry.MaxInterval {
+			wait = c.retry.MaxInterval
+		}
+	}
+}
+
+/*
+callJSON make an API call, and parse the response
+
+	@param ctxt context.Context - the calling context
+	@param method string - the HTTP method
+	@param path string - the API path, relative to the base URL
+	@param payload interface{} - the request body to send as JSON, if any
+	@param result interface{} - if provided, the object to parse the response into
+	@return whether successful. An *APIError is returned if Padlock responded with an error.
+*/
+func (c baseClient) callJSON(
+	ctxt context.Context, method string, path string, payload interface{}, result interface{},
+) error {
+	return c.callJSONWithQuery(ctxt, method, path, nil, payload, result)
+}
+
+/*
+callJSONWithQuery make an API call with query parameters, and parse the response
+
+	@param ctxt context.Context - the calling context
+	@param method string - the HTTP method
+	@param path string - the API path, relative to the base URL
+	@param query url.Values - the query parameters, if any
+	@param payload interface{} - the request body to send as JSON, if any
+	@param result interface{} - if provided, the object to parse the response into
+	@return whether successful. An *APIError is returned if Padlock responded with an error.
+*/
+func (c baseClient) callJSONWithQuery(
+	ctxt context.Context,
+	method string,
+	path string,
+	query url.Values,
+	payload interface{},
+	result interface{},
+) error {
+	resp, err := c.call(ctxt, method, path, query, nil, payload)
+	if err != nil {
+		return err
+	}
+	if resp.statusCode != http.StatusOK {
+		return parseAPIError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.body, result)
+}
+
+/*
+parseAPIError convert an error response from Padlock into an APIError
+
+	@param resp apiResponse - the error response
+	@return the APIError
+*/
+func parseAPIError(resp apiResponse) *APIError {
+	apiErr := &APIError{StatusCode: resp.statusCode}
+	var parsed apis.RespError
+	if err := json.Unmarshal(resp.body, &parsed); err != nil {
+		apiErr.Message = strings.TrimSpace(string(resp.body))
+		return apiErr
+	}
+	apiErr.RequestID = parsed.RequestID
+	apiErr.Code = parsed.Code
+	if parsed.Error != nil {
+		apiErr.Message = parsed.Error.Msg
+		apiErr.Detail = parsed.Error.Detail
+	}
+	return apiErr
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRetry(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	paramHeaders := common.AuthorizeRequestParamLocConfig{
+		Host:      "X-Forwarded-Host",
+		Path:      "X-Forwarded-Uri",
+		Method:    "X-Forwarded-Method",
+		UserID:    "X-Caller-UserID",
+		Username:  "X-Caller-Username",
+		FirstName: "X-Caller-Firstname",
+		LastName:  "X-Caller-Lastname",
+		Email:     "X-Caller-Email",
+	}
+
+	// Test server which fails the first few requests
+	lock := sync.Mutex{}
+	failuresLeft := 0
+	requestIDs := []string{}
+	allowedUser := uuid.NewString()
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requestIDs = append(requestIDs, r.Header.Get(DefaultRequestIDHeader))
+		if failuresLeft > 0 {
+			failuresLeft--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(paramHeaders.UserID) != allowedUser {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	uut, err := DefineAuthorizationClient(Config{
+		BaseURL: testServer.URL,
+		Retry: RetryPolicy{
+			MaxAttempts:     3,
+			InitialInterval: time.Millisecond * 10,
+			MaxInterval:     time.Millisecond * 20,
+		},
+	}, paramHeaders)
+	assert.Nil(err)
+
+	resetServer := func(failures int) {
+		lock.Lock()
+		defer lock.Unlock()
+		failuresLeft = failures
+		requestIDs = []string{}
+	}
+
+	// Case 0: no failures
+	resetServer(0)
+	{
+		allowed, err := uut.Allow(context.Background(), AuthorizationRequest{
+			Host: "unit-test.org", Path: "/", Method: "GET", UserID: allowedUser,
+		})
+		assert.Nil(err)
+		assert.True(allowed)
+		allowed, err = uut.Allow(context.Background(), AuthorizationRequest{
+			Host: "unit-test.org", Path: "/", Method: "GET", UserID: uuid.NewString(),
+		})
+		assert.Nil(err)
+		assert.False(allowed)
+	}
+
+	// Case 1: recovers within the retry limit, with the same request ID
+	resetServer(2)
+	{
+		rid := uuid.NewString()
+		allowed, err := uut.Allow(
+			WithRequestID(context.Background(), rid),
+			AuthorizationRequest{
+				Host: "unit-test.org", Path: "/", Method: "GET", UserID: allowedUser,
+			},
+		)
+		assert.Nil(err)
+		assert.True(allowed)
+		lock.Lock()
+		assert.EqualValues([]string{rid, rid, rid}, requestIDs)
+		lock.Unlock()
+	}
+
+	// Case 2: exceeds the retry limit
+	resetServer(3)
+	{
+		_, err := uut.Allow(context.Background(), AuthorizationRequest{
+			Host: "unit-test.org", Path: "/", Method: "GET", UserID: allowedUser,
+		})
+		assert.NotNil(err)
+		apiErr, ok := err.(*APIError)
+		assert.True(ok)
+		assert.Equal(http.StatusServiceUnavailable, apiErr.StatusCode)
+		lock.Lock()
+		assert.Len(requestIDs, 3)
+		lock.Unlock()
+	}
+
+	// Case 3: invalid base URL
+	{
+		_, err := DefineAuthorizationClient(Config{BaseURL: "not-a-url"}, paramHeaders)
+		assert.NotNil(err)
+	}
+}
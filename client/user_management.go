@@ -0,0 +1,453 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+)
+
+// UserManagementClient is the client for the Padlock user management API
+type UserManagementClient interface {
+	/*
+		Ready check whether the user management API is ready
+
+		 @param ctxt context.Context - the calling context
+		 @return nil if ready, or an error otherwise
+	*/
+	Ready(ctxt context.Context) error
+
+	/*
+		ListAllRoles list all roles the system is operating against
+
+		 @param ctxt context.Context - the calling context
+		 @return the roles
+	*/
+	ListAllRoles(ctxt context.Context) (map[string]common.UserRoleConfig, error)
+
+	/*
+		ListRolesWithPermission list the roles which grant a permission
+
+		 @param ctxt context.Context - the calling context
+		 @param permission string - the permission
+		 @return the roles
+	*/
+	ListRolesWithPermission(
+		ctxt context.Context, permission string,
+	) (map[string]common.UserRoleConfig, error)
+
+	/*
+		GetRole get info on one role
+
+		 @param ctxt context.Context - the calling context
+		 @param roleName string - the role name
+		 @return the role, and the users assigned this role
+	*/
+	GetRole(ctxt context.Context, roleName string) (common.UserRoleConfig, []models.UserInfo, error)
+
+	/*
+		DefineUser define a new user
+
+		 @param ctxt context.Context - the calling context
+		 @param config models.UserConfig - the new user parameters
+		 @param roles []string - the roles to assign to this user
+		 @return whether successful
+	*/
+	DefineUser(ctxt context.Context, config models.UserConfig, roles []string) error
+
+	/*
+		ListAllUsers list all users the system is managing
+
+		 @param ctxt context.Context - the calling context
+		 @return the users
+	*/
+	ListAllUsers(ctxt context.Context) ([]models.UserInfo, error)
+
+	/*
+		SearchUsers search for users matching all of the provided filters
+
+		 @param ctxt context.Context - the calling context
+		 @param filter models.UserSearchFilter - the search filter
+		 @return the matching users, ordered by user ID
+	*/
+	SearchUsers(ctxt context.Context, filter models.UserSearchFilter) ([]models.UserInfo, error)
+
+	/*
+		GetUser get info on one user
+
+		 @param ctxt context.Context - the calling context
+		 @param userID string - the user ID
+		 @return the user details
+	*/
+	GetUser(ctxt context.Context, userID string) (users.UserDetailsWithPermission, error)
+
+	/*
+		GetUserPermissions get the effective permissions of one user
+
+		 @param ctxt context.Context - the calling context
+		 @param userID string - the user ID
+		 @param check []string - if provided, the permissions to check the user for
+		 @return the user's permissions, and whether the user has each of the checked permissions
+	*/
+	GetUserPermissions(
+		ctxt context.Context, userID string, check []string,
+	) ([]string, map[string]bool, error)
+
+	/*
+		DeleteUser delete one user
+
+		 @param ctxt context.Context - the calling context
+		 @param userID string - the user ID
+		 @return whether successful
+	*/
+	DeleteUser(ctxt context.Context, userID string) error
+
+	/*
+		UpdateUser update one user's information
+
+		 @param ctxt context.Context - the calling context
+		 @param userID string - the user ID
+		 @param newConfig models.UserConfig - the new user parameters
+		 @return whether successful
+	*/
+	UpdateUser(ctxt context.Context, userID string, newConfig models.UserConfig) error
+
+	/*
+		UpdateUserRoles change one user's roles
+
+		 @param ctxt context.Context - the calling context
+		 @param userID string - the user ID
+		 @param roles []string - the user's new roles
+		 @return whether successful
+	*/
+	UpdateUserRoles(ctxt context.Context, userID string, roles []string) error
+
+	/*
+		UpdateUserPermissions change the permissions granted directly to one user
+
+		 @param ctxt context.Context - the calling context
+		 @param userID string - the user ID
+		 @param permissions []string - the user's new directly granted permissions
+		 @return whether successful
+	*/
+	UpdateUserPermissions(ctxt context.Context, userID string, permissions []string) error
+
+	/*
+		GetFeatureToggles get the state of the runtime feature toggles
+
+		 @param ctxt context.Context - the calling context
+		 @return the toggle state
+	*/
+	GetFeatureToggles(ctxt context.Context) (common.FeatureToggleState, error)
+
+	/*
+		UpdateFeatureToggles change the runtime feature toggles
+
+		 @param ctxt context.Context - the calling context
+		 @param update common.FeatureToggleUpdate - the toggles to change
+		 @return the toggle state after the update
+	*/
+	UpdateFeatureToggles(
+		ctxt context.Context, update common.FeatureToggleUpdate,
+	) (common.FeatureToggleState, error)
+}
+
+// userManagementClientImpl implements UserManagementClient
+type userManagementClientImpl struct {
+	baseClient
+}
+
+/*
+DefineUserManagementClient define a new user management API client
+
+	@param cfg Config - the client config
+	@return new UserManagementClient instance
+*/
+func DefineUserManagementClient(cfg Config) (UserManagementClient, error) {
+	base, err := defineBaseClient(cfg, "user-management")
+	if err != nil {
+		return nil, err
+	}
+	return &userManagementClientImpl{baseClient: base}, nil
+}
+
+/*
+Ready check whether the user management API is ready
+
+	@param ctxt context.Context - the calling context
+	@return nil if ready, or an error otherwise
+*/
+func (c *userManagementClientImpl) Ready(ctxt context.Context) error {
+	return c.callJSON(ctxt, http.MethodGet, "/liveness/ready", nil, nil)
+}
+
+/*
+ListAllRoles list all roles the system is operating against
+
+	@param ctxt context.Context - the calling context
+	@return the roles
+*/
+func (c *userManagementClientImpl) ListAllRoles(
+	ctxt context.Context,
+) (map[string]common.UserRoleConfig, error) {
+	var resp apis.RespListAllRoles
+	if err := c.callJSON(ctxt, http.MethodGet, "/v1/role", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Roles, nil
+}
+
+/*
+ListRolesWithPermission list the roles which grant a permission
+
+	@param ctxt context.Context - the calling context
+	@param permission string - the permission
+	@return the roles
+*/
+func (c *userManagementClientImpl) ListRolesWithPermission(
+	ctxt context.Context, permission string,
+) (map[string]common.UserRoleConfig, error) {
+	var resp apis.RespListAllRoles
+	if err := c.callJSONWithQuery(
+		ctxt, http.MethodGet, "/v1/role", url.Values{"permission": []string{permission}}, nil, &resp,
+	); err != nil {
+		return nil, err
+	}
+	return resp.Roles, nil
+}
+
+/*
+GetRole get info on one role
+
+	@param ctxt context.Context - the calling context
+	@param roleName string - the role name
+	@return the role, and the users assigned this role
+*/
+func (c *userManagementClientImpl) GetRole(
+	ctxt context.Context, roleName string,
+) (common.UserRoleConfig, []models.UserInfo, error) {
+	var resp apis.RespRoleInfo
+	if err := c.callJSON(
+		ctxt, http.MethodGet, "/v1/role/"+url.PathEscape(roleName), nil, &resp,
+	); err != nil {
+		return common.UserRoleConfig{}, nil, err
+	}
+	return resp.Role, resp.AssignedUsers, nil
+}
+
+/*
+DefineUser define a new user
+
+	@param ctxt context.Context - the calling context
+	@param config models.UserConfig - the new user parameters
+	@param roles []string - the roles to assign to this user
+	@return whether successful
+*/
+func (c *userManagementClientImpl) DefineUser(
+	ctxt context.Context, config models.UserConfig, roles []string,
+) error {
+	return c.callJSON(
+		ctxt, http.MethodPost, "/v1/user", apis.ReqNewUserParams{User: config, Roles: roles}, nil,
+	)
+}
+
+/*
+ListAllUsers list all users the system is managing
+
+	@param ctxt context.Context - the calling context
+	@return the users
+*/
+func (c *userManagementClientImpl) ListAllUsers(ctxt context.Context) ([]models.UserInfo, error) {
+	var resp apis.RespListAllUsers
+	if err := c.callJSON(ctxt, http.MethodGet, "/v1/user", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+/*
+SearchUsers search for users matching all of the provided filters
+
+	@param ctxt context.Context - the calling context
+	@param filter models.UserSearchFilter - the search filter
+	@return the matching users, ordered by user ID
+*/
+func (c *userManagementClientImpl) SearchUsers(
+	ctxt context.Context, filter models.UserSearchFilter,
+) ([]models.UserInfo, error) {
+	query := url.Values{}
+	if filter.UserIDPrefix != "" {
+		query.Set("user_id_prefix", filter.UserIDPrefix)
+	}
+	if filter.Username != "" {
+		query.Set("username", filter.Username)
+	}
+	if filter.Email != "" {
+		query.Set("email", filter.Email)
+	}
+	if filter.Role != "" {
+		query.Set("role", filter.Role)
+	}
+	if filter.AccountType != "" {
+		query.Set("account_type", filter.AccountType)
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	var resp apis.RespListAllUsers
+	if err := c.callJSONWithQuery(
+		ctxt, http.MethodGet, "/v1/user/search", query, nil, &resp,
+	); err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+/*
+GetUser get info on one user
+
+	@param ctxt context.Context - the calling context
+	@param userID string - the user ID
+	@return the user details
+*/
+func (c *userManagementClientImpl) GetUser(
+	ctxt context.Context, userID string,
+) (users.UserDetailsWithPermission, error) {
+	var resp apis.RespUserInfo
+	if err := c.callJSON(
+		ctxt, http.MethodGet, "/v1/user/"+url.PathEscape(userID), nil, &resp,
+	); err != nil {
+		return users.UserDetailsWithPermission{}, err
+	}
+	return resp.User, nil
+}
+
+/*
+GetUserPermissions get the effective permissions of one user
+
+	@param ctxt context.Context - the calling context
+	@param userID string - the user ID
+	@param check []string - if provided, the permissions to check the user for
+	@return the user's permissions, and whether the user has each of the checked permissions
+*/
+func (c *userManagementClientImpl) GetUserPermissions(
+	ctxt context.Context, userID string, check []string,
+) ([]string, map[string]bool, error) {
+	var query url.Values
+	if len(check) > 0 {
+		query = url.Values{"check": []string{strings.Join(check, ",")}}
+	}
+	var resp apis.RespUserPermissions
+	if err := c.callJSONWithQuery(
+		ctxt, http.MethodGet, "/v1/user/"+url.PathEscape(userID)+"/permissions", query, nil, &resp,
+	); err != nil {
+		return nil, nil, err
+	}
+	return resp.Permissions, resp.Checks, nil
+}
+
+/*
+DeleteUser delete one user
+
+	@param ctxt context.Context - the calling context
+	@param userID string - the user ID
+	@return whether successful
+*/
+func (c *userManagementClientImpl) DeleteUser(ctxt context.Context, userID string) error {
+	return c.callJSON(ctxt, http.MethodDelete, "/v1/user/"+url.PathEscape(userID), nil, nil)
+}
+
+/*
+UpdateUser update one user's information
+
+	@param ctxt context.Context - the calling context
+	@param userID string - the user ID
+	@param newConfig models.UserConfig - the new user parameters
+	@return whether successful
+*/
+func (c *userManagementClientImpl) UpdateUser(
+	ctxt context.Context, userID string, newConfig models.UserConfig,
+) error {
+	return c.callJSON(
+		ctxt, http.MethodPut, "/v1/user/"+url.PathEscape(userID), newConfig, nil,
+	)
+}
+
+/*
+UpdateUserRoles change one user's roles
+
+	@param ctxt context.Context - the calling context
+	@param userID string - the user ID
+	@param roles []string - the user's new roles
+	@return whether successful
+*/
+func (c *userManagementClientImpl) UpdateUserRoles(
+	ctxt context.Context, userID string, roles []string,
+) error {
+	return c.callJSON(
+		ctxt,
+		http.MethodPut,
+		"/v1/user/"+url.PathEscape(userID)+"/roles",
+		apis.ReqNewUserRoles{Roles: roles},
+		nil,
+	)
+}
+
+/*
+UpdateUserPermissions change the permissions granted directly to one user
+
+	@param ctxt context.Context - the calling context
+	@param userID string - the user ID
+	@param permissions []string - the user's new directly granted permissions
+	@return whether successful
+*/
+func (c *userManagementClientImpl) UpdateUserPermissions(
+	ctxt context.Context, userID string, permissions []string,
+) error {
+	return c.callJSON(
+		ctxt,
+		http.MethodPut,
+		"/v1/user/"+url.PathEscape(userID)+"/permissions",
+		apis.ReqNewUserPermissions{Permissions: permissions},
+		nil,
+	)
+}
+
+/*
+GetFeatureToggles get the state of the runtime feature toggles
+
+	@param ctxt context.Context - the calling context
+	@return the toggle state
+*/
+func (c *userManagementClientImpl) GetFeatureToggles(
+	ctxt context.Context,
+) (common.FeatureToggleState, error) {
+	var resp apis.RespFeatureToggles
+	if err := c.callJSON(ctxt, http.MethodGet, "/v1/admin/toggles", nil, &resp); err != nil {
+		return common.FeatureToggleState{}, err
+	}
+	return resp.Toggles, nil
+}
+
+/*
+UpdateFeatureToggles change the runtime feature toggles
+
+	@param ctxt context.Context - the calling context
+	@param update common.FeatureToggleUpdate - the toggles to change
+	@return the toggle state after the update
+*/
+func (c *userManagementClientImpl) UpdateFeatureToggles(
+	ctxt context.Context, update common.FeatureToggleUpdate,
+) (common.FeatureToggleState, error) {
+	var resp apis.RespFeatureToggles
+	if err := c.callJSON(ctxt, http.MethodPut, "/v1/admin/toggles", update, &resp); err != nil {
+		return common.FeatureToggleState{}, err
+	}
+	return resp.Toggles, nil
+}
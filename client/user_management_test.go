@@ -0,0 +1,247 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestUserManagementClient(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	mgmtCore, err := users.CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(mgmtCore.Ready())
+
+	// Define test roles
+	roles := []string{"admin", "user"}
+	testRoles := map[string]common.UserRoleConfig{
+		roles[0]: {AssignedPermissions: []string{"admin-read", "read"}},
+		roles[1]: {AssignedPermissions: []string{"read"}},
+	}
+	assert.Nil(mgmtCore.AlignRolesWithConfig(context.Background(), testRoles))
+
+	startup := common.DefineReadinessGate()
+	toggles := common.DefineFeatureToggles(common.FeatureToggleState{}, nil)
+	svr, err := apis.BuildUserManagementServer(
+		common.APIServerConfig{
+			APIs: common.APIConfig{
+				Endpoint: common.EndpointConfig{PathPrefix: "/"},
+				RequestLogging: common.HTTPRequestLogging{
+					DoNotLogHeaders: []string{}, RequestIDHeader: DefaultRequestIDHeader,
+				},
+			},
+		},
+		mgmtCore,
+		supportMatch,
+		nil,
+		nil,
+		startup,
+		toggles,
+		nil,
+		nil,
+		common.ManagementAdminAuthConfig{},
+		nil,
+	)
+	assert.Nil(err)
+	testServer := httptest.NewServer(svr.Handler)
+	defer testServer.Close()
+
+	uut, err := DefineUserManagementClient(Config{BaseURL: testServer.URL})
+	assert.Nil(err)
+
+	// Case 0: not ready
+	{
+		err := uut.Ready(context.Background())
+		assert.NotNil(err)
+		var apiErr *APIError
+		assert.True(errors.As(err, &apiErr))
+		assert.Equal(http.StatusInternalServerError, apiErr.StatusCode)
+		assert.Equal(apis.ErrCodeNotReady, apiErr.Code)
+	}
+	startup.MarkReady()
+	assert.Nil(uut.Ready(context.Background()))
+
+	// Case 1: list roles
+	{
+		allRoles, err := uut.ListAllRoles(context.Background())
+		assert.Nil(err)
+		assert.Len(allRoles, 2)
+		assert.Contains(allRoles, roles[0])
+		assert.Contains(allRoles, roles[1])
+	}
+	{
+		adminRoles, err := uut.ListRolesWithPermission(context.Background(), "admin-read")
+		assert.Nil(err)
+		assert.Len(adminRoles, 1)
+		assert.Contains(adminRoles, roles[0])
+	}
+
+	// Case 2: define a user, with a caller provided request ID
+	testUser := uuid.NewString()
+	{
+		rid := uuid.NewString()
+		err := uut.DefineUser(
+			WithRequestID(context.Background(), rid),
+			models.UserConfig{UserID: testUser},
+			[]string{roles[1]},
+		)
+		assert.Nil(err)
+	}
+
+	// Case 3: read the user back
+	{
+		details, err := uut.GetUser(context.Background(), testUser)
+		assert.Nil(err)
+		assert.Equal(testUser, details.UserID)
+		assert.EqualValues([]string{roles[1]}, details.Roles)
+		assert.EqualValues([]string{"read"}, details.AssociatedPermission)
+
+		_, assigned, err := uut.GetRole(context.Background(), roles[1])
+		assert.Nil(err)
+		assert.Len(assigned, 1)
+		assert.Equal(testUser, assigned[0].UserID)
+	}
+
+	// Case 3a: query the user's effective permissions
+	{
+		perms, checks, err := uut.GetUserPermissions(context.Background(), testUser, nil)
+		assert.Nil(err)
+		assert.EqualValues([]string{"read"}, perms)
+		assert.Nil(checks)
+		_, checks, err = uut.GetUserPermissions(
+			context.Background(), testUser, []string{"read", "admin-read"},
+		)
+		assert.Nil(err)
+		assert.EqualValues(map[string]bool{"read": true, "admin-read": false}, checks)
+	}
+
+	// Case 3b: grant a permission directly to the user
+	{
+		assert.Nil(uut.UpdateUserPermissions(context.Background(), testUser, []string{"admin-read"}))
+		perms, _, err := uut.GetUserPermissions(context.Background(), testUser, nil)
+		assert.Nil(err)
+		assert.ElementsMatch([]string{"read", "admin-read"}, perms)
+		details, err := uut.GetUser(context.Background(), testUser)
+		assert.Nil(err)
+		assert.EqualValues([]string{"admin-read"}, details.Permissions)
+
+		err = uut.UpdateUserPermissions(context.Background(), testUser, []string{"unknown"})
+		assert.NotNil(err)
+		var apiErr *APIError
+		assert.True(errors.As(err, &apiErr))
+		assert.Equal(apis.ErrCodePermissionUnknown, apiErr.Code)
+
+		assert.Nil(uut.UpdateUserPermissions(context.Background(), testUser, []string{}))
+		perms, _, err = uut.GetUserPermissions(context.Background(), testUser, nil)
+		assert.Nil(err)
+		assert.EqualValues([]string{"read"}, perms)
+	}
+
+	// Case 4: update the user
+	{
+		email := "unit-test@example.com"
+		assert.Nil(uut.UpdateUser(
+			context.Background(), testUser, models.UserConfig{UserID: testUser, Email: &email},
+		))
+		assert.Nil(uut.UpdateUserRoles(context.Background(), testUser, roles))
+		details, err := uut.GetUser(context.Background(), testUser)
+		assert.Nil(err)
+		assert.NotNil(details.Email)
+		assert.Equal(email, *details.Email)
+		assert.Len(details.Roles, 2)
+	}
+
+	// Case 4a: search for users
+	{
+		found, err := uut.SearchUsers(
+			context.Background(), models.UserSearchFilter{UserIDPrefix: testUser[:8], Role: roles[0]},
+		)
+		assert.Nil(err)
+		assert.Len(found, 1)
+		assert.Equal(testUser, found[0].UserID)
+		found, err = uut.SearchUsers(
+			context.Background(), models.UserSearchFilter{Email: "unknown@example.com"},
+		)
+		assert.Nil(err)
+		assert.Empty(found)
+		_, err = uut.SearchUsers(context.Background(), models.UserSearchFilter{Email: "not-email"})
+		assert.NotNil(err)
+		var apiErr *APIError
+		assert.True(errors.As(err, &apiErr))
+		assert.Equal(apis.ErrCodeInvalidRequest, apiErr.Code)
+	}
+
+	// Case 5: runtime toggles
+	{
+		dryRun := true
+		state, err := uut.UpdateFeatureToggles(
+			context.Background(), common.FeatureToggleUpdate{DryRun: &dryRun},
+		)
+		assert.Nil(err)
+		assert.True(state.DryRun)
+		state, err = uut.GetFeatureToggles(context.Background())
+		assert.Nil(err)
+		assert.True(state.DryRun)
+	}
+
+	// Case 6: delete the user
+	{
+		allUsers, err := uut.ListAllUsers(context.Background())
+		assert.Nil(err)
+		assert.Len(allUsers, 1)
+		assert.Nil(uut.DeleteUser(context.Background(), testUser))
+		allUsers, err = uut.ListAllUsers(context.Background())
+		assert.Nil(err)
+		assert.Len(allUsers, 0)
+	}
+
+	// Case 7: unknown user
+	{
+		rid := uuid.NewString()
+		_, err := uut.GetUser(WithRequestID(context.Background(), rid), testUser)
+		assert.NotNil(err)
+		var apiErr *APIError
+		assert.True(errors.As(err, &apiErr))
+		assert.Equal(rid, apiErr.RequestID)
+		assert.Equal(apis.ErrCodeUserNotFound, apiErr.Code)
+	}
+
+	// Case 8: unknown role
+	{
+		err := uut.UpdateUserRoles(context.Background(), uuid.NewString(), []string{"unknown"})
+		assert.NotNil(err)
+		var apiErr *APIError
+		assert.True(errors.As(err, &apiErr))
+		assert.Equal(apis.ErrCodeRoleUnknown, apiErr.Code)
+	}
+}
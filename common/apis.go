@@ -3,6 +3,8 @@ package common
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/url"
 
 	"github.com/apex/log"
 )
@@ -14,12 +16,24 @@ type AccessAuthorizeParamKey struct{}
 type AccessAuthorizeParam struct {
 	// UserID is the ID of the user needing access
 	UserID string `json:"user_id" validate:"required,user_id"`
+	// ClientID is the ID of the OAuth2 client making the request on behalf of the user, if known
+	ClientID string `json:"client_id,omitempty"`
 	// Method is the method used
 	Method string `json:"method" validate:"required,oneof=GET HEAD PUT POST PATCH DELETE OPTIONS"`
 	// Path is the request Path needing access check
 	Path string `json:"path" validate:"required,uri"`
 	// Host is the Host needing access check
 	Host string `json:"host" validate:"required,fqdn"`
+	// WebSocket whether the request is a WebSocket upgrade
+	WebSocket bool `json:"websocket,omitempty"`
+	// Attributes are the additional named attributes of the request, such as its scheme or
+	// source address, read from the headers listed in AuthorizeRequestParamLocConfig.Attributes
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Headers are the request headers the authorization rules have conditions on, keyed by
+	// the canonical header name
+	Headers map[string]string `json:"headers,omitempty"`
+	// Scopes are the OAuth2 scopes of the token the request was made with
+	Scopes []string `json:"scopes,omitempty"`
 }
 
 // String implements toString for object
@@ -27,6 +41,38 @@ func (i *AccessAuthorizeParam) String() string {
 	return fmt.Sprintf("%s http://%s%s", i.Method, i.Host, i.Path)
 }
 
+/*
+CanonicalAttributes render a set of named attributes as a string, which is the same for the
+same set of attributes
+
+	@param attributes map[string]string - the named attributes
+	@return the canonical string
+*/
+func CanonicalAttributes(attributes map[string]string) string {
+	values := url.Values{}
+	for name, value := range attributes {
+		values.Set(name, value)
+	}
+	return values.Encode()
+}
+
+/*
+CanonicalHeaders re-key a set of header values by the canonical header names
+
+	@param headers map[string]string - the header values, by header name
+	@return the header values, by canonical header name
+*/
+func CanonicalHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	canonical := map[string]string{}
+	for name, value := range headers {
+		canonical[http.CanonicalHeaderKey(name)] = value
+	}
+	return canonical
+}
+
 /*
 UpdateLogTags updates Apex log.Fields map with values from the parameter
 
@@ -34,9 +80,18 @@ UpdateLogTags updates Apex log.Fields map with values from the parameter
 */
 func (i *AccessAuthorizeParam) UpdateLogTags(tags log.Fields) {
 	tags["auth_user_id"] = i.UserID
+	if i.ClientID != "" {
+		tags["auth_client_id"] = i.ClientID
+	}
 	tags["auth_method"] = i.Method
 	tags["auth_path"] = fmt.Sprintf("'%s'", i.Path)
 	tags["auth_host"] = i.Host
+	if i.WebSocket {
+		tags["auth_websocket"] = true
+	}
+	if len(i.Attributes) > 0 {
+		tags["auth_attributes"] = CanonicalAttributes(i.Attributes)
+	}
 }
 
 /*
@@ -53,3 +108,31 @@ func ModifyLogMetadataByAccessAuthorizeParam(ctxt context.Context, theTags log.F
 		}
 	}
 }
+
+/*
+AuthorizeRequestHost fetch the host of the request being authorized from the request context
+
+	@param ctxt context.Context - a request context
+	@return the host, and whether the context carries one
+*/
+func AuthorizeRequestHost(ctxt context.Context) (string, bool) {
+	v, ok := ctxt.Value(AccessAuthorizeParamKey{}).(AccessAuthorizeParam)
+	if !ok || v.Host == "" {
+		return "", false
+	}
+	return v.Host, true
+}
+
+// CacheBypassKey associated key for marking a request to skip the caches in request context
+type CacheBypassKey struct{}
+
+/*
+CacheBypassRequested whether the request skips the token and decision caches
+
+	@param ctxt context.Context - a request context
+	@return whether the caches are skipped
+*/
+func CacheBypassRequested(ctxt context.Context) bool {
+	bypass, ok := ctxt.Value(CacheBypassKey{}).(bool)
+	return ok && bypass
+}
@@ -3,6 +3,9 @@ package common
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/apex/log"
 	"github.com/go-playground/validator/v10"
@@ -16,6 +19,16 @@ Validate the authorization server config
 func (c AuthorizationServerConfig) Validate() error {
 	validate := validator.New()
 
+	// Validate the config schema version
+	if c.Version < 1 || c.Version > ConfigVersion {
+		err := fmt.Errorf(
+			"config version %d is not supported, the supported versions are 1 to %d",
+			c.Version, ConfigVersion,
+		)
+		log.WithError(err).Errorf("Config version not supported")
+		return err
+	}
+
 	// Validate the custom regex section first
 	if err := validate.Struct(&c.CustomRegex); err != nil {
 		log.WithError(err).Errorf("Custom validator support not defined")
@@ -41,6 +54,73 @@ func (c AuthorizationServerConfig) Validate() error {
 		}
 	}
 
+	// Validate the cache invalidation config
+	if c.CacheInvalidation.Enabled {
+		if err := validate.Struct(&c.CacheInvalidation); err != nil {
+			log.WithError(err).Errorf("Cache invalidation config parse failure")
+			return err
+		}
+	}
+
+	// Validate the metrics config
+	if err := validate.Struct(&c.Metrics); err != nil {
+		log.WithError(err).Errorf("Metrics config parse failure")
+		return err
+	}
+
+	// Validate the startup config
+	if err := validate.Struct(&c.Startup); err != nil {
+		log.WithError(err).Errorf("Startup config parse failure")
+		return err
+	}
+
+	// Validate the shutdown config
+	if err := validate.Struct(&c.Shutdown); err != nil {
+		log.WithError(err).Errorf("Shutdown config parse failure")
+		return err
+	}
+
+	// Validate the logging config
+	if err := validate.Struct(&c.Logging); err != nil {
+		log.WithError(err).Errorf("Logging config parse failure")
+		return err
+	}
+
+	// Validate the account lifecycle notification config
+	if c.AccountNotifications.Enabled {
+		if err := validate.Struct(&c.AccountNotifications); err != nil {
+			log.WithError(err).Errorf("Account notification config parse failure")
+			return err
+		}
+	}
+
+	// Validate the Kubernetes policy config
+	if c.KubernetesPolicy.Enabled {
+		if err := validate.Struct(&c.KubernetesPolicy); err != nil {
+			log.WithError(err).Errorf("Kubernetes policy config parse failure")
+			return err
+		}
+		// The roles can only have one remote source
+		if c.UserManagement.RemoteRoles.Enabled {
+			log.Errorf("Kubernetes policy and remote user roles can not both be enabled")
+			return fmt.Errorf("kubernetes policy and remote user roles can not both be enabled")
+		}
+	}
+
+	// The ext_authz API reads the decisions from the standard responses
+	if c.Authorization.Enabled && c.Authorization.ExtAuthz.Enabled &&
+		c.Authorization.Response.Mode != "" &&
+		c.Authorization.Response.Mode != AuthorizeResponseModeStandard {
+		log.Errorf(
+			"Envoy ext_authz API requires the standard response mode, not '%s'",
+			c.Authorization.Response.Mode,
+		)
+		return fmt.Errorf(
+			"envoy ext_authz API requires the standard response mode, not '%s'",
+			c.Authorization.Response.Mode,
+		)
+	}
+
 	// Short circuit if authorization or user management server not enabled
 	if !c.Authorization.Enabled || !c.UserManagement.Enabled {
 		return nil
@@ -52,6 +132,17 @@ func (c AuthorizationServerConfig) Validate() error {
 		return err
 	}
 
+	// Verify the selected authorization engine is fully defined
+	if c.Authorization.Engine == AuthorizeEngineRego {
+		if c.Authorization.Rego.PolicyFile == "" || c.Authorization.Rego.Query == "" {
+			log.Errorf("Rego authorization engine requires a policy file and a query")
+			return fmt.Errorf("rego authorization engine requires a policy file and a query")
+		}
+	} else if c.Authorization.Rules == nil {
+		log.Errorf("Rules authorization engine requires authorization rules")
+		return fmt.Errorf("rules authorization engine requires authorization rules")
+	}
+
 	// Validate roles
 	type roleKeyValidate struct {
 		Roles []string `json:"defined_roles" validate:"required,gte=1,dive,role_name"`
@@ -62,6 +153,11 @@ func (c AuthorizationServerConfig) Validate() error {
 		allRoles.Roles = append(allRoles.Roles, roleName)
 		seenPermission := map[string]bool{}
 		for _, permission := range roleInfo.AssignedPermissions {
+			if permission == AnonymousPermission {
+				msg := fmt.Sprintf("Role %s assigned reserved permission %s", roleName, permission)
+				log.Error(msg)
+				return fmt.Errorf(msg)
+			}
 			if _, ok := seenPermission[permission]; ok {
 				msg := fmt.Sprintf("Role %s already assigned permission %s", roleName, permission)
 				log.Error(msg)
@@ -75,6 +171,76 @@ func (c AuthorizationServerConfig) Validate() error {
 		log.WithError(err).Errorf("Roles config parse failure: %s", t)
 		return err
 	}
+	// Verify the role inheritance is resolvable
+	if _, err := ResolveRoleInheritance(c.UserManagement.AvailableRoles); err != nil {
+		log.WithError(err).Errorf("Role inheritance not valid")
+		return err
+	}
+	// Whether a permission is assigned to a role, or satisfied by a permission assigned to a role
+	isPermissionDefined := func(permission string) bool {
+		if _, ok := availablePermissions[permission]; ok {
+			return true
+		}
+		wildcards := c.UserManagement.PermissionWildcards
+		if !wildcards.Enabled {
+			return false
+		}
+		for available := range availablePermissions {
+			if wildcards.Grants(available, permission) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Verify the impersonation permission is actually supported
+	if c.Authorization.Impersonation.Enabled {
+		permission := c.Authorization.Impersonation.Permission
+		if !isPermissionDefined(permission) {
+			log.Errorf("Impersonation permission %s is not defined", permission)
+			return fmt.Errorf("impersonation permission %s is not defined", permission)
+		}
+	}
+
+	// Verify the user management admin permission is actually supported
+	if c.UserManagement.AdminAuth.Enabled {
+		permission := c.UserManagement.AdminAuth.Permission
+		if !isPermissionDefined(permission) {
+			log.Errorf("User management admin permission %s is not defined", permission)
+			return fmt.Errorf("user management admin permission %s is not defined", permission)
+		}
+	}
+
+	// Verify the sensitive roles are actually defined
+	if c.AccountNotifications.Enabled {
+		for _, roleName := range c.AccountNotifications.SensitiveRoles {
+			if _, ok := c.UserManagement.AvailableRoles[roleName]; !ok {
+				log.Errorf("Sensitive role %s is not defined", roleName)
+				return fmt.Errorf("sensitive role %s is not defined", roleName)
+			}
+		}
+	}
+
+	// Verify the quota names are unique, and the quota permissions are actually supported
+	if c.Authorization.Quota.Enabled {
+		seenQuota := map[string]bool{}
+		for _, quota := range c.Authorization.Quota.Rules {
+			if _, ok := seenQuota[quota.Name]; ok {
+				log.Errorf("Quota %s already defined", quota.Name)
+				return fmt.Errorf("quota %s already defined", quota.Name)
+			}
+			seenQuota[quota.Name] = true
+			if quota.Permission == "" {
+				continue
+			}
+			if !isPermissionDefined(quota.Permission) {
+				log.Errorf("Quota %s permission %s is not defined", quota.Name, quota.Permission)
+				return fmt.Errorf(
+					"quota %s permission %s is not defined", quota.Name, quota.Permission,
+				)
+			}
+		}
+	}
 
 	// Verify hosts defined are all unique
 	seenHost := map[string]bool{}
@@ -88,14 +254,36 @@ func (c AuthorizationServerConfig) Validate() error {
 		// Verify path defined are all unique
 		seenPathRegex := map[string]bool{}
 		for _, pathAuthEntry := range hostAuthEntry.TargetPaths {
-			if _, ok := seenPathRegex[pathAuthEntry.PathRegexPattern]; ok {
+			// Paths with the same pattern are distinct if they have different attribute, query
+			// parameter, or header conditions
+			pathKey := fmt.Sprintf(
+				"%s?%s?%s?%s",
+				pathAuthEntry.PathRegexPattern,
+				CanonicalAttributes(pathAuthEntry.Attributes),
+				CanonicalAttributes(pathAuthEntry.QueryParams),
+				CanonicalAttributes(CanonicalHeaders(pathAuthEntry.Headers)),
+			)
+			if _, ok := seenPathRegex[pathKey]; ok {
 				msg := fmt.Sprintf(
 					"Host %s Path %s already defined", hostAuthEntry.Host, pathAuthEntry.PathRegexPattern,
 				)
 				log.Errorf(msg)
 				return fmt.Errorf(msg)
 			}
-			seenPathRegex[pathAuthEntry.PathRegexPattern] = true
+			seenPathRegex[pathKey] = true
+			// Verify the attributes the path depends on are defined
+			for attribute := range pathAuthEntry.Attributes {
+				if _, ok := c.Authorization.RequestParamLocation.Attributes[attribute]; !ok {
+					msg := fmt.Sprintf(
+						"Host %s Path %s uses undefined request attribute %s",
+						hostAuthEntry.Host,
+						pathAuthEntry.PathRegexPattern,
+						attribute,
+					)
+					log.Errorf(msg)
+					return fmt.Errorf(msg)
+				}
+			}
 			// Verify method defined are all unique
 			seenMethod := map[string]bool{}
 			for _, methodEntry := range pathAuthEntry.AllowedMethods {
@@ -113,7 +301,7 @@ func (c AuthorizationServerConfig) Validate() error {
 				seenPermission := map[string]bool{}
 				// Verify the permission allowed for this method is actually supported
 				for _, permission := range methodEntry.Permissions {
-					if _, ok := availablePermissions[permission]; !ok {
+					if !isPermissionDefined(permission) && permission != AnonymousPermission {
 						log.Errorf("Permission %s is not defined", permission)
 						return fmt.Errorf("permission %s is not defined", permission)
 					}
@@ -133,7 +321,154 @@ func (c AuthorizationServerConfig) Validate() error {
 				}
 			}
 		}
+		// Verify the permissions denied are actually supported
+		for _, denyEntry := range hostAuthEntry.DenyPaths {
+			for _, permission := range denyEntry.DeniedPermissions {
+				if !isPermissionDefined(permission) {
+					msg := fmt.Sprintf(
+						"Denied permission %s ==> Host %s Path %s is not defined",
+						permission,
+						hostAuthEntry.Host,
+						denyEntry.PathRegexPattern,
+					)
+					log.Errorf(msg)
+					return fmt.Errorf(msg)
+				}
+			}
+		}
 	}
 
 	return nil
 }
+
+// HeaderIssue is an inconsistency in the HTTP header configuration
+type HeaderIssue struct {
+	// Severe is whether the inconsistency breaks request processing
+	Severe bool `json:"severe"`
+	// Description describes the inconsistency
+	Description string `json:"description"`
+}
+
+/*
+CheckHeaderConsistency check the HTTP headers used by the enabled submodules for collisions,
+and for mismatches between submodules, which the config validation does not catch
+
+	@return the inconsistencies found
+*/
+func (c AuthorizationServerConfig) CheckHeaderConsistency() []HeaderIssue {
+	issues := []HeaderIssue{}
+
+	// Each header of a submodule must carry one parameter
+	checkDistinct := func(submodule string, headers map[string]string) {
+		seen := map[string]string{}
+		for _, param := range sortedKeys(headers) {
+			header := http.CanonicalHeaderKey(headers[param])
+			if other, ok := seen[header]; ok {
+				issues = append(issues, HeaderIssue{
+					Severe: true,
+					Description: fmt.Sprintf(
+						"%s uses header %s for both %s and %s", submodule, header, other, param,
+					),
+				})
+				continue
+			}
+			seen[header] = param
+		}
+	}
+
+	authzParams := c.Authorization.RequestParamLocation
+	authzHeaders := map[string]string{
+		"host":      authzParams.Host,
+		"path":      authzParams.Path,
+		"method":    authzParams.Method,
+		"userID":    authzParams.UserID,
+		"username":  authzParams.Username,
+		"firstName": authzParams.FirstName,
+		"lastName":  authzParams.LastName,
+		"email":     authzParams.Email,
+		"clientID":  authzParams.ClientID,
+	}
+	if authzParams.Scopes != "" {
+		authzHeaders["scopes"] = authzParams.Scopes
+	}
+	for attribute, header := range authzParams.Attributes {
+		authzHeaders["attributes."+attribute] = header
+	}
+	authnParams := c.Authentication.RequestParamLocation
+	authnHeaders := map[string]string{
+		"host": authnParams.Host, "path": authnParams.Path, "method": authnParams.Method,
+	}
+
+	if c.Authorization.Enabled {
+		checkDistinct("authorize.requestParamHeaders", authzHeaders)
+	}
+	if c.Authentication.Enabled {
+		// The authentication server responds with the user parameters in the authorization
+		// request headers, which must not collide with the authentication request headers.
+		combined := map[string]string{}
+		for param, header := range authnHeaders {
+			combined["authenticate "+param] = header
+		}
+		for _, param := range []string{
+			"userID", "username", "firstName", "lastName", "email", "clientID", "scopes",
+		} {
+			if header, ok := authzHeaders[param]; ok {
+				combined["authorize "+param] = header
+			}
+		}
+		checkDistinct("authentication", combined)
+	}
+	if c.Authorization.Enabled && c.Authentication.Enabled {
+		// The proxy typically forwards the same request headers to both servers
+		for _, param := range sortedKeys(authnHeaders) {
+			authnHeader := http.CanonicalHeaderKey(authnHeaders[param])
+			authzHeader := http.CanonicalHeaderKey(authzHeaders[param])
+			if authnHeader != authzHeader {
+				issues = append(issues, HeaderIssue{
+					Description: fmt.Sprintf(
+						"request %s is read from %s for authentication, but from %s for authorization",
+						param, authnHeader, authzHeader,
+					),
+				})
+			}
+		}
+	}
+
+	// The request ID should be carried in the same header across the submodules
+	requestIDHeaders := map[string]string{}
+	if c.UserManagement.Enabled {
+		requestIDHeaders["userManagement"] = c.UserManagement.APIs.RequestLogging.RequestIDHeader
+	}
+	if c.Authorization.Enabled {
+		requestIDHeaders["authorize"] = c.Authorization.APIs.RequestLogging.RequestIDHeader
+	}
+	if c.Authentication.Enabled {
+		requestIDHeaders["authenticate"] = c.Authentication.APIs.RequestLogging.RequestIDHeader
+	}
+	uniqueRequestIDHeaders := map[string]bool{}
+	described := []string{}
+	for _, submodule := range sortedKeys(requestIDHeaders) {
+		header := http.CanonicalHeaderKey(requestIDHeaders[submodule])
+		uniqueRequestIDHeaders[header] = true
+		described = append(described, fmt.Sprintf("%s=%s", submodule, header))
+	}
+	if len(uniqueRequestIDHeaders) > 1 {
+		issues = append(issues, HeaderIssue{
+			Description: fmt.Sprintf(
+				"request ID headers differ between submodules: %s", strings.Join(described, ", "),
+			),
+		})
+	}
+
+	return issues
+}
+
+// sortedKeys the keys of a map in sorted order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,6 +1,15 @@
 package common
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
 	"github.com/alwitt/goutils"
 	"github.com/spf13/viper"
 )
@@ -20,6 +29,8 @@ type CustomValidationsConfig struct {
 	RoleNameRegex string `mapstructure:"roleName" json:"roleName" validate:"required"`
 	// PermissionRegex is the regex pattern used to validate a permission name
 	PermissionRegex string `mapstructure:"permission" json:"permission" validate:"required"`
+	// ServiceAccountIDRegex is the regex pattern used to validate a service account ID
+	ServiceAccountIDRegex string `mapstructure:"serviceAccountID" json:"serviceAccountID" validate:"required"`
 }
 
 /*
@@ -28,8 +39,13 @@ DefineCustomFieldValidator defines a CustomFieldValidator based on the config pa
 	@return the defined CustomFieldValidator
 */
 func (c CustomValidationsConfig) DefineCustomFieldValidator() (CustomFieldValidator, error) {
-	return GetCustomFieldValidator(
-		c.UserIDRegex, c.UserNameRegex, c.PersonalNameRegex, c.RoleNameRegex, c.PermissionRegex,
+	return GetCustomFieldValidatorWithServiceAccounts(
+		c.UserIDRegex,
+		c.UserNameRegex,
+		c.PersonalNameRegex,
+		c.RoleNameRegex,
+		c.PermissionRegex,
+		c.ServiceAccountIDRegex,
 	)
 }
 
@@ -61,6 +77,50 @@ type HTTPServerConfig struct {
 	Port uint16 `mapstructure:"appPort" json:"appPort" validate:"required,gt=0,lt=65536"`
 	// Timeouts sets the HTTP timeout settings
 	Timeouts HTTPServerTimeoutConfig `mapstructure:"timeoutSecs" json:"timeoutSecs" validate:"required,dive"`
+	// TLS sets whether the HTTP server terminates TLS
+	TLS ServerTLSConfig `mapstructure:"tls" json:"tls"`
+}
+
+// ServerTLSConfig defines the TLS settings for HTTP server
+type ServerTLSConfig struct {
+	// Enabled whether to serve over TLS instead of plaintext
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// CertFile is the PEM encoded server certificate file
+	CertFile string `mapstructure:"certFile" json:"certFile" validate:"required_if=Enabled true,omitempty,file"`
+	// KeyFile is the PEM encoded server private key file
+	KeyFile string `mapstructure:"keyFile" json:"keyFile" validate:"required_if=Enabled true,omitempty,file"`
+	// ClientCAFile if provided, is the PEM encoded CA bundle used to verify client
+	// certificates. Clients must then present a certificate signed by one of these CAs.
+	ClientCAFile string `mapstructure:"clientCAFile" json:"clientCAFile,omitempty" validate:"omitempty,file"`
+}
+
+/*
+DefineTLSConfig define the TLS config of a HTTP server
+
+	@return the TLS config, or nil if TLS is not enabled
+*/
+func (c ServerTLSConfig) DefineTLSConfig() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load server certificate %s: %w", c.CertFile, err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client CA %s: %w", c.ClientCAFile, err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("client CA %s contains no certificates", c.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
 }
 
 // HTTPRequestLogging defines HTTP request logging parameters
@@ -87,6 +147,74 @@ type APIConfig struct {
 	Endpoint EndpointConfig `mapstructure:"endPoint" json:"endPoint" validate:"required,dive"`
 	// RequestLogging sets API request logging parameters
 	RequestLogging HTTPRequestLogging `mapstructure:"requestLogging" json:"requestLogging" validate:"required,dive"`
+	// CacheBypass sets the debug header which makes a request skip the caches
+	CacheBypass CacheBypassConfig `mapstructure:"cacheBypass" json:"cacheBypass"`
+	// RateLimit sets the rate limits of the API requests
+	RateLimit RateLimitConfig `mapstructure:"rateLimit" json:"rateLimit"`
+}
+
+// Ways of telling the rate limited callers apart
+const (
+	// RateLimitCallerUserID tell the callers apart by user ID
+	RateLimitCallerUserID = "user_id"
+	// RateLimitCallerSourceIP tell the callers apart by source IP
+	RateLimitCallerSourceIP = "source_ip"
+)
+
+// RateLimitConfig defines the token bucket rate limits of the API requests, protecting the
+// user database and the OpenID issuer from traffic spikes. Requests over a limit are rejected
+// with 429.
+type RateLimitConfig struct {
+	// Enabled whether to rate limit the API requests
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Global is the rate limit shared by all callers
+	Global RateLimitBucketConfig `mapstructure:"global" json:"global"`
+	// PerCaller is the rate limit of each caller
+	PerCaller PerCallerRateLimitConfig `mapstructure:"perCaller" json:"perCaller"`
+}
+
+// RateLimitBucketConfig defines one token bucket rate limit
+type RateLimitBucketConfig struct {
+	// Rate is the average number of requests allowed per second. 0 means no limit.
+	Rate float64 `mapstructure:"ratePerSec" json:"ratePerSec" validate:"gte=0"`
+	// Burst is the number of requests allowed at once, above the average rate
+	Burst int `mapstructure:"burst" json:"burst" validate:"gte=0"`
+}
+
+// PerCallerRateLimitConfig defines the token bucket rate limit of each caller
+type PerCallerRateLimitConfig struct {
+	RateLimitBucketConfig `mapstructure:",squash"`
+	// CallerKey is how the callers are told apart: "user_id" or "source_ip". The user ID is
+	// only known to the authorization server; the callers of the authentication server, or
+	// lacking a user ID, are told apart by source IP.
+	CallerKey string `mapstructure:"callerKey" json:"callerKey" validate:"omitempty,oneof=user_id source_ip"`
+	// SourceIPHeader if provided, is the header the request proxy forwards the source IP in,
+	// i.e. "X-Forwarded-For". The right-most address not of a trusted proxy is used, as the
+	// addresses left of it are set by the client. Otherwise, the address of the connection is
+	// used.
+	SourceIPHeader string `mapstructure:"sourceIPHeader" json:"sourceIPHeader,omitempty"`
+	// TrustedProxies are the CIDRs of the request proxies. Their addresses are skipped in the
+	// source IP header, and the header is ignored on connections from other sources. If not
+	// given, only the proxy connecting to Padlock is trusted, and the right-most address is used.
+	TrustedProxies []string `mapstructure:"trustedProxies" json:"trustedProxies,omitempty" validate:"omitempty,dive,cidr"`
+	// MaxCallers is the maximum number of callers tracked. When full, the least recently seen
+	// caller is forgotten. 0 means no limit.
+	MaxCallers int `mapstructure:"maxCallers" json:"maxCallers" validate:"gte=0"`
+}
+
+// CacheBypassConfig defines a debug HTTP header which makes a request skip the token and
+// decision caches. The header is only honored on requests from trusted sources, i.e. the
+// proxies and admin hosts, carrying the shared secret. Request proxies relay the headers of the
+// client, so the source alone does not tell whether the header was set by an operator.
+type CacheBypassConfig struct {
+	// Enabled whether to honor the cache bypass header
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Header is the HTTP header which, when set to the secret, makes the request skip the caches
+	Header string `mapstructure:"header" json:"header" validate:"required_if=Enabled true"`
+	// Secret is the value the header must carry to be honored
+	Secret string `mapstructure:"secret" json:"-" validate:"required_if=Enabled true"`
+	// TrustedSources are the CIDRs of the sources whose cache bypass header is honored
+	TrustedSources []string `mapstructure:"trustedSources" json:"trustedSources" validate:"required_if=Enabled true,dive,cidr"`
 }
 
 // APIServerConfig defines HTTP API / server parameters
@@ -103,6 +231,87 @@ type APIServerConfig struct {
 type MetricsFeatureConfig struct {
 	// EnableAppMetrics whether to enable Golang application metrics
 	EnableAppMetrics bool `mapstructure:"enableAppMetrics" json:"enableAppMetrics"`
+	// EnableRouteMetrics whether to enable HTTP metrics labelled by API route and status class
+	EnableRouteMetrics bool `mapstructure:"enableRouteMetrics" json:"enableRouteMetrics"`
+	// MaxRouteLabels max number of distinct route labels recorded per server. Requests for
+	// additional routes are recorded under the route "other".
+	MaxRouteLabels int `mapstructure:"maxRouteLabels" json:"maxRouteLabels" validate:"gte=1"`
+	// EnableDecisionMetrics whether to enable the authorization decision metrics labelled by
+	// matched rule, and the authentication outcome metrics labelled by request host
+	EnableDecisionMetrics bool `mapstructure:"enableDecisionMetrics" json:"enableDecisionMetrics"`
+	// MaxHostLabels max number of distinct request host labels recorded for the authentication
+	// outcomes. Requests for additional hosts are recorded under the host "other".
+	MaxHostLabels int `mapstructure:"maxHostLabels" json:"maxHostLabels" validate:"gte=1"`
+}
+
+// MetricsTLSConfig metrics HTTP server TLS config
+//
+// Deprecated: set "metrics.service.tls" instead. This is used only if that is not enabled.
+type MetricsTLSConfig struct {
+	// Enabled whether to serve the metrics endpoint over TLS
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// CertFile is the PEM encoded server certificate file
+	CertFile string `mapstructure:"certFile" json:"certFile" validate:"required_if=Enabled true,omitempty,file"`
+	// KeyFile is the PEM encoded server private key file
+	KeyFile string `mapstructure:"keyFile" json:"keyFile" validate:"required_if=Enabled true,omitempty,file"`
+}
+
+// Supported metrics endpoint authentication modes
+const (
+	// MetricsAuthModeNone no authentication
+	MetricsAuthModeNone = "none"
+	// MetricsAuthModeBasic HTTP basic authentication
+	MetricsAuthModeBasic = "basic"
+	// MetricsAuthModeBearer HTTP bearer token authentication
+	MetricsAuthModeBearer = "bearer"
+)
+
+// MetricsAuthConfig metrics endpoint authentication config
+type MetricsAuthConfig struct {
+	// Mode is the authentication mode
+	Mode string `mapstructure:"mode" json:"mode" validate:"oneof=none basic bearer"`
+	// Username is the username for basic authentication
+	Username string `mapstructure:"username" json:"username" validate:"required_if=Mode basic"`
+	// Password is the password for basic authentication
+	Password string `mapstructure:"password" json:"-" validate:"required_if=Mode basic"`
+	// BearerToken is the token for bearer token authentication
+	BearerToken string `mapstructure:"bearerToken" json:"-" validate:"required_if=Mode bearer"`
+}
+
+// MetricsPushConfig Prometheus Pushgateway config for short-lived CLI operations
+type MetricsPushConfig struct {
+	// Enabled whether to push metrics at the end of a CLI operation
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// GatewayURL is the Pushgateway base URL
+	GatewayURL string `mapstructure:"gatewayURL" json:"gatewayURL" validate:"required_if=Enabled true,omitempty,url"`
+	// Job is the job name to push the metrics under
+	Job string `mapstructure:"job" json:"job" validate:"required"`
+	// TimeoutSecs is the push request timeout in seconds
+	TimeoutSecs int `mapstructure:"timeoutSecs" json:"timeoutSecs" validate:"gte=1"`
+}
+
+// Supported StatsD protocol flavors
+const (
+	// StatsDFlavorStatsD plain StatsD. Metric labels are appended to the metric name.
+	StatsDFlavorStatsD = "statsd"
+	// StatsDFlavorDogStatsD Datadog DogStatsD. Metric labels are sent as tags.
+	StatsDFlavorDogStatsD = "dogstatsd"
+)
+
+// MetricsStatsDConfig StatsD / DogStatsD metrics sink config
+type MetricsStatsDConfig struct {
+	// Enabled whether to also ship metrics to a StatsD server
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Address is the StatsD server UDP address in the form "host:port"
+	Address string `mapstructure:"address" json:"address" validate:"required_if=Enabled true,omitempty,hostname_port"`
+	// Flavor is the StatsD protocol flavor
+	Flavor string `mapstructure:"flavor" json:"flavor" validate:"oneof=statsd dogstatsd"`
+	// Prefix is prepended to all metric names
+	Prefix string `mapstructure:"prefix" json:"prefix"`
+	// FlushIntervalSecs is the interval in seconds between shipping the custom metrics
+	FlushIntervalSecs int `mapstructure:"flushIntervalSecs" json:"flushIntervalSecs" validate:"gte=1"`
+	// Tags are constant "key:value" tags attached to all metrics. Only used with DogStatsD.
+	Tags []string `mapstructure:"tags" json:"tags" validate:"dive,contains=:"`
 }
 
 // MetricsConfig application metrics config
@@ -115,6 +324,14 @@ type MetricsConfig struct {
 	MaxRequests int `mapstructure:"maxRequests" json:"maxRequests" validate:"gte=1"`
 	// Features metrics framework features to enable
 	Features MetricsFeatureConfig `mapstructure:"features" json:"features" validate:"gte=1"`
+	// TLS metrics HTTP server TLS config
+	TLS MetricsTLSConfig `mapstructure:"tls" json:"tls"`
+	// Auth metrics endpoint authentication config
+	Auth MetricsAuthConfig `mapstructure:"auth" json:"auth"`
+	// Push Prometheus Pushgateway config for short-lived CLI operations
+	Push MetricsPushConfig `mapstructure:"push" json:"push"`
+	// StatsD StatsD / DogStatsD metrics sink config
+	StatsD MetricsStatsDConfig `mapstructure:"statsd" json:"statsd"`
 }
 
 // ===============================================================================
@@ -135,8 +352,103 @@ type DatabaseConfig struct {
 
 // UserRoleConfig a single user role
 type UserRoleConfig struct {
-	// AssignedPermissions is the list of permissions assigned to a role
-	AssignedPermissions []string `mapstructure:"permissions" json:"permissions" validate:"required,gte=1,dive,user_permissions"`
+	// AssignedPermissions is the list of permissions assigned to a role. Once the role
+	// inheritance is resolved, this includes the permissions of the inherited roles.
+	AssignedPermissions []string `mapstructure:"permissions" json:"permissions" validate:"required_without=Inherits,omitempty,gte=1,dive,user_permissions"`
+	// AllowedHosts if set, the permissions of the role only apply when the request being
+	// authorized targets one of these hosts
+	AllowedHosts []string `mapstructure:"allowedHosts" json:"allowedHosts,omitempty" validate:"omitempty,dive,fqdn"`
+	// Inherits is the list of roles whose permissions this role also has. The allowed hosts
+	// of the inherited roles do not apply to this role.
+	Inherits []string `mapstructure:"inherits" json:"inherits,omitempty" validate:"omitempty,dive,role_name"`
+}
+
+/*
+AppliesToHost whether the permissions of the role apply to a request targeting a host
+
+	@param host string - the host of the request
+	@return whether the role applies
+*/
+func (c UserRoleConfig) AppliesToHost(host string) bool {
+	if len(c.AllowedHosts) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedHosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ResolveRoleInheritance flatten the role inheritance, so each role is assigned its own
+permissions, followed by those of the roles it inherits from, directly or not
+
+	@param roles map[string]UserRoleConfig - the roles, keyed by name
+	@return the roles with the inherited permissions assigned, or an error if a role inherits
+	from an unknown role, or the inheritance has a cycle
+*/
+func ResolveRoleInheritance(roles map[string]UserRoleConfig) (map[string]UserRoleConfig, error) {
+	resolved := map[string][]string{}
+	visiting := map[string]bool{}
+
+	var resolve func(roleName string, chain []string) ([]string, error)
+	resolve = func(roleName string, chain []string) ([]string, error) {
+		if permissions, ok := resolved[roleName]; ok {
+			return permissions, nil
+		}
+		chain = append(chain, roleName)
+		if visiting[roleName] {
+			return nil, fmt.Errorf("role inheritance cycle %s", strings.Join(chain, " -> "))
+		}
+		role, ok := roles[roleName]
+		if !ok {
+			return nil, fmt.Errorf(
+				"role %s inherits from unknown role %s", chain[len(chain)-2], roleName,
+			)
+		}
+		visiting[roleName] = true
+		defer delete(visiting, roleName)
+
+		permissions := []string{}
+		seenPermission := map[string]bool{}
+		addPermissions := func(toAdd []string) {
+			for _, permission := range toAdd {
+				if !seenPermission[permission] {
+					seenPermission[permission] = true
+					permissions = append(permissions, permission)
+				}
+			}
+		}
+		addPermissions(role.AssignedPermissions)
+		for _, parent := range role.Inherits {
+			inherited, err := resolve(parent, chain)
+			if err != nil {
+				return nil, err
+			}
+			addPermissions(inherited)
+		}
+		resolved[roleName] = permissions
+		return permissions, nil
+	}
+
+	roleNames := make([]string, 0, len(roles))
+	for roleName := range roles {
+		roleNames = append(roleNames, roleName)
+	}
+	sort.Strings(roleNames)
+	result := map[string]UserRoleConfig{}
+	for _, roleName := range roleNames {
+		permissions, err := resolve(roleName, nil)
+		if err != nil {
+			return nil, err
+		}
+		role := roles[roleName]
+		role.AssignedPermissions = permissions
+		result[roleName] = role
+	}
+	return result, nil
 }
 
 // UserRolesConfig a group of user roles
@@ -148,10 +460,162 @@ type UserRolesConfig struct {
 // ===============================================================================
 // User Management Submodule Config
 
+// RemoteRolesConfig describes where to periodically fetch the role configuration from, so the
+// roles can be centrally managed across many deployments
+type RemoteRolesConfig struct {
+	// Enabled whether to fetch the role configuration from the remote source. The roles of
+	// the local config are used until the first successful fetch.
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// URL is the HTTP(S) URL of the role configuration document. The document is YAML or
+	// JSON, listing the roles under "userRoles" in the same form as the local config.
+	URL string `mapstructure:"url" json:"url" validate:"required_with=Enabled,omitempty,url"`
+	// Headers are additional HTTP headers to send with the fetch, i.e. for authentication
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty"`
+	// SyncInterval interval (sec) between fetches
+	SyncInterval int `mapstructure:"syncIntervalSec" json:"syncIntervalSec" validate:"required_with=Enabled,omitempty,gte=10"`
+	// Timeout (sec) of each fetch
+	Timeout int `mapstructure:"timeoutSec" json:"timeoutSec" validate:"required_with=Enabled,omitempty,gte=1"`
+}
+
 // UserManageSubmodule defines user management submodule config
 type UserManageSubmodule struct {
 	APIServerConfig `mapstructure:",squash"`
 	UserRolesConfig `mapstructure:",squash"`
+	// RemoteRoles sets where to periodically fetch the role configuration from
+	RemoteRoles RemoteRolesConfig `mapstructure:"remoteUserRoles" json:"remoteUserRoles"`
+	// PermissionWildcards sets how a permission held by a user may satisfy other permissions
+	PermissionWildcards PermissionWildcardConfig `mapstructure:"permissionWildcards" json:"permissionWildcards"`
+	// GRPC sets the gRPC server serving the user management API
+	GRPC ManagementGRPCConfig `mapstructure:"grpc" json:"grpc"`
+	// AdminAuth sets how the callers of the user management REST and gRPC APIs are
+	// authenticated
+	AdminAuth ManagementAdminAuthConfig `mapstructure:"adminAuth" json:"adminAuth"`
+}
+
+// ManagementAdminAuthConfig describes how the callers of the user management API are
+// authenticated. The caller must present a bearer token accepted by the authentication
+// server, for a user holding the admin permission.
+type ManagementAdminAuthConfig struct {
+	// Enabled whether to authenticate the callers of the user management REST and gRPC APIs
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Permission is the permission a caller must have to use the user management API
+	Permission string `mapstructure:"permission" json:"permission" validate:"required_with=Enabled,omitempty,user_permissions"`
+	// UserIDClaim is the token claim carrying the caller's user ID
+	UserIDClaim string `mapstructure:"userIDClaim" json:"userIDClaim" validate:"required_with=Enabled"`
+}
+
+// ManagementGRPCConfig describes the gRPC server serving the user management API, through
+// which other services manage the users and their roles with generated clients
+type ManagementGRPCConfig struct {
+	// Enabled whether to serve the gRPC user management API
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// ListenOn is the interface the gRPC server will listen on
+	ListenOn string `mapstructure:"listenOn" json:"listenOn" validate:"required_with=Enabled,omitempty,ip"`
+	// Port is the port the gRPC server will listen on
+	Port uint16 `mapstructure:"appPort" json:"appPort" validate:"required_with=Enabled,omitempty,gt=0,lt=65536"`
+	// TLS sets the TLS termination of the gRPC server
+	TLS ServerTLSConfig `mapstructure:"tls" json:"tls"`
+}
+
+// PermissionWildcardConfig sets how a permission held by a user may satisfy other permissions.
+// A permission is a list of segments, i.e. "catalog:read" with the separator ":". A held
+// permission segment which is the wildcard matches any one segment, so "catalog:*" satisfies
+// "catalog:read". A final held permission segment which is the recursive wildcard matches one or
+// more segments, so "admin:**" satisfies "admin:users:delete".
+type PermissionWildcardConfig struct {
+	// Enabled whether held permissions may contain wildcards
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Separator separates the segments of a permission
+	Separator string `mapstructure:"separator" json:"separator" validate:"required_with=Enabled"`
+	// Wildcard is the segment matching any one segment
+	Wildcard string `mapstructure:"wildcard" json:"wildcard" validate:"required_with=Enabled"`
+	// RecursiveWildcard is the final segment matching one or more segments
+	RecursiveWildcard string `mapstructure:"recursiveWildcard" json:"recursiveWildcard" validate:"required_with=Enabled"`
+}
+
+/*
+Grants checks whether holding a permission satisfies a required permission
+
+	@param held string - the permission held
+	@param required string - the permission required
+	@return whether the held permission satisfies the required permission
+*/
+func (c PermissionWildcardConfig) Grants(held, required string) bool {
+	if held == required {
+		return true
+	}
+	if !c.Enabled {
+		return false
+	}
+	heldSegments := strings.Split(held, c.Separator)
+	requiredSegments := strings.Split(required, c.Separator)
+	for idx, segment := range heldSegments {
+		if segment == c.RecursiveWildcard && idx == len(heldSegments)-1 {
+			return len(requiredSegments) > idx
+		}
+		if idx >= len(requiredSegments) {
+			return false
+		}
+		if segment != c.Wildcard && segment != requiredSegments[idx] {
+			return false
+		}
+	}
+	return len(heldSegments) == len(requiredSegments)
+}
+
+// UserIDNormalizationConfig sets how user IDs are normalized before they are recorded or
+// looked up, so IDs which differ only by case or formatting refer to the same user
+type UserIDNormalizationConfig struct {
+	// TrimSpace whether to remove leading and trailing whitespace
+	TrimSpace bool `mapstructure:"trimSpace" json:"trimSpace"`
+	// StripEmailDomain whether to remove the "@domain" suffix of user IDs which are emails
+	StripEmailDomain bool `mapstructure:"stripEmailDomain" json:"stripEmailDomain"`
+	// EmailDomains are the domains StripEmailDomain removes, matched case-insensitively. The
+	// user IDs of other domains keep their domain. If empty, every domain is removed, so users
+	// of different domains with the same local part, i.e. from different OpenID issuers, become
+	// the same user.
+	EmailDomains []string `mapstructure:"emailDomains" json:"emailDomains,omitempty" validate:"omitempty,dive,required"`
+	// Lowercase whether to lowercase the user ID
+	Lowercase bool `mapstructure:"lowercase" json:"lowercase"`
+}
+
+/*
+Normalize apply the normalization policy to a user ID
+
+	@param userID string - the user ID
+	@return the normalized user ID
+*/
+func (c UserIDNormalizationConfig) Normalize(userID string) string {
+	if c.TrimSpace {
+		userID = strings.TrimSpace(userID)
+	}
+	if c.StripEmailDomain {
+		if at := strings.LastIndex(userID, "@"); at > 0 && c.stripsDomain(userID[at+1:]) {
+			userID = userID[:at]
+		}
+	}
+	if c.Lowercase {
+		userID = strings.ToLower(userID)
+	}
+	return userID
+}
+
+/*
+stripsDomain check whether StripEmailDomain removes an email domain
+
+	@param domain string - the email domain
+	@return whether the domain is removed
+*/
+func (c UserIDNormalizationConfig) stripsDomain(domain string) bool {
+	if len(c.EmailDomains) == 0 {
+		return true
+	}
+	for _, allowed := range c.EmailDomains {
+		if strings.EqualFold(allowed, domain) {
+			return true
+		}
+	}
+	return false
 }
 
 // ===============================================================================
@@ -170,6 +634,15 @@ type AuthenticateRequestParamLocConfig struct {
 	Method string `mapstructure:"method" json:"method" validate:"required"`
 }
 
+// TokenHeaderConfig defines where the authentication submodule reads the token from
+type TokenHeaderConfig struct {
+	// Header is the HTTP header carrying the token
+	Header string `mapstructure:"header" json:"header" validate:"required"`
+	// Schemes are the accepted authorization schemes preceding the token, i.e. "Bearer" in
+	// "Bearer <token>". The schemes are matched case-insensitively.
+	Schemes []string `mapstructure:"schemes" json:"schemes" validate:"required,gte=1,dive,required"`
+}
+
 // AuthnBypassMatchEntry one authentication bypass rule
 type AuthnBypassMatchEntry struct {
 	// MatchType indicates which request element this rules applies to
@@ -179,21 +652,89 @@ type AuthnBypassMatchEntry struct {
 	Matches []string `mapstructure:"matches" json:"matches" validate:"required,gte=1"`
 }
 
-// AuthnBypassConfig authentication bypass configuration
+// AuthnBypassConfig authentication or authorization bypass configuration
 type AuthnBypassConfig struct {
-	// Rules the authentication bypass rules to check against
+	// Rules the bypass rules to check against
 	Rules []AuthnBypassMatchEntry `mapstructure:"rules,omitempty" json:"rules,omitempty" validate:"omitempty,gte=1,dive"`
 }
 
+// Supported failed authentication / authorization response actions
+const (
+	// FailureActionJSON respond with the standard JSON error
+	FailureActionJSON = "json"
+	// FailureActionRedirect redirect to the login URL, with the URL of the original request
+	FailureActionRedirect = "redirect"
+	// FailureActionTemplate respond with the rendered error page template
+	FailureActionTemplate = "template"
+)
+
+// FailureResponseRuleConfig selects how to respond to failed requests to matching paths
+type FailureResponseRuleConfig struct {
+	// PathPattern is the regex pattern the path of the original request must match
+	PathPattern string `mapstructure:"pathPattern" json:"pathPattern" validate:"required"`
+	// Action is how to respond: "json", "redirect", or "template"
+	Action string `mapstructure:"action" json:"action" validate:"required,oneof=json redirect template"`
+}
+
+// FailureResponseConfig describes how a failed authentication or authorization is answered.
+// For browser traffic, a redirect to a login page, or a rendered error page, is more helpful
+// than a JSON error.
+type FailureResponseConfig struct {
+	// Enabled whether to customize the failure responses
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// BrowserAction is how to respond to requests accepting "text/html", i.e. browser traffic:
+	// "json", "redirect", or "template". Other requests get the JSON error.
+	BrowserAction string `mapstructure:"browserAction" json:"browserAction" validate:"required_with=Enabled,omitempty,oneof=json redirect template"`
+	// Rules select how to respond by the path of the original request, ahead of the
+	// BrowserAction. The first matching rule is used.
+	Rules []FailureResponseRuleConfig `mapstructure:"rules" json:"rules,omitempty" validate:"omitempty,dive"`
+	// AcceptHeader is the header carrying the accepted content types of the original request
+	AcceptHeader string `mapstructure:"acceptHeader" json:"acceptHeader" validate:"required_with=Enabled"`
+	// SchemeHeader is the header carrying the scheme of the original request. The scheme is
+	// "https" if the header is absent.
+	SchemeHeader string `mapstructure:"schemeHeader" json:"schemeHeader" validate:"required_with=Enabled"`
+	// LoginURL is the URL to redirect to with the "redirect" action
+	LoginURL string `mapstructure:"loginURL" json:"loginURL,omitempty" validate:"omitempty,url"`
+	// ReturnToParam is the query parameter of the login URL carrying the URL of the original
+	// request
+	ReturnToParam string `mapstructure:"returnToParam" json:"returnToParam" validate:"required_with=LoginURL"`
+	// Template is the Go "html/template" file to render with the "template" action
+	Template string `mapstructure:"template" json:"template,omitempty" validate:"omitempty,file"`
+	// TemplateContentType is the content type of the rendered template
+	TemplateContentType string `mapstructure:"templateContentType" json:"templateContentType" validate:"required_with=Template"`
+}
+
 // ===============================================================================
 // REST API Authorization Config
 
+// AnonymousPermission is the reserved rule permission which allows any request, including
+// requests without a user ID. It is used to model public endpoints, and can not be assigned to
+// a role.
+const AnonymousPermission = "@anonymous"
+
 // PermissionForAPIMethodConfig lists the permissions needed use a method
 type PermissionForAPIMethodConfig struct {
 	// Method specify the REST method these permissions are associated with. "*" is a wildcard.
-	Method string `mapstructure:"method" json:"method" validate:"required,oneof=GET HEAD PUT POST PATCH DELETE OPTIONS *"`
-	// Permissions is the list of user permissions allowed to use a method
-	Permissions []string `mapstructure:"allowedPermissions" json:"allowedPermissions" validate:"required,gte=1,dive,user_permissions"`
+	// "WEBSOCKET" applies to WebSocket upgrade requests, which otherwise match as "GET".
+	Method string `mapstructure:"method" json:"method" validate:"required,oneof=GET HEAD PUT POST PATCH DELETE OPTIONS WEBSOCKET *"`
+	// Permissions is the list of user permissions allowed to use a method. The reserved
+	// permission AnonymousPermission allows anyone to use the method.
+	Permissions []string `mapstructure:"allowedPermissions" json:"allowedPermissions" validate:"required_without=Clients,omitempty,dive,rule_permissions"`
+	// Clients is the list of OAuth2 client IDs allowed to use a method directly, regardless
+	// of the user the request is made for
+	Clients []string `mapstructure:"allowedClients" json:"allowedClients,omitempty" validate:"omitempty,dive,required"`
+	// UserAttributes is the REGEX pattern each named user attribute must match, in addition
+	// to the user holding one of the permissions. A user missing one of these attributes is
+	// denied. They do not apply to the directly allowed clients.
+	UserAttributes map[string]string `mapstructure:"userAttributes" json:"userAttributes,omitempty" validate:"omitempty,dive,keys,required,endkeys,required"`
+	// RequiredScopes is the list of OAuth2 scopes the token of the request must all carry, in
+	// addition to the user holding one of the permissions, or the client being allowed
+	// directly. They do not apply to methods open to anonymous access.
+	RequiredScopes []string `mapstructure:"requiredScopes" json:"requiredScopes,omitempty" validate:"omitempty,dive,required"`
+	// ServiceAccountsOnly if set, only service accounts may use the method. Users which are not
+	// service accounts are denied, even if they hold one of the permissions. This does not
+	// apply to the directly allowed clients.
+	ServiceAccountsOnly bool `mapstructure:"serviceAccountsOnly" json:"serviceAccountsOnly,omitempty"`
 }
 
 // PathAuthorizationConfig a single path authorization specification
@@ -205,6 +746,29 @@ type PathAuthorizationConfig struct {
 	// If the request method is not explicitly listed here, it may match against "*" if that
 	// was defined.
 	AllowedMethods []PermissionForAPIMethodConfig `mapstructure:"allowedMethods" json:"allowedMethods" validate:"required,gte=1,dive"`
+	// Attributes is the REGEX pattern each named request attribute must match for this path
+	// to apply. The attributes are defined in "requestParamHeaders.attributes". A path with
+	// the same pattern, but without the attribute conditions, can serve as the fallback.
+	Attributes map[string]string `mapstructure:"attributes" json:"attributes,omitempty" validate:"omitempty,dive,keys,required,endkeys,required"`
+	// QueryParams is the REGEX pattern each named query parameter of the request URI must
+	// match for this path to apply. Every value of a repeated parameter must match. The path
+	// pattern is still matched against the whole URI, including the query.
+	QueryParams map[string]string `mapstructure:"queryParams" json:"queryParams,omitempty" validate:"omitempty,dive,keys,required,endkeys,required"`
+	// Headers is the REGEX pattern each named header, forwarded by the proxy with the
+	// authorization request, must match for this path to apply
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty" validate:"omitempty,dive,keys,required,endkeys,required"`
+}
+
+// PathDenyConfig a single path deny specification
+type PathDenyConfig struct {
+	// PathRegexPattern is the regex for matching against a request URI path
+	PathRegexPattern string `mapstructure:"pathPattern" json:"pathPattern" validate:"required"`
+	// Methods is the list of request methods denied. "*" is a wildcard. All methods are
+	// denied if not set.
+	Methods []string `mapstructure:"methods" json:"methods,omitempty" validate:"omitempty,dive,oneof=GET HEAD PUT POST PATCH DELETE OPTIONS WEBSOCKET *"`
+	// DeniedPermissions if set, only the users holding one of these permissions are denied.
+	// Otherwise, every request is denied, regardless of the user, or the client.
+	DeniedPermissions []string `mapstructure:"deniedPermissions" json:"deniedPermissions,omitempty" validate:"omitempty,dive,user_permissions"`
 }
 
 // HostAuthorizationConfig is a group path authorizations for a specific host
@@ -212,7 +776,10 @@ type HostAuthorizationConfig struct {
 	// Host is the hostname for this group of path authorizers
 	Host string `mapstructure:"host" json:"host" validate:"required,fqdn|eq=*"`
 	// TargetPaths is the list of path being checked for this host
-	TargetPaths []PathAuthorizationConfig `mapstructure:"allowedPaths" json:"allowedPaths" validate:"required,gte=1,dive"`
+	TargetPaths []PathAuthorizationConfig `mapstructure:"allowedPaths" json:"allowedPaths" validate:"required_without=DenyPaths,omitempty,gte=1,dive"`
+	// DenyPaths is the list of paths denied for this host. These are checked before the
+	// allowed paths, and the deny paths of the host "*" apply to all hosts.
+	DenyPaths []PathDenyConfig `mapstructure:"denyPaths" json:"denyPaths,omitempty" validate:"omitempty,dive"`
 }
 
 // AuthorizeRequestParamLocConfig defines which HTTP headers to parse to get the parameters of
@@ -236,6 +803,18 @@ type AuthorizeRequestParamLocConfig struct {
 	LastName string `mapstructure:"lastName" json:"lastName" validate:"required"`
 	// Email is the email of the user making the request
 	Email string `mapstructure:"email" json:"email" validate:"required"`
+	// ClientID is the ID of the OAuth2 client making the request, such as the "azp" or
+	// "client_id" claim of a machine token
+	ClientID string `mapstructure:"clientID" json:"clientID" validate:"required"`
+	// Scopes is the space separated OAuth2 scopes of the token the request was made with. The
+	// scopes are not read if not set.
+	Scopes string `mapstructure:"scopes" json:"scopes,omitempty"`
+	// Attributes are additional named attributes of the request being authorized, keyed by
+	// name, with the header carrying each as the value. These are usable in the path rules.
+	//
+	// For example, with Envoy, the request scheme, query, and source address. As with all
+	// config keys, the names are case insensitive.
+	Attributes map[string]string `mapstructure:"attributes" json:"attributes,omitempty" validate:"omitempty,dive,keys,required,endkeys,required"`
 }
 
 // UnknownUserActionConfig defines what actions to take when the request being authorized is made
@@ -245,6 +824,192 @@ type UnknownUserActionConfig struct {
 	//
 	// Note: This can be dangerous as it could lead to denial-of-service due to resource exhaustion.
 	AutoAdd bool `mapstructure:"autoAdd" json:"autoAdd"`
+	// Alert sets whether to send a webhook alert each time an unknown user is automatically recorded
+	Alert AutoAddAlertConfig `mapstructure:"alert" json:"alert"`
+}
+
+// AutoAddAlertConfig webhook alert sent each time an unknown user is automatically recorded
+type AutoAddAlertConfig struct {
+	// Enabled whether to send the webhook alert
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// WebhookURL is the URL to POST the alert to
+	WebhookURL string `mapstructure:"webhookURL" json:"webhookURL" validate:"required_if=Enabled true,omitempty,url"`
+	// TimeoutSecs is the webhook request timeout in seconds
+	TimeoutSecs int `mapstructure:"timeoutSecs" json:"timeoutSecs" validate:"gte=1"`
+}
+
+// Supported authorization response modes
+const (
+	// AuthorizeResponseModeStandard respond with the standard JSON body
+	AuthorizeResponseModeStandard = "standard"
+	// AuthorizeResponseModeMinimal respond to allowed requests with 204 and no body, and to
+	// denied requests with only the error code
+	AuthorizeResponseModeMinimal = "minimal"
+	// AuthorizeResponseModeAPIGateway respond to allowed and denied requests with 200, and an
+	// API Gateway Lambda authorizer style policy document
+	AuthorizeResponseModeAPIGateway = "apigateway"
+)
+
+// AuthorizeResponseConfig defines how the authorization server responds to requests
+type AuthorizeResponseConfig struct {
+	// Mode is the response mode. The "minimal" mode reduces serialization overhead and bandwidth
+	// for high request rate deployments. The "apigateway" mode renders the decisions as API
+	// Gateway Lambda authorizer output, for migrating from API Gateway custom authorizers.
+	Mode string `mapstructure:"mode" json:"mode" validate:"oneof=standard minimal apigateway"`
+	// IncludeDenyDetail whether to include the matched rule and the permissions which would
+	// have allowed the request in denied responses. Only the "standard" mode supports this.
+	//
+	// Note: This is meant for debugging, as it exposes the authorization rules to the caller.
+	IncludeDenyDetail bool `mapstructure:"includeDenyDetail" json:"includeDenyDetail"`
+	// PolicyResourceHeader is the header carrying the resource (i.e. the method ARN) of the
+	// policy statement in the "apigateway" mode. If the header is absent, the resource is "*".
+	PolicyResourceHeader string `mapstructure:"policyResourceHeader" json:"policyResourceHeader"`
+}
+
+// DecisionCacheConfig describes the authorization decision memoization config
+type DecisionCacheConfig struct {
+	// Enabled whether to memoize the authorization decisions of known users. The decisions of
+	// a user are flushed when the user or the user's roles change, or on request through the
+	// user management admin API.
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// TTL is the number of seconds a decision is memoized for
+	TTL uint32 `mapstructure:"ttlSec" json:"ttlSec" validate:"required_with=Enabled,omitempty,gte=1"`
+	// MaxEntries is the max number of decisions to memoize. When full, the least recently used
+	// decision is removed. Zero means no limit.
+	MaxEntries int `mapstructure:"maxEntries" json:"maxEntries" validate:"gte=0"`
+}
+
+// DecisionHistoryConfig describes how the authorization decisions are recorded for later
+// queries through the user management API
+type DecisionHistoryConfig struct {
+	// Enabled whether to record the authorization decisions
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Retention is the number of hours a decision is kept for
+	Retention uint32 `mapstructure:"retentionHours" json:"retentionHours" validate:"required_with=Enabled,omitempty,gte=1"`
+	// MaxEntries is the max number of decisions to keep. The oldest decisions are removed
+	// first. Zero means the decisions are only bounded by the retention.
+	MaxEntries int `mapstructure:"maxEntries" json:"maxEntries" validate:"gte=0"`
+	// FlushInterval is the number of seconds between writes of the queued decisions
+	FlushInterval uint32 `mapstructure:"flushIntervalSec" json:"flushIntervalSec" validate:"required_with=Enabled,omitempty,gte=1"`
+	// MaxPending is the max number of decisions to queue between writes. Decisions over this
+	// are dropped.
+	MaxPending int `mapstructure:"maxPending" json:"maxPending" validate:"required_with=Enabled,omitempty,gte=1"`
+	// PruneInterval is the number of seconds between removals of the expired decisions
+	PruneInterval uint32 `mapstructure:"pruneIntervalSec" json:"pruneIntervalSec" validate:"required_with=Enabled,omitempty,gte=1"`
+}
+
+// WebSocketConfig describes how WebSocket upgrade requests are authorized
+type WebSocketConfig struct {
+	// Enabled whether to detect WebSocket upgrade requests. An upgrade request is matched
+	// against the "WEBSOCKET" method rules first, then as a "GET".
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// UpgradeHeader is the header which, when set to "websocket", marks an upgrade request.
+	// Proxies may strip the hop-by-hop "Upgrade" header from the forwarded check, in which
+	// case configure the header the proxy forwards it under.
+	UpgradeHeader string `mapstructure:"upgradeHeader" json:"upgradeHeader" validate:"required_with=Enabled"`
+}
+
+// ImpersonationConfig describes how privileged callers, such as support tooling, may have a
+// request authorized as another user
+type ImpersonationConfig struct {
+	// Enabled whether to support impersonation
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Header is the request header naming the user to impersonate
+	Header string `mapstructure:"header" json:"header" validate:"required_with=Enabled"`
+	// Permission is the permission a caller must have to impersonate another user
+	Permission string `mapstructure:"permission" json:"permission" validate:"required_with=Enabled,omitempty,user_permissions"`
+}
+
+// Supported request quota counter backends
+const (
+	// QuotaBackendMemory count the requests in memory, separately for each replica
+	QuotaBackendMemory = "memory"
+	// QuotaBackendRedis count the requests in Redis, shared by all replicas
+	QuotaBackendRedis = "redis"
+)
+
+// QuotaRuleConfig describes one request quota
+type QuotaRuleConfig struct {
+	// Name is the name of the quota, reported in the quota headers
+	Name string `mapstructure:"name" json:"name" validate:"required"`
+	// Permission if set, the quota only applies to requests matching an authorization rule
+	// which lists this permission. Otherwise, the quota applies to all requests of a user.
+	Permission string `mapstructure:"permission" json:"permission,omitempty" validate:"omitempty,user_permissions"`
+	// Requests is the number of requests a user may make within a window
+	Requests int `mapstructure:"requests" json:"requests" validate:"gte=1"`
+	// Window is the length of the quota window in seconds
+	Window int `mapstructure:"windowSec" json:"windowSec" validate:"gte=1"`
+}
+
+// QuotaConfig describes the per-user request quotas enforced at authorization time
+type QuotaConfig struct {
+	// Enabled whether to enforce the request quotas
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Backend is where to count the requests: "memory" or "redis"
+	Backend string `mapstructure:"backend" json:"backend" validate:"required_with=Enabled,omitempty,oneof=memory redis"`
+	// Redis is the Redis server to count the requests in, with the "redis" backend. The key
+	// is used as the prefix of the counter keys.
+	Redis *RedisConfig `mapstructure:"redis,omitempty" json:"redis,omitempty" validate:"required_if=Backend redis,omitempty"`
+	// Rules are the quotas to enforce. A request must be within all applicable quotas.
+	Rules []QuotaRuleConfig `mapstructure:"rules" json:"rules" validate:"required_with=Enabled,dive"`
+}
+
+// LoadSheddingConfig describes how the authorization server sheds load while the user
+// database is slow or failing
+type LoadSheddingConfig struct {
+	// Enabled whether to shed load
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Window is the length, in seconds, of the window over which the database lookups are
+	// observed
+	Window int `mapstructure:"windowSec" json:"windowSec" validate:"required_with=Enabled,omitempty,gte=1"`
+	// MinRequests is the number of lookups needed within a window before the thresholds apply
+	MinRequests int `mapstructure:"minRequests" json:"minRequests" validate:"gte=0"`
+	// LatencyThreshold sheds load once the average lookup latency within a window exceeds this
+	// many milliseconds. 0 disables the latency check.
+	LatencyThreshold int `mapstructure:"latencyThresholdMs" json:"latencyThresholdMs" validate:"gte=0"`
+	// ErrorRatio sheds load once this fraction of the lookups within a window fails.
+	// 0 disables the error check.
+	ErrorRatio float64 `mapstructure:"errorRatio" json:"errorRatio" validate:"gte=0,lte=1"`
+	// Cooldown is the number of seconds to shed load for once a threshold is crossed
+	Cooldown int `mapstructure:"cooldownSec" json:"cooldownSec" validate:"required_with=Enabled,omitempty,gte=1"`
+	// MaxInFlight is the maximum number of concurrent database lookups. Requests beyond this
+	// are shed. 0 means unlimited.
+	MaxInFlight int `mapstructure:"maxInFlight" json:"maxInFlight" validate:"gte=0"`
+	// StatusCode is the response code of a shed request: 429 or 503
+	StatusCode int `mapstructure:"statusCode" json:"statusCode" validate:"required_with=Enabled,omitempty,oneof=429 503"`
+	// FailOpenPaths are regex patterns of the low-risk request paths which are allowed,
+	// instead of rejected, while load is shed
+	FailOpenPaths []string `mapstructure:"failOpenPaths" json:"failOpenPaths"`
+}
+
+// ExtAuthzConfig describes the gRPC server implementing the Envoy "ext_authz" API, through
+// which Envoy, or Istio, queries the authorization submodule directly
+type ExtAuthzConfig struct {
+	// Enabled whether to serve the Envoy "ext_authz" API
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// ListenOn is the interface the gRPC server will listen on
+	ListenOn string `mapstructure:"listenOn" json:"listenOn" validate:"required_with=Enabled,omitempty,ip"`
+	// Port is the port the gRPC server will listen on
+	Port uint16 `mapstructure:"appPort" json:"appPort" validate:"required_with=Enabled,omitempty,gt=0,lt=65536"`
+}
+
+// Authorization decision engines
+const (
+	// AuthorizeEngineRules matches requests against the authorization rules of the config
+	AuthorizeEngineRules = "rules"
+	// AuthorizeEngineRego evaluates a Rego policy to decide the permissions needed for requests
+	AuthorizeEngineRego = "rego"
+)
+
+// RegoPolicyConfig describes the Rego policy deciding the permissions needed for requests
+type RegoPolicyConfig struct {
+	// PolicyFile is the file containing the Rego policy module
+	PolicyFile string `mapstructure:"policyFile" json:"policyFile" validate:"omitempty,file"`
+	// Query is the Rego query evaluated for each request. It must produce an object listing the
+	// permissions needed, and optionally the OAuth2 clients allowed directly.
+	Query string `mapstructure:"query" json:"query"`
+	// Headers are the request headers given to the policy, if any
+	Headers []string `mapstructure:"headers" json:"headers,omitempty"`
 }
 
 // AuthorizationConfig describes the REST API authorization config
@@ -252,7 +1017,12 @@ type AuthorizationConfig struct {
 	// Rules is the list of TargetHostSpec supported by the server. The host of "*"
 	// functions as a wildcard. If a request host is not explicitly listed here, it may match
 	// against "*" if that was defined.
-	Rules []HostAuthorizationConfig `mapstructure:"rules" json:"rules" validate:"required_with=Enabled,dive"`
+	Rules []HostAuthorizationConfig `mapstructure:"rules" json:"rules" validate:"omitempty,dive"`
+	// Engine is the engine deciding the permissions needed for a request, either the
+	// authorization rules, or a Rego policy. The rules are required when using the rules engine.
+	Engine string `mapstructure:"engine" json:"engine" validate:"omitempty,oneof=rules rego"`
+	// Rego sets the Rego policy, when the Rego engine is selected
+	Rego RegoPolicyConfig `mapstructure:"rego" json:"rego"`
 	// RequestParamLocation sets which HTTP headers to parse to get the parameters of
 	// a REST request to authorize. It is expected that the component (i.e. a proxy) requesting
 	// authorization for a request will provide the needed values through these headers when it
@@ -261,6 +1031,27 @@ type AuthorizationConfig struct {
 	// UnknownUser sets what actions to take when the request being authorized is made
 	// by an unknown user
 	UnknownUser UnknownUserActionConfig `mapstructure:"forUnknownUser" json:"forUnknownUser" validate:"required,dive"`
+	// Response sets how the authorization server responds to requests
+	Response AuthorizeResponseConfig `mapstructure:"response" json:"response" validate:"required"`
+	// DecisionCache sets how the authorization decisions are memoized
+	DecisionCache DecisionCacheConfig `mapstructure:"decisionCache" json:"decisionCache"`
+	// DecisionHistory sets how the authorization decisions are recorded
+	DecisionHistory DecisionHistoryConfig `mapstructure:"decisionHistory" json:"decisionHistory"`
+	// WebSocket sets how WebSocket upgrade requests are authorized
+	WebSocket WebSocketConfig `mapstructure:"webSocket" json:"webSocket"`
+	// Impersonation sets how privileged callers may be authorized as another user
+	Impersonation ImpersonationConfig `mapstructure:"impersonation" json:"impersonation"`
+	// Quota sets the per-user request quotas enforced on allowed requests
+	Quota QuotaConfig `mapstructure:"quota" json:"quota"`
+	// FailureResponse sets how denied requests are answered
+	FailureResponse FailureResponseConfig `mapstructure:"failureResponse" json:"failureResponse"`
+	// LoadShedding sets how load is shed while the user database is slow or failing
+	LoadShedding LoadSheddingConfig `mapstructure:"loadShedding" json:"loadShedding"`
+	// ExtAuthz sets the gRPC server implementing the Envoy "ext_authz" API
+	ExtAuthz ExtAuthzConfig `mapstructure:"extAuthz" json:"extAuthz"`
+	// Bypass authorization bypass rules. Requests matching these are allowed without any
+	// permission check.
+	Bypass *AuthnBypassConfig `mapstructure:"bypass,omitempty" json:"bypass,omitempty" validate:"omitempty,dive"`
 }
 
 // AuthorizationSubmodule defines authorization submodule config
@@ -280,10 +1071,104 @@ type OpenIDIssuerConfig struct {
 	ClientID *string `json:"client_id" validate:"omitempty"`
 	// ClientCred is the client credential to use during token introspection
 	ClientCred *string `json:"client_cred" validate:"omitempty"`
+	// ClientCredFile if provided, is the file to read the client credential from, in place of
+	// ClientCred. The file is re-read whenever it changes, so the credential can be rotated
+	// without a restart.
+	ClientCredFile *string `json:"client_cred_file,omitempty" validate:"omitempty,file"`
 	// CustomCA if provided, is the custom CA to use for the TLS session with this issuer.
 	CustomCA *string `json:"http_tls_ca,omitempty" validate:"omitempty,file"`
 	// RequestHostOverride if specified, use this as "Host" header when communicating with issuer
 	RequestHostOverride *string `json:"host_override" validate:"omitempty"`
+	// JWKSRefreshMinInterval if specified, the minimum interval (sec) between refetching the
+	// issuer's JWKS on encountering a token signed with an unknown key. Defaults to 30 sec.
+	JWKSRefreshMinInterval *int `json:"jwks_refresh_min_interval_sec,omitempty" validate:"omitempty,gte=0"`
+	// JWKSRefreshInterval if specified, the interval (sec) between refetching the issuer's JWKS
+	// in the background, so rotated signing keys are picked up and retired ones are dropped.
+	// Defaults to 3600 sec; 0 disables the background refresh.
+	JWKSRefreshInterval *int `json:"jwks_refresh_interval_sec,omitempty" validate:"omitempty,gte=0"`
+	// Claims if provided, are the claims to parse from tokens issued by this issuer, in place of
+	// the authentication submodule's "targetClaims".
+	Claims *OpenIDClaimsOfInterestConfig `json:"claims,omitempty" validate:"omitempty"`
+	// UserIDNamespace if provided, the user IDs of this issuer's tokens are prefixed with
+	// "<namespace>_", so users of different issuers sharing an ID stay apart.
+	UserIDNamespace *string `json:"user_id_namespace,omitempty" validate:"omitempty,alphanum"`
+	// SharedUserIDs whether this issuer's user IDs are used as is, alongside those of the other
+	// issuers. A user ID issued by two issuers then refers to the same user.
+	SharedUserIDs bool `json:"shared_user_ids,omitempty"`
+	// TokenCache if provided, sets how this issuer's tokens are cached, in place of the
+	// authentication submodule's introspection settings
+	TokenCache *IssuerTokenCacheConfig `json:"token_cache,omitempty" validate:"omitempty"`
+}
+
+// IssuerTokenCacheConfig sets how the tokens of one OpenID issuer are cached. Each issuer's
+// tokens are cached in their own partition, so an issuer of short-lived tokens can be
+// re-introspected more often without affecting the others.
+type IssuerTokenCacheConfig struct {
+	// ReIntrospectInterval if provided, interval (sec) to re-introspect this issuer's cached
+	// tokens
+	ReIntrospectInterval *int `json:"recheck_interval_sec,omitempty" validate:"omitempty,gte=30"`
+	// InactiveCacheTTL if provided, duration (sec) to remember this issuer's tokens which failed
+	// introspection. 0 disables negative caching for this issuer.
+	InactiveCacheTTL *int `json:"inactive_cache_ttl_sec,omitempty" validate:"omitempty,gte=0"`
+	// MaxCacheEntries if provided, maximum number of this issuer's entries in the in-memory token
+	// cache. 0 means unbounded.
+	MaxCacheEntries *int `json:"max_cache_entries,omitempty" validate:"omitempty,gte=0"`
+}
+
+/*
+ParseOpenIDIssuerParams parse the content of an OpenID issuer parameter file. The file holds
+either one issuer's parameters, or a list of issuers' parameters.
+
+	@param raw []byte - the parameter file content
+	@return the parameters of each issuer
+*/
+func ParseOpenIDIssuerParams(raw []byte) ([]OpenIDIssuerConfig, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var issuers []OpenIDIssuerConfig
+		if err := json.Unmarshal(trimmed, &issuers); err != nil {
+			return nil, err
+		}
+		if len(issuers) == 0 {
+			return nil, fmt.Errorf("no OpenID issuer listed")
+		}
+		seen := map[string]bool{}
+		namespaces := map[string]bool{}
+		for _, issuer := range issuers {
+			canonical := strings.TrimSuffix(issuer.Issuer, "/")
+			if seen[canonical] {
+				return nil, fmt.Errorf("OpenID issuer %s listed more than once", issuer.Issuer)
+			}
+			seen[canonical] = true
+			if len(issuers) == 1 {
+				continue
+			}
+			// With several issuers, how their user IDs relate must be stated explicitly
+			switch {
+			case issuer.UserIDNamespace != nil && issuer.SharedUserIDs:
+				return nil, fmt.Errorf(
+					"OpenID issuer %s sets both user_id_namespace and shared_user_ids", issuer.Issuer,
+				)
+			case issuer.UserIDNamespace != nil:
+				if namespaces[*issuer.UserIDNamespace] {
+					return nil, fmt.Errorf(
+						"OpenID issuer user_id_namespace %s used more than once", *issuer.UserIDNamespace,
+					)
+				}
+				namespaces[*issuer.UserIDNamespace] = true
+			case !issuer.SharedUserIDs:
+				return nil, fmt.Errorf(
+					"OpenID issuer %s must set either user_id_namespace or shared_user_ids", issuer.Issuer,
+				)
+			}
+		}
+		return issuers, nil
+	}
+	var issuer OpenIDIssuerConfig
+	if err := json.Unmarshal(trimmed, &issuer); err != nil {
+		return nil, err
+	}
+	return []OpenIDIssuerConfig{issuer}, nil
 }
 
 // OpenIDClaimsOfInterestConfig sets which claims to parse from a token to get key
@@ -302,18 +1187,249 @@ type OpenIDClaimsOfInterestConfig struct {
 	LastNameClaim *string `mapstructure:"lastName,omitempty" json:"lastName,omitempty"`
 	// EmailClaim is the claim containing the email of the user
 	EmailClaim *string `mapstructure:"email,omitempty" json:"email,omitempty"`
+	// ClientIDClaims are the claims which may contain the ID of the OAuth2 client the token was
+	// issued to. The first claim present in the token is used.
+	ClientIDClaims []string `mapstructure:"clientID,omitempty" json:"clientID,omitempty"`
+	// ScopeClaim is the claim containing the OAuth2 scopes of the token, either as a space
+	// separated string, or a list
+	ScopeClaim string `mapstructure:"scope,omitempty" json:"scope,omitempty"`
 }
 
 // IntrospectionConfig OAuth2 token introspect operation config
 type IntrospectionConfig struct {
 	// Enabled whether introspection enabled
 	Enabled bool `mapstructure:"enabled" json:"enabled"`
-	// ReIntrospectInterval interval (sec) to periodically re-introspect cached tokens
+	// ReIntrospectInterval interval (sec) to periodically re-introspect cached tokens, i.e. how
+	// long an active introspection result is cached (the positive TTL)
 	ReIntrospectInterval int `mapstructure:"recheckIntervalSec" json:"recheck_interval_sec" validate:"gte=30"`
 	// CacheCleanInterval interval (sec) to periodically clear expired tokens from cache
 	CacheCleanInterval int `mapstructure:"cacheCleanIntervalSec" json:"cache_clean_interval_sec" validate:"gte=30"`
 	// CachePurgeInterval interval (sec) to periodically purge the token cache
 	CachePurgeInterval int `mapstructure:"cachePurgeIntervalSec" json:"cache_purge_interval_sec" validate:"gte=60"`
+	// InactiveCacheTTL duration (sec) to remember a token failed introspection, so repeated
+	// requests bearing the token do not trigger introspection (the negative TTL). Errored
+	// introspections are never cached. 0 disables negative caching.
+	InactiveCacheTTL int `mapstructure:"inactiveCacheTTLSec" json:"inactive_cache_ttl_sec" validate:"gte=0"`
+	// StaleWhileRevalidate whether to accept a cached token due for re-introspection, while it
+	// is re-introspected in the background, instead of re-introspecting it before accepting.
+	StaleWhileRevalidate bool `mapstructure:"staleWhileRevalidate" json:"stale_while_revalidate"`
+	// ProactiveRecheckInterval interval (sec) to periodically re-introspect the cached tokens due
+	// for re-introspection before the next run, so requests need not wait for it. 0 disables.
+	ProactiveRecheckInterval int `mapstructure:"proactiveRecheckIntervalSec" json:"proactive_recheck_interval_sec" validate:"omitempty,gte=10"`
+	// MaxCacheEntries maximum number of entries in the in-memory token cache of each OpenID
+	// issuer, beyond which the least recently used entries are evicted. 0 means unbounded.
+	MaxCacheEntries int `mapstructure:"maxCacheEntries" json:"max_cache_entries" validate:"gte=0"`
+	// Cache token cache backend config
+	Cache TokenCacheConfig `mapstructure:"cache" json:"cache"`
+	// Persist token cache persistence config
+	Persist TokenCachePersistConfig `mapstructure:"persist" json:"persist"`
+}
+
+/*
+ForIssuer the introspection config applying to the tokens of an OpenID issuer, with the
+issuer's token cache settings in place of the shared ones
+
+	@param issuer OpenIDIssuerConfig - the parameters of the OpenID issuer
+	@return the introspection config for the issuer
+*/
+func (c IntrospectionConfig) ForIssuer(issuer OpenIDIssuerConfig) IntrospectionConfig {
+	if issuer.TokenCache == nil {
+		return c
+	}
+	if issuer.TokenCache.ReIntrospectInterval != nil {
+		c.ReIntrospectInterval = *issuer.TokenCache.ReIntrospectInterval
+	}
+	if issuer.TokenCache.InactiveCacheTTL != nil {
+		c.InactiveCacheTTL = *issuer.TokenCache.InactiveCacheTTL
+	}
+	if issuer.TokenCache.MaxCacheEntries != nil {
+		c.MaxCacheEntries = *issuer.TokenCache.MaxCacheEntries
+	}
+	return c
+}
+
+// Supported token cache backends
+const (
+	// TokenCacheBackendMemory keep the token cache in memory, separately for each replica
+	TokenCacheBackendMemory = "memory"
+	// TokenCacheBackendMemcached keep the token cache in Memcached, shared by all replicas
+	TokenCacheBackendMemcached = "memcached"
+)
+
+// TokenCacheConfig defines where the token cache is kept
+type TokenCacheConfig struct {
+	// Backend is where to keep the token cache: "memory" or "memcached"
+	Backend string `mapstructure:"backend" json:"backend" validate:"required,oneof=memory memcached"`
+	// Memcached is the Memcached servers to keep the token cache in, with the "memcached" backend
+	Memcached *MemcachedConfig `mapstructure:"memcached,omitempty" json:"memcached,omitempty" validate:"required_if=Backend memcached,omitempty"`
+}
+
+// MemcachedConfig defines a Memcached server pool connection
+type MemcachedConfig struct {
+	// Servers are the Memcached servers "host:port"
+	Servers []string `mapstructure:"servers" json:"servers" validate:"required,gte=1,dive,hostname_port"`
+	// KeyPrefix is the prefix of the Memcached keys used
+	KeyPrefix string `mapstructure:"keyPrefix" json:"key_prefix" validate:"required"`
+	// Timeout (ms) for each Memcached operation. 0 uses the client default.
+	Timeout int `mapstructure:"timeoutMs" json:"timeout_ms" validate:"gte=0"`
+}
+
+// Supported token cache persistence backends
+const (
+	// TokenCachePersistBackendFile persist the token cache to a local file
+	TokenCachePersistBackendFile = "file"
+	// TokenCachePersistBackendRedis persist the token cache to Redis
+	TokenCachePersistBackendRedis = "redis"
+)
+
+// TokenCachePersistConfig defines how the token cache is persisted, so it is warmed on
+// restart instead of every token being introspected again. Only token hashes are persisted.
+type TokenCachePersistConfig struct {
+	// Enabled whether to persist the token cache
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Backend is where to persist the token cache: "file" or "redis"
+	Backend string `mapstructure:"backend" json:"backend" validate:"required_if=Enabled true,omitempty,oneof=file redis"`
+	// File is the file to persist the token cache to, with the "file" backend
+	File string `mapstructure:"file" json:"file" validate:"required_if=Backend file"`
+	// Redis is the Redis server to persist the token cache to, with the "redis" backend
+	Redis *RedisConfig `mapstructure:"redis,omitempty" json:"redis,omitempty" validate:"required_if=Backend redis,omitempty"`
+	// SaveInterval interval (sec) to periodically persist the token cache. The token cache is
+	// also persisted on shutdown.
+	SaveInterval int `mapstructure:"saveIntervalSec" json:"save_interval_sec" validate:"gte=10"`
+}
+
+// RedisConfig defines a Redis server connection. The password is supplied through the command
+// line.
+type RedisConfig struct {
+	// Address is the Redis server "host:port"
+	Address string `mapstructure:"address" json:"address" validate:"required,hostname_port"`
+	// DB is the Redis database number
+	DB int `mapstructure:"db" json:"db" validate:"gte=0"`
+	// Key is the Redis key to store the data under
+	Key string `mapstructure:"key" json:"key" validate:"required"`
+}
+
+// Supported OpenID issuer degraded modes
+const (
+	// IssuerDegradedModeNone continue operating normally while the issuer is unhealthy
+	IssuerDegradedModeNone = "none"
+	// IssuerDegradedModeCachedTokensOnly only accept previously cached tokens while the issuer
+	// is unhealthy
+	IssuerDegradedModeCachedTokensOnly = "cached_tokens_only"
+)
+
+// IssuerHealthConfig OpenID issuer health watchdog config
+type IssuerHealthConfig struct {
+	// Enabled whether to periodically check the health of the OpenID issuer
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// CheckInterval interval (sec) between health checks
+	CheckInterval int `mapstructure:"checkIntervalSec" json:"check_interval_sec" validate:"gte=5"`
+	// DegradedMode how authentication operates while the OpenID issuer is unhealthy. The
+	// "cached_tokens_only" mode only takes effect when introspection is enabled.
+	DegradedMode string `mapstructure:"degradedMode" json:"degraded_mode" validate:"oneof=none cached_tokens_only"`
+	// GateReadiness whether the authentication submodule only reports ready while the OpenID
+	// issuer is healthy, so traffic is routed away from replicas unable to reach the issuer
+	GateReadiness bool `mapstructure:"gateReadiness" json:"gate_readiness"`
+}
+
+// SAMLAttributesConfig sets which SAML assertion attributes to read the user parameters from
+type SAMLAttributesConfig struct {
+	// UserID is the attribute containing the user ID. If not specified, the assertion subject
+	// NameID is the user ID.
+	UserID *string `mapstructure:"userID,omitempty" json:"userID,omitempty"`
+	// Username is the attribute containing the username
+	Username *string `mapstructure:"username,omitempty" json:"username,omitempty"`
+	// FirstName is the attribute containing the first name / given name of the user
+	FirstName *string `mapstructure:"firstName,omitempty" json:"firstName,omitempty"`
+	// LastName is the attribute containing the last name / surname / family name of the user
+	LastName *string `mapstructure:"lastName,omitempty" json:"lastName,omitempty"`
+	// Email is the attribute containing the email of the user
+	Email *string `mapstructure:"email,omitempty" json:"email,omitempty"`
+}
+
+// SAMLConfig describes how to validate SAML responses forwarded with a request to authenticate
+type SAMLConfig struct {
+	// Enabled whether to accept SAML responses in place of a bearer token
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// IDPIssuer is the expected issuer of the SAML response and assertion
+	IDPIssuer string `mapstructure:"idpIssuer" json:"idpIssuer" validate:"required_with=Enabled"`
+	// IDPCertificateFile is the PEM file of the SAML identity provider's signing certificates
+	IDPCertificateFile string `mapstructure:"idpCertificateFile" json:"idpCertificateFile" validate:"required_with=Enabled,omitempty,file"`
+	// Audience is the audience the assertion must be restricted to, i.e. the entity ID of
+	// the service provider.
+	Audience string `mapstructure:"audience" json:"audience" validate:"required_with=Enabled"`
+	// AssertionConsumerServiceURL is the URL the SAML response must be addressed to
+	AssertionConsumerServiceURL string `mapstructure:"assertionConsumerServiceURL" json:"assertionConsumerServiceURL" validate:"required_with=Enabled,omitempty,url"`
+	// Header is the HTTP header carrying the base64 encoded SAML response
+	Header string `mapstructure:"header" json:"header" validate:"required_with=Enabled"`
+	// FormField is the form field carrying the base64 encoded SAML response, when the SAML
+	// response is form posted to the authentication server
+	FormField string `mapstructure:"formField" json:"formField" validate:"required_with=Enabled"`
+	// Attributes sets which assertion attributes to read the user parameters from
+	Attributes SAMLAttributesConfig `mapstructure:"attributes" json:"attributes"`
+}
+
+// CloudflareAccessConfig describes how to validate the JWT assertions Cloudflare Access
+// attaches to the requests it forwards
+type CloudflareAccessConfig struct {
+	// Enabled whether to accept Cloudflare Access JWT assertions in place of a bearer token
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// TeamDomain is the Cloudflare Access team domain, i.e. https://<team>.cloudflareaccess.com.
+	// The assertion must be issued by it, and signed by one of its signing keys.
+	TeamDomain string `mapstructure:"teamDomain" json:"teamDomain" validate:"required_with=Enabled,omitempty,url"`
+	// Audience is the Application Audience (AUD) tag of the Cloudflare Access application
+	Audience string `mapstructure:"audience" json:"audience" validate:"required_with=Enabled"`
+	// Header is the HTTP header carrying the JWT assertion
+	Header string `mapstructure:"header" json:"header" validate:"required_with=Enabled"`
+	// Claims sets which assertion claims to read the user parameters from
+	Claims OpenIDClaimsOfInterestConfig `mapstructure:"claims" json:"claims"`
+}
+
+// APIKeyAuthConfig describes how API keys minted through the user management API are accepted
+// in place of a bearer token
+type APIKeyAuthConfig struct {
+	// Enabled whether to accept API keys in place of a bearer token. The authentication server
+	// then needs access to the user database.
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Header is the HTTP header carrying the API key
+	Header string `mapstructure:"header" json:"header" validate:"required_with=Enabled"`
+	// Scheme is the authorization scheme marking an API key in the bearer token header, i.e.
+	// "Authorization: ApiKey <key>"
+	Scheme string `mapstructure:"scheme" json:"scheme" validate:"required_with=Enabled"`
+}
+
+// ClientCertAuthConfig describes how the caller identity is read from a client certificate,
+// for service mesh callers which present a certificate in place of a bearer token. The request
+// proxy terminates mutual TLS with the caller, and forwards its certificate to Padlock.
+type ClientCertAuthConfig struct {
+	// Enabled whether to accept a client certificate in place of a bearer token
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Headers are the HTTP headers carrying the client certificate forwarded by the request
+	// proxy, checked in order. "X-Forwarded-Client-Cert" is read in the Envoy format, while the
+	// other headers must carry a PEM encoded certificate, optionally URL encoded.
+	//
+	// NOTE: the request proxy must strip these headers from the incoming requests.
+	Headers []string `mapstructure:"headers" json:"headers" validate:"required_with=Enabled,omitempty,gte=1,dive,required"`
+	// TrustedProxies are the CIDRs of the request proxies allowed to forward the client
+	// certificates. The headers are ignored on requests from other sources.
+	TrustedProxies []string `mapstructure:"trustedProxies" json:"trustedProxies" validate:"required_with=Enabled,omitempty,gte=1,dive,cidr"`
+	// IdentitySources are the certificate fields the user ID is read from, in order of
+	// preference
+	IdentitySources []string `mapstructure:"identitySources" json:"identitySources" validate:"required_with=Enabled,omitempty,gte=1,dive,oneof=uri dns email cn"`
+	// CAFile is the PEM encoded CA bundle the forwarded certificates must chain to
+	CAFile string `mapstructure:"caFile,omitempty" json:"caFile,omitempty" validate:"required_with=Enabled,omitempty,file"`
+}
+
+// UserinfoConfig describes how tokens lacking the claims of interest are enriched from the
+// OpenID issuer's userinfo endpoint
+type UserinfoConfig struct {
+	// Enabled whether to query the userinfo endpoint when a token lacks a claim of interest
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// CacheTTL is the number of seconds a userinfo response is cached for. An entry is never
+	// cached past the expiration of its token.
+	CacheTTL uint32 `mapstructure:"cacheTTLSec" json:"cacheTTLSec" validate:"required_with=Enabled,omitempty,gte=1"`
+	// MaxCacheEntries is the maximum number of userinfo responses to cache
+	MaxCacheEntries int `mapstructure:"maxCacheEntries" json:"maxCacheEntries" validate:"required_with=Enabled,omitempty,gte=1"`
 }
 
 // AuthenticationConfig describes the REST API authentication config
@@ -327,10 +1443,51 @@ type AuthenticationConfig struct {
 	// authentication for a request will provide the needed values through these headers when it
 	// contacts the authentication server.
 	RequestParamLocation AuthenticateRequestParamLocConfig `mapstructure:"requestParamHeaders" json:"requestParamHeaders" validate:"required,dive"`
+	// TokenHeader sets which HTTP header carries the token, and the accepted authorization
+	// schemes
+	TokenHeader TokenHeaderConfig `mapstructure:"tokenHeader" json:"tokenHeader" validate:"required,dive"`
 	// Introspection define OAuth2 token introspect operation config
 	Introspection IntrospectionConfig `mapstructure:"introspect" json:"introspect" validate:"required,dive"`
+	// IssuerHealth OpenID issuer health watchdog config
+	IssuerHealth IssuerHealthConfig `mapstructure:"issuerHealth" json:"issuerHealth" validate:"required,dive"`
 	// Bypass authentication bypass rules
 	Bypass *AuthnBypassConfig `mapstructure:"bypass,omitempty" json:"bypass,omitempty" validate:"omitempty,dive"`
+	// SAML sets how SAML responses are validated, for callers which do not use OpenID
+	SAML SAMLConfig `mapstructure:"saml" json:"saml"`
+	// CloudflareAccess sets how Cloudflare Access JWT assertions are validated, for applications
+	// fronted by Cloudflare Access
+	CloudflareAccess CloudflareAccessConfig `mapstructure:"cloudflareAccess" json:"cloudflareAccess"`
+	// APIKey sets how API keys are accepted, for machine clients which do not use OpenID
+	APIKey APIKeyAuthConfig `mapstructure:"apiKey" json:"apiKey"`
+	// ClientCert sets how client certificates are accepted, for service mesh callers which do
+	// not use bearer tokens
+	ClientCert ClientCertAuthConfig `mapstructure:"clientCert" json:"clientCert"`
+	// Userinfo sets how tokens lacking the claims of interest are enriched from the OpenID
+	// issuer's userinfo endpoint
+	Userinfo UserinfoConfig `mapstructure:"userinfo" json:"userinfo"`
+	// FailureResponse sets how unauthenticated requests are answered
+	FailureResponse FailureResponseConfig `mapstructure:"failureResponse" json:"failureResponse"`
+	// IntrospectEndpoint sets the token introspection endpoint offered to the backends
+	IntrospectEndpoint IntrospectEndpointConfig `mapstructure:"introspectEndpoint" json:"introspectEndpoint"`
+}
+
+// IntrospectEndpointConfig describes the token introspection endpoint, through which the
+// backends introspect tokens without their own OpenID issuer client credentials. The backends
+// authenticate with their own client credentials, through HTTP basic authentication.
+type IntrospectEndpointConfig struct {
+	// Enabled whether to offer the token introspection endpoint
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Clients are the client credentials of the backends allowed to introspect tokens
+	Clients []IntrospectClientConfig `mapstructure:"clients" json:"clients" validate:"required_with=Enabled,dive"`
+}
+
+// IntrospectClientConfig is the client credential of a backend calling the token
+// introspection endpoint
+type IntrospectClientConfig struct {
+	// ID is the client ID
+	ID string `mapstructure:"id" json:"id" validate:"required"`
+	// Secret is the client secret
+	Secret string `mapstructure:"secret" json:"-" validate:"required"`
 }
 
 // AuthenticationSubmodule defines authentication submodule config
@@ -339,11 +1496,230 @@ type AuthenticationSubmodule struct {
 	AuthenticationConfig `mapstructure:",squash"`
 }
 
+// ===============================================================================
+// Cache Invalidation Config
+
+// CacheInvalidationConfig defines how cache invalidation notices are exchanged between
+// padlock replicas, so that changes observed by one replica are reflected by all.
+//
+// Notices are exchanged through Postgres LISTEN / NOTIFY using the user tracking database.
+type CacheInvalidationConfig struct {
+	// Enabled whether to exchange cache invalidation notices with other replicas
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Channel is the Postgres notification channel to exchange notices on
+	Channel string `mapstructure:"channel" json:"channel" validate:"required"`
+}
+
+// ===============================================================================
+// Startup Config
+
+// RetryConfig defines how an operation is retried with exponential backoff
+type RetryConfig struct {
+	// MaxAttempts is the max number of attempts. 0 means retry until successful.
+	MaxAttempts int `mapstructure:"maxAttempts" json:"max_attempts" validate:"gte=0"`
+	// InitialInterval is the wait (sec) before the first retry
+	InitialInterval int `mapstructure:"initialIntervalSec" json:"initial_interval_sec" validate:"gte=1"`
+	// MaxInterval is the max wait (sec) between retries
+	MaxInterval int `mapstructure:"maxIntervalSec" json:"max_interval_sec" validate:"gtefield=InitialInterval"`
+}
+
+// DBConnectConfig defines how the application connects to the database at startup
+type DBConnectConfig struct {
+	// Retry is the retry policy for the database connection
+	Retry RetryConfig `mapstructure:"retry" json:"retry" validate:"required,dive"`
+	// MaxWait is the max duration (sec) to wait for the database to become reachable.
+	// 0 means wait until the retry attempts are exhausted.
+	MaxWait int `mapstructure:"maxWaitSec" json:"max_wait_sec" validate:"gte=0"`
+}
+
+// StartupConfig defines how the application performs the startup tasks which must complete
+// before it reports ready.
+type StartupConfig struct {
+	// Retry is the retry policy for the startup tasks
+	Retry RetryConfig `mapstructure:"retry" json:"retry" validate:"required,dive"`
+	// DBConnect is the database connection config
+	DBConnect DBConnectConfig `mapstructure:"dbConnect" json:"db_connect" validate:"required,dive"`
+}
+
+// ShutdownConfig defines how the application shuts down
+type ShutdownConfig struct {
+	// DrainTimeout is the max duration (sec) to wait for in-flight requests to complete
+	// before shutting down the servers
+	DrainTimeout int `mapstructure:"drainTimeoutSec" json:"drain_timeout_sec" validate:"gte=0"`
+}
+
+// ===============================================================================
+// Logging Config
+
+// Supported log file formats
+const (
+	// LogFormatJSON one JSON document per log entry
+	LogFormatJSON = "json"
+	// LogFormatLogfmt one logfmt line per log entry
+	LogFormatLogfmt = "logfmt"
+)
+
+// LogSinkLevelConfig defines the log level of a log sink
+type LogSinkLevelConfig struct {
+	// Level is the log level of the sink
+	Level string `mapstructure:"level" json:"level" validate:"oneof=debug info warn error"`
+	// ModuleLevels overrides the log level of the sink for log entries from specific modules,
+	// keyed by the "module" log field
+	ModuleLevels map[string]string `mapstructure:"moduleLevels" json:"moduleLevels" validate:"dive,oneof=debug info warn error"`
+}
+
+// LogFileConfig defines the rotating log file sink
+type LogFileConfig struct {
+	// Enabled whether to also write the logs to a file
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Path is the log file path. Rotated files are written in the same directory.
+	Path string `mapstructure:"path" json:"path" validate:"required_if=Enabled true"`
+	// Format is the log entry format
+	Format string `mapstructure:"format" json:"format" validate:"oneof=json logfmt"`
+	// LogSinkLevelConfig is the log level of the sink
+	LogSinkLevelConfig `mapstructure:",squash"`
+	// MaxSizeMB is the size (MB) at which the log file is rotated
+	MaxSizeMB int `mapstructure:"maxSizeMB" json:"maxSizeMB" validate:"gte=1"`
+	// RotateIntervalHours is the interval (hr) at which the log file is rotated regardless of
+	// its size. 0 disables time based rotation.
+	RotateIntervalHours int `mapstructure:"rotateIntervalHours" json:"rotateIntervalHours" validate:"gte=0"`
+	// MaxBackups is the max number of rotated files to keep. 0 keeps all of them.
+	MaxBackups int `mapstructure:"maxBackups" json:"maxBackups" validate:"gte=0"`
+	// MaxAgeDays is the max age (day) of rotated files to keep. 0 keeps all of them.
+	MaxAgeDays int `mapstructure:"maxAgeDays" json:"maxAgeDays" validate:"gte=0"`
+	// Compress whether to gzip the rotated files
+	Compress bool `mapstructure:"compress" json:"compress"`
+}
+
+// LogSyslogConfig defines the syslog (RFC5424) log sink
+type LogSyslogConfig struct {
+	// Enabled whether to also ship the logs to a syslog server
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Network is the network to reach the syslog server over
+	Network string `mapstructure:"network" json:"network" validate:"oneof=udp tcp unix"`
+	// Address is the syslog server address. Either "host:port", or a socket path for "unix".
+	Address string `mapstructure:"address" json:"address" validate:"required_if=Enabled true"`
+	// Facility is the syslog facility
+	Facility string `mapstructure:"facility" json:"facility" validate:"oneof=user daemon auth local0 local1 local2 local3 local4 local5 local6 local7"`
+	// AppName is the syslog APP-NAME
+	AppName string `mapstructure:"appName" json:"appName" validate:"required,max=48"`
+	// LogSinkLevelConfig is the log level of the sink
+	LogSinkLevelConfig `mapstructure:",squash"`
+}
+
+// LogLokiConfig defines the Loki log sink
+type LogLokiConfig struct {
+	// Enabled whether to also ship the logs to Loki
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// URL is the Loki base URL. The logs are sent to the push API "/loki/api/v1/push".
+	URL string `mapstructure:"url" json:"url" validate:"required_if=Enabled true,omitempty,url"`
+	// TenantID if provided, is sent as the "X-Scope-OrgID" header
+	TenantID string `mapstructure:"tenantID" json:"tenantID"`
+	// Labels are constant stream labels. The "level" and "module" labels are always added.
+	Labels map[string]string `mapstructure:"labels" json:"labels"`
+	// BatchSize is the max number of log entries sent per push
+	BatchSize int `mapstructure:"batchSize" json:"batchSize" validate:"gte=1"`
+	// FlushIntervalSecs is the max interval (sec) between pushes
+	FlushIntervalSecs int `mapstructure:"flushIntervalSecs" json:"flushIntervalSecs" validate:"gte=1"`
+	// TimeoutSecs is the push request timeout (sec)
+	TimeoutSecs int `mapstructure:"timeoutSecs" json:"timeoutSecs" validate:"gte=1"`
+	// LogSinkLevelConfig is the log level of the sink
+	LogSinkLevelConfig `mapstructure:",squash"`
+}
+
+// LoggingConfig defines the log sinks used in addition to stderr
+type LoggingConfig struct {
+	// File is the rotating log file sink
+	File LogFileConfig `mapstructure:"file" json:"file"`
+	// Syslog is the syslog log sink
+	Syslog LogSyslogConfig `mapstructure:"syslog" json:"syslog"`
+	// Loki is the Loki log sink
+	Loki LogLokiConfig `mapstructure:"loki" json:"loki"`
+}
+
+// ===============================================================================
+// NATS Responder Config
+
+// NATSResponderConfig defines the NATS request / response interface, through which services
+// query the authorization and authentication submodules without HTTP
+type NATSResponderConfig struct {
+	// Enabled whether to answer queries over NATS
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// URL is the NATS server URL
+	URL string `mapstructure:"url" json:"url" validate:"required_with=Enabled"`
+	// Subject is the subject to listen for queries on
+	Subject string `mapstructure:"subject" json:"subject" validate:"required_with=Enabled"`
+	// QueueGroup is the queue group to join, so each query is answered by only one replica
+	QueueGroup string `mapstructure:"queueGroup" json:"queueGroup" validate:"required_with=Enabled"`
+}
+
+// ===============================================================================
+// Account Lifecycle Notifications
+
+// Supported account lifecycle events
+const (
+	// AccountEventAutoAdded an unknown user was automatically recorded during authorization
+	AccountEventAutoAdded = "autoAdded"
+	// AccountEventDeleted a user was deleted
+	AccountEventDeleted = "deleted"
+	// AccountEventSuspended a user was suspended by the users sync command, which removed all
+	// of its roles, directly granted permissions, and group memberships
+	AccountEventSuspended = "suspended"
+	// AccountEventSensitiveRoleGranted a user was granted a sensitive role
+	AccountEventSensitiveRoleGranted = "sensitiveRoleGranted"
+)
+
+// AccountWebhookConfig defines a webhook the account lifecycle events are POSTed to as JSON
+type AccountWebhookConfig struct {
+	// Enabled whether to send the events to the webhook
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// URL is the URL to POST the events to
+	URL string `mapstructure:"url" json:"url" validate:"required_with=Enabled,omitempty,url"`
+}
+
+// AccountEmailConfig defines the email the account lifecycle events are sent as
+type AccountEmailConfig struct {
+	// Enabled whether to send the events by email
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// SMTPServer is the "host:port" of the SMTP server
+	SMTPServer string `mapstructure:"smtpServer" json:"smtpServer" validate:"required_with=Enabled,omitempty,hostname_port"`
+	// Username if set, the SMTP user to authenticate as. The password is provided through the
+	// command line.
+	Username string `mapstructure:"username" json:"username,omitempty"`
+	// From is the sender address
+	From string `mapstructure:"from" json:"from" validate:"required_with=Enabled,omitempty,email"`
+	// To are the recipient addresses
+	To []string `mapstructure:"to" json:"to" validate:"required_with=Enabled,dive,email"`
+}
+
+// AccountNotificationConfig defines the notifications sent on account lifecycle events, so
+// security gets real-time awareness of access changes
+type AccountNotificationConfig struct {
+	// Enabled whether to send the notifications
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// Events are the events to notify on: "autoAdded", "deleted", "suspended", or
+	// "sensitiveRoleGranted"
+	Events []string `mapstructure:"events" json:"events" validate:"required_with=Enabled,dive,oneof=autoAdded deleted suspended sensitiveRoleGranted"`
+	// SensitiveRoles are the roles which trigger the "sensitiveRoleGranted" event when
+	// granted to a user
+	SensitiveRoles []string `mapstructure:"sensitiveRoles" json:"sensitiveRoles"`
+	// TimeoutSecs is the timeout for sending each notification in seconds
+	TimeoutSecs int `mapstructure:"timeoutSecs" json:"timeoutSecs" validate:"gte=1"`
+	// Webhook sends the events to a webhook as JSON
+	Webhook AccountWebhookConfig `mapstructure:"webhook" json:"webhook"`
+	// Slack sends the events to a Slack incoming webhook
+	Slack AccountWebhookConfig `mapstructure:"slack" json:"slack"`
+	// Email sends the events by email
+	Email AccountEmailConfig `mapstructure:"email" json:"email"`
+}
+
 // ===============================================================================
 // Complete Configuration Structures
 
 // AuthorizationServerConfig is the authorization server config
 type AuthorizationServerConfig struct {
+	// Version is the version of the config schema the config is written for
+	Version int `mapstructure:"version" json:"version"`
 	// Metrics metrics framework configuration
 	Metrics MetricsConfig `mapstructure:"metrics" json:"metrics" validate:"required,dive"`
 	// CustomRegex sets custom regex used by validator for custom field tags
@@ -354,38 +1730,163 @@ type AuthorizationServerConfig struct {
 	Authorization AuthorizationSubmodule `mapstructure:"authorize" json:"authorize" validate:"required,dive"`
 	// Authentication are the authentication submodule configs
 	Authentication AuthenticationSubmodule `mapstructure:"authenticate" json:"authenticate" validate:"required,dive"`
+	// CacheInvalidation are the cross replica cache invalidation configs
+	CacheInvalidation CacheInvalidationConfig `mapstructure:"cacheInvalidation" json:"cacheInvalidation" validate:"required,dive"`
+	// NATSResponder is the NATS request / response interface config
+	NATSResponder NATSResponderConfig `mapstructure:"natsResponder" json:"natsResponder"`
+	// Startup are the application startup configs
+	Startup StartupConfig `mapstructure:"startup" json:"startup" validate:"required,dive"`
+	// Shutdown are the application shutdown configs
+	Shutdown ShutdownConfig `mapstructure:"shutdown" json:"shutdown" validate:"required,dive"`
+	// Logging are the additional log sink configs
+	Logging LoggingConfig `mapstructure:"logging" json:"logging"`
+	// AccountNotifications are the account lifecycle notification configs
+	AccountNotifications AccountNotificationConfig `mapstructure:"accountNotifications" json:"accountNotifications"`
+	// UserIDNormalization sets how user IDs are normalized
+	UserIDNormalization UserIDNormalizationConfig `mapstructure:"userIDNormalization" json:"userIDNormalization"`
+	// ProxyPreset selects the request proxy preset, which sets the request parameter headers
+	// and the authorization response mode to suit the proxy. Values set explicitly in the config
+	// override the preset.
+	ProxyPreset string `mapstructure:"proxyPreset" json:"proxyPreset,omitempty" validate:"omitempty,oneof=traefik nginx caddy oauth2-proxy"`
+	// Tracing sets the export of the OpenTelemetry traces
+	Tracing TracingConfig `mapstructure:"tracing" json:"tracing"`
+	// KubernetesPolicy sets the reading of the roles and the authorization rules from
+	// Kubernetes custom resources
+	KubernetesPolicy KubernetesPolicyConfig `mapstructure:"kubernetesPolicy" json:"kubernetesPolicy"`
+}
+
+// ===============================================================================
+// Tracing Config
+
+// TracingConfig sets the export of the OpenTelemetry traces of the handled requests, the
+// database queries, and the calls to the OpenID issuers. The trace context of an incoming
+// request is continued, if present, whether or not the traces are exported.
+type TracingConfig struct {
+	// Enabled whether to export the traces
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// ServiceName is the service name the traces are reported under
+	ServiceName string `mapstructure:"serviceName" json:"serviceName" validate:"required_if=Enabled true"`
+	// Endpoint is the OTLP gRPC collector address in the form "host:port"
+	Endpoint string `mapstructure:"endpoint" json:"endpoint" validate:"required_if=Enabled true,omitempty,hostname_port"`
+	// Insecure whether to connect to the collector without TLS
+	Insecure bool `mapstructure:"insecure" json:"insecure"`
+	// Headers are additional headers sent with each export, i.e. for authentication
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty"`
+	// SampleRatio is the fraction of new traces which are sampled. A trace continued from an
+	// incoming request follows the sampling decision of the caller.
+	SampleRatio float64 `mapstructure:"sampleRatio" json:"sampleRatio" validate:"gte=0,lte=1"`
+	// TimeoutSecs is the export timeout in seconds
+	TimeoutSecs int `mapstructure:"timeoutSecs" json:"timeoutSecs" validate:"gte=1"`
+}
+
+// ===============================================================================
+// Kubernetes Policy Config
+
+// KubernetesPolicyConfig sets how the roles and the authorization rules are read from the
+// "PadlockRole" and "PadlockAuthorizationRule" custom resources of a Kubernetes cluster, so the
+// policy can be managed with GitOps in place of the config file
+type KubernetesPolicyConfig struct {
+	// Enabled whether to read the policy from the custom resources. The roles and the rules of
+	// the local config are used until the first successful sync, and while no resource of
+	// that kind exists.
+	Enabled bool `mapstructure:"enabled" json:"enabled"`
+	// APIServer is the URL of the Kubernetes API server
+	APIServer string `mapstructure:"apiServer" json:"apiServer" validate:"required_with=Enabled,omitempty,url"`
+	// Namespace is the namespace holding the custom resources. If not set, the namespace of
+	// the service account is used.
+	Namespace string `mapstructure:"namespace" json:"namespace,omitempty"`
+	// TokenFile is the file holding the service account token used to call the API server
+	TokenFile string `mapstructure:"tokenFile" json:"tokenFile" validate:"required_with=Enabled"`
+	// CAFile is the CA bundle verifying the API server certificate
+	CAFile string `mapstructure:"caFile" json:"caFile,omitempty"`
+	// Group is the API group of the custom resources
+	Group string `mapstructure:"group" json:"group" validate:"required_with=Enabled,omitempty,fqdn"`
+	// Version is the API version of the custom resources
+	Version string `mapstructure:"version" json:"version" validate:"required_with=Enabled"`
+	// SyncInterval interval (sec) between reading the custom resources
+	SyncInterval int `mapstructure:"syncIntervalSec" json:"syncIntervalSec" validate:"required_with=Enabled,omitempty,gte=5"`
+	// Timeout (sec) of each call to the API server
+	Timeout int `mapstructure:"timeoutSec" json:"timeoutSec" validate:"required_with=Enabled,omitempty,gte=1"`
 }
 
 // ===============================================================================
 
 // InstallDefaultAuthorizationServerConfigValues installs default config parameters in viper
 func InstallDefaultAuthorizationServerConfigValues() {
+	// Default config schema version
+	viper.SetDefault("version", ConfigVersion)
+
 	// Default metrics config
 	viper.SetDefault("metrics.metricsEndpoint", "/metrics")
 	viper.SetDefault("metrics.maxRequests", 4)
 	// Default metrics features config
 	viper.SetDefault("metrics.features.enableAppMetrics", false)
+	viper.SetDefault("metrics.features.enableRouteMetrics", false)
+	viper.SetDefault("metrics.features.maxRouteLabels", 50)
+	viper.SetDefault("metrics.features.enableDecisionMetrics", false)
+	viper.SetDefault("metrics.features.maxHostLabels", 50)
 	// Default metrics HTTP server config
 	viper.SetDefault("metrics.service.listenOn", "0.0.0.0")
 	viper.SetDefault("metrics.service.appPort", 2001)
 	viper.SetDefault("metrics.service.timeoutSecs.read", 60)
 	viper.SetDefault("metrics.service.timeoutSecs.write", 60)
 	viper.SetDefault("metrics.service.timeoutSecs.idle", 60)
+	viper.SetDefault("metrics.service.tls.enabled", false)
+	viper.SetDefault("metrics.tls.enabled", false)
+	viper.SetDefault("metrics.auth.mode", MetricsAuthModeNone)
+	viper.SetDefault("metrics.push.enabled", false)
+	viper.SetDefault("metrics.push.job", "padlock")
+	viper.SetDefault("metrics.push.timeoutSecs", 10)
+	viper.SetDefault("metrics.statsd.enabled", false)
+	viper.SetDefault("metrics.statsd.flavor", StatsDFlavorDogStatsD)
+	viper.SetDefault("metrics.statsd.prefix", "padlock.")
+	viper.SetDefault("metrics.statsd.flushIntervalSecs", 10)
 
 	// Default custom validation REGEX patterns
 	viper.SetDefault("customValidationRegex.userID", "^([[:alnum:]]|-|_)+$")
 	viper.SetDefault("customValidationRegex.username", "^([[:alnum:]]|-|_)+$")
 	viper.SetDefault("customValidationRegex.personalName", "^([[:alnum:]]|-)+$")
 	viper.SetDefault("customValidationRegex.roleName", "^([[:alnum:]]|-|_)+$")
-	viper.SetDefault("customValidationRegex.permission", "^([[:alnum:]]|-|_|:)+$")
+	viper.SetDefault("customValidationRegex.permission", "^([[:alnum:]]|-|_|:|\\.|\\*)+$")
+	viper.SetDefault("customValidationRegex.serviceAccountID", "^([[:alnum:]]|-|_)+$")
+
+	// Default tracing config
+	viper.SetDefault("tracing.enabled", false)
+	viper.SetDefault("tracing.serviceName", "padlock")
+	viper.SetDefault("tracing.endpoint", "127.0.0.1:4317")
+	viper.SetDefault("tracing.insecure", false)
+	viper.SetDefault("tracing.sampleRatio", 1.0)
+	viper.SetDefault("tracing.timeoutSecs", 10)
+
+	// Default Kubernetes policy config
+	viper.SetDefault("kubernetesPolicy.enabled", false)
+	viper.SetDefault("kubernetesPolicy.apiServer", "https://kubernetes.default.svc")
+	viper.SetDefault(
+		"kubernetesPolicy.tokenFile", "/var/run/secrets/kubernetes.io/serviceaccount/token",
+	)
+	viper.SetDefault(
+		"kubernetesPolicy.caFile", "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+	)
+	viper.SetDefault("kubernetesPolicy.group", "padlock.alwitt.github.com")
+	viper.SetDefault("kubernetesPolicy.version", "v1")
+	viper.SetDefault("kubernetesPolicy.syncIntervalSec", 30)
+	viper.SetDefault("kubernetesPolicy.timeoutSec", 10)
 
 	// Default user management submodule config
 	viper.SetDefault("userManagement.enabled", true)
 	viper.SetDefault("userManagement.service.listenOn", "0.0.0.0")
 	viper.SetDefault("userManagement.service.appPort", 3000)
+	viper.SetDefault("userManagement.grpc.enabled", false)
+	viper.SetDefault("userManagement.grpc.listenOn", "0.0.0.0")
+	viper.SetDefault("userManagement.grpc.appPort", 3004)
+	viper.SetDefault("userManagement.grpc.tls.enabled", false)
+	viper.SetDefault("userManagement.adminAuth.enabled", false)
+	viper.SetDefault("userManagement.adminAuth.permission", "padlock:admin")
+	viper.SetDefault("userManagement.adminAuth.userIDClaim", "sub")
 	viper.SetDefault("userManagement.service.timeoutSecs.read", 60)
 	viper.SetDefault("userManagement.service.timeoutSecs.write", 60)
 	viper.SetDefault("userManagement.service.timeoutSecs.idle", 600)
+	viper.SetDefault("userManagement.service.tls.enabled", false)
 	viper.SetDefault("userManagement.apis.requestLogging.logLevel", "warn")
 	viper.SetDefault("userManagement.apis.requestLogging.healthLogLevel", "debug")
 	viper.SetDefault("userManagement.apis.requestLogging.requestIDHeader", "X-Request-ID")
@@ -395,14 +1896,24 @@ func InstallDefaultAuthorizationServerConfigValues() {
 		},
 	)
 	viper.SetDefault("userManagement.apis.endPoint.pathPrefix", "/")
+	viper.SetDefault("userManagement.remoteUserRoles.enabled", false)
+	viper.SetDefault("userManagement.permissionWildcards.enabled", false)
+	viper.SetDefault("userManagement.permissionWildcards.separator", ":")
+	viper.SetDefault("userManagement.permissionWildcards.wildcard", "*")
+	viper.SetDefault("userManagement.permissionWildcards.recursiveWildcard", "**")
+	viper.SetDefault("userManagement.remoteUserRoles.syncIntervalSec", 300)
+	viper.SetDefault("userManagement.remoteUserRoles.timeoutSec", 10)
 
 	// Default authorization submodule config
 	viper.SetDefault("authorize.enabled", true)
+	viper.SetDefault("authorize.engine", AuthorizeEngineRules)
+	viper.SetDefault("authorize.rego.query", "data.padlock.authz.decision")
 	viper.SetDefault("authorize.service.listenOn", "0.0.0.0")
 	viper.SetDefault("authorize.service.appPort", 3001)
 	viper.SetDefault("authorize.service.timeoutSecs.read", 60)
 	viper.SetDefault("authorize.service.timeoutSecs.write", 60)
 	viper.SetDefault("authorize.service.timeoutSecs.idle", 600)
+	viper.SetDefault("authorize.service.tls.enabled", false)
 	viper.SetDefault("authorize.apis.requestLogging.logLevel", "warn")
 	viper.SetDefault("authorize.apis.requestLogging.healthLogLevel", "debug")
 	viper.SetDefault("authorize.apis.requestLogging.requestIDHeader", "X-Request-ID")
@@ -412,6 +1923,15 @@ func InstallDefaultAuthorizationServerConfigValues() {
 		},
 	)
 	viper.SetDefault("authorize.apis.endPoint.pathPrefix", "/")
+	viper.SetDefault("authorize.apis.cacheBypass.enabled", false)
+	viper.SetDefault("authorize.apis.cacheBypass.header", "Padlock-Cache-Bypass")
+	viper.SetDefault("authorize.apis.rateLimit.enabled", false)
+	viper.SetDefault("authorize.apis.rateLimit.global.ratePerSec", 1000)
+	viper.SetDefault("authorize.apis.rateLimit.global.burst", 2000)
+	viper.SetDefault("authorize.apis.rateLimit.perCaller.ratePerSec", 50)
+	viper.SetDefault("authorize.apis.rateLimit.perCaller.burst", 100)
+	viper.SetDefault("authorize.apis.rateLimit.perCaller.callerKey", "user_id")
+	viper.SetDefault("authorize.apis.rateLimit.perCaller.maxCallers", 100000)
 	viper.SetDefault("authorize.requestParamHeaders.host", "X-Forwarded-Host")
 	viper.SetDefault("authorize.requestParamHeaders.path", "X-Forwarded-Uri")
 	viper.SetDefault("authorize.requestParamHeaders.method", "X-Forwarded-Method")
@@ -420,6 +1940,47 @@ func InstallDefaultAuthorizationServerConfigValues() {
 	viper.SetDefault("authorize.requestParamHeaders.firstName", "X-Caller-Firstname")
 	viper.SetDefault("authorize.requestParamHeaders.lastName", "X-Caller-Lastname")
 	viper.SetDefault("authorize.requestParamHeaders.email", "X-Caller-Email")
+	viper.SetDefault("authorize.requestParamHeaders.clientID", "X-Caller-ClientID")
+	viper.SetDefault("authorize.requestParamHeaders.scopes", "X-Caller-Scopes")
+	viper.SetDefault("authorize.response.mode", AuthorizeResponseModeStandard)
+	viper.SetDefault("authorize.response.policyResourceHeader", "X-Method-Arn")
+	viper.SetDefault("authorize.response.includeDenyDetail", false)
+	viper.SetDefault("authorize.decisionCache.enabled", false)
+	viper.SetDefault("authorize.decisionCache.ttlSec", 30)
+	viper.SetDefault("authorize.decisionCache.maxEntries", 100000)
+	viper.SetDefault("authorize.decisionHistory.enabled", false)
+	viper.SetDefault("authorize.decisionHistory.retentionHours", 168)
+	viper.SetDefault("authorize.decisionHistory.maxEntries", 1000000)
+	viper.SetDefault("authorize.decisionHistory.flushIntervalSec", 5)
+	viper.SetDefault("authorize.decisionHistory.maxPending", 10000)
+	viper.SetDefault("authorize.decisionHistory.pruneIntervalSec", 300)
+	viper.SetDefault("authorize.webSocket.enabled", false)
+	viper.SetDefault("authorize.webSocket.upgradeHeader", "Upgrade")
+	viper.SetDefault("authorize.impersonation.enabled", false)
+	viper.SetDefault("authorize.impersonation.header", "X-Impersonate-UserID")
+	viper.SetDefault("authorize.quota.enabled", false)
+	viper.SetDefault("authorize.quota.backend", QuotaBackendMemory)
+	viper.SetDefault("authorize.loadShedding.enabled", false)
+	viper.SetDefault("authorize.loadShedding.windowSec", 10)
+	viper.SetDefault("authorize.loadShedding.minRequests", 20)
+	viper.SetDefault("authorize.loadShedding.latencyThresholdMs", 500)
+	viper.SetDefault("authorize.loadShedding.errorRatio", 0.5)
+	viper.SetDefault("authorize.loadShedding.cooldownSec", 5)
+	viper.SetDefault("authorize.loadShedding.maxInFlight", 0)
+	viper.SetDefault("authorize.loadShedding.statusCode", 503)
+	viper.SetDefault("authorize.loadShedding.failOpenPaths", []string{})
+	viper.SetDefault("authorize.extAuthz.enabled", false)
+	viper.SetDefault("authorize.extAuthz.listenOn", "0.0.0.0")
+	viper.SetDefault("authorize.extAuthz.appPort", 3003)
+	viper.SetDefault("authorize.failureResponse.enabled", false)
+	viper.SetDefault("authorize.failureResponse.browserAction", FailureActionJSON)
+	viper.SetDefault("authorize.failureResponse.acceptHeader", "Accept")
+	viper.SetDefault("authorize.failureResponse.schemeHeader", "X-Forwarded-Proto")
+	viper.SetDefault("authorize.failureResponse.returnToParam", "return_to")
+	viper.SetDefault("authorize.failureResponse.templateContentType", "text/html; charset=utf-8")
+	viper.SetDefault("authorize.forUnknownUser.autoAdd", false)
+	viper.SetDefault("authorize.forUnknownUser.alert.enabled", false)
+	viper.SetDefault("authorize.forUnknownUser.alert.timeoutSecs", 5)
 
 	// Default authentication submodule config
 	viper.SetDefault("authenticate.enabled", false)
@@ -428,6 +1989,7 @@ func InstallDefaultAuthorizationServerConfigValues() {
 	viper.SetDefault("authenticate.service.timeoutSecs.read", 60)
 	viper.SetDefault("authenticate.service.timeoutSecs.write", 60)
 	viper.SetDefault("authenticate.service.timeoutSecs.idle", 600)
+	viper.SetDefault("authenticate.service.tls.enabled", false)
 	viper.SetDefault("authenticate.apis.requestLogging.logLevel", "warn")
 	viper.SetDefault("authenticate.apis.requestLogging.healthLogLevel", "debug")
 	viper.SetDefault("authenticate.apis.requestLogging.requestIDHeader", "X-Request-ID")
@@ -437,12 +1999,123 @@ func InstallDefaultAuthorizationServerConfigValues() {
 		},
 	)
 	viper.SetDefault("authenticate.apis.endPoint.pathPrefix", "/")
+	viper.SetDefault("authenticate.apis.cacheBypass.enabled", false)
+	viper.SetDefault("authenticate.apis.cacheBypass.header", "Padlock-Cache-Bypass")
+	viper.SetDefault("authenticate.apis.rateLimit.enabled", false)
+	viper.SetDefault("authenticate.apis.rateLimit.global.ratePerSec", 1000)
+	viper.SetDefault("authenticate.apis.rateLimit.global.burst", 2000)
+	viper.SetDefault("authenticate.apis.rateLimit.perCaller.ratePerSec", 50)
+	viper.SetDefault("authenticate.apis.rateLimit.perCaller.burst", 100)
+	viper.SetDefault("authenticate.apis.rateLimit.perCaller.callerKey", "source_ip")
+	viper.SetDefault("authenticate.apis.rateLimit.perCaller.maxCallers", 100000)
 	viper.SetDefault("authenticate.targetClaims.userID", "sub")
+	viper.SetDefault("authenticate.targetClaims.clientID", []string{"azp", "client_id"})
+	viper.SetDefault("authenticate.targetClaims.scope", "scope")
 	viper.SetDefault("authenticate.requestParamHeaders.host", "X-Forwarded-Host")
 	viper.SetDefault("authenticate.requestParamHeaders.path", "X-Forwarded-Uri")
 	viper.SetDefault("authenticate.requestParamHeaders.method", "X-Forwarded-Method")
+	viper.SetDefault("authenticate.tokenHeader.header", "Authorization")
+	viper.SetDefault("authenticate.tokenHeader.schemes", []string{"Bearer"})
 	viper.SetDefault("authenticate.introspect.enabled", false)
 	viper.SetDefault("authenticate.introspect.recheckIntervalSec", 300)
 	viper.SetDefault("authenticate.introspect.cacheCleanIntervalSec", 3600)
 	viper.SetDefault("authenticate.introspect.cachePurgeIntervalSec", 43200)
+	viper.SetDefault("authenticate.introspect.inactiveCacheTTLSec", 10)
+	viper.SetDefault("authenticate.introspect.staleWhileRevalidate", true)
+	viper.SetDefault("authenticate.introspect.proactiveRecheckIntervalSec", 60)
+	viper.SetDefault("authenticate.introspect.maxCacheEntries", 100000)
+	viper.SetDefault("authenticate.introspect.cache.backend", TokenCacheBackendMemory)
+	viper.SetDefault("authenticate.introspect.persist.enabled", false)
+	viper.SetDefault("authenticate.introspect.persist.saveIntervalSec", 60)
+	viper.SetDefault("authenticate.issuerHealth.enabled", false)
+	viper.SetDefault("authenticate.issuerHealth.checkIntervalSec", 30)
+	viper.SetDefault("authenticate.issuerHealth.degradedMode", IssuerDegradedModeNone)
+	viper.SetDefault("authenticate.issuerHealth.gateReadiness", true)
+	viper.SetDefault("authenticate.saml.enabled", false)
+	viper.SetDefault("authenticate.saml.header", "X-SAML-Response")
+	viper.SetDefault("authenticate.saml.formField", "SAMLResponse")
+	viper.SetDefault("authenticate.cloudflareAccess.enabled", false)
+	viper.SetDefault("authenticate.cloudflareAccess.header", "Cf-Access-Jwt-Assertion")
+	viper.SetDefault("authenticate.cloudflareAccess.claims.userID", "sub")
+	viper.SetDefault("authenticate.cloudflareAccess.claims.email", "email")
+	viper.SetDefault("authenticate.apiKey.enabled", false)
+	viper.SetDefault("authenticate.apiKey.header", "X-API-Key")
+	viper.SetDefault("authenticate.apiKey.scheme", "ApiKey")
+	viper.SetDefault("authenticate.clientCert.enabled", false)
+	viper.SetDefault("authenticate.introspectEndpoint.enabled", false)
+	viper.SetDefault(
+		"authenticate.clientCert.headers", []string{"X-Forwarded-Client-Cert", "ssl-client-cert"},
+	)
+	viper.SetDefault("authenticate.clientCert.identitySources", []string{"uri", "dns", "cn"})
+	viper.SetDefault("authenticate.userinfo.enabled", false)
+	viper.SetDefault("authenticate.userinfo.cacheTTLSec", 300)
+	viper.SetDefault("authenticate.userinfo.maxCacheEntries", 10000)
+	viper.SetDefault("authenticate.failureResponse.enabled", false)
+	viper.SetDefault("authenticate.failureResponse.browserAction", FailureActionJSON)
+	viper.SetDefault("authenticate.failureResponse.acceptHeader", "Accept")
+	viper.SetDefault("authenticate.failureResponse.schemeHeader", "X-Forwarded-Proto")
+	viper.SetDefault("authenticate.failureResponse.returnToParam", "return_to")
+	viper.SetDefault("authenticate.failureResponse.templateContentType", "text/html; charset=utf-8")
+
+	// Default cache invalidation config
+	viper.SetDefault("cacheInvalidation.enabled", false)
+	viper.SetDefault("cacheInvalidation.channel", "padlock_cache_invalidation")
+
+	// Default NATS responder config
+	viper.SetDefault("natsResponder.enabled", false)
+	viper.SetDefault("natsResponder.url", "nats://127.0.0.1:4222")
+	viper.SetDefault("natsResponder.subject", "padlock.query")
+	viper.SetDefault("natsResponder.queueGroup", "padlock")
+
+	// Default startup config
+	viper.SetDefault("startup.retry.maxAttempts", 0)
+	viper.SetDefault("startup.retry.initialIntervalSec", 1)
+	viper.SetDefault("startup.retry.maxIntervalSec", 30)
+	viper.SetDefault("startup.dbConnect.retry.maxAttempts", 0)
+	viper.SetDefault("startup.dbConnect.retry.initialIntervalSec", 1)
+	viper.SetDefault("startup.dbConnect.retry.maxIntervalSec", 10)
+	viper.SetDefault("startup.dbConnect.maxWaitSec", 60)
+
+	// Default shutdown config
+	viper.SetDefault("shutdown.drainTimeoutSec", 30)
+
+	// Default logging config
+	viper.SetDefault("logging.file.enabled", false)
+	viper.SetDefault("logging.file.format", LogFormatJSON)
+	viper.SetDefault("logging.file.level", "info")
+	viper.SetDefault("logging.file.maxSizeMB", 100)
+	viper.SetDefault("logging.file.rotateIntervalHours", 24)
+	viper.SetDefault("logging.file.maxBackups", 7)
+	viper.SetDefault("logging.file.maxAgeDays", 30)
+	viper.SetDefault("logging.file.compress", true)
+	viper.SetDefault("logging.syslog.enabled", false)
+	viper.SetDefault("logging.syslog.network", "udp")
+	viper.SetDefault("logging.syslog.facility", "local0")
+	viper.SetDefault("logging.syslog.appName", "padlock")
+	viper.SetDefault("logging.syslog.level", "info")
+	viper.SetDefault("logging.loki.enabled", false)
+	viper.SetDefault("logging.loki.batchSize", 100)
+	viper.SetDefault("logging.loki.flushIntervalSecs", 5)
+	viper.SetDefault("logging.loki.timeoutSecs", 10)
+	viper.SetDefault("logging.loki.level", "info")
+
+	// Default account lifecycle notification config
+	viper.SetDefault("accountNotifications.enabled", false)
+	viper.SetDefault("accountNotifications.events", []string{
+		AccountEventAutoAdded,
+		AccountEventDeleted,
+		AccountEventSuspended,
+		AccountEventSensitiveRoleGranted,
+	})
+	viper.SetDefault("accountNotifications.sensitiveRoles", []string{})
+	viper.SetDefault("accountNotifications.timeoutSecs", 10)
+	viper.SetDefault("accountNotifications.webhook.enabled", false)
+	viper.SetDefault("accountNotifications.slack.enabled", false)
+	viper.SetDefault("accountNotifications.email.enabled", false)
+
+	// Default user ID normalization config
+	viper.SetDefault("userIDNormalization.trimSpace", false)
+	viper.SetDefault("userIDNormalization.stripEmailDomain", false)
+	viper.SetDefault("userIDNormalization.emailDomains", []string{})
+	viper.SetDefault("userIDNormalization.lowercase", false)
 }
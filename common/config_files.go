@@ -0,0 +1,107 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigVersion is the current version of the application config schema
+const ConfigVersion = 1
+
+// configAppendPaths config paths whose lists are appended together across the config files,
+// rather than replaced by the later file
+var configAppendPaths = map[string]bool{"authorize.rules": true}
+
+// configFileExtensions extensions of the files read from a config directory
+var configFileExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+/*
+ListConfigDir list the config files in a config directory, in lexical order. Only the files
+with the extension ".yaml", ".yml", or ".json" are listed.
+
+	@param dir string - the config directory
+	@return the config files
+*/
+func ListConfigDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !configFileExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			continue
+		}
+		result = append(result, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+/*
+ReadConfigFiles read multiple application config files, merging them in order into the
+application config. Sections are merged key by key, with the values of a later file
+replacing those of an earlier one, except for the authorization rules, which are appended
+together. All of the files which set a config "version" must set the same version.
+
+	@param files []string - the config files, in merge order
+	@return whether successful
+*/
+func ReadConfigFiles(files []string) error {
+	merged := map[string]interface{}{}
+	versionFile := ""
+	version := 0
+	for _, file := range files {
+		fileConfig := viper.New()
+		fileConfig.SetConfigFile(file)
+		if err := fileConfig.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read config file %s: %w", file, err)
+		}
+		if fileConfig.IsSet("version") {
+			fileVersion := fileConfig.GetInt("version")
+			if versionFile != "" && fileVersion != version {
+				return fmt.Errorf(
+					"config file %s is version %d, while %s is version %d",
+					file, fileVersion, versionFile, version,
+				)
+			}
+			versionFile, version = file, fileVersion
+		}
+		mergeConfigValues(merged, fileConfig.AllSettings(), "")
+	}
+	return viper.MergeConfigMap(merged)
+}
+
+/*
+mergeConfigValues merge the config values of one file into the merged config values
+
+	@param merged map[string]interface{} - the merged config values
+	@param values map[string]interface{} - the config values of one file
+	@param path string - the config path of the values
+*/
+func mergeConfigValues(merged, values map[string]interface{}, path string) {
+	for key, value := range values {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		mergedMap, mergedIsMap := merged[key].(map[string]interface{})
+		valueMap, valueIsMap := value.(map[string]interface{})
+		if mergedIsMap && valueIsMap {
+			mergeConfigValues(mergedMap, valueMap, keyPath)
+			continue
+		}
+		mergedList, mergedIsList := merged[key].([]interface{})
+		valueList, valueIsList := value.([]interface{})
+		if mergedIsList && valueIsList && configAppendPaths[keyPath] {
+			merged[key] = append(mergedList, valueList...)
+			continue
+		}
+		merged[key] = value
+	}
+}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfigFiles(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Restore the standard defaults for the other tests
+	defer func() {
+		viper.Reset()
+		InstallDefaultAuthorizationServerConfigValues()
+	}()
+
+	configDir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(configDir, name)
+		assert.Nil(os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+	readConfig := func(files []string) (AuthorizationServerConfig, error) {
+		viper.Reset()
+		InstallDefaultAuthorizationServerConfigValues()
+		var cfg AuthorizationServerConfig
+		if err := ReadConfigFiles(files); err != nil {
+			return cfg, err
+		}
+		assert.Nil(viper.Unmarshal(&cfg))
+		return cfg, cfg.Validate()
+	}
+
+	baseFile := writeFile("base.yaml", `---
+version: 1
+userManagement:
+  userRoles:
+    reader:
+      permissions:
+        - read
+authorize:
+  rules:
+    - host: "*"
+      allowedPaths:
+        - pathPattern: "^/path1$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`)
+	hostsDir := filepath.Join(configDir, "hosts")
+	assert.Nil(os.Mkdir(hostsDir, 0700))
+	assert.Nil(os.WriteFile(filepath.Join(hostsDir, "b-host.yaml"), []byte(`---
+userManagement:
+  userRoles:
+    writer:
+      permissions:
+        - write
+authorize:
+  rules:
+    - host: "b.unit-test.org"
+      allowedPaths:
+        - pathPattern: "^/path2$"
+          allowedMethods:
+            - method: PUT
+              allowedPermissions:
+                - write`), 0600))
+	assert.Nil(os.WriteFile(filepath.Join(hostsDir, "a-host.json"), []byte(`{
+  "authorize": {
+    "rules": [
+      {
+        "host": "a.unit-test.org",
+        "allowedPaths": [
+          {
+            "pathPattern": "^/path3$",
+            "allowedMethods": [{"method": "GET", "allowedPermissions": ["read"]}]
+          }
+        ]
+      }
+    ]
+  }
+}`), 0600))
+	assert.Nil(os.WriteFile(filepath.Join(hostsDir, "README.md"), []byte("# hosts"), 0600))
+
+	// Case 0: list the config directory
+	hostFiles, err := ListConfigDir(hostsDir)
+	assert.Nil(err)
+	assert.Equal(
+		[]string{filepath.Join(hostsDir, "a-host.json"), filepath.Join(hostsDir, "b-host.yaml")},
+		hostFiles,
+	)
+
+	// Case 1: the rules are appended together, and the roles merged
+	{
+		cfg, err := readConfig(append([]string{baseFile}, hostFiles...))
+		assert.Nil(err)
+		assert.Equal(ConfigVersion, cfg.Version)
+		assert.Len(cfg.Authorization.Rules, 3)
+		hosts := []string{}
+		for _, rule := range cfg.Authorization.Rules {
+			hosts = append(hosts, rule.Host)
+		}
+		assert.Equal([]string{"*", "a.unit-test.org", "b.unit-test.org"}, hosts)
+		assert.Contains(cfg.UserManagement.AvailableRoles, "reader")
+		assert.Contains(cfg.UserManagement.AvailableRoles, "writer")
+	}
+
+	// Case 2: the merged config is validated together
+	{
+		duplicate := writeFile("duplicate.yaml", `---
+authorize:
+  rules:
+    - host: "*"
+      allowedPaths:
+        - pathPattern: "^/path4$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`)
+		_, err := readConfig([]string{baseFile, duplicate})
+		assert.NotNil(err)
+	}
+
+	// Case 3: the files must agree on the config version
+	{
+		newer := writeFile("newer.yaml", "---\nversion: 2")
+		_, err := readConfig([]string{baseFile, newer})
+		assert.NotNil(err)
+		// An unsupported version is rejected
+		_, err = readConfig([]string{newer})
+		assert.NotNil(err)
+	}
+
+	// Case 4: a missing config file
+	{
+		_, err := readConfig([]string{filepath.Join(configDir, "missing.yaml")})
+		assert.NotNil(err)
+	}
+}
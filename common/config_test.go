@@ -2,7 +2,19 @@ package common
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/spf13/viper"
@@ -392,4 +404,937 @@ authenticate:
 		assert.Nil(viper.Unmarshal(&cfg))
 		assert.NotNil(cfg.Validate())
 	}
+
+	// Case 14: path rules with request attribute conditions
+	{
+		config := []byte(`---
+userManagement:
+  userRoles:
+    user:
+      permissions:
+        - write
+        - read
+authorize:
+  requestParamHeaders:
+    attributes:
+      scheme: X-Forwarded-Proto
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/path1$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read
+        - pathPattern: "^/path1$"
+          attributes:
+            scheme: "^https$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - write`)
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		assert.Nil(cfg.Validate())
+		assert.Equal(
+			map[string]string{"scheme": "X-Forwarded-Proto"},
+			cfg.Authorization.RequestParamLocation.Attributes,
+		)
+		assert.Equal(
+			map[string]string{"scheme": "^https$"}, cfg.Authorization.Rules[0].TargetPaths[1].Attributes,
+		)
+	}
+
+	// Case 15: path rule using an undefined request attribute
+	{
+		config := []byte(`---
+userManagement:
+  userRoles:
+    user:
+      permissions:
+        - read
+authorize:
+  requestParamHeaders:
+    attributes:
+      scheme: X-Forwarded-Proto
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/path1$"
+          attributes:
+            sourceAddress: "^10\\."
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`)
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		assert.NotNil(cfg.Validate())
+	}
+
+	// Case 16: rule allowing anonymous access
+	{
+		config := []byte(`---
+userManagement:
+  userRoles:
+    user:
+      permissions:
+        - read
+authorize:
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/public$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - "@anonymous"
+            - method: POST
+              allowedPermissions:
+                - read`)
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		assert.Nil(cfg.Validate())
+	}
+
+	// Case 17: role assigned the anonymous permission
+	{
+		config := []byte(`---
+userManagement:
+  userRoles:
+    user:
+      permissions:
+        - "@anonymous"
+authorize:
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/public$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - "@anonymous"`)
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		assert.NotNil(cfg.Validate())
+	}
+
+	// Case 18: impersonation permission must be assigned to a role
+	for _, testCase := range []struct {
+		permission string
+		valid      bool
+	}{{"impersonate", true}, {"unknown", false}, {"", false}} {
+		config := []byte(fmt.Sprintf(`---
+userManagement:
+  userRoles:
+    support:
+      permissions:
+        - impersonate
+        - read
+authorize:
+  impersonation:
+    enabled: true
+    permission: "%s"
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/path1$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`, testCase.permission))
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate())
+		} else {
+			assert.NotNil(cfg.Validate())
+		}
+	}
+
+	// Case 19: request quotas
+	for _, testCase := range []struct {
+		quota string
+		valid bool
+	}{
+		{"backend: memory\n    rules:\n      - name: all\n        requests: 10\n        windowSec: 60", true},
+		{"backend: memory\n    rules:\n      - name: reads\n        permission: read\n        requests: 10\n        windowSec: 60", true},
+		{"backend: memory\n    rules:\n      - name: other\n        permission: unknown\n        requests: 10\n        windowSec: 60", false},
+		{"backend: memory\n    rules:\n      - name: all\n        requests: 0\n        windowSec: 60", false},
+		{"backend: memory\n    rules:\n      - name: all\n        requests: 1\n        windowSec: 1\n      - name: all\n        requests: 10\n        windowSec: 60", false},
+		{"backend: redis\n    rules:\n      - name: all\n        requests: 10\n        windowSec: 60", false},
+		{"backend: redis\n    redis:\n      address: localhost:6379\n      key: padlock:quota\n    rules:\n      - name: all\n        requests: 10\n        windowSec: 60", true},
+		{"backend: memory", false},
+	} {
+		config := []byte(fmt.Sprintf(`---
+userManagement:
+  userRoles:
+    reader:
+      permissions:
+        - read
+authorize:
+  quota:
+    enabled: true
+    %s
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/path1$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`, testCase.quota))
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate(), testCase.quota)
+		} else {
+			assert.NotNil(cfg.Validate(), testCase.quota)
+		}
+	}
+
+	// Case 20: account lifecycle notifications
+	for _, testCase := range []struct {
+		notify string
+		valid  bool
+	}{
+		{"sensitiveRoles:\n    - reader\n  webhook:\n    enabled: true\n    url: http://hooks.unittest.org/padlock", true},
+		{"sensitiveRoles:\n    - writer", false},
+		{"events:\n    - suspended", true},
+		{"events:\n    - disabled", false},
+		{"webhook:\n    enabled: true", false},
+		{"email:\n    enabled: true\n    smtpServer: smtp.unittest.org:587\n    from: padlock@unittest.org\n    to:\n      - security@unittest.org", true},
+		{"email:\n    enabled: true\n    smtpServer: smtp.unittest.org:587\n    from: padlock@unittest.org\n    to:\n      - security", false},
+	} {
+		config := []byte(fmt.Sprintf(`---
+userManagement:
+  userRoles:
+    reader:
+      permissions:
+        - read
+accountNotifications:
+  enabled: true
+  %s
+authorize:
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/path1$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`, testCase.notify))
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate(), testCase.notify)
+		} else {
+			assert.NotNil(cfg.Validate(), testCase.notify)
+		}
+	}
+
+	// Case 21: rule granting clients directly
+	for _, testCase := range []struct {
+		method string
+		valid  bool
+	}{
+		{method: `
+            - method: GET
+              allowedClients:
+                - report-service`, valid: true},
+		{method: `
+            - method: GET
+              allowedPermissions:
+                - read
+              allowedClients:
+                - report-service`, valid: true},
+		{method: `
+            - method: GET`, valid: false},
+	} {
+		config := []byte(`---
+userManagement:
+  userRoles:
+    user:
+      permissions:
+        - read
+authorize:
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/reports$"
+          allowedMethods:` + testCase.method)
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate(), testCase.method)
+		} else {
+			assert.NotNil(cfg.Validate(), testCase.method)
+		}
+	}
+
+	// Case 22: role restricted to some hosts
+	for _, testCase := range []struct {
+		hosts string
+		valid bool
+	}{
+		{hosts: `
+        - staging.example.com`, valid: true},
+		{hosts: `
+        - "https://staging.example.com"`, valid: false},
+	} {
+		config := []byte(`---
+userManagement:
+  userRoles:
+    admin:
+      permissions:
+        - write
+      allowedHosts:` + testCase.hosts + `
+authorize:
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/reports$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - write`)
+		viper.SetConfigType("yaml")
+		err := viper.ReadConfig(bytes.NewBuffer(config))
+		assert.Nil(err)
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate(), testCase.hosts)
+			assert.True(cfg.UserManagement.AvailableRoles["admin"].AppliesToHost("staging.example.com"))
+			assert.False(cfg.UserManagement.AvailableRoles["admin"].AppliesToHost("prod.example.com"))
+		} else {
+			assert.NotNil(cfg.Validate(), testCase.hosts)
+		}
+	}
+
+	// Case 23: Rego authorization engine
+	{
+		policyFile, err := os.CreateTemp("", "padlock-policy-*.rego")
+		assert.Nil(err)
+		defer os.Remove(policyFile.Name())
+		assert.Nil(policyFile.Close())
+		for _, testCase := range []struct {
+			rego  string
+			valid bool
+		}{
+			{rego: `
+  engine: rego
+  rego:
+    policyFile: ` + policyFile.Name(), valid: true},
+			{rego: `
+  engine: rego
+  rego:
+    policyFile: /no/such/policy.rego`, valid: false},
+			{rego: `
+  engine: rego`, valid: false},
+			{rego: `
+  engine: rules`, valid: false},
+			{rego: `
+  engine: opa`, valid: false},
+		} {
+			config := []byte(`---
+userManagement:
+  userRoles:
+    admin:
+      permissions:
+        - write
+authorize:` + testCase.rego)
+			viper.SetConfigType("yaml")
+			assert.Nil(viper.ReadConfig(bytes.NewBuffer(config)))
+			var cfg AuthorizationServerConfig
+			assert.Nil(viper.Unmarshal(&cfg))
+			if testCase.valid {
+				assert.Nil(cfg.Validate(), testCase.rego)
+				assert.Equal("data.padlock.authz.decision", cfg.Authorization.Rego.Query)
+			} else {
+				assert.NotNil(cfg.Validate(), testCase.rego)
+			}
+		}
+	}
+
+	// Case 24: deny paths
+	for _, testCase := range []struct {
+		deny  string
+		valid bool
+	}{
+		{deny: `
+        - pathPattern: "^/admin/.+$"
+          methods:
+            - DELETE`, valid: true},
+		{deny: `
+        - pathPattern: "^/reports/.+$"
+          deniedPermissions:
+            - read`, valid: true},
+		{deny: `
+        - pathPattern: "^/reports/.+$"
+          deniedPermissions:
+            - unknown`, valid: false},
+		{deny: `
+        - pathPattern: "^/admin/.+$"
+          methods:
+            - FETCH`, valid: false},
+		{deny: `
+        - methods:
+            - DELETE`, valid: false},
+	} {
+		config := []byte(`---
+userManagement:
+  userRoles:
+    user:
+      permissions:
+        - read
+authorize:
+  rules:
+    - host: "*"
+      denyPaths:` + testCase.deny + `
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/reports$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`)
+		viper.SetConfigType("yaml")
+		assert.Nil(viper.ReadConfig(bytes.NewBuffer(config)))
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate(), testCase.deny)
+		} else {
+			assert.NotNil(cfg.Validate(), testCase.deny)
+		}
+	}
+
+	// Case 25: role inheritance
+	for _, testCase := range []struct {
+		roles string
+		valid bool
+	}{
+		{roles: `
+    reader:
+      permissions:
+        - read
+    admin:
+      permissions:
+        - write
+      inherits:
+        - reader
+    auditor:
+      inherits:
+        - reader`, valid: true},
+		{roles: `
+    reader:
+      permissions:
+        - read
+    admin:
+      permissions:
+        - write
+      inherits:
+        - writer`, valid: false},
+		{roles: `
+    reader:
+      permissions:
+        - read
+      inherits:
+        - admin
+    admin:
+      permissions:
+        - write
+      inherits:
+        - reader`, valid: false},
+		{roles: `
+    reader:
+      permissions:
+        - read
+    admin:
+      allowedHosts:
+        - unittest.testing.org`, valid: false},
+	} {
+		config := []byte(`---
+userManagement:
+  userRoles:` + testCase.roles + `
+authorize:
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/reports$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`)
+		viper.SetConfigType("yaml")
+		assert.Nil(viper.ReadConfig(bytes.NewBuffer(config)))
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate(), testCase.roles)
+			roles, err := ResolveRoleInheritance(cfg.UserManagement.AvailableRoles)
+			assert.Nil(err)
+			assert.Equal([]string{"write", "read"}, roles["admin"].AssignedPermissions)
+			assert.Equal([]string{"read"}, roles["auditor"].AssignedPermissions)
+		} else {
+			assert.NotNil(cfg.Validate(), testCase.roles)
+		}
+	}
+
+	// Case 26: the ext_authz API only works with the standard response mode
+	for _, testCase := range []struct {
+		extAuthz bool
+		mode     string
+		valid    bool
+	}{
+		{extAuthz: true, mode: AuthorizeResponseModeStandard, valid: true},
+		{extAuthz: true, mode: AuthorizeResponseModeMinimal, valid: false},
+		{extAuthz: true, mode: AuthorizeResponseModeAPIGateway, valid: false},
+		{extAuthz: false, mode: AuthorizeResponseModeMinimal, valid: true},
+		{extAuthz: false, mode: AuthorizeResponseModeAPIGateway, valid: true},
+	} {
+		config := []byte(fmt.Sprintf(`---
+userManagement:
+  userRoles:
+    reader:
+      permissions:
+        - read
+authorize:
+  response:
+    mode: %s
+  extAuthz:
+    enabled: %v
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/reports$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - read`, testCase.mode, testCase.extAuthz))
+		viper.SetConfigType("yaml")
+		assert.Nil(viper.ReadConfig(bytes.NewBuffer(config)))
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate(), testCase.mode)
+		} else {
+			assert.NotNil(cfg.Validate(), testCase.mode)
+		}
+	}
+}
+
+func TestCheckHeaderConsistency(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	InstallDefaultAuthorizationServerConfigValues()
+	viper.SetConfigType("yaml")
+	assert.Nil(viper.ReadConfig(bytes.NewBuffer([]byte(`---
+userManagement:
+  enabled: true
+authorize:
+  enabled: true
+authenticate:
+  enabled: true`))))
+	var base AuthorizationServerConfig
+	assert.Nil(viper.Unmarshal(&base))
+
+	// Case 0: default headers are consistent
+	{
+		assert.Empty(base.CheckHeaderConsistency())
+	}
+
+	// Case 1: authorization header used for two parameters
+	{
+		cfg := base
+		cfg.Authorization.RequestParamLocation.Email = "x-caller-userid"
+		assert.Equal(
+			[]HeaderIssue{
+				{
+					Severe: true,
+					Description: "authorize.requestParamHeaders uses header X-Caller-Userid for " +
+						"both email and userID",
+				},
+				{
+					Severe: true,
+					Description: "authentication uses header X-Caller-Userid for both " +
+						"authorize email and authorize userID",
+				},
+			},
+			cfg.CheckHeaderConsistency(),
+		)
+	}
+
+	// Case 2: authentication request header collides with a user parameter header
+	{
+		cfg := base
+		cfg.Authentication.RequestParamLocation.Path = "X-Caller-Username"
+		issues := cfg.CheckHeaderConsistency()
+		assert.Len(issues, 2)
+		assert.True(issues[0].Severe)
+		assert.Contains(issues[0].Description, "X-Caller-Username")
+		assert.False(issues[1].Severe)
+		assert.Equal(
+			"request path is read from X-Caller-Username for authentication, but from "+
+				"X-Forwarded-Uri for authorization",
+			issues[1].Description,
+		)
+	}
+
+	// Case 3: request ID headers differ
+	{
+		cfg := base
+		cfg.Authentication.APIs.RequestLogging.RequestIDHeader = "X-Correlation-ID"
+		assert.Equal(
+			[]HeaderIssue{
+				{
+					Description: "request ID headers differ between submodules: " +
+						"authenticate=X-Correlation-Id, authorize=X-Request-Id, " +
+						"userManagement=X-Request-Id",
+				},
+			},
+			cfg.CheckHeaderConsistency(),
+		)
+		// Only the enabled submodules are checked
+		cfg.Authentication.Enabled = false
+		assert.Empty(cfg.CheckHeaderConsistency())
+	}
+}
+
+func TestIntrospectionConfigForIssuer(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	shared := IntrospectionConfig{
+		Enabled: true, ReIntrospectInterval: 300, InactiveCacheTTL: 10, MaxCacheEntries: 1000,
+	}
+
+	// Case 0: issuer without its own token cache settings
+	assert.Equal(shared, shared.ForIssuer(OpenIDIssuerConfig{Issuer: "https://a.unit-test.org"}))
+
+	// Case 1: issuer overriding some of the token cache settings
+	{
+		recheck := 30
+		inactiveTTL := 0
+		issuerCfg := shared.ForIssuer(OpenIDIssuerConfig{
+			Issuer: "https://a.unit-test.org",
+			TokenCache: &IssuerTokenCacheConfig{
+				ReIntrospectInterval: &recheck, InactiveCacheTTL: &inactiveTTL,
+			},
+		})
+		assert.Equal(30, issuerCfg.ReIntrospectInterval)
+		assert.Equal(0, issuerCfg.InactiveCacheTTL)
+		assert.Equal(1000, issuerCfg.MaxCacheEntries)
+		assert.Equal(300, shared.ReIntrospectInterval)
+	}
+}
+
+func TestUserIDNormalizationConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	type testCase struct {
+		policy   UserIDNormalizationConfig
+		userID   string
+		expected string
+	}
+	testCases := []testCase{
+		{policy: UserIDNormalizationConfig{}, userID: " Alice@Example.com ", expected: " Alice@Example.com "},
+		{policy: UserIDNormalizationConfig{TrimSpace: true}, userID: " Alice ", expected: "Alice"},
+		{policy: UserIDNormalizationConfig{Lowercase: true}, userID: "Alice", expected: "alice"},
+		{
+			policy: UserIDNormalizationConfig{StripEmailDomain: true}, userID: "Alice@Example.com",
+			expected: "Alice",
+		},
+		{
+			policy:   UserIDNormalizationConfig{TrimSpace: true, StripEmailDomain: true, Lowercase: true},
+			userID:   " Alice@Example.com ",
+			expected: "alice",
+		},
+		// An ID which only starts with "@" has no local part to keep
+		{policy: UserIDNormalizationConfig{StripEmailDomain: true}, userID: "@alice", expected: "@alice"},
+		// Only the listed domains are removed
+		{
+			policy: UserIDNormalizationConfig{
+				StripEmailDomain: true, EmailDomains: []string{"example.com", "example.org"},
+			},
+			userID:   "alice@Example.COM",
+			expected: "alice",
+		},
+		{
+			policy: UserIDNormalizationConfig{
+				StripEmailDomain: true, EmailDomains: []string{"example.com", "example.org"},
+			},
+			userID:   "alice@partner.com",
+			expected: "alice@partner.com",
+		},
+	}
+	for _, oneTest := range testCases {
+		assert.Equal(oneTest.expected, oneTest.policy.Normalize(oneTest.userID), oneTest.userID)
+	}
+}
+
+func TestParseOpenIDIssuerParams(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Case 0: single issuer
+	{
+		issuers, err := ParseOpenIDIssuerParams([]byte(`{"issuer": "https://idp.unit-test.org"}`))
+		assert.Nil(err)
+		assert.Len(issuers, 1)
+		assert.Equal("https://idp.unit-test.org", issuers[0].Issuer)
+		assert.Nil(issuers[0].Claims)
+	}
+
+	// Case 1: list of issuers, with per-issuer claims
+	{
+		issuers, err := ParseOpenIDIssuerParams([]byte(`
+[
+  {"issuer": "https://workforce.unit-test.org", "shared_user_ids": true},
+  {
+    "issuer": "https://customer.unit-test.org",
+    "claims": {"userID": "customer_id"},
+    "user_id_namespace": "customer"
+  }
+]`))
+		assert.Nil(err)
+		assert.Len(issuers, 2)
+		assert.Equal("https://workforce.unit-test.org", issuers[0].Issuer)
+		assert.True(issuers[0].SharedUserIDs)
+		assert.NotNil(issuers[1].Claims)
+		assert.Equal("customer_id", issuers[1].Claims.UserIDClaim)
+		assert.Equal("customer", *issuers[1].UserIDNamespace)
+	}
+
+	// Case 2: empty list
+	{
+		_, err := ParseOpenIDIssuerParams([]byte(`[]`))
+		assert.NotNil(err)
+	}
+
+	// Case 3: issuer listed twice
+	{
+		_, err := ParseOpenIDIssuerParams([]byte(
+			`[{"issuer": "https://idp.unit-test.org", "shared_user_ids": true},` +
+				` {"issuer": "https://idp.unit-test.org/", "shared_user_ids": true}]`,
+		))
+		assert.NotNil(err)
+	}
+
+	// Case 4: several issuers must state how their user IDs relate
+	for idx, params := range []string{
+		`[{"issuer": "https://a.unit-test.org", "user_id_namespace": "a"},` +
+			` {"issuer": "https://b.unit-test.org"}]`,
+		`[{"issuer": "https://a.unit-test.org", "user_id_namespace": "a"},` +
+			` {"issuer": "https://b.unit-test.org", "user_id_namespace": "a"}]`,
+		`[{"issuer": "https://a.unit-test.org", "user_id_namespace": "a"},` +
+			` {"issuer": "https://b.unit-test.org", "user_id_namespace": "b", "shared_user_ids": true}]`,
+	} {
+		_, err := ParseOpenIDIssuerParams([]byte(params))
+		assert.NotNil(err, fmt.Sprintf("case %d", idx))
+	}
+	{
+		issuers, err := ParseOpenIDIssuerParams([]byte(
+			`[{"issuer": "https://a.unit-test.org", "user_id_namespace": "a"},` +
+				` {"issuer": "https://b.unit-test.org", "user_id_namespace": "b"}]`,
+		))
+		assert.Nil(err)
+		assert.Len(issuers, 2)
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Generate a self-signed server certificate
+	testDir := t.TempDir()
+	certFile := filepath.Join(testDir, "server.crt")
+	keyFile := filepath.Join(testDir, "server.key")
+	{
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.Nil(err)
+		template := x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "padlock.unit-test.org"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+		assert.Nil(err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		assert.Nil(err)
+		assert.Nil(os.WriteFile(
+			certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600,
+		))
+		assert.Nil(os.WriteFile(
+			keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600,
+		))
+	}
+
+	// Case 0: TLS not enabled
+	{
+		tlsConfig, err := ServerTLSConfig{}.DefineTLSConfig()
+		assert.Nil(err)
+		assert.Nil(tlsConfig)
+	}
+
+	// Case 1: TLS without client certificates
+	{
+		tlsConfig, err := ServerTLSConfig{
+			Enabled: true, CertFile: certFile, KeyFile: keyFile,
+		}.DefineTLSConfig()
+		assert.Nil(err)
+		assert.NotNil(tlsConfig)
+		assert.Len(tlsConfig.Certificates, 1)
+		assert.Equal(tls.NoClientCert, tlsConfig.ClientAuth)
+	}
+
+	// Case 2: mutual TLS
+	{
+		tlsConfig, err := ServerTLSConfig{
+			Enabled: true, CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile,
+		}.DefineTLSConfig()
+		assert.Nil(err)
+		assert.NotNil(tlsConfig)
+		assert.Equal(tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+		assert.NotNil(tlsConfig.ClientCAs)
+	}
+
+	// Case 3: client CA file with no certificates
+	{
+		_, err := ServerTLSConfig{
+			Enabled: true, CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile,
+		}.DefineTLSConfig()
+		assert.NotNil(err)
+	}
+
+	// Case 4: key does not match the certificate
+	{
+		_, err := ServerTLSConfig{
+			Enabled: true, CertFile: keyFile, KeyFile: certFile,
+		}.DefineTLSConfig()
+		assert.NotNil(err)
+	}
+}
+
+func TestPermissionWildcards(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Case 0: wildcards not enabled
+	{
+		uut := PermissionWildcardConfig{Separator: ":", Wildcard: "*", RecursiveWildcard: "**"}
+		assert.True(uut.Grants("catalog:read", "catalog:read"))
+		assert.False(uut.Grants("catalog:*", "catalog:read"))
+	}
+
+	// Case 1: wildcard semantics
+	{
+		uut := PermissionWildcardConfig{
+			Enabled: true, Separator: ":", Wildcard: "*", RecursiveWildcard: "**",
+		}
+		for _, testCase := range []struct {
+			held     string
+			required string
+			granted  bool
+		}{
+			{held: "catalog:read", required: "catalog:read", granted: true},
+			{held: "catalog:read", required: "catalog:write", granted: false},
+			{held: "catalog:*", required: "catalog:read", granted: true},
+			{held: "catalog:*", required: "catalog", granted: false},
+			{held: "catalog:*", required: "catalog:items:read", granted: false},
+			{held: "*:read", required: "orders:read", granted: true},
+			{held: "*:read", required: "orders:write", granted: false},
+			{held: "catalog:**", required: "catalog:read", granted: true},
+			{held: "catalog:**", required: "catalog:items:read", granted: true},
+			{held: "catalog:**", required: "catalog", granted: false},
+			{held: "catalog:**", required: "orders:read", granted: false},
+			{held: "**", required: "anything:at:all", granted: true},
+			{held: "catalog:**:read", required: "catalog:items:read", granted: false},
+		} {
+			assert.Equal(
+				testCase.granted,
+				uut.Grants(testCase.held, testCase.required),
+				"%s => %s", testCase.held, testCase.required,
+			)
+		}
+	}
+
+	// Case 2: custom separator
+	{
+		uut := PermissionWildcardConfig{
+			Enabled: true, Separator: ".", Wildcard: "*", RecursiveWildcard: "**",
+		}
+		assert.True(uut.Grants("admin.**", "admin.users.delete"))
+		assert.False(uut.Grants("admin.**", "admin:users"))
+	}
+
+	InstallDefaultAuthorizationServerConfigValues()
+
+	// Case 3: rules requiring permissions satisfied by wildcard permissions of roles
+	for _, testCase := range []struct {
+		wildcards string
+		valid     bool
+	}{
+		{wildcards: `
+  permissionWildcards:
+    enabled: true`, valid: true},
+		{wildcards: `
+  permissionWildcards:
+    enabled: false`, valid: false},
+	} {
+		config := []byte(`---
+userManagement:
+  userRoles:
+    catalog-admin:
+      permissions:
+        - "catalog:*"` + testCase.wildcards + `
+authorize:
+  rules:
+    - host: unittest.testing.org
+      allowedPaths:
+        - pathPattern: "^/catalog$"
+          allowedMethods:
+            - method: GET
+              allowedPermissions:
+                - "catalog:read"`)
+		viper.SetConfigType("yaml")
+		assert.Nil(viper.ReadConfig(bytes.NewBuffer(config)))
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		if testCase.valid {
+			assert.Nil(cfg.Validate(), testCase.wildcards)
+		} else {
+			assert.NotNil(cfg.Validate(), testCase.wildcards)
+		}
+	}
 }
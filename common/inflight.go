@@ -0,0 +1,185 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InFlightTracker tracks the number of requests currently being processed
+type InFlightTracker interface {
+	/*
+		RequestStarted record that a request has started processing
+
+		 @param server string - the server processing the request
+	*/
+	RequestStarted(server string)
+
+	/*
+		RequestCompleted record that a request has finished processing
+
+		 @param server string - the server processing the request
+	*/
+	RequestCompleted(server string)
+
+	/*
+		InFlight get the number of requests currently being processed
+
+		 @return the number of in-flight requests
+	*/
+	InFlight() int
+
+	/*
+		WaitForDrain wait until there are no in-flight requests. The drain progress is exported
+		while waiting.
+
+		 @param ctxt context.Context - the operating context. The wait stops once it is cancelled.
+		 @return nil if all in-flight requests completed, or an error otherwise
+	*/
+	WaitForDrain(ctxt context.Context) error
+}
+
+// InFlightMetrics are the metrics to export the in-flight request tracking on
+type InFlightMetrics struct {
+	// InFlight the number of in-flight requests per server. It must support the label "server".
+	InFlight *prometheus.GaugeVec
+	// Draining whether the shutdown drain is in progress. It must support no labels.
+	Draining *prometheus.GaugeVec
+	// DrainProgress the fraction of the requests in-flight at the start of the shutdown drain
+	// which have since completed. It must support no labels.
+	DrainProgress *prometheus.GaugeVec
+}
+
+// inFlightTrackerImpl implements InFlightTracker
+type inFlightTrackerImpl struct {
+	lock      sync.Mutex
+	inFlight  int
+	perServer map[string]int
+	// idle is closed whenever there are no in-flight requests
+	idle chan struct{}
+	// drainStart is the number of in-flight requests when the drain started, if draining
+	drainStart *int
+	metrics    *InFlightMetrics
+}
+
+/*
+DefineInFlightTracker defines a new InFlightTracker
+
+	@param metrics *InFlightMetrics - if provided, the metrics to export the tracking on
+	@return new InFlightTracker instance
+*/
+func DefineInFlightTracker(metrics *InFlightMetrics) InFlightTracker {
+	idle := make(chan struct{})
+	close(idle)
+	return &inFlightTrackerImpl{
+		lock: sync.Mutex{}, inFlight: 0, perServer: map[string]int{}, idle: idle, metrics: metrics,
+	}
+}
+
+// exportServer export the number of in-flight requests of a server
+func (t *inFlightTrackerImpl) exportServer(server string) {
+	if t.metrics == nil {
+		return
+	}
+	t.metrics.InFlight.
+		With(prometheus.Labels{"server": server}).
+		Set(float64(t.perServer[server]))
+}
+
+// exportDrain export the drain progress
+func (t *inFlightTrackerImpl) exportDrain() {
+	if t.metrics == nil {
+		return
+	}
+	if t.drainStart == nil {
+		t.metrics.Draining.WithLabelValues().Set(0)
+		return
+	}
+	t.metrics.Draining.WithLabelValues().Set(1)
+	progress := 1.0
+	if *t.drainStart > 0 && t.inFlight > 0 {
+		progress = float64(*t.drainStart-t.inFlight) / float64(*t.drainStart)
+		if progress < 0 {
+			progress = 0
+		}
+	}
+	t.metrics.DrainProgress.WithLabelValues().Set(progress)
+}
+
+/*
+RequestStarted record that a request has started processing
+
+	@param server string - the server processing the request
+*/
+func (t *inFlightTrackerImpl) RequestStarted(server string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.inFlight == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.inFlight++
+	t.perServer[server]++
+	t.exportServer(server)
+}
+
+/*
+RequestCompleted record that a request has finished processing
+
+	@param server string - the server processing the request
+*/
+func (t *inFlightTrackerImpl) RequestCompleted(server string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.perServer[server] == 0 {
+		return
+	}
+	t.inFlight--
+	t.perServer[server]--
+	t.exportServer(server)
+	if t.drainStart != nil {
+		t.exportDrain()
+	}
+	if t.inFlight == 0 {
+		close(t.idle)
+	}
+}
+
+/*
+InFlight get the number of requests currently being processed
+
+	@return the number of in-flight requests
+*/
+func (t *inFlightTrackerImpl) InFlight() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.inFlight
+}
+
+/*
+WaitForDrain wait until there are no in-flight requests. The drain progress is exported
+while waiting.
+
+	@param ctxt context.Context - the operating context. The wait stops once it is cancelled.
+	@return nil if all in-flight requests completed, or an error otherwise
+*/
+func (t *inFlightTrackerImpl) WaitForDrain(ctxt context.Context) error {
+	t.lock.Lock()
+	idle := t.idle
+	drainStart := t.inFlight
+	t.drainStart = &drainStart
+	t.exportDrain()
+	t.lock.Unlock()
+	defer func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		t.drainStart = nil
+		t.exportDrain()
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctxt.Done():
+		return ctxt.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInFlightTracker(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics := InFlightMetrics{
+		InFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: "http_in_flight_requests", Help: "unit-test"},
+			[]string{"server"},
+		),
+		Draining: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: "shutdown_draining", Help: "unit-test"}, []string{},
+		),
+		DrainProgress: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: "shutdown_drain_progress_ratio", Help: "unit-test"},
+			[]string{},
+		),
+	}
+	uut := DefineInFlightTracker(&metrics)
+
+	// Case 0: nothing in-flight
+	assert.Equal(0, uut.InFlight())
+	assert.Nil(uut.WaitForDrain(context.Background()))
+
+	// Case 1: drain times out with requests in-flight
+	uut.RequestStarted("authorization")
+	uut.RequestStarted("authorization")
+	uut.RequestStarted("authentication")
+	uut.RequestStarted("authentication")
+	assert.Equal(4, uut.InFlight())
+	assert.Equal(2.0, testutil.ToFloat64(metrics.InFlight.WithLabelValues("authorization")))
+	{
+		ctxt, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		assert.NotNil(uut.WaitForDrain(ctxt))
+	}
+	assert.Equal(0.0, testutil.ToFloat64(metrics.Draining.WithLabelValues()))
+
+	// Case 2: drain completes once requests finish
+	uut.RequestCompleted("authorization")
+	assert.Equal(3, uut.InFlight())
+	assert.Equal(1.0, testutil.ToFloat64(metrics.InFlight.WithLabelValues("authorization")))
+	drainDone := make(chan error, 1)
+	go func() {
+		ctxt, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		drainDone <- uut.WaitForDrain(ctxt)
+	}()
+	assert.Eventually(func() bool {
+		return testutil.ToFloat64(metrics.Draining.WithLabelValues()) == 1.0
+	}, time.Second, time.Millisecond*10)
+	uut.RequestCompleted("authorization")
+	assert.InDelta(1.0/3.0, testutil.ToFloat64(metrics.DrainProgress.WithLabelValues()), 0.001)
+	uut.RequestCompleted("authentication")
+	uut.RequestCompleted("authentication")
+	assert.Nil(<-drainDone)
+	assert.Equal(0, uut.InFlight())
+	assert.Equal(1.0, testutil.ToFloat64(metrics.DrainProgress.WithLabelValues()))
+	assert.Equal(0.0, testutil.ToFloat64(metrics.Draining.WithLabelValues()))
+
+	// Case 3: extra completions are ignored
+	uut.RequestCompleted("authorization")
+	assert.Equal(0, uut.InFlight())
+	assert.Equal(0.0, testutil.ToFloat64(metrics.InFlight.WithLabelValues("authorization")))
+}
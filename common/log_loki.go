@@ -0,0 +1,226 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// lokiPushPath is the Loki push API path
+const lokiPushPath = "/loki/api/v1/push"
+
+// lokiStream is one Loki log stream in a push request
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiPushRequest is the Loki push API request
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+// lokiEntry is one buffered log entry
+type lokiEntry struct {
+	labels    map[string]string
+	timestamp time.Time
+	line      string
+}
+
+// lokiLogSinkImpl implements LogSink
+type lokiLogSinkImpl struct {
+	*levelFilterHandler
+	config  LogLokiConfig
+	client  *http.Client
+	lock    sync.Mutex
+	pending []lokiEntry
+	flush   chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+/*
+DefineLokiLogSink define a new LogSink shipping the logs to Loki through its push API. The log
+entries are buffered, and pushed in batches in the background.
+
+	@param config LogLokiConfig - the Loki sink config
+	@return new LogSink instance
+*/
+func DefineLokiLogSink(config LogLokiConfig) (LogSink, error) {
+	instance := &lokiLogSinkImpl{
+		config:  config,
+		client:  &http.Client{Timeout: time.Second * time.Duration(config.TimeoutSecs)},
+		lock:    sync.Mutex{},
+		pending: []lokiEntry{},
+		flush:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		wg:      sync.WaitGroup{},
+	}
+	var err error
+	instance.levelFilterHandler, err = defineLevelFilterHandler(
+		log.HandlerFunc(instance.buffer), config.Level, config.ModuleLevels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	instance.wg.Add(1)
+	go instance.pushLoop()
+	return instance, nil
+}
+
+/*
+buffer record one log entry to push
+
+	@param e *log.Entry - the log entry
+*/
+func (s *lokiLogSinkImpl) buffer(e *log.Entry) error {
+	labels := map[string]string{}
+	for name, value := range s.config.Labels {
+		labels[name] = value
+	}
+	labels["level"] = e.Level.String()
+	if module, ok := e.Fields["module"].(string); ok {
+		labels["module"] = module
+	}
+	line := map[string]interface{}{}
+	for name, value := range e.Fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		line[name] = value
+	}
+	line["msg"] = e.Message
+	serialized, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.pending = append(s.pending, lokiEntry{
+		labels: labels, timestamp: e.Timestamp, line: string(serialized),
+	})
+	full := len(s.pending) >= s.config.BatchSize
+	s.lock.Unlock()
+	if full {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// pushLoop push the buffered log entries periodically, or once a batch is full
+func (s *lokiLogSinkImpl) pushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Second * time.Duration(s.config.FlushIntervalSecs))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.reportPushError(s.push())
+			return
+		case <-ticker.C:
+		case <-s.flush:
+		}
+		s.reportPushError(s.push())
+	}
+}
+
+/*
+reportPushError report a push failure. The failure is written directly to stderr, as logging it
+would feed it back into this sink.
+
+	@param err error - the push failure
+*/
+func (s *lokiLogSinkImpl) reportPushError(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to push logs to Loki %s: %s\n", s.config.URL, err.Error())
+	}
+}
+
+// push send the buffered log entries to Loki
+func (s *lokiLogSinkImpl) push() error {
+	s.lock.Lock()
+	entries := s.pending
+	s.pending = []lokiEntry{}
+	s.lock.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// Group the entries into streams by their labels
+	streams := map[string]*lokiStream{}
+	streamOrder := []string{}
+	for _, entry := range entries {
+		labelPairs := []string{}
+		for name, value := range entry.labels {
+			labelPairs = append(labelPairs, fmt.Sprintf("%s=%q", name, value))
+		}
+		sort.Strings(labelPairs)
+		key := strings.Join(labelPairs, ",")
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: entry.labels, Values: [][2]string{}}
+			streams[key] = stream
+			streamOrder = append(streamOrder, key)
+		}
+		stream.Values = append(stream.Values, [2]string{
+			fmt.Sprintf("%d", entry.timestamp.UnixNano()), entry.line,
+		})
+	}
+	request := lokiPushRequest{Streams: []lokiStream{}}
+	for _, key := range streamOrder {
+		request.Streams = append(request.Streams, *streams[key])
+	}
+
+	payload, err := json.Marshal(&request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(
+		http.MethodPost, strings.TrimSuffix(s.config.URL, "/")+lokiPushPath, bytes.NewReader(payload),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.config.TenantID)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("push responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+/*
+Level get the lowest log level the sink accepts, across all modules
+
+	@return the lowest accepted log level
+*/
+func (s *lokiLogSinkImpl) Level() log.Level {
+	return s.lowestLevel()
+}
+
+/*
+Close push the remaining buffered log entries, and stop the background push
+*/
+func (s *lokiLogSinkImpl) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// syslogFacilities maps the supported facility names to their RFC5424 codes
+var syslogFacilities = map[string]int{
+	"user":   1,
+	"daemon": 3,
+	"auth":   4,
+	"local0": 16,
+	"local1": 17,
+	"local2": 18,
+	"local3": 19,
+	"local4": 20,
+	"local5": 21,
+	"local6": 22,
+	"local7": 23,
+}
+
+// syslogSeverities maps the log levels to their RFC5424 severities
+var syslogSeverities = map[log.Level]int{
+	log.DebugLevel: 7,
+	log.InfoLevel:  6,
+	log.WarnLevel:  4,
+	log.ErrorLevel: 3,
+	log.FatalLevel: 2,
+}
+
+// syslogStructuredDataID is the SD-ID the log fields are sent under
+const syslogStructuredDataID = "fields@32473"
+
+// syslogParamNameSanitizer matches the characters not allowed in a structured data parameter
+// name
+var syslogParamNameSanitizer = regexp.MustCompile(`[^!#-<>-\\^-~]`)
+
+// syslogParamEscaper escapes the characters not allowed in a structured data parameter value
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogLogSinkImpl implements LogSink
+type syslogLogSinkImpl struct {
+	*levelFilterHandler
+	config   LogSyslogConfig
+	facility int
+	hostname string
+	procID   string
+	lock     sync.Mutex
+	conn     net.Conn
+}
+
+/*
+DefineSyslogLogSink define a new LogSink shipping the logs to a syslog server in the RFC5424
+format. Over TCP, the messages are framed with octet counting (RFC6587).
+
+	@param config LogSyslogConfig - the syslog sink config
+	@return new LogSink instance
+*/
+func DefineSyslogLogSink(config LogSyslogConfig) (LogSink, error) {
+	facility, ok := syslogFacilities[config.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unsupported syslog facility '%s'", config.Facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(config.Network, config.Address)
+	if err != nil {
+		return nil, err
+	}
+	instance := &syslogLogSinkImpl{
+		config:   config,
+		facility: facility,
+		hostname: hostname,
+		procID:   fmt.Sprintf("%d", os.Getpid()),
+		lock:     sync.Mutex{},
+		conn:     conn,
+	}
+	instance.levelFilterHandler, err = defineLevelFilterHandler(
+		log.HandlerFunc(instance.send), config.Level, config.ModuleLevels,
+	)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return instance, nil
+}
+
+/*
+formatMessage format one log entry as a RFC5424 message
+
+	@param e *log.Entry - the log entry
+	@return the message
+*/
+func (s *syslogLogSinkImpl) formatMessage(e *log.Entry) string {
+	severity, ok := syslogSeverities[e.Level]
+	if !ok {
+		severity = syslogSeverities[log.InfoLevel]
+	}
+
+	structuredData := "-"
+	if len(e.Fields) > 0 {
+		names := e.Fields.Names()
+		sort.Strings(names)
+		params := []string{syslogStructuredDataID}
+		for _, name := range names {
+			paramName := syslogParamNameSanitizer.ReplaceAllString(name, "_")
+			if len(paramName) > 32 {
+				paramName = paramName[:32]
+			}
+			params = append(params, fmt.Sprintf(
+				`%s="%s"`,
+				paramName,
+				syslogParamEscaper.Replace(fmt.Sprintf("%v", e.Fields.Get(name))),
+			))
+		}
+		structuredData = fmt.Sprintf("[%s]", strings.Join(params, " "))
+	}
+
+	return fmt.Sprintf(
+		"<%d>1 %s %s %s %s - %s %s",
+		s.facility*8+severity,
+		e.Timestamp.UTC().Format(time.RFC3339Nano),
+		s.hostname,
+		s.config.AppName,
+		s.procID,
+		structuredData,
+		e.Message,
+	)
+}
+
+/*
+send ship one log entry to the syslog server
+
+	@param e *log.Entry - the log entry
+*/
+func (s *syslogLogSinkImpl) send(e *log.Entry) error {
+	msg := s.formatMessage(e)
+	if s.config.Network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		// The stream connection may have been dropped by the server, so reconnect once
+		if s.config.Network == "udp" {
+			return err
+		}
+		conn, dialErr := net.Dial(s.config.Network, s.config.Address)
+		if dialErr != nil {
+			return err
+		}
+		_ = s.conn.Close()
+		s.conn = conn
+		_, err = s.conn.Write([]byte(msg))
+		return err
+	}
+	return nil
+}
+
+/*
+Level get the lowest log level the sink accepts, across all modules
+
+	@return the lowest accepted log level
+*/
+func (s *syslogLogSinkImpl) Level() log.Level {
+	return s.lowestLevel()
+}
+
+/*
+Close release the resources held by the sink
+*/
+func (s *syslogLogSinkImpl) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn.Close()
+}
@@ -0,0 +1,151 @@
+package common
+
+import (
+	"github.com/apex/log"
+	apexJSON "github.com/apex/log/handlers/json"
+	"github.com/apex/log/handlers/logfmt"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// LogSink is a log destination used in addition to stderr
+type LogSink interface {
+	log.Handler
+
+	/*
+		Level get the lowest log level the sink accepts, across all modules
+
+		 @return the lowest accepted log level
+	*/
+	Level() log.Level
+
+	/*
+		Close release the resources held by the sink
+	*/
+	Close() error
+}
+
+// RotatingLogSink is a LogSink writing to a file which can be rotated
+type RotatingLogSink interface {
+	LogSink
+
+	/*
+		Rotate close the current log file, and start a new one
+	*/
+	Rotate() error
+}
+
+// levelFilterHandler drops log entries below the configured level
+type levelFilterHandler struct {
+	handler      log.Handler
+	level        log.Level
+	moduleLevels map[string]log.Level
+}
+
+/*
+defineLevelFilterHandler define a handler which drops log entries below the configured level
+
+	@param handler log.Handler - the handler to forward the accepted log entries to
+	@param level string - the log level
+	@param moduleLevels map[string]string - log level overrides, keyed by the "module" log field
+	@return new level filter handler
+*/
+func defineLevelFilterHandler(
+	handler log.Handler, level string, moduleLevels map[string]string,
+) (*levelFilterHandler, error) {
+	parsedLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	parsedModuleLevels := map[string]log.Level{}
+	for module, moduleLevel := range moduleLevels {
+		parsed, err := log.ParseLevel(moduleLevel)
+		if err != nil {
+			return nil, err
+		}
+		parsedModuleLevels[module] = parsed
+	}
+	return &levelFilterHandler{
+		handler: handler, level: parsedLevel, moduleLevels: parsedModuleLevels,
+	}, nil
+}
+
+// HandleLog implements log.Handler
+func (h *levelFilterHandler) HandleLog(e *log.Entry) error {
+	level := h.level
+	if module, ok := e.Fields["module"].(string); ok {
+		if moduleLevel, ok := h.moduleLevels[module]; ok {
+			level = moduleLevel
+		}
+	}
+	if e.Level < level {
+		return nil
+	}
+	return h.handler.HandleLog(e)
+}
+
+// lowestLevel get the lowest log level the filter accepts, across all modules
+func (h *levelFilterHandler) lowestLevel() log.Level {
+	lowest := h.level
+	for _, moduleLevel := range h.moduleLevels {
+		if moduleLevel < lowest {
+			lowest = moduleLevel
+		}
+	}
+	return lowest
+}
+
+// rotatingFileLogSinkImpl implements RotatingLogSink
+type rotatingFileLogSinkImpl struct {
+	*levelFilterHandler
+	file *lumberjack.Logger
+}
+
+/*
+DefineRotatingFileLogSink define a new RotatingLogSink writing to a log file
+
+	@param config LogFileConfig - the log file config
+	@return new RotatingLogSink instance
+*/
+func DefineRotatingFileLogSink(config LogFileConfig) (RotatingLogSink, error) {
+	file := &lumberjack.Logger{
+		Filename:   config.Path,
+		MaxSize:    config.MaxSizeMB,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAgeDays,
+		Compress:   config.Compress,
+	}
+	var formatter log.Handler
+	if config.Format == LogFormatLogfmt {
+		formatter = logfmt.New(file)
+	} else {
+		formatter = apexJSON.New(file)
+	}
+	filter, err := defineLevelFilterHandler(formatter, config.Level, config.ModuleLevels)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingFileLogSinkImpl{levelFilterHandler: filter, file: file}, nil
+}
+
+/*
+Level get the lowest log level the sink accepts, across all modules
+
+	@return the lowest accepted log level
+*/
+func (s *rotatingFileLogSinkImpl) Level() log.Level {
+	return s.lowestLevel()
+}
+
+/*
+Rotate close the current log file, and start a new one
+*/
+func (s *rotatingFileLogSinkImpl) Rotate() error {
+	return s.file.Rotate()
+}
+
+/*
+Close release the resources held by the sink
+*/
+func (s *rotatingFileLogSinkImpl) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFileLogSink(t *testing.T) {
+	assert := assert.New(t)
+
+	logDir := fmt.Sprintf("/tmp/log_test_%s", uuid.NewString())
+	assert.Nil(os.MkdirAll(logDir, 0700))
+	defer os.RemoveAll(logDir)
+	logFile := filepath.Join(logDir, "padlock.log")
+
+	uut, err := DefineRotatingFileLogSink(LogFileConfig{
+		Enabled: true,
+		Path:    logFile,
+		Format:  LogFormatLogfmt,
+		LogSinkLevelConfig: LogSinkLevelConfig{
+			Level: "warn", ModuleLevels: map[string]string{"apis": "debug"},
+		},
+		MaxSizeMB: 1,
+	})
+	assert.Nil(err)
+	assert.Equal(log.DebugLevel, uut.Level())
+
+	logger := &log.Logger{Handler: uut, Level: log.DebugLevel}
+
+	// Case 0: log level per module
+	logger.WithFields(log.Fields{"module": "apis"}).Debug("apis-debug")
+	logger.WithFields(log.Fields{"module": "users"}).Debug("users-debug")
+	logger.WithFields(log.Fields{"module": "users"}).Warn("users-warn")
+	{
+		content, err := os.ReadFile(logFile)
+		assert.Nil(err)
+		assert.Contains(string(content), "apis-debug")
+		assert.NotContains(string(content), "users-debug")
+		assert.Contains(string(content), "users-warn")
+	}
+
+	// Case 1: rotation
+	assert.Nil(uut.Rotate())
+	logger.WithFields(log.Fields{"module": "users"}).Error("after-rotate")
+	{
+		content, err := os.ReadFile(logFile)
+		assert.Nil(err)
+		assert.Equal(1, strings.Count(string(content), "\n"))
+		assert.Contains(string(content), "after-rotate")
+		entries, err := os.ReadDir(logDir)
+		assert.Nil(err)
+		assert.Len(entries, 2)
+	}
+
+	assert.Nil(uut.Close())
+}
+
+func TestSyslogLogSink(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer server.Close()
+
+	uut, err := DefineSyslogLogSink(LogSyslogConfig{
+		Enabled:            true,
+		Network:            "udp",
+		Address:            server.LocalAddr().String(),
+		Facility:           "local0",
+		AppName:            "padlock",
+		LogSinkLevelConfig: LogSinkLevelConfig{Level: "info"},
+	})
+	assert.Nil(err)
+	defer uut.Close()
+
+	logger := &log.Logger{Handler: uut, Level: log.DebugLevel}
+	logger.WithFields(log.Fields{"module": "apis"}).Debug("dropped")
+	logger.WithFields(log.Fields{"module": "apis", "user": `a"b]`}).Warn("decision")
+
+	buf := make([]byte, 4096)
+	assert.Nil(server.SetReadDeadline(time.Now().Add(time.Second * 5)))
+	n, _, err := server.ReadFrom(buf)
+	assert.Nil(err)
+	msg := string(buf[:n])
+	// local0 (16) * 8 + warning (4)
+	assert.True(strings.HasPrefix(msg, "<132>1 "), msg)
+	assert.Contains(msg, " padlock ")
+	assert.Contains(msg, `[fields@32473 module="apis" user="a\"b\]"] decision`)
+}
+
+func TestLokiLogSink(t *testing.T) {
+	assert := assert.New(t)
+
+	pushed := make(chan lokiPushRequest, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/loki/api/v1/push", r.URL.Path)
+		assert.Equal("tenant-1", r.Header.Get("X-Scope-OrgID"))
+		var req lokiPushRequest
+		assert.Nil(json.NewDecoder(r.Body).Decode(&req))
+		pushed <- req
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	uut, err := DefineLokiLogSink(LogLokiConfig{
+		Enabled:            true,
+		URL:                server.URL,
+		TenantID:           "tenant-1",
+		Labels:             map[string]string{"app": "padlock"},
+		BatchSize:          2,
+		FlushIntervalSecs:  60,
+		TimeoutSecs:        5,
+		LogSinkLevelConfig: LogSinkLevelConfig{Level: "info"},
+	})
+	assert.Nil(err)
+
+	logger := &log.Logger{Handler: uut, Level: log.DebugLevel}
+
+	// Case 0: push once the batch is full
+	logger.WithFields(log.Fields{"module": "apis"}).Debug("dropped")
+	logger.WithFields(log.Fields{"module": "apis"}).Info("allowed")
+	logger.WithFields(log.Fields{"module": "apis"}).Info("denied")
+	select {
+	case req := <-pushed:
+		assert.Len(req.Streams, 1)
+		assert.Equal(
+			map[string]string{"app": "padlock", "level": "info", "module": "apis"},
+			req.Streams[0].Stream,
+		)
+		assert.Len(req.Streams[0].Values, 2)
+		assert.Contains(req.Streams[0].Values[0][1], `"msg":"allowed"`)
+	case <-time.After(time.Second * 5):
+		assert.Fail("batch not pushed")
+	}
+
+	// Case 1: remaining entries are pushed on close
+	logger.WithFields(log.Fields{"module": "users"}).Error("failed")
+	assert.Nil(uut.Close())
+	select {
+	case req := <-pushed:
+		assert.Len(req.Streams, 1)
+		assert.Equal("users", req.Streams[0].Stream["module"])
+		assert.Equal("error", req.Streams[0].Stream["level"])
+	default:
+		assert.Fail("remaining entries not pushed")
+	}
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// BatchJobResult is the outcome of one short-lived CLI operation
+type BatchJobResult struct {
+	// Operation is the name of the CLI operation
+	Operation string
+	// Started is when the operation started
+	Started time.Time
+	// Completed is when the operation completed
+	Completed time.Time
+	// Records is the number of records processed by the operation
+	Records int
+	// Success whether the operation completed successfully
+	Success bool
+}
+
+// BatchJobMetricsPusher pushes the metrics of short-lived CLI operations to a Pushgateway
+type BatchJobMetricsPusher interface {
+	/*
+		PushResult push the metrics describing the outcome of a CLI operation
+
+		 @param ctxt context.Context - execution context
+		 @param result BatchJobResult - the operation outcome
+	*/
+	PushResult(ctxt context.Context, result BatchJobResult) error
+}
+
+// batchJobMetricsPusherImpl implements BatchJobMetricsPusher
+type batchJobMetricsPusherImpl struct {
+	config   MetricsPushConfig
+	instance string
+	client   *http.Client
+}
+
+/*
+DefineBatchJobMetricsPusher defines a new BatchJobMetricsPusher
+
+	@param config MetricsPushConfig - Pushgateway config
+	@param instance string - the instance grouping label value to push the metrics under
+	@return new BatchJobMetricsPusher instance
+*/
+func DefineBatchJobMetricsPusher(config MetricsPushConfig, instance string) BatchJobMetricsPusher {
+	return &batchJobMetricsPusherImpl{
+		config:   config,
+		instance: instance,
+		client:   &http.Client{Timeout: time.Second * time.Duration(config.TimeoutSecs)},
+	}
+}
+
+/*
+PushResult push the metrics describing the outcome of a CLI operation
+
+	@param ctxt context.Context - execution context
+	@param result BatchJobResult - the operation outcome
+*/
+func (p *batchJobMetricsPusherImpl) PushResult(ctxt context.Context, result BatchJobResult) error {
+	newGauge := func(name, help string, value float64) prometheus.Gauge {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+		gauge.Set(value)
+		return gauge
+	}
+	success := 0.0
+	if result.Success {
+		success = 1.0
+	}
+
+	pusher := push.New(p.config.GatewayURL, p.config.Job).
+		Client(p.client).
+		Grouping("instance", p.instance).
+		Grouping("operation", result.Operation).
+		Collector(newGauge(
+			"padlock_batch_job_last_completion_timestamp_secs",
+			"Unix time the CLI operation last completed",
+			float64(result.Completed.Unix()),
+		)).
+		Collector(newGauge(
+			"padlock_batch_job_duration_secs",
+			"Duration of the last run of the CLI operation",
+			result.Completed.Sub(result.Started).Seconds(),
+		)).
+		Collector(newGauge(
+			"padlock_batch_job_records",
+			"Number of records processed by the last run of the CLI operation",
+			float64(result.Records),
+		)).
+		Collector(newGauge(
+			"padlock_batch_job_success",
+			"Whether the last run of the CLI operation succeeded",
+			success,
+		))
+	if result.Success {
+		pusher = pusher.Collector(newGauge(
+			"padlock_batch_job_last_success_timestamp_secs",
+			"Unix time the CLI operation last completed successfully",
+			float64(result.Completed.Unix()),
+		))
+	}
+	// Only replace the metrics being pushed, so the last success timestamp survives a failed run
+	return pusher.AddContext(ctxt)
+}
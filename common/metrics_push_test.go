@@ -0,0 +1,80 @@
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchJobMetricsPusher(t *testing.T) {
+	assert := assert.New(t)
+
+	type pushedRequest struct {
+		method string
+		path   string
+		body   string
+	}
+	received := make(chan pushedRequest, 4)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- pushedRequest{method: r.Method, path: r.URL.Path, body: string(body)}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	uut := DefineBatchJobMetricsPusher(
+		MetricsPushConfig{Enabled: true, GatewayURL: gateway.URL, Job: "padlock", TimeoutSecs: 5},
+		"unit-test",
+	)
+	started := time.Now()
+
+	// Case 0: successful operation
+	{
+		assert.Nil(uut.PushResult(context.Background(), BatchJobResult{
+			Operation: "backup",
+			Started:   started,
+			Completed: started.Add(time.Second * 2),
+			Records:   12,
+			Success:   true,
+		}))
+		req := <-received
+		assert.Equal(http.MethodPost, req.method)
+		assert.True(strings.HasPrefix(req.path, "/metrics/job/padlock/"))
+		assert.Contains(req.path, "/instance/unit-test")
+		assert.Contains(req.path, "/operation/backup")
+		assert.Contains(req.body, "padlock_batch_job_records")
+		assert.Contains(req.body, "padlock_batch_job_last_success_timestamp_secs")
+	}
+
+	// Case 1: failed operation does not report a success timestamp
+	{
+		assert.Nil(uut.PushResult(context.Background(), BatchJobResult{
+			Operation: "restore", Started: started, Completed: started, Success: false,
+		}))
+		req := <-received
+		assert.Contains(req.path, "/operation/restore")
+		assert.Contains(req.body, "padlock_batch_job_success")
+		assert.NotContains(req.body, "padlock_batch_job_last_success_timestamp_secs")
+	}
+
+	// Case 2: gateway rejects the push
+	{
+		rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer rejecting.Close()
+		uut := DefineBatchJobMetricsPusher(
+			MetricsPushConfig{Enabled: true, GatewayURL: rejecting.URL, Job: "padlock", TimeoutSecs: 5},
+			"unit-test",
+		)
+		assert.NotNil(uut.PushResult(context.Background(), BatchJobResult{
+			Operation: "backup", Started: started, Completed: started, Success: true,
+		}))
+	}
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Supported request proxy presets
+const (
+	// ProxyPresetTraefik Traefik "forwardAuth" middleware
+	ProxyPresetTraefik = "traefik"
+	// ProxyPresetNginx nginx "auth_request" module
+	ProxyPresetNginx = "nginx"
+	// ProxyPresetCaddy Caddy "forward_auth" directive
+	ProxyPresetCaddy = "caddy"
+	// ProxyPresetOAuth2Proxy nginx "auth_request" module, with authentication performed by
+	// oauth2-proxy
+	ProxyPresetOAuth2Proxy = "oauth2-proxy"
+)
+
+// forwardedRequestHeaders headers carrying the request parameters, as set by proxies which
+// follow the "X-Forwarded-*" convention
+var forwardedRequestHeaders = map[string]interface{}{
+	"host":   "X-Forwarded-Host",
+	"path":   "X-Forwarded-Uri",
+	"method": "X-Forwarded-Method",
+}
+
+// originalRequestHeaders headers carrying the request parameters, as commonly set in nginx
+// "auth_request" locations
+var originalRequestHeaders = map[string]interface{}{
+	"host":   "X-Forwarded-Host",
+	"path":   "X-Original-URI",
+	"method": "X-Original-Method",
+}
+
+// proxyPresets the config defaults of each request proxy preset, keyed by config path
+var proxyPresets = map[string]map[string]interface{}{
+	ProxyPresetTraefik: presetValues(forwardedRequestHeaders, AuthorizeResponseModeStandard, nil),
+	ProxyPresetCaddy:   presetValues(forwardedRequestHeaders, AuthorizeResponseModeStandard, nil),
+	// nginx only checks the status of the "auth_request" subrequest
+	ProxyPresetNginx: presetValues(originalRequestHeaders, AuthorizeResponseModeMinimal, nil),
+	ProxyPresetOAuth2Proxy: presetValues(
+		originalRequestHeaders,
+		AuthorizeResponseModeMinimal,
+		// The user parameters are the oauth2-proxy "X-Auth-Request-*" response headers
+		map[string]interface{}{
+			"userID":   "X-Auth-Request-User",
+			"username": "X-Auth-Request-Preferred-Username",
+			"email":    "X-Auth-Request-Email",
+		},
+	),
+}
+
+/*
+presetValues build the config defaults of a request proxy preset
+
+	@param requestHeaders map[string]interface{} - headers carrying the request parameters
+	@param responseMode string - the authorization response mode
+	@param userHeaders map[string]interface{} - headers carrying the user parameters, if they
+	differ from the defaults
+	@return the config defaults, keyed by config path
+*/
+func presetValues(
+	requestHeaders map[string]interface{},
+	responseMode string,
+	userHeaders map[string]interface{},
+) map[string]interface{} {
+	values := map[string]interface{}{"authorize.response.mode": responseMode}
+	for param, header := range requestHeaders {
+		values["authorize.requestParamHeaders."+param] = header
+		values["authenticate.requestParamHeaders."+param] = header
+	}
+	for param, header := range userHeaders {
+		values["authorize.requestParamHeaders."+param] = header
+	}
+	return values
+}
+
+/*
+InstallProxyPresetConfigValues installs the config defaults of the request proxy preset
+selected by "proxyPreset" in viper. This must be called after the config is read, and as the
+preset only changes defaults, values set explicitly in the config take precedence.
+
+	@return nil if no preset is selected or the preset is installed, or an error otherwise
+*/
+func InstallProxyPresetConfigValues() error {
+	presetName := viper.GetString("proxyPreset")
+	if presetName == "" {
+		return nil
+	}
+	preset, ok := proxyPresets[presetName]
+	if !ok {
+		return fmt.Errorf("unknown proxy preset '%s'", presetName)
+	}
+	for key, value := range preset {
+		viper.SetDefault(key, value)
+	}
+	return nil
+}
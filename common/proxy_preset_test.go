@@ -0,0 +1,72 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyPreset(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// Restore the standard defaults for the other tests
+	defer InstallDefaultAuthorizationServerConfigValues()
+
+	readConfig := func(config string) (AuthorizationServerConfig, error) {
+		InstallDefaultAuthorizationServerConfigValues()
+		viper.SetConfigType("yaml")
+		assert.Nil(viper.ReadConfig(bytes.NewBufferString(config)))
+		if err := InstallProxyPresetConfigValues(); err != nil {
+			return AuthorizationServerConfig{}, err
+		}
+		var cfg AuthorizationServerConfig
+		assert.Nil(viper.Unmarshal(&cfg))
+		return cfg, nil
+	}
+
+	// Case 0: no preset
+	{
+		cfg, err := readConfig("---\nauthorize:\n  enabled: true")
+		assert.Nil(err)
+		assert.Equal("X-Forwarded-Uri", cfg.Authorization.RequestParamLocation.Path)
+		assert.Equal(AuthorizeResponseModeStandard, cfg.Authorization.Response.Mode)
+	}
+
+	// Case 1: nginx preset
+	{
+		cfg, err := readConfig("---\nproxyPreset: nginx")
+		assert.Nil(err)
+		assert.Equal("X-Original-URI", cfg.Authorization.RequestParamLocation.Path)
+		assert.Equal("X-Original-Method", cfg.Authorization.RequestParamLocation.Method)
+		assert.Equal("X-Original-URI", cfg.Authentication.RequestParamLocation.Path)
+		assert.Equal("X-Caller-UserID", cfg.Authorization.RequestParamLocation.UserID)
+		assert.Equal(AuthorizeResponseModeMinimal, cfg.Authorization.Response.Mode)
+	}
+
+	// Case 2: explicit values override the preset
+	{
+		cfg, err := readConfig(`---
+proxyPreset: oauth2-proxy
+authorize:
+  requestParamHeaders:
+    path: X-Request-Path
+  response:
+    mode: standard`)
+		assert.Nil(err)
+		assert.Equal("X-Request-Path", cfg.Authorization.RequestParamLocation.Path)
+		assert.Equal("X-Original-Method", cfg.Authorization.RequestParamLocation.Method)
+		assert.Equal("X-Auth-Request-User", cfg.Authorization.RequestParamLocation.UserID)
+		assert.Equal("X-Auth-Request-Email", cfg.Authorization.RequestParamLocation.Email)
+		assert.Equal(AuthorizeResponseModeStandard, cfg.Authorization.Response.Mode)
+	}
+
+	// Case 3: unknown preset
+	{
+		_, err := readConfig("---\nproxyPreset: haproxy")
+		assert.NotNil(err)
+	}
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// SecretFile is a secret read from a file, which is re-read whenever the file changes. This
+// supports secrets mounted from Kubernetes, which are rotated by swapping the mounted files.
+type SecretFile interface {
+	/*
+		Value get the current value of the secret
+
+		 @return the secret
+	*/
+	Value() string
+
+	/*
+		Stop stop watching the file for changes
+
+		 @return whether successful
+	*/
+	Stop() error
+}
+
+// secretFileImpl implements SecretFile
+type secretFileImpl struct {
+	path    string
+	logTags log.Fields
+	lock    sync.RWMutex
+	value   string
+	watcher *fsnotify.Watcher
+	wg      sync.WaitGroup
+}
+
+/*
+WatchSecretFile read a secret from a file, and watch the file for changes. Trailing newlines
+are removed from the secret.
+
+	@param path string - the secret file
+	@return new SecretFile instance
+*/
+func WatchSecretFile(path string) (SecretFile, error) {
+	logTags := log.Fields{"module": "common", "component": "secret-file", "file": path}
+
+	value, err := readSecretFile(path)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Unable to read secret file")
+		return nil, err
+	}
+
+	// Watch the parent directory, as a mounted secret is updated by replacing a symlink
+	// instead of writing to the file
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Unable to define file watcher")
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		log.WithError(err).WithFields(logTags).Error("Unable to watch secret file directory")
+		return nil, err
+	}
+
+	instance := &secretFileImpl{
+		path:    path,
+		logTags: logTags,
+		lock:    sync.RWMutex{},
+		value:   value,
+		watcher: watcher,
+		wg:      sync.WaitGroup{},
+	}
+	instance.wg.Add(1)
+	go instance.watch()
+	return instance, nil
+}
+
+// readSecretFile read a secret from a file, removing trailing newlines
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// watch re-read the secret file whenever its directory changes, until the watcher is closed
+func (s *secretFileImpl) watch() {
+	defer s.wg.Done()
+	for {
+		select {
+		case _, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			s.reload()
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.WithError(err).WithFields(s.logTags).Error("Secret file watch error")
+		}
+	}
+}
+
+// reload re-read the secret file. The previous secret is kept if the file can not be read,
+// as it may be in the middle of being replaced.
+func (s *secretFileImpl) reload() {
+	value, err := readSecretFile(s.path)
+	if err != nil {
+		log.WithError(err).WithFields(s.logTags).Warn("Unable to re-read secret file")
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if value != s.value {
+		s.value = value
+		log.WithFields(s.logTags).Info("Secret file changed")
+	}
+}
+
+/*
+Value get the current value of the secret
+
+	@return the secret
+*/
+func (s *secretFileImpl) Value() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.value
+}
+
+/*
+Stop stop watching the file for changes
+
+	@return whether successful
+*/
+func (s *secretFileImpl) Stop() error {
+	err := s.watcher.Close()
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretFile(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	secretDir := t.TempDir()
+	secretPath := filepath.Join(secretDir, "password")
+	assert.Nil(os.WriteFile(secretPath, []byte("secret-1\n"), 0600))
+
+	uut, err := WatchSecretFile(secretPath)
+	assert.Nil(err)
+	defer func() {
+		assert.Nil(uut.Stop())
+	}()
+
+	// Case 0: initial value
+	assert.Equal("secret-1", uut.Value())
+
+	// Case 1: file rewritten in place
+	assert.Nil(os.WriteFile(secretPath, []byte("secret-2\n"), 0600))
+	assert.Eventually(func() bool {
+		return uut.Value() == "secret-2"
+	}, time.Second*5, time.Millisecond*20)
+
+	// Case 2: file replaced, as is done for a mounted secret
+	{
+		stagedPath := filepath.Join(secretDir, "password.staged")
+		assert.Nil(os.WriteFile(stagedPath, []byte("secret-3"), 0600))
+		assert.Nil(os.Rename(stagedPath, secretPath))
+		assert.Eventually(func() bool {
+			return uut.Value() == "secret-3"
+		}, time.Second*5, time.Millisecond*20)
+	}
+
+	// Case 3: file missing
+	{
+		_, err := WatchSecretFile(filepath.Join(secretDir, "unknown"))
+		assert.NotNil(err)
+	}
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// ReadinessGate tracks whether the application startup tasks have completed
+type ReadinessGate interface {
+	/*
+		Ready checks whether the startup tasks have completed
+
+		 @return nil if ready, or an error otherwise
+	*/
+	Ready() error
+
+	/*
+		MarkReady record that the startup tasks have completed
+	*/
+	MarkReady()
+
+	/*
+		MarkDraining record that the application is shutting down, and should no longer be
+		considered ready
+	*/
+	MarkDraining()
+}
+
+// readinessGateImpl implements ReadinessGate
+type readinessGateImpl struct {
+	lock     sync.RWMutex
+	ready    bool
+	draining bool
+}
+
+/*
+DefineReadinessGate defines a new ReadinessGate which starts as not ready
+
+	@return new ReadinessGate instance
+*/
+func DefineReadinessGate() ReadinessGate {
+	return &readinessGateImpl{lock: sync.RWMutex{}, ready: false, draining: false}
+}
+
+/*
+Ready checks whether the startup tasks have completed
+
+	@return nil if ready, or an error otherwise
+*/
+func (g *readinessGateImpl) Ready() error {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	if g.draining {
+		return fmt.Errorf("shutting down")
+	}
+	if !g.ready {
+		return fmt.Errorf("startup not yet complete")
+	}
+	return nil
+}
+
+/*
+MarkReady record that the startup tasks have completed
+*/
+func (g *readinessGateImpl) MarkReady() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.ready = true
+}
+
+/*
+MarkDraining record that the application is shutting down, and should no longer be
+considered ready
+*/
+func (g *readinessGateImpl) MarkDraining() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.draining = true
+}
+
+/*
+RetryWithBackoff run a task until it succeeds, waiting with exponential backoff between attempts
+
+	@param ctxt context.Context - the operating context. Retries stop once it is cancelled.
+	@param cfg RetryConfig - the retry policy
+	@param logTags log.Fields - metadata fields to include in the logs
+	@param taskName string - name of the task for logging
+	@param task func() error - the task
+	@return nil if the task eventually succeeded, or the last error otherwise
+*/
+func RetryWithBackoff(
+	ctxt context.Context,
+	cfg RetryConfig,
+	logTags log.Fields,
+	taskName string,
+	task func() error,
+) error {
+	wait := time.Second * time.Duration(cfg.InitialInterval)
+	maxWait := time.Second * time.Duration(cfg.MaxInterval)
+	for attempt := 1; ; attempt++ {
+		err := task()
+		if err == nil {
+			return nil
+		}
+		if cfg.MaxAttempts > 0 && attempt >= cfg.MaxAttempts {
+			log.WithError(err).WithFields(logTags).
+				Errorf("'%s' failed after %d attempts", taskName, attempt)
+			return err
+		}
+		log.WithError(err).WithFields(logTags).
+			Warnf("'%s' attempt %d failed, retrying in %s", taskName, attempt, wait)
+		select {
+		case <-ctxt.Done():
+			return ctxt.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > maxWait {
+			wait = maxWait
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessGate(t *testing.T) {
+	assert := assert.New(t)
+
+	uut := DefineReadinessGate()
+	assert.NotNil(uut.Ready())
+	uut.MarkReady()
+	assert.Nil(uut.Ready())
+	uut.MarkDraining()
+	assert.NotNil(uut.Ready())
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+	logTags := log.Fields{"module": "common", "component": "retry-test"}
+
+	cfg := RetryConfig{MaxAttempts: 0, InitialInterval: 1, MaxInterval: 1}
+
+	// Case 0: succeed after a retry
+	{
+		attempts := 0
+		err := RetryWithBackoff(context.Background(), cfg, logTags, "case-0", func() error {
+			attempts++
+			if attempts < 2 {
+				return fmt.Errorf("dummy error")
+			}
+			return nil
+		})
+		assert.Nil(err)
+		assert.Equal(2, attempts)
+	}
+
+	// Case 1: give up after max attempts
+	{
+		attempts := 0
+		limited := RetryConfig{MaxAttempts: 1, InitialInterval: 1, MaxInterval: 1}
+		err := RetryWithBackoff(context.Background(), limited, logTags, "case-1", func() error {
+			attempts++
+			return fmt.Errorf("dummy error")
+		})
+		assert.NotNil(err)
+		assert.Equal(1, attempts)
+	}
+
+	// Case 2: stop once context is cancelled
+	{
+		ctxt, cancel := context.WithCancel(context.Background())
+		cancel()
+		attempts := 0
+		err := RetryWithBackoff(ctxt, cfg, logTags, "case-2", func() error {
+			attempts++
+			return fmt.Errorf("dummy error")
+		})
+		assert.Equal(context.Canceled, err)
+		assert.Equal(1, attempts)
+	}
+}
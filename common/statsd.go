@@ -0,0 +1,289 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdNameSanitizer matches characters not allowed in a plain StatsD metric name segment
+var statsdNameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+
+// StatsDMetricsCollector is a goutils.MetricsCollector which, in addition to collecting the
+// metrics for Prometheus, ships them to a StatsD / DogStatsD server.
+//
+// HTTP request metrics are sent as they are recorded. Custom metrics are sent on each Flush.
+type StatsDMetricsCollector interface {
+	goutils.MetricsCollector
+
+	/*
+		Flush send the current value of the custom metrics to the StatsD server
+
+		 @param ctxt context.Context - execution context
+	*/
+	Flush(ctxt context.Context) error
+
+	/*
+		Close flush the custom metrics one last time, and release the StatsD connection
+	*/
+	Close() error
+}
+
+// statsdMetricsCollectorImpl implements StatsDMetricsCollector
+type statsdMetricsCollectorImpl struct {
+	goutils.Component
+	base   goutils.MetricsCollector
+	config MetricsStatsDConfig
+	conn   net.Conn
+	// mirror holds the custom metrics to ship on each flush
+	mirror       *prometheus.Registry
+	lock         sync.Mutex
+	lastCounters map[string]float64
+}
+
+/*
+DefineStatsDMetricsCollector defines a new StatsDMetricsCollector
+
+	@param base goutils.MetricsCollector - the Prometheus metrics collector being wrapped
+	@param config MetricsStatsDConfig - StatsD sink config
+	@return new StatsDMetricsCollector instance
+*/
+func DefineStatsDMetricsCollector(
+	base goutils.MetricsCollector, config MetricsStatsDConfig,
+) (StatsDMetricsCollector, error) {
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdMetricsCollectorImpl{
+		Component: goutils.Component{
+			LogTags: log.Fields{
+				"module": "common", "component": "statsd-metrics", "instance": config.Address,
+			},
+		},
+		base:         base,
+		config:       config,
+		conn:         conn,
+		mirror:       prometheus.NewRegistry(),
+		lock:         sync.Mutex{},
+		lastCounters: map[string]float64{},
+	}, nil
+}
+
+// InstallApplicationMetrics implements goutils.MetricsCollector. The Golang application
+// metrics are only collected for Prometheus.
+func (c *statsdMetricsCollectorImpl) InstallApplicationMetrics() {
+	c.base.InstallApplicationMetrics()
+}
+
+// InstallHTTPMetrics implements goutils.MetricsCollector
+func (c *statsdMetricsCollectorImpl) InstallHTTPMetrics() goutils.HTTPRequestMetricHelper {
+	return &statsdHTTPRequestMetricHelper{base: c.base.InstallHTTPMetrics(), sink: c}
+}
+
+// InstallPubSubMetrics implements goutils.MetricsCollector. The PubSub metrics are only
+// collected for Prometheus.
+func (c *statsdMetricsCollectorImpl) InstallPubSubMetrics() goutils.PubSubMetricHelper {
+	return c.base.InstallPubSubMetrics()
+}
+
+// InstallCustomCounterVecMetrics implements goutils.MetricsCollector
+func (c *statsdMetricsCollectorImpl) InstallCustomCounterVecMetrics(
+	ctxt context.Context, metricsName string, metricsHelpMessage string, metricsLabels []string,
+) (*prometheus.CounterVec, error) {
+	metric, err := c.base.InstallCustomCounterVecMetrics(
+		ctxt, metricsName, metricsHelpMessage, metricsLabels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.mirror.Register(metric); err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
+
+// InstallCustomGaugeVecMetrics implements goutils.MetricsCollector
+func (c *statsdMetricsCollectorImpl) InstallCustomGaugeVecMetrics(
+	ctxt context.Context, metricsName string, metricsHelpMessage string, metricsLabels []string,
+) (*prometheus.GaugeVec, error) {
+	metric, err := c.base.InstallCustomGaugeVecMetrics(
+		ctxt, metricsName, metricsHelpMessage, metricsLabels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.mirror.Register(metric); err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
+
+// ExposeCollectionEndpoint implements goutils.MetricsCollector
+func (c *statsdMetricsCollectorImpl) ExposeCollectionEndpoint(
+	router *mux.Router, metricsPath string, maxSupportedRequest int,
+) {
+	c.base.ExposeCollectionEndpoint(router, metricsPath, maxSupportedRequest)
+}
+
+/*
+Flush send the current value of the custom metrics to the StatsD server. Counters are sent as
+the increase since the previous flush, and gauges as their current value.
+
+	@param ctxt context.Context - execution context
+*/
+func (c *statsdMetricsCollectorImpl) Flush(ctxt context.Context) error {
+	families, err := c.mirror.Gather()
+	if err != nil {
+		log.WithError(err).WithFields(c.GetLogTagsForContext(ctxt)).Error("Metrics gather failed")
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			key := family.GetName()
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+				key += fmt.Sprintf(",%s=%s", pair.GetName(), pair.GetValue())
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				value := metric.GetCounter().GetValue()
+				delta := value - c.lastCounters[key]
+				c.lastCounters[key] = value
+				if delta <= 0 {
+					continue
+				}
+				err = c.send(family.GetName(), delta, "c", labels)
+			case dto.MetricType_GAUGE:
+				err = c.send(family.GetName(), metric.GetGauge().GetValue(), "g", labels)
+			default:
+				continue
+			}
+			if err != nil {
+				log.WithError(err).WithFields(c.GetLogTagsForContext(ctxt)).
+					Errorf("Failed to send metric '%s'", family.GetName())
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Close flush the custom metrics one last time, and release the StatsD connection
+*/
+func (c *statsdMetricsCollectorImpl) Close() error {
+	flushErr := c.Flush(context.Background())
+	if err := c.conn.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+/*
+send send one metric to the StatsD server
+
+	@param name string - metric name
+	@param value float64 - metric value
+	@param metricType string - StatsD metric type
+	@param labels map[string]string - metric labels
+*/
+func (c *statsdMetricsCollectorImpl) send(
+	name string, value float64, metricType string, labels map[string]string,
+) error {
+	_, err := c.conn.Write([]byte(c.formatLine(name, value, metricType, labels)))
+	return err
+}
+
+/*
+formatLine format one metric in the configured StatsD protocol flavor
+
+	@param name string - metric name
+	@param value float64 - metric value
+	@param metricType string - StatsD metric type
+	@param labels map[string]string - metric labels
+	@return the StatsD line
+*/
+func (c *statsdMetricsCollectorImpl) formatLine(
+	name string, value float64, metricType string, labels map[string]string,
+) string {
+	labelNames := make([]string, 0, len(labels))
+	for labelName := range labels {
+		labelNames = append(labelNames, labelName)
+	}
+	sort.Strings(labelNames)
+
+	fullName := c.config.Prefix + name
+	formattedValue := strconv.FormatFloat(value, 'f', -1, 64)
+
+	if c.config.Flavor != StatsDFlavorDogStatsD {
+		segments := []string{fullName}
+		for _, labelName := range labelNames {
+			segments = append(segments, statsdNameSanitizer.ReplaceAllString(labels[labelName], "_"))
+		}
+		return fmt.Sprintf("%s:%s|%s", strings.Join(segments, "."), formattedValue, metricType)
+	}
+
+	tags := append([]string{}, c.config.Tags...)
+	for _, labelName := range labelNames {
+		tags = append(tags, fmt.Sprintf("%s:%s", labelName, labels[labelName]))
+	}
+	if len(tags) == 0 {
+		return fmt.Sprintf("%s:%s|%s", fullName, formattedValue, metricType)
+	}
+	return fmt.Sprintf(
+		"%s:%s|%s|#%s", fullName, formattedValue, metricType, strings.Join(tags, ","),
+	)
+}
+
+// statsdHTTPRequestMetricHelper records HTTP request metrics for both Prometheus and StatsD
+type statsdHTTPRequestMetricHelper struct {
+	base goutils.HTTPRequestMetricHelper
+	sink *statsdMetricsCollectorImpl
+}
+
+/*
+RecordRequest record parameters regarding a request to the metrics
+
+	@param method string - HTTP request method
+	@param status int - HTTP response status
+	@param latency time.Duration - delay between request received, and response sent
+	@param respSize int64 - HTTP response size in bytes
+*/
+func (h *statsdHTTPRequestMetricHelper) RecordRequest(
+	method string, status int, latency time.Duration, respSize int64,
+) {
+	h.base.RecordRequest(method, status, latency, respSize)
+
+	// Quantize the response status the same way as the Prometheus HTTP metrics
+	statusLabel := "2XX"
+	if status >= http.StatusInternalServerError {
+		statusLabel = "5XX"
+	} else if status >= http.StatusBadRequest {
+		statusLabel = "4XX"
+	} else if status >= http.StatusMultipleChoices {
+		statusLabel = "3XX"
+	}
+	labels := map[string]string{"method": strings.ToUpper(method), "status": statusLabel}
+	// Dropping a metric is preferable to failing the request
+	_ = h.sink.send("http_request_total", 1, "c", labels)
+	_ = h.sink.send("http_request_latency", float64(latency.Milliseconds()), "ms", labels)
+	_ = h.sink.send("http_response_size_bytes_total", float64(respSize), "c", labels)
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsDMetricsCollector(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer server.Close()
+	readLine := func() string {
+		buf := make([]byte, 1024)
+		assert.Nil(server.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := server.ReadFrom(buf)
+		assert.Nil(err)
+		return string(buf[:n])
+	}
+
+	defineCollector := func(flavor string, tags []string) StatsDMetricsCollector {
+		base, err := goutils.GetNewMetricsCollector(log.Fields{}, []goutils.LogMetadataModifier{})
+		assert.Nil(err)
+		uut, err := DefineStatsDMetricsCollector(base, MetricsStatsDConfig{
+			Enabled:           true,
+			Address:           server.LocalAddr().String(),
+			Flavor:            flavor,
+			Prefix:            "padlock.",
+			FlushIntervalSecs: 1,
+			Tags:              tags,
+		})
+		assert.Nil(err)
+		return uut
+	}
+
+	// Case 0: DogStatsD HTTP metrics are sent as recorded
+	{
+		uut := defineCollector(StatsDFlavorDogStatsD, []string{"env:test"})
+		httpMetrics := uut.InstallHTTPMetrics()
+		httpMetrics.RecordRequest("get", http.StatusNotFound, time.Millisecond*15, 128)
+		assert.Equal("padlock.http_request_total:1|c|#env:test,method:GET,status:4XX", readLine())
+		assert.Equal("padlock.http_request_latency:15|ms|#env:test,method:GET,status:4XX", readLine())
+		assert.Equal(
+			"padlock.http_response_size_bytes_total:128|c|#env:test,method:GET,status:4XX", readLine(),
+		)
+		assert.Nil(uut.Close())
+	}
+
+	// Case 1: custom metrics are sent on flush, with counters sent as the increase
+	{
+		uut := defineCollector(StatsDFlavorStatsD, nil)
+		counter, err := uut.InstallCustomCounterVecMetrics(
+			context.Background(), "unit_test_total", "unit-test", []string{"host"},
+		)
+		assert.Nil(err)
+		gauge, err := uut.InstallCustomGaugeVecMetrics(
+			context.Background(), "unit_test_up", "unit-test", []string{"host"},
+		)
+		assert.Nil(err)
+
+		counter.With(prometheus.Labels{"host": "a.example.com"}).Add(3)
+		gauge.With(prometheus.Labels{"host": "a.example.com"}).Set(1)
+		assert.Nil(uut.Flush(context.Background()))
+		assert.Equal("padlock.unit_test_total.a_example_com:3|c", readLine())
+		assert.Equal("padlock.unit_test_up.a_example_com:1|g", readLine())
+
+		counter.With(prometheus.Labels{"host": "a.example.com"}).Add(2)
+		assert.Nil(uut.Flush(context.Background()))
+		assert.Equal("padlock.unit_test_total.a_example_com:2|c", readLine())
+		assert.Equal("padlock.unit_test_up.a_example_com:1|g", readLine())
+
+		// Unchanged counters are not sent
+		assert.Nil(uut.Close())
+		assert.Equal("padlock.unit_test_up.a_example_com:1|g", readLine())
+	}
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FeatureToggleState is the state of the runtime feature toggles
+type FeatureToggleState struct {
+	// Introspection whether the authentication submodule performs token introspection
+	Introspection bool `json:"introspection"`
+	// AutoAddUser whether the authorization submodule automatically records unknown users
+	AutoAddUser bool `json:"auto_add_user"`
+	// DryRun whether the authorization submodule only logs denials, and allows all requests
+	DryRun bool `json:"dry_run"`
+}
+
+// FeatureToggleUpdate is a change to the runtime feature toggles. Toggles not set are left
+// unchanged.
+type FeatureToggleUpdate struct {
+	// Introspection whether the authentication submodule performs token introspection
+	Introspection *bool `json:"introspection,omitempty"`
+	// AutoAddUser whether the authorization submodule automatically records unknown users
+	AutoAddUser *bool `json:"auto_add_user,omitempty"`
+	// DryRun whether the authorization submodule only logs denials, and allows all requests
+	DryRun *bool `json:"dry_run,omitempty"`
+}
+
+// FeatureToggles are behaviors which can be changed at runtime without a restart
+type FeatureToggles interface {
+	/*
+		CurrentState get the current state of the toggles
+
+		 @return the toggle state
+	*/
+	CurrentState() FeatureToggleState
+
+	/*
+		UpdateState change the state of the toggles
+
+		 @param update FeatureToggleUpdate - the toggles to change
+		 @return the toggle state after the update
+	*/
+	UpdateState(update FeatureToggleUpdate) FeatureToggleState
+}
+
+// featureTogglesImpl implements FeatureToggles
+type featureTogglesImpl struct {
+	lock   sync.RWMutex
+	state  FeatureToggleState
+	metric *prometheus.GaugeVec
+}
+
+/*
+DefineFeatureToggles defines a new FeatureToggles
+
+	@param initial FeatureToggleState - the initial toggle state
+	@param metric *prometheus.GaugeVec - if provided, the metric to export the toggle state on.
+	It must support the label "toggle".
+	@return new FeatureToggles instance
+*/
+func DefineFeatureToggles(initial FeatureToggleState, metric *prometheus.GaugeVec) FeatureToggles {
+	instance := &featureTogglesImpl{lock: sync.RWMutex{}, state: initial, metric: metric}
+	instance.exportState()
+	return instance
+}
+
+// exportState export the toggle state as metrics
+func (t *featureTogglesImpl) exportState() {
+	if t.metric == nil {
+		return
+	}
+	toValue := func(enabled bool) float64 {
+		if enabled {
+			return 1.0
+		}
+		return 0.0
+	}
+	t.metric.With(prometheus.Labels{"toggle": "introspection"}).Set(toValue(t.state.Introspection))
+	t.metric.With(prometheus.Labels{"toggle": "auto_add_user"}).Set(toValue(t.state.AutoAddUser))
+	t.metric.With(prometheus.Labels{"toggle": "dry_run"}).Set(toValue(t.state.DryRun))
+}
+
+/*
+CurrentState get the current state of the toggles
+
+	@return the toggle state
+*/
+func (t *featureTogglesImpl) CurrentState() FeatureToggleState {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.state
+}
+
+/*
+UpdateState change the state of the toggles
+
+	@param update FeatureToggleUpdate - the toggles to change
+	@return the toggle state after the update
+*/
+func (t *featureTogglesImpl) UpdateState(update FeatureToggleUpdate) FeatureToggleState {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if update.Introspection != nil {
+		t.state.Introspection = *update.Introspection
+	}
+	if update.AutoAddUser != nil {
+		t.state.AutoAddUser = *update.AutoAddUser
+	}
+	if update.DryRun != nil {
+		t.state.DryRun = *update.DryRun
+	}
+	t.exportState()
+	return t.state
+}
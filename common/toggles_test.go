@@ -0,0 +1,35 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureToggles(t *testing.T) {
+	assert := assert.New(t)
+
+	metric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "feature_toggle", Help: "unit-test"}, []string{"toggle"},
+	)
+	uut := DefineFeatureToggles(FeatureToggleState{Introspection: true}, metric)
+
+	// Case 0: initial state
+	assert.Equal(FeatureToggleState{Introspection: true}, uut.CurrentState())
+	assert.Equal(1.0, testutil.ToFloat64(metric.WithLabelValues("introspection")))
+	assert.Equal(0.0, testutil.ToFloat64(metric.WithLabelValues("dry_run")))
+
+	// Case 1: partial update
+	enable := true
+	disable := false
+	state := uut.UpdateState(FeatureToggleUpdate{Introspection: &disable, DryRun: &enable})
+	assert.Equal(FeatureToggleState{Introspection: false, AutoAddUser: false, DryRun: true}, state)
+	assert.Equal(state, uut.CurrentState())
+	assert.Equal(0.0, testutil.ToFloat64(metric.WithLabelValues("introspection")))
+	assert.Equal(1.0, testutil.ToFloat64(metric.WithLabelValues("dry_run")))
+
+	// Case 2: empty update
+	assert.Equal(state, uut.UpdateState(FeatureToggleUpdate{}))
+}
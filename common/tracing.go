@@ -0,0 +1,67 @@
+package common
+
+import (
+	"context"
+	"time"
+
+	"github.com/apex/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+/*
+InstallTracing install the global OpenTelemetry trace propagator, and, if the trace export is
+enabled, the global tracer provider exporting the traces to an OTLP collector. Until the
+tracer provider is installed, the spans are not recorded, but the trace context of incoming
+requests is still propagated.
+
+	@param ctxt context.Context - execution context
+	@param config TracingConfig - the tracing config
+	@return the function flushing and stopping the trace export
+*/
+func InstallTracing(ctxt context.Context, config TracingConfig) (
+	func(context.Context) error, error,
+) {
+	otel.SetTextMapPropagator(
+		propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	)
+	if !config.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	logTags := log.Fields{"module": "common", "component": "tracing"}
+	opts := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(config.Endpoint),
+		otlptracegrpc.WithTimeout(time.Second * time.Duration(config.TimeoutSecs)),
+	}
+	if config.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	if len(config.Headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(config.Headers))
+	}
+	exporter, err := otlptracegrpc.New(ctxt, opts...)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define OTLP trace exporter")
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(config.ServiceName),
+			semconv.ServiceVersion(Version),
+		)),
+		sdktrace.WithSampler(
+			sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio)),
+		),
+	)
+	otel.SetTracerProvider(provider)
+	log.WithFields(logTags).Infof("Exporting traces to %s", config.Endpoint)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedNetworks is a set of networks whose requests are trusted, i.e. the request proxies
+type TrustedNetworks []*net.IPNet
+
+/*
+ParseTrustedNetworks parse a list of CIDRs into a TrustedNetworks
+
+	@param cidrs []string - the CIDRs of the trusted networks
+	@return the trusted networks
+*/
+func ParseTrustedNetworks(cidrs []string) (TrustedNetworks, error) {
+	networks := TrustedNetworks{}
+	for _, source := range cidrs {
+		_, cidr, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted network '%s': %w", source, err)
+		}
+		networks = append(networks, cidr)
+	}
+	return networks, nil
+}
+
+/*
+Contains check whether an address belongs to one of the trusted networks
+
+	@param address string - the IP address, with or without a port
+	@return whether the address is trusted
+*/
+func (n TrustedNetworks) Contains(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	addr := net.ParseIP(strings.TrimSpace(host))
+	if addr == nil {
+		return false
+	}
+	for _, cidr := range n {
+		if cidr.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+SourceIP determine the IP address a request originates from. The addresses listed in the
+forwarded header are appended by each proxy in turn, so only the right-most address not of a
+trusted proxy is reliable; the addresses left of it are set by the client. The header is
+ignored if the connection is not from a trusted proxy, when the trusted proxies are given.
+
+	@param r *http.Request - the request
+	@param header string - the header the proxies forward the source IP in, i.e.
+	"X-Forwarded-For". If empty, the address of the connection is used.
+	@return the source IP address
+*/
+func (n TrustedNetworks) SourceIP(r *http.Request, header string) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if header == "" || (len(n) > 0 && !n.Contains(remote)) {
+		return remote
+	}
+	hops := []string{}
+	for _, value := range r.Header.Values(header) {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		return remote
+	}
+	for idx := len(hops) - 1; idx >= 0; idx-- {
+		if !n.Contains(hops[idx]) {
+			return hops[idx]
+		}
+	}
+	// Every hop is a trusted proxy
+	return hops[0]
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedNetworksSourceIP(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ParseTrustedNetworks([]string{"10.0.0.1"})
+	assert.NotNil(err)
+
+	sourceIP := func(proxies TrustedNetworks, remoteAddr string, forwarded ...string) string {
+		req, err := http.NewRequest("GET", "/", nil)
+		assert.Nil(err)
+		req.RemoteAddr = remoteAddr
+		for _, value := range forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		return proxies.SourceIP(req, "X-Forwarded-For")
+	}
+
+	// Case 0: only the connecting proxy is trusted
+	assert.Equal("192.168.1.1", sourceIP(nil, "10.0.0.1:80", "1.2.3.4, 192.168.1.1"))
+	assert.Equal("10.0.0.1", sourceIP(nil, "10.0.0.1:80"))
+
+	proxies, err := ParseTrustedNetworks([]string{"10.0.0.0/8", "fd00::/8"})
+	assert.Nil(err)
+	assert.True(proxies.Contains("10.1.2.3:443"))
+	assert.True(proxies.Contains("[fd00::1]:443"))
+	assert.False(proxies.Contains("192.168.1.1"))
+
+	// Case 1: the trusted proxies are skipped, over repeated headers
+	assert.Equal(
+		"192.168.1.1", sourceIP(proxies, "10.0.0.1:80", "1.2.3.4, 192.168.1.1", "10.0.0.2"),
+	)
+	assert.Equal("10.0.0.3", sourceIP(proxies, "10.0.0.1:80", "10.0.0.3, 10.0.0.2"))
+
+	// Case 2: the header is ignored on connections not from a trusted proxy
+	assert.Equal("192.168.9.9", sourceIP(proxies, "192.168.9.9:80", "1.2.3.4"))
+}
@@ -24,6 +24,14 @@ type CustomFieldValidator interface {
 	*/
 	ValidateUserID(fl validator.FieldLevel) bool
 
+	/*
+		ValidateServiceAccountID custom service account ID validation function
+
+		 @param fl validator.FieldLevel - the field to validate
+		 @return whether is valid
+	*/
+	ValidateServiceAccountID(fl validator.FieldLevel) bool
+
 	/*
 		ValidateUserName custom user name validation function
 
@@ -55,6 +63,15 @@ type CustomFieldValidator interface {
 		 @return whether is valid
 	*/
 	ValidatePermissionName(fl validator.FieldLevel) bool
+
+	/*
+		ValidateRulePermissionName custom authorization rule permission name validation function.
+		This accepts the reserved permissions in addition to the regular permission names.
+
+		 @param fl validator.FieldLevel - the field to validate
+		 @return whether is valid
+	*/
+	ValidateRulePermissionName(fl validator.FieldLevel) bool
 }
 
 // customValidatorImpl support class for running custom validation of fields
@@ -64,6 +81,7 @@ type customValidatorImpl struct {
 	personalNameMatcher   RegexCheck
 	roleNameMatcher       RegexCheck
 	permissionNameMatcher RegexCheck
+	serviceAccountMatcher RegexCheck
 }
 
 /*
@@ -82,6 +100,31 @@ func GetCustomFieldValidator(
 	nameRegex string,
 	roleNameRegex string,
 	permissionRegex string,
+) (CustomFieldValidator, error) {
+	return GetCustomFieldValidatorWithServiceAccounts(
+		userIDRegex, usernameRegex, nameRegex, roleNameRegex, permissionRegex, userIDRegex,
+	)
+}
+
+/*
+GetCustomFieldValidatorWithServiceAccounts get new CustomFieldValidator instance, which
+validates service account IDs separately from user IDs
+
+	@param userIDRegex string - usr ID validation regex
+	@param usernameRegex string - username validation regex
+	@param nameRegex string - personal name validation regex
+	@param roleNameRegex string - role name validation regex
+	@param permissionRegex string - permission name validation regex
+	@param serviceAccountIDRegex string - service account ID validation regex
+	@return new CustomFieldValidator instance
+*/
+func GetCustomFieldValidatorWithServiceAccounts(
+	userIDRegex string,
+	usernameRegex string,
+	nameRegex string,
+	roleNameRegex string,
+	permissionRegex string,
+	serviceAccountIDRegex string,
 ) (CustomFieldValidator, error) {
 	idMatch, err := NewRegexCheck(userIDRegex)
 	if err != nil {
@@ -103,12 +146,17 @@ func GetCustomFieldValidator(
 	if err != nil {
 		return nil, err
 	}
+	serviceAccountMatch, err := NewRegexCheck(serviceAccountIDRegex)
+	if err != nil {
+		return nil, err
+	}
 	return &customValidatorImpl{
 		userIDMatcher:         idMatch,
 		usernameMatcher:       unMatch,
 		personalNameMatcher:   nameMatch,
 		roleNameMatcher:       roleMatch,
 		permissionNameMatcher: permissionMatch,
+		serviceAccountMatcher: serviceAccountMatch,
 	}, nil
 }
 
@@ -122,6 +170,9 @@ func (m *customValidatorImpl) RegisterWithValidator(v *validator.Validate) error
 	if err := v.RegisterValidation("user_id", m.ValidateUserID); err != nil {
 		return err
 	}
+	if err := v.RegisterValidation("service_account_id", m.ValidateServiceAccountID); err != nil {
+		return err
+	}
 	if err := v.RegisterValidation("username", m.ValidateUserName); err != nil {
 		return err
 	}
@@ -134,11 +185,15 @@ func (m *customValidatorImpl) RegisterWithValidator(v *validator.Validate) error
 	if err := v.RegisterValidation("user_permissions", m.ValidatePermissionName); err != nil {
 		return err
 	}
+	if err := v.RegisterValidation("rule_permissions", m.ValidateRulePermissionName); err != nil {
+		return err
+	}
 	return nil
 }
 
 /*
-ValidateUserID custom user ID validation function
+ValidateUserID custom user ID validation function. As service accounts are users as well,
+this also accepts service account IDs.
 
 	@param fl validator.FieldLevel - the field to validate
 	@return whether is valid
@@ -152,6 +207,24 @@ func (m *customValidatorImpl) ValidateUserID(fl validator.FieldLevel) bool {
 	if err != nil {
 		return false
 	}
+	return valid || m.ValidateServiceAccountID(fl)
+}
+
+/*
+ValidateServiceAccountID custom service account ID validation function
+
+	@param fl validator.FieldLevel - the field to validate
+	@return whether is valid
+*/
+func (m *customValidatorImpl) ValidateServiceAccountID(fl validator.FieldLevel) bool {
+	if fl.Field().Kind() != reflect.String {
+		return false
+	}
+	asString := fl.Field().String()
+	valid, err := m.serviceAccountMatcher.Match([]byte(asString))
+	if err != nil {
+		return false
+	}
 	return valid
 }
 
@@ -226,3 +299,17 @@ func (m *customValidatorImpl) ValidatePermissionName(fl validator.FieldLevel) bo
 	}
 	return valid
 }
+
+/*
+ValidateRulePermissionName custom authorization rule permission name validation function.
+This accepts the reserved permissions in addition to the regular permission names.
+
+	@param fl validator.FieldLevel - the field to validate
+	@return whether is valid
+*/
+func (m *customValidatorImpl) ValidateRulePermissionName(fl validator.FieldLevel) bool {
+	if fl.Field().Kind() == reflect.String && fl.Field().String() == AnonymousPermission {
+		return true
+	}
+	return m.ValidatePermissionName(fl)
+}
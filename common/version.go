@@ -0,0 +1,62 @@
+package common
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Build parameters injected at build time with
+//
+//	-ldflags "-X github.com/alwitt/padlock/common.Version=... -X github.com/alwitt/padlock/common.GitCommit=..."
+var (
+	// Version is the application version
+	Version = "dev"
+	// GitCommit is the git commit the application was built from
+	GitCommit = ""
+)
+
+// BuildInfo describes the build of the running application
+type BuildInfo struct {
+	// Version is the application version
+	Version string `json:"version"`
+	// GitCommit is the git commit the application was built from
+	GitCommit string `json:"git_commit"`
+	// GoVersion is the Go version the application was built with
+	GoVersion string `json:"go_version"`
+}
+
+/*
+GetBuildInfo get the build information of the running application. If the git commit was not
+injected at build time, the VCS revision recorded by the Go toolchain is used instead.
+
+	@return the build information
+*/
+func GetBuildInfo() BuildInfo {
+	result := BuildInfo{Version: Version, GitCommit: GitCommit, GoVersion: runtime.Version()}
+	if result.GitCommit == "" {
+		result.GitCommit = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					result.GitCommit = setting.Value
+				}
+			}
+		}
+	}
+	return result
+}
+
+/*
+ExportBuildInfo export the build information as a metric with constant value 1
+
+	@param metric *prometheus.GaugeVec - the metric to export the build information on. It must
+	support the labels "version", "git_commit", and "go_version".
+*/
+func ExportBuildInfo(metric *prometheus.GaugeVec) {
+	info := GetBuildInfo()
+	metric.With(prometheus.Labels{
+		"version": info.Version, "git_commit": info.GitCommit, "go_version": info.GoVersion,
+	}).Set(1.0)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/authenticate"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/padlocktest"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/urfave/cli/v2"
+)
+
+// Dev mode settings
+const (
+	// devModeRole is the role granting every request under the dev mode rules
+	devModeRole = "developer"
+	// devModeClientID is the OpenID client ID padlock uses against the stub issuer
+	devModeClientID = "padlock"
+	// devModeClientSecret is the OpenID client secret padlock uses against the stub issuer
+	devModeClientSecret = "padlock-dev"
+	// devModeIssuerPrefix is the path prefix of the stub OpenID issuer
+	devModeIssuerPrefix = "/issuer"
+	// devModeTokenLifetime is the lifetime of the tokens the stub OpenID issuer issues
+	devModeTokenLifetime = time.Hour
+)
+
+// devModeConfig is the application config in dev mode. The submodules are served on one port,
+// so each is given its own path prefix. Any request is allowed for users holding the
+// "developer" role.
+const devModeConfig = `
+userManagement:
+  enabled: true
+  apis:
+    endPoint:
+      pathPrefix: /user-management
+  userRoles:
+    developer:
+      permissions:
+        - dev
+authorize:
+  enabled: true
+  apis:
+    endPoint:
+      pathPrefix: /authorize
+  forUnknownUser:
+    autoAdd: true
+  rules:
+    - host: "*"
+      allowedPaths:
+        - pathPattern: "^/.*$"
+          allowedMethods:
+            - method: "*"
+              allowedPermissions:
+                - dev
+authenticate:
+  enabled: true
+  apis:
+    endPoint:
+      pathPrefix: /authenticate
+  targetClaims:
+    userID: sub
+    username: preferred_username
+`
+
+/*
+devApplication run all the submodules on one port, with an in-memory database, the dev mode
+config, and a stub OpenID issuer. Users receiving a token from the stub issuer are recorded
+with the "developer" role, if not already known.
+
+	@param c *cli.Context - the CLI context
+	@return the error which stopped the application
+*/
+func devApplication(c *cli.Context) error {
+	appCfg, customValidator, _, err := setupApplication()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := closeLogSinks(); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to close log sinks")
+		}
+	}()
+	log.WithFields(logTags).Warn("Running in dev mode: all requests are allowed for developers")
+
+	userManager, err := defineInMemoryUserManager(
+		context.Background(),
+		appCfg,
+		customValidator,
+		users.Snapshot{Version: users.SnapshotFormatVersion},
+	)
+	if err != nil {
+		return err
+	}
+
+	listenAddr := fmt.Sprintf("127.0.0.1:%d", cmdArgs.DevPort)
+	issuerURL := fmt.Sprintf("http://%s%s", listenAddr, devModeIssuerPrefix)
+	issuer, err := padlocktest.DefineOpenIDIssuer(
+		issuerURL, devModeTokenLifetime, func(ctxt context.Context, userID string) error {
+			return recordDevModeUser(ctxt, userManager, userID)
+		},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define stub OpenID issuer")
+		return err
+	}
+
+	startupGate := common.DefineReadinessGate()
+	toggles := common.DefineFeatureToggles(common.FeatureToggleState{
+		Introspection: appCfg.Authentication.Introspection.Enabled,
+		AutoAddUser:   appCfg.Authorization.UnknownUser.AutoAdd,
+		DryRun:        false,
+	}, nil)
+	inFlight := common.DefineInFlightTracker(nil)
+
+	// The stub issuer is only reachable once the server is listening
+	oidClient := authenticate.DefineDeferredOpenIDClient()
+	introspector := authenticate.DefineIntrospector(
+		authenticate.DefineTokenCache(
+			time.Second*time.Duration(appCfg.Authentication.Introspection.ReIntrospectInterval),
+			time.Second*time.Duration(appCfg.Authentication.Introspection.InactiveCacheTTL),
+			appCfg.Authentication.Introspection.MaxCacheEntries,
+			nil,
+		),
+		oidClient.IntrospectToken,
+		nil,
+		nil,
+		appCfg.Authentication.Introspection.StaleWhileRevalidate,
+	)
+	// The user management API admins present the same tokens as the authenticated users
+	adminTokens, err := apis.DefineBearerTokenVerifier(
+		appCfg.UserManagement.APIs.RequestLogging,
+		oidClient,
+		appCfg.Authentication.Introspection.Enabled,
+		introspector,
+		appCfg.Authentication.AuthenticationConfig,
+		toggles,
+		nil,
+		appCfg.UserIDNormalization,
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define bearer token verifier")
+		return err
+	}
+
+	userManagementSvr, err := apis.BuildUserManagementServer(
+		appCfg.UserManagement.APIServerConfig,
+		userManager,
+		customValidator,
+		nil,
+		nil,
+		startupGate,
+		toggles,
+		nil,
+		nil,
+		appCfg.UserManagement.AdminAuth,
+		adminTokens,
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Unable to define User Management API HTTP Server")
+		return err
+	}
+
+	matcher, err := defineRequestMatcher(&appCfg.Authorization.AuthorizationConfig)
+	if err != nil {
+		return err
+	}
+	authorizationSvr, err := apis.BuildAuthorizationServer(
+		appCfg.Authorization.APIServerConfig,
+		userManager,
+		matcher,
+		customValidator,
+		appCfg.Authorization.RequestParamLocation,
+		appCfg.Authorization.UnknownUser,
+		appCfg.Authorization.Response,
+		nil,
+		startupGate,
+		inFlight,
+		apis.AuthorizationOptions{
+			Toggles:       toggles,
+			WebSocket:     appCfg.Authorization.WebSocket,
+			Impersonation: appCfg.Authorization.Impersonation,
+			UserIDs:       appCfg.UserIDNormalization,
+			Bypass:        appCfg.Authorization.Bypass,
+		},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Unable to define Authorization API HTTP Server")
+		return err
+	}
+
+	authenticationSvr, err := apis.BuildAuthenticationServer(
+		appCfg.Authentication.APIServerConfig,
+		oidClient,
+		appCfg.Authentication.Introspection.Enabled,
+		introspector,
+		appCfg.Authentication.AuthenticationConfig,
+		appCfg.Authorization.RequestParamLocation,
+		nil,
+		nil,
+		nil,
+		startupGate,
+		nil,
+		inFlight,
+		toggles,
+		nil,
+		userManager,
+		authorizationSvr.Handler,
+		appCfg.Authorization.APIs.Endpoint.PathPrefix,
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Unable to define Authentication API HTTP Server")
+		return err
+	}
+
+	router := http.NewServeMux()
+	router.Handle(devModeIssuerPrefix+"/", http.StripPrefix(devModeIssuerPrefix, issuer))
+	for _, svr := range []struct {
+		pathPrefix string
+		handler    http.Handler
+	}{
+		{appCfg.UserManagement.APIs.Endpoint.PathPrefix, userManagementSvr.Handler},
+		{appCfg.Authorization.APIs.Endpoint.PathPrefix, authorizationSvr.Handler},
+		{appCfg.Authentication.APIs.Endpoint.PathPrefix, authenticationSvr.Handler},
+	} {
+		router.Handle(svr.pathPrefix+"/", svr.handler)
+	}
+	devSvr := &http.Server{Addr: listenAddr, Handler: router}
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to listen on %s", listenAddr)
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- devSvr.Serve(listener)
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := devSvr.Shutdown(ctx); err != nil {
+			log.WithError(err).Errorf("Failure during dev mode HTTP Server shutdown")
+		}
+	}()
+
+	// OpenID issuer discovery against the stub issuer
+	clientID := devModeClientID
+	clientSecret := devModeClientSecret
+	err = common.RetryWithBackoff(
+		c.Context, appCfg.Startup.Retry, logTags, "OpenID issuer discovery", func() error {
+			client, err := apis.DefineOpenIDIssuerClient(common.OpenIDIssuerConfig{
+				Issuer: issuerURL, ClientID: &clientID, ClientCred: &clientSecret,
+			})
+			if err != nil {
+				return err
+			}
+			oidClient.SetClient(client)
+			return nil
+		},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Startup failed")
+		return err
+	}
+	startupGate.MarkReady()
+	log.WithFields(logTags).Warnf(
+		"Dev mode serving on http://%s: user management at %s, authorization at %s, "+
+			"authentication at %s, OpenID issuer at %s",
+		listenAddr,
+		appCfg.UserManagement.APIs.Endpoint.PathPrefix,
+		appCfg.Authorization.APIs.Endpoint.PathPrefix,
+		appCfg.Authentication.APIs.Endpoint.PathPrefix,
+		devModeIssuerPrefix,
+	)
+
+	cc := make(chan os.Signal, 1)
+	signal.Notify(cc, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-cc:
+		return nil
+	case err := <-serveErr:
+		log.WithError(err).Error("Dev mode HTTP Server Failure")
+		return err
+	}
+}
+
+/*
+recordDevModeUser record a user with the "developer" role, if the user is not already known
+
+	@param ctxt context.Context - the operating context
+	@param userManager users.Management - the user manager
+	@param userID string - the user ID
+	@return whether successful
+*/
+func recordDevModeUser(ctxt context.Context, userManager users.Management, userID string) error {
+	if _, err := userManager.GetUser(ctxt, userID); err == nil {
+		return nil
+	}
+	log.WithFields(logTags).Infof("Recording dev mode user %s as %s", userID, devModeRole)
+	return userManager.DefineUser(ctxt, models.UserConfig{UserID: userID}, []string{devModeRole})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+	"github.com/go-playground/validator/v10"
+	"github.com/urfave/cli/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Diagnostic check outcomes
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+type doctorArgs struct {
+	TimeoutSec int
+}
+
+var doctorCmdArgs doctorArgs
+
+// doctorCommand define the installation diagnostics CLI command
+func doctorCommand() *cli.Command {
+	return &cli.Command{
+		Name: "doctor",
+		Usage: "Diagnose an installation: config validity, database connectivity and schema, " +
+			"OpenID issuer reachability, and header configuration consistency",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:        "timeout",
+				Usage:       "Timeout (sec) of each network check",
+				Value:       10,
+				DefaultText: "10",
+				Destination: &doctorCmdArgs.TimeoutSec,
+				Required:    false,
+			},
+		},
+		Action: commandAction("doctor", doctorApplication),
+	}
+}
+
+// doctorCheck is the outcome of one diagnostic check
+type doctorCheck struct {
+	// Name is the check name
+	Name string `json:"name"`
+	// Status is either "pass", "warn", or "fail"
+	Status string `json:"status"`
+	// Detail describes the outcome
+	Detail string `json:"detail"`
+}
+
+// doctorResult is the result of the doctor command
+type doctorResult struct {
+	// Checks are the outcomes of the checks performed
+	Checks []doctorCheck `json:"checks"`
+}
+
+func doctorApplication(c *cli.Context) (interface{}, error) {
+	result := doctorResult{Checks: []doctorCheck{}}
+	report := func(check doctorCheck) {
+		result.Checks = append(result.Checks, check)
+		if !jsonOutput() {
+			fmt.Printf("[%s] %s: %s\n", strings.ToUpper(check.Status), check.Name, check.Detail)
+		}
+	}
+
+	appCfg, _, validate, err := setupApplication()
+	if err != nil {
+		report(doctorCheck{Name: "config", Status: doctorFail, Detail: err.Error()})
+		return result, fmt.Errorf("config %s is not valid", cmdArgs.ConfigFile)
+	}
+	defer func() {
+		if err := closeLogSinks(); err != nil {
+			log.WithError(err).Errorf("Failed to close log sinks")
+		}
+	}()
+	for _, check := range doctorCheckConfig(appCfg) {
+		report(check)
+	}
+	if appCfg.UserManagement.Enabled ||
+		appCfg.Authorization.Enabled ||
+		appCfg.CacheInvalidation.Enabled {
+		for _, check := range doctorCheckDatabase(c.Context, validate) {
+			report(check)
+		}
+	}
+	if appCfg.Authentication.Enabled {
+		for _, check := range doctorCheckOpenIDIssuer(c.Context, appCfg, validate) {
+			report(check)
+		}
+	}
+	for _, check := range doctorCheckHeaders(appCfg) {
+		report(check)
+	}
+
+	failed := 0
+	for _, check := range result.Checks {
+		if check.Status == doctorFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return result, fmt.Errorf("%d of %d checks failed", failed, len(result.Checks))
+	}
+	return result, nil
+}
+
+/*
+doctorCheckConfig check the application config for problems the config validation allows
+
+	@param appCfg common.AuthorizationServerConfig - the application config
+	@return the check outcomes
+*/
+func doctorCheckConfig(appCfg common.AuthorizationServerConfig) []doctorCheck {
+	enabled := []string{}
+	if appCfg.UserManagement.Enabled {
+		enabled = append(enabled, "userManagement")
+	}
+	if appCfg.Authorization.Enabled {
+		enabled = append(enabled, "authorize")
+	}
+	if appCfg.Authentication.Enabled {
+		enabled = append(enabled, "authenticate")
+	}
+	if len(enabled) == 0 {
+		return []doctorCheck{{
+			Name: "config", Status: doctorWarn, Detail: "valid, but no submodule is enabled",
+		}}
+	}
+	checks := []doctorCheck{{
+		Name:   "config",
+		Status: doctorPass,
+		Detail: fmt.Sprintf("valid, enabled submodules: %s", strings.Join(enabled, " ")),
+	}}
+	if appCfg.Authorization.Enabled && !appCfg.UserManagement.Enabled {
+		checks = append(checks, doctorCheck{
+			Name:   "config",
+			Status: doctorWarn,
+			Detail: "the authorization rules and roles are only fully validated when " +
+				"userManagement is also enabled",
+		})
+	}
+	return checks
+}
+
+/*
+doctorCheckDatabase check the database is reachable, and its schema matches the models
+
+	@param ctxt context.Context - the operating context
+	@param validate *validator.Validate - validator for the database parameters
+	@return the check outcomes
+*/
+func doctorCheckDatabase(ctxt context.Context, validate *validator.Validate) []doctorCheck {
+	if cmdArgs.DBParamFile == "" {
+		return []doctorCheck{{
+			Name:   "database",
+			Status: doctorFail,
+			Detail: "no database connection parameter file given",
+		}}
+	}
+	dbDSN, err := buildDatabaseDSN(validate)
+	if err != nil {
+		return []doctorCheck{{Name: "database", Status: doctorFail, Detail: err.Error()}}
+	}
+	dbDSN = fmt.Sprintf("%s connect_timeout=%d", dbDSN, doctorCmdArgs.TimeoutSec)
+	db, err := gorm.Open(
+		postgres.Open(dbDSN), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)},
+	)
+	if err != nil {
+		return []doctorCheck{{Name: "database", Status: doctorFail, Detail: err.Error()}}
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return []doctorCheck{{Name: "database", Status: doctorFail, Detail: err.Error()}}
+	}
+	defer sqlDB.Close()
+
+	lclCtxt, cancel := context.WithTimeout(
+		ctxt, time.Second*time.Duration(doctorCmdArgs.TimeoutSec),
+	)
+	defer cancel()
+	var version string
+	if err := db.WithContext(lclCtxt).Raw("SELECT version()").Scan(&version).Error; err != nil {
+		return []doctorCheck{{Name: "database", Status: doctorFail, Detail: err.Error()}}
+	}
+	checks := []doctorCheck{{Name: "database", Status: doctorPass, Detail: version}}
+
+	missing, err := models.CheckSchema(db.WithContext(lclCtxt))
+	switch {
+	case err != nil:
+		checks = append(checks, doctorCheck{
+			Name: "database schema", Status: doctorFail, Detail: err.Error(),
+		})
+	case len(missing) > 0:
+		checks = append(checks, doctorCheck{
+			Name:   "database schema",
+			Status: doctorWarn,
+			Detail: fmt.Sprintf(
+				"missing %s; these are created on startup if the DB user is allowed to",
+				strings.Join(missing, ", "),
+			),
+		})
+	default:
+		checks = append(checks, doctorCheck{
+			Name: "database schema", Status: doctorPass, Detail: "matches the current models",
+		})
+	}
+	return checks
+}
+
+/*
+doctorCheckOpenIDIssuer check OpenID issuer discovery, and whether the issuer endpoints used
+are reachable
+
+	@param ctxt context.Context - the operating context
+	@param appCfg common.AuthorizationServerConfig - the application config
+	@param validate *validator.Validate - validator for the OpenID issuer parameters
+	@return the check outcomes
+*/
+func doctorCheckOpenIDIssuer(
+	ctxt context.Context,
+	appCfg common.AuthorizationServerConfig,
+	validate *validator.Validate,
+) []doctorCheck {
+	oidParams, err := readOpenIDIssuerParams(validate)
+	if err != nil {
+		return []doctorCheck{{Name: "openid discovery", Status: doctorFail, Detail: err.Error()}}
+	}
+	oidClient, err := defineOpenIDIssuersClient(oidParams)
+	if err != nil {
+		return []doctorCheck{{Name: "openid discovery", Status: doctorFail, Detail: err.Error()}}
+	}
+	checks := []doctorCheck{{
+		Name:   "openid discovery",
+		Status: doctorPass,
+		Detail: fmt.Sprintf("read configuration and JWKS of %s", openIDIssuerNames(oidParams)),
+	}}
+
+	lclCtxt, cancel := context.WithTimeout(
+		ctxt, time.Second*time.Duration(doctorCmdArgs.TimeoutSec),
+	)
+	defer cancel()
+	if err := oidClient.ProbeEndpoints(lclCtxt); err != nil {
+		checks = append(checks, doctorCheck{
+			Name: "openid endpoints", Status: doctorFail, Detail: err.Error(),
+		})
+	} else {
+		checks = append(checks, doctorCheck{
+			Name: "openid endpoints", Status: doctorPass, Detail: "reachable",
+		})
+	}
+
+	if appCfg.Authentication.Introspection.Enabled {
+		if oidClient.CanIntrospect() {
+			checks = append(checks, doctorCheck{
+				Name: "openid introspection", Status: doctorPass, Detail: "available",
+			})
+		} else {
+			checks = append(checks, doctorCheck{
+				Name:   "openid introspection",
+				Status: doctorFail,
+				Detail: "introspection is enabled, but the issuer has no introspection endpoint, " +
+					"or no client credentials are given",
+			})
+		}
+	}
+	return checks
+}
+
+/*
+doctorCheckHeaders check the HTTP header configuration of the enabled submodules is consistent
+
+	@param appCfg common.AuthorizationServerConfig - the application config
+	@return the check outcomes
+*/
+func doctorCheckHeaders(appCfg common.AuthorizationServerConfig) []doctorCheck {
+	issues := appCfg.CheckHeaderConsistency()
+	if len(issues) == 0 {
+		return []doctorCheck{{Name: "headers", Status: doctorPass, Detail: "consistent"}}
+	}
+	checks := []doctorCheck{}
+	for _, issue := range issues {
+		status := doctorWarn
+		if issue.Severe {
+			status = doctorFail
+		}
+		checks = append(checks, doctorCheck{
+			Name: "headers", Status: status, Detail: issue.Description,
+		})
+	}
+	return checks
+}
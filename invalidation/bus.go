@@ -0,0 +1,188 @@
+package invalidation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+)
+
+// Supported invalidation targets
+const (
+	// TargetToken invalidates a cached token. The key is the token hash.
+	TargetToken = "token"
+	// TargetUser invalidates cached information for a user. The key is the user ID.
+	TargetUser = "user"
+	// TargetDecision invalidates memoized authorization decisions. The key is the user ID, or
+	// empty for the decisions of all users.
+	TargetDecision = "decision"
+	// TargetAll invalidates all cached entries. The key is not used.
+	TargetAll = "all"
+)
+
+// Message is one cache invalidation notice
+type Message struct {
+	// Source is the instance which emitted the notice
+	Source string `json:"source"`
+	// Target is the type of cache entry being invalidated
+	Target string `json:"target"`
+	// Key identifies the cache entry being invalidated
+	Key string `json:"key,omitempty"`
+}
+
+// String implements toString for object
+func (m Message) String() string {
+	return fmt.Sprintf("INVALIDATE[%s '%s' FROM %s]", m.Target, m.Key, m.Source)
+}
+
+// Handler signature for a function which processes invalidation notices
+type Handler func(ctxt context.Context, msg Message) error
+
+// Bus delivers cache invalidation notices between padlock replicas
+type Bus interface {
+	/*
+		Publish broadcast an invalidation notice to all replicas, including this one
+
+		 @param ctxt context.Context - the operating context
+		 @param target string - the type of cache entry being invalidated
+		 @param key string - the cache entry being invalidated
+		 @return whether successful
+	*/
+	Publish(ctxt context.Context, target, key string) error
+
+	/*
+		Subscribe register a handler for invalidation notices of a target type. The handler
+		will also receive TargetAll notices.
+
+		 @param target string - the type of cache entry being invalidated
+		 @param handler Handler - the handler for the notice
+	*/
+	Subscribe(target string, handler Handler)
+
+	/*
+		Stop stop the bus
+
+		 @param ctxt context.Context - the operating context
+		 @return whether successful
+	*/
+	Stop(ctxt context.Context) error
+}
+
+// handlerRegistry support object for tracking handlers by target
+type handlerRegistry struct {
+	goutils.Component
+	lock     sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// defineHandlerRegistry define new handlerRegistry
+func defineHandlerRegistry(logTags log.Fields) *handlerRegistry {
+	return &handlerRegistry{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		lock:     sync.RWMutex{},
+		handlers: make(map[string][]Handler),
+	}
+}
+
+// Subscribe register a handler for invalidation notices of a target type
+func (r *handlerRegistry) Subscribe(target string, handler Handler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.handlers[target] = append(r.handlers[target], handler)
+}
+
+// dispatch pass a notice to all associated handlers. A TargetAll notice is passed to every
+// registered handler.
+func (r *handlerRegistry) dispatch(ctxt context.Context, msg Message) {
+	logTags := r.GetLogTagsForContext(ctxt)
+	handlers := []Handler{}
+	r.lock.RLock()
+	if msg.Target == TargetAll {
+		for _, targetHandlers := range r.handlers {
+			handlers = append(handlers, targetHandlers...)
+		}
+	} else {
+		handlers = append(handlers, r.handlers[msg.Target]...)
+	}
+	r.lock.RUnlock()
+	for _, handler := range handlers {
+		if err := handler(ctxt, msg); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to process %s", msg.String())
+		}
+	}
+}
+
+// ======================================================================================
+
+// localBusImpl implements Bus for a single process
+type localBusImpl struct {
+	*handlerRegistry
+	instance string
+}
+
+/*
+DefineLocalBus defines a Bus which only delivers notices within the current process
+
+	@param instance string - name of this replica
+	@return new Bus instance
+*/
+func DefineLocalBus(instance string) Bus {
+	logTags := log.Fields{"module": "invalidation", "component": "local-bus", "instance": instance}
+	return &localBusImpl{handlerRegistry: defineHandlerRegistry(logTags), instance: instance}
+}
+
+/*
+Publish broadcast an invalidation notice to all replicas, including this one
+
+	@param ctxt context.Context - the operating context
+	@param target string - the type of cache entry being invalidated
+	@param key string - the cache entry being invalidated
+	@return whether successful
+*/
+func (b *localBusImpl) Publish(ctxt context.Context, target, key string) error {
+	b.dispatch(ctxt, Message{Source: b.instance, Target: target, Key: key})
+	return nil
+}
+
+/*
+Stop stop the bus
+
+	@param ctxt context.Context - the operating context
+	@return whether successful
+*/
+func (b *localBusImpl) Stop(ctxt context.Context) error {
+	return nil
+}
+
+// ======================================================================================
+
+// encodeMessage serialize a notice for transport
+func encodeMessage(msg Message) (string, error) {
+	t, err := json.Marshal(&msg)
+	if err != nil {
+		return "", err
+	}
+	return string(t), nil
+}
+
+// decodeMessage parse a notice received from transport
+func decodeMessage(raw string) (Message, error) {
+	var msg Message
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		return Message{}, err
+	}
+	if msg.Target == "" {
+		return Message{}, fmt.Errorf("invalidation notice missing target")
+	}
+	return msg, nil
+}
@@ -0,0 +1,76 @@
+package invalidation
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBus(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+	utCtxt := context.Background()
+
+	instance := uuid.New().String()
+	uut := DefineLocalBus(instance)
+
+	tokenNotices := []Message{}
+	userNotices := []Message{}
+	uut.Subscribe(TargetToken, func(ctxt context.Context, msg Message) error {
+		tokenNotices = append(tokenNotices, msg)
+		return nil
+	})
+	uut.Subscribe(TargetUser, func(ctxt context.Context, msg Message) error {
+		userNotices = append(userNotices, msg)
+		return fmt.Errorf("dummy error")
+	})
+
+	// Case 0: token notice
+	{
+		key := uuid.New().String()
+		assert.Nil(uut.Publish(utCtxt, TargetToken, key))
+		assert.Len(tokenNotices, 1)
+		assert.Empty(userNotices)
+		assert.Equal(key, tokenNotices[0].Key)
+		assert.Equal(instance, tokenNotices[0].Source)
+	}
+
+	// Case 1: user notice, handler error does not affect publish
+	{
+		key := uuid.New().String()
+		assert.Nil(uut.Publish(utCtxt, TargetUser, key))
+		assert.Len(tokenNotices, 1)
+		assert.Len(userNotices, 1)
+		assert.Equal(key, userNotices[0].Key)
+	}
+
+	// Case 2: invalidate all reaches every handler
+	{
+		assert.Nil(uut.Publish(utCtxt, TargetAll, ""))
+		assert.Len(tokenNotices, 2)
+		assert.Len(userNotices, 2)
+		assert.Equal(TargetAll, tokenNotices[1].Target)
+	}
+
+	assert.Nil(uut.Stop(utCtxt))
+}
+
+func TestMessageEncoding(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := Message{Source: uuid.New().String(), Target: TargetUser, Key: uuid.New().String()}
+	encoded, err := encodeMessage(msg)
+	assert.Nil(err)
+	decoded, err := decodeMessage(encoded)
+	assert.Nil(err)
+	assert.Equal(msg, decoded)
+
+	_, err = decodeMessage(`{"source": "hello"}`)
+	assert.NotNil(err)
+	_, err = decodeMessage(`not-json`)
+	assert.NotNil(err)
+}
@@ -0,0 +1,242 @@
+package invalidation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// listenConn is the part of a Postgres connection the receiving loop uses
+type listenConn interface {
+	WaitForNotification(ctxt context.Context) (*pgconn.Notification, error)
+	Close(ctxt context.Context) error
+}
+
+// postgresBusImpl implements Bus using Postgres LISTEN / NOTIFY
+type postgresBusImpl struct {
+	*handlerRegistry
+	instance string
+	dsn      string
+	// password if provided, the Postgres password, in place of the one in the DSN
+	password common.SecretFile
+	channel  string
+	// publisher is the connection used to send notices
+	publisher     *pgx.Conn
+	publisherLock sync.Mutex
+	// connectListener open a connection, and LISTEN on the channel
+	connectListener func(ctxt context.Context) (listenConn, error)
+	// reconnectWait is the initial wait before reconnecting a lost listening connection
+	reconnectWait time.Duration
+	// stop the listening loop
+	runtimeCtxt context.Context
+	stop        context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+/*
+DefinePostgresBus defines a Bus which exchanges notices through Postgres LISTEN / NOTIFY
+
+	@param ctxt context.Context - the operating context
+	@param instance string - name of this replica
+	@param dsn string - Postgres connection DSN
+	@param channel string - the Postgres notification channel
+	@param password common.SecretFile - if provided, the Postgres password, in place of the one
+	in the DSN. It is read on each new connection, so the password can be rotated.
+	@return new Bus instance
+*/
+func DefinePostgresBus(
+	ctxt context.Context, instance, dsn, channel string, password common.SecretFile,
+) (Bus, error) {
+	logTags := log.Fields{
+		"module": "invalidation", "component": "postgres-bus", "instance": instance, "channel": channel,
+	}
+
+	publisher, err := connectPostgres(ctxt, dsn, password)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to connect publisher to Postgres")
+		return nil, err
+	}
+	listener, err := connectPostgres(ctxt, dsn, password)
+	if err != nil {
+		_ = publisher.Close(ctxt)
+		log.WithError(err).WithFields(logTags).Error("Failed to connect listener to Postgres")
+		return nil, err
+	}
+
+	runtimeCtxt, stop := context.WithCancel(context.Background())
+	instanceObj := &postgresBusImpl{
+		handlerRegistry: defineHandlerRegistry(logTags),
+		instance:        instance,
+		dsn:             dsn,
+		password:        password,
+		channel:         channel,
+		publisher:       publisher,
+		publisherLock:   sync.Mutex{},
+		reconnectWait:   time.Second,
+		runtimeCtxt:     runtimeCtxt,
+		stop:            stop,
+		wg:              sync.WaitGroup{},
+	}
+
+	instanceObj.connectListener = func(ctxt context.Context) (listenConn, error) {
+		conn, err := connectPostgres(ctxt, dsn, password)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to reconnect listener to Postgres")
+			return nil, err
+		}
+		if err := instanceObj.listen(ctxt, conn); err != nil {
+			_ = conn.Close(context.Background())
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	if err := instanceObj.listen(ctxt, listener); err != nil {
+		stop()
+		_ = publisher.Close(ctxt)
+		_ = listener.Close(ctxt)
+		return nil, err
+	}
+
+	instanceObj.wg.Add(1)
+	go instanceObj.receiveLoop(listener)
+
+	return instanceObj, nil
+}
+
+/*
+connectPostgres open a connection to Postgres
+
+	@param ctxt context.Context - the operating context
+	@param dsn string - Postgres connection DSN
+	@param password common.SecretFile - if provided, the Postgres password, in place of the one
+	in the DSN
+	@return the connection
+*/
+func connectPostgres(
+	ctxt context.Context, dsn string, password common.SecretFile,
+) (*pgx.Conn, error) {
+	connCfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if password != nil {
+		connCfg.Password = password.Value()
+	}
+	return pgx.ConnectConfig(ctxt, connCfg)
+}
+
+// listen issue the LISTEN command on a connection
+func (b *postgresBusImpl) listen(ctxt context.Context, conn *pgx.Conn) error {
+	cmd := fmt.Sprintf("LISTEN %s", pgx.Identifier{b.channel}.Sanitize())
+	if _, err := conn.Exec(ctxt, cmd); err != nil {
+		log.WithError(err).WithFields(b.LogTags).Error("Failed to LISTEN on channel")
+		return err
+	}
+	return nil
+}
+
+/*
+receiveLoop wait for notifications, and reconnect if the listening connection is lost. The
+notices sent while disconnected are lost, so all cached entries of this replica are
+invalidated after reconnecting.
+
+	@param conn listenConn - the listening connection
+*/
+func (b *postgresBusImpl) receiveLoop(conn listenConn) {
+	defer b.wg.Done()
+	retryWait := b.reconnectWait
+	for {
+		if conn != nil {
+			notice, err := conn.WaitForNotification(b.runtimeCtxt)
+			if err == nil {
+				retryWait = b.reconnectWait
+				msg, err := decodeMessage(notice.Payload)
+				if err != nil {
+					log.WithError(err).WithFields(b.LogTags).
+						Errorf("Unable to parse notice '%s'", notice.Payload)
+					continue
+				}
+				log.WithFields(b.LogTags).Debugf("Received %s", msg.String())
+				b.dispatch(b.runtimeCtxt, msg)
+				continue
+			}
+			_ = conn.Close(context.Background())
+			conn = nil
+			if b.runtimeCtxt.Err() != nil {
+				return
+			}
+			log.WithError(err).WithFields(b.LogTags).Error("Lost Postgres listening connection")
+		}
+		// Reconnect after a wait
+		select {
+		case <-b.runtimeCtxt.Done():
+			return
+		case <-time.After(retryWait):
+		}
+		if retryWait < time.Second*30 {
+			retryWait *= 2
+		}
+		newConn, err := b.connectListener(b.runtimeCtxt)
+		if err != nil {
+			continue
+		}
+		conn = newConn
+		log.WithFields(b.LogTags).
+			Warn("Reconnected Postgres listening connection, invalidating all cached entries")
+		b.dispatch(b.runtimeCtxt, Message{Source: b.instance, Target: TargetAll})
+	}
+}
+
+/*
+Publish broadcast an invalidation notice to all replicas, including this one
+
+	@param ctxt context.Context - the operating context
+	@param target string - the type of cache entry being invalidated
+	@param key string - the cache entry being invalidated
+	@return whether successful
+*/
+func (b *postgresBusImpl) Publish(ctxt context.Context, target, key string) error {
+	logTags := b.GetLogTagsForContext(ctxt)
+	msg := Message{Source: b.instance, Target: target, Key: key}
+	payload, err := encodeMessage(msg)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to encode %s", msg.String())
+		return err
+	}
+	b.publisherLock.Lock()
+	defer b.publisherLock.Unlock()
+	if b.publisher.IsClosed() {
+		newConn, err := connectPostgres(ctxt, b.dsn, b.password)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to reconnect publisher to Postgres")
+			return err
+		}
+		b.publisher = newConn
+	}
+	if _, err := b.publisher.Exec(ctxt, "SELECT pg_notify($1, $2)", b.channel, payload); err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to publish %s", msg.String())
+		return err
+	}
+	return nil
+}
+
+/*
+Stop stop the bus
+
+	@param ctxt context.Context - the operating context
+	@return whether successful
+*/
+func (b *postgresBusImpl) Stop(ctxt context.Context) error {
+	b.stop()
+	b.wg.Wait()
+	b.publisherLock.Lock()
+	defer b.publisherLock.Unlock()
+	return b.publisher.Close(ctxt)
+}
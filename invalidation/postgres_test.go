@@ -0,0 +1,121 @@
+package invalidation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeListenConn is a listening connection whose notices are fed by the test
+type fakeListenConn struct {
+	notices chan *pgconn.Notification
+}
+
+func (c *fakeListenConn) WaitForNotification(ctxt context.Context) (*pgconn.Notification, error) {
+	select {
+	case <-ctxt.Done():
+		return nil, ctxt.Err()
+	case notice, ok := <-c.notices:
+		if !ok {
+			return nil, fmt.Errorf("connection lost")
+		}
+		return notice, nil
+	}
+}
+
+func (c *fakeListenConn) Close(ctxt context.Context) error {
+	return nil
+}
+
+func TestPostgresBusReconnect(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	instance := uuid.New().String()
+	runtimeCtxt, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	// The listening connections handed out on reconnect. The first attempt fails.
+	lock := sync.Mutex{}
+	attempts := 0
+	reconnected := &fakeListenConn{notices: make(chan *pgconn.Notification)}
+	uut := &postgresBusImpl{
+		handlerRegistry: defineHandlerRegistry(log.Fields{"module": "invalidation"}),
+		instance:        instance,
+		channel:         "unit-test",
+		reconnectWait:   time.Millisecond * 10,
+		runtimeCtxt:     runtimeCtxt,
+		stop:            stop,
+		connectListener: func(ctxt context.Context) (listenConn, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			attempts++
+			if attempts == 1 {
+				return nil, fmt.Errorf("unit-test")
+			}
+			return reconnected, nil
+		},
+	}
+
+	received := make(chan Message, 4)
+	uut.Subscribe(TargetUser, func(ctxt context.Context, msg Message) error {
+		received <- msg
+		return nil
+	})
+
+	original := &fakeListenConn{notices: make(chan *pgconn.Notification)}
+	uut.wg.Add(1)
+	go uut.receiveLoop(original)
+
+	sendNotice := func(conn *fakeListenConn, msg Message) {
+		payload, err := encodeMessage(msg)
+		assert.Nil(err)
+		conn.notices <- &pgconn.Notification{Channel: "unit-test", Payload: payload}
+	}
+	nextMessage := func() Message {
+		select {
+		case msg := <-received:
+			return msg
+		case <-time.After(time.Second * 5):
+			assert.Fail("no notice received")
+			return Message{}
+		}
+	}
+
+	// Case 0: notice from another replica
+	{
+		sendNotice(original, Message{Source: "other", Target: TargetUser, Key: "alice"})
+		msg := nextMessage()
+		assert.Equal(TargetUser, msg.Target)
+		assert.Equal("alice", msg.Key)
+	}
+
+	// Case 1: the connection is lost, and everything is invalidated after reconnecting
+	{
+		close(original.notices)
+		msg := nextMessage()
+		assert.Equal(TargetAll, msg.Target)
+		assert.Equal(instance, msg.Source)
+		lock.Lock()
+		assert.Equal(2, attempts)
+		lock.Unlock()
+	}
+
+	// Case 2: notices are received on the new connection
+	{
+		sendNotice(reconnected, Message{Source: "other", Target: TargetUser, Key: "bob"})
+		msg := nextMessage()
+		assert.Equal(TargetUser, msg.Target)
+		assert.Equal("bob", msg.Key)
+	}
+
+	stop()
+	uut.wg.Wait()
+}
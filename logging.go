@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/level"
+	"github.com/apex/log/handlers/multi"
+)
+
+// logFileSink is the rotating log file sink, if enabled
+var logFileSink common.RotatingLogSink
+
+// logSinks are all the enabled log sinks
+var logSinks []common.LogSink
+
+/*
+setupLogSinks add the configured log sinks in addition to stderr. The stderr logging keeps the
+level given on the command line.
+
+	@param config common.LoggingConfig - the log sink config
+*/
+func setupLogSinks(config common.LoggingConfig) error {
+	sinks := []common.LogSink{}
+	if config.File.Enabled {
+		sink, err := common.DefineRotatingFileLogSink(config.File)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define log file sink")
+			return err
+		}
+		logFileSink = sink
+		sinks = append(sinks, sink)
+	}
+	if config.Syslog.Enabled {
+		sink, err := common.DefineSyslogLogSink(config.Syslog)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define syslog log sink")
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	if config.Loki.Enabled {
+		sink, err := common.DefineLokiLogSink(config.Loki)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define Loki log sink")
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	logSinks = sinks
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	logger, ok := log.Log.(*log.Logger)
+	if !ok {
+		return nil
+	}
+	// Keep the stderr logging at the command line level, while letting the lower level
+	// entries through to the sinks which want them
+	handlers := []log.Handler{level.New(logger.Handler, logger.Level)}
+	lowest := logger.Level
+	for _, sink := range sinks {
+		handlers = append(handlers, sink)
+		if sink.Level() < lowest {
+			lowest = sink.Level()
+		}
+	}
+	log.SetHandler(multi.New(handlers...))
+	log.SetLevel(lowest)
+	return nil
+}
+
+/*
+closeLogSinks flush and close all the log sinks. Logging continues on stderr afterwards.
+*/
+func closeLogSinks() error {
+	if logger, ok := log.Log.(*log.Logger); ok && len(logSinks) > 0 {
+		// Only the stderr handler remains
+		if handler, ok := logger.Handler.(*multi.Handler); ok && len(handler.Handlers) > 0 {
+			log.SetHandler(handler.Handlers[0])
+		}
+	}
+	var firstErr error
+	for _, sink := range logSinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	logSinks = nil
+	return firstErr
+}
+
+/*
+startLogFileRotation periodically rotate the log file, if enabled
+
+	@param config common.LogFileConfig - the log file config
+	@param wg *sync.WaitGroup - the wait group tracking the application goroutines
+	@return function to stop the rotation
+*/
+func startLogFileRotation(config common.LogFileConfig, wg *sync.WaitGroup) (func() error, error) {
+	if logFileSink == nil || config.RotateIntervalHours == 0 {
+		return func() error { return nil }, nil
+	}
+	rotateTimer, err := goutils.GetIntervalTimerInstance(
+		context.Background(), wg, log.Fields{
+			"module":    "main",
+			"component": "timer",
+			"instance":  "log-file-rotate",
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := rotateTimer.Start(
+		time.Hour*time.Duration(config.RotateIntervalHours), logFileSink.Rotate, false,
+	); err != nil {
+		return nil, err
+	}
+	return rotateTimer.Stop, nil
+}
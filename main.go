@@ -4,22 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/apis"
 	"github.com/alwitt/padlock/authenticate"
 	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
 	"github.com/alwitt/padlock/match"
 	"github.com/alwitt/padlock/models"
+	"github.com/alwitt/padlock/operator"
 	"github.com/alwitt/padlock/users"
 	"github.com/apex/log"
 	apexJSON "github.com/apex/log/handlers/json"
+	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	"github.com/urfave/cli/v2"
 	"gorm.io/driver/postgres"
@@ -29,15 +39,29 @@ import (
 type cliArgs struct {
 	JSONLog               bool
 	LogLevel              string `validate:"required,oneof=debug info warn error"`
-	ConfigFile            string `validate:"file"`
+	ConfigFile            string `validate:"omitempty,file"`
+	ConfigDir             string `validate:"omitempty,dir"`
 	DBParamFile           string `validate:"omitempty,file"`
 	DBPassword            string
+	DBPasswordFile        string `validate:"omitempty,file"`
 	OpenIDIssuerParamFile string `validate:"omitempty,file"`
+	OpenIDClientCredFile  string `validate:"omitempty,file"`
+	RedisPassword         string
+	SMTPPassword          string
 	Hostname              string
+	OutputFormat          string `validate:"oneof=text json"`
+	DevMode               bool
+	DevPort               int `validate:"omitempty,min=1,max=65535"`
 }
 
 var cmdArgs cliArgs
 
+// startupTask is a task which must complete before the application reports ready
+type startupTask struct {
+	name string
+	task func(ctxt context.Context) error
+}
+
 var logTags log.Fields
 
 // @title padlock
@@ -94,7 +118,15 @@ func main() {
 				Aliases:     []string{"c"},
 				EnvVars:     []string{"CONFIG_FILE"},
 				Destination: &cmdArgs.ConfigFile,
-				Required:    true,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name: "config-dir",
+				Usage: "Directory of additional application config files, merged in lexical " +
+					"order after the config file",
+				EnvVars:     []string{"CONFIG_DIR"},
+				Destination: &cmdArgs.ConfigDir,
+				Required:    false,
 			},
 			&cli.StringFlag{
 				Name:        "db-param-file",
@@ -114,6 +146,13 @@ func main() {
 				Destination: &cmdArgs.DBPassword,
 				Required:    false,
 			},
+			&cli.StringFlag{
+				Name:        "db-user-password-file",
+				Usage:       "File containing the database user password. Re-read when it changes.",
+				EnvVars:     []string{"DB_CONNECT_USER_PASSWORD_FILE"},
+				Destination: &cmdArgs.DBPasswordFile,
+				Required:    false,
+			},
 			&cli.StringFlag{
 				Name:        "openid-issuer-param-file",
 				Usage:       "OpenID issuer parameter file",
@@ -122,8 +161,73 @@ func main() {
 				Destination: &cmdArgs.OpenIDIssuerParamFile,
 				Required:    false,
 			},
+			&cli.StringFlag{
+				Name:        "openid-client-cred-file",
+				Usage:       "File containing the OpenID client credential. Re-read when it changes.",
+				EnvVars:     []string{"OPENID_CLIENT_CRED_FILE"},
+				Destination: &cmdArgs.OpenIDClientCredFile,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "redis-password",
+				Usage:       "Redis password, if the token cache is persisted to Redis, or the request quotas are counted in Redis",
+				EnvVars:     []string{"REDIS_PASSWORD"},
+				Value:       "",
+				DefaultText: "",
+				Destination: &cmdArgs.RedisPassword,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "smtp-password",
+				Usage:       "SMTP password, if the account lifecycle notifications are sent by email",
+				EnvVars:     []string{"SMTP_PASSWORD"},
+				Value:       "",
+				DefaultText: "",
+				Destination: &cmdArgs.SMTPPassword,
+				Required:    false,
+			},
+			// Dev mode
+			&cli.BoolFlag{
+				Name: "dev",
+				Usage: "Run all submodules on one port with an in-memory database, permissive " +
+					"authorization rules, and a stub OpenID issuer. For local development only.",
+				EnvVars:     []string{"PADLOCK_DEV"},
+				Value:       false,
+				DefaultText: "false",
+				Destination: &cmdArgs.DevMode,
+				Required:    false,
+			},
+			&cli.IntFlag{
+				Name:        "dev-port",
+				Usage:       "Port to serve on in dev mode; only the loopback interface is bound",
+				EnvVars:     []string{"PADLOCK_DEV_PORT"},
+				Value:       3000,
+				DefaultText: "3000",
+				Destination: &cmdArgs.DevPort,
+				Required:    false,
+			},
+			// CLI command output
+			&cli.StringFlag{
+				Name: "output",
+				Usage: fmt.Sprintf(
+					"Output format of the CLI commands: [%s %s]", outputFormatText, outputFormatJSON,
+				),
+				EnvVars:     []string{"OUTPUT_FORMAT"},
+				Value:       outputFormatText,
+				DefaultText: outputFormatText,
+				Destination: &cmdArgs.OutputFormat,
+				Required:    false,
+			},
 		},
 		Action: mainApplication,
+		Commands: append(
+			backupCommands(),
+			checkCommand(),
+			accessReportCommand(),
+			rulesCommand(),
+			doctorCommand(),
+			usersCommand(),
+		),
 	}
 
 	err = app.Run(os.Args)
@@ -133,131 +237,158 @@ func main() {
 }
 
 func mainApplication(c *cli.Context) error {
-	validate := validator.New()
-	// Validate command line argument
-	if err := validate.Struct(&cmdArgs); err != nil {
-		log.WithError(err).WithFields(logTags).Error("Invalid CMD args")
+	if cmdArgs.DevMode {
+		return devApplication(c)
+	}
+	appCfg, customValidator, validate, err := setupApplication()
+	if err != nil {
 		return err
 	}
 
-	// Setup logging
-	if cmdArgs.JSONLog {
-		log.SetHandler(apexJSON.New(os.Stderr))
-	}
-	switch cmdArgs.LogLevel {
-	case "debug":
-		log.SetLevel(log.DebugLevel)
-	case "info":
-		log.SetLevel(log.InfoLevel)
-	case "warn":
-		log.SetLevel(log.WarnLevel)
-	case "error":
-		log.SetLevel(log.ErrorLevel)
-	default:
-		log.SetLevel(log.ErrorLevel)
+	// Define the database connection DSN if any submodule requires it
+	var dbDSN string
+	if appCfg.UserManagement.Enabled ||
+		appCfg.Authorization.Enabled ||
+		appCfg.CacheInvalidation.Enabled {
+		dbDSN, err = buildDatabaseDSN(validate)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to define DB connection DSN")
+			return err
+		}
 	}
 
-	// Process the config file
-	var appCfg common.AuthorizationServerConfig
-	viper.SetConfigFile(cmdArgs.ConfigFile)
-	if err := viper.ReadInConfig(); err != nil {
-		log.WithError(err).WithFields(logTags).
-			Errorf("Failed to read config file %s", cmdArgs.ConfigFile)
-		return err
-	}
-	if err := viper.Unmarshal(&appCfg); err != nil {
-		log.WithError(err).WithFields(logTags).
-			Errorf("Failed to parse config file %s", cmdArgs.ConfigFile)
-		return err
+	// The database user password file is watched, so new connections use the rotated password
+	var dbPassword common.SecretFile
+	if dbDSN != "" && cmdArgs.DBPasswordFile != "" {
+		dbPassword, err = common.WatchSecretFile(cmdArgs.DBPasswordFile)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to watch DB password file")
+			return err
+		}
+		defer func() {
+			if err := dbPassword.Stop(); err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("Failed to stop watching DB password file")
+			}
+		}()
 	}
-	{
-		t, _ := json.MarshalIndent(&appCfg, "", "  ")
-		log.Debugf("Application Config\n%s", t)
+
+	// Define the cache invalidation bus
+	var invalidateBus invalidation.Bus
+	if appCfg.CacheInvalidation.Enabled {
+		err = connectDatabaseWithRetry(
+			appCfg.Startup.DBConnect, "cache-invalidation-bus-connect", func() error {
+				var err error
+				invalidateBus, err = invalidation.DefinePostgresBus(
+					context.Background(),
+					cmdArgs.Hostname,
+					dbDSN,
+					appCfg.CacheInvalidation.Channel,
+					dbPassword,
+				)
+				return err
+			},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to define cache invalidation bus")
+			return err
+		}
+		defer func() {
+			if err := invalidateBus.Stop(context.Background()); err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("Failed to stop cache invalidation bus")
+			}
+		}()
 	}
-	// Verify the application config is correct
-	if err := appCfg.Validate(); err != nil {
-		log.WithError(err).WithFields(logTags).
-			Errorf("Application config %s is not valid", cmdArgs.ConfigFile)
-		return err
+
+	// The memoized authorization decisions are flushed through the invalidation bus. Without
+	// cross replica cache invalidation, the notices are only delivered within this replica.
+	var decisionCache apis.DecisionCache
+	if appCfg.Authorization.Enabled && appCfg.Authorization.DecisionCache.Enabled {
+		if invalidateBus == nil {
+			invalidateBus = invalidation.DefineLocalBus(cmdArgs.Hostname)
+		}
+		decisionCache = apis.DefineDecisionCache(
+			time.Second*time.Duration(appCfg.Authorization.DecisionCache.TTL),
+			appCfg.Authorization.DecisionCache.MaxEntries,
+		)
+		apis.SubscribeDecisionCacheToInvalidation(decisionCache, invalidateBus)
 	}
 
-	customValidator, err := appCfg.CustomRegex.DefineCustomFieldValidator()
-	if err != nil {
-		log.WithError(err).WithFields(logTags).Errorf("Unable to define custom validator supporter")
-		return err
+	// The servers will not report ready until the startup tasks have completed
+	startupGate := common.DefineReadinessGate()
+	// Health of the enabled submodules and their dependencies, summarized on the metrics server
+	health := apis.DefineHealthRegistry()
+	startupTasks := []startupTask{}
+
+	// Notifications on account lifecycle events
+	var accountNotifier users.AccountNotifier
+	if appCfg.AccountNotifications.Enabled {
+		accountNotifier = users.DefineAccountNotifier(
+			appCfg.AccountNotifications, cmdArgs.SMTPPassword,
+		)
 	}
 
 	var userManager users.Management
 	// Only define user management module if either the
 	//  * user management service
-	//  * user authorization service is enabled
-	if appCfg.UserManagement.Enabled || appCfg.Authorization.Enabled {
-		// Process the database connection parameters
-		var dbParam common.DatabaseConfig
-		{
-			params, err := os.ReadFile(cmdArgs.DBParamFile)
-			if err != nil {
-				log.WithError(err).WithFields(logTags).Errorf("Unable to read %s", cmdArgs.DBParamFile)
-				return err
-			}
-			if err := json.Unmarshal(params, &dbParam); err != nil {
-				log.WithError(err).WithFields(logTags).Errorf("Unable to parse %s", cmdArgs.DBParamFile)
-				return err
-			}
-			if err := validate.Struct(&dbParam); err != nil {
-				log.WithError(err).WithFields(logTags).
-					Errorf("%s content is not valid", cmdArgs.DBParamFile)
-				return err
-			}
-		}
-
-		// Create base DB client
-		dbDSN := fmt.Sprintf(
-			"host=%s user=%s dbname=%s sslmode=disable",
-			dbParam.Host,
-			dbParam.User,
-			dbParam.DB,
+	//  * user authorization service
+	//  * API key authentication is enabled
+	if appCfg.UserManagement.Enabled || appCfg.Authorization.Enabled ||
+		(appCfg.Authentication.Enabled && appCfg.Authentication.APIKey.Enabled) {
+		userManager, err = defineUserManager(
+			dbDSN,
+			appCfg.Startup.DBConnect,
+			customValidator,
+			invalidateBus,
+			dbPassword,
+			&appCfg.UserManagement.PermissionWildcards,
 		)
-		if cmdArgs.DBPassword != "" {
-			dbDSN = fmt.Sprintf(
-				"host=%s user=%s dbname=%s password=%s sslmode=disable",
-				dbParam.Host,
-				dbParam.User,
-				dbParam.DB,
-				cmdArgs.DBPassword,
-			)
-		}
-		baseDBClient, err := gorm.Open(postgres.Open(dbDSN))
-		if err != nil {
-			log.WithError(err).WithFields(logTags).Errorf("Failed to create base DB client")
-			return err
-		}
-		dbClient, err := models.CreateManagementDBClient(baseDBClient, customValidator)
 		if err != nil {
-			log.WithError(err).WithFields(logTags).Errorf("Failed to create DB client")
 			return err
 		}
-
-		// Define user management client
-		userManager, err = users.CreateManagement(dbClient)
-		if err != nil {
-			log.WithError(err).WithFields(logTags).Errorf("Failed to define user management instance")
-			return err
+		if accountNotifier != nil {
+			userManager = users.WithAccountNotifications(
+				userManager, accountNotifier, appCfg.AccountNotifications.SensitiveRoles,
+			)
 		}
+		// Normalize the user IDs before any other handling, so the notifications report the
+		// user IDs as recorded
+		userManager = users.WithUserIDNormalization(userManager, appCfg.UserIDNormalization)
+		health.Register("database", userManager.Ready)
 
 		// Synchronize role configuration
-		err = userManager.AlignRolesWithConfig(
-			context.Background(), appCfg.UserManagement.AvailableRoles,
-		)
-		if err != nil {
-			log.WithError(err).WithFields(logTags).Errorf("Failed to perform initial role config sync")
-			return err
-		}
+		startupTasks = append(startupTasks, startupTask{
+			name: "initial role config sync",
+			task: func(ctxt context.Context) error {
+				return userManager.AlignRolesWithConfig(ctxt, appCfg.UserManagement.AvailableRoles)
+			},
+		})
 	}
 
 	// ------------------------------------------------------------------------------------
 	// Define application servers based on application configuration
 
+	// Listeners passed in through systemd socket activation
+	listeners, err := apis.SystemdListeners()
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to read socket activation listeners")
+		return err
+	}
+	for name := range listeners {
+		switch name {
+		case apis.ServerNameMetrics, apis.ServerNameUserManagement,
+			apis.ServerNameAuthorization, apis.ServerNameAuthentication:
+		default:
+			log.WithFields(logTags).Warnf("Ignoring socket activation listener '%s'", name)
+		}
+	}
+
+	// Close the log sinks last, so they capture the shutdown
+	defer func() {
+		if err := closeLogSinks(); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to close log sinks")
+		}
+	}()
+
 	wg := sync.WaitGroup{}
 	defer wg.Wait()
 	apiServers := map[string]*http.Server{}
@@ -280,191 +411,934 @@ func mainApplication(c *cli.Context) error {
 		}
 	}()
 
-	metrics, err := newMetricsCollector(appCfg.Metrics.Features)
+	stopLogFileRotation, err := startLogFileRotation(appCfg.Logging.File, &wg)
 	if err != nil {
-		log.
-			WithError(err).
-			WithFields(logTags).
-			Error("Failed to create metrics collector")
+		log.WithError(err).WithFields(logTags).Errorf("Unable to start log-file-rotate timer")
 		return err
 	}
-	httpMetricsAgent := metrics.InstallHTTPMetrics()
+	// Stop the log file rotation on exit
+	cleanUpTasks["Stop log-file-rotate timer"] = stopLogFileRotation
 
-	{
-		svr, err := apis.BuildMetricsCollectionServer(
-			appCfg.Metrics.Server, metrics, appCfg.Metrics.MetricsEndpoint, appCfg.Metrics.MaxRequests,
-		)
-		if err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to define metrics HTTP Server")
-			return err
-		}
-		apiServers["Metrics"] = svr
-		// Start the server
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.WithError(err).Error("Metrics HTTP Server Failure")
-			}
-		}()
+	// Export the traces of the handled requests
+	stopTracing, err := common.InstallTracing(context.Background(), appCfg.Tracing)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to start trace export")
+		return err
+	}
+	cleanUpTasks["Stop trace export"] = func() error {
+		ctxt, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		return stopTracing(ctxt)
 	}
 
-	if appCfg.UserManagement.Enabled {
-		svr, err := apis.BuildUserManagementServer(
-			appCfg.UserManagement.APIServerConfig, userManager, customValidator, httpMetricsAgent,
+	// Periodically fetch the role configuration from the remote source
+	if userManager != nil && appCfg.UserManagement.RemoteRoles.Enabled {
+		remoteRoles := appCfg.UserManagement.RemoteRoles
+		roleSource := users.DefineHTTPRoleSource(
+			remoteRoles, &http.Client{Timeout: time.Second * time.Duration(remoteRoles.Timeout)},
 		)
-		if err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to define User Management API HTTP Server")
-			return err
-		}
-		apiServers["User-Management"] = svr
-		// Start the server
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.WithError(err).Error("User Management API HTTP Server Failure")
+		syncRemoteRoles := func(ctxt context.Context) error {
+			changed, err := users.SyncRolesFromSource(
+				ctxt,
+				userManager,
+				roleSource,
+				func(roles map[string]common.UserRoleConfig) error {
+					candidate := appCfg
+					candidate.UserManagement.AvailableRoles = roles
+					return candidate.Validate()
+				},
+			)
+			if err != nil {
+				return err
 			}
-		}()
-	}
-
-	if appCfg.Authorization.Enabled {
-		// Build request matcher
-		matcherSpec, err := match.ConvertConfigToTargetGroupSpec(
-			&appCfg.Authorization.AuthorizationConfig,
+			// The memoized decisions were made with the previous roles
+			if changed && decisionCache != nil {
+				decisionCache.FlushAll(ctxt)
+			}
+			return nil
+		}
+		// The roles of the local config remain in use until the remote source is available
+		startupTasks = append(startupTasks, startupTask{
+			name: "initial remote role sync",
+			task: func(ctxt context.Context) error {
+				if err := syncRemoteRoles(ctxt); err != nil {
+					log.WithError(err).WithFields(logTags).Warn("Using the roles of the local config")
+				}
+				return nil
+			},
+		})
+		roleSyncTimer, err := goutils.GetIntervalTimerInstance(
+			context.Background(), &wg, log.Fields{
+				"module":    "main",
+				"component": "timer",
+				"instance":  "remote-role-sync",
+			},
 		)
 		if err != nil {
-			log.WithError(err).WithFields(logTags).Errorf("Unable to define request matcher spec")
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define remote-role-sync timer")
 			return err
 		}
-		matcher, err := match.DefineTargetGroupMatcher(matcherSpec)
-		if err != nil {
-			log.WithError(err).WithFields(logTags).Errorf("Unable to define request matcher")
+		if err := roleSyncTimer.Start(
+			time.Second*time.Duration(remoteRoles.SyncInterval), func() error {
+				// A failed sync is retried on the next interval
+				_ = syncRemoteRoles(context.Background())
+				return nil
+			}, false,
+		); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to start remote-role-sync timer")
 			return err
 		}
-		svr, err := apis.BuildAuthorizationServer(
-			appCfg.Authorization.APIServerConfig,
-			userManager,
-			matcher,
-			customValidator,
-			appCfg.Authorization.RequestParamLocation,
-			appCfg.Authorization.UnknownUser,
-			httpMetricsAgent,
-		)
-		if err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to define Authorization API HTTP Server")
-			return err
+		// Stop the remote role sync timer on exit
+		cleanUpTasks["Stop remote-role-sync timer"] = func() error {
+			return roleSyncTimer.Stop()
 		}
-		apiServers["Authorization"] = svr
-		// Start the server
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.WithError(err).Error("Authorization API HTTP Server Failure")
-			}
-		}()
 	}
 
-	if appCfg.Authentication.Enabled {
-		if cmdArgs.OpenIDIssuerParamFile == "" {
-			return fmt.Errorf("no OpenID issuer parameter file given")
-		}
-		// Parse OpenID issuer parameter file
-		var oidParam common.OpenIDIssuerConfig
-		params, err := os.ReadFile(cmdArgs.OpenIDIssuerParamFile)
+	// The decisions are recorded by the authorization server, and queried through the user
+	// management server
+	var decisionHistory apis.DecisionHistory
+	historyCfg := appCfg.Authorization.DecisionHistory
+	if historyCfg.Enabled && (appCfg.UserManagement.Enabled || appCfg.Authorization.Enabled) {
+		decisionHistory, err = defineDecisionHistory(
+			dbDSN, appCfg.Startup.DBConnect, dbPassword, historyCfg,
+		)
 		if err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to read %s", cmdArgs.OpenIDIssuerParamFile)
-			return err
-		}
-		if err := json.Unmarshal(params, &oidParam); err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to parse %s", cmdArgs.OpenIDIssuerParamFile)
 			return err
 		}
-		if err := validate.Struct(&oidParam); err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("%s content is not valid", cmdArgs.OpenIDIssuerParamFile)
-			return err
-		}
-		// Token cache in support of introspection
-		tokenCache := authenticate.DefineTokenCache(
-			time.Second * time.Duration(appCfg.Authentication.Introspection.ReIntrospectInterval),
-		)
-		// Timer to clear out expired tokens from the cache
-		expireTokenCleanupTimer, err := goutils.GetIntervalTimerInstance(
+		// Timer to write the queued decisions
+		historyFlushTimer, err := goutils.GetIntervalTimerInstance(
 			context.Background(), &wg, log.Fields{
 				"module":    "main",
 				"component": "timer",
-				"instance":  "expired-token-cleanup",
+				"instance":  "decision-history-flush",
 			},
 		)
 		if err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to define expired-token-cleanup timer")
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define decision-history-flush timer")
 			return err
 		}
-		// Start timer to clear out expired tokens from the cache
-		if err := expireTokenCleanupTimer.Start(time.Second*time.Duration(
-			appCfg.Authentication.Introspection.CacheCleanInterval), func() error {
-			err := tokenCache.RemoveExpiredFromCache(context.Background(), time.Now().UTC())
-			if err != nil {
-				log.WithError(err).WithFields(logTags).Error("Expired token cleanup in cache failed")
-			}
-			return err
-		}, false,
+		if err := historyFlushTimer.Start(
+			time.Second*time.Duration(historyCfg.FlushInterval), func() error {
+				// The decisions are dropped if they can't be written
+				_ = decisionHistory.Flush(context.Background())
+				return nil
+			}, false,
 		); err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to start expired-token-cleanup timer")
+			log.WithError(err).WithFields(logTags).Errorf("Unable to start decision-history-flush timer")
 			return err
 		}
-		// Stop the expired token cleanup timer on exit
-		cleanUpTasks["Stop expired-token-cache-cleanup timer"] = func() error {
-			return expireTokenCleanupTimer.Stop()
+		// Stop the decision history flush timer on exit, and write the remaining decisions
+		cleanUpTasks["Stop decision-history-flush timer"] = func() error {
+			if err := historyFlushTimer.Stop(); err != nil {
+				return err
+			}
+			return decisionHistory.Flush(context.Background())
 		}
-		// Timer to purge token cache
-		tokenCachePurgeTimer, err := goutils.GetIntervalTimerInstance(
+		// Timer to remove the decisions outside the retention window
+		historyPruneTimer, err := goutils.GetIntervalTimerInstance(
 			context.Background(), &wg, log.Fields{
 				"module":    "main",
 				"component": "timer",
-				"instance":  "token-cache-purge",
+				"instance":  "decision-history-prune",
 			},
 		)
 		if err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to define token-cache-purge timer")
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define decision-history-prune timer")
 			return err
 		}
-		// Start timer to purge token cache
-		if err := tokenCachePurgeTimer.Start(time.Second*time.Duration(
-			appCfg.Authentication.Introspection.CachePurgeInterval), func() error {
-			err := tokenCache.RemoveExpiredFromCache(context.Background(), time.Now().UTC())
-			if err != nil {
-				log.WithError(err).WithFields(logTags).Error("Token cache purge failed")
-			}
+		if err := historyPruneTimer.Start(
+			time.Second*time.Duration(historyCfg.PruneInterval), func() error {
+				_, _ = decisionHistory.Prune(context.Background(), time.Now().UTC())
+				return nil
+			}, false,
+		); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to start decision-history-prune timer")
+			return err
+		}
+		// Stop the decision history prune timer on exit
+		cleanUpTasks["Stop decision-history-prune timer"] = func() error {
+			return historyPruneTimer.Stop()
+		}
+	}
+
+	metrics, err := newMetricsCollector(appCfg.Metrics.Features)
+	if err != nil {
+		log.
+			WithError(err).
+			WithFields(logTags).
+			Error("Failed to create metrics collector")
+		return err
+	}
+	// Also ship the metrics to a StatsD server
+	if appCfg.Metrics.StatsD.Enabled {
+		statsdMetrics, err := common.DefineStatsDMetricsCollector(metrics, appCfg.Metrics.StatsD)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Error("Failed to create StatsD metrics collector")
+			return err
+		}
+		metrics = statsdMetrics
+		statsdFlushTimer, err := goutils.GetIntervalTimerInstance(
+			context.Background(), &wg, log.Fields{
+				"module":    "main",
+				"component": "timer",
+				"instance":  "statsd-flush",
+			},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define statsd-flush timer")
+			return err
+		}
+		if err := statsdFlushTimer.Start(
+			time.Second*time.Duration(appCfg.Metrics.StatsD.FlushIntervalSecs), func() error {
+				return statsdMetrics.Flush(context.Background())
+			}, false,
+		); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to start statsd-flush timer")
+			return err
+		}
+		// Stop the flush timer, and ship the final metric values on exit
+		cleanUpTasks["Stop statsd-flush timer"] = func() error {
+			if err := statsdFlushTimer.Stop(); err != nil {
+				return err
+			}
+			return statsdMetrics.Close()
+		}
+	}
+	routeMetrics, err := newRouteMetricsHelper(metrics, appCfg.Metrics.Features)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to create route metrics helper")
+		return err
+	}
+	decisionMetrics, err := newDecisionMetricsHelper(metrics, appCfg.Metrics.Features)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to create decision metrics helper")
+		return err
+	}
+	httpMetricsAgent := metrics.InstallHTTPMetrics()
+
+	// Build information
+	buildInfoMetric, err := metrics.InstallCustomGaugeVecMetrics(
+		context.Background(),
+		"padlock_build_info",
+		"Build information of the running application, with constant value 1",
+		[]string{"version", "git_commit", "go_version"},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define build info metric")
+		return err
+	}
+	common.ExportBuildInfo(buildInfoMetric)
+
+	// Runtime feature toggles
+	toggleMetric, err := metrics.InstallCustomGaugeVecMetrics(
+		context.Background(),
+		"feature_toggle",
+		"Whether a runtime feature toggle is enabled",
+		[]string{"toggle"},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define feature toggle metric")
+		return err
+	}
+	toggles := common.DefineFeatureToggles(common.FeatureToggleState{
+		Introspection: appCfg.Authentication.Introspection.Enabled,
+		AutoAddUser:   appCfg.Authorization.UnknownUser.AutoAdd,
+		DryRun:        false,
+	}, toggleMetric)
+
+	// In-flight authorization and authentication requests are allowed to complete on shutdown
+	inFlightMetrics := common.InFlightMetrics{}
+	inFlightMetrics.InFlight, err = metrics.InstallCustomGaugeVecMetrics(
+		context.Background(),
+		"http_in_flight_requests",
+		"Number of requests currently being processed",
+		[]string{"server"},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define in-flight request metric")
+		return err
+	}
+	inFlightMetrics.Draining, err = metrics.InstallCustomGaugeVecMetrics(
+		context.Background(),
+		"shutdown_draining",
+		"Whether the in-flight requests are being drained for shutdown",
+		[]string{},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define shutdown drain metric")
+		return err
+	}
+	inFlightMetrics.DrainProgress, err = metrics.InstallCustomGaugeVecMetrics(
+		context.Background(),
+		"shutdown_drain_progress_ratio",
+		"Fraction of the requests in-flight at the start of the shutdown drain which have completed",
+		[]string{},
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define shutdown drain metric")
+		return err
+	}
+	inFlight := common.DefineInFlightTracker(&inFlightMetrics)
+
+	{
+		metricsServerCfg := appCfg.Metrics.Server
+		if !metricsServerCfg.TLS.Enabled && appCfg.Metrics.TLS.Enabled {
+			metricsServerCfg.TLS = common.ServerTLSConfig{
+				Enabled:  true,
+				CertFile: appCfg.Metrics.TLS.CertFile,
+				KeyFile:  appCfg.Metrics.TLS.KeyFile,
+			}
+		}
+		svr, err := apis.BuildMetricsCollectionServer(
+			metricsServerCfg,
+			metrics,
+			appCfg.Metrics.MetricsEndpoint,
+			appCfg.Metrics.MaxRequests,
+			appCfg.Metrics.Auth,
+			health,
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Unable to define metrics HTTP Server")
+			return err
+		}
+		apiServers["Metrics"] = svr
+		// Start the server
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := serveHTTP(svr, listeners[apis.ServerNameMetrics])
+			if err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Error("Metrics HTTP Server Failure")
+			}
+		}()
+	}
+
+	// The OpenID issuer verifies the tokens of the authentication server, and of the user
+	// management API admins
+	var oidParams []common.OpenIDIssuerConfig
+	var oidClient authenticate.DeferredOpenIDIssuerClient
+	if appCfg.Authentication.Enabled ||
+		(appCfg.UserManagement.Enabled && appCfg.UserManagement.AdminAuth.Enabled) {
+		oidParams, err = readOpenIDIssuerParams(validate)
+		if err != nil {
+			return err
+		}
+		// Users of different issuers with the same local part become the same user
+		if norm := appCfg.UserIDNormalization; norm.StripEmailDomain &&
+			len(norm.EmailDomains) == 0 && len(oidParams) > 1 {
+			log.WithFields(logTags).Warnf(
+				"Removing the email domain of the user IDs of all %d OpenID issuers. "+
+					"Set userIDNormalization.emailDomains to the domains to remove.",
+				len(oidParams),
+			)
+		}
+		// OpenID issuer discovery is performed as a startup task
+		oidClient = authenticate.DefineDeferredOpenIDClient()
+		startupTasks = append(startupTasks, startupTask{
+			name: "OpenID issuer discovery",
+			task: func(ctxt context.Context) error {
+				client, err := defineOpenIDIssuersClient(oidParams)
+				if err != nil {
+					return err
+				}
+				oidClient.SetClient(client)
+				return nil
+			},
+		})
+	}
+
+	// The token introspection shared by the authentication server, and the user management API
+	// admin authentication
+	var introspector authenticate.Introspector
+	// If provided, the authentication server is only ready while the OpenID issuer is healthy
+	var issuerReadiness authenticate.IssuerHealthStatus
+	// Verifies the bearer tokens of the user management API admins
+	var adminTokens apis.BearerTokenVerifier
+	if oidClient != nil {
+		// Token cache in support of introspection
+		tokenCacheEvictMetric, err := metrics.InstallCustomCounterVecMetrics(
+			context.Background(),
+			"token_cache_evicted_total",
+			"Number of token cache entries evicted to stay within the maximum entry count",
+			[]string{"issuer", "kind"},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define token cache eviction metric")
+			return err
+		}
+		tokenCacheLookupMetric, err := metrics.InstallCustomCounterVecMetrics(
+			context.Background(),
+			"token_cache_lookups_total",
+			"Number of token cache lookups by OpenID issuer and result",
+			[]string{"issuer", "result"},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define token cache lookup metric")
+			return err
+		}
+		tokenCache, err := defineTokenCache(
+			appCfg.Authentication.Introspection, oidParams, tokenCacheEvictMetric, tokenCacheLookupMetric,
+		)
+		if err != nil {
+			return err
+		}
+		if invalidateBus != nil {
+			authenticate.SubscribeTokenCacheToInvalidation(tokenCache, invalidateBus)
+		}
+		// Warm the token cache from, and periodically persist it to, the token cache store
+		if appCfg.Authentication.Introspection.Persist.Enabled &&
+			appCfg.Authentication.Introspection.Cache.Backend != common.TokenCacheBackendMemory {
+			log.WithFields(logTags).Warnf(
+				"Token cache kept in %s, which is not persisted",
+				appCfg.Authentication.Introspection.Cache.Backend,
+			)
+		} else if appCfg.Authentication.Introspection.Persist.Enabled {
+			persistCfg := appCfg.Authentication.Introspection.Persist
+			store, closeStore, err := defineTokenCacheStore(persistCfg)
+			if err != nil {
+				return err
+			}
+			// A cold token cache only costs extra introspections
+			if _, err := authenticate.WarmTokenCache(
+				context.Background(), tokenCache, store, time.Now().UTC(),
+			); err != nil {
+				log.WithError(err).WithFields(logTags).Warn("Starting with a cold token cache")
+			}
+			tokenCachePersistTimer, err := goutils.GetIntervalTimerInstance(
+				context.Background(), &wg, log.Fields{
+					"module":    "main",
+					"component": "timer",
+					"instance":  "token-cache-persist",
+				},
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to define token-cache-persist timer")
+				return err
+			}
+			if err := tokenCachePersistTimer.Start(
+				time.Second*time.Duration(persistCfg.SaveInterval), func() error {
+					_ = authenticate.PersistTokenCache(
+						context.Background(), tokenCache, store, time.Now().UTC(),
+					)
+					return nil
+				}, false,
+			); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to start token-cache-persist timer")
+				return err
+			}
+			// Stop the persist timer, and persist the token cache a final time on exit
+			cleanUpTasks["Persist token cache"] = func() error {
+				if err := tokenCachePersistTimer.Stop(); err != nil {
+					return err
+				}
+				err := authenticate.PersistTokenCache(
+					context.Background(), tokenCache, store, time.Now().UTC(),
+				)
+				if closeErr := closeStore(); err == nil {
+					err = closeErr
+				}
+				return err
+			}
+		}
+		// Timer to clear out expired tokens from the cache
+		expireTokenCleanupTimer, err := goutils.GetIntervalTimerInstance(
+			context.Background(), &wg, log.Fields{
+				"module":    "main",
+				"component": "timer",
+				"instance":  "expired-token-cleanup",
+			},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Unable to define expired-token-cleanup timer")
+			return err
+		}
+		// Start timer to clear out expired tokens from the cache
+		if err := expireTokenCleanupTimer.Start(time.Second*time.Duration(
+			appCfg.Authentication.Introspection.CacheCleanInterval), func() error {
+			err := tokenCache.RemoveExpiredFromCache(context.Background(), time.Now().UTC())
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Error("Expired token cleanup in cache failed")
+			}
+			return err
+		}, false,
+		); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Unable to start expired-token-cleanup timer")
+			return err
+		}
+		// Stop the expired token cleanup timer on exit
+		cleanUpTasks["Stop expired-token-cache-cleanup timer"] = func() error {
+			return expireTokenCleanupTimer.Stop()
+		}
+		// Timer to purge token cache
+		tokenCachePurgeTimer, err := goutils.GetIntervalTimerInstance(
+			context.Background(), &wg, log.Fields{
+				"module":    "main",
+				"component": "timer",
+				"instance":  "token-cache-purge",
+			},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Unable to define token-cache-purge timer")
+			return err
+		}
+		// Start timer to purge token cache
+		if err := tokenCachePurgeTimer.Start(time.Second*time.Duration(
+			appCfg.Authentication.Introspection.CachePurgeInterval), func() error {
+			err := tokenCache.RemoveExpiredFromCache(context.Background(), time.Now().UTC())
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Error("Token cache purge failed")
+			}
 			return err
 		}, false,
 		); err != nil {
-			log.WithError(err).WithFields(logTags).
-				Errorf("Unable to start token-cache-purge timer")
+			log.WithError(err).WithFields(logTags).
+				Errorf("Unable to start token-cache-purge timer")
+			return err
+		}
+		// Stop the token cache purge timer on exit
+		cleanUpTasks["Stop token-cache-purge timer"] = func() error {
+			return tokenCachePurgeTimer.Stop()
+		}
+		// OpenID issuer health watchdog
+		var issuerHealth authenticate.IssuerHealthStatus
+		if appCfg.Authentication.IssuerHealth.Enabled {
+			issuerUpMetric, err := metrics.InstallCustomGaugeVecMetrics(
+				context.Background(),
+				"oidc_issuer_up",
+				"Whether the OpenID issuer is reachable",
+				[]string{"issuer"},
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("Unable to define OpenID issuer metric")
+				return err
+			}
+			watchdog := authenticate.DefineIssuerWatchdog(
+				openIDIssuerNames(oidParams), oidClient.ProbeEndpoints, issuerUpMetric,
+			)
+			health.Register("oidc-issuer", func() error {
+				if !watchdog.IssuerHealthy() {
+					return fmt.Errorf("OpenID issuer %s is unreachable", openIDIssuerNames(oidParams))
+				}
+				return nil
+			})
+			if appCfg.Authentication.IssuerHealth.DegradedMode ==
+				common.IssuerDegradedModeCachedTokensOnly {
+				issuerHealth = watchdog
+			}
+			if appCfg.Authentication.IssuerHealth.GateReadiness {
+				// Probe the issuer once, so the server is not ready before the first check
+				_ = watchdog.CheckHealth(context.Background())
+				issuerReadiness = watchdog
+			}
+			// Timer to periodically check the OpenID issuer
+			issuerWatchdogTimer, err := goutils.GetIntervalTimerInstance(
+				context.Background(), &wg, log.Fields{
+					"module":    "main",
+					"component": "timer",
+					"instance":  "issuer-watchdog",
+				},
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to define issuer-watchdog timer")
+				return err
+			}
+			if err := issuerWatchdogTimer.Start(time.Second*time.Duration(
+				appCfg.Authentication.IssuerHealth.CheckInterval), func() error {
+				_ = watchdog.CheckHealth(context.Background())
+				return nil
+			}, false,
+			); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to start issuer-watchdog timer")
+				return err
+			}
+			// Stop the issuer watchdog timer on exit
+			cleanUpTasks["Stop issuer-watchdog timer"] = func() error {
+				return issuerWatchdogTimer.Stop()
+			}
+		}
+		introspector = authenticate.DefineIntrospector(
+			tokenCache,
+			oidClient.IntrospectToken,
+			invalidateBus,
+			issuerHealth,
+			appCfg.Authentication.Introspection.StaleWhileRevalidate,
+		)
+		// Re-introspect cached tokens before they are due for re-validation
+		if appCfg.Authentication.Introspection.Enabled &&
+			appCfg.Authentication.Introspection.ProactiveRecheckInterval > 0 {
+			proactiveRecheckInt := time.Second * time.Duration(
+				appCfg.Authentication.Introspection.ProactiveRecheckInterval,
+			)
+			tokenRecheckTimer, err := goutils.GetIntervalTimerInstance(
+				context.Background(), &wg, log.Fields{
+					"module":    "main",
+					"component": "timer",
+					"instance":  "token-recheck",
+				},
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("Unable to define token-recheck timer")
+				return err
+			}
+			if err := tokenRecheckTimer.Start(proactiveRecheckInt, func() error {
+				// The OpenID issuer is not known until startup completes
+				if startupGate.Ready() != nil || !toggles.CurrentState().Introspection {
+					return nil
+				}
+				introspector.RevalidateCachedTokens(
+					context.Background(), proactiveRecheckInt, time.Now().UTC(),
+				)
+				return nil
+			}, false,
+			); err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("Unable to start token-recheck timer")
+				return err
+			}
+			// Stop the token recheck timer on exit
+			cleanUpTasks["Stop token-recheck timer"] = func() error {
+				return tokenRecheckTimer.Stop()
+			}
+		}
+		adminTokens, err = apis.DefineBearerTokenVerifier(
+			appCfg.UserManagement.APIs.RequestLogging,
+			oidClient,
+			appCfg.Authentication.Introspection.Enabled,
+			introspector,
+			appCfg.Authentication.AuthenticationConfig,
+			toggles,
+			oidParams,
+			appCfg.UserIDNormalization,
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define bearer token verifier")
+			return err
+		}
+	}
+
+	if appCfg.UserManagement.Enabled {
+		health.Register(apis.ServerNameUserManagement, startupGate.Ready)
+		svr, err := apis.BuildUserManagementServer(
+			appCfg.UserManagement.APIServerConfig,
+			userManager,
+			customValidator,
+			httpMetricsAgent,
+			routeMetrics,
+			startupGate,
+			toggles,
+			invalidateBus,
+			decisionHistory,
+			appCfg.UserManagement.AdminAuth,
+			adminTokens,
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Unable to define User Management API HTTP Server")
+			return err
+		}
+		apiServers["User-Management"] = svr
+		// Start the server
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serveHTTP(svr, listeners[apis.ServerNameUserManagement]); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Error("User Management API HTTP Server Failure")
+			}
+		}()
+		// Serve the user management API over gRPC as well
+		if appCfg.UserManagement.GRPC.Enabled {
+			grpcCfg := appCfg.UserManagement.GRPC
+			service, err := apis.DefineManagementGRPCServer(userManager, customValidator)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to define User Management gRPC service")
+				return err
+			}
+			grpcSvr, err := apis.BuildManagementGRPCServer(
+				service, grpcCfg.TLS, appCfg.UserManagement.AdminAuth, adminTokens, userManager,
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to define User Management gRPC server")
+				return err
+			}
+			listener, err := net.Listen(
+				"tcp", fmt.Sprintf("%s:%d", grpcCfg.ListenOn, grpcCfg.Port),
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to listen for User Management gRPC calls")
+				return err
+			}
+			cleanUpTasks["Stop User Management gRPC server"] = func() error {
+				grpcSvr.GracefulStop()
+				return nil
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := grpcSvr.Serve(listener); err != nil {
+					log.WithError(err).Error("User Management gRPC Server Failure")
+				}
+			}()
+		}
+	}
+
+	// The authorization server handler, with which the authentication server answers the
+	// combined authentication and authorization checks
+	var authorizationHandler http.Handler
+	// The request matcher rebuilt as the authorization rules custom resources change
+	var policyMatcher match.SwappableMatcher
+	if appCfg.Authorization.Enabled {
+		health.Register(apis.ServerNameAuthorization, startupGate.Ready)
+		// Build request matcher
+		matcher, err := defineRequestMatcher(&appCfg.Authorization.AuthorizationConfig)
+		if err != nil {
+			return err
+		}
+		if appCfg.KubernetesPolicy.Enabled {
+			policyMatcher = match.DefineSwappableMatcher(matcher)
+			matcher = policyMatcher
+		}
+		autoAddMetric, err := metrics.InstallCustomCounterVecMetrics(
+			context.Background(),
+			"authorization_auto_added_user_total",
+			"Number of unknown users automatically recorded during authorization",
+			[]string{"host"},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define auto-added user metric")
+			return err
+		}
+		autoAddObserver := apis.DefineAutoAddedUserObserver(
+			autoAddMetric, appCfg.Authorization.UnknownUser.Alert, accountNotifier,
+		)
+		var quotas apis.QuotaEnforcer
+		if appCfg.Authorization.Quota.Enabled {
+			counter, closeCounter, err := defineQuotaCounter(appCfg.Authorization.Quota)
+			if err != nil {
+				return err
+			}
+			cleanUpTasks["Close quota counter"] = closeCounter
+			quotas = apis.DefineQuotaEnforcer(counter, appCfg.Authorization.Quota.Rules)
+		}
+		var shedder apis.LoadShedder
+		if appCfg.Authorization.LoadShedding.Enabled {
+			shedder, err = apis.DefineLoadShedder(appCfg.Authorization.LoadShedding)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("Unable to define load shedder")
+				return err
+			}
+		}
+		svr, err := apis.BuildAuthorizationServer(
+			appCfg.Authorization.APIServerConfig,
+			userManager,
+			matcher,
+			customValidator,
+			appCfg.Authorization.RequestParamLocation,
+			appCfg.Authorization.UnknownUser,
+			appCfg.Authorization.Response,
+			httpMetricsAgent,
+			startupGate,
+			inFlight,
+			apis.AuthorizationOptions{
+				AutoAddObserver: autoAddObserver,
+				RouteMetrics:    routeMetrics,
+				DecisionMetrics: decisionMetrics,
+				Toggles:         toggles,
+				Decisions:       decisionCache,
+				WebSocket:       appCfg.Authorization.WebSocket,
+				Impersonation:   appCfg.Authorization.Impersonation,
+				Quotas:          quotas,
+				FailureResponse: appCfg.Authorization.FailureResponse,
+				History:         decisionHistory,
+				UserIDs:         appCfg.UserIDNormalization,
+				Bypass:          appCfg.Authorization.Bypass,
+				Shedder:         shedder,
+			},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Unable to define Authorization API HTTP Server")
+			return err
+		}
+		if decisionCache != nil {
+			// Timer to remove the expired decisions
+			decisionPurgeTimer, err := goutils.GetIntervalTimerInstance(
+				context.Background(), &wg, log.Fields{
+					"module":    "main",
+					"component": "timer",
+					"instance":  "decision-cache-purge",
+				},
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to define decision-cache-purge timer")
+				return err
+			}
+			if err := decisionPurgeTimer.Start(time.Second*time.Duration(
+				appCfg.Authorization.DecisionCache.TTL), func() error {
+				decisionCache.RemoveExpired(context.Background(), time.Now().UTC())
+				return nil
+			}, false,
+			); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to start decision-cache-purge timer")
+				return err
+			}
+			// Stop the decision cache purge timer on exit
+			cleanUpTasks["Stop decision-cache-purge timer"] = func() error {
+				return decisionPurgeTimer.Stop()
+			}
+		}
+		apiServers["Authorization"] = svr
+		authorizationHandler = svr.Handler
+		// Start the server
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serveHTTP(svr, listeners[apis.ServerNameAuthorization]); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Error("Authorization API HTTP Server Failure")
+			}
+		}()
+		// Answer the Envoy "ext_authz" checks with the authorization server
+		if appCfg.Authorization.ExtAuthz.Enabled {
+			extAuthzCfg := appCfg.Authorization.ExtAuthz
+			extAuthzSvr := apis.BuildExtAuthzServer(apis.DefineExtAuthzServer(
+				svr.Handler,
+				appCfg.Authorization.APIs.Endpoint.PathPrefix,
+				appCfg.Authorization.RequestParamLocation,
+			))
+			listener, err := net.Listen(
+				"tcp", fmt.Sprintf("%s:%d", extAuthzCfg.ListenOn, extAuthzCfg.Port),
+			)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("Unable to listen for ext_authz checks")
+				return err
+			}
+			cleanUpTasks["Stop ext_authz gRPC server"] = func() error {
+				extAuthzSvr.GracefulStop()
+				return nil
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := extAuthzSvr.Serve(listener); err != nil {
+					log.WithError(err).Error("ext_authz gRPC Server Failure")
+				}
+			}()
+		}
+	}
+
+	// Periodically read the policy from the Kubernetes custom resources
+	if appCfg.KubernetesPolicy.Enabled {
+		policySource, err := operator.DefineKubernetesPolicySource(appCfg.KubernetesPolicy)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define Kubernetes policy source")
+			return err
+		}
+		var applyRules func([]common.HostAuthorizationConfig) error
+		if policyMatcher != nil {
+			applyRules = func(rules []common.HostAuthorizationConfig) error {
+				authzCfg := appCfg.Authorization.AuthorizationConfig
+				authzCfg.Rules = rules
+				matcher, err := defineRequestMatcher(&authzCfg)
+				if err != nil {
+					return err
+				}
+				policyMatcher.Swap(matcher)
+				return nil
+			}
+		}
+		policyController := operator.DefinePolicyController(
+			policySource,
+			userManager,
+			operator.PolicyResources{
+				Roles: appCfg.UserManagement.AvailableRoles, Rules: appCfg.Authorization.Rules,
+			},
+			func(policy operator.PolicyResources) error {
+				candidate := appCfg
+				candidate.UserManagement.AvailableRoles = policy.Roles
+				candidate.Authorization.Rules = policy.Rules
+				return candidate.Validate()
+			},
+			applyRules,
+		)
+		syncPolicy := func(ctxt context.Context) error {
+			changed, err := policyController.Sync(ctxt)
+			if err != nil {
+				return err
+			}
+			// The memoized decisions were made with the previous policy
+			if changed && decisionCache != nil {
+				decisionCache.FlushAll(ctxt)
+			}
+			return nil
+		}
+		// The policy of the local config remains in use until the API server is available
+		startupTasks = append(startupTasks, startupTask{
+			name: "initial Kubernetes policy sync",
+			task: func(ctxt context.Context) error {
+				if err := syncPolicy(ctxt); err != nil {
+					log.WithError(err).WithFields(logTags).Warn("Using the policy of the local config")
+				}
+				return nil
+			},
+		})
+		policySyncTimer, err := goutils.GetIntervalTimerInstance(
+			context.Background(), &wg, log.Fields{
+				"module":    "main",
+				"component": "timer",
+				"instance":  "kubernetes-policy-sync",
+			},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define kubernetes-policy-sync timer")
+			return err
+		}
+		if err := policySyncTimer.Start(
+			time.Second*time.Duration(appCfg.KubernetesPolicy.SyncInterval), func() error {
+				// A failed sync is retried on the next interval
+				_ = syncPolicy(context.Background())
+				return nil
+			}, false,
+		); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to start kubernetes-policy-sync timer")
 			return err
 		}
-		// Stop the token cache purge timer on exit
-		cleanUpTasks["Stop token-cache-purge timer"] = func() error {
-			return tokenCachePurgeTimer.Stop()
+		// Stop the Kubernetes policy sync timer on exit
+		cleanUpTasks["Stop kubernetes-policy-sync timer"] = func() error {
+			return policySyncTimer.Stop()
 		}
+	}
+
+	if appCfg.Authentication.Enabled {
+		health.Register(apis.ServerNameAuthentication, startupGate.Ready)
 		svr, err := apis.BuildAuthenticationServer(
 			appCfg.Authentication.APIServerConfig,
-			oidParam,
+			oidClient,
 			appCfg.Authentication.Introspection.Enabled,
-			tokenCache,
+			introspector,
 			appCfg.Authentication.AuthenticationConfig,
 			appCfg.Authorization.RequestParamLocation,
 			httpMetricsAgent,
+			routeMetrics,
+			decisionMetrics,
+			startupGate,
+			issuerReadiness,
+			inFlight,
+			toggles,
+			oidParams,
+			userManager,
+			authorizationHandler,
+			appCfg.Authorization.APIs.Endpoint.PathPrefix,
 		)
 		if err != nil {
 			log.WithError(err).WithFields(logTags).
@@ -476,24 +1350,644 @@ func mainApplication(c *cli.Context) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			if err := serveHTTP(svr, listeners[apis.ServerNameAuthentication]); err != nil && err != http.ErrServerClosed {
 				log.WithError(err).Error("Authentication API HTTP Server Failure")
 			}
 		}()
 	}
 
+	// Answer queries over NATS with the submodule servers
+	if appCfg.NATSResponder.Enabled {
+		targets := map[string]apis.NATSQueryTarget{}
+		if svr, ok := apiServers["Authorization"]; ok {
+			targets[apis.NATSQueryAuthorize] = apis.DefineNATSQueryTarget(
+				svr.Handler, appCfg.Authorization.APIs.Endpoint.PathPrefix, "/v1/allow",
+			)
+		}
+		if svr, ok := apiServers["Authentication"]; ok {
+			targets[apis.NATSQueryAuthenticate] = apis.DefineNATSQueryTarget(
+				svr.Handler, appCfg.Authentication.APIs.Endpoint.PathPrefix, "/v1/authenticate",
+			)
+		}
+		responder, err := apis.DefineNATSResponder(appCfg.NATSResponder, targets)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define NATS responder")
+			return err
+		}
+		cleanUpTasks["Stop NATS responder"] = func() error {
+			ctxt, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			return responder.Stop(ctxt)
+		}
+	}
+
+	// ------------------------------------------------------------------------------------
+	// Perform the startup tasks, and report ready once they complete
+
+	startupCtxt, stopStartup := context.WithCancel(context.Background())
+	defer stopStartup()
+	startupErr := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, oneTask := range startupTasks {
+			theTask := oneTask
+			if err := common.RetryWithBackoff(
+				startupCtxt, appCfg.Startup.Retry, logTags, theTask.name, func() error {
+					return theTask.task(startupCtxt)
+				},
+			); err != nil {
+				startupErr <- err
+				return
+			}
+			log.WithFields(logTags).Infof("Startup task '%s' complete", theTask.name)
+		}
+		startupGate.MarkReady()
+		log.WithFields(logTags).Info("Startup complete")
+	}()
+
 	// ------------------------------------------------------------------------------------
 	// Wait for termination
 
 	cc := make(chan os.Signal, 1)
-	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
-	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
-	signal.Notify(cc, os.Interrupt)
-	<-cc
+	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C) or SIGTERM
+	// SIGKILL or SIGQUIT (Ctrl+/) will not be caught.
+	signal.Notify(cc, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-cc:
+	case err := <-startupErr:
+		log.WithError(err).WithFields(logTags).Error("Startup failed")
+		return err
+	}
+
+	// Stop reporting ready, and allow the in-flight requests to complete
+	startupGate.MarkDraining()
+	log.WithFields(logTags).Infof("Draining %d in-flight requests", inFlight.InFlight())
+	drainCtxt, drainCancel := context.WithTimeout(
+		context.Background(), time.Second*time.Duration(appCfg.Shutdown.DrainTimeout),
+	)
+	defer drainCancel()
+	if err := inFlight.WaitForDrain(drainCtxt); err != nil {
+		log.WithError(err).WithFields(logTags).
+			Warnf("Shutting down with %d requests still in-flight", inFlight.InFlight())
+	}
 
 	return nil
 }
 
+/*
+setupApplication validate the command line arguments, setup logging, and parse the
+application config file
+
+	@return the application config, the custom field validator, and the general validator
+*/
+func setupApplication() (
+	common.AuthorizationServerConfig, common.CustomFieldValidator, *validator.Validate, error,
+) {
+	validate := validator.New()
+	// Validate command line argument
+	if err := validate.Struct(&cmdArgs); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Invalid CMD args")
+		return common.AuthorizationServerConfig{}, nil, nil, err
+	}
+
+	// Setup logging
+	if cmdArgs.JSONLog {
+		log.SetHandler(apexJSON.New(os.Stderr))
+	}
+	switch cmdArgs.LogLevel {
+	case "debug":
+		log.SetLevel(log.DebugLevel)
+	case "info":
+		log.SetLevel(log.InfoLevel)
+	case "warn":
+		log.SetLevel(log.WarnLevel)
+	case "error":
+		log.SetLevel(log.ErrorLevel)
+	default:
+		log.SetLevel(log.ErrorLevel)
+	}
+
+	// Process the config file, or the built-in config in dev mode
+	var appCfg common.AuthorizationServerConfig
+	if cmdArgs.DevMode {
+		if cmdArgs.ConfigFile != "" {
+			log.WithFields(logTags).
+				Warnf("Ignoring config file %s in dev mode", cmdArgs.ConfigFile)
+		}
+		if cmdArgs.ConfigDir != "" {
+			log.WithFields(logTags).
+				Warnf("Ignoring config directory %s in dev mode", cmdArgs.ConfigDir)
+		}
+		cmdArgs.ConfigFile = "<dev mode>"
+		viper.SetConfigType("yaml")
+		if err := viper.ReadConfig(strings.NewReader(devModeConfig)); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to read dev mode config")
+			return common.AuthorizationServerConfig{}, nil, nil, err
+		}
+	} else {
+		configFiles := []string{}
+		if cmdArgs.ConfigFile != "" {
+			configFiles = append(configFiles, cmdArgs.ConfigFile)
+		}
+		if cmdArgs.ConfigDir != "" {
+			dirFiles, err := common.ListConfigDir(cmdArgs.ConfigDir)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Failed to list config directory %s", cmdArgs.ConfigDir)
+				return common.AuthorizationServerConfig{}, nil, nil, err
+			}
+			configFiles = append(configFiles, dirFiles...)
+		}
+		if len(configFiles) == 0 {
+			err := fmt.Errorf("no config file given")
+			log.WithError(err).WithFields(logTags).Error("Invalid CMD args")
+			return common.AuthorizationServerConfig{}, nil, nil, err
+		}
+		if err := common.ReadConfigFiles(configFiles); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to read config files %s", strings.Join(configFiles, ", "))
+			return common.AuthorizationServerConfig{}, nil, nil, err
+		}
+		// Name the config directory in the later messages, if there is no config file
+		if cmdArgs.ConfigFile == "" {
+			cmdArgs.ConfigFile = cmdArgs.ConfigDir
+		}
+	}
+	if err := common.InstallProxyPresetConfigValues(); err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Failed to apply proxy preset of config file %s", cmdArgs.ConfigFile)
+		return common.AuthorizationServerConfig{}, nil, nil, err
+	}
+	if err := viper.Unmarshal(&appCfg); err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Failed to parse config file %s", cmdArgs.ConfigFile)
+		return common.AuthorizationServerConfig{}, nil, nil, err
+	}
+	{
+		t, _ := json.MarshalIndent(&appCfg, "", "  ")
+		log.Debugf("Application Config\n%s", t)
+	}
+	// Verify the application config is correct
+	if err := appCfg.Validate(); err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Application config %s is not valid", cmdArgs.ConfigFile)
+		return common.AuthorizationServerConfig{}, nil, nil, err
+	}
+
+	// Add the log sinks used in addition to stderr
+	if err := setupLogSinks(appCfg.Logging); err != nil {
+		return common.AuthorizationServerConfig{}, nil, nil, err
+	}
+
+	customValidator, err := appCfg.CustomRegex.DefineCustomFieldValidator()
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define custom validator supporter")
+		return common.AuthorizationServerConfig{}, nil, nil, err
+	}
+
+	return appCfg, customValidator, validate, nil
+}
+
+/*
+defineUserManager define the user management module backed by the database
+
+	@param dbDSN string - the database connection DSN
+	@param connectCfg common.DBConnectConfig - the database connection retry config
+	@param customValidator common.CustomFieldValidator - custom field validator
+	@param invalidateBus invalidation.Bus - if provided, the cache invalidation bus
+	@param dbPassword common.SecretFile - if provided, the database user password, in place of
+	the one in the DSN. It is read on each new connection, so the password can be rotated.
+	@param wildcards *common.PermissionWildcardConfig - how a permission held by a user may
+	satisfy other permissions
+	@return the user manager
+*/
+func defineUserManager(
+	dbDSN string,
+	connectCfg common.DBConnectConfig,
+	customValidator common.CustomFieldValidator,
+	invalidateBus invalidation.Bus,
+	dbPassword common.SecretFile,
+	wildcards *common.PermissionWildcardConfig,
+) (users.Management, error) {
+	var baseDBClient *gorm.DB
+	err := connectDatabaseWithRetry(connectCfg, "db-connect", func() error {
+		var err error
+		baseDBClient, err = openDatabase(dbDSN, dbPassword)
+		return err
+	})
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to create base DB client")
+		return nil, err
+	}
+	dbClient, err := models.CreateManagementDBClient(baseDBClient, customValidator)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to create DB client")
+		return nil, err
+	}
+	// Define user management client
+	userManager, err := users.CreateManagement(dbClient, invalidateBus, wildcards)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to define user management instance")
+		return nil, err
+	}
+	return userManager, nil
+}
+
+/*
+defineDecisionHistory define the authorization decision history
+
+	@param dbDSN string - the database connection DSN
+	@param connectCfg common.DBConnectConfig - the database connection retry config
+	@param dbPassword common.SecretFile - if provided, the database user password
+	@param historyCfg common.DecisionHistoryConfig - the decision history config
+	@return the decision history
+*/
+func defineDecisionHistory(
+	dbDSN string,
+	connectCfg common.DBConnectConfig,
+	dbPassword common.SecretFile,
+	historyCfg common.DecisionHistoryConfig,
+) (apis.DecisionHistory, error) {
+	var baseDBClient *gorm.DB
+	err := connectDatabaseWithRetry(connectCfg, "decision-history-db-connect", func() error {
+		var err error
+		baseDBClient, err = openDatabase(dbDSN, dbPassword)
+		return err
+	})
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to create base DB client")
+		return nil, err
+	}
+	dbClient, err := models.CreateDecisionHistoryDBClient(baseDBClient)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to create decision history DB client")
+		return nil, err
+	}
+	return apis.DefineDecisionHistory(
+		dbClient,
+		time.Hour*time.Duration(historyCfg.Retention),
+		historyCfg.MaxEntries,
+		historyCfg.MaxPending,
+	), nil
+}
+
+/*
+defineRequestMatcher define the request matcher of the selected authorization engine
+
+	@param authzCfg *common.AuthorizationConfig - the authorize config section
+	@return the request matcher
+*/
+func defineRequestMatcher(authzCfg *common.AuthorizationConfig) (match.RequestMatch, error) {
+	if authzCfg.Engine == common.AuthorizeEngineRego {
+		policySpec, err := match.ConvertConfigToRegoPolicySpec(authzCfg)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to read Rego policy")
+			return nil, err
+		}
+		matcher, err := match.DefineRegoPolicyMatcher(policySpec)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to define Rego request matcher")
+			return nil, err
+		}
+		return matcher, nil
+	}
+	matcherSpec, err := match.ConvertConfigToTargetGroupSpec(authzCfg)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define request matcher spec")
+		return nil, err
+	}
+	matcher, err := match.DefineTargetGroupMatcher(matcherSpec)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to define request matcher")
+		return nil, err
+	}
+	return matcher, nil
+}
+
+/*
+openDatabase open the database connection pool
+
+	@param dbDSN string - the database connection DSN
+	@param dbPassword common.SecretFile - if provided, the database user password, in place of
+	the one in the DSN
+	@return the database client
+*/
+func openDatabase(dbDSN string, dbPassword common.SecretFile) (*gorm.DB, error) {
+	if dbPassword == nil {
+		return gorm.Open(postgres.Open(dbDSN))
+	}
+	connCfg, err := pgx.ParseConfig(dbDSN)
+	if err != nil {
+		return nil, err
+	}
+	// Read the current password whenever the pool opens a new connection
+	sqlDB := stdlib.OpenDB(*connCfg, stdlib.OptionBeforeConnect(
+		func(ctxt context.Context, cfg *pgx.ConnConfig) error {
+			cfg.Password = dbPassword.Value()
+			return nil
+		},
+	))
+	dbClient, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}))
+	if err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	return dbClient, nil
+}
+
+/*
+connectDatabaseWithRetry run a database connection task, retrying with backoff until it
+succeeds, the retry attempts are exhausted, or the max wait has elapsed
+
+	@param connectCfg common.DBConnectConfig - the database connection retry config
+	@param taskName string - name of the connection task for logging
+	@param connect func() error - the connection task
+	@return whether successful
+*/
+func connectDatabaseWithRetry(
+	connectCfg common.DBConnectConfig, taskName string, connect func() error,
+) error {
+	ctx := context.Background()
+	if connectCfg.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*time.Duration(connectCfg.MaxWait))
+		defer cancel()
+	}
+	return common.RetryWithBackoff(ctx, connectCfg.Retry, logTags, taskName, connect)
+}
+
+/*
+buildDatabaseDSN read the database connection parameter file, and define the connection DSN
+
+	@param validate *validator.Validate - validator for the parameters
+	@return the DSN
+*/
+func buildDatabaseDSN(validate *validator.Validate) (string, error) {
+	// Process the database connection parameters
+	var dbParam common.DatabaseConfig
+	params, err := os.ReadFile(cmdArgs.DBParamFile)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to read %s", cmdArgs.DBParamFile)
+		return "", err
+	}
+	if err := json.Unmarshal(params, &dbParam); err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to parse %s", cmdArgs.DBParamFile)
+		return "", err
+	}
+	if err := validate.Struct(&dbParam); err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("%s content is not valid", cmdArgs.DBParamFile)
+		return "", err
+	}
+
+	password := cmdArgs.DBPassword
+	if cmdArgs.DBPasswordFile != "" {
+		content, err := os.ReadFile(cmdArgs.DBPasswordFile)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Unable to read %s", cmdArgs.DBPasswordFile)
+			return "", err
+		}
+		password = strings.TrimRight(string(content), "\r\n")
+	}
+
+	if password != "" {
+		return fmt.Sprintf(
+			"host=%s user=%s dbname=%s password=%s sslmode=disable",
+			dbParam.Host,
+			dbParam.User,
+			dbParam.DB,
+			password,
+		), nil
+	}
+	return fmt.Sprintf(
+		"host=%s user=%s dbname=%s sslmode=disable", dbParam.Host, dbParam.User, dbParam.DB,
+	), nil
+}
+
+/*
+readOpenIDIssuerParams read the OpenID issuer parameter file. The file lists one or more
+issuers; the first is the primary issuer.
+
+	@param validate *validator.Validate - validator for the parameters
+	@return the parameters of each OpenID issuer
+*/
+func readOpenIDIssuerParams(validate *validator.Validate) ([]common.OpenIDIssuerConfig, error) {
+	if cmdArgs.OpenIDIssuerParamFile == "" {
+		return nil, fmt.Errorf("no OpenID issuer parameter file given")
+	}
+	params, err := os.ReadFile(cmdArgs.OpenIDIssuerParamFile)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Unable to read %s", cmdArgs.OpenIDIssuerParamFile)
+		return nil, err
+	}
+	oidParams, err := common.ParseOpenIDIssuerParams(params)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Unable to parse %s", cmdArgs.OpenIDIssuerParamFile)
+		return nil, err
+	}
+	if cmdArgs.OpenIDClientCredFile != "" {
+		oidParams[0].ClientCredFile = &cmdArgs.OpenIDClientCredFile
+	}
+	for idx := range oidParams {
+		if err := validate.Struct(&oidParams[idx]); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("%s content is not valid", cmdArgs.OpenIDIssuerParamFile)
+			return nil, err
+		}
+	}
+	return oidParams, nil
+}
+
+/*
+defineOpenIDIssuersClient performs OpenID issuer discovery for each issuer, and defines the
+client accepting tokens from all of them
+
+	@param oidParams []common.OpenIDIssuerConfig - the parameters of each OpenID issuer
+	@return the OpenID issuer client
+*/
+func defineOpenIDIssuersClient(
+	oidParams []common.OpenIDIssuerConfig,
+) (authenticate.OpenIDIssuerClient, error) {
+	if len(oidParams) == 1 {
+		return apis.DefineOpenIDIssuerClient(oidParams[0])
+	}
+	clients := map[string]authenticate.OpenIDIssuerClient{}
+	for _, oidParam := range oidParams {
+		client, err := apis.DefineOpenIDIssuerClient(oidParam)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("OpenID issuer discovery failed for %s", oidParam.Issuer)
+			return nil, err
+		}
+		clients[oidParam.Issuer] = client
+	}
+	return authenticate.DefineMultiIssuerOpenIDClient(oidParams[0].Issuer, clients)
+}
+
+/*
+openIDIssuerNames list the URLs of the OpenID issuers
+
+	@param oidParams []common.OpenIDIssuerConfig - the parameters of each OpenID issuer
+	@return the issuer URLs
+*/
+func openIDIssuerNames(oidParams []common.OpenIDIssuerConfig) string {
+	names := []string{}
+	for _, oidParam := range oidParams {
+		names = append(names, oidParam.Issuer)
+	}
+	return strings.Join(names, ", ")
+}
+
+/*
+defineTokenCache define the token cache in support of introspection. The tokens of each OpenID
+issuer are cached in a separate partition, with the issuer's token cache settings.
+
+	@param introspectCfg common.IntrospectionConfig - the introspection config
+	@param oidParams []common.OpenIDIssuerConfig - the parameters of each OpenID issuer
+	@param evictions *prometheus.CounterVec - the metric to count the entries evicted from the
+	in-memory token cache on
+	@param lookups *prometheus.CounterVec - the metric to count the token cache lookups on
+	@return the token cache
+*/
+func defineTokenCache(
+	introspectCfg common.IntrospectionConfig,
+	oidParams []common.OpenIDIssuerConfig,
+	evictions *prometheus.CounterVec,
+	lookups *prometheus.CounterVec,
+) (authenticate.TokenCache, error) {
+	partitions := map[string]authenticate.TokenCache{}
+	for _, oidParam := range oidParams {
+		issuerEvictions, err := evictions.CurryWith(
+			prometheus.Labels{"issuer": authenticate.CanonicalIssuer(oidParam.Issuer)},
+		)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Error("Unable to define token cache eviction metric")
+			return nil, err
+		}
+		partition, err := defineTokenCachePartition(introspectCfg.ForIssuer(oidParam), issuerEvictions)
+		if err != nil {
+			return nil, err
+		}
+		partitions[oidParam.Issuer] = partition
+	}
+	return authenticate.DefinePartitionedTokenCache(oidParams[0].Issuer, partitions, lookups)
+}
+
+/*
+defineTokenCachePartition define the token cache partition of one OpenID issuer
+
+	@param introspectCfg common.IntrospectionConfig - the introspection config of the issuer
+	@param evictions *prometheus.CounterVec - the metric to count the entries evicted from the
+	in-memory token cache on
+	@return the token cache partition
+*/
+func defineTokenCachePartition(
+	introspectCfg common.IntrospectionConfig, evictions *prometheus.CounterVec,
+) (authenticate.TokenCache, error) {
+	refreshInt := time.Second * time.Duration(introspectCfg.ReIntrospectInterval)
+	inactiveTTL := time.Second * time.Duration(introspectCfg.InactiveCacheTTL)
+	switch introspectCfg.Cache.Backend {
+	case common.TokenCacheBackendMemory:
+		return authenticate.DefineTokenCache(
+			refreshInt, inactiveTTL, introspectCfg.MaxCacheEntries, evictions,
+		), nil
+	case common.TokenCacheBackendMemcached:
+		client := memcache.New(introspectCfg.Cache.Memcached.Servers...)
+		if introspectCfg.Cache.Memcached.Timeout > 0 {
+			client.Timeout = time.Millisecond * time.Duration(introspectCfg.Cache.Memcached.Timeout)
+		}
+		return authenticate.DefineMemcachedTokenCache(
+			client, introspectCfg.Cache.Memcached.KeyPrefix, refreshInt, inactiveTTL,
+		), nil
+	default:
+		err := fmt.Errorf("unsupported token cache backend '%s'", introspectCfg.Cache.Backend)
+		log.WithError(err).WithFields(logTags).Error("Unable to define token cache")
+		return nil, err
+	}
+}
+
+/*
+defineQuotaCounter define the counter to count the requests against the request quotas with
+
+	@param quotaCfg common.QuotaConfig - the request quota config
+	@return the request counter, and the function to close it
+*/
+func defineQuotaCounter(
+	quotaCfg common.QuotaConfig,
+) (apis.QuotaCounter, func() error, error) {
+	switch quotaCfg.Backend {
+	case common.QuotaBackendMemory:
+		return apis.DefineMemoryQuotaCounter(), func() error { return nil }, nil
+	case common.QuotaBackendRedis:
+		client := redis.NewClient(&redis.Options{
+			Addr:     quotaCfg.Redis.Address,
+			Password: cmdArgs.RedisPassword,
+			DB:       quotaCfg.Redis.DB,
+		})
+		return apis.DefineRedisQuotaCounter(client, quotaCfg.Redis.Key), client.Close, nil
+	default:
+		err := fmt.Errorf("unsupported quota backend '%s'", quotaCfg.Backend)
+		log.WithError(err).WithFields(logTags).Error("Unable to define quota counter")
+		return nil, nil, err
+	}
+}
+
+/*
+defineTokenCacheStore define the token cache store to persist the token cache to
+
+	@param persistCfg common.TokenCachePersistConfig - the token cache persistence config
+	@return the token cache store, and the function to close it
+*/
+func defineTokenCacheStore(
+	persistCfg common.TokenCachePersistConfig,
+) (authenticate.TokenCacheStore, func() error, error) {
+	switch persistCfg.Backend {
+	case common.TokenCachePersistBackendFile:
+		return authenticate.DefineFileTokenCacheStore(persistCfg.File),
+			func() error { return nil },
+			nil
+	case common.TokenCachePersistBackendRedis:
+		client := redis.NewClient(&redis.Options{
+			Addr:     persistCfg.Redis.Address,
+			Password: cmdArgs.RedisPassword,
+			DB:       persistCfg.Redis.DB,
+		})
+		return authenticate.DefineRedisTokenCacheStore(client, persistCfg.Redis.Key),
+			client.Close,
+			nil
+	default:
+		err := fmt.Errorf("unsupported token cache persistence backend '%s'", persistCfg.Backend)
+		log.WithError(err).WithFields(logTags).Error("Unable to define token cache store")
+		return nil, nil, err
+	}
+}
+
+/*
+serveHTTP start a HTTP server, on the provided listener if available. The server is served
+over TLS if it has a TLS config.
+
+	@param svr *http.Server - the server
+	@param listener net.Listener - if provided, serve on this listener instead of binding the
+	server's address
+	@return the error which stopped the server
+*/
+func serveHTTP(svr *http.Server, listener net.Listener) error {
+	if svr.TLSConfig != nil {
+		// The certificate is part of the TLS config
+		if listener != nil {
+			return svr.ServeTLS(listener, "", "")
+		}
+		return svr.ListenAndServeTLS("", "")
+	}
+	if listener != nil {
+		return svr.Serve(listener)
+	}
+	return svr.ListenAndServe()
+}
+
 // newMetricsCollector define metrics collector
 func newMetricsCollector(config common.MetricsFeatureConfig) (goutils.MetricsCollector, error) {
 	framework, err := goutils.GetNewMetricsCollector(
@@ -507,3 +2001,23 @@ func newMetricsCollector(config common.MetricsFeatureConfig) (goutils.MetricsCol
 	}
 	return framework, nil
 }
+
+// newRouteMetricsHelper define the route metrics helper if enabled
+func newRouteMetricsHelper(
+	metrics goutils.MetricsCollector, config common.MetricsFeatureConfig,
+) (apis.RouteMetricsHelper, error) {
+	if !config.EnableRouteMetrics {
+		return nil, nil
+	}
+	return apis.DefineRouteMetricsHelper(metrics, config.MaxRouteLabels)
+}
+
+// newDecisionMetricsHelper define the decision metrics helper if enabled
+func newDecisionMetricsHelper(
+	metrics goutils.MetricsCollector, config common.MetricsFeatureConfig,
+) (apis.DecisionMetricsHelper, error) {
+	if !config.EnableDecisionMetrics {
+		return nil, nil
+	}
+	return apis.DefineDecisionMetricsHelper(metrics, config.MaxHostLabels)
+}
@@ -0,0 +1,5 @@
+// Package managementpb is the gRPC user management API, mirroring the user and role
+// end-points of the user management REST API
+package managementpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative management.proto
@@ -136,8 +136,14 @@ Match checks whether a request matches against auth bypass rules
 */
 func (m *bypassPathMatcher) Match(ctxt context.Context, request RequestParam) (bool, error) {
 	logTags := m.GetLogTagsForContext(ctxt)
+	// Match against the absolute path, so relative references can't reach past a bypass path
+	absPath, err := GetAbsPath(request.Path)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Request path normalization failed")
+		return false, err
+	}
 	for regPattern, oneMatch := range m.matches {
-		if matched, err := oneMatch.Match([]byte(request.Path)); err != nil {
+		if matched, err := oneMatch.Match([]byte(absPath)); err != nil {
 			continue
 		} else if matched {
 			log.
@@ -75,6 +75,14 @@ func TestBypassURIPathMatcher(t *testing.T) {
 	match, err = uut.Match(utCtxt, RequestParam{Path: "/public/919-886-7134/meta.json"})
 	assert.Nil(err)
 	assert.True(match)
+
+	// Relative references are resolved before matching
+	match, err = uut.Match(utCtxt, RequestParam{Path: "/public/919-886-7134/../../admin"})
+	assert.Nil(err)
+	assert.False(match)
+	match, err = uut.Match(utCtxt, RequestParam{Path: "/assets/../public/store/index.html"})
+	assert.Nil(err)
+	assert.True(match)
 }
 
 func TestAuthBypassMatcher(t *testing.T) {
@@ -9,6 +9,9 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
+// WebSocketMethod is the rule method applying to WebSocket upgrade requests
+const WebSocketMethod = "WEBSOCKET"
+
 // RequestParam contains critical parameters describing a REST request
 type RequestParam struct {
 	// Host is the request target "host"
@@ -17,6 +20,19 @@ type RequestParam struct {
 	Path string `validate:"required,uri"`
 	// Method is the request method
 	Method string `validate:"required,oneof=GET HEAD PUT POST PATCH DELETE OPTIONS"`
+	// WebSocket whether the request is a WebSocket upgrade. Such a request is matched against
+	// the WebSocketMethod rules first, then by its method.
+	WebSocket bool
+	// Attributes are the additional named attributes of the request
+	Attributes map[string]string
+	// Headers are the request headers named by the header conditions, keyed by the canonical
+	// header name
+	Headers map[string]string
+	// Scopes are the OAuth2 scopes of the token the request was made with, if any
+	Scopes []string
+	// UserPermissions are the permissions of the user making the request. Only provided to the
+	// matchers which decide on them, see UserPermissionsConditioned.
+	UserPermissions []string
 }
 
 /*
@@ -43,8 +59,41 @@ type TargetPathSpec struct {
 	// PermissionsForMethod is the DICT of required permission for each specified request
 	// method that is allowed for this path. The method key of "*" functions as a wildcard.
 	// If the request method is not explicitly listed here, it may match against "*" if that
-	// key was defined.
+	// key was defined. The method key of WebSocketMethod applies to WebSocket upgrades.
 	PermissionsForMethod map[string][]string `validate:"required,min=1"`
+	// ClientsForMethod is the DICT of OAuth2 client IDs allowed to use each request method
+	// directly, keyed as PermissionsForMethod.
+	ClientsForMethod map[string][]string
+	// UserAttributesForMethod is the DICT of REGEX pattern each named user attribute must
+	// match to use each request method, keyed as PermissionsForMethod.
+	UserAttributesForMethod map[string]map[string]string
+	// ServiceAccountsOnlyForMethod is the DICT of whether only service accounts may use each
+	// request method, keyed as PermissionsForMethod.
+	ServiceAccountsOnlyForMethod map[string]bool
+	// ScopesForMethod is the DICT of OAuth2 scopes the token must all carry to use each
+	// request method, keyed as PermissionsForMethod.
+	ScopesForMethod map[string][]string
+	// Attributes is the DICT of REGEX pattern each named request attribute must match for
+	// this path to apply. A request missing one of these attributes does not match.
+	Attributes map[string]string
+	// QueryParams is the DICT of REGEX pattern each named query parameter must match for this
+	// path to apply. Every value of a repeated parameter must match.
+	QueryParams map[string]string
+	// Headers is the DICT of REGEX pattern each request header, keyed by the canonical header
+	// name, must match for this path to apply
+	Headers map[string]string
+}
+
+// TargetDenySpec is a single path pattern to deny
+type TargetDenySpec struct {
+	// PathPattern is the pattern for matching against a request URI path
+	PathPattern string `validate:"required"`
+	// Methods is the list of request methods denied for this path. The method of "*"
+	// functions as a wildcard. All methods are denied if empty.
+	Methods []string `validate:"omitempty,dive,oneof=GET HEAD PUT POST PATCH DELETE OPTIONS WEBSOCKET *"`
+	// DeniedPermissions if set, only the users holding one of these permissions are denied.
+	// Otherwise, every request is denied.
+	DeniedPermissions []string
 }
 
 // TargetHostSpec is a single host to check against defined by multiple associated paths
@@ -52,17 +101,207 @@ type TargetHostSpec struct {
 	// TargetHost is the host value the URI are associated with
 	TargetHost string `validate:"required"`
 	// AllowedPathsForHost is the list of paths associated with this host
-	AllowedPathsForHost []TargetPathSpec `validate:"required,min=1,dive"`
+	AllowedPathsForHost []TargetPathSpec `validate:"required_without=DeniedPathsForHost,omitempty,dive"`
+	// DeniedPathsForHost is the list of paths denied for this host. These are checked before
+	// the allowed paths of any host.
+	DeniedPathsForHost []TargetDenySpec `validate:"omitempty,dive"`
 }
 
 // TargetGroupSpec is a groups of hosts to check against
 type TargetGroupSpec struct {
 	// AllowedHosts is the list of TargetHostSpec keyed by the host name. The host key of "*"
 	// functions as a wildcard. If a request host is not explicitly listed here, it may match
-	// against "*" if that key was defined
+	// against "*" if that key was defined. The denied paths of the request host, and of "*",
+	// are checked before any allowed path.
 	AllowedHosts map[string]TargetHostSpec `validate:"required,min=1,dive"`
 }
 
+// MatchedRule describes the authorization rule a request matched against
+type MatchedRule struct {
+	// Host is the host of the rule. "*" is the wildcard host.
+	Host string `json:"host"`
+	// PathPattern is the path pattern of the rule
+	PathPattern string `json:"path_pattern"`
+	// Method is the method of the rule. "*" is the wildcard method.
+	Method string `json:"method"`
+	// Permissions is the list permissions needed to proceed
+	Permissions []string `json:"permissions"`
+	// Attributes is the attribute conditions of the rule, if any
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// QueryParams is the query parameter conditions of the rule, if any
+	QueryParams map[string]string `json:"query_params,omitempty"`
+	// Headers is the header conditions of the rule, if any
+	Headers map[string]string `json:"headers,omitempty"`
+	// Clients is the list of OAuth2 client IDs allowed to proceed directly, if any
+	Clients []string `json:"clients,omitempty"`
+	// UserAttributes is the REGEX pattern each named user attribute must match, if any
+	UserAttributes map[string]string `json:"user_attributes,omitempty"`
+	// ServiceAccountsOnly is whether only service accounts may proceed
+	ServiceAccountsOnly bool `json:"service_accounts_only,omitempty"`
+	// Scopes is the list of OAuth2 scopes the token must all carry, if any
+	Scopes []string `json:"scopes,omitempty"`
+	// Denied is whether the rule denies the request, regardless of the user or the client
+	Denied bool `json:"denied,omitempty"`
+	// DeniedPermissions is the list of permissions whose holders are denied, even if they
+	// hold one of the Permissions, if any
+	DeniedPermissions []string `json:"denied_permissions,omitempty"`
+	// userAttributeRegex is the compiled UserAttributes, by attribute name
+	userAttributeRegex map[string]common.RegexCheck
+}
+
+/*
+DeniesAll whether the rule denies the request, regardless of the user or the client
+
+	@return whether the rule is an unconditional deny rule
+*/
+func (r *MatchedRule) DeniesAll() bool {
+	return r != nil && r.Denied
+}
+
+/*
+AcceptsUserAttributes whether the user attributes satisfy the user attribute conditions of
+the rule. A rule without such conditions accepts any user.
+
+	@param attributes map[string]string - the attributes of the user
+	@return whether the attributes satisfy the conditions
+*/
+func (r *MatchedRule) AcceptsUserAttributes(attributes map[string]string) (bool, error) {
+	if r == nil || len(r.UserAttributes) == 0 {
+		return true, nil
+	}
+	checks := r.userAttributeRegex
+	if checks == nil {
+		// The rule was not produced by a matcher which compiled the conditions
+		var err error
+		if checks, err = compileAttributeRegex(r.UserAttributes); err != nil {
+			return false, err
+		}
+	}
+	return attributesMatch(checks, attributes)
+}
+
+/*
+compileAttributeRegex compile the REGEX pattern of each named attribute
+
+	@param patterns map[string]string - the REGEX pattern, by attribute name
+	@return the REGEX checks, by attribute name
+*/
+func compileAttributeRegex(patterns map[string]string) (map[string]common.RegexCheck, error) {
+	checks := map[string]common.RegexCheck{}
+	for attribute, pattern := range patterns {
+		regex, err := common.NewRegexCheck(pattern)
+		if err != nil {
+			return nil, err
+		}
+		checks[attribute] = regex
+	}
+	return checks, nil
+}
+
+/*
+attributesMatch check whether each named attribute is present, and matches its REGEX check
+
+	@param checks map[string]common.RegexCheck - the REGEX checks, by attribute name
+	@param attributes map[string]string - the attributes to check
+	@return whether all the attributes match
+*/
+func attributesMatch(
+	checks map[string]common.RegexCheck, attributes map[string]string,
+) (bool, error) {
+	for attribute, regex := range checks {
+		value, ok := attributes[attribute]
+		if !ok {
+			return false, nil
+		}
+		matched, err := regex.Match([]byte(value))
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+/*
+queryParamsMatch check whether each named query parameter is present, and all its values
+match its REGEX check
+
+	@param checks map[string]common.RegexCheck - the REGEX checks, by parameter name
+	@param query url.Values - the query parameters to check
+	@return whether all the query parameters match
+*/
+func queryParamsMatch(checks map[string]common.RegexCheck, query url.Values) (bool, error) {
+	for param, regex := range checks {
+		values, ok := query[param]
+		if !ok {
+			return false, nil
+		}
+		for _, value := range values {
+			matched, err := regex.Match([]byte(value))
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+/*
+AllowsAnonymous whether the rule allows any request, including requests without a user ID
+
+	@return whether the rule carries the reserved anonymous permission
+*/
+func (r *MatchedRule) AllowsAnonymous() bool {
+	if r == nil {
+		return false
+	}
+	for _, permission := range r.Permissions {
+		if permission == common.AnonymousPermission {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+AllowsClient whether the rule allows an OAuth2 client directly, regardless of the user
+
+	@param clientID string - the client ID
+	@return whether the client is listed in the rule
+*/
+func (r *MatchedRule) AllowsClient(clientID string) bool {
+	if r == nil || clientID == "" {
+		return false
+	}
+	for _, client := range r.Clients {
+		if client == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+AcceptsScopes whether the OAuth2 scopes of the token satisfy the scopes the rule requires
+
+	@param scopes []string - the scopes of the token
+	@return whether the token carries all the required scopes
+*/
+func (r *MatchedRule) AcceptsScopes(scopes []string) bool {
+	if r == nil {
+		return true
+	}
+	held := map[string]bool{}
+	for _, scope := range scopes {
+		held[scope] = true
+	}
+	for _, required := range r.Scopes {
+		if !held[required] {
+			return false
+		}
+	}
+	return true
+}
+
 // RequestMatch checks whether a request matches against defined parameters
 type RequestMatch interface {
 	/*
@@ -74,6 +313,16 @@ type RequestMatch interface {
 	*/
 	Match(ctxt context.Context, request RequestParam) ([]string, error)
 
+	/*
+		MatchRule checks whether a request matches against defined parameters, and report which
+		rule it matched
+
+		 @param ctxt context.Context - context calling this API
+		 @param request RequestParam - request parameters
+		 @return if a match, the matched rule, or an error otherwise
+	*/
+	MatchRule(ctxt context.Context, request RequestParam) (*MatchedRule, error)
+
 	/*
 		String returns an ASCII description of the object
 
@@ -82,6 +331,62 @@ type RequestMatch interface {
 	String() string
 }
 
+// permissionsOfRule helper function to get the permissions of a matched rule
+func permissionsOfRule(rule *MatchedRule, err error) ([]string, error) {
+	if err != nil || rule == nil {
+		return nil, err
+	}
+	return rule.Permissions, nil
+}
+
+// HeaderConditioned is implemented by the RequestMatch with conditions on the request
+// headers, so the caller knows which headers to provide in RequestParam.Headers
+type HeaderConditioned interface {
+	/*
+		ConditionHeaders list the request headers the matcher has conditions on
+
+		 @return the canonical header names
+	*/
+	ConditionHeaders() []string
+}
+
+/*
+ConditionHeadersOf list the request headers a RequestMatch has conditions on
+
+	@param matcher RequestMatch - the request matcher
+	@return the canonical header names, or nil if the matcher has no header conditions
+*/
+func ConditionHeadersOf(matcher RequestMatch) []string {
+	if conditioned, ok := matcher.(HeaderConditioned); ok {
+		return conditioned.ConditionHeaders()
+	}
+	return nil
+}
+
+// UserPermissionsConditioned is implemented by the RequestMatch which decide on the
+// permissions of the user, so the caller knows to provide RequestParam.UserPermissions
+type UserPermissionsConditioned interface {
+	/*
+		ConditionsOnUserPermissions whether the matcher decides on the permissions of the user
+
+		 @return whether the permissions of the user are needed
+	*/
+	ConditionsOnUserPermissions() bool
+}
+
+/*
+NeedsUserPermissions whether a RequestMatch decides on the permissions of the user
+
+	@param matcher RequestMatch - the request matcher
+	@return whether RequestParam.UserPermissions must be provided
+*/
+func NeedsUserPermissions(matcher RequestMatch) bool {
+	if conditioned, ok := matcher.(UserPermissionsConditioned); ok {
+		return conditioned.ConditionsOnUserPermissions()
+	}
+	return false
+}
+
 /*
 ConvertConfigToTargetGroupSpec convert a common.AuthorizationConfig into TargetGroupSpec
 
@@ -100,12 +405,45 @@ func ConvertConfigToTargetGroupSpec(cfg *common.AuthorizationConfig) (TargetGrou
 			pathSpec := TargetPathSpec{
 				PathPattern:          oneTargetPath.PathRegexPattern,
 				PermissionsForMethod: make(map[string][]string),
+				ClientsForMethod:     make(map[string][]string),
+				Attributes:           oneTargetPath.Attributes,
+				QueryParams:          oneTargetPath.QueryParams,
+				Headers:              common.CanonicalHeaders(oneTargetPath.Headers),
 			}
 			for _, oneTargetMethod := range oneTargetPath.AllowedMethods {
 				pathSpec.PermissionsForMethod[oneTargetMethod.Method] = oneTargetMethod.Permissions
+				if len(oneTargetMethod.Clients) > 0 {
+					pathSpec.ClientsForMethod[oneTargetMethod.Method] = oneTargetMethod.Clients
+				}
+				if len(oneTargetMethod.UserAttributes) > 0 {
+					if pathSpec.UserAttributesForMethod == nil {
+						pathSpec.UserAttributesForMethod = make(map[string]map[string]string)
+					}
+					pathSpec.UserAttributesForMethod[oneTargetMethod.Method] =
+						oneTargetMethod.UserAttributes
+				}
+				if len(oneTargetMethod.RequiredScopes) > 0 {
+					if pathSpec.ScopesForMethod == nil {
+						pathSpec.ScopesForMethod = make(map[string][]string)
+					}
+					pathSpec.ScopesForMethod[oneTargetMethod.Method] = oneTargetMethod.RequiredScopes
+				}
+				if oneTargetMethod.ServiceAccountsOnly {
+					if pathSpec.ServiceAccountsOnlyForMethod == nil {
+						pathSpec.ServiceAccountsOnlyForMethod = make(map[string]bool)
+					}
+					pathSpec.ServiceAccountsOnlyForMethod[oneTargetMethod.Method] = true
+				}
 			}
 			hostSpec.AllowedPathsForHost = append(hostSpec.AllowedPathsForHost, pathSpec)
 		}
+		for _, oneDenyPath := range oneTargetHost.DenyPaths {
+			hostSpec.DeniedPathsForHost = append(hostSpec.DeniedPathsForHost, TargetDenySpec{
+				PathPattern:       oneDenyPath.PathRegexPattern,
+				Methods:           oneDenyPath.Methods,
+				DeniedPermissions: oneDenyPath.DeniedPermissions,
+			})
+		}
 		result.AllowedHosts[oneTargetHost.Host] = hostSpec
 	}
 
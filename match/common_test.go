@@ -56,7 +56,19 @@ authorize:
             - method: POST
               allowedPermissions:
                 - all
-                - write`)
+                - write
+              userAttributes:
+                department: "^finance$"
+              serviceAccountsOnly: true
+            - method: GET
+              allowedClients:
+                - report-service
+      denyPaths:
+        - pathPattern: "^/path3/admin/?$"
+          methods:
+            - DELETE
+          deniedPermissions:
+            - write`)
 	viper.SetConfigType("yaml")
 	assert.Nil(viper.ReadConfig(bytes.NewBuffer(config)))
 	var cfg common.AuthorizationServerConfig
@@ -96,11 +108,29 @@ authorize:
 	{
 		paths := groupSpec.AllowedHosts["*"].AllowedPathsForHost
 		assert.Equal("^/path3/[[:alpha:]]+/?$", paths[0].PathPattern)
-		assert.Len(paths[0].PermissionsForMethod, 1)
+		assert.Len(paths[0].PermissionsForMethod, 2)
 		postMethodPerm, ok := paths[0].PermissionsForMethod["POST"]
 		assert.True(ok)
 		assert.Equal([]string{"all", "write"}, postMethodPerm)
+		assert.Empty(paths[0].PermissionsForMethod["GET"])
+		assert.Equal(map[string][]string{"GET": {"report-service"}}, paths[0].ClientsForMethod)
+		assert.Equal(
+			map[string]map[string]string{"POST": {"department": "^finance$"}},
+			paths[0].UserAttributesForMethod,
+		)
+		assert.Equal(map[string]bool{"POST": true}, paths[0].ServiceAccountsOnlyForMethod)
 	}
+	assert.Empty(groupSpec.AllowedHosts["unittest.testing.org"].DeniedPathsForHost)
+	assert.Equal(
+		[]TargetDenySpec{
+			{
+				PathPattern:       "^/path3/admin/?$",
+				Methods:           []string{"DELETE"},
+				DeniedPermissions: []string{"write"},
+			},
+		},
+		groupSpec.AllowedHosts["*"].DeniedPathsForHost,
+	)
 }
 
 func TestGetAbsPath(t *testing.T) {
@@ -0,0 +1,91 @@
+package match
+
+import (
+	"fmt"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/go-playground/validator/v10"
+)
+
+// targetDenyMatcher matches requests against a denied path
+type targetDenyMatcher struct {
+	TargetDenySpec
+	targetHost string
+	regex      common.RegexCheck
+}
+
+/*
+defineTargetDenyMatcher defines a new matcher for a denied path
+
+	@param targetHost string - the host name this matcher is associated with
+	@param spec TargetDenySpec - the matcher specification
+	@return new targetDenyMatcher instance
+*/
+func defineTargetDenyMatcher(targetHost string, spec TargetDenySpec) (*targetDenyMatcher, error) {
+	validate := validator.New()
+	if err := validate.Struct(&spec); err != nil {
+		return nil, err
+	}
+	regex, err := common.NewRegexCheck(spec.PathPattern)
+	if err != nil {
+		return nil, err
+	}
+	return &targetDenyMatcher{TargetDenySpec: spec, targetHost: targetHost, regex: regex}, nil
+}
+
+/*
+matchMethod find which of the denied methods the request matches
+
+	@param request RequestParam - request parameters
+	@return the matched method, and whether the request matched
+*/
+func (m *targetDenyMatcher) matchMethod(request RequestParam) (string, bool) {
+	if len(m.Methods) == 0 {
+		return "*", true
+	}
+	candidates := []string{request.Method, "*"}
+	if request.WebSocket {
+		candidates = append([]string{WebSocketMethod}, candidates...)
+	}
+	for _, candidate := range candidates {
+		for _, method := range m.Methods {
+			if method == candidate {
+				return method, true
+			}
+		}
+	}
+	return "", false
+}
+
+/*
+match checks whether a request matches the denied path
+
+	@param request RequestParam - request parameters
+	@return if a match, the deny rule, or an error otherwise
+*/
+func (m *targetDenyMatcher) match(request RequestParam) (*MatchedRule, error) {
+	pathMatch, err := m.regex.Match([]byte(request.Path))
+	if err != nil || !pathMatch {
+		return nil, err
+	}
+	method, ok := m.matchMethod(request)
+	if !ok {
+		return nil, nil
+	}
+	return &MatchedRule{
+		Host:              m.targetHost,
+		PathPattern:       m.PathPattern,
+		Method:            method,
+		Denied:            len(m.DeniedPermissions) == 0,
+		DeniedPermissions: m.DeniedPermissions,
+	}, nil
+}
+
+/*
+String returns an ASCII description of the object
+
+	@return an ASCII description of the object
+*/
+func (m *targetDenyMatcher) String() string {
+	return fmt.Sprintf("DENY-MATCH['%s']", m.PathPattern)
+}
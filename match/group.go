@@ -2,6 +2,7 @@ package match
 
 import (
 	"context"
+	"sort"
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/common"
@@ -15,7 +16,11 @@ import (
 type targetGroupMatcher struct {
 	goutils.Component
 	hostMatchers map[string]*targetHostMatcher
-	validate     *validator.Validate
+	// denyMatchers is the denied path matchers, keyed by host name
+	denyMatchers map[string][]*targetDenyMatcher
+	// conditionHeaders is the sorted request headers the path matchers have conditions on
+	conditionHeaders []string
+	validate         *validator.Validate
 }
 
 /*
@@ -41,6 +46,33 @@ func DefineTargetGroupMatcher(spec TargetGroupSpec) (RequestMatch, error) {
 		}
 		hostMatchers[hostName] = matcher
 	}
+	// Build out the denied path matchers
+	denyMatchers := map[string][]*targetDenyMatcher{}
+	for hostName, matcherSpec := range spec.AllowedHosts {
+		for _, denySpec := range matcherSpec.DeniedPathsForHost {
+			matcher, err := defineTargetDenyMatcher(matcherSpec.TargetHost, denySpec)
+			if err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Unable to build deny matcher for %s", denySpec.PathPattern)
+				return nil, err
+			}
+			denyMatchers[hostName] = append(denyMatchers[hostName], matcher)
+		}
+	}
+	// Collect the request headers the path matchers have conditions on
+	conditionHeaders := []string{}
+	seenHeader := map[string]bool{}
+	for _, matcherSpec := range spec.AllowedHosts {
+		for _, pathSpec := range matcherSpec.AllowedPathsForHost {
+			for header := range pathSpec.Headers {
+				if !seenHeader[header] {
+					seenHeader[header] = true
+					conditionHeaders = append(conditionHeaders, header)
+				}
+			}
+		}
+	}
+	sort.Strings(conditionHeaders)
 	return &targetGroupMatcher{
 		Component: goutils.Component{
 			LogTags: logTags,
@@ -48,10 +80,61 @@ func DefineTargetGroupMatcher(spec TargetGroupSpec) (RequestMatch, error) {
 				goutils.ModifyLogMetadataByRestRequestParam,
 				common.ModifyLogMetadataByAccessAuthorizeParam,
 			},
-		}, hostMatchers: hostMatchers, validate: validate,
+		},
+		hostMatchers:     hostMatchers,
+		denyMatchers:     denyMatchers,
+		conditionHeaders: conditionHeaders,
+		validate:         validate,
 	}, nil
 }
 
+/*
+ConditionHeaders list the request headers the matcher has conditions on
+
+	@return the canonical header names
+*/
+func (m *targetGroupMatcher) ConditionHeaders() []string {
+	return m.conditionHeaders
+}
+
+/*
+matchDeny checks whether a request matches the denied paths of its host, or of the wildcard
+host
+
+	@param request RequestParam - request parameters
+	@return the unconditional deny rule matched if any, the permissions whose holders are
+	        denied otherwise, or an error
+*/
+func (m *targetGroupMatcher) matchDeny(request RequestParam) (*MatchedRule, []string, error) {
+	hosts := []string{"*"}
+	if request.Host != nil && *request.Host != "*" {
+		hosts = []string{*request.Host, "*"}
+	}
+	deniedPermissions := []string{}
+	seenPermission := map[string]bool{}
+	for _, host := range hosts {
+		for _, matcher := range m.denyMatchers[host] {
+			rule, err := matcher.match(request)
+			if err != nil {
+				return nil, nil, err
+			}
+			if rule == nil {
+				continue
+			}
+			if rule.DeniesAll() {
+				return rule, nil, nil
+			}
+			for _, permission := range rule.DeniedPermissions {
+				if !seenPermission[permission] {
+					seenPermission[permission] = true
+					deniedPermissions = append(deniedPermissions, permission)
+				}
+			}
+		}
+	}
+	return nil, deniedPermissions, nil
+}
+
 /*
 Match checks whether a request matches against defined parameters
 
@@ -61,6 +144,20 @@ Match checks whether a request matches against defined parameters
 */
 func (m *targetGroupMatcher) Match(ctxt context.Context, request RequestParam) (
 	[]string, error,
+) {
+	return permissionsOfRule(m.MatchRule(ctxt, request))
+}
+
+/*
+MatchRule checks whether a request matches against defined parameters, and report which
+rule it matched
+
+	@param ctxt context.Context - context calling this API
+	@param request RequestParam - request parameters
+	@return if a match, the matched rule, or an error otherwise
+*/
+func (m *targetGroupMatcher) MatchRule(ctxt context.Context, request RequestParam) (
+	*MatchedRule, error,
 ) {
 	logTags := m.GetLogTagsForContext(ctxt)
 	// Verify the request is considered valid
@@ -70,37 +167,58 @@ func (m *targetGroupMatcher) Match(ctxt context.Context, request RequestParam) (
 			Error("Invalid request check parameters")
 		return nil, err
 	}
+	// Denied paths are checked before the allowed paths
+	denyRule, deniedPermissions, err := m.matchDeny(request)
+	if err != nil {
+		log.WithError(err).
+			WithFields(logTags).
+			WithField("check_request", request.String()).
+			Error("Failed to execute DENY match")
+		return nil, err
+	}
+	if denyRule != nil {
+		log.WithFields(logTags).WithField("check_request", request.String()).Debug("DENIED")
+		return denyRule, nil
+	}
+	rule, err := m.matchAllowed(ctxt, request)
+	if err != nil {
+		log.WithError(err).
+			WithFields(logTags).
+			WithField("check_request", request.String()).
+			Error("Failed to execute HOST match")
+		return nil, err
+	}
+	if rule != nil && len(deniedPermissions) > 0 {
+		rule.DeniedPermissions = deniedPermissions
+	}
+	return rule, nil
+}
+
+/*
+matchAllowed checks whether a request matches the allowed paths of its host, or of the
+wildcard host
+
+	@param ctxt context.Context - context calling this API
+	@param request RequestParam - request parameters
+	@return if a match, the matched rule, or an error otherwise
+*/
+func (m *targetGroupMatcher) matchAllowed(ctxt context.Context, request RequestParam) (
+	*MatchedRule, error,
+) {
 	// Find a matching host, use "*" if not provided
 	if request.Host != nil {
 		matcher, ok := m.hostMatchers[*request.Host]
 		if ok {
-			permissions, err := matcher.Match(ctxt, request)
-			if err != nil {
-				log.WithError(err).
-					WithFields(logTags).
-					WithField("check_request", request.String()).
-					Error("Failed to execute HOST match")
-				return nil, err
-			}
-			if permissions != nil {
-				return permissions, nil
+			rule, err := matcher.MatchRule(ctxt, request)
+			if err != nil || rule != nil {
+				return rule, err
 			}
 		}
 	}
 	// Check with wildcard instead
 	matcher, ok := m.hostMatchers["*"]
 	if ok {
-		permissions, err := matcher.Match(ctxt, request)
-		if err != nil {
-			log.WithError(err).
-				WithFields(logTags).
-				WithField("check_request", request.String()).
-				Error("Failed to execute HOST match")
-			return nil, err
-		}
-		if permissions != nil {
-			return permissions, nil
-		}
+		return matcher.MatchRule(ctxt, request)
 	}
 	return nil, nil
 }
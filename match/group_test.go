@@ -107,6 +107,23 @@ func TestTargetGroupMatcher(t *testing.T) {
 			}
 			assert.Equalf(oneCase.expectedPermissions, permissions, oneCase.request.String())
 		}
+
+		// Report the matched rule
+		rule, err := uut.MatchRule(context.Background(), cases[0].request)
+		assert.Nil(err)
+		assert.NotNil(rule)
+		assert.EqualValues(
+			MatchedRule{
+				Host:        testHost0,
+				PathPattern: `^/part1/[[:alnum:]]+$`,
+				Method:      "GET",
+				Permissions: []string{"spec0.0", "spec0.1"},
+			},
+			*rule,
+		)
+		rule, err = uut.MatchRule(context.Background(), cases[1].request)
+		assert.Nil(err)
+		assert.Nil(rule)
 	}
 
 	// Case 1: wildcard selection
@@ -206,3 +223,116 @@ func TestTargetGroupMatcher(t *testing.T) {
 		}
 	}
 }
+
+func TestTargetGroupMatcherDenyPaths(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	testHost := "unit-test.testing.org"
+	otherHost := "other.testing.org"
+	spec := TargetGroupSpec{
+		AllowedHosts: map[string]TargetHostSpec{
+			testHost: {
+				TargetHost: testHost,
+				AllowedPathsForHost: []TargetPathSpec{
+					{
+						PathPattern:          `^/admin/.+$`,
+						PermissionsForMethod: map[string][]string{"*": {"admin"}},
+					},
+				},
+				DeniedPathsForHost: []TargetDenySpec{
+					{PathPattern: `^/admin/reports/.+$`, DeniedPermissions: []string{"contractor"}},
+				},
+			},
+			"*": {
+				TargetHost: "*",
+				DeniedPathsForHost: []TargetDenySpec{
+					{PathPattern: `^/admin/.+$`, Methods: []string{"DELETE", "WEBSOCKET"}},
+				},
+			},
+		},
+	}
+	uut, err := DefineTargetGroupMatcher(spec)
+	assert.Nil(err)
+
+	// Case 0: a denied method is denied before the allowed paths are checked
+	{
+		rule, err := uut.MatchRule(context.Background(), RequestParam{
+			Host: &testHost, Path: "/admin/users", Method: "DELETE",
+		})
+		assert.Nil(err)
+		assert.NotNil(rule)
+		assert.True(rule.DeniesAll())
+		assert.Equal("*", rule.Host)
+		assert.Equal("DELETE", rule.Method)
+		assert.Empty(rule.Permissions)
+		permissions, err := uut.Match(context.Background(), RequestParam{
+			Host: &testHost, Path: "/admin/users", Method: "DELETE",
+		})
+		assert.Nil(err)
+		assert.Empty(permissions)
+	}
+
+	// Case 1: other methods fall through to the allowed paths
+	{
+		rule, err := uut.MatchRule(context.Background(), RequestParam{
+			Host: &testHost, Path: "/admin/users", Method: "GET",
+		})
+		assert.Nil(err)
+		assert.NotNil(rule)
+		assert.False(rule.DeniesAll())
+		assert.Equal([]string{"admin"}, rule.Permissions)
+		assert.Empty(rule.DeniedPermissions)
+	}
+
+	// Case 2: WebSocket upgrades match the WEBSOCKET method
+	{
+		rule, err := uut.MatchRule(context.Background(), RequestParam{
+			Host: &testHost, Path: "/admin/users", Method: "GET", WebSocket: true,
+		})
+		assert.Nil(err)
+		assert.True(rule.DeniesAll())
+		assert.Equal(WebSocketMethod, rule.Method)
+	}
+
+	// Case 3: conditional deny is attached to the allowed rule
+	{
+		rule, err := uut.MatchRule(context.Background(), RequestParam{
+			Host: &testHost, Path: "/admin/reports/daily", Method: "GET",
+		})
+		assert.Nil(err)
+		assert.NotNil(rule)
+		assert.False(rule.DeniesAll())
+		assert.Equal([]string{"admin"}, rule.Permissions)
+		assert.Equal([]string{"contractor"}, rule.DeniedPermissions)
+	}
+
+	// Case 4: the wildcard host denies apply to every host
+	{
+		rule, err := uut.MatchRule(context.Background(), RequestParam{
+			Host: &otherHost, Path: "/admin/users", Method: "DELETE",
+		})
+		assert.Nil(err)
+		assert.True(rule.DeniesAll())
+		rule, err = uut.MatchRule(context.Background(), RequestParam{
+			Host: &otherHost, Path: "/admin/users", Method: "GET",
+		})
+		assert.Nil(err)
+		assert.Nil(rule)
+	}
+
+	// Case 5: invalid deny method
+	{
+		_, err := DefineTargetGroupMatcher(TargetGroupSpec{
+			AllowedHosts: map[string]TargetHostSpec{
+				"*": {
+					TargetHost: "*",
+					DeniedPathsForHost: []TargetDenySpec{
+						{PathPattern: `^/admin/.+$`, Methods: []string{"FETCH"}},
+					},
+				},
+			},
+		})
+		assert.NotNil(err)
+	}
+}
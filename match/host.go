@@ -46,9 +46,16 @@ func defineTargetHostMatcher(spec TargetHostSpec) (*targetHostMatcher, error) {
 		}
 		pathMatchers = append(pathMatchers, matcher)
 	}
-	// Sort the path matcher by length of pattern
-	sort.Slice(pathMatchers, func(i, j int) bool {
-		return len(pathMatchers[i].PathPattern) > len(pathMatchers[j].PathPattern)
+	// Sort the path matcher by length of pattern. For the same pattern length, the path
+	// matchers with more attribute, query parameter, and header conditions are checked first.
+	conditions := func(matcher *targetPathMatcher) int {
+		return len(matcher.Attributes) + len(matcher.QueryParams) + len(matcher.Headers)
+	}
+	sort.SliceStable(pathMatchers, func(i, j int) bool {
+		if len(pathMatchers[i].PathPattern) != len(pathMatchers[j].PathPattern) {
+			return len(pathMatchers[i].PathPattern) > len(pathMatchers[j].PathPattern)
+		}
+		return conditions(pathMatchers[i]) > conditions(pathMatchers[j])
 	})
 	return &targetHostMatcher{
 		Component: goutils.Component{
@@ -73,6 +80,20 @@ Match checks whether a request matches against defined parameters
 */
 func (m *targetHostMatcher) Match(ctxt context.Context, request RequestParam) (
 	[]string, error,
+) {
+	return permissionsOfRule(m.MatchRule(ctxt, request))
+}
+
+/*
+MatchRule checks whether a request matches against defined parameters, and report which
+rule it matched
+
+	@param ctxt context.Context - context calling this API
+	@param request RequestParam - request parameters
+	@return if a match, the matched rule, or an error otherwise
+*/
+func (m *targetHostMatcher) MatchRule(ctxt context.Context, request RequestParam) (
+	*MatchedRule, error,
 ) {
 	logTags := m.GetLogTagsForContext(ctxt)
 	// Verify the request is considered valid
@@ -96,7 +117,7 @@ func (m *targetHostMatcher) Match(ctxt context.Context, request RequestParam) (
 		if pathOK {
 			log.WithFields(logTags).WithField("check_request", request.String()).
 				Debugf("Matches %s", pathMatcher.PathPattern)
-			permissions, err := pathMatcher.match(ctxt, request, true)
+			rule, err := pathMatcher.match(ctxt, request, true)
 			if err != nil {
 				log.WithError(err).
 					WithFields(logTags).
@@ -104,8 +125,8 @@ func (m *targetHostMatcher) Match(ctxt context.Context, request RequestParam) (
 					Error("Failed to execute path match")
 				return nil, err
 			}
-			if permissions != nil {
-				return permissions, nil
+			if rule != nil {
+				return rule, nil
 			}
 			// Keep checking
 		}
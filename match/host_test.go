@@ -176,4 +176,147 @@ func TestTargetHostMatcher(t *testing.T) {
 			assert.Equalf(oneCase.expectedPermissions, permissions, oneCase.request.String())
 		}
 	}
+
+	// Case 2: attribute conditions
+	{
+		spec := TargetHostSpec{
+			TargetHost: "unit-test",
+			AllowedPathsForHost: []TargetPathSpec{
+				{
+					PathPattern:          `^/api$`,
+					PermissionsForMethod: map[string][]string{"GET": {"spec2.0"}},
+				},
+				{
+					PathPattern:          `^/api$`,
+					PermissionsForMethod: map[string][]string{"GET": {"spec2.1"}},
+					Attributes:           map[string]string{"scheme": `^http$`},
+				},
+				{
+					PathPattern:          `^/api$`,
+					PermissionsForMethod: map[string][]string{"GET": {"spec2.2"}},
+					Attributes: map[string]string{
+						"scheme": `^http$`, "sourceAddress": `^10\.`,
+					},
+				},
+			},
+		}
+		uut, err := defineTargetHostMatcher(spec)
+		assert.Nil(err)
+
+		cases := []testCase{
+			{
+				request:             RequestParam{Path: "/api", Method: "GET"},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec2.0"},
+			},
+			{
+				request: RequestParam{
+					Path: "/api", Method: "GET", Attributes: map[string]string{"scheme": "https"},
+				},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec2.0"},
+			},
+			{
+				request: RequestParam{
+					Path: "/api", Method: "GET", Attributes: map[string]string{"scheme": "http"},
+				},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec2.1"},
+			},
+			{
+				request: RequestParam{
+					Path:       "/api",
+					Method:     "GET",
+					Attributes: map[string]string{"scheme": "http", "sourceAddress": "10.0.0.1"},
+				},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec2.2"},
+			},
+		}
+
+		for _, oneCase := range cases {
+			permissions, err := uut.Match(context.Background(), oneCase.request)
+			if oneCase.expectedErr {
+				assert.NotNilf(err, oneCase.request.String())
+			} else {
+				assert.Nilf(err, oneCase.request.String())
+			}
+			assert.Equalf(oneCase.expectedPermissions, permissions, oneCase.request.String())
+		}
+	}
+
+	// Case 3: query parameter and header conditions
+	{
+		spec := TargetHostSpec{
+			TargetHost: "unit-test",
+			AllowedPathsForHost: []TargetPathSpec{
+				{
+					PathPattern:          `^/reports(\?.*)?$`,
+					PermissionsForMethod: map[string][]string{"GET": {"spec3.0"}},
+				},
+				{
+					PathPattern:          `^/reports(\?.*)?$`,
+					PermissionsForMethod: map[string][]string{"GET": {"spec3.1"}},
+					QueryParams:          map[string]string{"action": `^export$`},
+				},
+				{
+					PathPattern:          `^/reports(\?.*)?$`,
+					PermissionsForMethod: map[string][]string{"GET": {"spec3.2"}},
+					Headers:              map[string]string{"X-Tenant": `^acme$`},
+				},
+			},
+		}
+		uut, err := defineTargetHostMatcher(spec)
+		assert.Nil(err)
+
+		cases := []testCase{
+			{
+				request:             RequestParam{Path: "/reports", Method: "GET"},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec3.0"},
+			},
+			{
+				request:             RequestParam{Path: "/reports?action=export", Method: "GET"},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec3.1"},
+			},
+			{
+				request:             RequestParam{Path: "/reports?action=view", Method: "GET"},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec3.0"},
+			},
+			{
+				// Every value of a repeated parameter must match
+				request: RequestParam{
+					Path: "/reports?action=export&action=delete", Method: "GET",
+				},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec3.0"},
+			},
+			{
+				request: RequestParam{
+					Path: "/reports", Method: "GET", Headers: map[string]string{"X-Tenant": "acme"},
+				},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec3.2"},
+			},
+			{
+				request: RequestParam{
+					Path: "/reports", Method: "GET", Headers: map[string]string{"X-Tenant": "other"},
+				},
+				expectedErr:         false,
+				expectedPermissions: []string{"spec3.0"},
+			},
+		}
+
+		for _, oneCase := range cases {
+			permissions, err := uut.Match(context.Background(), oneCase.request)
+			if oneCase.expectedErr {
+				assert.NotNilf(err, oneCase.request.String())
+			} else {
+				assert.Nilf(err, oneCase.request.String())
+			}
+			assert.Equalf(oneCase.expectedPermissions, permissions, oneCase.request.String())
+		}
+	}
 }
@@ -0,0 +1,344 @@
+package match
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alwitt/padlock/common"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIMethods are the OpenAPI operations padlock can authorize, in output order
+var openAPIMethods = []string{"get", "head", "options", "post", "put", "patch", "delete"}
+
+// openAPIReadMethods are the methods seeded with the "read" permission. The others are seeded
+// with the "write" permission.
+var openAPIReadMethods = map[string]bool{"get": true, "head": true, "options": true}
+
+// openAPIUntaggedPermissionPrefix is the permission prefix for operations without tags
+const openAPIUntaggedPermissionPrefix = "api"
+
+// openAPIPathParamPattern is the regex which replaces a path template parameter
+const openAPIPathParamPattern = "[^/]+"
+
+// openAPIPathParam matches a path template parameter
+var openAPIPathParam = regexp.MustCompile(`\{[^{}/]*\}`)
+
+// openAPIPermissionSanitizer matches the characters not kept from a tag in a permission
+var openAPIPermissionSanitizer = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// openAPISpec is the part of a OpenAPI 3 / Swagger 2 spec needed to seed authorization rules
+type openAPISpec struct {
+	// Swagger 2
+	Host     string `yaml:"host"`
+	BasePath string `yaml:"basePath"`
+	// OpenAPI 3
+	Servers []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths map[string]map[string]yaml.Node `yaml:"paths"`
+}
+
+// openAPIOperation is the part of a OpenAPI operation needed to seed authorization rules
+type openAPIOperation struct {
+	Tags []string `yaml:"tags"`
+}
+
+// AmbiguousPaths is a pair of path templates whose path patterns can match the same request
+// path. The matcher checks the longer path pattern first.
+type AmbiguousPaths struct {
+	// Paths are the two path templates
+	Paths [2]string `json:"paths"`
+	// Preferred is the path template whose pattern is checked first. It is empty if the two
+	// patterns are of the same length, as the order they are checked in is then undefined.
+	Preferred string `json:"preferred,omitempty"`
+}
+
+// OpenAPIRules are authorization rules seeded from an OpenAPI spec
+type OpenAPIRules struct {
+	// Rules are the seeded authorization rules
+	Rules []common.HostAuthorizationConfig `json:"rules"`
+	// Permissions are the seeded permissions, which must be assigned to roles
+	Permissions []string `json:"permissions"`
+	// Ambiguous are the path templates which can match the same request path
+	Ambiguous []AmbiguousPaths `json:"ambiguous"`
+	// Skipped lists the operations which could not be converted, and why
+	Skipped []string `json:"skipped"`
+}
+
+/*
+ConvertOpenAPIToRules seed authorization rules from an OpenAPI 3 or Swagger 2 spec, in YAML or
+JSON. Each path template is converted into an anchored path pattern, where each parameter
+matches one path segment. Each operation is allowed for the "<tag>:read" permission of its tags
+if the operation does not modify (GET, HEAD, OPTIONS), and "<tag>:write" otherwise.
+
+	@param spec []byte - the OpenAPI spec
+	@param host string - the host of the rules. If empty, the host is read from the spec, or
+	the wildcard host "*" if the spec does not give one.
+	@return the seeded authorization rules
+*/
+func ConvertOpenAPIToRules(spec []byte, host string) (OpenAPIRules, error) {
+	var parsed openAPISpec
+	if err := yaml.Unmarshal(spec, &parsed); err != nil {
+		return OpenAPIRules{}, err
+	}
+	if len(parsed.Paths) == 0 {
+		return OpenAPIRules{}, fmt.Errorf("spec does not define any paths")
+	}
+
+	specHost, basePath := parsed.hostAndBasePath()
+	if host == "" {
+		host = specHost
+	}
+	if host == "" {
+		host = "*"
+	}
+
+	result := OpenAPIRules{
+		Permissions: []string{}, Ambiguous: []AmbiguousPaths{}, Skipped: []string{},
+	}
+	hostRules := common.HostAuthorizationConfig{
+		Host: host, TargetPaths: []common.PathAuthorizationConfig{},
+	}
+	permissions := map[string]bool{}
+
+	templates := []string{}
+	for template := range parsed.Paths {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+
+	patterns := map[string]string{}
+	for _, template := range templates {
+		fullTemplate := joinOpenAPIPath(basePath, template)
+		pattern := openAPIPathToPattern(fullTemplate)
+		if _, err := regexp.Compile(pattern); err != nil {
+			result.Skipped = append(
+				result.Skipped, fmt.Sprintf("%s: invalid path pattern %s", fullTemplate, pattern),
+			)
+			continue
+		}
+
+		pathRules := common.PathAuthorizationConfig{
+			PathRegexPattern: pattern, AllowedMethods: []common.PermissionForAPIMethodConfig{},
+		}
+		pathItem := parsed.Paths[template]
+		for _, method := range openAPIMethods {
+			node, ok := pathItem[method]
+			if !ok {
+				continue
+			}
+			var operation openAPIOperation
+			if err := node.Decode(&operation); err != nil {
+				result.Skipped = append(
+					result.Skipped,
+					fmt.Sprintf("%s %s: %s", strings.ToUpper(method), fullTemplate, err.Error()),
+				)
+				continue
+			}
+			methodPermissions := openAPIOperationPermissions(method, operation.Tags)
+			for _, onePerm := range methodPermissions {
+				permissions[onePerm] = true
+			}
+			pathRules.AllowedMethods = append(
+				pathRules.AllowedMethods,
+				common.PermissionForAPIMethodConfig{
+					Method: strings.ToUpper(method), Permissions: methodPermissions,
+				},
+			)
+		}
+		for key := range pathItem {
+			if key == "trace" {
+				result.Skipped = append(
+					result.Skipped, fmt.Sprintf("TRACE %s: method not supported", fullTemplate),
+				)
+			}
+		}
+		if len(pathRules.AllowedMethods) == 0 {
+			continue
+		}
+		hostRules.TargetPaths = append(hostRules.TargetPaths, pathRules)
+		patterns[fullTemplate] = pattern
+	}
+	if len(hostRules.TargetPaths) == 0 {
+		return OpenAPIRules{}, fmt.Errorf("spec does not define any supported operations")
+	}
+	result.Rules = []common.HostAuthorizationConfig{hostRules}
+
+	for onePerm := range permissions {
+		result.Permissions = append(result.Permissions, onePerm)
+	}
+	sort.Strings(result.Permissions)
+
+	// Report the path templates which can match the same request path
+	converted := []string{}
+	for template := range patterns {
+		converted = append(converted, template)
+	}
+	sort.Strings(converted)
+	for i := 0; i < len(converted); i++ {
+		for j := i + 1; j < len(converted); j++ {
+			if !openAPIPathsOverlap(converted[i], converted[j]) {
+				continue
+			}
+			preferred := ""
+			if len(patterns[converted[i]]) > len(patterns[converted[j]]) {
+				preferred = converted[i]
+			} else if len(patterns[converted[j]]) > len(patterns[converted[i]]) {
+				preferred = converted[j]
+			}
+			result.Ambiguous = append(result.Ambiguous, AmbiguousPaths{
+				Paths: [2]string{converted[i], converted[j]}, Preferred: preferred,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+/*
+hostAndBasePath read the API host and base path from the spec
+
+	@return the host, and the base path
+*/
+func (s openAPISpec) hostAndBasePath() (string, string) {
+	if s.Host != "" || s.BasePath != "" {
+		host := s.Host
+		if parsed, err := url.Parse("//" + s.Host); err == nil {
+			host = parsed.Hostname()
+		}
+		return host, s.BasePath
+	}
+	if len(s.Servers) > 0 {
+		if parsed, err := url.Parse(s.Servers[0].URL); err == nil {
+			return parsed.Hostname(), parsed.Path
+		}
+	}
+	return "", ""
+}
+
+// joinOpenAPIPath prefix a path template with the base path
+func joinOpenAPIPath(basePath, template string) string {
+	basePath = strings.TrimSuffix(basePath, "/")
+	if !strings.HasPrefix(template, "/") {
+		template = "/" + template
+	}
+	return basePath + template
+}
+
+/*
+openAPIPathToPattern convert a path template into an anchored path pattern. The literal parts
+are escaped, each parameter matches one path segment, and a trailing slash is optional.
+
+	@param template string - the path template
+	@return the path pattern
+*/
+func openAPIPathToPattern(template string) string {
+	trimmed := strings.TrimSuffix(template, "/")
+	if trimmed == "" {
+		return "^/$"
+	}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range openAPIPathParam.FindAllStringIndex(trimmed, -1) {
+		pattern.WriteString(regexp.QuoteMeta(trimmed[last:loc[0]]))
+		pattern.WriteString(openAPIPathParamPattern)
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(trimmed[last:]))
+	pattern.WriteString("/?$")
+	return pattern.String()
+}
+
+/*
+openAPIOperationPermissions seed the permissions allowed to call an operation
+
+	@param method string - the operation method
+	@param tags []string - the operation tags
+	@return the permissions
+*/
+func openAPIOperationPermissions(method string, tags []string) []string {
+	access := "write"
+	if openAPIReadMethods[method] {
+		access = "read"
+	}
+	prefixes := []string{}
+	for _, tag := range tags {
+		prefix := strings.Trim(
+			openAPIPermissionSanitizer.ReplaceAllString(strings.ToLower(tag), "-"), "-",
+		)
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{openAPIUntaggedPermissionPrefix}
+	}
+	unique := map[string]bool{}
+	permissions := []string{}
+	for _, prefix := range prefixes {
+		permission := fmt.Sprintf("%s:%s", prefix, access)
+		if !unique[permission] {
+			unique[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+/*
+openAPIPathsOverlap whether the patterns of two path templates can match the same request path
+
+	@param a string - a path template
+	@param b string - another path template
+	@return whether the two can match the same request path
+*/
+func openAPIPathsOverlap(a, b string) bool {
+	segmentsA := strings.Split(strings.Trim(a, "/"), "/")
+	segmentsB := strings.Split(strings.Trim(b, "/"), "/")
+	if len(segmentsA) != len(segmentsB) {
+		return false
+	}
+	for idx := range segmentsA {
+		if !openAPISegmentsOverlap(segmentsA[idx], segmentsB[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+// openAPISegmentsOverlap whether two path template segments can match the same path segment
+func openAPISegmentsOverlap(a, b string) bool {
+	paramA := openAPIPathParam.MatchString(a)
+	paramB := openAPIPathParam.MatchString(b)
+	switch {
+	case !paramA && !paramB:
+		return a == b
+	case paramA && paramB:
+		// Conservatively assume two templated segments overlap
+		return true
+	case paramA:
+		return regexp.MustCompile(openAPIPathToSegmentPattern(a)).MatchString(b)
+	default:
+		return regexp.MustCompile(openAPIPathToSegmentPattern(b)).MatchString(a)
+	}
+}
+
+// openAPIPathToSegmentPattern convert a path template segment into an anchored pattern
+func openAPIPathToSegmentPattern(segment string) string {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range openAPIPathParam.FindAllStringIndex(segment, -1) {
+		pattern.WriteString(regexp.QuoteMeta(segment[last:loc[0]]))
+		pattern.WriteString(openAPIPathParamPattern)
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(segment[last:]))
+	pattern.WriteString("$")
+	return pattern.String()
+}
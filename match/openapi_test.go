@@ -0,0 +1,177 @@
+package match
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertOpenAPIToRules(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+	utCtxt := context.Background()
+
+	// Case 0: OpenAPI 3 spec in YAML
+	{
+		spec := `
+openapi: 3.0.0
+servers:
+  - url: https://api.testing.org:8443/v1
+paths:
+  /orders:
+    parameters:
+      - name: limit
+        in: query
+    get:
+      tags: [Orders]
+    post:
+      tags: [Orders, "Billing Records"]
+  /orders/{orderId}:
+    get:
+      tags: [Orders]
+    delete: {}
+    trace: {}
+  /orders/search:
+    get:
+      tags: [Orders]
+  /orders/count:
+    get:
+      tags: [Orders]
+  /files/{name}.json:
+    get:
+      tags: [files]
+  /files/{name}.json/meta:
+    get:
+      tags: [files]
+  /files/index.json/{field}:
+    get:
+      tags: [files]
+`
+		uut, err := ConvertOpenAPIToRules([]byte(spec), "")
+		assert.Nil(err)
+		assert.Len(uut.Rules, 1)
+		assert.Equal("api.testing.org", uut.Rules[0].Host)
+		assert.Equal(
+			[]string{
+				"api:write", "billing-records:write", "files:read", "orders:read", "orders:write",
+			},
+			uut.Permissions,
+		)
+		assert.Equal([]string{"TRACE /v1/orders/{orderId}: method not supported"}, uut.Skipped)
+		assert.Equal(
+			[]AmbiguousPaths{
+				{
+					Paths:     [2]string{"/v1/files/index.json/{field}", "/v1/files/{name}.json/meta"},
+					Preferred: "/v1/files/index.json/{field}",
+				},
+				{
+					Paths: [2]string{"/v1/orders/count", "/v1/orders/{orderId}"},
+				},
+				{
+					Paths:     [2]string{"/v1/orders/search", "/v1/orders/{orderId}"},
+					Preferred: "/v1/orders/search",
+				},
+			},
+			uut.Ambiguous,
+		)
+
+		// The rules must be usable by the matcher
+		matcherSpec, err := ConvertConfigToTargetGroupSpec(
+			&common.AuthorizationConfig{Rules: uut.Rules},
+		)
+		assert.Nil(err)
+		matcher, err := DefineTargetGroupMatcher(matcherSpec)
+		assert.Nil(err)
+
+		type testCase struct {
+			path        string
+			method      string
+			permissions []string
+		}
+		host := "api.testing.org"
+		cases := []testCase{
+			{path: "/v1/orders", method: "GET", permissions: []string{"orders:read"}},
+			{
+				path:        "/v1/orders/",
+				method:      "POST",
+				permissions: []string{"orders:write", "billing-records:write"},
+			},
+			{path: "/v1/orders/1234", method: "GET", permissions: []string{"orders:read"}},
+			// The method is not defined for the preferred path, so the other ambiguous path matches
+			{path: "/v1/orders/search", method: "DELETE", permissions: []string{"api:write"}},
+			{path: "/v1/orders/1234", method: "DELETE", permissions: []string{"api:write"}},
+			{path: "/v1/orders/1234/items", method: "GET", permissions: nil},
+			{path: "/v1/files/report.json", method: "GET", permissions: []string{"files:read"}},
+			{path: "/v1/files/report.csv", method: "GET", permissions: nil},
+			{path: "/orders", method: "GET", permissions: nil},
+		}
+		for _, oneCase := range cases {
+			matched, err := matcher.MatchRule(
+				utCtxt, RequestParam{Host: &host, Path: oneCase.path, Method: oneCase.method},
+			)
+			assert.Nil(err, "%s %s", oneCase.method, oneCase.path)
+			if oneCase.permissions == nil {
+				assert.Nil(matched, "%s %s", oneCase.method, oneCase.path)
+				continue
+			}
+			assert.NotNil(matched, "%s %s", oneCase.method, oneCase.path)
+			assert.Equal(oneCase.permissions, matched.Permissions)
+		}
+	}
+
+	// Case 1: Swagger 2 spec in JSON, with the host given
+	{
+		spec := `{
+  "swagger": "2.0",
+  "host": "legacy.testing.org",
+  "basePath": "/api/",
+  "paths": {
+    "/items/{id}": {"put": {"tags": ["items"]}, "head": {}}
+  }
+}`
+		uut, err := ConvertOpenAPIToRules([]byte(spec), "override.testing.org")
+		assert.Nil(err)
+		assert.Len(uut.Rules, 1)
+		assert.Equal("override.testing.org", uut.Rules[0].Host)
+		assert.Len(uut.Rules[0].TargetPaths, 1)
+		assert.Equal(`^/api/items/[^/]+/?$`, uut.Rules[0].TargetPaths[0].PathRegexPattern)
+		assert.Equal(
+			[]common.PermissionForAPIMethodConfig{
+				{Method: "HEAD", Permissions: []string{"api:read"}},
+				{Method: "PUT", Permissions: []string{"items:write"}},
+			},
+			uut.Rules[0].TargetPaths[0].AllowedMethods,
+		)
+		assert.Empty(uut.Ambiguous)
+	}
+
+	// Case 2: no host in the spec
+	{
+		spec := `
+openapi: 3.0.0
+paths:
+  /:
+    get: {}
+  /a.b:
+    get: {}
+`
+		uut, err := ConvertOpenAPIToRules([]byte(spec), "")
+		assert.Nil(err)
+		assert.Equal("*", uut.Rules[0].Host)
+		assert.Equal("^/$", uut.Rules[0].TargetPaths[0].PathRegexPattern)
+		assert.Equal(`^/a\.b/?$`, uut.Rules[0].TargetPaths[1].PathRegexPattern)
+	}
+
+	// Case 3: invalid specs
+	{
+		_, err := ConvertOpenAPIToRules([]byte("openapi: 3.0.0\n"), "")
+		assert.NotNil(err)
+		_, err = ConvertOpenAPIToRules([]byte("paths:\n  /a:\n    trace: {}\n"), "")
+		assert.NotNil(err)
+		_, err = ConvertOpenAPIToRules([]byte("paths: [\n"), "")
+		assert.NotNil(err)
+	}
+}
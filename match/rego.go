@@ -0,0 +1,322 @@
+package match
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/go-playground/validator/v10"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// RegoPolicySpec is a Rego policy deciding the permissions needed for a request
+type RegoPolicySpec struct {
+	// Module is the name of the policy module, i.e. its file name
+	Module string `validate:"required"`
+	// Policy is the Rego source of the policy module
+	Policy string `validate:"required"`
+	// Query is the Rego query evaluated for each request
+	Query string `validate:"required"`
+	// Headers are the request headers given to the policy, if any
+	Headers []string
+}
+
+// regoDecision is the value the Rego query produces for a matched request
+type regoDecision struct {
+	// Permissions is the list permissions needed to proceed
+	Permissions []string `json:"permissions"`
+	// Clients is the list of OAuth2 client IDs allowed to proceed directly
+	Clients []string `json:"clients"`
+}
+
+// regoPolicyMatcher implements RequestMatch by evaluating a Rego policy
+type regoPolicyMatcher struct {
+	goutils.Component
+	query string
+	// ruleName names the rule the query evaluates, i.e. the policy rule reference, or the policy
+	// package if the query is not a single reference
+	ruleName string
+	// headers are the canonical names of the request headers given to the policy
+	headers  []string
+	prepared rego.PreparedEvalQuery
+	validate *validator.Validate
+}
+
+/*
+DefineRegoPolicyMatcher defines a new RequestMatch which evaluates a Rego policy. For each
+request, the query is evaluated with the request parameters as the input document:
+
+	{
+	  "host": "...", "path": "...", "method": "...", "websocket": false, "attributes": {...},
+	  "query": {"name": ["..."]}, "headers": {...}, "scopes": [...], "user_permissions": [...]
+	}
+
+Only the request headers named in the spec are given. The query must produce an object listing the permissions needed to proceed, and optionally
+the OAuth2 clients allowed to proceed directly, i.e. {"permissions": [...], "clients": [...]}.
+If the query is undefined for a request, the request does not match.
+
+	@param spec RegoPolicySpec - the policy specification
+	@return new RequestMatch instance
+*/
+func DefineRegoPolicyMatcher(spec RegoPolicySpec) (RequestMatch, error) {
+	validate := validator.New()
+	if err := validate.Struct(&spec); err != nil {
+		return nil, err
+	}
+	logTags := log.Fields{"module": "match", "component": "rego-matcher"}
+	ruleName, err := regoRuleName(spec)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to parse Rego policy %s", spec.Module)
+		return nil, err
+	}
+	headers := make([]string, len(spec.Headers))
+	for idx, header := range spec.Headers {
+		headers[idx] = http.CanonicalHeaderKey(header)
+	}
+	prepared, err := rego.New(
+		rego.Query(spec.Query), rego.Module(spec.Module, spec.Policy),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to compile Rego policy %s", spec.Module)
+		return nil, err
+	}
+	return &regoPolicyMatcher{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		query:    spec.Query,
+		ruleName: ruleName,
+		headers:  headers,
+		prepared: prepared,
+		validate: validate,
+	}, nil
+}
+
+/*
+regoRuleName name the rule the query of a Rego policy evaluates. This is the rule reference if
+the query is a single reference, e.g. "padlock.authz.decision" for the query
+"data.padlock.authz.decision", otherwise the package of the policy.
+
+	@param spec RegoPolicySpec - the policy specification
+	@return the rule name
+*/
+func regoRuleName(spec RegoPolicySpec) (string, error) {
+	module, err := ast.ParseModule(spec.Module, spec.Policy)
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimPrefix(module.Package.Path.String(), "data.")
+	if body, err := ast.ParseBody(spec.Query); err == nil && len(body) == 1 {
+		if term, ok := body[0].Terms.(*ast.Term); ok {
+			if ref, ok := term.Value.(ast.Ref); ok && ref.HasPrefix(ast.DefaultRootRef) {
+				name = strings.TrimPrefix(ref.String(), "data.")
+			}
+		}
+	}
+	return name, nil
+}
+
+/*
+ConvertConfigToRegoPolicySpec convert a common.AuthorizationConfig into RegoPolicySpec
+
+	@param cfg *common.AuthorizationConfig -  the authorize config section
+	@return the converted RegoPolicySpec
+*/
+func ConvertConfigToRegoPolicySpec(cfg *common.AuthorizationConfig) (RegoPolicySpec, error) {
+	policy, err := os.ReadFile(cfg.Rego.PolicyFile)
+	if err != nil {
+		return RegoPolicySpec{}, fmt.Errorf(
+			"unable to read Rego policy %s: %w", cfg.Rego.PolicyFile, err,
+		)
+	}
+	return RegoPolicySpec{
+		Module:  cfg.Rego.PolicyFile,
+		Policy:  string(policy),
+		Query:   cfg.Rego.Query,
+		Headers: cfg.Rego.Headers,
+	}, nil
+}
+
+/*
+Match checks whether a request matches against defined parameters
+
+	@param ctxt context.Context - context calling this API
+	@param request RequestParam - request parameters
+	@return if a match, the list permissions needed to proceed, or an error otherwise
+*/
+func (m *regoPolicyMatcher) Match(ctxt context.Context, request RequestParam) (
+	[]string, error,
+) {
+	return permissionsOfRule(m.MatchRule(ctxt, request))
+}
+
+/*
+MatchRule checks whether a request matches against defined parameters, and report which
+rule it matched
+
+	@param ctxt context.Context - context calling this API
+	@param request RequestParam - request parameters
+	@return if a match, the matched rule, or an error otherwise
+*/
+func (m *regoPolicyMatcher) MatchRule(ctxt context.Context, request RequestParam) (
+	*MatchedRule, error,
+) {
+	logTags := m.GetLogTagsForContext(ctxt)
+	// Verify the request is considered valid
+	if err := request.validate(m.validate); err != nil {
+		log.WithError(err).WithFields(logTags).
+			WithField("check_request", request.String()).
+			Error("Invalid request check parameters")
+		return nil, err
+	}
+
+	parsedPath, err := url.Parse(request.Path)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			WithField("check_request", request.String()).
+			Error("Unable to parse request path")
+		return nil, err
+	}
+	input := map[string]interface{}{
+		"path":             request.Path,
+		"method":           request.Method,
+		"websocket":        request.WebSocket,
+		"attributes":       map[string]string{},
+		"query":            map[string][]string(parsedPath.Query()),
+		"headers":          map[string]string{},
+		"scopes":           []string{},
+		"user_permissions": []string{},
+	}
+	host := "*"
+	if request.Host != nil {
+		host = *request.Host
+		input["host"] = host
+	}
+	if request.Attributes != nil {
+		input["attributes"] = request.Attributes
+	}
+	if len(m.headers) > 0 && request.Headers != nil {
+		headers := map[string]string{}
+		for _, name := range m.headers {
+			if value, ok := request.Headers[name]; ok {
+				headers[name] = value
+			}
+		}
+		input["headers"] = headers
+	}
+	if request.Scopes != nil {
+		input["scopes"] = request.Scopes
+	}
+	if request.UserPermissions != nil {
+		input["user_permissions"] = request.UserPermissions
+	}
+
+	results, err := m.prepared.Eval(ctxt, rego.EvalInput(input))
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			WithField("check_request", request.String()).
+			Error("Failed to evaluate Rego policy")
+		return nil, err
+	}
+	// An undefined query result means the request does not match
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, nil
+	}
+	decision, err := parseRegoDecision(results[0].Expressions[0].Value)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			WithField("check_request", request.String()).
+			Error("Rego policy produced an invalid decision")
+		return nil, err
+	}
+	return &MatchedRule{
+		Host:        host,
+		PathPattern: m.ruleName,
+		Method:      request.Method,
+		Permissions: decision.Permissions,
+		Clients:     decision.Clients,
+	}, nil
+}
+
+/*
+ConditionHeaders list the request headers given to the policy
+
+	@return the canonical header names
+*/
+func (m *regoPolicyMatcher) ConditionHeaders() []string {
+	return m.headers
+}
+
+/*
+ConditionsOnUserPermissions whether the matcher decides on the permissions of the user. The
+policy may decide on them, so they are always needed.
+
+	@return whether the permissions of the user are needed
+*/
+func (m *regoPolicyMatcher) ConditionsOnUserPermissions() bool {
+	return true
+}
+
+/*
+parseRegoDecision parse the value produced by the Rego query
+
+	@param value interface{} - the query value
+	@return the decision
+*/
+func parseRegoDecision(value interface{}) (regoDecision, error) {
+	asObject, ok := value.(map[string]interface{})
+	if !ok {
+		return regoDecision{}, fmt.Errorf("decision is not an object")
+	}
+	readList := func(field string) ([]string, error) {
+		result := []string{}
+		raw, ok := asObject[field]
+		if !ok {
+			return result, nil
+		}
+		entries, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("decision field '%s' is not a list", field)
+		}
+		for _, entry := range entries {
+			asString, ok := entry.(string)
+			if !ok {
+				return nil, fmt.Errorf("decision field '%s' contains a non-string", field)
+			}
+			result = append(result, asString)
+		}
+		return result, nil
+	}
+	permissions, err := readList("permissions")
+	if err != nil {
+		return regoDecision{}, err
+	}
+	if len(permissions) == 0 {
+		return regoDecision{}, fmt.Errorf("decision lists no permissions")
+	}
+	clients, err := readList("clients")
+	if err != nil {
+		return regoDecision{}, err
+	}
+	return regoDecision{Permissions: permissions, Clients: clients}, nil
+}
+
+/*
+String returns an ASCII description of the object
+
+	@return an ASCII description of the object
+*/
+func (m *regoPolicyMatcher) String() string {
+	return fmt.Sprintf("REGO-POLICY-MATCH[%s]", m.query)
+}
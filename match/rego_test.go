@@ -0,0 +1,161 @@
+package match
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegoPolicyMatcher(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	testHost := fmt.Sprintf("%s.unit-test.org", uuid.New().String())
+
+	policy := fmt.Sprintf(`package padlock.authz
+
+decision := {"permissions": ["read"]} {
+	input.host == "%s"
+	startswith(input.path, "/reports/")
+	input.method == "GET"
+}
+
+decision := {"permissions": ["write"], "clients": ["batch-job"]} {
+	input.host == "%s"
+	startswith(input.path, "/reports/")
+	input.method == "PUT"
+	input.attributes.scheme == "https"
+}
+
+decision := {"permissions": ["export"]} {
+	input.host == "%s"
+	startswith(input.path, "/exports")
+	input.query.format[_] == "csv"
+	input.headers["X-Tenant"] == "acme"
+	input.scopes[_] == "exports:read"
+	input.user_permissions[_] == "read"
+}
+
+decision := {"permissions": "admin"} {
+	input.path == "/broken"
+}
+`, testHost, testHost, testHost)
+
+	// Case 0: invalid specs
+	{
+		_, err := DefineRegoPolicyMatcher(RegoPolicySpec{Module: "policy.rego", Policy: policy})
+		assert.NotNil(err)
+		_, err = DefineRegoPolicyMatcher(RegoPolicySpec{
+			Module: "policy.rego", Policy: "package padlock.authz\n\ndecision := {", Query: "data.padlock.authz.decision",
+		})
+		assert.NotNil(err)
+	}
+
+	uut, err := DefineRegoPolicyMatcher(RegoPolicySpec{
+		Module:  "policy.rego",
+		Policy:  policy,
+		Query:   "data.padlock.authz.decision",
+		Headers: []string{"x-tenant"},
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"X-Tenant"}, ConditionHeadersOf(uut))
+	assert.True(NeedsUserPermissions(uut))
+
+	type testCase struct {
+		request             RequestParam
+		expectedErr         bool
+		expectedPermissions []string
+		expectedClients     []string
+	}
+
+	cases := []testCase{
+		// Case 1: matched by the policy
+		{
+			request:             RequestParam{Host: &testHost, Path: "/reports/123", Method: "GET"},
+			expectedPermissions: []string{"read"},
+		},
+		// Case 2: matched by the policy using request attributes
+		{
+			request: RequestParam{
+				Host: &testHost, Path: "/reports/123", Method: "PUT",
+				Attributes: map[string]string{"scheme": "https"},
+			},
+			expectedPermissions: []string{"write"},
+			expectedClients:     []string{"batch-job"},
+		},
+		// Case 3: policy undefined for the request
+		{
+			request: RequestParam{Host: &testHost, Path: "/reports/123", Method: "PUT"},
+		},
+		// Case 4: policy undefined without a host
+		{
+			request: RequestParam{Path: "/reports/123", Method: "GET"},
+		},
+		// Case 5: matched by the policy using the query, headers, scopes, and user permissions
+		{
+			request: RequestParam{
+				Host: &testHost, Path: "/exports?format=csv", Method: "GET",
+				Headers:         map[string]string{"X-Tenant": "acme", "X-Other": "skip"},
+				Scopes:          []string{"exports:read"},
+				UserPermissions: []string{"read", "write"},
+			},
+			expectedPermissions: []string{"export"},
+		},
+		// Case 6: policy undefined without the user permissions
+		{
+			request: RequestParam{
+				Host: &testHost, Path: "/exports?format=csv", Method: "GET",
+				Headers: map[string]string{"X-Tenant": "acme"},
+				Scopes:  []string{"exports:read"},
+			},
+		},
+		// Case 7: policy produced an invalid decision
+		{
+			request:     RequestParam{Host: &testHost, Path: "/broken", Method: "GET"},
+			expectedErr: true,
+		},
+		// Case 8: invalid request
+		{
+			request:     RequestParam{Host: &testHost, Path: "/reports/123", Method: "FETCH"},
+			expectedErr: true,
+		},
+	}
+
+	for idx, oneTest := range cases {
+		rule, err := uut.MatchRule(context.Background(), oneTest.request)
+		if oneTest.expectedErr {
+			assert.NotNil(err, "Case %d", idx+1)
+			continue
+		}
+		assert.Nil(err, "Case %d", idx+1)
+		if oneTest.expectedPermissions == nil {
+			assert.Nil(rule, "Case %d", idx+1)
+			continue
+		}
+		assert.NotNil(rule, "Case %d", idx+1)
+		assert.Equal(testHost, rule.Host, "Case %d", idx+1)
+		assert.Equal("padlock.authz.decision", rule.PathPattern, "Case %d", idx+1)
+		assert.Equal(oneTest.expectedPermissions, rule.Permissions, "Case %d", idx+1)
+		if oneTest.expectedClients == nil {
+			assert.Empty(rule.Clients, "Case %d", idx+1)
+		} else {
+			assert.Equal(oneTest.expectedClients, rule.Clients, "Case %d", idx+1)
+		}
+		permissions, err := uut.Match(context.Background(), oneTest.request)
+		assert.Nil(err, "Case %d", idx+1)
+		assert.Equal(oneTest.expectedPermissions, permissions, "Case %d", idx+1)
+	}
+
+	// Case 9: the rule is named by the policy package if the query is not a single reference
+	{
+		name, err := regoRuleName(RegoPolicySpec{
+			Module: "policy.rego", Policy: policy, Query: "x := data.padlock.authz.decision",
+		})
+		assert.Nil(err)
+		assert.Equal("padlock.authz", name)
+	}
+}
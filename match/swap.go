@@ -0,0 +1,110 @@
+package match
+
+import (
+	"context"
+	"sync"
+)
+
+// SwappableMatcher is a RequestMatch whose underlying matcher can be replaced while in use,
+// i.e. when the authorization rules are updated at runtime
+type SwappableMatcher interface {
+	RequestMatch
+
+	/*
+		Swap replace the underlying matcher. Requests being matched finish with the previous one.
+
+		 @param matcher RequestMatch - the new matcher
+	*/
+	Swap(matcher RequestMatch)
+}
+
+// swappableMatcherImpl implements SwappableMatcher
+type swappableMatcherImpl struct {
+	lock    sync.RWMutex
+	current RequestMatch
+}
+
+/*
+DefineSwappableMatcher defines a new SwappableMatcher
+
+	@param matcher RequestMatch - the initial matcher
+	@return new SwappableMatcher instance
+*/
+func DefineSwappableMatcher(matcher RequestMatch) SwappableMatcher {
+	return &swappableMatcherImpl{lock: sync.RWMutex{}, current: matcher}
+}
+
+/*
+Swap replace the underlying matcher. Requests being matched finish with the previous one.
+
+	@param matcher RequestMatch - the new matcher
+*/
+func (m *swappableMatcherImpl) Swap(matcher RequestMatch) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.current = matcher
+}
+
+/*
+active fetch the matcher currently in use
+
+	@return the matcher
+*/
+func (m *swappableMatcherImpl) active() RequestMatch {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.current
+}
+
+/*
+Match checks whether a request matches against defined parameters
+
+	@param ctxt context.Context - context calling this API
+	@param request RequestParam - request parameters
+	@return if a match, the list permissions needed to proceed, or an error otherwise
+*/
+func (m *swappableMatcherImpl) Match(ctxt context.Context, request RequestParam) ([]string, error) {
+	return m.active().Match(ctxt, request)
+}
+
+/*
+MatchRule checks whether a request matches against defined parameters, and report which
+rule it matched
+
+	@param ctxt context.Context - context calling this API
+	@param request RequestParam - request parameters
+	@return if a match, the matched rule, or an error otherwise
+*/
+func (m *swappableMatcherImpl) MatchRule(
+	ctxt context.Context, request RequestParam,
+) (*MatchedRule, error) {
+	return m.active().MatchRule(ctxt, request)
+}
+
+/*
+String returns an ASCII description of the object
+
+	@return an ASCII description of the object
+*/
+func (m *swappableMatcherImpl) String() string {
+	return m.active().String()
+}
+
+/*
+ConditionHeaders list the request headers the matcher currently in use has conditions on
+
+	@return the canonical header names
+*/
+func (m *swappableMatcherImpl) ConditionHeaders() []string {
+	return ConditionHeadersOf(m.active())
+}
+
+/*
+ConditionsOnUserPermissions whether the matcher currently in use decides on the permissions of
+the user
+
+	@return whether the permissions of the user are needed
+*/
+func (m *swappableMatcherImpl) ConditionsOnUserPermissions() bool {
+	return NeedsUserPermissions(m.active())
+}
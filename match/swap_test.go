@@ -0,0 +1,50 @@
+package match
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwappableMatcher(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	defineMatcher := func(permission string) RequestMatch {
+		matcher, err := DefineTargetGroupMatcher(TargetGroupSpec{
+			AllowedHosts: map[string]TargetHostSpec{
+				"*": {
+					TargetHost: "*",
+					AllowedPathsForHost: []TargetPathSpec{
+						{
+							PathPattern:          `^/.+$`,
+							PermissionsForMethod: map[string][]string{"GET": {permission}},
+						},
+					},
+				},
+			},
+		})
+		assert.Nil(err)
+		return matcher
+	}
+
+	utCtxt := context.Background()
+	host := "unit-test.org"
+	request := RequestParam{Host: &host, Path: "/data", Method: "GET"}
+
+	uut := DefineSwappableMatcher(defineMatcher("read"))
+	permissions, err := uut.Match(utCtxt, request)
+	assert.Nil(err)
+	assert.Equal([]string{"read"}, permissions)
+
+	// Requests are matched with the new matcher once swapped
+	uut.Swap(defineMatcher("view"))
+	permissions, err = uut.Match(utCtxt, request)
+	assert.Nil(err)
+	assert.Equal([]string{"view"}, permissions)
+	rule, err := uut.MatchRule(utCtxt, request)
+	assert.Nil(err)
+	assert.Equal([]string{"view"}, rule.Permissions)
+}
@@ -3,6 +3,7 @@ package match
 import (
 	"context"
 	"fmt"
+	"net/url"
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/common"
@@ -14,8 +15,18 @@ import (
 type targetPathMatcher struct {
 	goutils.Component
 	TargetPathSpec
-	regex    common.RegexCheck
-	validate *validator.Validate
+	targetHost string
+	regex      common.RegexCheck
+	// attributeRegex is the REGEX checks of the request attributes, by attribute name
+	attributeRegex map[string]common.RegexCheck
+	// queryRegex is the REGEX checks of the query parameters, by parameter name
+	queryRegex map[string]common.RegexCheck
+	// headerRegex is the REGEX checks of the request headers, by canonical header name
+	headerRegex map[string]common.RegexCheck
+	// userAttributeRegex is the REGEX checks of the user attributes, by method then attribute
+	// name
+	userAttributeRegex map[string]map[string]common.RegexCheck
+	validate           *validator.Validate
 }
 
 /*
@@ -34,6 +45,24 @@ func defineTargetPathMatcher(targetHost string, spec TargetPathSpec) (*targetPat
 	if err != nil {
 		return nil, err
 	}
+	attributeRegex, err := compileAttributeRegex(spec.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	queryRegex, err := compileAttributeRegex(spec.QueryParams)
+	if err != nil {
+		return nil, err
+	}
+	headerRegex, err := compileAttributeRegex(spec.Headers)
+	if err != nil {
+		return nil, err
+	}
+	userAttributeRegex := map[string]map[string]common.RegexCheck{}
+	for method, patterns := range spec.UserAttributesForMethod {
+		if userAttributeRegex[method], err = compileAttributeRegex(patterns); err != nil {
+			return nil, err
+		}
+	}
 	logTags := log.Fields{
 		"module":              "match",
 		"component":           "path-matcher",
@@ -43,7 +72,7 @@ func defineTargetPathMatcher(targetHost string, spec TargetPathSpec) (*targetPat
 
 	// Verify that the methods listed in the PermissionsForMethod are permitted
 	type methodCheck struct {
-		Methods []string `validate:"required,dive,oneof=GET HEAD PUT POST PATCH DELETE OPTIONS *"`
+		Methods []string `validate:"required,dive,oneof=GET HEAD PUT POST PATCH DELETE OPTIONS WEBSOCKET *"`
 	}
 	{
 		check := methodCheck{Methods: make([]string, 0)}
@@ -63,9 +92,14 @@ func defineTargetPathMatcher(targetHost string, spec TargetPathSpec) (*targetPat
 				common.ModifyLogMetadataByAccessAuthorizeParam,
 			},
 		},
-		TargetPathSpec: spec,
-		regex:          regex,
-		validate:       validate,
+		TargetPathSpec:     spec,
+		targetHost:         targetHost,
+		regex:              regex,
+		attributeRegex:     attributeRegex,
+		queryRegex:         queryRegex,
+		headerRegex:        headerRegex,
+		userAttributeRegex: userAttributeRegex,
+		validate:           validate,
 	}, nil
 }
 
@@ -74,16 +108,43 @@ func (m *targetPathMatcher) checkPath(requestPath string) (bool, error) {
 	return m.regex.Match([]byte(requestPath))
 }
 
+/*
+checkConditions helper function to check whether the request attributes, query parameters,
+and headers match this instance
+
+	@param request RequestParam - request parameters
+	@return whether the request matches, and if not, which condition it missed
+*/
+func (m *targetPathMatcher) checkConditions(request RequestParam) (bool, string, error) {
+	if matched, err := attributesMatch(m.attributeRegex, request.Attributes); err != nil ||
+		!matched {
+		return false, "attributes", err
+	}
+	if len(m.queryRegex) > 0 {
+		parsed, err := url.Parse(request.Path)
+		if err != nil {
+			return false, "query", err
+		}
+		if matched, err := queryParamsMatch(m.queryRegex, parsed.Query()); err != nil || !matched {
+			return false, "query", err
+		}
+	}
+	if matched, err := attributesMatch(m.headerRegex, request.Headers); err != nil || !matched {
+		return false, "headers", err
+	}
+	return true, "", nil
+}
+
 /*
 match is core logic for targetPathMatcher.Match
 
 	@param ctxt context.Context - context calling this API
 	@param request RequestParam - request parameters
 	@param skipPathCheck bool - whether to skip the path REGEX matching
-	@return if a match, the list permissions needed to proceed, or an error otherwise
+	@return if a match, the matched rule, or an error otherwise
 */
 func (m *targetPathMatcher) match(ctxt context.Context, request RequestParam, skipPathCheck bool) (
-	[]string, error,
+	*MatchedRule, error,
 ) {
 	logTags := m.GetLogTagsForContext(ctxt)
 	// Verify the request is considered valid
@@ -111,11 +172,36 @@ func (m *targetPathMatcher) match(ctxt context.Context, request RequestParam, sk
 			return nil, nil
 		}
 	}
+	// Verify the request attributes, query parameters, and headers match
+	conditionsMatch, miss, err := m.checkConditions(request)
+	if err != nil {
+		log.WithError(err).
+			WithFields(logTags).
+			WithField("check_request", request.String()).
+			Errorf("Failed to execute %s REGEX check", miss)
+		return nil, err
+	}
+	if !conditionsMatch {
+		log.WithFields(logTags).
+			WithField("check_request", request.String()).
+			WithField("miss", miss).
+			Debug("MISMATCH")
+		return nil, nil
+	}
 	// Verify method is known
-	permissionsForMethod, ok := m.PermissionsForMethod[request.Method]
+	matchedMethod := request.Method
+	permissionsForMethod, ok := m.PermissionsForMethod[matchedMethod]
+	if request.WebSocket {
+		// WebSocket upgrades may be given distinct permissions
+		if wsPermissions, wsOK := m.PermissionsForMethod[WebSocketMethod]; wsOK {
+			matchedMethod = WebSocketMethod
+			permissionsForMethod, ok = wsPermissions, wsOK
+		}
+	}
 	if !ok {
 		// Check whether wildcard entry was provided
-		permissionsForMethod, ok = m.PermissionsForMethod["*"]
+		matchedMethod = "*"
+		permissionsForMethod, ok = m.PermissionsForMethod[matchedMethod]
 		if !ok {
 			log.WithFields(logTags).
 				WithField("check_request", request.String()).
@@ -125,7 +211,20 @@ func (m *targetPathMatcher) match(ctxt context.Context, request RequestParam, sk
 		}
 	}
 	log.WithFields(logTags).WithField("check_request", request.String()).Debug("MATCH")
-	return permissionsForMethod, nil
+	return &MatchedRule{
+		Host:                m.targetHost,
+		PathPattern:         m.PathPattern,
+		Method:              matchedMethod,
+		Permissions:         permissionsForMethod,
+		Attributes:          m.Attributes,
+		QueryParams:         m.QueryParams,
+		Headers:             m.Headers,
+		Clients:             m.ClientsForMethod[matchedMethod],
+		UserAttributes:      m.UserAttributesForMethod[matchedMethod],
+		ServiceAccountsOnly: m.ServiceAccountsOnlyForMethod[matchedMethod],
+		Scopes:              m.ScopesForMethod[matchedMethod],
+		userAttributeRegex:  m.userAttributeRegex[matchedMethod],
+	}, nil
 }
 
 /*
@@ -136,6 +235,20 @@ Match checks whether a request matches against defined parameters
 	@return if a match, the list permissions needed to proceed, or an error otherwise
 */
 func (m *targetPathMatcher) Match(ctxt context.Context, request RequestParam) ([]string, error) {
+	return permissionsOfRule(m.MatchRule(ctxt, request))
+}
+
+/*
+MatchRule checks whether a request matches against defined parameters, and report which
+rule it matched
+
+	@param ctxt context.Context - context calling this API
+	@param request RequestParam - request parameters
+	@return if a match, the matched rule, or an error otherwise
+*/
+func (m *targetPathMatcher) MatchRule(
+	ctxt context.Context, request RequestParam,
+) (*MatchedRule, error) {
 	return m.match(ctxt, request, false)
 }
 
@@ -170,4 +170,42 @@ func TestTargetPathMatcher(t *testing.T) {
 			assert.Equalf(oneCase.expectedPermissions, permissions, oneCase.request.String())
 		}
 	}
+
+	// Case 3: WebSocket upgrade matching
+	{
+		spec := TargetPathSpec{
+			PathPattern: `^/events$`,
+			PermissionsForMethod: map[string][]string{
+				"GET":       {"spec3.0"},
+				"WEBSOCKET": {"spec3.1"},
+			},
+		}
+		uut, err := defineTargetPathMatcher("unit-test", spec)
+		assert.Nil(err)
+
+		rule, err := uut.MatchRule(
+			context.Background(), RequestParam{Path: "/events", Method: "GET", WebSocket: true},
+		)
+		assert.Nil(err)
+		assert.NotNil(rule)
+		assert.Equal(WebSocketMethod, rule.Method)
+		assert.Equal([]string{"spec3.1"}, rule.Permissions)
+
+		permissions, err := uut.Match(
+			context.Background(), RequestParam{Path: "/events", Method: "GET"},
+		)
+		assert.Nil(err)
+		assert.Equal([]string{"spec3.0"}, permissions)
+
+		// Without distinct permissions, the upgrade matches as a GET
+		uut, err = defineTargetPathMatcher("unit-test", TargetPathSpec{
+			PathPattern: `^/events$`, PermissionsForMethod: map[string][]string{"GET": {"spec3.0"}},
+		})
+		assert.Nil(err)
+		permissions, err = uut.Match(
+			context.Background(), RequestParam{Path: "/events", Method: "GET", WebSocket: true},
+		)
+		assert.Nil(err)
+		assert.Equal([]string{"spec3.0"}, permissions)
+	}
 }
@@ -0,0 +1,233 @@
+// Package middleware provides HTTP middleware which protects routes with Padlock
+// authorization checks
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/client"
+	"github.com/apex/log"
+)
+
+// DefaultCacheMaxEntries is the default max number of decisions to remember
+const DefaultCacheMaxEntries = 10000
+
+// FailurePolicy defines how to handle requests when Padlock can't be reached
+type FailurePolicy string
+
+const (
+	// FailClosed deny the request if Padlock can't be reached
+	FailClosed FailurePolicy = "fail-closed"
+	// FailOpen allow the request if Padlock can't be reached, times out, or responds with 5xx.
+	// Denials, including every 4xx response, are never overridden.
+	FailOpen FailurePolicy = "fail-open"
+)
+
+// HeaderMapping defines which headers of the incoming request carry the caller's parameters.
+// These are typically set by an upstream authenticating proxy.
+type HeaderMapping struct {
+	// UserID is the header containing the user ID. Required.
+	UserID string
+	// Username is the header containing the username, if any
+	Username string
+	// FirstName is the header containing the first name / given name, if any
+	FirstName string
+	// LastName is the header containing the last name / surname / family name, if any
+	LastName string
+	// Email is the header containing the email, if any
+	Email string
+	// RequestID is the header containing the request ID, if any. It is forwarded to Padlock
+	// so the checks can be matched against the Padlock logs.
+	RequestID string
+}
+
+// Config is the Guard config
+type Config struct {
+	// Authorizer is the Padlock authorization API client
+	Authorizer client.AuthorizationClient
+	// Headers defines which headers of the incoming request carry the caller's parameters
+	Headers HeaderMapping
+	// OnFailure is the policy for when Padlock can't be reached. Defaults to FailClosed.
+	OnFailure FailurePolicy
+	// CacheTTL is how long to remember a decision. 0 disables caching.
+	CacheTTL time.Duration
+	// CacheMaxEntries is the max number of decisions to remember. When full, the least
+	// recently used decision is dropped. Defaults to DefaultCacheMaxEntries.
+	CacheMaxEntries int
+}
+
+// Guard protects HTTP routes with Padlock authorization checks
+type Guard interface {
+	/*
+		Allowed check whether the request is allowed, applying the failure policy if Padlock
+		can't be reached
+
+		 @param r *http.Request - the incoming request
+		 @return whether the request is allowed
+	*/
+	Allowed(r *http.Request) bool
+
+	/*
+		Handler wrap a handler so only allowed requests reach it. Denied requests receive
+		a 403 response.
+
+		 @param next http.Handler - the handler to protect
+		 @return the protected handler
+	*/
+	Handler(next http.Handler) http.Handler
+}
+
+// guardImpl implements Guard
+type guardImpl struct {
+	goutils.Component
+	cfg   Config
+	cache apis.DecisionCache
+}
+
+/*
+DefineGuard define a new Guard
+
+	@param cfg Config - the Guard config
+	@return new Guard instance
+*/
+func DefineGuard(cfg Config) (Guard, error) {
+	if cfg.Authorizer == nil {
+		return nil, fmt.Errorf("authorization API client is required")
+	}
+	if cfg.Headers.UserID == "" {
+		return nil, fmt.Errorf("user ID header is required")
+	}
+	switch cfg.OnFailure {
+	case "":
+		cfg.OnFailure = FailClosed
+	case FailClosed, FailOpen:
+	default:
+		return nil, fmt.Errorf("unknown failure policy '%s'", cfg.OnFailure)
+	}
+	if cfg.CacheMaxEntries < 0 {
+		return nil, fmt.Errorf("cache max entries can't be negative")
+	}
+	if cfg.CacheMaxEntries == 0 {
+		cfg.CacheMaxEntries = DefaultCacheMaxEntries
+	}
+	var cache apis.DecisionCache
+	if cfg.CacheTTL > 0 {
+		cache = apis.DefineDecisionCache(cfg.CacheTTL, cfg.CacheMaxEntries)
+	}
+	return &guardImpl{
+		Component: goutils.Component{
+			LogTags: log.Fields{"module": "middleware", "component": "padlock-guard"},
+		},
+		cfg:   cfg,
+		cache: cache,
+	}, nil
+}
+
+/*
+Allowed check whether the request is allowed, applying the failure policy if Padlock
+can't be reached
+
+	@param r *http.Request - the incoming request
+	@return whether the request is allowed
+*/
+func (g *guardImpl) Allowed(r *http.Request) bool {
+	logTags := g.GetLogTagsForContext(r.Context())
+
+	request := client.AuthorizationRequest{
+		Host:   r.Host,
+		Path:   cleanPath(r.URL.Path),
+		Method: r.Method,
+		UserID: r.Header.Get(g.cfg.Headers.UserID),
+	}
+	if request.UserID == "" {
+		log.WithFields(logTags).Debugf("Request missing header '%s'", g.cfg.Headers.UserID)
+		return false
+	}
+	optional := map[string]*string{
+		g.cfg.Headers.Username:  &request.Username,
+		g.cfg.Headers.FirstName: &request.FirstName,
+		g.cfg.Headers.LastName:  &request.LastName,
+		g.cfg.Headers.Email:     &request.Email,
+	}
+	for header, value := range optional {
+		if header != "" {
+			*value = r.Header.Get(header)
+		}
+	}
+
+	cacheKey := apis.DecisionKey{
+		UserID: request.UserID, Host: request.Host, Path: request.Path, Method: request.Method,
+	}
+	if g.cache != nil {
+		if decision, ok := g.cache.Lookup(r.Context(), cacheKey, time.Now()); ok {
+			return decision.Allowed
+		}
+	}
+
+	ctxt := r.Context()
+	if g.cfg.Headers.RequestID != "" {
+		if requestID := r.Header.Get(g.cfg.Headers.RequestID); requestID != "" {
+			ctxt = client.WithRequestID(ctxt, requestID)
+		}
+	}
+	allowed, err := g.cfg.Authorizer.Allow(ctxt, request)
+	if err != nil {
+		if ctxt.Err() == context.Canceled {
+			return false
+		}
+		// A 4xx is Padlock's answer, not a failure to reach it
+		if client.IsDenied(err) {
+			log.WithError(err).WithFields(logTags).Debug("Padlock denied the request")
+			return false
+		}
+		log.WithError(err).WithFields(logTags).
+			Errorf("Padlock authorization check failed, applying %s policy", g.cfg.OnFailure)
+		return g.cfg.OnFailure == FailOpen
+	}
+	if g.cache != nil {
+		g.cache.Record(r.Context(), cacheKey, apis.Decision{Allowed: allowed}, time.Now())
+	}
+	return allowed
+}
+
+/*
+cleanPath normalize a request path, so equivalent paths are checked, and remembered, as one.
+A trailing slash is kept.
+
+	@param reqPath string - the request path
+	@return the normalized path
+*/
+func cleanPath(reqPath string) string {
+	if reqPath == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + reqPath)
+	if strings.HasSuffix(reqPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+/*
+Handler wrap a handler so only allowed requests reach it. Denied requests receive
+a 403 response.
+
+	@param next http.Handler - the handler to protect
+	@return the protected handler
+*/
+func (g *guardImpl) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.Allowed(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/client"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	paramHeaders := common.AuthorizeRequestParamLocConfig{
+		Host:      "X-Forwarded-Host",
+		Path:      "X-Forwarded-Uri",
+		Method:    "X-Forwarded-Method",
+		UserID:    "X-Caller-UserID",
+		Username:  "X-Caller-Username",
+		FirstName: "X-Caller-Firstname",
+		LastName:  "X-Caller-Lastname",
+		Email:     "X-Caller-Email",
+	}
+
+	// Fake Padlock authorization server
+	lock := sync.Mutex{}
+	checks := 0
+	available := true
+	requestIDs := []string{}
+	allowedUser := uuid.NewString()
+	padlock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		checks++
+		requestIDs = append(requestIDs, r.Header.Get(client.DefaultRequestIDHeader))
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.Header.Get(paramHeaders.Path) {
+		case "/no-rule":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case "/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if r.Header.Get(paramHeaders.UserID) == allowedUser &&
+			r.Header.Get(paramHeaders.Path) == "/allowed" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer padlock.Close()
+
+	authorizer, err := client.DefineAuthorizationClient(
+		client.Config{BaseURL: padlock.URL}, paramHeaders,
+	)
+	assert.Nil(err)
+
+	headers := HeaderMapping{UserID: "X-User-ID", RequestID: "X-Request-ID"}
+
+	protected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	type testCase struct {
+		path, userID string
+		expected     int
+	}
+	executeTest := func(guard Guard, tc testCase) string {
+		rid := uuid.NewString()
+		req, err := http.NewRequest("GET", tc.path, nil)
+		assert.Nil(err)
+		req.Host = "unit-test.org"
+		if tc.userID != "" {
+			req.Header.Add(headers.UserID, tc.userID)
+		}
+		req.Header.Add(headers.RequestID, rid)
+		respRecorder := httptest.NewRecorder()
+		guard.Handler(protected).ServeHTTP(respRecorder, req)
+		assert.Equal(tc.expected, respRecorder.Code)
+		return rid
+	}
+
+	// Case 0: invalid configs
+	{
+		_, err := DefineGuard(Config{Headers: headers})
+		assert.NotNil(err)
+		_, err = DefineGuard(Config{Authorizer: authorizer})
+		assert.NotNil(err)
+		_, err = DefineGuard(Config{Authorizer: authorizer, Headers: headers, OnFailure: "maybe"})
+		assert.NotNil(err)
+		_, err = DefineGuard(Config{Authorizer: authorizer, Headers: headers, CacheMaxEntries: -1})
+		assert.NotNil(err)
+	}
+
+	// Case 1: basic checks without caching
+	{
+		uut, err := DefineGuard(Config{Authorizer: authorizer, Headers: headers})
+		assert.Nil(err)
+		rid := executeTest(uut, testCase{path: "/allowed", userID: allowedUser, expected: http.StatusOK})
+		lock.Lock()
+		assert.Equal(rid, requestIDs[len(requestIDs)-1])
+		lock.Unlock()
+		executeTest(uut, testCase{path: "/denied", userID: allowedUser, expected: http.StatusForbidden})
+		executeTest(uut, testCase{path: "/allowed", userID: uuid.NewString(), expected: http.StatusForbidden})
+		// Missing user ID is denied without asking Padlock
+		lock.Lock()
+		checksBefore := checks
+		lock.Unlock()
+		executeTest(uut, testCase{path: "/allowed", expected: http.StatusForbidden})
+		lock.Lock()
+		assert.Equal(checksBefore, checks)
+		lock.Unlock()
+	}
+
+	// Case 2: decisions are cached
+	{
+		uut, err := DefineGuard(Config{
+			Authorizer: authorizer, Headers: headers, CacheTTL: time.Millisecond * 100,
+		})
+		assert.Nil(err)
+		lock.Lock()
+		checks = 0
+		lock.Unlock()
+		executeTest(uut, testCase{path: "/allowed", userID: allowedUser, expected: http.StatusOK})
+		executeTest(uut, testCase{path: "/allowed", userID: allowedUser, expected: http.StatusOK})
+		executeTest(uut, testCase{path: "/denied", userID: allowedUser, expected: http.StatusForbidden})
+		executeTest(uut, testCase{path: "/denied", userID: allowedUser, expected: http.StatusForbidden})
+		lock.Lock()
+		assert.Equal(2, checks)
+		lock.Unlock()
+		time.Sleep(time.Millisecond * 150)
+		executeTest(uut, testCase{path: "/allowed", userID: allowedUser, expected: http.StatusOK})
+		lock.Lock()
+		assert.Equal(3, checks)
+		lock.Unlock()
+	}
+
+	// Case 3: decision cache is bounded, and keyed on the normalized path
+	{
+		uut, err := DefineGuard(Config{
+			Authorizer: authorizer, Headers: headers, CacheTTL: time.Minute, CacheMaxEntries: 2,
+		})
+		assert.Nil(err)
+		lock.Lock()
+		checks = 0
+		lock.Unlock()
+		executeTest(uut, testCase{path: "/allowed", userID: allowedUser, expected: http.StatusOK})
+		executeTest(uut, testCase{path: "/./allowed", userID: allowedUser, expected: http.StatusOK})
+		executeTest(uut, testCase{path: "/x/../allowed", userID: allowedUser, expected: http.StatusOK})
+		lock.Lock()
+		assert.Equal(1, checks)
+		lock.Unlock()
+		executeTest(uut, testCase{path: "/denied", userID: allowedUser, expected: http.StatusForbidden})
+		executeTest(uut, testCase{path: "/other", userID: allowedUser, expected: http.StatusForbidden})
+		lock.Lock()
+		assert.Equal(3, checks)
+		lock.Unlock()
+		// "/allowed" is the least recently used, and was dropped
+		executeTest(uut, testCase{path: "/allowed", userID: allowedUser, expected: http.StatusOK})
+		lock.Lock()
+		assert.Equal(4, checks)
+		lock.Unlock()
+	}
+
+	// Case 4: 4xx responses are denials, even when failing open
+	{
+		uut, err := DefineGuard(Config{
+			Authorizer: authorizer, Headers: headers, OnFailure: FailOpen,
+		})
+		assert.Nil(err)
+		executeTest(uut, testCase{path: "/no-rule", userID: allowedUser, expected: http.StatusForbidden})
+		executeTest(uut, testCase{path: "/limited", userID: allowedUser, expected: http.StatusForbidden})
+		executeTest(uut, testCase{path: "/denied", userID: allowedUser, expected: http.StatusForbidden})
+	}
+
+	// Case 5: failure policies
+	lock.Lock()
+	available = false
+	lock.Unlock()
+	{
+		uut, err := DefineGuard(Config{Authorizer: authorizer, Headers: headers})
+		assert.Nil(err)
+		executeTest(uut, testCase{path: "/allowed", userID: allowedUser, expected: http.StatusForbidden})
+	}
+	{
+		uut, err := DefineGuard(Config{
+			Authorizer: authorizer, Headers: headers, OnFailure: FailOpen,
+		})
+		assert.Nil(err)
+		executeTest(uut, testCase{path: "/denied", userID: allowedUser, expected: http.StatusOK})
+	}
+}
@@ -1,21 +1,45 @@
 package models
 
 import (
+	"errors"
 	"time"
 )
 
+// Account types of a user, for filtering users by
+const (
+	// AccountTypeUser is a human user
+	AccountTypeUser = "user"
+	// AccountTypeServiceAccount is a service account
+	AccountTypeServiceAccount = "service_account"
+)
+
+// ErrUserNotFound is returned when the requested user is not on record
+var ErrUserNotFound = errors.New("unknown user")
+
+// ErrGroupNotFound is returned when the requested group is not on record
+var ErrGroupNotFound = errors.New("unknown group")
+
+// ErrAPIKeyNotFound is returned when the requested API key is not on record
+var ErrAPIKeyNotFound = errors.New("unknown API key")
+
 // UserConfig is user create / update parameters
 type UserConfig struct {
 	// UserID is the user's ID
 	UserID string `json:"user_id" gorm:"uniqueIndex" validate:"required,user_id"`
 	// UserName is the username
-	Username *string `json:"username,omitempty" validate:"omitempty,username"`
+	Username *string `json:"username,omitempty" gorm:"index" validate:"omitempty,username"`
 	// Email is the user's email
-	Email *string `json:"email,omitempty" validate:"omitempty,email"`
+	Email *string `json:"email,omitempty" gorm:"index" validate:"omitempty,email"`
 	// FirstName is the user's first name / given name
 	FirstName *string `json:"first_name,omitempty" validate:"omitempty,personal_name"`
 	// LastName is the user's last name / surname / family name
 	LastName *string `json:"last_name,omitempty" validate:"omitempty,personal_name"`
+	// Attributes are arbitrary named attributes of the user, which authorization rules may
+	// place conditions on
+	Attributes map[string]string `json:"attributes,omitempty" gorm:"serializer:json;type:jsonb" validate:"omitempty,dive,keys,required,endkeys"`
+	// ServiceAccount marks the user as a service account, i.e. a machine identity rather than
+	// a person. The user ID of a service account must be a valid service account ID.
+	ServiceAccount bool `json:"service_account,omitempty" gorm:"index;not null;default:false"`
 }
 
 // UserInfo is information regarding a user
@@ -32,4 +56,61 @@ type UserDetails struct {
 	UserInfo
 	// Roles are the roles associated with the user
 	Roles []string `json:"roles"`
+	// Permissions are the permissions granted directly to the user, in addition to those
+	// of its roles
+	Permissions []string `json:"permissions"`
+	// Groups are the groups the user is a member of
+	Groups []string `json:"groups"`
+	// GroupRoles are the roles the user holds through its groups
+	GroupRoles []string `json:"group_roles"`
+}
+
+// GroupInfo is information regarding a group of users
+type GroupInfo struct {
+	// CreatedAt is when the group entry is created
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is when the group entry was last updated
+	UpdatedAt time.Time `json:"updated_at"`
+	// GroupName is the group's name. It follows the same format as a role name.
+	GroupName string `json:"group_name" gorm:"uniqueIndex" validate:"required,role_name"`
+}
+
+// GroupDetails is information regarding a group with its roles and members
+type GroupDetails struct {
+	GroupInfo
+	// Roles are the roles the members of the group hold through the group
+	Roles []string `json:"roles"`
+	// Users are the IDs of the members of the group
+	Users []string `json:"users"`
+}
+
+// APIKeyInfo is information regarding an API key of a user. The key itself is not recorded.
+type APIKeyInfo struct {
+	// CreatedAt is when the API key entry is created
+	CreatedAt time.Time `json:"created_at"`
+	// KeyID is the ID of the API key, to refer to the key without revealing it
+	KeyID string `json:"key_id" gorm:"uniqueIndex" validate:"required"`
+	// UserID is the ID of the user the API key authenticates as
+	UserID string `json:"user_id" gorm:"index" validate:"required,user_id"`
+	// Description describes the purpose of the API key
+	Description *string `json:"description,omitempty"`
+	// ExpiresAt if set, is when the API key expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// UserSearchFilter is the parameters for searching for users. Empty fields are not used
+// for filtering, and the filters are combined with AND.
+type UserSearchFilter struct {
+	// UserIDPrefix only match users whose user ID starts with this prefix
+	UserIDPrefix string `json:"user_id_prefix,omitempty"`
+	// Username only match users with this username
+	Username string `json:"username,omitempty"`
+	// Email only match users with this email
+	Email string `json:"email,omitempty"`
+	// Role only match users with this role
+	Role string `json:"role,omitempty"`
+	// AccountType only match users of this account type
+	AccountType string `json:"account_type,omitempty" validate:"omitempty,oneof=user service_account"`
+	// Limit is the max number of users to return. Zero means no limit.
+	Limit int `json:"limit,omitempty" validate:"gte=0"`
 }
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"gorm.io/gorm"
+)
+
+// DecisionRecord is one recorded authorization decision
+type DecisionRecord struct {
+	// Timestamp is when the decision was made
+	Timestamp time.Time `json:"timestamp" gorm:"index"`
+	// UserID is the ID of the user the request was authorized as. Empty for anonymous requests.
+	UserID string `json:"user_id,omitempty" gorm:"index"`
+	// Impersonator is the ID of the caller, if the request was authorized as another user
+	Impersonator string `json:"impersonator,omitempty"`
+	// Host is the host of the request
+	Host string `json:"host"`
+	// Path is the absolute path of the request
+	Path string `json:"path"`
+	// Method is the method of the request
+	Method string `json:"method"`
+	// RuleHost is the host of the authorization rule the request matched
+	RuleHost string `json:"rule_host,omitempty"`
+	// RulePathPattern is the path pattern of the authorization rule the request matched
+	RulePathPattern string `json:"rule_path_pattern,omitempty"`
+	// RuleMethod is the method of the authorization rule the request matched
+	RuleMethod string `json:"rule_method,omitempty"`
+	// Allowed whether the request was allowed
+	Allowed bool `json:"allowed"`
+	// Code is the error code of the denial. In dry-run mode, a request may be allowed with
+	// the code of the denial it would have received.
+	Code string `json:"code,omitempty"`
+}
+
+// DecisionHistoryFilter is the parameters for querying the decision history. Empty fields
+// are not used for filtering, and the filters are combined with AND.
+type DecisionHistoryFilter struct {
+	// UserID only match decisions for this user
+	UserID string `json:"user_id,omitempty"`
+	// Since only match decisions made at or after this time
+	Since *time.Time `json:"since,omitempty"`
+	// Until only match decisions made before this time
+	Until *time.Time `json:"until,omitempty"`
+	// Limit is the max number of decisions to return. Zero means no limit.
+	Limit int `json:"limit,omitempty"`
+}
+
+// dbDecision is a DB entry recording an authorization decision
+type dbDecision struct {
+	// ID the DB table entry ID
+	ID uint `json:"id" gorm:"primaryKey"`
+	DecisionRecord
+}
+
+// DecisionHistoryDBClient is the DB client for the authorization decision history
+type DecisionHistoryDBClient interface {
+	/*
+		RecordDecisions record a batch of authorization decisions
+
+		 @param ctxt context.Context - context calling this API
+		 @param decisions []DecisionRecord - the decisions
+		 @return whether successful
+	*/
+	RecordDecisions(ctxt context.Context, decisions []DecisionRecord) error
+
+	/*
+		ListDecisions query for the decisions matching the filter, newest first
+
+		 @param ctxt context.Context - context calling this API
+		 @param filter DecisionHistoryFilter - the query filter
+		 @return the list of matching decisions
+	*/
+	ListDecisions(ctxt context.Context, filter DecisionHistoryFilter) ([]DecisionRecord, error)
+
+	/*
+		PruneDecisions remove the decisions made before a cutoff, and the oldest decisions
+		beyond the max number of decisions to keep
+
+		 @param ctxt context.Context - context calling this API
+		 @param before time.Time - remove decisions made before this time
+		 @param maxEntries int - max number of decisions to keep. Zero means no limit.
+		 @return the number of decisions removed
+	*/
+	PruneDecisions(ctxt context.Context, before time.Time, maxEntries int) (int64, error)
+}
+
+// decisionHistoryDBClientImpl implements DecisionHistoryDBClient
+type decisionHistoryDBClientImpl struct {
+	goutils.Component
+	db *gorm.DB
+}
+
+/*
+CreateDecisionHistoryDBClient create a new decision history DB client
+
+	@param db *gorm.DB - GORM DB client
+	@return client
+*/
+func CreateDecisionHistoryDBClient(db *gorm.DB) (DecisionHistoryDBClient, error) {
+	logTags := log.Fields{"module": "models", "component": "decision-history-db-client"}
+
+	if err := installTracing(db); err != nil {
+		return nil, err
+	}
+
+	// Prepare the models
+	if err := db.AutoMigrate(&dbDecision{}); err != nil {
+		return nil, err
+	}
+
+	return &decisionHistoryDBClientImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+				common.ModifyLogMetadataByAccessAuthorizeParam,
+			},
+		},
+		db: db,
+	}, nil
+}
+
+/*
+RecordDecisions record a batch of authorization decisions
+
+	@param ctxt context.Context - context calling this API
+	@param decisions []DecisionRecord - the decisions
+	@return whether successful
+*/
+func (c *decisionHistoryDBClientImpl) RecordDecisions(
+	ctxt context.Context, decisions []DecisionRecord,
+) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	logTags := c.GetLogTagsForContext(ctxt)
+	entries := make([]dbDecision, len(decisions))
+	for idx, decision := range decisions {
+		entries[idx] = dbDecision{DecisionRecord: decision}
+	}
+	if tmp := c.db.WithContext(ctxt).Create(&entries); tmp.Error != nil {
+		log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to record decisions")
+		return tmp.Error
+	}
+	return nil
+}
+
+/*
+ListDecisions query for the decisions matching the filter, newest first
+
+	@param ctxt context.Context - context calling this API
+	@param filter DecisionHistoryFilter - the query filter
+	@return the list of matching decisions
+*/
+func (c *decisionHistoryDBClientImpl) ListDecisions(
+	ctxt context.Context, filter DecisionHistoryFilter,
+) ([]DecisionRecord, error) {
+	logTags := c.GetLogTagsForContext(ctxt)
+	query := c.db.WithContext(ctxt).Model(&dbDecision{})
+	if filter.UserID != "" {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Since != nil {
+		query = query.Where("timestamp >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("timestamp < ?", *filter.Until)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var entries []dbDecision
+	if tmp := query.Order("timestamp DESC").Order("id DESC").Find(&entries); tmp.Error != nil {
+		log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to query decisions")
+		return nil, tmp.Error
+	}
+	result := make([]DecisionRecord, len(entries))
+	for idx, entry := range entries {
+		result[idx] = entry.DecisionRecord
+	}
+	return result, nil
+}
+
+/*
+PruneDecisions remove the decisions made before a cutoff, and the oldest decisions beyond
+the max number of decisions to keep
+
+	@param ctxt context.Context - context calling this API
+	@param before time.Time - remove decisions made before this time
+	@param maxEntries int - max number of decisions to keep. Zero means no limit.
+	@return the number of decisions removed
+*/
+func (c *decisionHistoryDBClientImpl) PruneDecisions(
+	ctxt context.Context, before time.Time, maxEntries int,
+) (int64, error) {
+	logTags := c.GetLogTagsForContext(ctxt)
+	var removed int64
+	return removed, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		tmp := tx.Where("timestamp < ?", before).Delete(&dbDecision{})
+		if tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to prune expired decisions")
+			return tmp.Error
+		}
+		removed = tmp.RowsAffected
+		if maxEntries <= 0 {
+			return nil
+		}
+		// The entry IDs increase with insertion, so the newest entries have the largest IDs
+		var cutoff []uint
+		tmp = tx.Model(&dbDecision{}).
+			Order("id DESC").Offset(maxEntries).Limit(1).Pluck("id", &cutoff)
+		if tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to find decisions over limit")
+			return tmp.Error
+		}
+		if len(cutoff) == 0 {
+			return nil
+		}
+		tmp = tx.Where("id <= ?", cutoff[0]).Delete(&dbDecision{})
+		if tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to prune decisions over limit")
+			return tmp.Error
+		}
+		removed += tmp.RowsAffected
+		return nil
+	})
+}
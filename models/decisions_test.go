@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDecisionHistory(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	uut, err := CreateDecisionHistoryDBClient(db)
+	assert.Nil(err)
+
+	utCtxt := context.Background()
+	user0 := uuid.NewString()
+	user1 := uuid.NewString()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Case 0: record decisions over four hours
+	{
+		decisions := []DecisionRecord{}
+		for hour := 0; hour < 4; hour++ {
+			for _, userID := range []string{user0, user1} {
+				decisions = append(decisions, DecisionRecord{
+					Timestamp: start.Add(time.Hour * time.Duration(hour)),
+					UserID:    userID,
+					Host:      "unit-test.org",
+					Path:      fmt.Sprintf("/orders/%d", hour),
+					Method:    "GET",
+					Allowed:   userID == user0,
+				})
+			}
+		}
+		assert.Nil(uut.RecordDecisions(utCtxt, decisions))
+		assert.Nil(uut.RecordDecisions(utCtxt, nil))
+	}
+
+	// Case 1: query by user, newest first
+	{
+		decisions, err := uut.ListDecisions(utCtxt, DecisionHistoryFilter{UserID: user0})
+		assert.Nil(err)
+		assert.Len(decisions, 4)
+		assert.Equal("/orders/3", decisions[0].Path)
+		assert.Equal("/orders/0", decisions[3].Path)
+		assert.True(decisions[0].Allowed)
+	}
+
+	// Case 2: query by time range and limit
+	{
+		since := start.Add(time.Hour)
+		until := start.Add(time.Hour * 3)
+		decisions, err := uut.ListDecisions(utCtxt, DecisionHistoryFilter{
+			UserID: user1, Since: &since, Until: &until,
+		})
+		assert.Nil(err)
+		assert.Len(decisions, 2)
+		assert.Equal("/orders/2", decisions[0].Path)
+		assert.Equal("/orders/1", decisions[1].Path)
+		assert.False(decisions[0].Allowed)
+		decisions, err = uut.ListDecisions(utCtxt, DecisionHistoryFilter{Limit: 3})
+		assert.Nil(err)
+		assert.Len(decisions, 3)
+	}
+
+	// Case 3: prune by retention
+	{
+		removed, err := uut.PruneDecisions(utCtxt, start.Add(time.Hour), 0)
+		assert.Nil(err)
+		assert.Equal(int64(2), removed)
+		decisions, err := uut.ListDecisions(utCtxt, DecisionHistoryFilter{})
+		assert.Nil(err)
+		assert.Len(decisions, 6)
+	}
+
+	// Case 4: prune by max entries
+	{
+		removed, err := uut.PruneDecisions(utCtxt, start, 3)
+		assert.Nil(err)
+		assert.Equal(int64(3), removed)
+		decisions, err := uut.ListDecisions(utCtxt, DecisionHistoryFilter{})
+		assert.Nil(err)
+		assert.Len(decisions, 3)
+		assert.Equal("/orders/3", decisions[0].Path)
+		removed, err = uut.PruneDecisions(utCtxt, start, 3)
+		assert.Nil(err)
+		assert.Equal(int64(0), removed)
+	}
+}
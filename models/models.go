@@ -3,7 +3,9 @@ package models
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/alwitt/goutils"
@@ -14,12 +16,19 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// likeEscaper escapes the LIKE pattern wildcards in a user provided string
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 // dbUser is a DB entry recording a user
 type dbUser struct {
 	// ID the DB table entry ID
 	ID uint `json:"id" gorm:"primaryKey"`
 	// Roles is the list roles assigned to the user
 	Roles []dbRole `gorm:"many2many:user_roles;"`
+	// Permissions is the list of permissions granted directly to the user
+	Permissions []dbPermission `gorm:"many2many:user_permissions;"`
+	// Groups is the list of groups the user is a member of
+	Groups []dbGroup `gorm:"many2many:user_groups;"`
 	UserInfo
 }
 
@@ -43,6 +52,8 @@ type dbRole struct {
 	RoleName string `json:"role_name" gorm:"uniqueIndex" validate:"required,role_name"`
 	// Users is the list of users with this role
 	Users []dbUser `gorm:"many2many:user_roles;"`
+	// Groups is the list of groups with this role
+	Groups []dbGroup `gorm:"many2many:group_roles;"`
 }
 
 // String is toString for roleInfo
@@ -50,6 +61,53 @@ func (e dbRole) String() string {
 	return fmt.Sprintf("'ROLE %s'", e.RoleName)
 }
 
+// dbPermission is a DB entry recording a permission granted directly to users
+type dbPermission struct {
+	// ID the DB table entry ID
+	ID uint `json:"id" gorm:"primaryKey"`
+	// CreatedAt is when the table entry is created
+	CreatedAt time.Time `json:"created_at"`
+	// Permission is the permission's name
+	Permission string `json:"permission" gorm:"uniqueIndex" validate:"required,user_permissions"`
+	// Users is the list of users granted this permission
+	Users []dbUser `gorm:"many2many:user_permissions;"`
+}
+
+// String is toString for dbPermission
+func (e dbPermission) String() string {
+	return fmt.Sprintf("'PERMISSION %s'", e.Permission)
+}
+
+// dbGroup is a DB entry recording a group of users sharing a set of roles
+type dbGroup struct {
+	// ID the DB table entry ID
+	ID uint `json:"id" gorm:"primaryKey"`
+	// Roles is the list of roles the members of the group hold through the group
+	Roles []dbRole `gorm:"many2many:group_roles;"`
+	// Users is the list of members of the group
+	Users []dbUser `gorm:"many2many:user_groups;"`
+	GroupInfo
+}
+
+// String is toString for dbGroup
+func (e dbGroup) String() string {
+	return fmt.Sprintf("'GROUP %s'", e.GroupName)
+}
+
+// dbAPIKey is a DB entry recording an API key of a user. Only the hash of the key is recorded.
+type dbAPIKey struct {
+	// ID the DB table entry ID
+	ID uint `json:"id" gorm:"primaryKey"`
+	// KeyHash is the hash of the API key
+	KeyHash string `json:"-" gorm:"uniqueIndex"`
+	APIKeyInfo
+}
+
+// String is toString for dbAPIKey
+func (e dbAPIKey) String() string {
+	return fmt.Sprintf("'API KEY %s[%s]'", e.KeyID, e.UserID)
+}
+
 // ManagementDBClient is the DB client for managing user and roles
 type ManagementDBClient interface {
 	/*
@@ -121,6 +179,15 @@ type ManagementDBClient interface {
 	*/
 	ListAllUsers(ctxt context.Context) ([]UserInfo, error)
 
+	/*
+		SearchUsers query for the users matching the search filter, ordered by user ID
+
+		 @param ctxt context.Context - context calling this API
+		 @param filter UserSearchFilter - the search filter
+		 @return the list of matching users
+	*/
+	SearchUsers(ctxt context.Context, filter UserSearchFilter) ([]UserInfo, error)
+
 	/*
 		DeleteUser deletes a user
 
@@ -169,6 +236,126 @@ type ManagementDBClient interface {
 		 @return whether successful
 	*/
 	RemoveRolesFromUser(ctxt context.Context, id string, roles []string) error
+
+	/*
+		SetUserPermissions change the permissions granted directly to a user
+
+		 @param ctxt context.Context - context calling this API
+		 @param id string - user entry ID
+		 @param permissions []string - new directly granted permissions for this user
+		 @return whether successful
+	*/
+	SetUserPermissions(ctxt context.Context, id string, permissions []string) error
+
+	// ------------------------------------------------------------------------------------
+	// Group Management
+
+	/*
+		DefineGroup define a group entry with roles
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @param roles []string - roles for the members of this group
+		 @return whether successful
+	*/
+	DefineGroup(ctxt context.Context, name string, roles []string) error
+
+	/*
+		ListAllGroups query for the list of groups within the DB
+
+		 @param ctxt context.Context - context calling this API
+		 @return the list of groups in the DB
+	*/
+	ListAllGroups(ctxt context.Context) ([]string, error)
+
+	/*
+		GetGroup query for a group by name
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @return the group information
+	*/
+	GetGroup(ctxt context.Context, name string) (GroupDetails, error)
+
+	/*
+		DeleteGroup deletes a group. The members of the group are not deleted.
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @return whether successful
+	*/
+	DeleteGroup(ctxt context.Context, name string) error
+
+	/*
+		SetGroupRoles change the roles of a group
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @param newRoles []string - new roles for the members of this group
+		 @return whether successful
+	*/
+	SetGroupRoles(ctxt context.Context, name string, newRoles []string) error
+
+	/*
+		AddUsersToGroup add users to a group
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @param ids []string - user entry IDs
+		 @return whether successful
+	*/
+	AddUsersToGroup(ctxt context.Context, name string, ids []string) error
+
+	/*
+		RemoveUsersFromGroup remove users from a group
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @param ids []string - user entry IDs
+		 @return whether successful
+	*/
+	RemoveUsersFromGroup(ctxt context.Context, name string, ids []string) error
+
+	// ------------------------------------------------------------------------------------
+	// API Key Management
+
+	/*
+		RecordAPIKey record a new API key of a user
+
+		 @param ctxt context.Context - context calling this API
+		 @param key APIKeyInfo - the API key parameters
+		 @param keyHash string - the hash of the API key
+		 @return whether successful
+	*/
+	RecordAPIKey(ctxt context.Context, key APIKeyInfo, keyHash string) error
+
+	/*
+		ListAPIKeys query for the API keys of a user
+
+		 @param ctxt context.Context - context calling this API
+		 @param userID string - user entry ID
+		 @return the API keys of the user
+	*/
+	ListAPIKeys(ctxt context.Context, userID string) ([]APIKeyInfo, error)
+
+	/*
+		GetAPIKeyByHash query for an API key by its hash
+
+		 @param ctxt context.Context - context calling this API
+		 @param keyHash string - the hash of the API key
+		 @return the API key information
+	*/
+	GetAPIKeyByHash(ctxt context.Context, keyHash string) (APIKeyInfo, error)
+
+	/*
+		DeleteAPIKey deletes an API key of a user
+
+		 @param ctxt context.Context - context calling this API
+		 @param userID string - user entry ID
+		 @param keyID string - the API key ID
+		 @return whether successful
+	*/
+	DeleteAPIKey(ctxt context.Context, userID string, keyID string) error
 }
 
 // ======================================================================================
@@ -181,6 +368,21 @@ type managementDBClientImpl struct {
 	customValidateSupport common.CustomFieldValidator
 }
 
+/*
+validateUserConfig user entry validation which spans multiple fields
+
+	@param sl validator.StructLevel - the user entry to validate
+*/
+func validateUserConfig(sl validator.StructLevel) {
+	config, ok := sl.Current().Interface().(UserConfig)
+	if !ok || !config.ServiceAccount {
+		return
+	}
+	if err := sl.Validator().Var(config.UserID, "service_account_id"); err != nil {
+		sl.ReportError(config.UserID, "UserID", "UserID", "service_account_id", "")
+	}
+}
+
 /*
 CreateManagementDBClient create a new DB client
 
@@ -194,9 +396,14 @@ func CreateManagementDBClient(db *gorm.DB, validateSupport common.CustomFieldVal
 	if err := validateSupport.RegisterWithValidator(validate); err != nil {
 		return nil, err
 	}
+	validate.RegisterStructValidation(validateUserConfig, UserConfig{})
 
 	logTags := log.Fields{"module": "models", "component": "user-db-client"}
 
+	if err := installTracing(db); err != nil {
+		return nil, err
+	}
+
 	// Prepare the models
 	if err := db.AutoMigrate(&dbUser{}); err != nil {
 		return nil, err
@@ -204,6 +411,15 @@ func CreateManagementDBClient(db *gorm.DB, validateSupport common.CustomFieldVal
 	if err := db.AutoMigrate(&dbRole{}); err != nil {
 		return nil, err
 	}
+	if err := db.AutoMigrate(&dbPermission{}); err != nil {
+		return nil, err
+	}
+	if err := db.AutoMigrate(&dbGroup{}); err != nil {
+		return nil, err
+	}
+	if err := db.AutoMigrate(&dbAPIKey{}); err != nil {
+		return nil, err
+	}
 
 	return &managementDBClientImpl{
 		Component: goutils.Component{
@@ -219,6 +435,41 @@ func CreateManagementDBClient(db *gorm.DB, validateSupport common.CustomFieldVal
 	}, nil
 }
 
+/*
+CheckSchema compare the DB schema against the models, without migrating the DB. The missing
+tables and columns are created when the DB client is created.
+
+	@param db *gorm.DB - GORM DB client
+	@return the missing tables and columns
+*/
+func CheckSchema(db *gorm.DB) ([]string, error) {
+	missing := []string{}
+	migrator := db.Migrator()
+	for _, model := range []interface{}{
+		&dbUser{}, &dbRole{}, &dbPermission{}, &dbGroup{}, &dbAPIKey{},
+	} {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		if !migrator.HasTable(model) {
+			missing = append(missing, fmt.Sprintf("table %s", stmt.Schema.Table))
+			continue
+		}
+		for _, column := range stmt.Schema.DBNames {
+			if !migrator.HasColumn(model, column) {
+				missing = append(missing, fmt.Sprintf("column %s.%s", stmt.Schema.Table, column))
+			}
+		}
+	}
+	for _, joinTable := range []string{"user_roles", "user_permissions", "user_groups", "group_roles"} {
+		if !migrator.HasTable(joinTable) {
+			missing = append(missing, fmt.Sprintf("table %s", joinTable))
+		}
+	}
+	return missing, nil
+}
+
 // --------------------------------------------------------------------------------------
 
 /*
@@ -251,9 +502,9 @@ func (c *managementDBClientImpl) AlignRolesWithConfig(
 	ctxt context.Context, configuredRoles []string,
 ) error {
 	logTags := c.GetLogTagsForContext(ctxt)
-	return c.db.Transaction(func(tx *gorm.DB) error {
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		var roles []dbRole
-		if tmp := tx.Preload("Users").Find(&roles); tmp.Error != nil {
+		if tmp := tx.Preload("Users").Preload("Groups").Find(&roles); tmp.Error != nil {
 			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to list all roles")
 			return tmp.Error
 		}
@@ -297,6 +548,14 @@ func (c *managementDBClientImpl) AlignRolesWithConfig(
 					return err
 				}
 			}
+			// Clear the associations with group entries
+			if len(entry.Groups) > 0 {
+				if err := tx.Model(&entry).Association("Groups").Clear(); err != nil {
+					log.WithError(err).WithFields(logTags).
+						Errorf("Unable to clear group associations for %s", entry.String())
+					return err
+				}
+			}
 		}
 		if err := c.deleteRoles(ctxt, tx, deleteRoleNames); err != nil {
 			return err
@@ -386,7 +645,7 @@ ListAllRoles query for the list of known roles within the DB
 func (c *managementDBClientImpl) ListAllRoles(ctxt context.Context) ([]string, error) {
 	var result []string
 	logTags := c.GetLogTagsForContext(ctxt)
-	return result, c.db.Transaction(func(tx *gorm.DB) error {
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		var allRoles []dbRole
 		if tmp := tx.Find(&allRoles); tmp.Error != nil {
 			log.WithFields(logTags).Errorf("Unable to query all user roles")
@@ -411,7 +670,7 @@ func (c *managementDBClientImpl) GetUsersOfRole(ctxt context.Context, role strin
 ) {
 	var result []UserInfo
 	logTags := c.GetLogTagsForContext(ctxt)
-	return result, c.db.Transaction(func(tx *gorm.DB) error {
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		var theRole dbRole
 		if tmp := tx.Where(&dbRole{RoleName: role}).Preload("Users").First(&theRole); tmp.Error != nil {
 			log.WithError(tmp.Error).WithFields(logTags).Errorf("Couldn't select role %s", role)
@@ -440,7 +699,7 @@ func (c *managementDBClientImpl) DefineUser(
 	ctxt context.Context, config UserConfig, roles []string,
 ) error {
 	logTags := c.GetLogTagsForContext(ctxt)
-	return c.db.Transaction(func(tx *gorm.DB) error {
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		if err := c.validate.Struct(&config); err != nil {
 			log.WithError(err).WithFields(logTags).Errorf("User %s has invalid params", config.UserID)
 			return err
@@ -485,11 +744,15 @@ func (c *managementDBClientImpl) fetchUser(tx *gorm.DB, id string) (dbUser, erro
 	tmp := tx.Where(
 		&dbUser{UserInfo: UserInfo{UserConfig: UserConfig{UserID: id}}},
 	).First(&userEntry)
+	if errors.Is(tmp.Error, gorm.ErrRecordNotFound) {
+		return userEntry, fmt.Errorf("%w %s: %w", ErrUserNotFound, id, tmp.Error)
+	}
 	return userEntry, tmp.Error
 }
 
 /*
-fetchUserWithRoles reads a single user entry with it associated roles
+fetchUserWithRoles reads a single user entry with it associated roles, directly granted
+permissions, and groups along with their roles
 
 	@param tx *gorm.DB - the DB client
 	@param id string - user entry ID
@@ -499,7 +762,10 @@ func (c *managementDBClientImpl) fetchUserWithRoles(tx *gorm.DB, id string) (dbU
 	var userEntry dbUser
 	tmp := tx.Where(
 		&dbUser{UserInfo: UserInfo{UserConfig: UserConfig{UserID: id}}},
-	).Preload("Roles").First(&userEntry)
+	).Preload("Roles").Preload("Permissions").Preload("Groups.Roles").First(&userEntry)
+	if errors.Is(tmp.Error, gorm.ErrRecordNotFound) {
+		return userEntry, fmt.Errorf("%w %s: %w", ErrUserNotFound, id, tmp.Error)
+	}
 	return userEntry, tmp.Error
 }
 
@@ -513,7 +779,7 @@ GetUser query for a user by ID
 func (c *managementDBClientImpl) GetUser(ctxt context.Context, id string) (UserDetails, error) {
 	var result UserDetails
 	logTags := c.GetLogTagsForContext(ctxt)
-	return result, c.db.Transaction(func(tx *gorm.DB) error {
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		userEntry, err := c.fetchUserWithRoles(tx, id)
 		if err != nil {
 			log.WithError(err).WithFields(logTags).Errorf("Failed to query user %s", id)
@@ -524,6 +790,22 @@ func (c *managementDBClientImpl) GetUser(ctxt context.Context, id string) (UserD
 		for idx, roleEntry := range userEntry.Roles {
 			result.Roles[idx] = roleEntry.RoleName
 		}
+		result.Permissions = make([]string, len(userEntry.Permissions))
+		for idx, permissionEntry := range userEntry.Permissions {
+			result.Permissions[idx] = permissionEntry.Permission
+		}
+		result.Groups = make([]string, len(userEntry.Groups))
+		result.GroupRoles = []string{}
+		groupRoles := map[string]bool{}
+		for idx, groupEntry := range userEntry.Groups {
+			result.Groups[idx] = groupEntry.GroupName
+			for _, roleEntry := range groupEntry.Roles {
+				if !groupRoles[roleEntry.RoleName] {
+					groupRoles[roleEntry.RoleName] = true
+					result.GroupRoles = append(result.GroupRoles, roleEntry.RoleName)
+				}
+			}
+		}
 		return nil
 	})
 }
@@ -537,7 +819,7 @@ ListAllUsers query for all users in system
 func (c *managementDBClientImpl) ListAllUsers(ctxt context.Context) ([]UserInfo, error) {
 	var result []UserInfo
 	logTags := c.GetLogTagsForContext(ctxt)
-	return result, c.db.Transaction(func(tx *gorm.DB) error {
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		var allUsers []dbUser
 		if tmp := tx.Find(&allUsers); tmp.Error != nil {
 			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to query for all user")
@@ -551,6 +833,66 @@ func (c *managementDBClientImpl) ListAllUsers(ctxt context.Context) ([]UserInfo,
 	})
 }
 
+/*
+SearchUsers query for the users matching the search filter, ordered by user ID
+
+	@param ctxt context.Context - context calling this API
+	@param filter UserSearchFilter - the search filter
+	@return the list of matching users
+*/
+func (c *managementDBClientImpl) SearchUsers(
+	ctxt context.Context, filter UserSearchFilter,
+) ([]UserInfo, error) {
+	var result []UserInfo
+	logTags := c.GetLogTagsForContext(ctxt)
+	if err := c.validate.Struct(&filter); err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Invalid user search filter")
+		return nil, err
+	}
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&dbUser{})
+		if filter.UserIDPrefix != "" {
+			query = query.Where(
+				`user_id LIKE ? ESCAPE '\'`, likeEscaper.Replace(filter.UserIDPrefix)+"%",
+			)
+		}
+		if filter.Username != "" {
+			query = query.Where("username = ?", filter.Username)
+		}
+		if filter.Email != "" {
+			query = query.Where("email = ?", filter.Email)
+		}
+		if filter.Role != "" {
+			query = query.Where(
+				"id IN (?)",
+				tx.Table("user_roles").
+					Select("user_roles.db_user_id").
+					Joins("JOIN db_roles ON db_roles.id = user_roles.db_role_id").
+					Where("db_roles.role_name = ?", filter.Role),
+			)
+		}
+		switch filter.AccountType {
+		case AccountTypeUser:
+			query = query.Where("service_account = ?", false)
+		case AccountTypeServiceAccount:
+			query = query.Where("service_account = ?", true)
+		}
+		if filter.Limit > 0 {
+			query = query.Limit(filter.Limit)
+		}
+		var matchedUsers []dbUser
+		if tmp := query.Order("user_id").Find(&matchedUsers); tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to search for users")
+			return tmp.Error
+		}
+		result = make([]UserInfo, len(matchedUsers))
+		for idx, entry := range matchedUsers {
+			result[idx] = entry.UserInfo
+		}
+		return nil
+	})
+}
+
 /*
 DeleteUser deletes a user
 
@@ -560,7 +902,7 @@ DeleteUser deletes a user
 */
 func (c *managementDBClientImpl) DeleteUser(ctxt context.Context, id string) error {
 	logTags := c.GetLogTagsForContext(ctxt)
-	return c.db.Transaction(func(tx *gorm.DB) error {
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		userEntry, err := c.fetchUserWithRoles(tx, id)
 		if err != nil {
 			log.WithError(err).WithFields(logTags).Errorf("Failed to query user %s", id)
@@ -574,6 +916,28 @@ func (c *managementDBClientImpl) DeleteUser(ctxt context.Context, id string) err
 				return err
 			}
 		}
+		// Remove permission association of user
+		if len(userEntry.Permissions) > 0 {
+			if err := tx.Model(&userEntry).Association("Permissions").Clear(); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Failed to remove permissions from %s", userEntry.String())
+				return err
+			}
+		}
+		// Remove group membership of user
+		if len(userEntry.Groups) > 0 {
+			if err := tx.Model(&userEntry).Association("Groups").Clear(); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Failed to remove %s from its groups", userEntry.String())
+				return err
+			}
+		}
+		// Remove the API keys of user
+		if tmp := tx.Where("user_id = ?", userEntry.UserID).Delete(&dbAPIKey{}); tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).
+				Errorf("Failed to delete API keys of %s", userEntry.String())
+			return tmp.Error
+		}
 		if tmp := tx.Delete(&userEntry); tmp.Error != nil {
 			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to delete %s", userEntry.String())
 			return tmp.Error
@@ -599,7 +963,7 @@ func (c *managementDBClientImpl) UpdateUser(
 		log.WithError(err).WithFields(logTags).Errorf("Updated entry for user %s is invalid", id)
 		return err
 	}
-	return c.db.Transaction(func(tx *gorm.DB) error {
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		userEntry, err := c.fetchUser(tx, id)
 		if err != nil {
 			log.WithError(err).WithFields(logTags).Errorf("Failed to query user %s", id)
@@ -631,7 +995,7 @@ func (c *managementDBClientImpl) AddRolesToUser(
 	ctxt context.Context, id string, newRoles []string,
 ) error {
 	logTags := c.GetLogTagsForContext(ctxt)
-	return c.db.Transaction(func(tx *gorm.DB) error {
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		userEntry, err := c.fetchUserWithRoles(tx, id)
 		if err != nil {
 			log.WithError(err).WithFields(logTags).Errorf("Failed to query user %s", id)
@@ -666,7 +1030,7 @@ func (c *managementDBClientImpl) SetUserRoles(
 	ctxt context.Context, id string, newRoles []string,
 ) error {
 	logTags := c.GetLogTagsForContext(ctxt)
-	return c.db.Transaction(func(tx *gorm.DB) error {
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		userEntry, err := c.fetchUserWithRoles(tx, id)
 		if err != nil {
 			log.WithError(err).WithFields(logTags).Errorf("Failed to query user %s", id)
@@ -706,7 +1070,7 @@ func (c *managementDBClientImpl) RemoveRolesFromUser(
 	ctxt context.Context, id string, roles []string,
 ) error {
 	logTags := c.GetLogTagsForContext(ctxt)
-	return c.db.Transaction(func(tx *gorm.DB) error {
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
 		rolesAsMap := map[string]bool{}
 		for _, role := range roles {
 			rolesAsMap[role] = true
@@ -733,3 +1097,506 @@ func (c *managementDBClientImpl) RemoveRolesFromUser(
 		return nil
 	})
 }
+
+// ------------------------------------------------------------------------------------
+// Direct Permission Grants
+
+/*
+createPermissions create a set of new permission entries
+
+	@param ctxt context.Context - context calling this API
+	@param tx *gorm.DB - DB session object
+	@param permissions []string - list of permissions to create for
+	@return the set of permission objects
+*/
+func (c *managementDBClientImpl) createPermissions(
+	ctxt context.Context, tx *gorm.DB, permissions []string,
+) ([]dbPermission, error) {
+	if len(permissions) == 0 {
+		return nil, nil
+	}
+	var results []dbPermission
+	logTags := c.GetLogTagsForContext(ctxt)
+	return results, tx.Transaction(func(tx *gorm.DB) error {
+		entries := []dbPermission{}
+		for _, newPermission := range permissions {
+			newEntry := dbPermission{Permission: newPermission}
+			if err := c.validate.Struct(&newEntry); err != nil {
+				log.WithError(err).WithFields(logTags).Errorf("Permission %s not valid", newPermission)
+				return err
+			}
+			entries = append(entries, newEntry)
+		}
+		if tmp := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entries); tmp.Error != nil {
+			t, _ := json.Marshal(&permissions)
+			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to insert new permissions %s", t)
+			return tmp.Error
+		}
+		if tmp := tx.Where("permission", permissions).Find(&results); tmp.Error != nil {
+			t, _ := json.Marshal(&permissions)
+			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to read back entries for %s", t)
+			return tmp.Error
+		}
+		return nil
+	})
+}
+
+/*
+SetUserPermissions change the permissions granted directly to a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param permissions []string - new directly granted permissions for this user
+	@return whether successful
+*/
+func (c *managementDBClientImpl) SetUserPermissions(
+	ctxt context.Context, id string, permissions []string,
+) error {
+	logTags := c.GetLogTagsForContext(ctxt)
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		userEntry, err := c.fetchUserWithRoles(tx, id)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to query user %s", id)
+			return err
+		}
+		permissionEntries, err := c.createPermissions(ctxt, tx, permissions)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to define %s new permissions", userEntry.String())
+			return err
+		}
+		// Clear the current associations
+		if err := tx.Model(&userEntry).Association("Permissions").Clear(); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to clear %s permissions", userEntry.String())
+			return err
+		}
+		for _, permissionEntry := range permissionEntries {
+			if err := tx.Model(&userEntry).Association("Permissions").Append(
+				&permissionEntry,
+			); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Failed to grant %s to %s", permissionEntry.String(), userEntry.String())
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ------------------------------------------------------------------------------------
+// Group Management
+
+/*
+DefineGroup define a group entry with roles
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param roles []string - roles for the members of this group
+	@return whether successful
+*/
+func (c *managementDBClientImpl) DefineGroup(
+	ctxt context.Context, name string, roles []string,
+) error {
+	logTags := c.GetLogTagsForContext(ctxt)
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		newEntry := dbGroup{GroupInfo: GroupInfo{GroupName: name}}
+		if err := c.validate.Struct(&newEntry); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Group %s has invalid params", name)
+			return err
+		}
+		if tmp := tx.Create(&newEntry); tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).Errorf("Failed to create group %s", name)
+			return tmp.Error
+		}
+		// Associate the roles as well
+		roleEntries, err := c.createRoles(ctxt, tx, roles)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to define %s roles", newEntry.String())
+			return err
+		}
+		for _, roleEntry := range roleEntries {
+			if err := tx.Model(&newEntry).Association("Roles").Append(&roleEntry); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Failed to add %s to %s", roleEntry.String(), newEntry.String())
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/*
+fetchGroup reads a single group entry with its associated roles and members
+
+	@param tx *gorm.DB - the DB client
+	@param name string - group name
+	@return the group entry from DB
+*/
+func (c *managementDBClientImpl) fetchGroup(tx *gorm.DB, name string) (dbGroup, error) {
+	var groupEntry dbGroup
+	tmp := tx.Where(
+		&dbGroup{GroupInfo: GroupInfo{GroupName: name}},
+	).Preload("Roles").Preload("Users").First(&groupEntry)
+	if errors.Is(tmp.Error, gorm.ErrRecordNotFound) {
+		return groupEntry, fmt.Errorf("%w %s: %w", ErrGroupNotFound, name, tmp.Error)
+	}
+	return groupEntry, tmp.Error
+}
+
+/*
+fetchUsers reads a set of user entries
+
+	@param tx *gorm.DB - the DB client
+	@param ids []string - user entry IDs
+	@return the user entries from DB
+*/
+func (c *managementDBClientImpl) fetchUsers(tx *gorm.DB, ids []string) ([]dbUser, error) {
+	var userEntries []dbUser
+	if len(ids) == 0 {
+		return userEntries, nil
+	}
+	if tmp := tx.Where("user_id", ids).Find(&userEntries); tmp.Error != nil {
+		return nil, tmp.Error
+	}
+	found := map[string]bool{}
+	for _, userEntry := range userEntries {
+		found[userEntry.UserID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, fmt.Errorf("%w %s", ErrUserNotFound, id)
+		}
+	}
+	return userEntries, nil
+}
+
+/*
+ListAllGroups query for the list of groups within the DB
+
+	@param ctxt context.Context - context calling this API
+	@return the list of groups in the DB
+*/
+func (c *managementDBClientImpl) ListAllGroups(ctxt context.Context) ([]string, error) {
+	var result []string
+	logTags := c.GetLogTagsForContext(ctxt)
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		var allGroups []dbGroup
+		if tmp := tx.Order("group_name").Find(&allGroups); tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).Errorf("Unable to query all groups")
+			return tmp.Error
+		}
+		result = make([]string, len(allGroups))
+		for idx, entry := range allGroups {
+			result[idx] = entry.GroupName
+		}
+		return nil
+	})
+}
+
+/*
+GetGroup query for a group by name
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@return the group information
+*/
+func (c *managementDBClientImpl) GetGroup(ctxt context.Context, name string) (GroupDetails, error) {
+	var result GroupDetails
+	logTags := c.GetLogTagsForContext(ctxt)
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		groupEntry, err := c.fetchGroup(tx, name)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to query group %s", name)
+			return err
+		}
+		result.GroupInfo = groupEntry.GroupInfo
+		result.Roles = make([]string, len(groupEntry.Roles))
+		for idx, roleEntry := range groupEntry.Roles {
+			result.Roles[idx] = roleEntry.RoleName
+		}
+		result.Users = make([]string, len(groupEntry.Users))
+		for idx, userEntry := range groupEntry.Users {
+			result.Users[idx] = userEntry.UserID
+		}
+		return nil
+	})
+}
+
+/*
+DeleteGroup deletes a group. The members of the group are not deleted.
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@return whether successful
+*/
+func (c *managementDBClientImpl) DeleteGroup(ctxt context.Context, name string) error {
+	logTags := c.GetLogTagsForContext(ctxt)
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		groupEntry, err := c.fetchGroup(tx, name)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to query group %s", name)
+			return err
+		}
+		// Remove role association of group
+		if len(groupEntry.Roles) > 0 {
+			if err := tx.Model(&groupEntry).Association("Roles").Clear(); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Failed to remove roles from %s", groupEntry.String())
+				return err
+			}
+		}
+		// Remove the members of group
+		if len(groupEntry.Users) > 0 {
+			if err := tx.Model(&groupEntry).Association("Users").Clear(); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Failed to remove members from %s", groupEntry.String())
+				return err
+			}
+		}
+		if tmp := tx.Delete(&groupEntry); tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).
+				Errorf("Failed to delete %s", groupEntry.String())
+			return tmp.Error
+		}
+		return nil
+	})
+}
+
+/*
+SetGroupRoles change the roles of a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param newRoles []string - new roles for the members of this group
+	@return whether successful
+*/
+func (c *managementDBClientImpl) SetGroupRoles(
+	ctxt context.Context, name string, newRoles []string,
+) error {
+	logTags := c.GetLogTagsForContext(ctxt)
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		groupEntry, err := c.fetchGroup(tx, name)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to query group %s", name)
+			return err
+		}
+		roleEntries, err := c.createRoles(ctxt, tx, newRoles)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to define %s new roles", groupEntry.String())
+			return err
+		}
+		// Clear the current associations
+		if err := tx.Model(&groupEntry).Association("Roles").Clear(); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to clear %s roles", groupEntry.String())
+			return err
+		}
+		for _, roleEntry := range roleEntries {
+			if err := tx.Model(&groupEntry).Association("Roles").Append(&roleEntry); err != nil {
+				log.WithError(err).WithFields(logTags).
+					Errorf("Failed to add %s to %s", roleEntry.String(), groupEntry.String())
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/*
+AddUsersToGroup add users to a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (c *managementDBClientImpl) AddUsersToGroup(
+	ctxt context.Context, name string, ids []string,
+) error {
+	logTags := c.GetLogTagsForContext(ctxt)
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		groupEntry, err := c.fetchGroup(tx, name)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to query group %s", name)
+			return err
+		}
+		userEntries, err := c.fetchUsers(tx, ids)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to query new members of %s", groupEntry.String())
+			return err
+		}
+		if len(userEntries) == 0 {
+			return nil
+		}
+		if err := tx.Model(&groupEntry).Association("Users").Append(&userEntries); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to add members to %s", groupEntry.String())
+			return err
+		}
+		return nil
+	})
+}
+
+/*
+RemoveUsersFromGroup remove users from a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (c *managementDBClientImpl) RemoveUsersFromGroup(
+	ctxt context.Context, name string, ids []string,
+) error {
+	logTags := c.GetLogTagsForContext(ctxt)
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		idsAsMap := map[string]bool{}
+		for _, id := range ids {
+			idsAsMap[id] = true
+		}
+		groupEntry, err := c.fetchGroup(tx, name)
+		if err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to query group %s", name)
+			return err
+		}
+		// Determine which members need to be removed
+		removeUsers := []dbUser{}
+		for _, userEntry := range groupEntry.Users {
+			if idsAsMap[userEntry.UserID] {
+				removeUsers = append(removeUsers, userEntry)
+			}
+		}
+		if len(removeUsers) == 0 {
+			return nil
+		}
+		if err := tx.Model(&groupEntry).Association("Users").Delete(removeUsers); err != nil {
+			t, _ := json.Marshal(ids)
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to remove members %s from %s", t, groupEntry.String())
+			return err
+		}
+		return nil
+	})
+}
+
+// --------------------------------------------------------------------------------------
+// API Key Management
+
+/*
+RecordAPIKey record a new API key of a user
+
+	@param ctxt context.Context - context calling this API
+	@param key APIKeyInfo - the API key parameters
+	@param keyHash string - the hash of the API key
+	@return whether successful
+*/
+func (c *managementDBClientImpl) RecordAPIKey(
+	ctxt context.Context, key APIKeyInfo, keyHash string,
+) error {
+	logTags := c.GetLogTagsForContext(ctxt)
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		if _, err := c.fetchUser(tx, key.UserID); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to query user %s", key.UserID)
+			return err
+		}
+		newEntry := dbAPIKey{KeyHash: keyHash, APIKeyInfo: key}
+		if err := c.validate.Struct(&newEntry); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("%s has invalid params", newEntry.String())
+			return err
+		}
+		if tmp := tx.Create(&newEntry); tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).
+				Errorf("Failed to create %s", newEntry.String())
+			return tmp.Error
+		}
+		return nil
+	})
+}
+
+/*
+ListAPIKeys query for the API keys of a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@return the API keys of the user
+*/
+func (c *managementDBClientImpl) ListAPIKeys(
+	ctxt context.Context, userID string,
+) ([]APIKeyInfo, error) {
+	result := []APIKeyInfo{}
+	logTags := c.GetLogTagsForContext(ctxt)
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		if _, err := c.fetchUser(tx, userID); err != nil {
+			log.WithError(err).WithFields(logTags).Errorf("Failed to query user %s", userID)
+			return err
+		}
+		var keyEntries []dbAPIKey
+		tmp := tx.Where("user_id = ?", userID).Order("created_at").Find(&keyEntries)
+		if tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).
+				Errorf("Failed to query API keys of user %s", userID)
+			return tmp.Error
+		}
+		for _, keyEntry := range keyEntries {
+			result = append(result, keyEntry.APIKeyInfo)
+		}
+		return nil
+	})
+}
+
+/*
+GetAPIKeyByHash query for an API key by its hash
+
+	@param ctxt context.Context - context calling this API
+	@param keyHash string - the hash of the API key
+	@return the API key information
+*/
+func (c *managementDBClientImpl) GetAPIKeyByHash(
+	ctxt context.Context, keyHash string,
+) (APIKeyInfo, error) {
+	var result APIKeyInfo
+	logTags := c.GetLogTagsForContext(ctxt)
+	return result, c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		var keyEntry dbAPIKey
+		tmp := tx.Where(&dbAPIKey{KeyHash: keyHash}).First(&keyEntry)
+		if errors.Is(tmp.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %w", ErrAPIKeyNotFound, tmp.Error)
+		}
+		if tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).Error("Failed to query API key")
+			return tmp.Error
+		}
+		result = keyEntry.APIKeyInfo
+		return nil
+	})
+}
+
+/*
+DeleteAPIKey deletes an API key of a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@param keyID string - the API key ID
+	@return whether successful
+*/
+func (c *managementDBClientImpl) DeleteAPIKey(
+	ctxt context.Context, userID string, keyID string,
+) error {
+	logTags := c.GetLogTagsForContext(ctxt)
+	return c.db.WithContext(ctxt).Transaction(func(tx *gorm.DB) error {
+		tmp := tx.Where("user_id = ? AND key_id = ?", userID, keyID).Delete(&dbAPIKey{})
+		if tmp.Error != nil {
+			log.WithError(tmp.Error).WithFields(logTags).
+				Errorf("Failed to delete API key %s of user %s", keyID, userID)
+			return tmp.Error
+		}
+		if tmp.RowsAffected == 0 {
+			return fmt.Errorf("%w %s of user %s", ErrAPIKeyNotFound, keyID, userID)
+		}
+		return nil
+	})
+}
@@ -172,7 +172,33 @@ func TestUserManagement(t *testing.T) {
 		assert.Equal(newEmail, *user.Email)
 	}
 
-	// Case 8: delete user
+	// Case 8: change user attributes
+	{
+		newEmail := "unit-test@testing.org"
+		param := UserConfig{
+			UserID:     user6,
+			Email:      &newEmail,
+			Attributes: map[string]string{"department": "finance", "region": "eu"},
+		}
+		assert.Nil(uut.UpdateUser(context.Background(), user6, param))
+		user, err := uut.GetUser(context.Background(), user6)
+		assert.Nil(err)
+		assert.EqualValues(param.Attributes, user.Attributes)
+		users, err := uut.ListAllUsers(context.Background())
+		assert.Nil(err)
+		for _, oneUser := range users {
+			if oneUser.UserID == user6 {
+				assert.EqualValues(param.Attributes, oneUser.Attributes)
+			} else {
+				assert.Empty(oneUser.Attributes)
+			}
+		}
+		// Attribute names can not be empty
+		param.Attributes[""] = "unknown"
+		assert.NotNil(uut.UpdateUser(context.Background(), user6, param))
+	}
+
+	// Case 9: delete user
 	assert.Nil(uut.DeleteUser(context.Background(), user1))
 	{
 		_, err := uut.GetUser(context.Background(), user1)
@@ -251,6 +277,402 @@ func TestUserAndRoleManagement(t *testing.T) {
 		assert.Equal(user2, user.UserID)
 		assert.EqualValues(roleListToMap([]string{roles[0]}), roleListToMap(user.Roles))
 	}
+
+	// Case 4: grant permissions directly
+	permissions := []string{uuid.New().String(), uuid.New().String()}
+	assert.Nil(uut.SetUserPermissions(context.Background(), user1, permissions))
+	assert.Nil(uut.SetUserPermissions(context.Background(), user2, permissions[1:]))
+	{
+		user, err := uut.GetUser(context.Background(), user1)
+		assert.Nil(err)
+		assert.EqualValues(roleListToMap(permissions), roleListToMap(user.Permissions))
+		assert.EqualValues(roleListToMap([]string{roles[0]}), roleListToMap(user.Roles))
+	}
+	assert.Nil(uut.SetUserPermissions(context.Background(), user1, permissions[:1]))
+	{
+		user, err := uut.GetUser(context.Background(), user1)
+		assert.Nil(err)
+		assert.EqualValues(permissions[:1], user.Permissions)
+	}
+	assert.NotNil(uut.SetUserPermissions(context.Background(), uuid.New().String(), permissions))
+
+	// Case 5: delete a user with granted permissions
+	assert.Nil(uut.DeleteUser(context.Background(), user2))
+	{
+		user, err := uut.GetUser(context.Background(), user1)
+		assert.Nil(err)
+		assert.EqualValues(permissions[:1], user.Permissions)
+	}
+}
+
+func TestGroupManagement(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	uut, err := CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(uut.Ready())
+
+	roles := []string{uuid.New().String(), uuid.New().String(), uuid.New().String()}
+	user1 := uuid.New().String()
+	user2 := uuid.New().String()
+	assert.Nil(uut.DefineUser(context.Background(), UserConfig{UserID: user1}, roles[:1]))
+	assert.Nil(uut.DefineUser(context.Background(), UserConfig{UserID: user2}, nil))
+
+	// Case 1: define group
+	group1 := uuid.New().String()
+	assert.Nil(uut.DefineGroup(context.Background(), group1, roles[1:]))
+	assert.NotNil(uut.DefineGroup(context.Background(), group1, nil))
+	assert.NotNil(uut.DefineGroup(context.Background(), "bad group", nil))
+	{
+		group, err := uut.GetGroup(context.Background(), group1)
+		assert.Nil(err)
+		assert.Equal(group1, group.GroupName)
+		assert.EqualValues(roleListToMap(roles[1:]), roleListToMap(group.Roles))
+		assert.Empty(group.Users)
+	}
+	{
+		_, err := uut.GetGroup(context.Background(), uuid.New().String())
+		assert.ErrorIs(err, ErrGroupNotFound)
+	}
+	{
+		groups, err := uut.ListAllGroups(context.Background())
+		assert.Nil(err)
+		assert.Equal([]string{group1}, groups)
+	}
+
+	// Case 2: add users to group
+	assert.Nil(uut.AddUsersToGroup(context.Background(), group1, []string{user1, user2}))
+	{
+		err := uut.AddUsersToGroup(context.Background(), group1, []string{uuid.New().String()})
+		assert.ErrorIs(err, ErrUserNotFound)
+	}
+	{
+		group, err := uut.GetGroup(context.Background(), group1)
+		assert.Nil(err)
+		assert.EqualValues(roleListToMap([]string{user1, user2}), roleListToMap(group.Users))
+		user, err := uut.GetUser(context.Background(), user1)
+		assert.Nil(err)
+		assert.Equal([]string{group1}, user.Groups)
+		assert.EqualValues(roleListToMap(roles[:1]), roleListToMap(user.Roles))
+		assert.EqualValues(roleListToMap(roles[1:]), roleListToMap(user.GroupRoles))
+	}
+
+	// Case 3: change group roles
+	assert.Nil(uut.SetGroupRoles(context.Background(), group1, roles[2:]))
+	{
+		user, err := uut.GetUser(context.Background(), user2)
+		assert.Nil(err)
+		assert.Empty(user.Roles)
+		assert.Equal(roles[2:], user.GroupRoles)
+	}
+
+	// Case 4: resync roles
+	assert.Nil(uut.AlignRolesWithConfig(context.Background(), roles[:2]))
+	{
+		group, err := uut.GetGroup(context.Background(), group1)
+		assert.Nil(err)
+		assert.Empty(group.Roles)
+	}
+	assert.Nil(uut.SetGroupRoles(context.Background(), group1, roles[1:2]))
+
+	// Case 5: remove user from group
+	assert.Nil(uut.RemoveUsersFromGroup(context.Background(), group1, []string{user1}))
+	{
+		user, err := uut.GetUser(context.Background(), user1)
+		assert.Nil(err)
+		assert.Empty(user.Groups)
+		assert.Empty(user.GroupRoles)
+	}
+
+	// Case 6: delete a group member
+	assert.Nil(uut.DeleteUser(context.Background(), user2))
+	{
+		group, err := uut.GetGroup(context.Background(), group1)
+		assert.Nil(err)
+		assert.Empty(group.Users)
+	}
+
+	// Case 7: delete group
+	assert.Nil(uut.AddUsersToGroup(context.Background(), group1, []string{user1}))
+	assert.Nil(uut.DeleteGroup(context.Background(), group1))
+	assert.ErrorIs(uut.DeleteGroup(context.Background(), group1), ErrGroupNotFound)
+	{
+		user, err := uut.GetUser(context.Background(), user1)
+		assert.Nil(err)
+		assert.Empty(user.Groups)
+		groups, err := uut.ListAllGroups(context.Background())
+		assert.Nil(err)
+		assert.Empty(groups)
+	}
+}
+
+func TestAPIKeyManagement(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	uut, err := CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(uut.Ready())
+
+	user1 := uuid.New().String()
+	assert.Nil(uut.DefineUser(context.Background(), UserConfig{UserID: user1}, nil))
+
+	// Case 0: API key of unknown user
+	{
+		key := APIKeyInfo{KeyID: uuid.New().String(), UserID: uuid.New().String()}
+		err := uut.RecordAPIKey(context.Background(), key, uuid.New().String())
+		assert.ErrorIs(err, ErrUserNotFound)
+		_, err = uut.ListAPIKeys(context.Background(), key.UserID)
+		assert.ErrorIs(err, ErrUserNotFound)
+	}
+
+	// Case 1: record API keys
+	key1 := APIKeyInfo{KeyID: uuid.New().String(), UserID: user1}
+	hash1 := uuid.New().String()
+	assert.Nil(uut.RecordAPIKey(context.Background(), key1, hash1))
+	description := "ci-pipeline"
+	key2 := APIKeyInfo{KeyID: uuid.New().String(), UserID: user1, Description: &description}
+	hash2 := uuid.New().String()
+	assert.Nil(uut.RecordAPIKey(context.Background(), key2, hash2))
+	{
+		keys, err := uut.ListAPIKeys(context.Background(), user1)
+		assert.Nil(err)
+		assert.Len(keys, 2)
+		key, err := uut.GetAPIKeyByHash(context.Background(), hash2)
+		assert.Nil(err)
+		assert.Equal(key2.KeyID, key.KeyID)
+		assert.Equal(user1, key.UserID)
+		assert.Equal(description, *key.Description)
+		_, err = uut.GetAPIKeyByHash(context.Background(), uuid.New().String())
+		assert.ErrorIs(err, ErrAPIKeyNotFound)
+	}
+
+	// Case 2: delete API key
+	{
+		err := uut.DeleteAPIKey(context.Background(), uuid.New().String(), key1.KeyID)
+		assert.ErrorIs(err, ErrAPIKeyNotFound)
+		assert.Nil(uut.DeleteAPIKey(context.Background(), user1, key1.KeyID))
+		_, err = uut.GetAPIKeyByHash(context.Background(), hash1)
+		assert.ErrorIs(err, ErrAPIKeyNotFound)
+		keys, err := uut.ListAPIKeys(context.Background(), user1)
+		assert.Nil(err)
+		assert.Len(keys, 1)
+	}
+
+	// Case 3: delete user removes its API keys
+	{
+		assert.Nil(uut.DeleteUser(context.Background(), user1))
+		_, err := uut.GetAPIKeyByHash(context.Background(), hash2)
+		assert.ErrorIs(err, ErrAPIKeyNotFound)
+	}
+}
+
+func TestUserSearch(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidatorWithServiceAccounts(
+		`^[a-zA-Z0-9-_]+$`,
+		`^[a-zA-Z0-9-]+$`,
+		`^[a-zA-Z0-9-]+$`,
+		`^[a-zA-Z0-9-]+$`,
+		`^.+$`,
+		`^svc\.[a-zA-Z0-9-]+$`,
+	)
+	assert.Nil(err)
+	uut, err := CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(uut.Ready())
+
+	roles := []string{uuid.New().String(), uuid.New().String()}
+	prefix := uuid.New().String()
+	email := "unit-test@example.com"
+	username := "unit-tester"
+	users := []UserConfig{
+		{UserID: prefix + "-0", Email: &email},
+		{UserID: prefix + "-1", Username: &username},
+		{UserID: prefix + "_2"},
+		{UserID: uuid.New().String(), Email: &email},
+	}
+	assert.Nil(uut.DefineUser(context.Background(), users[0], []string{roles[0]}))
+	assert.Nil(uut.DefineUser(context.Background(), users[1], []string{roles[0], roles[1]}))
+	assert.Nil(uut.DefineUser(context.Background(), users[2], nil))
+	assert.Nil(uut.DefineUser(context.Background(), users[3], []string{roles[1]}))
+
+	userIDs := func(entries []UserInfo) []string {
+		result := []string{}
+		for _, entry := range entries {
+			result = append(result, entry.UserID)
+		}
+		return result
+	}
+
+	// Case 0: search by prefix
+	{
+		found, err := uut.SearchUsers(context.Background(), UserSearchFilter{UserIDPrefix: prefix})
+		assert.Nil(err)
+		assert.EqualValues(
+			[]string{users[0].UserID, users[1].UserID, users[2].UserID}, userIDs(found),
+		)
+		// The LIKE wildcards are matched literally
+		found, err = uut.SearchUsers(
+			context.Background(), UserSearchFilter{UserIDPrefix: prefix + "_"},
+		)
+		assert.Nil(err)
+		assert.EqualValues([]string{users[2].UserID}, userIDs(found))
+		found, err = uut.SearchUsers(
+			context.Background(), UserSearchFilter{UserIDPrefix: prefix, Limit: 1},
+		)
+		assert.Nil(err)
+		assert.EqualValues([]string{users[0].UserID}, userIDs(found))
+	}
+
+	// Case 1: search by username and email
+	{
+		found, err := uut.SearchUsers(context.Background(), UserSearchFilter{Username: username})
+		assert.Nil(err)
+		assert.EqualValues([]string{users[1].UserID}, userIDs(found))
+		found, err = uut.SearchUsers(
+			context.Background(), UserSearchFilter{UserIDPrefix: prefix, Email: email},
+		)
+		assert.Nil(err)
+		assert.EqualValues([]string{users[0].UserID}, userIDs(found))
+	}
+
+	// Case 2: search by role
+	{
+		found, err := uut.SearchUsers(context.Background(), UserSearchFilter{Role: roles[1]})
+		assert.Nil(err)
+		assert.ElementsMatch([]string{users[1].UserID, users[3].UserID}, userIDs(found))
+		found, err = uut.SearchUsers(
+			context.Background(), UserSearchFilter{Role: roles[1], Email: email},
+		)
+		assert.Nil(err)
+		assert.EqualValues([]string{users[3].UserID}, userIDs(found))
+		found, err = uut.SearchUsers(
+			context.Background(), UserSearchFilter{Role: uuid.New().String()},
+		)
+		assert.Nil(err)
+		assert.Empty(found)
+	}
+
+	// Case 3: service accounts must use a valid service account ID
+	{
+		assert.NotNil(uut.DefineUser(
+			context.Background(), UserConfig{UserID: prefix + "-3", ServiceAccount: true}, nil,
+		))
+		serviceAccount := UserConfig{UserID: "svc." + prefix, ServiceAccount: true}
+		assert.Nil(uut.DefineUser(context.Background(), serviceAccount, []string{roles[1]}))
+		// The user ID regex accepts service account IDs as well
+		assert.Nil(uut.DefineUser(
+			context.Background(), UserConfig{UserID: "svc." + prefix + "-human"}, nil,
+		))
+		assert.NotNil(uut.UpdateUser(
+			context.Background(), users[2].UserID,
+			UserConfig{UserID: users[2].UserID, ServiceAccount: true},
+		))
+		user, err := uut.GetUser(context.Background(), serviceAccount.UserID)
+		assert.Nil(err)
+		assert.True(user.ServiceAccount)
+	}
+
+	// Case 4: search by account type
+	{
+		found, err := uut.SearchUsers(
+			context.Background(),
+			UserSearchFilter{Role: roles[1], AccountType: AccountTypeServiceAccount},
+		)
+		assert.Nil(err)
+		assert.EqualValues([]string{"svc." + prefix}, userIDs(found))
+		found, err = uut.SearchUsers(
+			context.Background(), UserSearchFilter{Role: roles[1], AccountType: AccountTypeUser},
+		)
+		assert.Nil(err)
+		assert.ElementsMatch([]string{users[1].UserID, users[3].UserID}, userIDs(found))
+		_, err = uut.SearchUsers(context.Background(), UserSearchFilter{AccountType: "robot"})
+		assert.NotNil(err)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+
+	// Case 0: empty DB
+	{
+		missing, err := CheckSchema(db)
+		assert.Nil(err)
+		assert.Equal(
+			[]string{
+				"table db_users",
+				"table db_roles",
+				"table db_permissions",
+				"table db_groups",
+				"table db_api_keys",
+				"table user_roles",
+				"table user_permissions",
+				"table user_groups",
+				"table group_roles",
+			},
+			missing,
+		)
+	}
+
+	// Case 1: DB missing a column
+	assert.Nil(db.AutoMigrate(&dbUser{}, &dbRole{}, &dbAPIKey{}))
+	assert.Nil(db.Migrator().DropColumn(&dbUser{}, "email"))
+	{
+		missing, err := CheckSchema(db)
+		assert.Nil(err)
+		assert.Equal([]string{"column db_users.email"}, missing)
+	}
+
+	// Case 2: the DB client creates the missing parts
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	_, err = CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	{
+		missing, err := CheckSchema(db)
+		assert.Nil(err)
+		assert.Empty(missing)
+	}
 }
 
 func roleListToMap(i []string) map[string]bool {
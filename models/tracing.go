@@ -0,0 +1,152 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tracingPluginName is the name the tracing plugin is registered under
+const tracingPluginName = "padlock:tracing"
+
+// tracingSpanKey is the statement instance key holding the span of the operation
+const tracingSpanKey = "padlock:tracing_span"
+
+// tracingPlugin is a GORM plugin recording an OpenTelemetry span for each database operation,
+// as a child of the span in the context of the operation
+type tracingPlugin struct {
+	tracer trace.Tracer
+}
+
+/*
+installTracing install the tracing plugin on a GORM DB client, if not already installed
+
+	@param db *gorm.DB - GORM DB client
+	@return whether successful
+*/
+func installTracing(db *gorm.DB) error {
+	if _, ok := db.Config.Plugins[tracingPluginName]; ok {
+		return nil
+	}
+	return db.Use(tracingPlugin{tracer: otel.Tracer("github.com/alwitt/padlock/models")})
+}
+
+// Name implements gorm.Plugin
+func (p tracingPlugin) Name() string {
+	return tracingPluginName
+}
+
+// Initialize implements gorm.Plugin
+func (p tracingPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	registrations := []func() error{
+		func() error {
+			return callbacks.Create().Before("gorm:create").
+				Register("padlock:trace_before_create", p.startSpan("create"))
+		},
+		func() error {
+			return callbacks.Create().After("gorm:create").
+				Register("padlock:trace_after_create", p.endSpan)
+		},
+		func() error {
+			return callbacks.Query().Before("gorm:query").
+				Register("padlock:trace_before_query", p.startSpan("query"))
+		},
+		func() error {
+			return callbacks.Query().After("gorm:query").
+				Register("padlock:trace_after_query", p.endSpan)
+		},
+		func() error {
+			return callbacks.Update().Before("gorm:update").
+				Register("padlock:trace_before_update", p.startSpan("update"))
+		},
+		func() error {
+			return callbacks.Update().After("gorm:update").
+				Register("padlock:trace_after_update", p.endSpan)
+		},
+		func() error {
+			return callbacks.Delete().Before("gorm:delete").
+				Register("padlock:trace_before_delete", p.startSpan("delete"))
+		},
+		func() error {
+			return callbacks.Delete().After("gorm:delete").
+				Register("padlock:trace_after_delete", p.endSpan)
+		},
+		func() error {
+			return callbacks.Row().Before("gorm:row").
+				Register("padlock:trace_before_row", p.startSpan("row"))
+		},
+		func() error {
+			return callbacks.Row().After("gorm:row").
+				Register("padlock:trace_after_row", p.endSpan)
+		},
+		func() error {
+			return callbacks.Raw().Before("gorm:raw").
+				Register("padlock:trace_before_raw", p.startSpan("raw"))
+		},
+		func() error {
+			return callbacks.Raw().After("gorm:raw").
+				Register("padlock:trace_after_raw", p.endSpan)
+		},
+	}
+	for _, register := range registrations {
+		if err := register(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+startSpan define the callback starting the span of a database operation
+
+	@param operation string - the database operation
+	@return the callback
+*/
+func (p tracingPlugin) startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement == nil || db.Statement.Context == nil {
+			return
+		}
+		ctxt, span := p.tracer.Start(
+			db.Statement.Context,
+			fmt.Sprintf("db.%s", operation),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemKey.String(db.Dialector.Name())),
+		)
+		db.Statement.Context = ctxt
+		db.InstanceSet(tracingSpanKey, span)
+	}
+}
+
+/*
+endSpan the callback ending the span of a database operation
+
+	@param db *gorm.DB - GORM DB client
+*/
+func (p tracingPlugin) endSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+	span.SetAttributes(
+		semconv.DBStatement(db.Statement.SQL.String()),
+		semconv.DBSQLTable(db.Statement.Table),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
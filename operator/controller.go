@@ -0,0 +1,131 @@
+package operator
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+)
+
+// PolicyController applies the policy read from the custom resources
+type PolicyController interface {
+	/*
+		Sync read the policy custom resources, and apply them if they pass validation and differ
+		from the policy in use
+
+			@param ctxt context.Context - the operating context
+			@return whether the policy changed
+	*/
+	Sync(ctxt context.Context) (bool, error)
+}
+
+// policyControllerImpl implements PolicyController
+type policyControllerImpl struct {
+	source     PolicySource
+	manager    users.Management
+	local      PolicyResources
+	validate   func(PolicyResources) error
+	applyRules func([]common.HostAuthorizationConfig) error
+	logTags    log.Fields
+
+	lock sync.Mutex
+	// rules is the authorization rules in use
+	rules []common.HostAuthorizationConfig
+}
+
+/*
+DefinePolicyController define a new policy controller
+
+	@param source PolicySource - the source of the policy custom resources
+	@param manager users.Management - if provided, the user management instance to apply the
+	roles to
+	@param local PolicyResources - the roles and the rules of the local config, which are used
+	while no resource of that kind exists
+	@param validate func(PolicyResources) error - validates the policy against the rest of the
+	application config
+	@param applyRules func([]common.HostAuthorizationConfig) error - if provided, rebuilds the
+	request matcher with the authorization rules
+	@return new PolicyController instance
+*/
+func DefinePolicyController(
+	source PolicySource,
+	manager users.Management,
+	local PolicyResources,
+	validate func(PolicyResources) error,
+	applyRules func([]common.HostAuthorizationConfig) error,
+) PolicyController {
+	return &policyControllerImpl{
+		source:     source,
+		manager:    manager,
+		local:      local,
+		validate:   validate,
+		applyRules: applyRules,
+		logTags:    log.Fields{"module": "operator", "component": "policy-controller"},
+		lock:       sync.Mutex{},
+		rules:      local.Rules,
+	}
+}
+
+/*
+Sync read the policy custom resources, and apply them if they pass validation and differ
+from the policy in use
+
+	@param ctxt context.Context - the operating context
+	@return whether the policy changed
+*/
+func (c *policyControllerImpl) Sync(ctxt context.Context) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	policy, err := c.source.Fetch(ctxt)
+	if err != nil {
+		log.WithError(err).WithFields(c.logTags).Error("Failed to read policy custom resources")
+		return false, err
+	}
+	// Fall back to the local config for the kinds without any resource
+	if len(policy.Roles) == 0 {
+		policy.Roles = c.local.Roles
+	}
+	if len(policy.Rules) == 0 {
+		policy.Rules = c.local.Rules
+	}
+	if err := c.validate(policy); err != nil {
+		log.WithError(err).WithFields(c.logTags).Error("Policy custom resources failed validation")
+		return false, err
+	}
+
+	changed := false
+	if c.manager != nil {
+		// The roles in use have their inheritance resolved, so compare them with the roles of
+		// the policy resolved the same way
+		resolved, err := common.ResolveRoleInheritance(policy.Roles)
+		if err != nil {
+			log.WithError(err).WithFields(c.logTags).Error("Unable to resolve the role inheritance")
+			return false, err
+		}
+		current, err := c.manager.ListAllRoles(ctxt)
+		if err != nil {
+			return false, err
+		}
+		if !reflect.DeepEqual(current, resolved) {
+			if err := c.manager.AlignRolesWithConfig(ctxt, policy.Roles); err != nil {
+				return false, err
+			}
+			log.WithFields(c.logTags).Infof("Applied %d roles", len(policy.Roles))
+			changed = true
+		}
+	}
+	if c.applyRules != nil && !reflect.DeepEqual(c.rules, policy.Rules) {
+		if err := c.applyRules(policy.Rules); err != nil {
+			log.WithError(err).WithFields(c.logTags).Error("Failed to apply authorization rules")
+			return changed, err
+		}
+		c.rules = policy.Rules
+		log.WithFields(c.logTags).Infof("Applied authorization rules of %d hosts", len(c.rules))
+		changed = true
+	}
+	return changed, nil
+}
@@ -0,0 +1,203 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/padlocktest"
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyController(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	// The service account files
+	saDir := t.TempDir()
+	tokenFile := filepath.Join(saDir, "token")
+	assert.Nil(os.WriteFile(tokenFile, []byte("unit-test\n"), 0600))
+	assert.Nil(os.WriteFile(filepath.Join(saDir, "namespace"), []byte("padlock"), 0600))
+
+	// The custom resources served by the API server
+	resources := map[string]string{RoleResource: `{"items": []}`, RuleResource: `{"items": []}`}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer unit-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		for resource, list := range resources {
+			if r.URL.Path == fmt.Sprintf(
+				"/apis/padlock.unit-test.org/v1/namespaces/padlock/%s", resource,
+			) {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(list))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	source, err := DefineKubernetesPolicySource(common.KubernetesPolicyConfig{
+		Enabled:   true,
+		APIServer: server.URL,
+		TokenFile: tokenFile,
+		Group:     "padlock.unit-test.org",
+		Version:   "v1",
+		Timeout:   5,
+	})
+	assert.Nil(err)
+
+	localRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}
+	localRules := []common.HostAuthorizationConfig{
+		{
+			Host: "*",
+			TargetPaths: []common.PathAuthorizationConfig{
+				{
+					PathRegexPattern: "^/.+$",
+					AllowedMethods: []common.PermissionForAPIMethodConfig{
+						{Method: "GET", Permissions: []string{"read"}},
+					},
+				},
+			},
+		},
+	}
+	manager, err := padlocktest.DefineManagement(localRoles)
+	assert.Nil(err)
+
+	validateErr := error(nil)
+	appliedRules := [][]common.HostAuthorizationConfig{}
+	uut := DefinePolicyController(
+		source,
+		manager,
+		PolicyResources{Roles: localRoles, Rules: localRules},
+		func(policy PolicyResources) error { return validateErr },
+		func(rules []common.HostAuthorizationConfig) error {
+			appliedRules = append(appliedRules, rules)
+			return nil
+		},
+	)
+
+	utCtxt := context.Background()
+
+	// Case 0: no custom resources, the local config remains in use
+	{
+		changed, err := uut.Sync(utCtxt)
+		assert.Nil(err)
+		assert.False(changed)
+		assert.Empty(appliedRules)
+	}
+
+	// Case 1: roles and rules defined by the custom resources
+	{
+		resources[RoleResource] = `{"items": [
+			{"metadata": {"name": "reader"}, "spec": {"permissions": ["read"]}},
+			{"metadata": {"name": "writer"}, "spec": {"permissions": ["read", "write"]}}
+		]}`
+		resources[RuleResource] = `{"items": [
+			{
+				"metadata": {"name": "unit-test"},
+				"spec": {
+					"host": "unit-test.org",
+					"allowedPaths": [
+						{
+							"pathPattern": "^/.+$",
+							"allowedMethods": [{"method": "PUT", "allowedPermissions": ["write"]}]
+						}
+					]
+				}
+			},
+			{
+				"metadata": {"name": "wildcard"},
+				"spec": {
+					"host": "*",
+					"denyPaths": [{"pathPattern": "^/admin$"}]
+				}
+			}
+		]}`
+		changed, err := uut.Sync(utCtxt)
+		assert.Nil(err)
+		assert.True(changed)
+		roles, err := manager.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.Contains(roles, "writer")
+		assert.Len(appliedRules, 1)
+		assert.Len(appliedRules[0], 2)
+		// Sorted by host
+		assert.Equal("*", appliedRules[0][0].Host)
+		assert.Equal("unit-test.org", appliedRules[0][1].Host)
+		assert.Equal("write", appliedRules[0][1].TargetPaths[0].AllowedMethods[0].Permissions[0])
+	}
+
+	// Case 2: unchanged custom resources
+	{
+		changed, err := uut.Sync(utCtxt)
+		assert.Nil(err)
+		assert.False(changed)
+		assert.Len(appliedRules, 1)
+	}
+
+	// Case 3: custom resources failing validation are not applied
+	{
+		resources[RoleResource] = `{"items": [
+			{"metadata": {"name": "reader"}, "spec": {"permissions": ["read"]}}
+		]}`
+		validateErr = fmt.Errorf("unit-test")
+		_, err := uut.Sync(utCtxt)
+		assert.NotNil(err)
+		roles, err := manager.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.Contains(roles, "writer")
+		validateErr = nil
+	}
+
+	// Case 4: API server not available
+	{
+		status = http.StatusForbidden
+		_, err := uut.Sync(utCtxt)
+		assert.NotNil(err)
+		status = http.StatusOK
+	}
+
+	// Case 5: the rules custom resources are removed
+	{
+		resources[RuleResource] = `{"items": []}`
+		changed, err := uut.Sync(utCtxt)
+		assert.Nil(err)
+		assert.True(changed)
+		roles, err := manager.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.NotContains(roles, "writer")
+		assert.Len(appliedRules, 2)
+		assert.Equal(localRules, appliedRules[1])
+	}
+
+	// Case 6: roles inheriting from other roles
+	{
+		resources[RoleResource] = `{"items": [
+			{"metadata": {"name": "reader"}, "spec": {"permissions": ["read"]}},
+			{"metadata": {"name": "editor"}, "spec": {"permissions": ["write"], "inherits": ["reader"]}}
+		]}`
+		changed, err := uut.Sync(utCtxt)
+		assert.Nil(err)
+		assert.True(changed)
+		roles, err := manager.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.EqualValues([]string{"write", "read"}, roles["editor"].AssignedPermissions)
+		// The same resources again are not a change
+		changed, err = uut.Sync(utCtxt)
+		assert.Nil(err)
+		assert.False(changed)
+		assert.Len(appliedRules, 2)
+	}
+}
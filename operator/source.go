@@ -0,0 +1,189 @@
+package operator
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+)
+
+// Custom resource kinds, by their plural resource names
+const (
+	// RoleResource is the plural resource name of the "PadlockRole" custom resource
+	RoleResource = "padlockroles"
+	// RuleResource is the plural resource name of the "PadlockAuthorizationRule" custom resource
+	RuleResource = "padlockauthorizationrules"
+)
+
+// PolicyResources is the policy read from the custom resources
+type PolicyResources struct {
+	// Roles is the roles, keyed by role name, read from the "PadlockRole" resources
+	Roles map[string]common.UserRoleConfig
+	// Rules is the authorization rules, sorted by host, read from the
+	// "PadlockAuthorizationRule" resources
+	Rules []common.HostAuthorizationConfig
+}
+
+// PolicySource is a source of the policy custom resources
+type PolicySource interface {
+	/*
+		Fetch read the policy custom resources
+
+			@param ctxt context.Context - the operating context
+			@return the policy
+	*/
+	Fetch(ctxt context.Context) (PolicyResources, error)
+}
+
+// resourceMeta is the metadata of a custom resource
+type resourceMeta struct {
+	Name string `json:"name"`
+}
+
+// roleResourceList is the list of "PadlockRole" resources, as returned by the API server
+type roleResourceList struct {
+	Items []struct {
+		Metadata resourceMeta          `json:"metadata"`
+		Spec     common.UserRoleConfig `json:"spec"`
+	} `json:"items"`
+}
+
+// ruleResourceList is the list of "PadlockAuthorizationRule" resources, as returned by the
+// API server
+type ruleResourceList struct {
+	Items []struct {
+		Metadata resourceMeta                   `json:"metadata"`
+		Spec     common.HostAuthorizationConfig `json:"spec"`
+	} `json:"items"`
+}
+
+// kubernetesPolicySource implements PolicySource with the Kubernetes API server
+type kubernetesPolicySource struct {
+	apiServer string
+	namespace string
+	tokenFile string
+	group     string
+	version   string
+	client    *http.Client
+}
+
+/*
+DefineKubernetesPolicySource define a policy source reading the custom resources from the
+Kubernetes API server
+
+	@param cfg common.KubernetesPolicyConfig - the Kubernetes policy config
+	@return new PolicySource instance
+*/
+func DefineKubernetesPolicySource(cfg common.KubernetesPolicyConfig) (PolicySource, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read API server CA file %s: %w", cfg.CAFile, err)
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("API server CA file %s holds no certificate", cfg.CAFile)
+		}
+	}
+	// Default to the namespace of the service account
+	namespace := cfg.Namespace
+	if namespace == "" {
+		content, err := os.ReadFile(filepath.Join(filepath.Dir(cfg.TokenFile), "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read service account namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(content))
+	}
+	return &kubernetesPolicySource{
+		apiServer: strings.TrimSuffix(cfg.APIServer, "/"),
+		namespace: namespace,
+		tokenFile: cfg.TokenFile,
+		group:     cfg.Group,
+		version:   cfg.Version,
+		client: &http.Client{
+			Timeout:   time.Second * time.Duration(cfg.Timeout),
+			Transport: &http.Transport{TLSClientConfig: tlsCfg},
+		},
+	}, nil
+}
+
+/*
+Fetch read the policy custom resources
+
+	@param ctxt context.Context - the operating context
+	@return the policy
+*/
+func (s *kubernetesPolicySource) Fetch(ctxt context.Context) (PolicyResources, error) {
+	var roles roleResourceList
+	if err := s.list(ctxt, RoleResource, &roles); err != nil {
+		return PolicyResources{}, err
+	}
+	var rules ruleResourceList
+	if err := s.list(ctxt, RuleResource, &rules); err != nil {
+		return PolicyResources{}, err
+	}
+
+	policy := PolicyResources{
+		Roles: map[string]common.UserRoleConfig{},
+		Rules: []common.HostAuthorizationConfig{},
+	}
+	// The role is named by the resource
+	for _, role := range roles.Items {
+		policy.Roles[role.Metadata.Name] = role.Spec
+	}
+	for _, rule := range rules.Items {
+		policy.Rules = append(policy.Rules, rule.Spec)
+	}
+	sort.SliceStable(policy.Rules, func(i, j int) bool {
+		return policy.Rules[i].Host < policy.Rules[j].Host
+	})
+	return policy, nil
+}
+
+/*
+list read all the resources of a kind in the namespace
+
+	@param ctxt context.Context - the operating context
+	@param resource string - the plural resource name
+	@param result interface{} - the list to parse the response into
+*/
+func (s *kubernetesPolicySource) list(
+	ctxt context.Context, resource string, result interface{},
+) error {
+	// The service account token is rotated, so it is read before each call
+	token, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return fmt.Errorf("unable to read service account token: %w", err)
+	}
+	url := fmt.Sprintf(
+		"%s/apis/%s/%s/namespaces/%s/%s", s.apiServer, s.group, s.version, s.namespace, resource,
+	)
+	req, err := http.NewRequestWithContext(ctxt, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("listing %s responded with %d", resource, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("unable to parse %s: %w", resource, err)
+	}
+	return nil
+}
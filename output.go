@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Supported CLI command output formats
+const (
+	outputFormatText = "text"
+	outputFormatJSON = "json"
+)
+
+// commandResult is the JSON output of a CLI command. The fields are stable, so pipelines can
+// parse the outcome of a command.
+type commandResult struct {
+	// Command is the command name
+	Command string `json:"command"`
+	// Success is whether the command succeeded
+	Success bool `json:"success"`
+	// Error is the error which failed the command
+	Error string `json:"error,omitempty"`
+	// Result is the command specific result
+	Result interface{} `json:"result,omitempty"`
+}
+
+// jsonOutput whether the CLI commands output JSON
+func jsonOutput() bool {
+	return cmdArgs.OutputFormat == outputFormatJSON
+}
+
+/*
+commandAction define a CLI command action which, with JSON output, prints the result of the
+command, or its error, as a commandResult
+
+	@param command string - the command name
+	@param action func(c *cli.Context) (interface{}, error) - the command action, which returns
+	the command specific result
+	@return the CLI command action
+*/
+func commandAction(
+	command string, action func(c *cli.Context) (interface{}, error),
+) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		switch cmdArgs.OutputFormat {
+		case outputFormatText, outputFormatJSON:
+		default:
+			return fmt.Errorf("unsupported output format '%s'", cmdArgs.OutputFormat)
+		}
+		result, err := action(c)
+		if !jsonOutput() {
+			return err
+		}
+		output := commandResult{Command: command, Success: err == nil, Result: result}
+		if err != nil {
+			output.Error = err.Error()
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(&output); encodeErr != nil {
+			return fmt.Errorf("failed to output %s result: %w", command, encodeErr)
+		}
+		return err
+	}
+}
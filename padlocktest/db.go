@@ -0,0 +1,664 @@
+// Package padlocktest provides in-memory implementations of the Padlock interfaces, so
+// handlers and downstream services can be exercised without a real database.
+package padlocktest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alwitt/padlock/models"
+)
+
+// memUser is one user entry recorded by the in-memory DB client
+type memUser struct {
+	info        models.UserInfo
+	roles       map[string]bool
+	permissions map[string]bool
+}
+
+// memGroup is one group entry recorded by the in-memory DB client
+type memGroup struct {
+	info  models.GroupInfo
+	roles map[string]bool
+	users map[string]bool
+}
+
+// memAPIKey is one API key entry recorded by the in-memory DB client
+type memAPIKey struct {
+	info    models.APIKeyInfo
+	keyHash string
+}
+
+// memDBClient implements models.ManagementDBClient in memory
+type memDBClient struct {
+	lock    sync.RWMutex
+	roles   map[string]bool
+	users   map[string]*memUser
+	groups  map[string]*memGroup
+	apiKeys []memAPIKey
+}
+
+/*
+DefineManagementDBClient define a new in-memory models.ManagementDBClient
+
+	@return new models.ManagementDBClient instance
+*/
+func DefineManagementDBClient() models.ManagementDBClient {
+	return &memDBClient{
+		lock:    sync.RWMutex{},
+		roles:   map[string]bool{},
+		users:   map[string]*memUser{},
+		groups:  map[string]*memGroup{},
+		apiKeys: []memAPIKey{},
+	}
+}
+
+/*
+Ready checks whether the client is ready for use.
+
+	@return nil if ready, or an error otherwise
+*/
+func (c *memDBClient) Ready() error {
+	return nil
+}
+
+/*
+AlignRolesWithConfig aligns the role entries with the configuration provided
+
+	@param ctxt context.Context - context calling this API
+	@param configuredRoles []string - the list of configured roles
+	@return whether successful
+*/
+func (c *memDBClient) AlignRolesWithConfig(ctxt context.Context, configuredRoles []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	newRoles := map[string]bool{}
+	for _, roleName := range configuredRoles {
+		newRoles[roleName] = true
+	}
+	// Remove the roles no longer configured from the users and groups
+	for _, oneUser := range c.users {
+		for roleName := range oneUser.roles {
+			if !newRoles[roleName] {
+				delete(oneUser.roles, roleName)
+			}
+		}
+	}
+	for _, oneGroup := range c.groups {
+		for roleName := range oneGroup.roles {
+			if !newRoles[roleName] {
+				delete(oneGroup.roles, roleName)
+			}
+		}
+	}
+	c.roles = newRoles
+	return nil
+}
+
+/*
+ListAllRoles query for the list of known roles
+
+	@param ctxt context.Context - context calling this API
+	@return the list roles
+*/
+func (c *memDBClient) ListAllRoles(ctxt context.Context) ([]string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	result := []string{}
+	for roleName := range c.roles {
+		result = append(result, roleName)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+/*
+GetUsersOfRole query for the list of users which have that role.
+
+	@param ctxt context.Context - context calling this API
+	@param role string - the role
+	@return the list of users
+*/
+func (c *memDBClient) GetUsersOfRole(ctxt context.Context, role string) ([]models.UserInfo, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if !c.roles[role] {
+		return nil, fmt.Errorf("role %s is unknown", role)
+	}
+	result := []models.UserInfo{}
+	for _, oneUser := range c.users {
+		if oneUser.roles[role] {
+			result = append(result, oneUser.info)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result, nil
+}
+
+/*
+DefineUser define a user entry with roles
+
+	@param ctxt context.Context - context calling this API
+	@param config models.UserConfig - user config
+	@param roles []string - roles for this user
+	@return whether successful
+*/
+func (c *memDBClient) DefineUser(
+	ctxt context.Context, config models.UserConfig, roles []string,
+) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.users[config.UserID]; ok {
+		return fmt.Errorf("user %s already exists", config.UserID)
+	}
+	currentTime := time.Now().UTC()
+	newEntry := &memUser{
+		info: models.UserInfo{
+			CreatedAt: currentTime, UpdatedAt: currentTime, UserConfig: config,
+		},
+		roles:       map[string]bool{},
+		permissions: map[string]bool{},
+	}
+	for _, roleName := range roles {
+		c.roles[roleName] = true
+		newEntry.roles[roleName] = true
+	}
+	c.users[config.UserID] = newEntry
+	return nil
+}
+
+/*
+GetUser query for a user by ID
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@return the user information
+*/
+func (c *memDBClient) GetUser(ctxt context.Context, id string) (models.UserDetails, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entry, ok := c.users[id]
+	if !ok {
+		return models.UserDetails{}, fmt.Errorf("%w %s", models.ErrUserNotFound, id)
+	}
+	roles := []string{}
+	for roleName := range entry.roles {
+		roles = append(roles, roleName)
+	}
+	sort.Strings(roles)
+	permissions := []string{}
+	for permission := range entry.permissions {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	groups := []string{}
+	groupRoles := map[string]bool{}
+	for groupName, oneGroup := range c.groups {
+		if !oneGroup.users[id] {
+			continue
+		}
+		groups = append(groups, groupName)
+		for roleName := range oneGroup.roles {
+			groupRoles[roleName] = true
+		}
+	}
+	sort.Strings(groups)
+	return models.UserDetails{
+		UserInfo:    entry.info,
+		Roles:       roles,
+		Permissions: permissions,
+		Groups:      groups,
+		GroupRoles:  sortedKeys(groupRoles),
+	}, nil
+}
+
+/*
+ListAllUsers query for all users in system
+
+	@param ctxt context.Context - context calling this API
+	@return the list of users in system
+*/
+func (c *memDBClient) ListAllUsers(ctxt context.Context) ([]models.UserInfo, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	result := []models.UserInfo{}
+	for _, oneUser := range c.users {
+		result = append(result, oneUser.info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result, nil
+}
+
+/*
+SearchUsers query for the users matching the search filter, ordered by user ID
+
+	@param ctxt context.Context - context calling this API
+	@param filter models.UserSearchFilter - the search filter
+	@return the list of matching users
+*/
+func (c *memDBClient) SearchUsers(
+	ctxt context.Context, filter models.UserSearchFilter,
+) ([]models.UserInfo, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	result := []models.UserInfo{}
+	for _, oneUser := range c.users {
+		info := oneUser.info
+		if !strings.HasPrefix(info.UserID, filter.UserIDPrefix) {
+			continue
+		}
+		if filter.Username != "" && (info.Username == nil || *info.Username != filter.Username) {
+			continue
+		}
+		if filter.Email != "" && (info.Email == nil || *info.Email != filter.Email) {
+			continue
+		}
+		if filter.Role != "" && !oneUser.roles[filter.Role] {
+			continue
+		}
+		if filter.AccountType != "" &&
+			info.ServiceAccount != (filter.AccountType == models.AccountTypeServiceAccount) {
+			continue
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
+
+/*
+DeleteUser deletes a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@return whether successful
+*/
+func (c *memDBClient) DeleteUser(ctxt context.Context, id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.users[id]; !ok {
+		return fmt.Errorf("%w %s", models.ErrUserNotFound, id)
+	}
+	delete(c.users, id)
+	for _, oneGroup := range c.groups {
+		delete(oneGroup.users, id)
+	}
+	remaining := []memAPIKey{}
+	for _, oneKey := range c.apiKeys {
+		if oneKey.info.UserID != id {
+			remaining = append(remaining, oneKey)
+		}
+	}
+	c.apiKeys = remaining
+	return nil
+}
+
+/*
+UpdateUser update the parameters for a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param newConfig models.UserConfig - new user config
+	@return whether successful
+*/
+func (c *memDBClient) UpdateUser(
+	ctxt context.Context, id string, newConfig models.UserConfig,
+) error {
+	if id != newConfig.UserID {
+		return fmt.Errorf("update details contains different ID")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.users[id]
+	if !ok {
+		return fmt.Errorf("%w %s", models.ErrUserNotFound, id)
+	}
+	entry.info.UserConfig = newConfig
+	entry.info.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+/*
+AddRolesToUser add new roles to a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param newRoles []string - new roles for this user
+	@return whether successful
+*/
+func (c *memDBClient) AddRolesToUser(ctxt context.Context, id string, newRoles []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.users[id]
+	if !ok {
+		return fmt.Errorf("%w %s", models.ErrUserNotFound, id)
+	}
+	for _, roleName := range newRoles {
+		c.roles[roleName] = true
+		entry.roles[roleName] = true
+	}
+	return nil
+}
+
+/*
+SetUserRoles change the roles of a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param newRoles []string - new roles for this user
+	@return whether successful
+*/
+func (c *memDBClient) SetUserRoles(ctxt context.Context, id string, newRoles []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.users[id]
+	if !ok {
+		return fmt.Errorf("%w %s", models.ErrUserNotFound, id)
+	}
+	entry.roles = map[string]bool{}
+	for _, roleName := range newRoles {
+		c.roles[roleName] = true
+		entry.roles[roleName] = true
+	}
+	return nil
+}
+
+/*
+RemoveRolesFromUser remove roles from user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param roles []string - roles to remove from user
+	@return whether successful
+*/
+func (c *memDBClient) RemoveRolesFromUser(ctxt context.Context, id string, roles []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.users[id]
+	if !ok {
+		return fmt.Errorf("%w %s", models.ErrUserNotFound, id)
+	}
+	for _, roleName := range roles {
+		delete(entry.roles, roleName)
+	}
+	return nil
+}
+
+/*
+SetUserPermissions change the permissions granted directly to a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param permissions []string - new directly granted permissions for this user
+	@return whether successful
+*/
+func (c *memDBClient) SetUserPermissions(
+	ctxt context.Context, id string, permissions []string,
+) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.users[id]
+	if !ok {
+		return fmt.Errorf("%w %s", models.ErrUserNotFound, id)
+	}
+	entry.permissions = map[string]bool{}
+	for _, permission := range permissions {
+		entry.permissions[permission] = true
+	}
+	return nil
+}
+
+/*
+DefineGroup define a group entry with roles
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param roles []string - roles for the members of this group
+	@return whether successful
+*/
+func (c *memDBClient) DefineGroup(ctxt context.Context, name string, roles []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.groups[name]; ok {
+		return fmt.Errorf("group %s already exists", name)
+	}
+	currentTime := time.Now().UTC()
+	newEntry := &memGroup{
+		info:  models.GroupInfo{CreatedAt: currentTime, UpdatedAt: currentTime, GroupName: name},
+		roles: map[string]bool{},
+		users: map[string]bool{},
+	}
+	for _, roleName := range roles {
+		c.roles[roleName] = true
+		newEntry.roles[roleName] = true
+	}
+	c.groups[name] = newEntry
+	return nil
+}
+
+/*
+ListAllGroups query for the list of groups
+
+	@param ctxt context.Context - context calling this API
+	@return the list of groups
+*/
+func (c *memDBClient) ListAllGroups(ctxt context.Context) ([]string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	result := []string{}
+	for groupName := range c.groups {
+		result = append(result, groupName)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+/*
+GetGroup query for a group by name
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@return the group information
+*/
+func (c *memDBClient) GetGroup(ctxt context.Context, name string) (models.GroupDetails, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entry, ok := c.groups[name]
+	if !ok {
+		return models.GroupDetails{}, fmt.Errorf("%w %s", models.ErrGroupNotFound, name)
+	}
+	return models.GroupDetails{
+		GroupInfo: entry.info, Roles: sortedKeys(entry.roles), Users: sortedKeys(entry.users),
+	}, nil
+}
+
+/*
+DeleteGroup deletes a group. The members of the group are not deleted.
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@return whether successful
+*/
+func (c *memDBClient) DeleteGroup(ctxt context.Context, name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.groups[name]; !ok {
+		return fmt.Errorf("%w %s", models.ErrGroupNotFound, name)
+	}
+	delete(c.groups, name)
+	return nil
+}
+
+/*
+SetGroupRoles change the roles of a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param newRoles []string - new roles for the members of this group
+	@return whether successful
+*/
+func (c *memDBClient) SetGroupRoles(ctxt context.Context, name string, newRoles []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.groups[name]
+	if !ok {
+		return fmt.Errorf("%w %s", models.ErrGroupNotFound, name)
+	}
+	entry.roles = map[string]bool{}
+	for _, roleName := range newRoles {
+		c.roles[roleName] = true
+		entry.roles[roleName] = true
+	}
+	return nil
+}
+
+/*
+AddUsersToGroup add users to a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (c *memDBClient) AddUsersToGroup(ctxt context.Context, name string, ids []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.groups[name]
+	if !ok {
+		return fmt.Errorf("%w %s", models.ErrGroupNotFound, name)
+	}
+	for _, id := range ids {
+		if _, ok := c.users[id]; !ok {
+			return fmt.Errorf("%w %s", models.ErrUserNotFound, id)
+		}
+	}
+	for _, id := range ids {
+		entry.users[id] = true
+	}
+	return nil
+}
+
+/*
+RemoveUsersFromGroup remove users from a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (c *memDBClient) RemoveUsersFromGroup(ctxt context.Context, name string, ids []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.groups[name]
+	if !ok {
+		return fmt.Errorf("%w %s", models.ErrGroupNotFound, name)
+	}
+	for _, id := range ids {
+		delete(entry.users, id)
+	}
+	return nil
+}
+
+/*
+RecordAPIKey record a new API key of a user
+
+	@param ctxt context.Context - context calling this API
+	@param key models.APIKeyInfo - the API key parameters
+	@param keyHash string - the hash of the API key
+	@return whether successful
+*/
+func (c *memDBClient) RecordAPIKey(
+	ctxt context.Context, key models.APIKeyInfo, keyHash string,
+) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.users[key.UserID]; !ok {
+		return fmt.Errorf("%w %s", models.ErrUserNotFound, key.UserID)
+	}
+	for _, oneKey := range c.apiKeys {
+		if oneKey.info.KeyID == key.KeyID || oneKey.keyHash == keyHash {
+			return fmt.Errorf("API key %s already exists", key.KeyID)
+		}
+	}
+	c.apiKeys = append(c.apiKeys, memAPIKey{info: key, keyHash: keyHash})
+	return nil
+}
+
+/*
+ListAPIKeys query for the API keys of a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@return the API keys of the user
+*/
+func (c *memDBClient) ListAPIKeys(ctxt context.Context, userID string) ([]models.APIKeyInfo, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if _, ok := c.users[userID]; !ok {
+		return nil, fmt.Errorf("%w %s", models.ErrUserNotFound, userID)
+	}
+	result := []models.APIKeyInfo{}
+	for _, oneKey := range c.apiKeys {
+		if oneKey.info.UserID == userID {
+			result = append(result, oneKey.info)
+		}
+	}
+	return result, nil
+}
+
+/*
+GetAPIKeyByHash query for an API key by its hash
+
+	@param ctxt context.Context - context calling this API
+	@param keyHash string - the hash of the API key
+	@return the API key information
+*/
+func (c *memDBClient) GetAPIKeyByHash(
+	ctxt context.Context, keyHash string,
+) (models.APIKeyInfo, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, oneKey := range c.apiKeys {
+		if oneKey.keyHash == keyHash {
+			return oneKey.info, nil
+		}
+	}
+	return models.APIKeyInfo{}, models.ErrAPIKeyNotFound
+}
+
+/*
+DeleteAPIKey deletes an API key of a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@param keyID string - the API key ID
+	@return whether successful
+*/
+func (c *memDBClient) DeleteAPIKey(ctxt context.Context, userID string, keyID string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for idx, oneKey := range c.apiKeys {
+		if oneKey.info.UserID == userID && oneKey.info.KeyID == keyID {
+			c.apiKeys = append(c.apiKeys[:idx], c.apiKeys[idx+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %s of user %s", models.ErrAPIKeyNotFound, keyID, userID)
+}
+
+// sortedKeys helper function to list the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	result := []string{}
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
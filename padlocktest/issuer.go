@@ -0,0 +1,251 @@
+package padlocktest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alwitt/padlock/authenticate"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// OpenIDIssuer is a stub OpenID issuer, which issues signed tokens to anyone who asks. It
+// serves the discovery, JWKS, token, and introspection endpoints relative to the issuer URL.
+type OpenIDIssuer interface {
+	http.Handler
+
+	/*
+		IssueToken sign an access token for a user
+
+		 @param userID string - the user ID, set as the "sub" claim
+		 @param claims map[string]interface{} - additional claims of the token
+		 @return the signed token
+	*/
+	IssueToken(userID string, claims map[string]interface{}) (string, error)
+}
+
+// openIDIssuerImpl implements OpenIDIssuer
+type openIDIssuerImpl struct {
+	issuerURL     string
+	keyID         string
+	signingKey    *rsa.PrivateKey
+	tokenLifetime time.Duration
+	onIssue       func(ctxt context.Context, userID string) error
+	router        *http.ServeMux
+}
+
+/*
+DefineOpenIDIssuer define a new stub OpenID issuer. The signing key is generated on definition,
+so the JWKS is static for the lifetime of the issuer.
+
+The token endpoint supports the "password" grant, where the "username" is the user ID, and the
+"client_credentials" grant, where the "client_id" is the user ID. The credentials are not
+checked. The optional "email", "given_name", and "family_name" parameters are added to the
+token as claims.
+
+	@param issuerURL string - the URL the issuer handler is reachable at
+	@param tokenLifetime time.Duration - the lifetime of the issued tokens
+	@param onIssue func(ctxt context.Context, userID string) error - if provided, called before
+	the token endpoint issues a token for a user
+	@return new OpenIDIssuer instance
+*/
+func DefineOpenIDIssuer(
+	issuerURL string,
+	tokenLifetime time.Duration,
+	onIssue func(ctxt context.Context, userID string) error,
+) (OpenIDIssuer, error) {
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate signing key: %w", err)
+	}
+	instance := &openIDIssuerImpl{
+		issuerURL:     strings.TrimSuffix(issuerURL, "/"),
+		keyID:         uuid.NewString(),
+		signingKey:    signingKey,
+		tokenLifetime: tokenLifetime,
+		onIssue:       onIssue,
+		router:        http.NewServeMux(),
+	}
+	instance.router.HandleFunc("/.well-known/openid-configuration", instance.discovery)
+	instance.router.HandleFunc("/jwks", instance.jwks)
+	instance.router.HandleFunc("/token", instance.token)
+	instance.router.HandleFunc("/introspect", instance.introspect)
+	return instance, nil
+}
+
+/*
+ServeHTTP serve the issuer endpoints
+
+	@param w http.ResponseWriter - response writer
+	@param r *http.Request - the request
+*/
+func (i *openIDIssuerImpl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i.router.ServeHTTP(w, r)
+}
+
+/*
+IssueToken sign an access token for a user
+
+	@param userID string - the user ID, set as the "sub" claim
+	@param claims map[string]interface{} - additional claims of the token
+	@return the signed token
+*/
+func (i *openIDIssuerImpl) IssueToken(
+	userID string, claims map[string]interface{},
+) (string, error) {
+	now := time.Now().UTC()
+	tokenClaims := jwt.MapClaims{}
+	for claim, value := range claims {
+		tokenClaims[claim] = value
+	}
+	tokenClaims["iss"] = i.issuerURL
+	tokenClaims["sub"] = userID
+	tokenClaims["jti"] = uuid.NewString()
+	tokenClaims["iat"] = now.Unix()
+	tokenClaims["nbf"] = now.Unix()
+	tokenClaims["exp"] = now.Add(i.tokenLifetime).Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims)
+	token.Header["kid"] = i.keyID
+	return token.SignedString(i.signingKey)
+}
+
+// discovery serve the OpenID configuration
+func (i *openIDIssuerImpl) discovery(w http.ResponseWriter, r *http.Request) {
+	writeIssuerJSON(w, http.StatusOK, authenticate.OpenIDIssuerConfig{
+		Issuer:             i.issuerURL,
+		TokenEP:            i.issuerURL + "/token",
+		IntrospectionEP:    i.issuerURL + "/introspect",
+		JwksURI:            i.issuerURL + "/jwks",
+		TokenEPAuthMethods: []string{"client_secret_basic", "client_secret_post"},
+		ClaimsSupported: []string{
+			"sub", "preferred_username", "email", "given_name", "family_name",
+		},
+	})
+}
+
+// jwks serve the public key of the signing key
+func (i *openIDIssuerImpl) jwks(w http.ResponseWriter, r *http.Request) {
+	publicKey := i.signingKey.PublicKey
+	writeIssuerJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []authenticate.OIDSigningJWK{{
+			Algorithm: "RS256",
+			Exponent: base64.RawURLEncoding.EncodeToString(
+				big.NewInt(int64(publicKey.E)).Bytes(),
+			),
+			Modulus: base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			ID:      i.keyID,
+			Type:    "RSA",
+			Use:     "sig",
+		}},
+	})
+}
+
+// token issue a token for the user given by the grant
+func (i *openIDIssuerImpl) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeIssuerJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	var userID string
+	switch r.PostForm.Get("grant_type") {
+	case "password":
+		userID = r.PostForm.Get("username")
+	case "client_credentials":
+		userID = r.PostForm.Get("client_id")
+		if clientID, _, ok := r.BasicAuth(); ok {
+			userID = clientID
+		}
+	default:
+		writeIssuerJSON(
+			w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"},
+		)
+		return
+	}
+	if userID == "" {
+		writeIssuerJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if i.onIssue != nil {
+		if err := i.onIssue(r.Context(), userID); err != nil {
+			writeIssuerJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "server_error", "error_description": err.Error(),
+			})
+			return
+		}
+	}
+
+	claims := map[string]interface{}{"preferred_username": userID}
+	for _, claim := range []string{"email", "given_name", "family_name"} {
+		if value := r.PostForm.Get(claim); value != "" {
+			claims[claim] = value
+		}
+	}
+	if scope := r.PostForm.Get("scope"); scope != "" {
+		claims["scope"] = scope
+	}
+	token, err := i.IssueToken(userID, claims)
+	if err != nil {
+		writeIssuerJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "server_error", "error_description": err.Error(),
+		})
+		return
+	}
+	writeIssuerJSON(w, http.StatusOK, authenticate.TokenGrantResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(i.tokenLifetime / time.Second),
+		Scope:       r.PostForm.Get("scope"),
+	})
+}
+
+// introspect report whether a token issued by this issuer is still valid
+func (i *openIDIssuerImpl) introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeIssuerJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(
+		r.PostForm.Get("token"), claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			return &i.signingKey.PublicKey, nil
+		},
+	)
+	if err != nil {
+		writeIssuerJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+		return
+	}
+	response := map[string]interface{}{"active": true, "token_type": "Bearer"}
+	for claim, value := range claims {
+		response[claim] = value
+	}
+	if username, ok := claims["preferred_username"]; ok {
+		response["username"] = username
+	}
+	writeIssuerJSON(w, http.StatusOK, response)
+}
+
+// writeIssuerJSON write a JSON response
+func writeIssuerJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,115 @@
+package padlocktest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenIDIssuer(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	issuedFor := []string{}
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	uut, err := DefineOpenIDIssuer(
+		server.URL+"/issuer", time.Minute, func(ctxt context.Context, userID string) error {
+			issuedFor = append(issuedFor, userID)
+			return nil
+		},
+	)
+	assert.Nil(err)
+	mux.Handle("/issuer/", http.StripPrefix("/issuer", uut))
+
+	clientID := "padlock"
+	clientSecret := "secret"
+	client, err := apis.DefineOpenIDIssuerClient(common.OpenIDIssuerConfig{
+		Issuer: server.URL + "/issuer", ClientID: &clientID, ClientCred: &clientSecret,
+	})
+	assert.Nil(err)
+	assert.True(client.CanIntrospect())
+	ctxt := context.Background()
+	assert.Nil(client.ProbeEndpoints(ctxt))
+
+	// Case 0: token from the password grant
+	{
+		resp, err := client.RequestToken(ctxt, url.Values{
+			"grant_type": []string{"password"},
+			"username":   []string{"alice"},
+			"email":      []string{"alice@testing.org"},
+		})
+		assert.Nil(err)
+		assert.Equal("Bearer", resp.TokenType)
+		assert.Equal(int64(60), resp.ExpiresIn)
+		claims := jwt.MapClaims{}
+		_, err = client.ParseJWT(resp.AccessToken, &claims)
+		assert.Nil(err)
+		assert.Equal("alice", claims["sub"])
+		assert.Equal("alice", claims["preferred_username"])
+		assert.Equal("alice@testing.org", claims["email"])
+		assert.Equal(server.URL+"/issuer", claims["iss"])
+
+		active, err := client.IntrospectToken(ctxt, resp.AccessToken)
+		assert.Nil(err)
+		assert.True(active)
+	}
+
+	// Case 1: token from the client credentials grant
+	{
+		resp, err := client.RequestToken(ctxt, url.Values{
+			"grant_type": []string{"client_credentials"},
+		})
+		assert.Nil(err)
+		claims := jwt.MapClaims{}
+		_, err = client.ParseJWT(resp.AccessToken, &claims)
+		assert.Nil(err)
+		assert.Equal("padlock", claims["sub"])
+	}
+	assert.Equal([]string{"alice", "padlock"}, issuedFor)
+
+	// Case 2: unsupported grant
+	{
+		_, err := client.RequestToken(ctxt, url.Values{
+			"grant_type": []string{"authorization_code"},
+		})
+		assert.NotNil(err)
+	}
+
+	// Case 3: tokens not issued by the issuer are not active
+	{
+		active, err := client.IntrospectToken(ctxt, "not-a-token")
+		assert.Nil(err)
+		assert.False(active)
+
+		other, err := DefineOpenIDIssuer(server.URL+"/issuer", time.Minute, nil)
+		assert.Nil(err)
+		token, err := other.IssueToken("alice", nil)
+		assert.Nil(err)
+		active, err = client.IntrospectToken(ctxt, token)
+		assert.Nil(err)
+		assert.False(active)
+		_, err = client.ParseJWT(token, &jwt.MapClaims{})
+		assert.NotNil(err)
+	}
+
+	// Case 4: expired tokens are not active
+	{
+		expiring, err := DefineOpenIDIssuer(server.URL+"/issuer", -time.Minute, nil)
+		assert.Nil(err)
+		token, err := expiring.IssueToken("alice", nil)
+		assert.Nil(err)
+		_, err = client.ParseJWT(token, &jwt.MapClaims{})
+		assert.NotNil(err)
+	}
+}
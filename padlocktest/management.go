@@ -0,0 +1,25 @@
+package padlocktest
+
+import (
+	"context"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/users"
+)
+
+/*
+DefineManagement define a new users.Management backed by the in-memory DB client
+
+	@param roles map[string]common.UserRoleConfig - the configured roles
+	@return new users.Management instance
+*/
+func DefineManagement(roles map[string]common.UserRoleConfig) (users.Management, error) {
+	manager, err := users.CreateManagement(DefineManagementDBClient(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.AlignRolesWithConfig(context.Background(), roles); err != nil {
+		return nil, err
+	}
+	return manager, nil
+}
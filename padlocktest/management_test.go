@@ -0,0 +1,146 @@
+package padlocktest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryManagement(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	roles := map[string]common.UserRoleConfig{
+		"admin": {AssignedPermissions: []string{"admin-read", "read"}},
+		"user":  {AssignedPermissions: []string{"read"}},
+	}
+	uut, err := DefineManagement(roles)
+	assert.Nil(err)
+	assert.Nil(uut.Ready())
+
+	// Case 0: user life cycle
+	testUser := uuid.NewString()
+	{
+		assert.Nil(uut.DefineUser(
+			context.Background(), models.UserConfig{UserID: testUser}, []string{"user"},
+		))
+		assert.NotNil(uut.DefineUser(
+			context.Background(), models.UserConfig{UserID: testUser}, []string{"user"},
+		))
+		details, err := uut.GetUser(context.Background(), testUser)
+		assert.Nil(err)
+		assert.EqualValues([]string{"user"}, details.Roles)
+		assert.EqualValues([]string{"read"}, details.AssociatedPermission)
+
+		allowed, err := uut.DoesUserHavePermission(
+			context.Background(), testUser, []string{"admin-read"},
+		)
+		assert.Nil(err)
+		assert.False(allowed)
+		assert.Nil(uut.AddRolesToUser(context.Background(), testUser, []string{"admin"}))
+		allowed, err = uut.DoesUserHavePermission(
+			context.Background(), testUser, []string{"admin-read"},
+		)
+		assert.Nil(err)
+		assert.True(allowed)
+	}
+
+	// Case 1: removing a role from the config removes it from the users
+	{
+		assert.Nil(uut.AlignRolesWithConfig(
+			context.Background(), map[string]common.UserRoleConfig{"user": roles["user"]},
+		))
+		details, err := uut.GetUser(context.Background(), testUser)
+		assert.Nil(err)
+		assert.EqualValues([]string{"user"}, details.Roles)
+	}
+
+	// Case 2: delete
+	{
+		assert.Nil(uut.DeleteUser(context.Background(), testUser))
+		_, err := uut.GetUser(context.Background(), testUser)
+		assert.NotNil(err)
+		allUsers, err := uut.ListAllUsers(context.Background())
+		assert.Nil(err)
+		assert.Len(allUsers, 0)
+	}
+}
+
+func TestAuthorizationWithFakes(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	manager, err := DefineManagement(map[string]common.UserRoleConfig{
+		"admin": {AssignedPermissions: []string{"admin-read", "read"}},
+		"user":  {AssignedPermissions: []string{"read"}},
+	})
+	assert.Nil(err)
+	basicUser := uuid.NewString()
+	assert.Nil(manager.DefineUser(
+		context.Background(), models.UserConfig{UserID: basicUser}, []string{"user"},
+	))
+
+	matcher := DefineRequestMatch([]MatchRule{
+		{Host: "unit-test.org", Path: "/admin", Method: "GET", Permissions: []string{"admin-read"}},
+		{Path: "/user", Method: "GET", Permissions: []string{"read"}},
+	})
+
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	paramLoc := common.AuthorizeRequestParamLocConfig{
+		Host:      "X-Forwarded-Host",
+		Path:      "X-Forwarded-Uri",
+		Method:    "X-Forwarded-Method",
+		UserID:    "X-Caller-UserID",
+		Username:  "X-Caller-Username",
+		FirstName: "X-Caller-Firstname",
+		LastName:  "X-Caller-Lastname",
+		Email:     "X-Caller-Email",
+	}
+	svr, err := apis.BuildAuthorizationServer(
+		common.APIServerConfig{
+			APIs: common.APIConfig{
+				Endpoint:       common.EndpointConfig{PathPrefix: "/"},
+				RequestLogging: common.HTTPRequestLogging{DoNotLogHeaders: []string{}},
+			},
+		},
+		manager,
+		matcher,
+		supportMatch,
+		paramLoc,
+		common.UnknownUserActionConfig{AutoAdd: false},
+		common.AuthorizeResponseConfig{Mode: common.AuthorizeResponseModeStandard},
+		nil,
+		common.DefineReadinessGate(),
+		common.DefineInFlightTracker(nil),
+		apis.AuthorizationOptions{},
+	)
+	assert.Nil(err)
+
+	checkAllow := func(host, path string, expected int) {
+		req, err := http.NewRequest("GET", "/v1/allow", nil)
+		assert.Nil(err)
+		req.Header.Add(paramLoc.Host, host)
+		req.Header.Add(paramLoc.Path, path)
+		req.Header.Add(paramLoc.Method, "GET")
+		req.Header.Add(paramLoc.UserID, basicUser)
+		respRecorder := httptest.NewRecorder()
+		svr.Handler.ServeHTTP(respRecorder, req)
+		assert.Equal(expected, respRecorder.Code)
+	}
+
+	checkAllow("unit-test.org", "/user", http.StatusOK)
+	checkAllow("other.unit-test.org", "/user", http.StatusOK)
+	checkAllow("unit-test.org", "/admin", http.StatusForbidden)
+	checkAllow("other.unit-test.org", "/admin", http.StatusBadRequest)
+}
@@ -0,0 +1,98 @@
+package padlocktest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alwitt/padlock/match"
+)
+
+// MatchRule is one request to permission mapping of the static request matcher
+type MatchRule struct {
+	// Host is the request host. Empty matches any host.
+	Host string
+	// Path is the request path
+	Path string
+	// Method is the request method
+	Method string
+	// Permissions are the permissions needed to proceed
+	Permissions []string
+}
+
+// staticRequestMatch implements match.RequestMatch with exact match rules
+type staticRequestMatch struct {
+	rules []MatchRule
+}
+
+/*
+DefineRequestMatch define a new match.RequestMatch which matches requests exactly against
+a list of rules. The first matching rule is used.
+
+	@param rules []MatchRule - the match rules
+	@return new match.RequestMatch instance
+*/
+func DefineRequestMatch(rules []MatchRule) match.RequestMatch {
+	return &staticRequestMatch{rules: rules}
+}
+
+/*
+Match checks whether a request matches against defined parameters
+
+	@param ctxt context.Context - context calling this API
+	@param request match.RequestParam - request parameters
+	@return if a match, the list permissions needed to proceed, or an error otherwise
+*/
+func (m *staticRequestMatch) Match(
+	ctxt context.Context, request match.RequestParam,
+) ([]string, error) {
+	rule, err := m.MatchRule(ctxt, request)
+	if err != nil {
+		return nil, err
+	}
+	return rule.Permissions, nil
+}
+
+/*
+MatchRule checks whether a request matches against defined parameters, and report which
+rule it matched
+
+	@param ctxt context.Context - context calling this API
+	@param request match.RequestParam - request parameters
+	@return if a match, the matched rule, or an error otherwise
+*/
+func (m *staticRequestMatch) MatchRule(
+	ctxt context.Context, request match.RequestParam,
+) (*match.MatchedRule, error) {
+	for _, rule := range m.rules {
+		if rule.Host != "" && (request.Host == nil || *request.Host != rule.Host) {
+			continue
+		}
+		if rule.Path == request.Path && rule.Method == request.Method {
+			host := rule.Host
+			if host == "" {
+				host = "*"
+			}
+			return &match.MatchedRule{
+				Host:        host,
+				PathPattern: rule.Path,
+				Method:      rule.Method,
+				Permissions: rule.Permissions,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no match for %s", request.String())
+}
+
+/*
+String returns an ASCII description of the object
+
+	@return an ASCII description of the object
+*/
+func (m *staticRequestMatch) String() string {
+	entries := []string{}
+	for _, rule := range m.rules {
+		entries = append(entries, fmt.Sprintf("%s %s%s", rule.Method, rule.Host, rule.Path))
+	}
+	return fmt.Sprintf("STATIC-MATCH [%s]", strings.Join(entries, ", "))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/match"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type rulesArgs struct {
+	Host string
+}
+
+var rulesCmdArgs rulesArgs
+
+// rulesCommand define the authorization rule generation CLI commands
+func rulesCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rules",
+		Usage: "Generate authorization rules",
+		Subcommands: []*cli.Command{
+			{
+				Name: "from-openapi",
+				Usage: "Convert an OpenAPI 3 or Swagger 2 spec into an \"authorize.rules\" config " +
+					"section, with permissions seeded from the operation tags",
+				ArgsUsage: "<spec file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: "host",
+						Usage: "Host of the rules. Defaults to the host in the spec, or the wildcard " +
+							"host \"*\".",
+						Destination: &rulesCmdArgs.Host,
+						Required:    false,
+					},
+				},
+				Action: commandAction("rules from-openapi", rulesFromOpenAPIApplication),
+			},
+		},
+	}
+}
+
+// The YAML form of the authorization rules, with the keys of the application config
+type (
+	rulesYAMLMethod struct {
+		Method      string   `yaml:"method"`
+		Permissions []string `yaml:"allowedPermissions"`
+	}
+	rulesYAMLPath struct {
+		PathRegexPattern string            `yaml:"pathPattern"`
+		AllowedMethods   []rulesYAMLMethod `yaml:"allowedMethods"`
+	}
+	rulesYAMLHost struct {
+		Host        string          `yaml:"host"`
+		TargetPaths []rulesYAMLPath `yaml:"allowedPaths"`
+	}
+	rulesYAMLSection struct {
+		Rules []rulesYAMLHost `yaml:"rules"`
+	}
+)
+
+func rulesFromOpenAPIApplication(c *cli.Context) (interface{}, error) {
+	if c.NArg() != 1 {
+		return nil, fmt.Errorf("expected exactly one OpenAPI spec file")
+	}
+	spec, err := os.ReadFile(c.Args().First())
+	if err != nil {
+		return nil, err
+	}
+	converted, err := match.ConvertOpenAPIToRules(spec, rulesCmdArgs.Host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert OpenAPI spec: %w", err)
+	}
+	// With JSON output, the rules use the keys of the application config
+	if jsonOutput() {
+		return converted, nil
+	}
+
+	fmt.Println("# Paste under \"authorize\" in the application config")
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(rulesToYAML(converted.Rules)); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	// Report to stderr, so stdout can be redirected into a file
+	fmt.Fprintln(os.Stderr, "Seeded permissions, to assign to roles in \"userManagement.userRoles\":")
+	for _, onePerm := range converted.Permissions {
+		fmt.Fprintf(os.Stderr, "  %s\n", onePerm)
+	}
+	for _, skipped := range converted.Skipped {
+		fmt.Fprintf(os.Stderr, "SKIPPED %s\n", skipped)
+	}
+	if len(converted.Ambiguous) > 0 {
+		fmt.Fprintln(
+			os.Stderr,
+			"Ambiguous paths, which can match the same request. The longer pattern is checked first:",
+		)
+		for _, ambiguous := range converted.Ambiguous {
+			preferred := ambiguous.Preferred
+			if preferred == "" {
+				preferred = "undefined, the patterns are of the same length"
+			}
+			fmt.Fprintf(
+				os.Stderr,
+				"  %s <-> %s (checked first: %s)\n",
+				ambiguous.Paths[0],
+				ambiguous.Paths[1],
+				preferred,
+			)
+		}
+	}
+	return converted, nil
+}
+
+// rulesToYAML convert the authorization rules into their YAML form
+func rulesToYAML(rules []common.HostAuthorizationConfig) rulesYAMLSection {
+	section := rulesYAMLSection{Rules: []rulesYAMLHost{}}
+	for _, oneHost := range rules {
+		hostEntry := rulesYAMLHost{Host: oneHost.Host, TargetPaths: []rulesYAMLPath{}}
+		for _, onePath := range oneHost.TargetPaths {
+			pathEntry := rulesYAMLPath{
+				PathRegexPattern: onePath.PathRegexPattern, AllowedMethods: []rulesYAMLMethod{},
+			}
+			for _, oneMethod := range onePath.AllowedMethods {
+				pathEntry.AllowedMethods = append(pathEntry.AllowedMethods, rulesYAMLMethod{
+					Method: oneMethod.Method, Permissions: oneMethod.Permissions,
+				})
+			}
+			hostEntry.TargetPaths = append(hostEntry.TargetPaths, pathEntry)
+		}
+		section.Rules = append(section.Rules, hostEntry)
+	}
+	return section
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/apex/log"
+	"github.com/urfave/cli/v2"
+)
+
+// introspectCommand define the token introspection CLI command
+func introspectCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "introspect",
+		Usage:     "Introspect a token against the OpenID issuer, and print the decoded response",
+		ArgsUsage: "<token>",
+		Action:    introspectToken,
+	}
+}
+
+func introspectToken(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expecting exactly one token argument")
+	}
+	token := c.Args().First()
+
+	oidParam, err := readOpenIDIssuerParams()
+	if err != nil {
+		return err
+	}
+	oidClient, err := apis.DefineOpenIDIssuerClient(oidParam)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("OpenID issuer discovery failed")
+		return err
+	}
+	if !oidClient.CanIntrospect() {
+		return fmt.Errorf(
+			"introspection requires the issuer's introspection endpoint, and the client credentials",
+		)
+	}
+
+	response, err := oidClient.IntrospectTokenDetails(context.Background(), token)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Introspection failed")
+		return err
+	}
+	return printJSON(response)
+}
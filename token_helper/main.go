@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	apexJSON "github.com/apex/log/handlers/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/urfave/cli/v2"
+)
+
+type cliArgs struct {
+	JSONLog               bool
+	LogLevel              string
+	OpenIDIssuerParamFile string
+}
+
+var cmdArgs cliArgs
+
+var logTags log.Fields
+
+func main() {
+	logTags = log.Fields{
+		"module":    "main",
+		"component": "token-helper",
+	}
+
+	app := &cli.App{
+		Name:        "token-helper",
+		Version:     "v0.5.0",
+		Usage:       "token debugging helper",
+		Description: "Helper for debugging OpenID tokens against the issuer and a padlock deployment",
+		Flags: []cli.Flag{
+			// LOGGING
+			&cli.BoolFlag{
+				Name:        "json-log",
+				Usage:       "Whether to log in JSON format",
+				Aliases:     []string{"j"},
+				EnvVars:     []string{"LOG_AS_JSON"},
+				Value:       false,
+				DefaultText: "false",
+				Destination: &cmdArgs.JSONLog,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "log-level",
+				Usage:       "Logging level: [debug info warn error]",
+				Aliases:     []string{"l"},
+				EnvVars:     []string{"LOG_LEVEL"},
+				Value:       "warn",
+				DefaultText: "warn",
+				Destination: &cmdArgs.LogLevel,
+				Required:    false,
+			},
+			// OpenID issuer
+			&cli.StringFlag{
+				Name:        "openid-issuer-param-file",
+				Usage:       "OpenID issuer parameter file",
+				Aliases:     []string{"o"},
+				EnvVars:     []string{"OPENID_ISSUER_PARAM_FILE"},
+				Destination: &cmdArgs.OpenIDIssuerParamFile,
+				Required:    false,
+			},
+		},
+		Before:   setupLogging,
+		Commands: []*cli.Command{introspectCommand(), getCommand(), verifyCommand()},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.WithError(err).WithFields(logTags).Fatal("Command failed")
+	}
+}
+
+// setupLogging setup the logging based on the command line arguments
+func setupLogging(_ *cli.Context) error {
+	if cmdArgs.JSONLog {
+		log.SetHandler(apexJSON.New(os.Stderr))
+	}
+	switch cmdArgs.LogLevel {
+	case "debug":
+		log.SetLevel(log.DebugLevel)
+	case "info":
+		log.SetLevel(log.InfoLevel)
+	case "warn":
+		log.SetLevel(log.WarnLevel)
+	case "error":
+		log.SetLevel(log.ErrorLevel)
+	default:
+		log.SetLevel(log.ErrorLevel)
+	}
+	return nil
+}
+
+/*
+readOpenIDIssuerParams read and validate the OpenID issuer parameter file. If the file lists
+several issuers, the first, primary, issuer is used.
+
+	@return the OpenID issuer parameters
+*/
+func readOpenIDIssuerParams() (common.OpenIDIssuerConfig, error) {
+	var oidParam common.OpenIDIssuerConfig
+	if cmdArgs.OpenIDIssuerParamFile == "" {
+		return oidParam, fmt.Errorf("no OpenID issuer parameter file given")
+	}
+	params, err := os.ReadFile(cmdArgs.OpenIDIssuerParamFile)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Unable to read %s", cmdArgs.OpenIDIssuerParamFile)
+		return oidParam, err
+	}
+	oidParams, err := common.ParseOpenIDIssuerParams(params)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("Unable to parse %s", cmdArgs.OpenIDIssuerParamFile)
+		return oidParam, err
+	}
+	oidParam = oidParams[0]
+	if err := validator.New().Struct(&oidParam); err != nil {
+		log.WithError(err).WithFields(logTags).
+			Errorf("%s content is not valid", cmdArgs.OpenIDIssuerParamFile)
+		return oidParam, err
+	}
+	return oidParam, nil
+}
+
+/*
+printJSON print a value to stdout as indented JSON
+
+	@param value interface{} - the value to print
+*/
+func printJSON(value interface{}) error {
+	t, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(t))
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/alwitt/padlock/apis"
+	"github.com/alwitt/padlock/authenticate"
+	"github.com/apex/log"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+// Supported token grants
+const (
+	grantClientCredentials = "client_credentials"
+	grantPassword          = "password"
+)
+
+type getArgs struct {
+	Grant     string
+	Scope     string
+	Username  string
+	TOTP      bool
+	TOTPParam string
+}
+
+var getCmdArgs getArgs
+
+// getCommand define the token acquisition CLI command
+func getCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "get",
+		Usage: "Request a token from the OpenID issuer, and print the token response",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name: "grant",
+				Usage: fmt.Sprintf(
+					"Token grant: [%s %s]", grantClientCredentials, grantPassword,
+				),
+				Aliases:     []string{"g"},
+				Value:       grantClientCredentials,
+				DefaultText: grantClientCredentials,
+				Destination: &getCmdArgs.Grant,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "scope",
+				Usage:       "Space separated token scopes to request",
+				Aliases:     []string{"s"},
+				Destination: &getCmdArgs.Scope,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "username",
+				Usage:       "Username for the password grant. Prompted for if not given.",
+				Aliases:     []string{"u"},
+				Destination: &getCmdArgs.Username,
+				Required:    false,
+			},
+			&cli.BoolFlag{
+				Name:        "totp",
+				Usage:       "Whether to prompt for a TOTP code for the password grant",
+				Value:       false,
+				DefaultText: "false",
+				Destination: &getCmdArgs.TOTP,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "totp-param",
+				Usage:       "Token request parameter to send the TOTP code in",
+				Value:       "totp",
+				DefaultText: "totp",
+				Destination: &getCmdArgs.TOTPParam,
+				Required:    false,
+			},
+		},
+		Action: getToken,
+	}
+}
+
+func getToken(c *cli.Context) error {
+	token, err := acquireToken(c.Context, getCmdArgs)
+	if err != nil {
+		return err
+	}
+	return printJSON(token)
+}
+
+/*
+acquireToken perform OpenID issuer discovery, and request a token from the issuer
+
+	@param ctxt context.Context - the operating context
+	@param args getArgs - the token request parameters
+	@return the token endpoint response
+*/
+func acquireToken(ctxt context.Context, args getArgs) (authenticate.TokenGrantResponse, error) {
+	oidParam, err := readOpenIDIssuerParams()
+	if err != nil {
+		return authenticate.TokenGrantResponse{}, err
+	}
+	if oidParam.ClientID == nil {
+		return authenticate.TokenGrantResponse{}, fmt.Errorf(
+			"%s does not provide a client ID", cmdArgs.OpenIDIssuerParamFile,
+		)
+	}
+	oidClient, err := apis.DefineOpenIDIssuerClient(oidParam)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("OpenID issuer discovery failed")
+		return authenticate.TokenGrantResponse{}, err
+	}
+
+	grantParams := url.Values{}
+	switch args.Grant {
+	case grantClientCredentials:
+		grantParams.Set("grant_type", grantClientCredentials)
+	case grantPassword:
+		grantParams.Set("grant_type", grantPassword)
+		if err := addPasswordGrantParams(grantParams, args); err != nil {
+			return authenticate.TokenGrantResponse{}, err
+		}
+	default:
+		return authenticate.TokenGrantResponse{}, fmt.Errorf("unsupported grant '%s'", args.Grant)
+	}
+	if args.Scope != "" {
+		grantParams.Set("scope", args.Scope)
+	}
+
+	token, err := oidClient.RequestToken(ctxt, grantParams)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Token request failed")
+		return authenticate.TokenGrantResponse{}, err
+	}
+	return token, nil
+}
+
+/*
+addPasswordGrantParams prompt for the user credentials of the password grant
+
+	@param grantParams url.Values - the grant parameters to add the credentials to
+	@param args getArgs - the token request parameters
+*/
+func addPasswordGrantParams(grantParams url.Values, args getArgs) error {
+	reader := bufio.NewReader(os.Stdin)
+	username := args.Username
+	if username == "" {
+		var err error
+		if username, err = prompt(reader, "Username: ", false); err != nil {
+			return err
+		}
+	}
+	password, err := prompt(reader, "Password: ", true)
+	if err != nil {
+		return err
+	}
+	grantParams.Set("username", username)
+	grantParams.Set("password", password)
+	if args.TOTP {
+		code, err := prompt(reader, "TOTP code: ", false)
+		if err != nil {
+			return err
+		}
+		grantParams.Set(args.TOTPParam, code)
+	}
+	return nil
+}
+
+/*
+prompt read one value from stdin. The prompt is written to stderr, so stdout only carries the
+command output.
+
+	@param reader *bufio.Reader - the stdin reader
+	@param message string - the prompt
+	@param secret bool - whether to hide the input, if stdin is a terminal
+	@return the value entered
+*/
+func prompt(reader *bufio.Reader, message string, secret bool) (string, error) {
+	fmt.Fprint(os.Stderr, message)
+	stdin := int(os.Stdin.Fd())
+	if secret && term.IsTerminal(stdin) {
+		value, err := term.ReadPassword(stdin)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(value), nil
+	}
+	value, err := reader.ReadString('\n')
+	if err != nil && value == "" {
+		return "", err
+	}
+	value = strings.TrimRight(value, "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("no value entered")
+	}
+	return value, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alwitt/padlock/client"
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+)
+
+type verifyArgs struct {
+	getArgs
+	PadlockURL      string
+	PadlockAuthzURL string
+	ConfigFile      string
+	Host            string
+	Path            string
+	Method          string
+}
+
+var verifyCmdArgs verifyArgs
+
+// verifyCommand define the padlock deployment end-to-end check CLI command
+func verifyCommand() *cli.Command {
+	return &cli.Command{
+		Name: "verify",
+		Usage: "Acquire a token, then authenticate and authorize a request with it against a " +
+			"padlock deployment",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "padlock-url",
+				Usage:       "Padlock authentication API base URL",
+				Aliases:     []string{"u"},
+				EnvVars:     []string{"PADLOCK_URL"},
+				Destination: &verifyCmdArgs.PadlockURL,
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "padlock-authz-url",
+				Usage:       "Padlock authorization API base URL, if different from --padlock-url",
+				EnvVars:     []string{"PADLOCK_AUTHZ_URL"},
+				Destination: &verifyCmdArgs.PadlockAuthzURL,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "config-file",
+				Usage:       "Padlock application config file, to read the request parameter headers from",
+				Aliases:     []string{"c"},
+				EnvVars:     []string{"CONFIG_FILE"},
+				Destination: &verifyCmdArgs.ConfigFile,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "host",
+				Usage:       "Host of the request to check",
+				Destination: &verifyCmdArgs.Host,
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "URI path of the request to check",
+				Value:       "/",
+				DefaultText: "/",
+				Destination: &verifyCmdArgs.Path,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "method",
+				Usage:       "HTTP method of the request to check",
+				Value:       http.MethodGet,
+				DefaultText: http.MethodGet,
+				Destination: &verifyCmdArgs.Method,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:        "scope",
+				Usage:       "Space separated token scopes to request",
+				Aliases:     []string{"s"},
+				Destination: &verifyCmdArgs.Scope,
+				Required:    false,
+			},
+		},
+		Action: verifyDeployment,
+	}
+}
+
+// padlockHeaders are the headers padlock uses to pass request parameters
+type padlockHeaders struct {
+	authnParams    common.AuthenticateRequestParamLocConfig
+	authnRequestID string
+	authzParams    common.AuthorizeRequestParamLocConfig
+	authzRequestID string
+}
+
+/*
+readPadlockHeaders read the headers padlock uses to pass request parameters. These are the
+padlock defaults, unless a padlock application config file is given.
+
+	@return the padlock headers
+*/
+func readPadlockHeaders() (padlockHeaders, error) {
+	var headers padlockHeaders
+	common.InstallDefaultAuthorizationServerConfigValues()
+	if verifyCmdArgs.ConfigFile != "" {
+		viper.SetConfigFile(verifyCmdArgs.ConfigFile)
+		if err := viper.ReadInConfig(); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to read config file %s", verifyCmdArgs.ConfigFile)
+			return headers, err
+		}
+		if err := common.InstallProxyPresetConfigValues(); err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to apply proxy preset of config file %s", verifyCmdArgs.ConfigFile)
+			return headers, err
+		}
+	}
+	if err := viper.UnmarshalKey(
+		"authenticate.requestParamHeaders", &headers.authnParams,
+	); err != nil {
+		return headers, err
+	}
+	if err := viper.UnmarshalKey(
+		"authorize.requestParamHeaders", &headers.authzParams,
+	); err != nil {
+		return headers, err
+	}
+	headers.authnRequestID = viper.GetString("authenticate.apis.requestLogging.requestIDHeader")
+	headers.authzRequestID = viper.GetString("authorize.apis.requestLogging.requestIDHeader")
+	return headers, nil
+}
+
+func verifyDeployment(c *cli.Context) error {
+	verifyCmdArgs.Grant = grantClientCredentials
+	authzURL := verifyCmdArgs.PadlockAuthzURL
+	if authzURL == "" {
+		authzURL = verifyCmdArgs.PadlockURL
+	}
+	headers, err := readPadlockHeaders()
+	if err != nil {
+		return err
+	}
+	authnClient, err := client.DefineAuthenticationClient(
+		client.Config{BaseURL: verifyCmdArgs.PadlockURL, RequestIDHeader: headers.authnRequestID},
+		headers.authnParams,
+		headers.authzParams,
+	)
+	if err != nil {
+		return err
+	}
+	authzClient, err := client.DefineAuthorizationClient(
+		client.Config{BaseURL: authzURL, RequestIDHeader: headers.authzRequestID},
+		headers.authzParams,
+	)
+	if err != nil {
+		return err
+	}
+
+	// All the calls share one request ID, so they can be matched against the padlock logs
+	requestID := uuid.NewString()
+	ctxt := client.WithRequestID(c.Context, requestID)
+	fmt.Printf("Request ID: %s\n", requestID)
+	fmt.Printf(
+		"Checking: %s %s%s\n", verifyCmdArgs.Method, verifyCmdArgs.Host, verifyCmdArgs.Path,
+	)
+
+	// Stage 1: acquire a token
+	token, err := acquireToken(ctxt, verifyCmdArgs.getArgs)
+	if err != nil {
+		fmt.Printf("[FAIL] token: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("[ OK ] token: %s token, expires in %ds\n", token.TokenType, token.ExpiresIn)
+
+	// Stage 2: authenticate
+	user, authenticated, err := authnClient.Authenticate(ctxt, client.AuthenticationRequest{
+		BearerToken: token.AccessToken,
+		Host:        verifyCmdArgs.Host,
+		Path:        verifyCmdArgs.Path,
+		Method:      verifyCmdArgs.Method,
+	})
+	if err != nil {
+		fmt.Printf("[FAIL] authenticate: %s\n", err.Error())
+		return err
+	}
+	if !authenticated {
+		err := fmt.Errorf("token rejected")
+		fmt.Printf("[FAIL] authenticate: %s\n", err.Error())
+		return err
+	}
+	if user.UserID == "" {
+		fmt.Println("[ OK ] authenticate: request bypassed authentication")
+		return nil
+	}
+	fmt.Printf(
+		"[ OK ] authenticate: user ID '%s', username '%s', name '%s %s', email '%s'\n",
+		user.UserID, user.Username, user.FirstName, user.LastName, user.Email,
+	)
+
+	// Stage 3: authorize
+	allowed, err := authzClient.Allow(ctxt, client.AuthorizationRequest{
+		Host:      verifyCmdArgs.Host,
+		Path:      verifyCmdArgs.Path,
+		Method:    verifyCmdArgs.Method,
+		UserID:    user.UserID,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+	})
+	if err != nil {
+		fmt.Printf("[FAIL] allow: %s\n", err.Error())
+		return err
+	}
+	if !allowed {
+		err := fmt.Errorf("request denied")
+		fmt.Printf("[FAIL] allow: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("[ OK ] allow: request allowed")
+	return nil
+}
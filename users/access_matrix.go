@@ -0,0 +1,188 @@
+package users
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+)
+
+// AccessMatrixEntry is the methods one user is allowed to call on one endpoint
+type AccessMatrixEntry struct {
+	// UserID is the user's ID
+	UserID string `json:"user_id"`
+	// Username is the username, if known
+	Username string `json:"username,omitempty"`
+	// Roles are the roles assigned to the user
+	Roles []string `json:"roles"`
+	// Host is the host of the authorization rule. "*" is the wildcard host.
+	Host string `json:"host"`
+	// PathPattern is the path pattern of the authorization rule
+	PathPattern string `json:"path_pattern"`
+	// AllowedMethods are the methods the user is allowed to call. "*" is the wildcard method.
+	AllowedMethods []string `json:"allowed_methods"`
+	// Permissions are the permissions of the user which grant the access
+	Permissions []string `json:"permissions"`
+}
+
+// AccessMatrix is the effective access of every user to every endpoint with authorization rules
+type AccessMatrix struct {
+	// GeneratedAt is when the matrix was generated
+	GeneratedAt time.Time `json:"generated_at"`
+	// Entries are the endpoints each user can access. A user without access to an endpoint has
+	// no entry for it.
+	Entries []AccessMatrixEntry `json:"entries"`
+}
+
+/*
+BuildAccessMatrix cross-join the users, their roles and permissions, and the authorization rules
+into the methods each user is allowed to call on each endpoint
+
+	@param snapshot Snapshot - the user records
+	@param roles map[string]common.UserRoleConfig - the role configuration
+	@param rules []common.HostAuthorizationConfig - the authorization rules
+	@param wildcards common.PermissionWildcardConfig - how a permission held by a user may
+	satisfy other permissions
+	@return the access matrix
+*/
+func BuildAccessMatrix(
+	snapshot Snapshot,
+	roles map[string]common.UserRoleConfig,
+	rules []common.HostAuthorizationConfig,
+	wildcards common.PermissionWildcardConfig,
+) AccessMatrix {
+	matrix := AccessMatrix{GeneratedAt: time.Now().UTC(), Entries: []AccessMatrixEntry{}}
+
+	sortedUsers := make([]SnapshotUser, len(snapshot.Users))
+	copy(sortedUsers, snapshot.Users)
+	sort.Slice(sortedUsers, func(i, j int) bool {
+		return sortedUsers[i].UserID < sortedUsers[j].UserID
+	})
+
+	for _, oneUser := range sortedUsers {
+		// Translate the user roles into permissions, along with the directly granted permissions.
+		// A role restricted to some hosts only applies to the rules of those hosts, and of the
+		// wildcard host, as it may cover them.
+		permissionsOnHost := func(host string) map[string]bool {
+			permissions := map[string]bool{}
+			for _, roleName := range oneUser.Roles {
+				roleInfo := roles[roleName]
+				if host != "*" && !roleInfo.AppliesToHost(host) {
+					continue
+				}
+				for _, onePerm := range roleInfo.AssignedPermissions {
+					permissions[onePerm] = true
+				}
+			}
+			for _, onePerm := range oneUser.Permissions {
+				permissions[onePerm] = true
+			}
+			return permissions
+		}
+		username := ""
+		if oneUser.Username != nil {
+			username = *oneUser.Username
+		}
+		userRoles := append([]string{}, oneUser.Roles...)
+		sort.Strings(userRoles)
+
+		for _, oneHost := range rules {
+			permissions := permissionsOnHost(oneHost.Host)
+			for _, onePath := range oneHost.TargetPaths {
+				allowedMethods := []string{}
+				grantedBy := map[string]bool{}
+				for _, oneMethod := range onePath.AllowedMethods {
+					allowed := false
+					for _, onePerm := range oneMethod.Permissions {
+						if holdsPermission(permissions, onePerm, wildcards) {
+							allowed = true
+							grantedBy[onePerm] = true
+						}
+					}
+					if allowed {
+						allowedMethods = append(allowedMethods, oneMethod.Method)
+					}
+				}
+				if len(allowedMethods) == 0 {
+					continue
+				}
+				sort.Strings(allowedMethods)
+				grantingPerms := []string{}
+				for onePerm := range grantedBy {
+					grantingPerms = append(grantingPerms, onePerm)
+				}
+				sort.Strings(grantingPerms)
+				matrix.Entries = append(matrix.Entries, AccessMatrixEntry{
+					UserID:         oneUser.UserID,
+					Username:       username,
+					Roles:          userRoles,
+					Host:           oneHost.Host,
+					PathPattern:    onePath.PathRegexPattern,
+					AllowedMethods: allowedMethods,
+					Permissions:    grantingPerms,
+				})
+			}
+		}
+	}
+	return matrix
+}
+
+/*
+WriteCSV write the access matrix as CSV, one row per entry. Lists are space separated.
+
+	@param w io.Writer - the output
+	@return whether successful
+*/
+func (m AccessMatrix) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{
+		"user_id", "username", "roles", "host", "path_pattern", "allowed_methods", "permissions",
+	}); err != nil {
+		return err
+	}
+	for _, entry := range m.Entries {
+		if err := writer.Write([]string{
+			entry.UserID,
+			entry.Username,
+			strings.Join(entry.Roles, " "),
+			entry.Host,
+			entry.PathPattern,
+			strings.Join(entry.AllowedMethods, " "),
+			strings.Join(entry.Permissions, " "),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+/*
+holdsPermission is a helper function to check whether a set of held permissions satisfies a
+required permission
+
+	@param held map[string]bool - the permissions held
+	@param required string - the permission required
+	@param wildcards common.PermissionWildcardConfig - how a held permission may satisfy other
+	permissions
+	@return whether the required permission is satisfied
+*/
+func holdsPermission(
+	held map[string]bool, required string, wildcards common.PermissionWildcardConfig,
+) bool {
+	if held[required] {
+		return true
+	}
+	if !wildcards.Enabled {
+		return false
+	}
+	for onePerm := range held {
+		if wildcards.Grants(onePerm, required) {
+			return true
+		}
+	}
+	return false
+}
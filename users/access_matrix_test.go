@@ -0,0 +1,92 @@
+package users
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildAccessMatrix(t *testing.T) {
+	assert := assert.New(t)
+
+	roles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+		"writer": {AssignedPermissions: []string{"write"}},
+	}
+	rules := []common.HostAuthorizationConfig{
+		{
+			Host: "api.example.com",
+			TargetPaths: []common.PathAuthorizationConfig{
+				{
+					PathRegexPattern: "^/orders$",
+					AllowedMethods: []common.PermissionForAPIMethodConfig{
+						{Method: "GET", Permissions: []string{"read", "write"}},
+						{Method: "POST", Permissions: []string{"write"}},
+					},
+				},
+				{
+					PathRegexPattern: "^/admin$",
+					AllowedMethods: []common.PermissionForAPIMethodConfig{
+						{Method: "*", Permissions: []string{"admin"}},
+					},
+				},
+			},
+		},
+	}
+	username := "alice"
+	snapshot := Snapshot{
+		Version: SnapshotFormatVersion,
+		Users: []SnapshotUser{
+			{UserConfig: models.UserConfig{UserID: "user-c"}},
+			{UserConfig: models.UserConfig{UserID: "user-b"}, Roles: []string{"reader"}},
+			{
+				UserConfig: models.UserConfig{UserID: "user-a", Username: &username},
+				Roles:      []string{"writer", "reader"},
+			},
+		},
+	}
+
+	uut := BuildAccessMatrix(snapshot, roles, rules, common.PermissionWildcardConfig{})
+	assert.Len(uut.Entries, 2)
+	assert.Equal(AccessMatrixEntry{
+		UserID:         "user-a",
+		Username:       "alice",
+		Roles:          []string{"reader", "writer"},
+		Host:           "api.example.com",
+		PathPattern:    "^/orders$",
+		AllowedMethods: []string{"GET", "POST"},
+		Permissions:    []string{"read", "write"},
+	}, uut.Entries[0])
+	assert.Equal("user-b", uut.Entries[1].UserID)
+	assert.Equal([]string{"GET"}, uut.Entries[1].AllowedMethods)
+
+	var buf bytes.Buffer
+	assert.Nil(uut.WriteCSV(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 3)
+	assert.Equal("user_id,username,roles,host,path_pattern,allowed_methods,permissions", lines[0])
+	assert.Equal("user-a,alice,reader writer,api.example.com,^/orders$,GET POST,read write", lines[1])
+	assert.Equal("user-b,,reader,api.example.com,^/orders$,GET,read", lines[2])
+
+	// A role restricted to other hosts grants no access
+	roles["writer"] = common.UserRoleConfig{
+		AssignedPermissions: []string{"write"}, AllowedHosts: []string{"staging.example.com"},
+	}
+	restricted := BuildAccessMatrix(snapshot, roles, rules, common.PermissionWildcardConfig{})
+	assert.Len(restricted.Entries, 2)
+	assert.Equal([]string{"GET"}, restricted.Entries[0].AllowedMethods)
+	assert.Equal([]string{"read"}, restricted.Entries[0].Permissions)
+
+	// A wildcard permission grants access to the rules requiring the permissions it satisfies
+	roles["reader"] = common.UserRoleConfig{AssignedPermissions: []string{"*"}}
+	wildcards := BuildAccessMatrix(snapshot, roles, rules, common.PermissionWildcardConfig{
+		Enabled: true, Separator: ":", Wildcard: "*", RecursiveWildcard: "**",
+	})
+	assert.Len(wildcards.Entries, 4)
+	assert.Equal("user-b", wildcards.Entries[3].UserID)
+	assert.Equal("^/admin$", wildcards.Entries[3].PathPattern)
+}
@@ -0,0 +1,264 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+)
+
+// SnapshotFormatVersion is the version of the backup snapshot format produced by ExportSnapshot.
+// Version 2 adds the groups, and the group memberships of the users.
+const SnapshotFormatVersion = 2
+
+// snapshotFormatVersionGroups is the first snapshot format version with groups
+const snapshotFormatVersionGroups = 2
+
+// SnapshotUser is one user entry within a backup snapshot
+type SnapshotUser struct {
+	models.UserConfig
+	// Roles are the roles assigned to the user
+	Roles []string `json:"roles"`
+	// Permissions are the permissions granted directly to the user
+	Permissions []string `json:"permissions,omitempty"`
+	// Groups are the groups the user is a member of
+	Groups []string `json:"groups,omitempty"`
+}
+
+// SnapshotGroup is one group entry within a backup snapshot
+type SnapshotGroup struct {
+	// Name is the group name
+	Name string `json:"name"`
+	// Roles are the roles the members of the group hold through the group
+	Roles []string `json:"roles"`
+}
+
+// Snapshot is a database engine independent backup of the user management records
+type Snapshot struct {
+	// Version is the snapshot format version
+	Version int `json:"version"`
+	// CreatedAt is when the snapshot was taken
+	CreatedAt time.Time `json:"created_at"`
+	// Roles are the configured roles, and the roles assigned to users, when the snapshot was taken
+	Roles []string `json:"roles"`
+	// Users are the users on record, along with their role assignments
+	Users []SnapshotUser `json:"users"`
+	// Groups are the groups on record. The members are recorded with the users.
+	Groups []SnapshotGroup `json:"groups"`
+}
+
+/*
+ExportSnapshot take a backup snapshot of the user management records
+
+	@param ctxt context.Context - context calling this API
+	@param manager Management - the user manager
+	@return the snapshot
+*/
+func ExportSnapshot(ctxt context.Context, manager Management) (Snapshot, error) {
+	snapshot := Snapshot{
+		Version:   SnapshotFormatVersion,
+		CreatedAt: time.Now().UTC(),
+		Roles:     []string{},
+		Users:     []SnapshotUser{},
+		Groups:    []SnapshotGroup{},
+	}
+
+	knownRoles := map[string]bool{}
+	roles, err := manager.ListAllRoles(ctxt)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for roleName := range roles {
+		knownRoles[roleName] = true
+	}
+
+	groupNames, err := manager.ListAllGroups(ctxt)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	sort.Strings(groupNames)
+	for _, groupName := range groupNames {
+		group, err := manager.GetGroup(ctxt, groupName)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		// The roles are read in no particular order, so sort them for a stable snapshot
+		groupRoles := append([]string{}, group.Roles...)
+		sort.Strings(groupRoles)
+		for _, roleName := range groupRoles {
+			knownRoles[roleName] = true
+		}
+		snapshot.Groups = append(snapshot.Groups, SnapshotGroup{Name: groupName, Roles: groupRoles})
+	}
+
+	allUsers, err := manager.ListAllUsers(ctxt)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for _, oneUser := range allUsers {
+		details, err := manager.GetUser(ctxt, oneUser.UserID)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		userRoles := append([]string{}, details.Roles...)
+		sort.Strings(userRoles)
+		for _, roleName := range userRoles {
+			knownRoles[roleName] = true
+		}
+		snapshot.Users = append(snapshot.Users, SnapshotUser{
+			UserConfig:  details.UserConfig,
+			Roles:       userRoles,
+			Permissions: details.Permissions,
+			Groups:      details.Groups,
+		})
+	}
+	for roleName := range knownRoles {
+		snapshot.Roles = append(snapshot.Roles, roleName)
+	}
+	sort.Strings(snapshot.Roles)
+	return snapshot, nil
+}
+
+/*
+RestoreSnapshot load a backup snapshot into the user management records. Users and groups in
+the snapshot which already exist are updated to match the snapshot, including the group
+memberships of the users; users and groups not in the snapshot are left untouched.
+
+The roles referenced by the snapshot must be present in the current role configuration.
+Snapshots from before groups were recorded leave the group memberships untouched.
+
+	@param ctxt context.Context - context calling this API
+	@param manager Management - the user manager
+	@param snapshot Snapshot - the snapshot to load
+	@return whether successful
+*/
+func RestoreSnapshot(ctxt context.Context, manager Management, snapshot Snapshot) error {
+	logTags := log.Fields{"module": "user", "component": "backup"}
+	if snapshot.Version < 1 || snapshot.Version > SnapshotFormatVersion {
+		return fmt.Errorf(
+			"unsupported snapshot format version %d, expecting at most %d",
+			snapshot.Version,
+			SnapshotFormatVersion,
+		)
+	}
+
+	// Verify the snapshot roles are all known
+	knownRoles, err := manager.ListAllRoles(ctxt)
+	if err != nil {
+		return err
+	}
+	for _, roleName := range snapshot.Roles {
+		if _, ok := knownRoles[roleName]; !ok {
+			return fmt.Errorf("snapshot role %s is not present in the role configuration", roleName)
+		}
+	}
+
+	existingGroups := map[string]bool{}
+	{
+		groupNames, err := manager.ListAllGroups(ctxt)
+		if err != nil {
+			return err
+		}
+		for _, groupName := range groupNames {
+			existingGroups[groupName] = true
+		}
+	}
+	for _, oneGroup := range snapshot.Groups {
+		if existingGroups[oneGroup.Name] {
+			if err := manager.SetGroupRoles(ctxt, oneGroup.Name, oneGroup.Roles); err != nil {
+				return err
+			}
+			log.WithFields(logTags).Debugf("Updated group %s from snapshot", oneGroup.Name)
+		} else {
+			if err := manager.DefineGroup(ctxt, oneGroup.Name, oneGroup.Roles); err != nil {
+				return err
+			}
+			existingGroups[oneGroup.Name] = true
+			log.WithFields(logTags).Debugf("Defined group %s from snapshot", oneGroup.Name)
+		}
+	}
+
+	existingUsers := map[string]bool{}
+	{
+		allUsers, err := manager.ListAllUsers(ctxt)
+		if err != nil {
+			return err
+		}
+		for _, oneUser := range allUsers {
+			existingUsers[oneUser.UserID] = true
+		}
+	}
+
+	for _, oneUser := range snapshot.Users {
+		if existingUsers[oneUser.UserID] {
+			if err := manager.UpdateUser(ctxt, oneUser.UserID, oneUser.UserConfig); err != nil {
+				return err
+			}
+			if err := manager.SetUserRoles(ctxt, oneUser.UserID, oneUser.Roles); err != nil {
+				return err
+			}
+			err := manager.SetUserPermissions(ctxt, oneUser.UserID, oneUser.Permissions)
+			if err != nil {
+				return err
+			}
+			log.WithFields(logTags).Debugf("Updated user %s from snapshot", oneUser.UserID)
+		} else {
+			if err := manager.DefineUser(ctxt, oneUser.UserConfig, oneUser.Roles); err != nil {
+				return err
+			}
+			if len(oneUser.Permissions) > 0 {
+				err := manager.SetUserPermissions(ctxt, oneUser.UserID, oneUser.Permissions)
+				if err != nil {
+					return err
+				}
+			}
+			log.WithFields(logTags).Debugf("Defined user %s from snapshot", oneUser.UserID)
+		}
+		if snapshot.Version >= snapshotFormatVersionGroups {
+			if err := restoreGroupMemberships(ctxt, manager, oneUser); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+restoreGroupMemberships change the group memberships of a user to match the snapshot
+
+	@param ctxt context.Context - context calling this API
+	@param manager Management - the user manager
+	@param user SnapshotUser - the user in the snapshot
+	@return whether successful
+*/
+func restoreGroupMemberships(ctxt context.Context, manager Management, user SnapshotUser) error {
+	details, err := manager.GetUser(ctxt, user.UserID)
+	if err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	for _, groupName := range details.Groups {
+		current[groupName] = true
+	}
+	wanted := map[string]bool{}
+	for _, groupName := range user.Groups {
+		wanted[groupName] = true
+		if !current[groupName] {
+			if err := manager.AddUsersToGroup(ctxt, groupName, []string{user.UserID}); err != nil {
+				return err
+			}
+		}
+	}
+	for groupName := range current {
+		if !wanted[groupName] {
+			err := manager.RemoveUsersFromGroup(ctxt, groupName, []string{user.UserID})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
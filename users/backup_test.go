@@ -0,0 +1,147 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestBackupRestore(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+	utCtxt := context.Background()
+
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+
+	defineManager := func() Management {
+		dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+		log.Debugf("Unit-test DB %s", dbName)
+		db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+			Logger: logger.Default.LogMode(logger.Info),
+		})
+		assert.Nil(err)
+		dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+		assert.Nil(err)
+		uut, err := CreateManagement(dbClient, nil, nil)
+		assert.Nil(err)
+		return uut
+	}
+
+	roles := map[string]common.UserRoleConfig{
+		uuid.New().String(): {AssignedPermissions: []string{uuid.New().String()}},
+		uuid.New().String(): {AssignedPermissions: []string{uuid.New().String()}},
+	}
+	roleNames := []string{}
+	for roleName := range roles {
+		roleNames = append(roleNames, roleName)
+	}
+
+	source := defineManager()
+	assert.Nil(source.AlignRolesWithConfig(utCtxt, roles))
+	userName := "user-name"
+	users := []models.UserConfig{
+		{UserID: uuid.New().String(), Username: &userName},
+		{UserID: uuid.New().String()},
+	}
+	assert.Nil(source.DefineUser(utCtxt, users[0], roleNames))
+	assert.Nil(source.DefineUser(utCtxt, users[1], nil))
+	grantedPermissions := roles[roleNames[0]].AssignedPermissions
+	assert.Nil(source.SetUserPermissions(utCtxt, users[1].UserID, grantedPermissions))
+	groupName := "group-0"
+	assert.Nil(source.DefineGroup(utCtxt, groupName, roleNames[1:]))
+	assert.Nil(source.AddUsersToGroup(utCtxt, groupName, []string{users[1].UserID}))
+
+	// Case 0: export
+	snapshot, err := ExportSnapshot(utCtxt, source)
+	assert.Nil(err)
+	assert.Equal(SnapshotFormatVersion, snapshot.Version)
+	assert.ElementsMatch(roleNames, snapshot.Roles)
+	assert.Len(snapshot.Users, 2)
+	assert.Equal([]SnapshotGroup{{Name: groupName, Roles: roleNames[1:]}}, snapshot.Groups)
+
+	// Case 1: restore into a new DB
+	target := defineManager()
+	assert.Nil(target.AlignRolesWithConfig(utCtxt, roles))
+	// This user is already present, but its roles and groups will be replaced
+	assert.Nil(target.DefineUser(utCtxt, users[1], roleNames[:1]))
+	// This group is already present, but its roles will be replaced
+	assert.Nil(target.DefineGroup(utCtxt, groupName, roleNames[:1]))
+	assert.Nil(target.DefineGroup(utCtxt, "group-1", nil))
+	assert.Nil(target.AddUsersToGroup(utCtxt, "group-1", []string{users[1].UserID}))
+	assert.Nil(RestoreSnapshot(utCtxt, target, snapshot))
+	{
+		user, err := target.GetUser(utCtxt, users[0].UserID)
+		assert.Nil(err)
+		assert.ElementsMatch(roleNames, user.Roles)
+		assert.NotNil(user.Username)
+		assert.Equal(userName, *user.Username)
+		user, err = target.GetUser(utCtxt, users[1].UserID)
+		assert.Nil(err)
+		assert.Empty(user.Roles)
+		assert.ElementsMatch(grantedPermissions, user.Permissions)
+		assert.Equal([]string{groupName}, user.Groups)
+		assert.Equal(roleNames[1:], user.GroupRoles)
+		group, err := target.GetGroup(utCtxt, groupName)
+		assert.Nil(err)
+		assert.Equal(roleNames[1:], group.Roles)
+		assert.Equal([]string{users[1].UserID}, group.Users)
+		group, err = target.GetGroup(utCtxt, "group-1")
+		assert.Nil(err)
+		assert.Empty(group.Users)
+	}
+
+	// Case 2: round trip through a fresh DB gives the same snapshot
+	{
+		restored, err := ExportSnapshot(utCtxt, target)
+		assert.Nil(err)
+		fresh := defineManager()
+		assert.Nil(fresh.AlignRolesWithConfig(utCtxt, roles))
+		assert.Nil(RestoreSnapshot(utCtxt, fresh, snapshot))
+		roundTrip, err := ExportSnapshot(utCtxt, fresh)
+		assert.Nil(err)
+		assert.Equal(snapshot.Roles, roundTrip.Roles)
+		assert.Equal(snapshot.Groups, roundTrip.Groups)
+		assert.ElementsMatch(snapshot.Users, roundTrip.Users)
+		assert.Len(restored.Groups, 2)
+	}
+
+	// Case 3: a snapshot from before groups were recorded leaves the memberships untouched
+	{
+		legacy := Snapshot{
+			Version: 1,
+			Roles:   snapshot.Roles,
+			Users:   []SnapshotUser{{UserConfig: users[1], Roles: []string{}}},
+		}
+		assert.Nil(RestoreSnapshot(utCtxt, target, legacy))
+		user, err := target.GetUser(utCtxt, users[1].UserID)
+		assert.Nil(err)
+		assert.Equal([]string{groupName}, user.Groups)
+	}
+
+	// Case 4: restore with missing roles
+	{
+		missingRoles := defineManager()
+		assert.Nil(missingRoles.AlignRolesWithConfig(
+			utCtxt, map[string]common.UserRoleConfig{roleNames[0]: roles[roleNames[0]]},
+		))
+		assert.NotNil(RestoreSnapshot(utCtxt, missingRoles, snapshot))
+	}
+
+	// Case 5: unsupported version
+	{
+		snapshot.Version = SnapshotFormatVersion + 1
+		assert.NotNil(RestoreSnapshot(utCtxt, target, snapshot))
+	}
+}
@@ -2,17 +2,29 @@ package users
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/alwitt/padlock/common"
 	"github.com/alwitt/padlock/models"
 )
 
+// ErrRoleUnknown is returned when the requested role is not in the role configuration
+var ErrRoleUnknown = errors.New("unknown role")
+
+// ErrPermissionUnknown is returned when the requested permission is not assigned to any role
+// in the role configuration
+var ErrPermissionUnknown = errors.New("unknown permission")
+
+// ErrAPIKeyInvalid is returned when the API key is not on record, or has expired
+var ErrAPIKeyInvalid = errors.New("invalid API key")
+
 // UserDetailsWithPermission extends models.UserDetails with additional information that
 // users associated permissions
 type UserDetailsWithPermission struct {
 	models.UserDetails
 	// AssociatedPermission list of permissions the user has based on the roles associated with
-	// the user
+	// the user, the roles of its groups, and the permissions granted directly to the user
 	AssociatedPermission []string
 }
 
@@ -25,12 +37,23 @@ type Management interface {
 	*/
 	Ready() error
 
+	/*
+		SubscribeEvents start receiving the user change events of this replica, i.e. the users
+		defined, updated, or deleted, and the changes to their role assignments
+
+		 @return the channel the events are delivered on, and the function to end the
+		 subscription, which closes the channel
+	*/
+	SubscribeEvents() (<-chan UserEvent, func())
+
 	// ------------------------------------------------------------------------------------
 	// Role Management
 
 	/*
 		AlignRolesWithConfig aligns the role entries on record with the configuration provided
 
+		The role inheritance is resolved, so the roles are queried with their inherited permissions.
+
 		 @param ctxt context.Context - context calling this API
 		 @param configuredRoles configuredRoles map[string]common.UserRoleConfig - the set of
 		 configured roles
@@ -103,6 +126,18 @@ type Management interface {
 		ctxt context.Context, id string, allowedPermissions []string,
 	) (bool, error)
 
+	/*
+		GetUserRequestPermissions query for the permissions a user holds for the request being
+		authorized. Same as DoesUserHavePermission, the roles restricted to other hosts than the
+		host of the request are left out. Held wildcard permissions are listed, along with the
+		known permissions they grant.
+
+		 @param ctxt context.Context - context calling this API
+		 @param id string - user entry ID
+		 @return the permissions the user holds for the request
+	*/
+	GetUserRequestPermissions(ctxt context.Context, id string) ([]string, error)
+
 	/*
 		ListAllUsers query for all users in system
 
@@ -111,6 +146,15 @@ type Management interface {
 	*/
 	ListAllUsers(ctxt context.Context) ([]models.UserInfo, error)
 
+	/*
+		SearchUsers query for the users matching the search filter, ordered by user ID
+
+		 @param ctxt context.Context - context calling this API
+		 @param filter models.UserSearchFilter - the search filter
+		 @return the list of matching users
+	*/
+	SearchUsers(ctxt context.Context, filter models.UserSearchFilter) ([]models.UserInfo, error)
+
 	/*
 		DeleteUser deletes a user
 
@@ -159,4 +203,135 @@ type Management interface {
 		 @return whether successful
 	*/
 	RemoveRolesFromUser(ctxt context.Context, id string, roles []string) error
+
+	/*
+		SetUserPermissions change the permissions granted directly to a user, in addition to
+		the permissions of its roles. The permissions must be assigned to at least one of the
+		configured roles.
+
+		 @param ctxt context.Context - context calling this API
+		 @param id string - user entry ID
+		 @param permissions []string - new directly granted permissions for this user
+		 @return whether successful
+	*/
+	SetUserPermissions(ctxt context.Context, id string, permissions []string) error
+
+	// ------------------------------------------------------------------------------------
+	// Group Management
+	//
+	// The members of a group hold the roles of the group, in addition to their own roles.
+
+	/*
+		DefineGroup define a group entry with roles
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @param roles []string - roles for the members of this group
+		 @return whether successful
+	*/
+	DefineGroup(ctxt context.Context, name string, roles []string) error
+
+	/*
+		ListAllGroups query for the list of groups on record
+
+		 @param ctxt context.Context - context calling this API
+		 @return the list of groups on record
+	*/
+	ListAllGroups(ctxt context.Context) ([]string, error)
+
+	/*
+		GetGroup query for a group by name
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @return the group information
+	*/
+	GetGroup(ctxt context.Context, name string) (models.GroupDetails, error)
+
+	/*
+		DeleteGroup deletes a group. The members of the group are not deleted.
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @return whether successful
+	*/
+	DeleteGroup(ctxt context.Context, name string) error
+
+	/*
+		SetGroupRoles change the roles of a group
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @param newRoles []string - new roles for the members of this group
+		 @return whether successful
+	*/
+	SetGroupRoles(ctxt context.Context, name string, newRoles []string) error
+
+	/*
+		AddUsersToGroup add users to a group
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @param ids []string - user entry IDs
+		 @return whether successful
+	*/
+	AddUsersToGroup(ctxt context.Context, name string, ids []string) error
+
+	/*
+		RemoveUsersFromGroup remove users from a group
+
+		 @param ctxt context.Context - context calling this API
+		 @param name string - group name
+		 @param ids []string - user entry IDs
+		 @return whether successful
+	*/
+	RemoveUsersFromGroup(ctxt context.Context, name string, ids []string) error
+
+	// ------------------------------------------------------------------------------------
+	// API Key Management
+	//
+	// API keys let machine clients authenticate as a user without an OpenID token.
+
+	/*
+		CreateAPIKey mint a new API key for a user. The key is only returned here; only its hash
+		is recorded.
+
+		 @param ctxt context.Context - context calling this API
+		 @param userID string - user entry ID
+		 @param description *string - if provided, describes the purpose of the key
+		 @param expiresAt *time.Time - if provided, when the key expires
+		 @return the API key, and its information
+	*/
+	CreateAPIKey(
+		ctxt context.Context, userID string, description *string, expiresAt *time.Time,
+	) (string, models.APIKeyInfo, error)
+
+	/*
+		ListAPIKeys query for the API keys of a user
+
+		 @param ctxt context.Context - context calling this API
+		 @param userID string - user entry ID
+		 @return the API keys of the user
+	*/
+	ListAPIKeys(ctxt context.Context, userID string) ([]models.APIKeyInfo, error)
+
+	/*
+		RevokeAPIKey revoke an API key of a user
+
+		 @param ctxt context.Context - context calling this API
+		 @param userID string - user entry ID
+		 @param keyID string - the API key ID
+		 @return whether successful
+	*/
+	RevokeAPIKey(ctxt context.Context, userID string, keyID string) error
+
+	/*
+		VerifyAPIKey verify an API key, and find the user it authenticates as
+
+		 @param ctxt context.Context - context calling this API
+		 @param key string - the API key
+		 @param timestamp time.Time - the current timestamp
+		 @return the user the API key authenticates as
+	*/
+	VerifyAPIKey(ctxt context.Context, key string, timestamp time.Time) (models.UserInfo, error)
 }
@@ -0,0 +1,115 @@
+package users
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// User change event types
+const (
+	// UserEventCreated a user was defined
+	UserEventCreated = "user_created"
+	// UserEventUpdated the parameters, or the directly granted permissions, of a user changed
+	UserEventUpdated = "user_updated"
+	// UserEventDeleted a user was deleted
+	UserEventDeleted = "user_deleted"
+	// UserEventRolesChanged the roles of a user changed, directly or through its groups
+	UserEventRolesChanged = "role_assignment_changed"
+)
+
+// defaultEventBufferSize is the number of events buffered for each subscriber
+const defaultEventBufferSize = 64
+
+// UserEvent describes a change to a user's records
+type UserEvent struct {
+	// Event is the event type
+	Event string `json:"event"`
+	// UserID is the ID of the user
+	UserID string `json:"user_id"`
+	// Timestamp is when the change occurred
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// EventBus distributes the user change events to the subscribers within this replica
+type EventBus interface {
+	/*
+		Publish deliver an event to all current subscribers. A subscriber whose buffer is full
+		misses the event, so a slow subscriber does not delay the publisher.
+
+			@param event UserEvent - the event
+	*/
+	Publish(event UserEvent)
+
+	/*
+		Subscribe start receiving the events published from now on
+
+			@return the channel the events are delivered on, and the function to end the
+			subscription, which closes the channel
+	*/
+	Subscribe() (<-chan UserEvent, func())
+}
+
+// eventBusImpl implements EventBus
+type eventBusImpl struct {
+	lock        sync.Mutex
+	bufferSize  int
+	nextID      int
+	subscribers map[int]chan UserEvent
+}
+
+/*
+NewEventBus define a new EventBus
+
+	@param bufferSize int - the number of events buffered for each subscriber
+	@return new EventBus instance
+*/
+func NewEventBus(bufferSize int) EventBus {
+	return &eventBusImpl{
+		lock: sync.Mutex{}, bufferSize: bufferSize, subscribers: map[int]chan UserEvent{},
+	}
+}
+
+/*
+Publish deliver an event to all current subscribers. A subscriber whose buffer is full
+misses the event, so a slow subscriber does not delay the publisher.
+
+	@param event UserEvent - the event
+*/
+func (b *eventBusImpl) Publish(event UserEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for id, events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.WithFields(log.Fields{"module": "user", "component": "event-bus"}).
+				Warnf("Subscriber %d is not keeping up, dropping %s event", id, event.Event)
+		}
+	}
+}
+
+/*
+Subscribe start receiving the events published from now on
+
+	@return the channel the events are delivered on, and the function to end the
+	subscription, which closes the channel
+*/
+func (b *eventBusImpl) Subscribe() (<-chan UserEvent, func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	id := b.nextID
+	b.nextID++
+	events := make(chan UserEvent, b.bufferSize)
+	b.subscribers[id] = events
+	once := sync.Once{}
+	return events, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			delete(b.subscribers, id)
+			close(events)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestEventBus(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	uut := NewEventBus(1)
+
+	// Case 0: events are delivered to all subscribers
+	first, endFirst := uut.Subscribe()
+	second, endSecond := uut.Subscribe()
+	uut.Publish(UserEvent{Event: UserEventCreated, UserID: "user-1"})
+	assert.Equal("user-1", (<-first).UserID)
+	assert.Equal("user-1", (<-second).UserID)
+
+	// Case 1: a subscriber with a full buffer misses events
+	uut.Publish(UserEvent{Event: UserEventUpdated, UserID: "user-1"})
+	uut.Publish(UserEvent{Event: UserEventDeleted, UserID: "user-1"})
+	assert.Equal(UserEventUpdated, (<-first).Event)
+	assert.Empty(first)
+
+	// Case 2: ending a subscription closes its channel
+	endFirst()
+	endFirst()
+	_, ok := <-first
+	assert.False(ok)
+	uut.Publish(UserEvent{Event: UserEventCreated, UserID: "user-2"})
+	endSecond()
+	assert.Equal(UserEventUpdated, (<-second).Event)
+	_, ok = <-second
+	assert.False(ok)
+}
+
+func TestManagementEvents(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	uut, err := CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(uut.AlignRolesWithConfig(context.Background(), map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}))
+
+	events, unsubscribe := uut.SubscribeEvents()
+	defer unsubscribe()
+	expectEvent := func(event, userID string) {
+		received := <-events
+		assert.Equal(event, received.Event)
+		assert.Equal(userID, received.UserID)
+		assert.False(received.Timestamp.IsZero())
+	}
+
+	ctxt := context.Background()
+	userID := uuid.New().String()
+	groupName := uuid.New().String()
+
+	// Case 0: user changes
+	assert.Nil(uut.DefineUser(ctxt, models.UserConfig{UserID: userID}, nil))
+	expectEvent(UserEventCreated, userID)
+	assert.Nil(uut.UpdateUser(ctxt, userID, models.UserConfig{UserID: userID}))
+	expectEvent(UserEventUpdated, userID)
+	assert.Nil(uut.SetUserPermissions(ctxt, userID, []string{"read"}))
+	expectEvent(UserEventUpdated, userID)
+
+	// Case 1: role assignment changes, directly and through groups
+	assert.Nil(uut.AddRolesToUser(ctxt, userID, []string{"reader"}))
+	expectEvent(UserEventRolesChanged, userID)
+	assert.Nil(uut.RemoveRolesFromUser(ctxt, userID, []string{"reader"}))
+	expectEvent(UserEventRolesChanged, userID)
+	assert.Nil(uut.DefineGroup(ctxt, groupName, nil))
+	assert.Nil(uut.AddUsersToGroup(ctxt, groupName, []string{userID}))
+	expectEvent(UserEventRolesChanged, userID)
+	assert.Nil(uut.SetGroupRoles(ctxt, groupName, []string{"reader"}))
+	expectEvent(UserEventRolesChanged, userID)
+	assert.Nil(uut.DeleteGroup(ctxt, groupName))
+	expectEvent(UserEventRolesChanged, userID)
+
+	// Case 2: failed changes are not published
+	assert.NotNil(uut.AddRolesToUser(ctxt, userID, []string{"unknown"}))
+	assert.Nil(uut.DeleteUser(ctxt, userID))
+	expectEvent(UserEventDeleted, userID)
+	assert.Empty(events)
+}
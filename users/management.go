@@ -3,14 +3,24 @@ package users
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/alwitt/goutils"
 	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
 	"github.com/alwitt/padlock/models"
 	"github.com/apex/log"
+	"github.com/google/uuid"
 )
 
 // managementImpl implements Management
@@ -22,16 +32,34 @@ type managementImpl struct {
 	roles map[string]common.UserRoleConfig
 	// rolesLock is a mutex to control access to roles
 	rolesLock *sync.RWMutex
+	// invalidate if provided, notify other replicas of changes to user records
+	invalidate invalidation.Bus
+	// wildcards sets how a permission held by a user may satisfy other permissions
+	wildcards common.PermissionWildcardConfig
+	// events distributes the user change events
+	events EventBus
 }
 
 /*
 CreateManagement defines a new Management
 
 	@param db models.ManagementDBClient - the DB client object
+	@param invalidate invalidation.Bus - if provided, notify other replicas of changes to
+	user records through this bus
+	@param wildcards *common.PermissionWildcardConfig - if provided, how a permission held by a
+	user may satisfy other permissions. Otherwise, a permission only satisfies itself.
 	@return instance of Management
 */
-func CreateManagement(db models.ManagementDBClient) (Management, error) {
+func CreateManagement(
+	db models.ManagementDBClient,
+	invalidate invalidation.Bus,
+	wildcards *common.PermissionWildcardConfig,
+) (Management, error) {
 	logTags := log.Fields{"module": "user", "component": "management"}
+	permissionWildcards := common.PermissionWildcardConfig{}
+	if wildcards != nil {
+		permissionWildcards = *wildcards
+	}
 	return &managementImpl{
 		Component: goutils.Component{
 			LogTags: logTags,
@@ -40,12 +68,45 @@ func CreateManagement(db models.ManagementDBClient) (Management, error) {
 				common.ModifyLogMetadataByAccessAuthorizeParam,
 			},
 		},
-		db:        db,
-		roles:     make(map[string]common.UserRoleConfig),
-		rolesLock: &sync.RWMutex{},
+		db:         db,
+		roles:      make(map[string]common.UserRoleConfig),
+		rolesLock:  &sync.RWMutex{},
+		invalidate: invalidate,
+		wildcards:  permissionWildcards,
+		events:     NewEventBus(defaultEventBufferSize),
 	}, nil
 }
 
+/*
+notifyUserChanged publish the user change event, and inform other replicas that a user's
+records have changed
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param event string - the user change event type
+*/
+func (m *managementImpl) notifyUserChanged(ctxt context.Context, id string, event string) {
+	m.events.Publish(UserEvent{Event: event, UserID: id, Timestamp: time.Now().UTC()})
+	if m.invalidate == nil || event == UserEventCreated {
+		return
+	}
+	if err := m.invalidate.Publish(ctxt, invalidation.TargetUser, id); err != nil {
+		logTags := m.GetLogTagsForContext(ctxt)
+		log.WithError(err).WithFields(logTags).Errorf("Failed to publish user %s change", id)
+	}
+}
+
+/*
+SubscribeEvents start receiving the user change events of this replica, i.e. the users
+defined, updated, or deleted, and the changes to their role assignments
+
+	@return the channel the events are delivered on, and the function to end the subscription,
+	which closes the channel
+*/
+func (m *managementImpl) SubscribeEvents() (<-chan UserEvent, func()) {
+	return m.events.Subscribe()
+}
+
 /*
 Ready checks whether the client is ready for use.
 
@@ -61,6 +122,8 @@ func (m *managementImpl) Ready() error {
 /*
 AlignRolesWithConfig aligns the role entries on record with the configuration provided
 
+The role inheritance is resolved, so the roles are queried with their inherited permissions.
+
 	@param ctxt context.Context - context calling this API
 	@param configuredRoles configuredRoles map[string]common.UserRoleConfig - the set of
 	configured roles
@@ -69,6 +132,12 @@ AlignRolesWithConfig aligns the role entries on record with the configuration pr
 func (m *managementImpl) AlignRolesWithConfig(
 	ctxt context.Context, configuredRoles map[string]common.UserRoleConfig,
 ) error {
+	// Assign each role the permissions of the roles it inherits from
+	configuredRoles, err := common.ResolveRoleInheritance(configuredRoles)
+	if err != nil {
+		log.WithError(err).WithFields(m.LogTags).Errorf("Unable to resolve the role inheritance")
+		return err
+	}
 	m.rolesLock.Lock()
 	defer m.rolesLock.Unlock()
 	// Update the DB with the new set of roles
@@ -124,7 +193,7 @@ func (m *managementImpl) GetRole(ctxt context.Context, role string) (common.User
 	defer m.rolesLock.RUnlock()
 	roleInfo, ok := m.roles[role]
 	if !ok {
-		return common.UserRoleConfig{}, fmt.Errorf("role %s is unknown", role)
+		return common.UserRoleConfig{}, fmt.Errorf("%w %s", ErrRoleUnknown, role)
 	}
 	return roleInfo, nil
 }
@@ -143,7 +212,7 @@ func (m *managementImpl) GetRoleWithLinkedUsers(ctxt context.Context, role strin
 	defer m.rolesLock.RUnlock()
 	roleInfo, ok := m.roles[role]
 	if !ok {
-		return common.UserRoleConfig{}, nil, fmt.Errorf("role %s is unknown", role)
+		return common.UserRoleConfig{}, nil, fmt.Errorf("%w %s", ErrRoleUnknown, role)
 	}
 	// Read from DB for the users
 	users, err := m.db.GetUsersOfRole(ctxt, role)
@@ -173,7 +242,9 @@ func (m *managementImpl) DefineUser(
 	// Verify that the roles actually exist
 	for _, aRole := range roles {
 		if _, ok := m.roles[aRole]; !ok {
-			return fmt.Errorf("user %s is referring to an unknown role %s", config.UserID, aRole)
+			return fmt.Errorf(
+				"user %s is referring to an %w %s", config.UserID, ErrRoleUnknown, aRole,
+			)
 		}
 	}
 	// Define the user
@@ -181,14 +252,24 @@ func (m *managementImpl) DefineUser(
 		log.WithError(err).WithFields(m.LogTags).Errorf("Failed to define new user %s", config.UserID)
 		return err
 	}
+	m.notifyUserChanged(ctxt, config.UserID, UserEventCreated)
 	return nil
 }
 
-// readPermissionSetOfRoles is a helper function to get a set of permission for a list of roles
-func (m *managementImpl) readPermissionSetOfRoles(roles []string) map[string]bool {
+/*
+readPermissionSetOfRoles is a helper function to get a set of permission for a list of roles
+
+	@param roles []string - the roles
+	@param host *string - if provided, only the roles applying to this host are included
+	@return the set of permissions
+*/
+func (m *managementImpl) readPermissionSetOfRoles(roles []string, host *string) map[string]bool {
 	permissions := map[string]bool{}
 	for _, aRole := range roles {
 		if roleInfo, ok := m.roles[aRole]; ok {
+			if host != nil && !roleInfo.AppliesToHost(*host) {
+				continue
+			}
 			// Return only the unique permissions
 			for _, onePerm := range roleInfo.AssignedPermissions {
 				permissions[onePerm] = true
@@ -198,6 +279,16 @@ func (m *managementImpl) readPermissionSetOfRoles(roles []string) map[string]boo
 	return permissions
 }
 
+/*
+userRoles is a helper function to list the roles a user holds, directly or through its groups
+
+	@param userInfo models.UserDetails - the user
+	@return the roles
+*/
+func userRoles(userInfo models.UserDetails) []string {
+	return append(append([]string{}, userInfo.Roles...), userInfo.GroupRoles...)
+}
+
 /*
 GetUser query for a user by ID
 
@@ -220,7 +311,11 @@ func (m *managementImpl) GetUser(ctxt context.Context, id string) (
 	result := UserDetailsWithPermission{
 		UserDetails: userInfo, AssociatedPermission: make([]string, 0),
 	}
-	for onePerm := range m.readPermissionSetOfRoles(userInfo.Roles) {
+	permissions := m.readPermissionSetOfRoles(userRoles(userInfo), nil)
+	for _, onePerm := range userInfo.Permissions {
+		permissions[onePerm] = true
+	}
+	for onePerm := range permissions {
 		result.AssociatedPermission = append(result.AssociatedPermission, onePerm)
 	}
 	return result, nil
@@ -245,16 +340,95 @@ func (m *managementImpl) DoesUserHavePermission(
 		log.WithError(err).WithFields(m.LogTags).Errorf("Failed to read user %s details", id)
 		return false, err
 	}
-	// Translate the user roles into permissions
-	permissions := m.readPermissionSetOfRoles(userInfo.Roles)
+	permissions := m.readRequestPermissionSet(ctxt, userInfo)
 	for _, checkPermission := range allowedPermissions {
 		if _, ok := permissions[checkPermission]; ok {
 			return true, nil
 		}
+		if !m.wildcards.Enabled {
+			continue
+		}
+		for heldPermission := range permissions {
+			if m.wildcards.Grants(heldPermission, checkPermission) {
+				return true, nil
+			}
+		}
 	}
 	return false, nil
 }
 
+/*
+GetUserRequestPermissions query for the permissions a user holds for the request being
+authorized. Held wildcard permissions are listed, along with the known permissions they grant.
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@return the permissions the user holds for the request
+*/
+func (m *managementImpl) GetUserRequestPermissions(
+	ctxt context.Context, id string,
+) ([]string, error) {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	// Fetch user
+	userInfo, err := m.db.GetUser(ctxt, id)
+	if err != nil {
+		log.WithError(err).WithFields(m.LogTags).Errorf("Failed to read user %s details", id)
+		return nil, err
+	}
+	permissions := m.readRequestPermissionSet(ctxt, userInfo)
+	if m.wildcards.Enabled {
+		// Only the permissions of the roles may be granted, so they are all the known permissions
+		known := m.readPermissionSetOfRoles(m.roleNames(), nil)
+		for heldPermission := range permissions {
+			for knownPermission := range known {
+				if m.wildcards.Grants(heldPermission, knownPermission) {
+					permissions[knownPermission] = true
+				}
+			}
+		}
+	}
+	result := make([]string, 0, len(permissions))
+	for onePerm := range permissions {
+		result = append(result, onePerm)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+/*
+readRequestPermissionSet is a helper function to translate the user roles, and the roles of its
+groups, into permissions, along with the directly granted permissions. A role restricted to some
+hosts never applies if the host of the request is not known.
+
+	@param ctxt context.Context - context carrying the request being authorized
+	@param userInfo models.UserDetails - the user
+	@return the set of permissions
+*/
+func (m *managementImpl) readRequestPermissionSet(
+	ctxt context.Context, userInfo models.UserDetails,
+) map[string]bool {
+	host, _ := common.AuthorizeRequestHost(ctxt)
+	permissions := m.readPermissionSetOfRoles(userRoles(userInfo), &host)
+	for _, onePerm := range userInfo.Permissions {
+		permissions[onePerm] = true
+	}
+	return permissions
+}
+
+/*
+roleNames is a helper function to list the names of the known roles
+
+	@return the role names
+*/
+func (m *managementImpl) roleNames() []string {
+	names := make([]string, 0, len(m.roles))
+	for roleName := range m.roles {
+		names = append(names, roleName)
+	}
+	return names
+}
+
 /*
 ListAllUsers query for all users in system
 
@@ -265,6 +439,19 @@ func (m *managementImpl) ListAllUsers(ctxt context.Context) ([]models.UserInfo,
 	return m.db.ListAllUsers(ctxt)
 }
 
+/*
+SearchUsers query for the users matching the search filter, ordered by user ID
+
+	@param ctxt context.Context - context calling this API
+	@param filter models.UserSearchFilter - the search filter
+	@return the list of matching users
+*/
+func (m *managementImpl) SearchUsers(
+	ctxt context.Context, filter models.UserSearchFilter,
+) ([]models.UserInfo, error) {
+	return m.db.SearchUsers(ctxt, filter)
+}
+
 /*
 DeleteUser deletes a user
 
@@ -273,7 +460,11 @@ DeleteUser deletes a user
 	@return whether successful
 */
 func (m *managementImpl) DeleteUser(ctxt context.Context, id string) error {
-	return m.db.DeleteUser(ctxt, id)
+	if err := m.db.DeleteUser(ctxt, id); err != nil {
+		return err
+	}
+	m.notifyUserChanged(ctxt, id, UserEventDeleted)
+	return nil
 }
 
 /*
@@ -287,7 +478,11 @@ UpdateUser update the parameters for a user
 func (m *managementImpl) UpdateUser(
 	ctxt context.Context, id string, newConfig models.UserConfig,
 ) error {
-	return m.db.UpdateUser(ctxt, id, newConfig)
+	if err := m.db.UpdateUser(ctxt, id, newConfig); err != nil {
+		return err
+	}
+	m.notifyUserChanged(ctxt, id, UserEventUpdated)
+	return nil
 }
 
 /*
@@ -304,10 +499,14 @@ func (m *managementImpl) AddRolesToUser(ctxt context.Context, id string, newRole
 	// Verify that the roles actually exist
 	for _, aRole := range newRoles {
 		if _, ok := m.roles[aRole]; !ok {
-			return fmt.Errorf("can't add an unknown role %s to user %s", aRole, id)
+			return fmt.Errorf("can't add an %w %s to user %s", ErrRoleUnknown, aRole, id)
 		}
 	}
-	return m.db.AddRolesToUser(ctxt, id, newRoles)
+	if err := m.db.AddRolesToUser(ctxt, id, newRoles); err != nil {
+		return err
+	}
+	m.notifyUserChanged(ctxt, id, UserEventRolesChanged)
+	return nil
 }
 
 /*
@@ -324,10 +523,14 @@ func (m *managementImpl) SetUserRoles(ctxt context.Context, id string, newRoles
 	// Verify that the roles actually exist
 	for _, aRole := range newRoles {
 		if _, ok := m.roles[aRole]; !ok {
-			return fmt.Errorf("can't add an unknown role %s to user %s", aRole, id)
+			return fmt.Errorf("can't add an %w %s to user %s", ErrRoleUnknown, aRole, id)
 		}
 	}
-	return m.db.SetUserRoles(ctxt, id, newRoles)
+	if err := m.db.SetUserRoles(ctxt, id, newRoles); err != nil {
+		return err
+	}
+	m.notifyUserChanged(ctxt, id, UserEventRolesChanged)
+	return nil
 }
 
 /*
@@ -346,8 +549,341 @@ func (m *managementImpl) RemoveRolesFromUser(
 	// Verify that the roles actually exist
 	for _, aRole := range roles {
 		if _, ok := m.roles[aRole]; !ok {
-			return fmt.Errorf("can't delete an unknown role %s from user %s", aRole, id)
+			return fmt.Errorf("can't delete an %w %s from user %s", ErrRoleUnknown, aRole, id)
+		}
+	}
+	if err := m.db.RemoveRolesFromUser(ctxt, id, roles); err != nil {
+		return err
+	}
+	m.notifyUserChanged(ctxt, id, UserEventRolesChanged)
+	return nil
+}
+
+/*
+SetUserPermissions change the permissions granted directly to a user, in addition to the
+permissions of its roles. The permissions must be assigned to at least one of the configured
+roles.
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param permissions []string - new directly granted permissions for this user
+	@return whether successful
+*/
+func (m *managementImpl) SetUserPermissions(
+	ctxt context.Context, id string, permissions []string,
+) error {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	// Verify that the permissions are actually in use
+	roleNames := []string{}
+	for roleName := range m.roles {
+		roleNames = append(roleNames, roleName)
+	}
+	knownPermissions := m.readPermissionSetOfRoles(roleNames, nil)
+	for _, aPermission := range permissions {
+		if !m.isPermissionKnown(knownPermissions, aPermission) {
+			return fmt.Errorf("can't grant an %w %s to user %s", ErrPermissionUnknown, aPermission, id)
+		}
+	}
+	if err := m.db.SetUserPermissions(ctxt, id, permissions); err != nil {
+		return err
+	}
+	m.notifyUserChanged(ctxt, id, UserEventUpdated)
+	return nil
+}
+
+/*
+isPermissionKnown is a helper function to check whether a permission is, or is satisfied by,
+one of the permissions assigned to the configured roles
+
+	@param knownPermissions map[string]bool - the permissions assigned to the configured roles
+	@param permission string - the permission
+	@return whether the permission is known
+*/
+func (m *managementImpl) isPermissionKnown(
+	knownPermissions map[string]bool, permission string,
+) bool {
+	if _, ok := knownPermissions[permission]; ok {
+		return true
+	}
+	if !m.wildcards.Enabled {
+		return false
+	}
+	for knownPermission := range knownPermissions {
+		if m.wildcards.Grants(knownPermission, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------------------------------------------------------------------------
+// Group Management
+
+/*
+verifyRolesExist is a helper function to check that roles are in the role configuration
+
+	@param roles []string - the roles
+	@return nil if all the roles are known, or an error otherwise
+*/
+func (m *managementImpl) verifyRolesExist(roles []string) error {
+	for _, aRole := range roles {
+		if _, ok := m.roles[aRole]; !ok {
+			return fmt.Errorf("%w %s", ErrRoleUnknown, aRole)
 		}
 	}
-	return m.db.RemoveRolesFromUser(ctxt, id, roles)
+	return nil
+}
+
+/*
+notifyGroupChanged publish the role change events of the members of a group, and inform other
+replicas that their records changed
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+*/
+func (m *managementImpl) notifyGroupChanged(ctxt context.Context, name string) {
+	group, err := m.db.GetGroup(ctxt, name)
+	if err != nil {
+		logTags := m.GetLogTagsForContext(ctxt)
+		log.WithError(err).WithFields(logTags).Errorf("Failed to read group %s members", name)
+		return
+	}
+	for _, id := range group.Users {
+		m.notifyUserChanged(ctxt, id, UserEventRolesChanged)
+	}
+}
+
+/*
+DefineGroup define a group entry with roles
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param roles []string - roles for the members of this group
+	@return whether successful
+*/
+func (m *managementImpl) DefineGroup(ctxt context.Context, name string, roles []string) error {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	if err := m.verifyRolesExist(roles); err != nil {
+		return fmt.Errorf("group %s is referring to an %w", name, err)
+	}
+	if err := m.db.DefineGroup(ctxt, name, roles); err != nil {
+		log.WithError(err).WithFields(m.LogTags).Errorf("Failed to define new group %s", name)
+		return err
+	}
+	return nil
+}
+
+/*
+ListAllGroups query for the list of groups on record
+
+	@param ctxt context.Context - context calling this API
+	@return the list of groups on record
+*/
+func (m *managementImpl) ListAllGroups(ctxt context.Context) ([]string, error) {
+	return m.db.ListAllGroups(ctxt)
+}
+
+/*
+GetGroup query for a group by name
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@return the group information
+*/
+func (m *managementImpl) GetGroup(ctxt context.Context, name string) (models.GroupDetails, error) {
+	return m.db.GetGroup(ctxt, name)
+}
+
+/*
+DeleteGroup deletes a group. The members of the group are not deleted.
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@return whether successful
+*/
+func (m *managementImpl) DeleteGroup(ctxt context.Context, name string) error {
+	// The members lose the roles of the group
+	group, err := m.db.GetGroup(ctxt, name)
+	if err != nil {
+		return err
+	}
+	if err := m.db.DeleteGroup(ctxt, name); err != nil {
+		return err
+	}
+	for _, id := range group.Users {
+		m.notifyUserChanged(ctxt, id, UserEventRolesChanged)
+	}
+	return nil
+}
+
+/*
+SetGroupRoles change the roles of a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param newRoles []string - new roles for the members of this group
+	@return whether successful
+*/
+func (m *managementImpl) SetGroupRoles(ctxt context.Context, name string, newRoles []string) error {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	if err := m.verifyRolesExist(newRoles); err != nil {
+		return fmt.Errorf("can't add an %w to group %s", err, name)
+	}
+	if err := m.db.SetGroupRoles(ctxt, name, newRoles); err != nil {
+		return err
+	}
+	m.notifyGroupChanged(ctxt, name)
+	return nil
+}
+
+/*
+AddUsersToGroup add users to a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (m *managementImpl) AddUsersToGroup(ctxt context.Context, name string, ids []string) error {
+	if err := m.db.AddUsersToGroup(ctxt, name, ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		m.notifyUserChanged(ctxt, id, UserEventRolesChanged)
+	}
+	return nil
+}
+
+/*
+RemoveUsersFromGroup remove users from a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (m *managementImpl) RemoveUsersFromGroup(
+	ctxt context.Context, name string, ids []string,
+) error {
+	if err := m.db.RemoveUsersFromGroup(ctxt, name, ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		m.notifyUserChanged(ctxt, id, UserEventRolesChanged)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------
+// API Key Management
+
+// apiKeyPrefix marks a string as a Padlock API key
+const apiKeyPrefix = "pdk_"
+
+/*
+IsAPIKey whether a string is formatted as a Padlock API key
+
+	@param value string - the string
+	@return whether it is formatted as an API key
+*/
+func IsAPIKey(value string) bool {
+	return strings.HasPrefix(value, apiKeyPrefix)
+}
+
+/*
+hashAPIKey compute the hash of an API key as recorded in the DB. The keys are random, so a
+plain hash is sufficient.
+
+	@param key string - the API key
+	@return the hash of the key
+*/
+func hashAPIKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
+/*
+CreateAPIKey mint a new API key for a user. The key is only returned here; only its hash
+is recorded.
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@param description *string - if provided, describes the purpose of the key
+	@param expiresAt *time.Time - if provided, when the key expires
+	@return the API key, and its information
+*/
+func (m *managementImpl) CreateAPIKey(
+	ctxt context.Context, userID string, description *string, expiresAt *time.Time,
+) (string, models.APIKeyInfo, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", models.APIKeyInfo{}, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	info := models.APIKeyInfo{
+		CreatedAt:   time.Now().UTC(),
+		KeyID:       uuid.NewString(),
+		UserID:      userID,
+		Description: description,
+		ExpiresAt:   expiresAt,
+	}
+	if err := m.db.RecordAPIKey(ctxt, info, hashAPIKey(key)); err != nil {
+		return "", models.APIKeyInfo{}, err
+	}
+	return key, info, nil
+}
+
+/*
+ListAPIKeys query for the API keys of a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@return the API keys of the user
+*/
+func (m *managementImpl) ListAPIKeys(
+	ctxt context.Context, userID string,
+) ([]models.APIKeyInfo, error) {
+	return m.db.ListAPIKeys(ctxt, userID)
+}
+
+/*
+RevokeAPIKey revoke an API key of a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@param keyID string - the API key ID
+	@return whether successful
+*/
+func (m *managementImpl) RevokeAPIKey(ctxt context.Context, userID string, keyID string) error {
+	return m.db.DeleteAPIKey(ctxt, userID, keyID)
+}
+
+/*
+VerifyAPIKey verify an API key, and find the user it authenticates as
+
+	@param ctxt context.Context - context calling this API
+	@param key string - the API key
+	@param timestamp time.Time - the current timestamp
+	@return the user the API key authenticates as
+*/
+func (m *managementImpl) VerifyAPIKey(
+	ctxt context.Context, key string, timestamp time.Time,
+) (models.UserInfo, error) {
+	info, err := m.db.GetAPIKeyByHash(ctxt, hashAPIKey(key))
+	if errors.Is(err, models.ErrAPIKeyNotFound) {
+		return models.UserInfo{}, ErrAPIKeyInvalid
+	} else if err != nil {
+		return models.UserInfo{}, err
+	}
+	if info.ExpiresAt != nil && !timestamp.Before(*info.ExpiresAt) {
+		return models.UserInfo{}, fmt.Errorf("%w: key %s has expired", ErrAPIKeyInvalid, info.KeyID)
+	}
+	user, err := m.db.GetUser(ctxt, info.UserID)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	return user.UserInfo, nil
 }
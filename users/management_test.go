@@ -3,9 +3,12 @@ package users
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/invalidation"
 	"github.com/alwitt/padlock/models"
 	"github.com/apex/log"
 	"github.com/google/uuid"
@@ -33,7 +36,7 @@ func TestManagingRoles(t *testing.T) {
 	assert.Nil(err)
 	assert.Nil(dbClient.Ready())
 
-	uut, err := CreateManagement(dbClient)
+	uut, err := CreateManagement(dbClient, nil, nil)
 	assert.Nil(err)
 	assert.Nil(uut.Ready())
 
@@ -117,7 +120,7 @@ func TestManagingUsers(t *testing.T) {
 	assert.Nil(err)
 	assert.Nil(dbClient.Ready())
 
-	uut, err := CreateManagement(dbClient)
+	uut, err := CreateManagement(dbClient, nil, nil)
 	assert.Nil(err)
 	assert.Nil(uut.Ready())
 
@@ -309,7 +312,7 @@ func TestUserPermissionChecking(assignedPermissions *testing.T) {
 	assert.Nil(err)
 	assert.Nil(dbClient.Ready())
 
-	uut, err := CreateManagement(dbClient)
+	uut, err := CreateManagement(dbClient, nil, nil)
 	assert.Nil(err)
 	assert.Nil(uut.Ready())
 
@@ -481,6 +484,243 @@ func TestUserPermissionChecking(assignedPermissions *testing.T) {
 			}
 		}
 	}
+
+	// Case 2: permissions granted directly to the user
+	{
+		assert.Nil(uut.AlignRolesWithConfig(context.Background(), testRoles))
+		assert.Nil(uut.SetUserRoles(context.Background(), userID, []string{roles[4]}))
+		assert.Nil(uut.SetUserPermissions(context.Background(), userID, []string{permissions[3]}))
+		for _, permission := range []string{permissions[3], permissions[7]} {
+			havePermission, err := uut.DoesUserHavePermission(
+				context.Background(), userID, []string{permission},
+			)
+			assert.Nil(err)
+			assert.True(havePermission)
+		}
+		havePermission, err := uut.DoesUserHavePermission(
+			context.Background(), userID, []string{permissions[0]},
+		)
+		assert.Nil(err)
+		assert.False(havePermission)
+		details, err := uut.GetUser(context.Background(), userID)
+		assert.Nil(err)
+		assert.ElementsMatch(
+			[]string{permissions[3], permissions[7]}, details.AssociatedPermission,
+		)
+
+		// Only permissions assigned to a configured role can be granted
+		err = uut.SetUserPermissions(context.Background(), userID, []string{uuid.New().String()})
+		assert.ErrorIs(err, ErrPermissionUnknown)
+
+		// Revoke the grant
+		assert.Nil(uut.SetUserPermissions(context.Background(), userID, nil))
+		havePermission, err = uut.DoesUserHavePermission(
+			context.Background(), userID, []string{permissions[3]},
+		)
+		assert.Nil(err)
+		assert.False(havePermission)
+	}
+
+	// Case 3: roles restricted to some hosts
+	{
+		restrictedRoles := map[string]common.UserRoleConfig{
+			roles[0]: {
+				AssignedPermissions: []string{permissions[0]},
+				AllowedHosts:        []string{"staging.example.com"},
+			},
+			roles[1]: {AssignedPermissions: []string{permissions[1]}},
+		}
+		assert.Nil(uut.AlignRolesWithConfig(context.Background(), restrictedRoles))
+		assert.Nil(uut.SetUserRoles(context.Background(), userID, []string{roles[0], roles[1]}))
+		forHost := func(host string) context.Context {
+			return context.WithValue(
+				context.Background(),
+				common.AccessAuthorizeParamKey{},
+				common.AccessAuthorizeParam{UserID: userID, Host: host, Method: "GET", Path: "/"},
+			)
+		}
+
+		// The restricted role applies on its hosts
+		havePermission, err := uut.DoesUserHavePermission(
+			forHost("Staging.Example.com"), userID, []string{permissions[0]},
+		)
+		assert.Nil(err)
+		assert.True(havePermission)
+
+		// The restricted role does not apply on other hosts, or an unknown host
+		for _, ctxt := range []context.Context{forHost("prod.example.com"), context.Background()} {
+			havePermission, err := uut.DoesUserHavePermission(ctxt, userID, []string{permissions[0]})
+			assert.Nil(err)
+			assert.False(havePermission)
+			havePermission, err = uut.DoesUserHavePermission(ctxt, userID, []string{permissions[1]})
+			assert.Nil(err)
+			assert.True(havePermission)
+		}
+
+		// The permissions for a request only include the roles applying to its host
+		held, err := uut.GetUserRequestPermissions(forHost("staging.example.com"), userID)
+		assert.Nil(err)
+		assert.ElementsMatch([]string{permissions[0], permissions[1]}, held)
+		held, err = uut.GetUserRequestPermissions(forHost("prod.example.com"), userID)
+		assert.Nil(err)
+		assert.Equal([]string{permissions[1]}, held)
+
+		// The user details list the permissions of all roles
+		details, err := uut.GetUser(context.Background(), userID)
+		assert.Nil(err)
+		assert.ElementsMatch(
+			[]string{permissions[0], permissions[1]}, details.AssociatedPermission,
+		)
+	}
+}
+
+func TestUserChangeNotification(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+
+	bus := invalidation.DefineLocalBus(uuid.New().String())
+	notified := []string{}
+	bus.Subscribe(invalidation.TargetUser, func(ctxt context.Context, msg invalidation.Message) error {
+		notified = append(notified, msg.Key)
+		return nil
+	})
+
+	uut, err := CreateManagement(dbClient, bus, nil)
+	assert.Nil(err)
+
+	role := uuid.New().String()
+	testRoles := map[string]common.UserRoleConfig{
+		role: {AssignedPermissions: []string{uuid.New().String()}},
+	}
+	assert.Nil(uut.AlignRolesWithConfig(context.Background(), testRoles))
+
+	userID := uuid.New().String()
+	assert.Nil(uut.DefineUser(context.Background(), models.UserConfig{UserID: userID}, nil))
+	assert.Empty(notified)
+
+	// Changing the roles of the user will trigger notification
+	assert.Nil(uut.SetUserRoles(context.Background(), userID, []string{role}))
+	assert.Equal([]string{userID}, notified)
+	assert.Nil(uut.RemoveRolesFromUser(context.Background(), userID, []string{role}))
+	assert.Len(notified, 2)
+
+	// Failed operations will not trigger notification
+	assert.NotNil(uut.SetUserRoles(context.Background(), userID, []string{uuid.New().String()}))
+	assert.Len(notified, 2)
+
+	// Granting permissions to the user will trigger notification
+	assert.Nil(uut.SetUserPermissions(
+		context.Background(), userID, testRoles[role].AssignedPermissions,
+	))
+	assert.Len(notified, 3)
+	assert.NotNil(uut.SetUserPermissions(context.Background(), userID, []string{"unknown"}))
+	assert.Len(notified, 3)
+
+	// Deleting the user will trigger notification
+	assert.Nil(uut.DeleteUser(context.Background(), userID))
+	assert.Len(notified, 4)
+	assert.Equal(userID, notified[3])
+}
+
+func TestGroupPermissionChecking(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+
+	bus := invalidation.DefineLocalBus(uuid.New().String())
+	notified := []string{}
+	bus.Subscribe(invalidation.TargetUser, func(ctxt context.Context, msg invalidation.Message) error {
+		notified = append(notified, msg.Key)
+		return nil
+	})
+
+	uut, err := CreateManagement(dbClient, bus, nil)
+	assert.Nil(err)
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+		"writer": {AssignedPermissions: []string{"read", "write"}},
+	}
+	assert.Nil(uut.AlignRolesWithConfig(context.Background(), testRoles))
+
+	userID := uuid.New().String()
+	assert.Nil(uut.DefineUser(
+		context.Background(), models.UserConfig{UserID: userID}, []string{"reader"},
+	))
+
+	// Case 0: group referring to unknown role
+	{
+		err := uut.DefineGroup(context.Background(), "editors", []string{uuid.New().String()})
+		assert.ErrorIs(err, ErrRoleUnknown)
+	}
+
+	// Case 1: permission not granted before joining the group
+	assert.Nil(uut.DefineGroup(context.Background(), "editors", []string{"writer"}))
+	{
+		allowed, err := uut.DoesUserHavePermission(context.Background(), userID, []string{"write"})
+		assert.Nil(err)
+		assert.False(allowed)
+	}
+
+	// Case 2: permission granted through the group
+	assert.Nil(uut.AddUsersToGroup(context.Background(), "editors", []string{userID}))
+	assert.Equal([]string{userID}, notified)
+	{
+		allowed, err := uut.DoesUserHavePermission(context.Background(), userID, []string{"write"})
+		assert.Nil(err)
+		assert.True(allowed)
+		user, err := uut.GetUser(context.Background(), userID)
+		assert.Nil(err)
+		assert.Equal([]string{"editors"}, user.Groups)
+		assert.EqualValues(
+			roleListToMap([]string{"read", "write"}), roleListToMap(user.AssociatedPermission),
+		)
+	}
+
+	// Case 3: changing the group roles notifies the members
+	assert.NotNil(uut.SetGroupRoles(context.Background(), "editors", []string{"unknown"}))
+	assert.Nil(uut.SetGroupRoles(context.Background(), "editors", []string{"reader"}))
+	assert.Equal([]string{userID, userID}, notified)
+	{
+		allowed, err := uut.DoesUserHavePermission(context.Background(), userID, []string{"write"})
+		assert.Nil(err)
+		assert.False(allowed)
+	}
+
+	// Case 4: deleting the group notifies the members
+	assert.Nil(uut.SetGroupRoles(context.Background(), "editors", []string{"writer"}))
+	assert.Nil(uut.DeleteGroup(context.Background(), "editors"))
+	assert.Len(notified, 4)
+	{
+		allowed, err := uut.DoesUserHavePermission(context.Background(), userID, []string{"write"})
+		assert.Nil(err)
+		assert.False(allowed)
+	}
 }
 
 func roleListToMap(i []string) map[string]bool {
@@ -490,3 +730,216 @@ func roleListToMap(i []string) map[string]bool {
 	}
 	return result
 }
+
+func TestAPIKeyManagement(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+
+	uut, err := CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+
+	userID := uuid.New().String()
+	username := "ci-bot"
+	assert.Nil(uut.DefineUser(
+		context.Background(), models.UserConfig{UserID: userID, Username: &username}, nil,
+	))
+	timestamp := time.Now().UTC()
+
+	// Case 0: mint API key
+	key, info, err := uut.CreateAPIKey(context.Background(), userID, nil, nil)
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(key, apiKeyPrefix))
+	assert.Equal(userID, info.UserID)
+	{
+		user, err := uut.VerifyAPIKey(context.Background(), key, timestamp)
+		assert.Nil(err)
+		assert.Equal(userID, user.UserID)
+		assert.Equal(username, *user.Username)
+		_, err = uut.VerifyAPIKey(context.Background(), key+"x", timestamp)
+		assert.ErrorIs(err, ErrAPIKeyInvalid)
+	}
+
+	// Case 1: expired API key
+	{
+		expiresAt := timestamp.Add(time.Hour)
+		expiring, _, err := uut.CreateAPIKey(context.Background(), userID, nil, &expiresAt)
+		assert.Nil(err)
+		_, err = uut.VerifyAPIKey(context.Background(), expiring, timestamp)
+		assert.Nil(err)
+		_, err = uut.VerifyAPIKey(context.Background(), expiring, expiresAt)
+		assert.ErrorIs(err, ErrAPIKeyInvalid)
+		keys, err := uut.ListAPIKeys(context.Background(), userID)
+		assert.Nil(err)
+		assert.Len(keys, 2)
+	}
+
+	// Case 2: revoke API key
+	{
+		assert.Nil(uut.RevokeAPIKey(context.Background(), userID, info.KeyID))
+		_, err := uut.VerifyAPIKey(context.Background(), key, timestamp)
+		assert.ErrorIs(err, ErrAPIKeyInvalid)
+		err = uut.RevokeAPIKey(context.Background(), userID, info.KeyID)
+		assert.ErrorIs(err, models.ErrAPIKeyNotFound)
+	}
+}
+
+func TestWildcardPermissionChecking(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+
+	wildcards := common.PermissionWildcardConfig{
+		Enabled: true, Separator: ":", Wildcard: "*", RecursiveWildcard: "**",
+	}
+	uut, err := CreateManagement(dbClient, nil, &wildcards)
+	assert.Nil(err)
+
+	testRoles := map[string]common.UserRoleConfig{
+		"catalog-admin":  {AssignedPermissions: []string{"catalog:*"}},
+		"super-admin":    {AssignedPermissions: []string{"admin:**"}},
+		"reader":         {AssignedPermissions: []string{"orders:read"}},
+		"catalog-reader": {AssignedPermissions: []string{"catalog:read"}},
+	}
+	assert.Nil(uut.AlignRolesWithConfig(context.Background(), testRoles))
+
+	userID := uuid.New().String()
+	assert.Nil(uut.DefineUser(
+		context.Background(), models.UserConfig{UserID: userID}, []string{"catalog-admin"},
+	))
+
+	checkPermission := func(permission string) bool {
+		allowed, err := uut.DoesUserHavePermission(
+			context.Background(), userID, []string{permission},
+		)
+		assert.Nil(err)
+		return allowed
+	}
+
+	// Case 0: a role with a wildcard permission
+	assert.True(checkPermission("catalog:read"))
+	assert.True(checkPermission("catalog:write"))
+	assert.False(checkPermission("catalog:items:read"))
+	assert.False(checkPermission("orders:read"))
+	held, err := uut.GetUserRequestPermissions(context.Background(), userID)
+	assert.Nil(err)
+	assert.Equal([]string{"catalog:*", "catalog:read"}, held)
+
+	// Case 1: a role with a recursive wildcard permission
+	assert.Nil(uut.SetUserRoles(context.Background(), userID, []string{"super-admin"}))
+	assert.True(checkPermission("admin:users:delete"))
+	assert.False(checkPermission("catalog:read"))
+
+	// Case 2: directly grant a permission satisfied by a wildcard permission of a role
+	assert.Nil(uut.SetUserRoles(context.Background(), userID, []string{}))
+	assert.Nil(uut.SetUserPermissions(context.Background(), userID, []string{"catalog:read"}))
+	assert.True(checkPermission("catalog:read"))
+	assert.False(checkPermission("catalog:write"))
+	err = uut.SetUserPermissions(context.Background(), userID, []string{"billing:read"})
+	assert.ErrorIs(err, ErrPermissionUnknown)
+
+	// Case 3: wildcards not enabled
+	{
+		exact, err := CreateManagement(dbClient, nil, nil)
+		assert.Nil(err)
+		assert.Nil(exact.AlignRolesWithConfig(context.Background(), testRoles))
+		assert.Nil(exact.SetUserRoles(context.Background(), userID, []string{"catalog-admin"}))
+		allowed, err := exact.DoesUserHavePermission(
+			context.Background(), userID, []string{"catalog:write"},
+		)
+		assert.Nil(err)
+		assert.False(allowed)
+	}
+}
+
+func TestRoleInheritance(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+
+	uut, err := CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+
+	testRoles := map[string]common.UserRoleConfig{
+		"reader":  {AssignedPermissions: []string{"read"}},
+		"writer":  {AssignedPermissions: []string{"write"}, Inherits: []string{"reader"}},
+		"admin":   {AssignedPermissions: []string{"delete"}, Inherits: []string{"writer"}},
+		"auditor": {Inherits: []string{"reader"}},
+	}
+	assert.Nil(uut.AlignRolesWithConfig(context.Background(), testRoles))
+
+	// Case 0: the role queries report the inherited permissions
+	{
+		role, err := uut.GetRole(context.Background(), "admin")
+		assert.Nil(err)
+		assert.Equal([]string{"delete", "write", "read"}, role.AssignedPermissions)
+		assert.Equal([]string{"writer"}, role.Inherits)
+		roles, err := uut.ListAllRoles(context.Background())
+		assert.Nil(err)
+		assert.Equal([]string{"read"}, roles["auditor"].AssignedPermissions)
+		assert.Equal([]string{"write", "read"}, roles["writer"].AssignedPermissions)
+		// The provided config is not modified
+		assert.Equal([]string{"delete"}, testRoles["admin"].AssignedPermissions)
+	}
+
+	// Case 1: a user with the child role holds the inherited permissions
+	userID := uuid.New().String()
+	assert.Nil(uut.DefineUser(
+		context.Background(), models.UserConfig{UserID: userID}, []string{"admin"},
+	))
+	allowed, err := uut.DoesUserHavePermission(context.Background(), userID, []string{"read"})
+	assert.Nil(err)
+	assert.True(allowed)
+
+	// Case 2: inheritance cycle
+	cyclic := map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}, Inherits: []string{"admin"}},
+		"admin":  {AssignedPermissions: []string{"delete"}, Inherits: []string{"reader"}},
+	}
+	assert.NotNil(uut.AlignRolesWithConfig(context.Background(), cyclic))
+
+	// Case 3: inherit from an unknown role
+	unknown := map[string]common.UserRoleConfig{
+		"admin": {AssignedPermissions: []string{"delete"}, Inherits: []string{"writer"}},
+	}
+	assert.NotNil(uut.AlignRolesWithConfig(context.Background(), unknown))
+	role, err := uut.GetRole(context.Background(), "admin")
+	assert.Nil(err)
+	assert.Equal([]string{"delete", "write", "read"}, role.AssignedPermissions)
+}
@@ -0,0 +1,301 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+)
+
+// normalizingManagement decorates a Management with the user ID normalization policy
+type normalizingManagement struct {
+	Management
+	policy common.UserIDNormalizationConfig
+}
+
+// UserIDNormalizer is implemented by a Management normalizing the user IDs
+type UserIDNormalizer interface {
+	/*
+		NormalizeUserID normalize a user ID the same way as the user records
+
+			@param id string - user entry ID
+			@return the normalized user ID
+	*/
+	NormalizeUserID(id string) string
+}
+
+/*
+NormalizeUserID normalize a user ID the same way as the user management instance does, so
+user IDs given outside of its API, i.e. to flush a cache, can be matched against the records
+
+	@param core Management - the user management instance
+	@param id string - user entry ID
+	@return the normalized user ID
+*/
+func NormalizeUserID(core Management, id string) string {
+	if normalizer, ok := core.(UserIDNormalizer); ok {
+		return normalizer.NormalizeUserID(id)
+	}
+	return id
+}
+
+/*
+WithUserIDNormalization decorate a Management so the user IDs are normalized before they are
+recorded or looked up
+
+	@param core Management - the user management instance to decorate
+	@param policy common.UserIDNormalizationConfig - the user ID normalization policy
+	@return the decorated Management
+*/
+func WithUserIDNormalization(
+	core Management, policy common.UserIDNormalizationConfig,
+) Management {
+	return &normalizingManagement{Management: core, policy: policy}
+}
+
+/*
+NormalizeUserID normalize a user ID the same way as the user records
+
+	@param id string - user entry ID
+	@return the normalized user ID
+*/
+func (m *normalizingManagement) NormalizeUserID(id string) string {
+	return m.policy.Normalize(id)
+}
+
+/*
+DefineUser define a user entry with roles
+
+	@param ctxt context.Context - context calling this API
+	@param config UserConfig - user config
+	@param roles []string - roles for this user
+	@return whether successful
+*/
+func (m *normalizingManagement) DefineUser(
+	ctxt context.Context, config models.UserConfig, roles []string,
+) error {
+	config.UserID = m.policy.Normalize(config.UserID)
+	return m.Management.DefineUser(ctxt, config, roles)
+}
+
+/*
+GetUser query for a user by ID
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@return the user information
+*/
+func (m *normalizingManagement) GetUser(ctxt context.Context, id string) (
+	UserDetailsWithPermission, error,
+) {
+	return m.Management.GetUser(ctxt, m.policy.Normalize(id))
+}
+
+/*
+DoesUserHavePermission checks whether a particular user has at least one of the allowed permissions.
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param allowedPermissions []string - list of allowed permissions
+	@return whether the user has at least one of the allowed permissions.
+*/
+func (m *normalizingManagement) DoesUserHavePermission(
+	ctxt context.Context, id string, allowedPermissions []string,
+) (bool, error) {
+	return m.Management.DoesUserHavePermission(ctxt, m.policy.Normalize(id), allowedPermissions)
+}
+
+/*
+GetUserRequestPermissions query for the permissions a user holds for the request being
+authorized
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@return the permissions the user holds for the request
+*/
+func (m *normalizingManagement) GetUserRequestPermissions(
+	ctxt context.Context, id string,
+) ([]string, error) {
+	return m.Management.GetUserRequestPermissions(ctxt, m.policy.Normalize(id))
+}
+
+/*
+SearchUsers query for the users matching the search filter, ordered by user ID
+
+	@param ctxt context.Context - context calling this API
+	@param filter models.UserSearchFilter - the search filter
+	@return the list of matching users
+*/
+func (m *normalizingManagement) SearchUsers(
+	ctxt context.Context, filter models.UserSearchFilter,
+) ([]models.UserInfo, error) {
+	filter.UserIDPrefix = m.policy.Normalize(filter.UserIDPrefix)
+	return m.Management.SearchUsers(ctxt, filter)
+}
+
+/*
+DeleteUser deletes a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@return whether successful
+*/
+func (m *normalizingManagement) DeleteUser(ctxt context.Context, id string) error {
+	return m.Management.DeleteUser(ctxt, m.policy.Normalize(id))
+}
+
+/*
+UpdateUser update the parameters for a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param newConfig UserConfig - new user config
+	@return whether successful
+*/
+func (m *normalizingManagement) UpdateUser(
+	ctxt context.Context, id string, newConfig models.UserConfig,
+) error {
+	newConfig.UserID = m.policy.Normalize(newConfig.UserID)
+	return m.Management.UpdateUser(ctxt, m.policy.Normalize(id), newConfig)
+}
+
+/*
+AddRolesToUser add new roles to a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param newRoles []string - new roles for this user
+	@return whether successful
+*/
+func (m *normalizingManagement) AddRolesToUser(
+	ctxt context.Context, id string, newRoles []string,
+) error {
+	return m.Management.AddRolesToUser(ctxt, m.policy.Normalize(id), newRoles)
+}
+
+/*
+SetUserRoles change the roles of a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param newRoles []string - new roles for this user
+	@return whether successful
+*/
+func (m *normalizingManagement) SetUserRoles(
+	ctxt context.Context, id string, newRoles []string,
+) error {
+	return m.Management.SetUserRoles(ctxt, m.policy.Normalize(id), newRoles)
+}
+
+/*
+RemoveRolesFromUser remove roles from user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param roles []string - roles to remove from user
+	@return whether successful
+*/
+func (m *normalizingManagement) RemoveRolesFromUser(
+	ctxt context.Context, id string, roles []string,
+) error {
+	return m.Management.RemoveRolesFromUser(ctxt, m.policy.Normalize(id), roles)
+}
+
+/*
+SetUserPermissions change the permissions granted directly to a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param permissions []string - new directly granted permissions for this user
+	@return whether successful
+*/
+func (m *normalizingManagement) SetUserPermissions(
+	ctxt context.Context, id string, permissions []string,
+) error {
+	return m.Management.SetUserPermissions(ctxt, m.policy.Normalize(id), permissions)
+}
+
+/*
+normalizeIDs is a helper function to normalize a list of user IDs
+
+	@param ids []string - user entry IDs
+	@return the normalized user entry IDs
+*/
+func (m *normalizingManagement) normalizeIDs(ids []string) []string {
+	result := make([]string, len(ids))
+	for idx, id := range ids {
+		result[idx] = m.policy.Normalize(id)
+	}
+	return result
+}
+
+/*
+AddUsersToGroup add users to a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (m *normalizingManagement) AddUsersToGroup(
+	ctxt context.Context, name string, ids []string,
+) error {
+	return m.Management.AddUsersToGroup(ctxt, name, m.normalizeIDs(ids))
+}
+
+/*
+RemoveUsersFromGroup remove users from a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (m *normalizingManagement) RemoveUsersFromGroup(
+	ctxt context.Context, name string, ids []string,
+) error {
+	return m.Management.RemoveUsersFromGroup(ctxt, name, m.normalizeIDs(ids))
+}
+
+/*
+CreateAPIKey mint a new API key for a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@param description *string - if provided, describes the purpose of the key
+	@param expiresAt *time.Time - if provided, when the key expires
+	@return the API key, and its information
+*/
+func (m *normalizingManagement) CreateAPIKey(
+	ctxt context.Context, userID string, description *string, expiresAt *time.Time,
+) (string, models.APIKeyInfo, error) {
+	return m.Management.CreateAPIKey(ctxt, m.policy.Normalize(userID), description, expiresAt)
+}
+
+/*
+ListAPIKeys query for the API keys of a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@return the API keys of the user
+*/
+func (m *normalizingManagement) ListAPIKeys(
+	ctxt context.Context, userID string,
+) ([]models.APIKeyInfo, error) {
+	return m.Management.ListAPIKeys(ctxt, m.policy.Normalize(userID))
+}
+
+/*
+RevokeAPIKey revoke an API key of a user
+
+	@param ctxt context.Context - context calling this API
+	@param userID string - user entry ID
+	@param keyID string - the API key ID
+	@return whether successful
+*/
+func (m *normalizingManagement) RevokeAPIKey(
+	ctxt context.Context, userID string, keyID string,
+) error {
+	return m.Management.RevokeAPIKey(ctxt, m.policy.Normalize(userID), keyID)
+}
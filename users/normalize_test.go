@@ -0,0 +1,96 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestUserIDNormalization(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	core, err := CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(core.AlignRolesWithConfig(context.Background(), map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+		"writer": {AssignedPermissions: []string{"write"}},
+	}))
+
+	uut := WithUserIDNormalization(core, common.UserIDNormalizationConfig{
+		TrimSpace: true, StripEmailDomain: true, Lowercase: true,
+	})
+
+	utCtxt := context.Background()
+
+	// Case 0: the user is recorded under the normalized ID
+	assert.Nil(uut.DefineUser(
+		utCtxt, models.UserConfig{UserID: " Alice@Example.com "}, []string{"reader"},
+	))
+	{
+		user, err := core.GetUser(utCtxt, "alice")
+		assert.Nil(err)
+		assert.Equal("alice", user.UserID)
+	}
+
+	// Case 1: variants of the ID refer to the same user
+	for _, variant := range []string{"ALICE", "alice@other.org", "  Alice"} {
+		user, err := uut.GetUser(utCtxt, variant)
+		assert.Nil(err, variant)
+		assert.Equal("alice", user.UserID, variant)
+		allowed, err := uut.DoesUserHavePermission(utCtxt, variant, []string{"read"})
+		assert.Nil(err, variant)
+		assert.True(allowed, variant)
+	}
+
+	// Case 2: a variant of the ID is a duplicate
+	assert.NotNil(uut.DefineUser(utCtxt, models.UserConfig{UserID: "ALICE"}, nil))
+	{
+		all, err := uut.ListAllUsers(utCtxt)
+		assert.Nil(err)
+		assert.Len(all, 1)
+	}
+
+	// Case 3: changes through a variant of the ID
+	assert.Nil(uut.AddRolesToUser(utCtxt, "Alice", []string{"writer"}))
+	{
+		allowed, err := uut.DoesUserHavePermission(utCtxt, "alice", []string{"write"})
+		assert.Nil(err)
+		assert.True(allowed)
+	}
+	assert.Nil(uut.RemoveRolesFromUser(utCtxt, "Alice@Example.com", []string{"writer"}))
+	assert.Nil(uut.SetUserRoles(utCtxt, "ALICE", []string{"reader"}))
+	{
+		matched, err := uut.SearchUsers(utCtxt, models.UserSearchFilter{UserIDPrefix: "AL"})
+		assert.Nil(err)
+		assert.Len(matched, 1)
+	}
+	assert.Nil(uut.DeleteUser(utCtxt, "Alice"))
+	{
+		_, err := core.GetUser(utCtxt, "alice")
+		assert.NotNil(err)
+	}
+}
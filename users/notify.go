@@ -0,0 +1,501 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alwitt/goutils"
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+)
+
+// AccountEvent describes an account lifecycle event
+type AccountEvent struct {
+	// Event is the event type: "autoAdded", "deleted", "suspended", or "sensitiveRoleGranted"
+	Event string `json:"event"`
+	// UserID is the ID of the user
+	UserID string `json:"user_id"`
+	// Username is the username of the user, if known
+	Username string `json:"username,omitempty"`
+	// Roles are the sensitive roles granted, for the "sensitiveRoleGranted" event
+	Roles []string `json:"roles,omitempty"`
+	// Timestamp is when the event occurred
+	Timestamp time.Time `json:"timestamp"`
+}
+
+/*
+Summary describe the event in one line, for human readers
+
+	@return the description
+*/
+func (e AccountEvent) Summary() string {
+	user := fmt.Sprintf("user ID %s", e.UserID)
+	if e.Username != "" {
+		user = fmt.Sprintf("user %s (ID %s)", e.Username, e.UserID)
+	}
+	switch e.Event {
+	case common.AccountEventAutoAdded:
+		return fmt.Sprintf("Padlock: %s was automatically added", user)
+	case common.AccountEventDeleted:
+		return fmt.Sprintf("Padlock: %s was deleted", user)
+	case common.AccountEventSuspended:
+		return fmt.Sprintf("Padlock: %s was suspended", user)
+	case common.AccountEventSensitiveRoleGranted:
+		return fmt.Sprintf(
+			"Padlock: %s was granted sensitive roles %s", user, strings.Join(e.Roles, ", "),
+		)
+	default:
+		return fmt.Sprintf("Padlock: %s event %s", user, e.Event)
+	}
+}
+
+// AccountNotifier sends notifications on account lifecycle events
+type AccountNotifier interface {
+	/*
+		Notify send the notifications for an event, if the event type is of interest. The
+		notifications are sent in the background, so they do not delay the caller.
+
+			@param ctxt context.Context - the operating context
+			@param event AccountEvent - the event
+	*/
+	Notify(ctxt context.Context, event AccountEvent)
+
+	/*
+		Wait block until the notifications sent in the background are delivered, or failed. Used
+		by the commands which exit once their work is done.
+	*/
+	Wait()
+}
+
+// accountEventSink is one destination of the account lifecycle notifications
+type accountEventSink struct {
+	name string
+	send func(event AccountEvent) error
+}
+
+// accountNotifierImpl implements AccountNotifier
+type accountNotifierImpl struct {
+	goutils.Component
+	events map[string]bool
+	sinks  []accountEventSink
+	// inFlight tracks the notifications being sent in the background
+	inFlight sync.WaitGroup
+}
+
+/*
+DefineAccountNotifier define a new account lifecycle notifier
+
+	@param cfg common.AccountNotificationConfig - the notification config
+	@param smtpPassword string - the SMTP password, if the email notifications authenticate
+	@return new AccountNotifier instance
+*/
+func DefineAccountNotifier(
+	cfg common.AccountNotificationConfig, smtpPassword string,
+) AccountNotifier {
+	logTags := log.Fields{"module": "users", "component": "account-notifier"}
+	client := &http.Client{Timeout: time.Second * time.Duration(cfg.TimeoutSecs)}
+	instance := &accountNotifierImpl{
+		Component: goutils.Component{
+			LogTags: logTags,
+			LogTagModifiers: []goutils.LogMetadataModifier{
+				goutils.ModifyLogMetadataByRestRequestParam,
+			},
+		},
+		events: map[string]bool{},
+		sinks:  []accountEventSink{},
+	}
+	for _, event := range cfg.Events {
+		instance.events[event] = true
+	}
+	if cfg.Webhook.Enabled {
+		instance.sinks = append(instance.sinks, accountEventSink{
+			name: "webhook",
+			send: func(event AccountEvent) error {
+				return postJSON(client, cfg.Webhook.URL, event)
+			},
+		})
+	}
+	if cfg.Slack.Enabled {
+		instance.sinks = append(instance.sinks, accountEventSink{
+			name: "slack",
+			send: func(event AccountEvent) error {
+				return postJSON(client, cfg.Slack.URL, map[string]string{"text": event.Summary()})
+			},
+		})
+	}
+	if cfg.Email.Enabled {
+		instance.sinks = append(instance.sinks, accountEventSink{
+			name: "email",
+			send: func(event AccountEvent) error {
+				return sendEmail(cfg.Email, smtpPassword, event)
+			},
+		})
+	}
+	return instance
+}
+
+/*
+Notify send the notifications for an event, if the event type is of interest. The
+notifications are sent in the background, so they do not delay the caller.
+
+	@param ctxt context.Context - the operating context
+	@param event AccountEvent - the event
+*/
+func (n *accountNotifierImpl) Notify(ctxt context.Context, event AccountEvent) {
+	if !n.events[event.Event] {
+		return
+	}
+	logTags := n.GetLogTagsForContext(ctxt)
+	for _, sink := range n.sinks {
+		n.inFlight.Add(1)
+		go func(sink accountEventSink) {
+			defer n.inFlight.Done()
+			if err := sink.send(event); err != nil {
+				log.WithError(err).WithFields(logTags).Errorf(
+					"Failed to send %s notification of %s", sink.name, event.Summary(),
+				)
+			}
+		}(sink)
+	}
+}
+
+/*
+Wait block until the notifications sent in the background are delivered, or failed
+*/
+func (n *accountNotifierImpl) Wait() {
+	n.inFlight.Wait()
+}
+
+/*
+postJSON POST a JSON payload to a webhook
+
+	@param client *http.Client - the HTTP client
+	@param url string - the webhook URL
+	@param payload interface{} - the payload
+	@return whether successful
+*/
+func postJSON(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+/*
+sendEmail send the event by email
+
+	@param cfg common.AccountEmailConfig - the email config
+	@param password string - the SMTP password
+	@param event AccountEvent - the event
+	@return whether successful
+*/
+func sendEmail(cfg common.AccountEmailConfig, password string, event AccountEvent) error {
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host := strings.Split(cfg.SMTPServer, ":")[0]
+		auth = smtp.PlainAuth("", cfg.Username, password, host)
+	}
+	msg, err := formatEmail(cfg, event)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(cfg.SMTPServer, auth, cfg.From, cfg.To, []byte(msg))
+}
+
+/*
+formatEmail form the email message of an event
+
+	@param cfg common.AccountEmailConfig - the email config
+	@param event AccountEvent - the event
+	@return the message, headers included
+*/
+func formatEmail(cfg common.AccountEmailConfig, event AccountEvent) (string, error) {
+	details, err := json.MarshalIndent(&event, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	// The summary includes the user ID and username, which come from the IdP claims or the API.
+	// Encoding the subject keeps any line breaks in them from starting new mail headers.
+	msg := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		cfg.From,
+		strings.Join(cfg.To, ", "),
+		mime.QEncoding.Encode("utf-8", event.Summary()),
+		details,
+	)
+	return msg, nil
+}
+
+// ====================================================================================
+
+// notifyingManagement decorates a Management with the account lifecycle notifications
+type notifyingManagement struct {
+	Management
+	notifier  AccountNotifier
+	sensitive map[string]bool
+}
+
+/*
+WithAccountNotifications decorate a Management so it sends notifications when a user is
+deleted, or is granted a sensitive role
+
+	@param core Management - the user management instance to decorate
+	@param notifier AccountNotifier - the account lifecycle notifier
+	@param sensitiveRoles []string - the roles which trigger a notification when granted
+	@return the decorated Management
+*/
+func WithAccountNotifications(
+	core Management, notifier AccountNotifier, sensitiveRoles []string,
+) Management {
+	sensitive := map[string]bool{}
+	for _, role := range sensitiveRoles {
+		sensitive[role] = true
+	}
+	return &notifyingManagement{Management: core, notifier: notifier, sensitive: sensitive}
+}
+
+/*
+notifySensitiveRoles notify if the newly assigned roles include sensitive roles the user did
+not have before
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param username string - the username, if known
+	@param previous []string - roles of the user before the change
+	@param assigned []string - roles assigned by the change
+*/
+func (m *notifyingManagement) notifySensitiveRoles(
+	ctxt context.Context, id, username string, previous, assigned []string,
+) {
+	// A role inheriting from a sensitive role grants the same access. Without the roles on
+	// record, only the roles assigned directly are checked.
+	roles, _ := m.ListAllRoles(ctxt)
+	had := map[string]bool{}
+	for _, role := range m.sensitiveRolesOf(roles, previous) {
+		had[role] = true
+	}
+	granted := []string{}
+	for _, role := range m.sensitiveRolesOf(roles, assigned) {
+		if !had[role] {
+			granted = append(granted, role)
+		}
+	}
+	if len(granted) == 0 {
+		return
+	}
+	m.notifier.Notify(ctxt, AccountEvent{
+		Event:     common.AccountEventSensitiveRoleGranted,
+		UserID:    id,
+		Username:  username,
+		Roles:     granted,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+/*
+sensitiveRolesOf list the sensitive roles among some roles, and the roles they inherit from
+
+	@param roles map[string]common.UserRoleConfig - the known roles
+	@param held []string - the roles
+	@return the sensitive roles, in the order they are found
+*/
+func (m *notifyingManagement) sensitiveRolesOf(
+	roles map[string]common.UserRoleConfig, held []string,
+) []string {
+	found := []string{}
+	visited := map[string]bool{}
+	var visit func(role string)
+	visit = func(role string) {
+		if visited[role] {
+			return
+		}
+		visited[role] = true
+		if m.sensitive[role] {
+			found = append(found, role)
+		}
+		for _, parent := range roles[role].Inherits {
+			visit(parent)
+		}
+	}
+	for _, role := range held {
+		visit(role)
+	}
+	return found
+}
+
+/*
+currentUser fetch the username and roles of a user before a change. The roles include those
+held through the user's groups.
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@return the username, and the roles
+*/
+func (m *notifyingManagement) currentUser(ctxt context.Context, id string) (string, []string) {
+	user, err := m.GetUser(ctxt, id)
+	if err != nil {
+		return "", nil
+	}
+	username := ""
+	if user.Username != nil {
+		username = *user.Username
+	}
+	return username, userRoles(user.UserDetails)
+}
+
+/*
+DefineUser define a user entry with roles
+
+	@param ctxt context.Context - context calling this API
+	@param config UserConfig - user config
+	@param roles []string - roles for this user
+	@return whether successful
+*/
+func (m *notifyingManagement) DefineUser(
+	ctxt context.Context, config models.UserConfig, roles []string,
+) error {
+	if err := m.Management.DefineUser(ctxt, config, roles); err != nil {
+		return err
+	}
+	username := ""
+	if config.Username != nil {
+		username = *config.Username
+	}
+	m.notifySensitiveRoles(ctxt, config.UserID, username, nil, roles)
+	return nil
+}
+
+/*
+DeleteUser deletes a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@return whether successful
+*/
+func (m *notifyingManagement) DeleteUser(ctxt context.Context, id string) error {
+	username, _ := m.currentUser(ctxt, id)
+	if err := m.Management.DeleteUser(ctxt, id); err != nil {
+		return err
+	}
+	m.notifier.Notify(ctxt, AccountEvent{
+		Event:     common.AccountEventDeleted,
+		UserID:    id,
+		Username:  username,
+		Timestamp: time.Now().UTC(),
+	})
+	return nil
+}
+
+/*
+AddRolesToUser add new roles to a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param newRoles []string - new roles for this user
+	@return whether successful
+*/
+func (m *notifyingManagement) AddRolesToUser(
+	ctxt context.Context, id string, newRoles []string,
+) error {
+	username, previous := m.currentUser(ctxt, id)
+	if err := m.Management.AddRolesToUser(ctxt, id, newRoles); err != nil {
+		return err
+	}
+	m.notifySensitiveRoles(ctxt, id, username, previous, newRoles)
+	return nil
+}
+
+/*
+SetUserRoles change the roles of a user
+
+	@param ctxt context.Context - context calling this API
+	@param id string - user entry ID
+	@param newRoles []string - new roles for this user
+	@return whether successful
+*/
+func (m *notifyingManagement) SetUserRoles(
+	ctxt context.Context, id string, newRoles []string,
+) error {
+	username, previous := m.currentUser(ctxt, id)
+	if err := m.Management.SetUserRoles(ctxt, id, newRoles); err != nil {
+		return err
+	}
+	m.notifySensitiveRoles(ctxt, id, username, previous, newRoles)
+	return nil
+}
+
+/*
+SetGroupRoles change the roles of a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param newRoles []string - new roles for the members of this group
+	@return whether successful
+*/
+func (m *notifyingManagement) SetGroupRoles(
+	ctxt context.Context, name string, newRoles []string,
+) error {
+	group, err := m.GetGroup(ctxt, name)
+	if err != nil {
+		return err
+	}
+	usernames := map[string]string{}
+	previous := map[string][]string{}
+	for _, id := range group.Users {
+		usernames[id], previous[id] = m.currentUser(ctxt, id)
+	}
+	if err := m.Management.SetGroupRoles(ctxt, name, newRoles); err != nil {
+		return err
+	}
+	for _, id := range group.Users {
+		m.notifySensitiveRoles(ctxt, id, usernames[id], previous[id], newRoles)
+	}
+	return nil
+}
+
+/*
+AddUsersToGroup add users to a group
+
+	@param ctxt context.Context - context calling this API
+	@param name string - group name
+	@param ids []string - user entry IDs
+	@return whether successful
+*/
+func (m *notifyingManagement) AddUsersToGroup(
+	ctxt context.Context, name string, ids []string,
+) error {
+	group, err := m.GetGroup(ctxt, name)
+	if err != nil {
+		return err
+	}
+	usernames := map[string]string{}
+	previous := map[string][]string{}
+	for _, id := range ids {
+		usernames[id], previous[id] = m.currentUser(ctxt, id)
+	}
+	if err := m.Management.AddUsersToGroup(ctxt, name, ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		m.notifySensitiveRoles(ctxt, id, usernames[id], previous[id], group.Roles)
+	}
+	return nil
+}
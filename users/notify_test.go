@@ -0,0 +1,224 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestAccountNotifications(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	core, err := CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(core.AlignRolesWithConfig(context.Background(), map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+		"admin":  {AssignedPermissions: []string{"read", "write"}},
+		"ops":    {AssignedPermissions: []string{"read"}, Inherits: []string{"admin"}},
+	}))
+
+	webhookEvents := make(chan AccountEvent, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event AccountEvent
+		assert.Nil(json.NewDecoder(r.Body).Decode(&event))
+		webhookEvents <- event
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+	slackMessages := make(chan string, 10)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message map[string]string
+		assert.Nil(json.NewDecoder(r.Body).Decode(&message))
+		slackMessages <- message["text"]
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer slack.Close()
+
+	notifier := DefineAccountNotifier(common.AccountNotificationConfig{
+		Enabled: true,
+		Events: []string{
+			common.AccountEventAutoAdded,
+			common.AccountEventDeleted,
+			common.AccountEventSensitiveRoleGranted,
+		},
+		TimeoutSecs: 5,
+		Webhook:     common.AccountWebhookConfig{Enabled: true, URL: webhook.URL},
+		Slack:       common.AccountWebhookConfig{Enabled: true, URL: slack.URL},
+	}, "")
+	uut := WithAccountNotifications(core, notifier, []string{"admin"})
+
+	expectEvent := func(event string, userID string) AccountEvent {
+		select {
+		case received := <-webhookEvents:
+			assert.Equal(event, received.Event)
+			assert.Equal(userID, received.UserID)
+			select {
+			case message := <-slackMessages:
+				assert.Equal(received.Summary(), message)
+			case <-time.After(time.Second * 5):
+				assert.Fail("slack message not received")
+			}
+			return received
+		case <-time.After(time.Second * 5):
+			assert.Fail("webhook event not received")
+			return AccountEvent{}
+		}
+	}
+	expectNoEvent := func() {
+		select {
+		case received := <-webhookEvents:
+			assert.Fail("unexpected event", received.Summary())
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	utCtxt := context.Background()
+	username := "alice"
+	user0 := uuid.NewString()
+	user1 := uuid.NewString()
+
+	// Case 0: defining a user without sensitive roles
+	assert.Nil(uut.DefineUser(
+		utCtxt, models.UserConfig{UserID: user0, Username: &username}, []string{"reader"},
+	))
+	expectNoEvent()
+
+	// Case 1: granting a sensitive role
+	{
+		assert.Nil(uut.AddRolesToUser(utCtxt, user0, []string{"admin"}))
+		event := expectEvent(common.AccountEventSensitiveRoleGranted, user0)
+		assert.Equal([]string{"admin"}, event.Roles)
+		assert.Equal(username, event.Username)
+		assert.Contains(event.Summary(), "alice")
+	}
+
+	// Case 2: keeping a sensitive role is not a new grant
+	assert.Nil(uut.SetUserRoles(utCtxt, user0, []string{"admin"}))
+	expectNoEvent()
+
+	// Case 3: defining a user with a sensitive role
+	assert.Nil(uut.DefineUser(utCtxt, models.UserConfig{UserID: user1}, []string{"admin"}))
+	expectEvent(common.AccountEventSensitiveRoleGranted, user1)
+
+	// Case 4: deleting a user
+	{
+		assert.Nil(uut.DeleteUser(utCtxt, user0))
+		event := expectEvent(common.AccountEventDeleted, user0)
+		assert.Equal(username, event.Username)
+	}
+
+	// Case 5: failed changes are not notified
+	assert.NotNil(uut.AddRolesToUser(utCtxt, user1, []string{"unknown"}))
+	assert.NotNil(uut.DeleteUser(utCtxt, user0))
+	expectNoEvent()
+
+	// Case 6: granting a role which inherits from a sensitive role
+	{
+		user2 := uuid.NewString()
+		assert.Nil(uut.DefineUser(utCtxt, models.UserConfig{UserID: user2}, []string{"reader"}))
+		expectNoEvent()
+		assert.Nil(uut.AddRolesToUser(utCtxt, user2, []string{"ops"}))
+		event := expectEvent(common.AccountEventSensitiveRoleGranted, user2)
+		assert.Equal([]string{"admin"}, event.Roles)
+
+		// Already holding the sensitive role through inheritance is not a new grant
+		assert.Nil(uut.SetUserRoles(utCtxt, user2, []string{"admin"}))
+		expectNoEvent()
+	}
+
+	// Case 7: events not of interest are not notified
+	{
+		filtered := DefineAccountNotifier(common.AccountNotificationConfig{
+			Enabled:     true,
+			Events:      []string{common.AccountEventAutoAdded},
+			TimeoutSecs: 5,
+			Webhook:     common.AccountWebhookConfig{Enabled: true, URL: webhook.URL},
+		}, "")
+		filtered.Notify(utCtxt, AccountEvent{Event: common.AccountEventDeleted, UserID: user1})
+		expectNoEvent()
+		filtered.Notify(utCtxt, AccountEvent{Event: common.AccountEventAutoAdded, UserID: user1})
+		select {
+		case received := <-webhookEvents:
+			assert.Equal(common.AccountEventAutoAdded, received.Event)
+		case <-time.After(time.Second * 5):
+			assert.Fail("webhook event not received")
+		}
+	}
+}
+
+func TestAccountNotificationEmail(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	cfg := common.AccountEmailConfig{
+		From: "padlock@testing.org", To: []string{"security@testing.org", "ops@testing.org"},
+	}
+
+	// Case 0: plain event
+	{
+		msg, err := formatEmail(cfg, AccountEvent{
+			Event: common.AccountEventSuspended, UserID: "alice", Username: "Alice",
+		})
+		assert.Nil(err)
+		headers, body, found := strings.Cut(msg, "\r\n\r\n")
+		assert.True(found)
+		assert.Equal(
+			[]string{
+				"From: padlock@testing.org",
+				"To: security@testing.org, ops@testing.org",
+				"Subject: Padlock: user Alice (ID alice) was suspended",
+				"Content-Type: text/plain; charset=utf-8",
+			},
+			strings.Split(headers, "\r\n"),
+		)
+		assert.Contains(body, `"event": "suspended"`)
+	}
+
+	// Case 1: line breaks in the username do not start new headers
+	{
+		msg, err := formatEmail(cfg, AccountEvent{
+			Event:    common.AccountEventDeleted,
+			UserID:   "mallory",
+			Username: "Mallory\r\nBcc: attacker@testing.org",
+		})
+		assert.Nil(err)
+		headers, _, found := strings.Cut(msg, "\r\n\r\n")
+		assert.True(found)
+		headerLines := strings.Split(headers, "\r\n")
+		assert.Len(headerLines, 4)
+		for _, line := range headerLines {
+			assert.NotContains(line, "\n")
+			assert.False(strings.HasPrefix(line, "Bcc:"))
+		}
+		assert.True(strings.HasPrefix(headerLines[2], "Subject: =?utf-8?q?"))
+	}
+}
@@ -0,0 +1,145 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/apex/log"
+	"github.com/spf13/viper"
+)
+
+// RoleSource is a remote source of the role configuration
+type RoleSource interface {
+	/*
+		Fetch read the role configuration from the source
+
+			@param ctxt context.Context - the operating context
+			@return the roles
+	*/
+	Fetch(ctxt context.Context) (map[string]common.UserRoleConfig, error)
+}
+
+// httpRoleSource implements RoleSource with a document served over HTTP(S)
+type httpRoleSource struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+/*
+DefineHTTPRoleSource define a role source reading a YAML or JSON document served over HTTP(S).
+The document lists the roles under "userRoles", in the same form as the application config.
+
+	@param cfg common.RemoteRolesConfig - the remote role source config
+	@param client *http.Client - the HTTP client to fetch the document with
+	@return new RoleSource instance
+*/
+func DefineHTTPRoleSource(cfg common.RemoteRolesConfig, client *http.Client) RoleSource {
+	return &httpRoleSource{url: cfg.URL, headers: cfg.Headers, client: client}
+}
+
+/*
+Fetch read the role configuration from the source
+
+	@param ctxt context.Context - the operating context
+	@return the roles
+*/
+func (s *httpRoleSource) Fetch(ctxt context.Context) (map[string]common.UserRoleConfig, error) {
+	req, err := http.NewRequestWithContext(ctxt, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for header, value := range s.headers {
+		req.Header.Set(header, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("role source %s responded with %d", s.url, resp.StatusCode)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRoleDocument(content)
+}
+
+/*
+ParseRoleDocument parse a YAML or JSON document listing roles under "userRoles"
+
+	@param content []byte - the document
+	@return the roles
+*/
+func ParseRoleDocument(content []byte) (map[string]common.UserRoleConfig, error) {
+	// A dedicated instance, so the application config is not touched. YAML is a superset of
+	// JSON, so both are parsed the same way.
+	parser := viper.New()
+	parser.SetConfigType("yaml")
+	if err := parser.ReadConfig(bytes.NewBuffer(content)); err != nil {
+		return nil, fmt.Errorf("unable to parse role document: %w", err)
+	}
+	var document common.UserRolesConfig
+	if err := parser.Unmarshal(&document); err != nil {
+		return nil, fmt.Errorf("unable to parse role document: %w", err)
+	}
+	if len(document.AvailableRoles) == 0 {
+		return nil, fmt.Errorf("role document lists no roles")
+	}
+	return document.AvailableRoles, nil
+}
+
+/*
+SyncRolesFromSource fetch the role configuration from a remote source, and apply it if it
+passes validation and differs from the roles in use
+
+	@param ctxt context.Context - the operating context
+	@param manager Management - the user management instance to apply the roles to
+	@param source RoleSource - the remote role source
+	@param validate func(map[string]common.UserRoleConfig) error - validates the fetched roles
+	against the rest of the application config
+	@return whether the roles changed
+*/
+func SyncRolesFromSource(
+	ctxt context.Context,
+	manager Management,
+	source RoleSource,
+	validate func(map[string]common.UserRoleConfig) error,
+) (bool, error) {
+	logTags := log.Fields{"module": "users", "component": "role-sync"}
+	roles, err := source.Fetch(ctxt)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Failed to fetch remote roles")
+		return false, err
+	}
+	if err := validate(roles); err != nil {
+		log.WithError(err).WithFields(logTags).Error("Remote roles failed validation")
+		return false, err
+	}
+	// The roles in use have their inheritance resolved, so compare them with the fetched
+	// roles resolved the same way
+	resolved, err := common.ResolveRoleInheritance(roles)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Error("Unable to resolve the remote role inheritance")
+		return false, err
+	}
+	current, err := manager.ListAllRoles(ctxt)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(current, resolved) {
+		return false, nil
+	}
+	if err := manager.AlignRolesWithConfig(ctxt, roles); err != nil {
+		return false, err
+	}
+	log.WithFields(logTags).Infof("Applied %d remote roles", len(roles))
+	return true, nil
+}
@@ -0,0 +1,168 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSyncRolesFromSource(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	assert.Nil(dbClient.Ready())
+
+	uut, err := CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+	assert.Nil(uut.Ready())
+
+	utCtxt := context.Background()
+	assert.Nil(uut.AlignRolesWithConfig(utCtxt, map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+	}))
+
+	// The document served by the remote source
+	document := ""
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer unit-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(document))
+	}))
+	defer server.Close()
+
+	source := DefineHTTPRoleSource(
+		common.RemoteRolesConfig{
+			URL: server.URL, Headers: map[string]string{"Authorization": "Bearer unit-test"},
+		},
+		&http.Client{Timeout: time.Second * 5},
+	)
+	// Reject roles which do not grant "read"
+	validate := func(roles map[string]common.UserRoleConfig) error {
+		for _, role := range roles {
+			for _, permission := range role.AssignedPermissions {
+				if permission == "read" {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("permission read is not defined")
+	}
+
+	// Case 0: YAML document with new roles
+	{
+		document = `userRoles:
+  reader:
+    permissions:
+      - read
+  writer:
+    permissions:
+      - read
+      - write
+`
+		changed, err := SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.Nil(err)
+		assert.True(changed)
+		roles, err := uut.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.Len(roles, 2)
+		assert.EqualValues([]string{"read", "write"}, roles["writer"].AssignedPermissions)
+	}
+
+	// Case 1: same roles as JSON
+	{
+		document = `{"userRoles": {"reader": {"permissions": ["read"]}, "writer": {"permissions": ["read", "write"]}}}`
+		changed, err := SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.Nil(err)
+		assert.False(changed)
+	}
+
+	// Case 2: roles failing validation are not applied
+	{
+		document = `{"userRoles": {"writer": {"permissions": ["write"]}}}`
+		_, err := SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.NotNil(err)
+		roles, err := uut.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.Len(roles, 2)
+	}
+
+	// Case 3: malformed or empty documents, and unavailable source
+	{
+		document = `userRoles: [`
+		_, err := SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.NotNil(err)
+		document = `other: {}`
+		_, err = SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.NotNil(err)
+		document = `{"userRoles": {"reader": {"permissions": ["read"]}}}`
+		status = http.StatusNotFound
+		_, err = SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.NotNil(err)
+		roles, err := uut.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.Len(roles, 2)
+	}
+
+	// Case 4: roles removed at the source
+	{
+		status = http.StatusOK
+		changed, err := SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.Nil(err)
+		assert.True(changed)
+		roles, err := uut.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.Len(roles, 1)
+	}
+
+	// Case 5: roles inheriting from other roles
+	{
+		document = `userRoles:
+  reader:
+    permissions:
+      - read
+  editor:
+    permissions:
+      - write
+    inherits:
+      - reader
+`
+		changed, err := SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.Nil(err)
+		assert.True(changed)
+		roles, err := uut.ListAllRoles(utCtxt)
+		assert.Nil(err)
+		assert.EqualValues([]string{"write", "read"}, roles["editor"].AssignedPermissions)
+		// The same document again is not a change
+		changed, err = SyncRolesFromSource(utCtxt, uut, source, validate)
+		assert.Nil(err)
+		assert.False(changed)
+	}
+}
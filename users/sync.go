@@ -0,0 +1,384 @@
+package users
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+)
+
+// User fields a sync source can manage
+const (
+	SyncFieldUsername  = "username"
+	SyncFieldEmail     = "email"
+	SyncFieldFirstName = "first_name"
+	SyncFieldLastName  = "last_name"
+	SyncFieldRoles     = "roles"
+	// SyncFieldPermissions is the directly granted permissions. A sync source can't set them,
+	// but disabling a user clears them.
+	SyncFieldPermissions = "permissions"
+	// SyncFieldGroups is the group memberships. A sync source can't set them, but disabling a
+	// user removes them.
+	SyncFieldGroups = "groups"
+)
+
+// Actions of a user sync
+const (
+	SyncActionCreate  = "create"
+	SyncActionUpdate  = "update"
+	SyncActionDisable = "disable"
+	SyncActionDelete  = "delete"
+)
+
+// Handling of the users on record which are not in the sync source
+const (
+	// SyncAbsentKeep leaves the users as is
+	SyncAbsentKeep = "keep"
+	// SyncAbsentDisable removes all roles, directly granted permissions, and group memberships
+	// of the users, so they have no permissions
+	SyncAbsentDisable = "disable"
+	// SyncAbsentDelete deletes the users
+	SyncAbsentDelete = "delete"
+)
+
+// SyncSource is an external list of users, i.e. an HR or IdP export, to reconcile the user
+// records against
+type SyncSource struct {
+	// Fields are the user fields the source manages. The fields not managed are left as is.
+	Fields []string
+	// Users are the users of the source
+	Users []SnapshotUser
+}
+
+// SyncFieldChange is a change of one user field
+type SyncFieldChange struct {
+	// Field is the user field
+	Field string `json:"field"`
+	// Old is the value on record
+	Old string `json:"old"`
+	// New is the value from the source
+	New string `json:"new"`
+}
+
+// SyncChange is one change a user sync makes
+type SyncChange struct {
+	// Action is the change action
+	Action string `json:"action"`
+	// UserID is the user changed
+	UserID string `json:"user_id"`
+	// Fields are the changed user fields
+	Fields []SyncFieldChange `json:"fields,omitempty"`
+	// user is the user after the change
+	user SnapshotUser
+	// leaveGroups are the groups the user is removed from
+	leaveGroups []string
+}
+
+// SyncPlan is the changes which reconcile the user records with a sync source
+type SyncPlan struct {
+	// Changes are the changes to make, ordered by user ID
+	Changes []SyncChange `json:"changes"`
+	// Unchanged is the number of users which need no change
+	Unchanged int `json:"unchanged"`
+}
+
+/*
+ReadSyncSourceCSV read a sync source from CSV. The header row names the columns: "user_id"
+is required, and "username", "email", "first_name", "last_name", and "roles" are optional.
+Roles are space separated. Other columns are ignored.
+
+	@param r io.Reader - the CSV input
+	@return the sync source
+*/
+func ReadSyncSourceCSV(r io.Reader) (SyncSource, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return SyncSource{}, fmt.Errorf("unable to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for idx, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	userIDColumn, ok := columns["user_id"]
+	if !ok {
+		return SyncSource{}, fmt.Errorf("CSV header has no user_id column")
+	}
+
+	source := SyncSource{Fields: []string{}, Users: []SnapshotUser{}}
+	for _, field := range []string{
+		SyncFieldUsername, SyncFieldEmail, SyncFieldFirstName, SyncFieldLastName, SyncFieldRoles,
+	} {
+		if _, ok := columns[field]; ok {
+			source.Fields = append(source.Fields, field)
+		}
+	}
+	optional := func(row []string, field string) *string {
+		idx, ok := columns[field]
+		if !ok || strings.TrimSpace(row[idx]) == "" {
+			return nil
+		}
+		value := strings.TrimSpace(row[idx])
+		return &value
+	}
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return SyncSource{}, err
+		}
+		user := SnapshotUser{
+			UserConfig: models.UserConfig{
+				UserID:    strings.TrimSpace(row[userIDColumn]),
+				Username:  optional(row, SyncFieldUsername),
+				Email:     optional(row, SyncFieldEmail),
+				FirstName: optional(row, SyncFieldFirstName),
+				LastName:  optional(row, SyncFieldLastName),
+			},
+			Roles: []string{},
+		}
+		if user.UserID == "" {
+			line, _ := reader.FieldPos(userIDColumn)
+			return SyncSource{}, fmt.Errorf("CSV line %d has no user_id", line)
+		}
+		if idx, ok := columns[SyncFieldRoles]; ok {
+			user.Roles = strings.Fields(row[idx])
+		}
+		source.Users = append(source.Users, user)
+	}
+	return source, nil
+}
+
+/*
+PlanSync compute the changes which reconcile the user records with a sync source. Users in the
+source and not on record are created, and users on record whose managed fields differ from the
+source are updated.
+
+	@param current Snapshot - the current user records, as returned by ExportSnapshot
+	@param source SyncSource - the sync source
+	@param absent string - how to handle the users on record not in the source: "keep",
+	"disable", or "delete"
+	@return the sync plan
+*/
+func PlanSync(current Snapshot, source SyncSource, absent string) (SyncPlan, error) {
+	switch absent {
+	case SyncAbsentKeep, SyncAbsentDisable, SyncAbsentDelete:
+	default:
+		return SyncPlan{}, fmt.Errorf("unsupported absent user handling '%s'", absent)
+	}
+	managed := map[string]bool{}
+	for _, field := range source.Fields {
+		managed[field] = true
+	}
+	knownRoles := map[string]bool{}
+	for _, roleName := range current.Roles {
+		knownRoles[roleName] = true
+	}
+	onRecord := map[string]SnapshotUser{}
+	for _, oneUser := range current.Users {
+		onRecord[oneUser.UserID] = oneUser
+	}
+
+	plan := SyncPlan{Changes: []SyncChange{}}
+	inSource := map[string]bool{}
+	for _, oneUser := range source.Users {
+		if inSource[oneUser.UserID] {
+			return SyncPlan{}, fmt.Errorf("user %s is listed more than once", oneUser.UserID)
+		}
+		inSource[oneUser.UserID] = true
+		for _, roleName := range oneUser.Roles {
+			if !knownRoles[roleName] {
+				return SyncPlan{}, fmt.Errorf(
+					"role %s of user %s is not present in the role configuration",
+					roleName,
+					oneUser.UserID,
+				)
+			}
+		}
+
+		existing, ok := onRecord[oneUser.UserID]
+		if !ok {
+			plan.Changes = append(plan.Changes, SyncChange{
+				Action: SyncActionCreate,
+				UserID: oneUser.UserID,
+				Fields: diffSyncUser(SnapshotUser{}, oneUser, managed),
+				user:   oneUser,
+			})
+			continue
+		}
+
+		// Only the managed fields are taken from the source
+		updated := SnapshotUser{UserConfig: existing.UserConfig, Roles: existing.Roles}
+		if managed[SyncFieldUsername] {
+			updated.Username = oneUser.Username
+		}
+		if managed[SyncFieldEmail] {
+			updated.Email = oneUser.Email
+		}
+		if managed[SyncFieldFirstName] {
+			updated.FirstName = oneUser.FirstName
+		}
+		if managed[SyncFieldLastName] {
+			updated.LastName = oneUser.LastName
+		}
+		if managed[SyncFieldRoles] {
+			updated.Roles = oneUser.Roles
+		}
+		fields := diffSyncUser(existing, updated, managed)
+		if len(fields) == 0 {
+			plan.Unchanged++
+			continue
+		}
+		plan.Changes = append(plan.Changes, SyncChange{
+			Action: SyncActionUpdate, UserID: oneUser.UserID, Fields: fields, user: updated,
+		})
+	}
+
+	for _, oneUser := range current.Users {
+		if inSource[oneUser.UserID] {
+			continue
+		}
+		switch {
+		case absent == SyncAbsentDelete:
+			plan.Changes = append(plan.Changes, SyncChange{
+				Action: SyncActionDelete, UserID: oneUser.UserID, user: oneUser,
+			})
+		case absent == SyncAbsentDisable &&
+			(len(oneUser.Roles) > 0 || len(oneUser.Permissions) > 0 || len(oneUser.Groups) > 0):
+			disabled := SnapshotUser{UserConfig: oneUser.UserConfig, Roles: []string{}}
+			plan.Changes = append(plan.Changes, SyncChange{
+				Action: SyncActionDisable,
+				UserID: oneUser.UserID,
+				Fields: diffSyncUser(
+					oneUser,
+					disabled,
+					map[string]bool{
+						SyncFieldRoles: true, SyncFieldPermissions: true, SyncFieldGroups: true,
+					},
+				),
+				user:        disabled,
+				leaveGroups: oneUser.Groups,
+			})
+		default:
+			plan.Unchanged++
+		}
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		return plan.Changes[i].UserID < plan.Changes[j].UserID
+	})
+	return plan, nil
+}
+
+/*
+ApplySync make the changes of a sync plan
+
+	@param ctxt context.Context - context calling this API
+	@param manager Management - the user manager
+	@param notifier AccountNotifier - if provided, notified of the users suspended by disabling
+	them
+	@param plan SyncPlan - the sync plan
+	@return whether successful
+*/
+func ApplySync(
+	ctxt context.Context, manager Management, notifier AccountNotifier, plan SyncPlan,
+) error {
+	logTags := log.Fields{"module": "user", "component": "sync"}
+	for _, change := range plan.Changes {
+		var err error
+		switch change.Action {
+		case SyncActionCreate:
+			err = manager.DefineUser(ctxt, change.user.UserConfig, change.user.Roles)
+		case SyncActionUpdate:
+			if err = manager.UpdateUser(ctxt, change.UserID, change.user.UserConfig); err == nil {
+				err = manager.SetUserRoles(ctxt, change.UserID, change.user.Roles)
+			}
+		case SyncActionDisable:
+			if err = manager.SetUserRoles(ctxt, change.UserID, change.user.Roles); err == nil {
+				err = manager.SetUserPermissions(ctxt, change.UserID, nil)
+			}
+			for _, groupName := range change.leaveGroups {
+				if err != nil {
+					break
+				}
+				err = manager.RemoveUsersFromGroup(ctxt, groupName, []string{change.UserID})
+			}
+			if err == nil && notifier != nil {
+				username := ""
+				if change.user.Username != nil {
+					username = *change.user.Username
+				}
+				notifier.Notify(ctxt, AccountEvent{
+					Event:     common.AccountEventSuspended,
+					UserID:    change.UserID,
+					Username:  username,
+					Timestamp: time.Now().UTC(),
+				})
+			}
+		case SyncActionDelete:
+			err = manager.DeleteUser(ctxt, change.UserID)
+		default:
+			err = fmt.Errorf("unsupported sync action '%s'", change.Action)
+		}
+		if err != nil {
+			log.WithError(err).WithFields(logTags).
+				Errorf("Failed to %s user %s", change.Action, change.UserID)
+			return err
+		}
+		log.WithFields(logTags).Debugf("Sync %s user %s", change.Action, change.UserID)
+	}
+	return nil
+}
+
+/*
+diffSyncUser compare the managed fields of two versions of a user
+
+	@param before SnapshotUser - the user on record
+	@param after SnapshotUser - the user after the change
+	@param managed map[string]bool - the fields to compare
+	@return the changed fields
+*/
+func diffSyncUser(before, after SnapshotUser, managed map[string]bool) []SyncFieldChange {
+	value := func(v *string) string {
+		if v == nil {
+			return ""
+		}
+		return *v
+	}
+	roles := func(v []string) string {
+		sorted := append([]string{}, v...)
+		sort.Strings(sorted)
+		return strings.Join(sorted, " ")
+	}
+	fields := []SyncFieldChange{}
+	for _, field := range []SyncFieldChange{
+		{Field: SyncFieldUsername, Old: value(before.Username), New: value(after.Username)},
+		{Field: SyncFieldEmail, Old: value(before.Email), New: value(after.Email)},
+		{Field: SyncFieldFirstName, Old: value(before.FirstName), New: value(after.FirstName)},
+		{Field: SyncFieldLastName, Old: value(before.LastName), New: value(after.LastName)},
+		{Field: SyncFieldRoles, Old: roles(before.Roles), New: roles(after.Roles)},
+		{
+			Field: SyncFieldPermissions,
+			Old:   roles(before.Permissions),
+			New:   roles(after.Permissions),
+		},
+		{Field: SyncFieldGroups, Old: roles(before.Groups), New: roles(after.Groups)},
+	} {
+		if managed[field.Field] && field.Old != field.New {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,198 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/models"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordingNotifier records the account lifecycle events it is notified of
+type recordingNotifier struct {
+	events []AccountEvent
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, event AccountEvent) {
+	n.events = append(n.events, event)
+}
+
+func (n *recordingNotifier) Wait() {}
+
+func TestUserSync(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.DebugLevel)
+	utCtxt := context.Background()
+
+	supportMatch, err := common.GetCustomFieldValidator(
+		`^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^[a-zA-Z0-9-]+$`, `^.+$`,
+	)
+	assert.Nil(err)
+	dbName := fmt.Sprintf("/tmp/models_test_%s.db", uuid.New().String())
+	log.Debugf("Unit-test DB %s", dbName)
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
+	assert.Nil(err)
+	dbClient, err := models.CreateManagementDBClient(db, supportMatch)
+	assert.Nil(err)
+	uut, err := CreateManagement(dbClient, nil, nil)
+	assert.Nil(err)
+
+	assert.Nil(uut.AlignRolesWithConfig(utCtxt, map[string]common.UserRoleConfig{
+		"reader": {AssignedPermissions: []string{"read"}},
+		"writer": {AssignedPermissions: []string{"write"}},
+	}))
+	email := "bob@testing.org"
+	assert.Nil(uut.DefineUser(utCtxt, models.UserConfig{UserID: "alice"}, []string{"reader"}))
+	assert.Nil(uut.DefineUser(
+		utCtxt, models.UserConfig{UserID: "bob", Email: &email}, []string{"reader"},
+	))
+	assert.Nil(uut.DefineUser(utCtxt, models.UserConfig{UserID: "carol"}, []string{"writer"}))
+	assert.Nil(uut.DefineUser(utCtxt, models.UserConfig{UserID: "dave"}, nil))
+	assert.Nil(uut.DefineUser(utCtxt, models.UserConfig{UserID: "frank"}, nil))
+	assert.Nil(uut.SetUserPermissions(utCtxt, "frank", []string{"write"}))
+	assert.Nil(uut.DefineUser(utCtxt, models.UserConfig{UserID: "grace"}, nil))
+	assert.Nil(uut.DefineGroup(utCtxt, "editors", []string{"writer"}))
+	assert.Nil(uut.AddUsersToGroup(utCtxt, "editors", []string{"grace"}))
+
+	// Case 0: read the CSV source
+	source, err := ReadSyncSourceCSV(strings.NewReader(
+		"user_id,email,roles,department\n" +
+			"alice,,reader,hr\n" +
+			"bob,bob@new.testing.org,reader writer,it\n" +
+			"erin,erin@testing.org,writer,it\n",
+	))
+	assert.Nil(err)
+	assert.Equal([]string{SyncFieldEmail, SyncFieldRoles}, source.Fields)
+	assert.Len(source.Users, 3)
+	assert.Nil(source.Users[0].Email)
+	assert.Equal([]string{"reader", "writer"}, source.Users[1].Roles)
+
+	// Case 1: invalid sources
+	{
+		_, err := ReadSyncSourceCSV(strings.NewReader("email,roles\nx@testing.org,reader\n"))
+		assert.NotNil(err)
+		_, err = ReadSyncSourceCSV(strings.NewReader("user_id,roles\n,reader\n"))
+		assert.NotNil(err)
+		current, err := ExportSnapshot(utCtxt, uut)
+		assert.Nil(err)
+		_, err = PlanSync(current, SyncSource{
+			Fields: []string{SyncFieldRoles},
+			Users: []SnapshotUser{
+				{UserConfig: models.UserConfig{UserID: "x"}, Roles: []string{"ghost"}},
+			},
+		}, SyncAbsentKeep)
+		assert.NotNil(err)
+		_, err = PlanSync(current, source, "ignore")
+		assert.NotNil(err)
+	}
+
+	// Case 2: plan, keeping the absent users
+	{
+		current, err := ExportSnapshot(utCtxt, uut)
+		assert.Nil(err)
+		plan, err := PlanSync(current, source, SyncAbsentKeep)
+		assert.Nil(err)
+		assert.Equal(5, plan.Unchanged)
+		assert.Len(plan.Changes, 2)
+		assert.Equal(SyncActionUpdate, plan.Changes[0].Action)
+		assert.Equal("bob", plan.Changes[0].UserID)
+		assert.Equal(
+			[]SyncFieldChange{
+				{Field: SyncFieldEmail, Old: "bob@testing.org", New: "bob@new.testing.org"},
+				{Field: SyncFieldRoles, Old: "reader", New: "reader writer"},
+			},
+			plan.Changes[0].Fields,
+		)
+		assert.Equal(SyncActionCreate, plan.Changes[1].Action)
+		assert.Equal("erin", plan.Changes[1].UserID)
+	}
+
+	// Case 3: plan and apply, disabling the absent users
+	{
+		current, err := ExportSnapshot(utCtxt, uut)
+		assert.Nil(err)
+		plan, err := PlanSync(current, source, SyncAbsentDisable)
+		assert.Nil(err)
+		// dave has no roles or permissions, so is already disabled
+		assert.Equal(2, plan.Unchanged)
+		assert.Len(plan.Changes, 5)
+		assert.Equal(SyncActionDisable, plan.Changes[1].Action)
+		assert.Equal("carol", plan.Changes[1].UserID)
+		// frank has no roles, but has directly granted permissions
+		assert.Equal(SyncActionDisable, plan.Changes[3].Action)
+		assert.Equal("frank", plan.Changes[3].UserID)
+		assert.Equal(
+			[]SyncFieldChange{{Field: SyncFieldPermissions, Old: "write", New: ""}},
+			plan.Changes[3].Fields,
+		)
+		// grace has no roles, but holds roles through a group
+		assert.Equal(SyncActionDisable, plan.Changes[4].Action)
+		assert.Equal("grace", plan.Changes[4].UserID)
+		assert.Equal(
+			[]SyncFieldChange{{Field: SyncFieldGroups, Old: "editors", New: ""}},
+			plan.Changes[4].Fields,
+		)
+		notifier := &recordingNotifier{}
+		assert.Nil(ApplySync(utCtxt, uut, notifier, plan))
+
+		// The disabled users are reported as suspended
+		suspended := []string{}
+		for _, event := range notifier.events {
+			assert.Equal(common.AccountEventSuspended, event.Event)
+			suspended = append(suspended, event.UserID)
+		}
+		assert.Equal([]string{"carol", "frank", "grace"}, suspended)
+
+		bob, err := uut.GetUser(utCtxt, "bob")
+		assert.Nil(err)
+		assert.Equal("bob@new.testing.org", *bob.Email)
+		assert.ElementsMatch([]string{"reader", "writer"}, bob.Roles)
+		carol, err := uut.GetUser(utCtxt, "carol")
+		assert.Nil(err)
+		assert.Empty(carol.Roles)
+		frank, err := uut.GetUser(utCtxt, "frank")
+		assert.Nil(err)
+		assert.Empty(frank.Permissions)
+		grace, err := uut.GetUser(utCtxt, "grace")
+		assert.Nil(err)
+		assert.Empty(grace.Groups)
+		assert.Empty(grace.GroupRoles)
+		erin, err := uut.GetUser(utCtxt, "erin")
+		assert.Nil(err)
+		assert.Equal([]string{"writer"}, erin.Roles)
+
+		// A second sync has nothing to do
+		current, err = ExportSnapshot(utCtxt, uut)
+		assert.Nil(err)
+		plan, err = PlanSync(current, source, SyncAbsentDisable)
+		assert.Nil(err)
+		assert.Empty(plan.Changes)
+	}
+
+	// Case 4: plan and apply, deleting the absent users
+	{
+		current, err := ExportSnapshot(utCtxt, uut)
+		assert.Nil(err)
+		plan, err := PlanSync(current, source, SyncAbsentDelete)
+		assert.Nil(err)
+		assert.Len(plan.Changes, 4)
+		assert.Nil(ApplySync(utCtxt, uut, nil, plan))
+		allUsers, err := uut.ListAllUsers(utCtxt)
+		assert.Nil(err)
+		userIDs := []string{}
+		for _, oneUser := range allUsers {
+			userIDs = append(userIDs, oneUser.UserID)
+		}
+		assert.ElementsMatch([]string{"alice", "bob", "erin"}, userIDs)
+	}
+}
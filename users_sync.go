@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alwitt/padlock/common"
+	"github.com/alwitt/padlock/users"
+	"github.com/apex/log"
+	"github.com/go-playground/validator/v10"
+	"github.com/urfave/cli/v2"
+)
+
+type usersSyncArgs struct {
+	DryRun bool
+	Absent string
+}
+
+var usersSyncCmdArgs usersSyncArgs
+
+// usersCommand define the user record CLI commands
+func usersCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "users",
+		Usage: "Manage the user records",
+		Subcommands: []*cli.Command{
+			{
+				Name: "sync",
+				Usage: "Reconcile the user records against an external list of users, i.e. an HR " +
+					"or IdP export, in CSV or backup file format",
+				ArgsUsage: "<users file>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "Only report the changes, without making them",
+						Value:       false,
+						DefaultText: "false",
+						Destination: &usersSyncCmdArgs.DryRun,
+						Required:    false,
+					},
+					&cli.StringFlag{
+						Name: "absent",
+						Usage: fmt.Sprintf(
+							"Handling of users on record not in the file: [%s %s %s]. Disabling "+
+								"removes all roles, permissions, and groups of the user.",
+							users.SyncAbsentKeep,
+							users.SyncAbsentDisable,
+							users.SyncAbsentDelete,
+						),
+						Value:       users.SyncAbsentKeep,
+						DefaultText: users.SyncAbsentKeep,
+						Destination: &usersSyncCmdArgs.Absent,
+						Required:    false,
+					},
+				},
+				Action: commandAction("users sync", usersSyncApplication),
+			},
+		},
+	}
+}
+
+// usersSyncResult is the result of the users sync command
+type usersSyncResult struct {
+	// DryRun is whether the changes were only reported
+	DryRun bool `json:"dry_run"`
+	users.SyncPlan
+}
+
+func usersSyncApplication(c *cli.Context) (interface{}, error) {
+	if c.NArg() != 1 {
+		return nil, fmt.Errorf("expected exactly one users file")
+	}
+	appCfg, customValidator, validate, err := setupApplication()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := closeLogSinks(); err != nil {
+			log.WithError(err).Errorf("Failed to close log sinks")
+		}
+	}()
+	started := time.Now()
+	result, err := performUsersSync(c.Args().First(), appCfg, customValidator, validate)
+	pushBatchJobMetrics(appCfg.Metrics.Push, "sync", started, len(result.Changes), err)
+	if err != nil {
+		return nil, err
+	}
+	if !jsonOutput() {
+		printUsersSyncPlan(result)
+	}
+	return result, nil
+}
+
+func performUsersSync(
+	usersFile string,
+	appCfg common.AuthorizationServerConfig,
+	customValidator common.CustomFieldValidator,
+	validate *validator.Validate,
+) (usersSyncResult, error) {
+	result := usersSyncResult{
+		DryRun: usersSyncCmdArgs.DryRun, SyncPlan: users.SyncPlan{Changes: []users.SyncChange{}},
+	}
+	source, err := readUsersSyncSource(usersFile)
+	if err != nil {
+		return result, err
+	}
+
+	dbDSN, err := buildDatabaseDSN(validate)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to define DB connection DSN")
+		return result, err
+	}
+	userManager, err := defineUserManager(
+		dbDSN,
+		appCfg.Startup.DBConnect,
+		customValidator,
+		nil,
+		nil,
+		&appCfg.UserManagement.PermissionWildcards,
+	)
+	if err != nil {
+		return result, err
+	}
+	// Same as the server, notify on the users deleted, suspended, or granted sensitive roles
+	var accountNotifier users.AccountNotifier
+	if appCfg.AccountNotifications.Enabled {
+		accountNotifier = users.DefineAccountNotifier(
+			appCfg.AccountNotifications, cmdArgs.SMTPPassword,
+		)
+		userManager = users.WithAccountNotifications(
+			userManager, accountNotifier, appCfg.AccountNotifications.SensitiveRoles,
+		)
+		// Deliver the notifications before the command exits
+		defer accountNotifier.Wait()
+	}
+	// The roles must be aligned with the configuration before users can refer to them
+	err = userManager.AlignRolesWithConfig(
+		context.Background(), appCfg.UserManagement.AvailableRoles,
+	)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to perform role config sync")
+		return result, err
+	}
+	current, err := users.ExportSnapshot(context.Background(), userManager)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to read user management records")
+		return result, err
+	}
+
+	plan, err := users.PlanSync(current, source, usersSyncCmdArgs.Absent)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to plan sync with %s", usersFile)
+		return result, err
+	}
+	result.SyncPlan = plan
+	if usersSyncCmdArgs.DryRun {
+		return result, nil
+	}
+	if err := users.ApplySync(
+		context.Background(), userManager, accountNotifier, plan,
+	); err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Failed to sync with %s", usersFile)
+		return result, err
+	}
+	log.WithFields(logTags).Infof(
+		"Synced with %s: %d changes, %d unchanged", usersFile, len(plan.Changes), plan.Unchanged,
+	)
+	return result, nil
+}
+
+/*
+readUsersSyncSource read the external list of users. A ".json" file is read as a backup file,
+where every user field is managed. Otherwise, the file is read as CSV.
+
+	@param usersFile string - the users file
+	@return the sync source
+*/
+func readUsersSyncSource(usersFile string) (users.SyncSource, error) {
+	if strings.EqualFold(filepath.Ext(usersFile), ".json") {
+		snapshot, err := readSnapshotFile(usersFile)
+		if err != nil {
+			return users.SyncSource{}, err
+		}
+		return users.SyncSource{
+			Fields: []string{
+				users.SyncFieldUsername,
+				users.SyncFieldEmail,
+				users.SyncFieldFirstName,
+				users.SyncFieldLastName,
+				users.SyncFieldRoles,
+			},
+			Users: snapshot.Users,
+		}, nil
+	}
+	input, err := os.Open(usersFile)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to open %s", usersFile)
+		return users.SyncSource{}, err
+	}
+	defer input.Close()
+	source, err := users.ReadSyncSourceCSV(input)
+	if err != nil {
+		log.WithError(err).WithFields(logTags).Errorf("Unable to parse %s", usersFile)
+		return users.SyncSource{}, err
+	}
+	return source, nil
+}
+
+// printUsersSyncPlan print the sync changes as a diff
+func printUsersSyncPlan(result usersSyncResult) {
+	symbols := map[string]string{
+		users.SyncActionCreate:  "+",
+		users.SyncActionUpdate:  "~",
+		users.SyncActionDisable: "-",
+		users.SyncActionDelete:  "-",
+	}
+	for _, change := range result.Changes {
+		fmt.Printf("%s %s %s\n", symbols[change.Action], change.Action, change.UserID)
+		for _, field := range change.Fields {
+			fmt.Printf("    %s: %q -> %q\n", field.Field, field.Old, field.New)
+		}
+	}
+	summary := fmt.Sprintf("%d changes, %d unchanged", len(result.Changes), result.Unchanged)
+	if result.DryRun {
+		summary += " (dry run, no changes made)"
+	}
+	fmt.Println(summary)
+}